@@ -21,9 +21,14 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
+	"os"
 	"runtime"
+	"strconv"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/ollama/ollama/envconfig"
 	"github.com/ollama/ollama/format"
@@ -35,6 +40,9 @@ import (
 type Client struct {
 	base *url.URL
 	http *http.Client
+
+	// apiKey is sent to servers that require API keys
+	apiKey string
 }
 
 func checkError(resp *http.Response, body []byte) error {
@@ -61,12 +69,60 @@ func checkError(resp *http.Response, body []byte) error {
 //	<scheme>://<host>:<port>
 //
 // If the variable is not specified, a default ollama host and port will be
-// used.
+// used. If it only lists Unix sockets, such as unix:///run/ollama.sock, the
+// client connects to the first. Requests are sent with the API key in
+// OLLAMA_CLIENT_KEY, if set, or else the first key in the key_file of the
+// address the client connects to.
 func ClientFromEnvironment() (*Client, error) {
-	return &Client{
-		base: envconfig.Host(),
-		http: http.DefaultClient,
-	}, nil
+	client := &Client{
+		base:   envconfig.Host(),
+		http:   http.DefaultClient,
+		apiKey: envconfig.ClientKey(),
+	}
+
+	if path := envconfig.HostKeyFile(); client.apiKey == "" && path != "" {
+		key, err := firstKey(path)
+		if err != nil {
+			return nil, err
+		}
+
+		client.apiKey = key
+	}
+
+	if socket := envconfig.Socket(); socket != "" {
+		client.base = &url.URL{Scheme: "http", Host: "localhost"}
+		client.http = &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		}}
+	}
+
+	return client, nil
+}
+
+// firstKey returns the first key in a listener's key file, skipping blank
+// lines and lines starting with #, as the server does
+func firstKey(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+			return line, nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+
+	return "", fmt.Errorf("no keys in %s", path)
 }
 
 func NewClient(base *url.URL, http *http.Client) *Client {
@@ -105,6 +161,9 @@ func (c *Client) do(ctx context.Context, method, path string, reqData, respData
 	request.Header.Set("Content-Type", "application/json")
 	request.Header.Set("Accept", "application/json")
 	request.Header.Set("User-Agent", fmt.Sprintf("ollama/%s (%s %s) Go/%s", version.Version, runtime.GOARCH, runtime.GOOS, runtime.Version()))
+	if c.apiKey != "" {
+		request.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
 
 	respObj, err := c.http.Do(request)
 	if err != nil {
@@ -132,25 +191,32 @@ func (c *Client) do(ctx context.Context, method, path string, reqData, respData
 const maxBufferSize = 512 * format.KiloByte
 
 func (c *Client) stream(ctx context.Context, method, path string, data any, fn func([]byte) error) error {
-	var buf *bytes.Buffer
+	var body io.Reader
 	if data != nil {
 		bts, err := json.Marshal(data)
 		if err != nil {
 			return err
 		}
 
-		buf = bytes.NewBuffer(bts)
+		body = bytes.NewBuffer(bts)
 	}
 
+	return c.streamBody(ctx, method, path, "application/json", body, fn)
+}
+
+func (c *Client) streamBody(ctx context.Context, method, path, contentType string, body io.Reader, fn func([]byte) error) error {
 	requestURL := c.base.JoinPath(path)
-	request, err := http.NewRequestWithContext(ctx, method, requestURL.String(), buf)
+	request, err := http.NewRequestWithContext(ctx, method, requestURL.String(), body)
 	if err != nil {
 		return err
 	}
 
-	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Content-Type", contentType)
 	request.Header.Set("Accept", "application/x-ndjson")
 	request.Header.Set("User-Agent", fmt.Sprintf("ollama/%s (%s %s) Go/%s", version.Version, runtime.GOARCH, runtime.GOOS, runtime.Version()))
+	if c.apiKey != "" {
+		request.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
 
 	response, err := c.http.Do(request)
 	if err != nil {
@@ -164,7 +230,8 @@ func (c *Client) stream(ctx context.Context, method, path string, data any, fn f
 	scanner.Buffer(scanBuf, maxBufferSize)
 	for scanner.Scan() {
 		var errorResponse struct {
-			Error string `json:"error,omitempty"`
+			Error string    `json:"error,omitempty"`
+			Code  ErrorCode `json:"code,omitempty"`
 		}
 
 		bts := scanner.Bytes()
@@ -172,6 +239,14 @@ func (c *Client) stream(ctx context.Context, method, path string, data any, fn f
 			return fmt.Errorf("unmarshal: %w", err)
 		}
 
+		if errorResponse.Code != "" {
+			return StatusError{
+				StatusCode:   response.StatusCode,
+				ErrorMessage: errorResponse.Error,
+				Code:         errorResponse.Code,
+			}
+		}
+
 		if errorResponse.Error != "" {
 			return errors.New(errorResponse.Error)
 		}
@@ -231,6 +306,125 @@ func (c *Client) Chat(ctx context.Context, req *ChatRequest, fn ChatResponseFunc
 	})
 }
 
+// EventFunc is a function that [Client.GenerateEvents] and
+// [Client.ChatEvents] invoke for every event received from the service. If
+// this function returns an error, the request is stopped and the error
+// returned.
+type EventFunc func(Event) error
+
+// GenerateEvents is like [Client.Generate], but streams the response as
+// events.
+func (c *Client) GenerateEvents(ctx context.Context, req *GenerateRequest, fn EventFunc) error {
+	r := *req
+	r.Events = true
+	return c.stream(ctx, http.MethodPost, "/api/generate", &r, eventFunc(fn))
+}
+
+// ChatEvents is like [Client.Chat], but streams the response as events.
+func (c *Client) ChatEvents(ctx context.Context, req *ChatRequest, fn EventFunc) error {
+	r := *req
+	r.Events = true
+	return c.stream(ctx, http.MethodPost, "/api/chat", &r, eventFunc(fn))
+}
+
+func eventFunc(fn EventFunc) func([]byte) error {
+	return func(bts []byte) error {
+		var event Event
+		if err := json.Unmarshal(bts, &event); err != nil {
+			return err
+		}
+
+		return fn(event)
+	}
+}
+
+// GenerateInput is like [Client.Generate], but appends the contents of input
+// to the prompt. input is sent as it's read, so very long prompts don't need
+// to be held in memory.
+func (c *Client) GenerateInput(ctx context.Context, req *GenerateRequest, input io.Reader, fn GenerateResponseFunc) error {
+	return c.streamBody(ctx, http.MethodPost, "/api/generate", "application/x-ndjson", inputBody(req, input), func(bts []byte) error {
+		var resp GenerateResponse
+		if err := json.Unmarshal(bts, &resp); err != nil {
+			return err
+		}
+
+		return fn(resp)
+	})
+}
+
+// ChatInput is like [Client.Chat], but appends the contents of input to the
+// content of the last message. input is sent as it's read, so very long
+// messages don't need to be held in memory.
+func (c *Client) ChatInput(ctx context.Context, req *ChatRequest, input io.Reader, fn ChatResponseFunc) error {
+	return c.streamBody(ctx, http.MethodPost, "/api/chat", "application/x-ndjson", inputBody(req, input), func(bts []byte) error {
+		var resp ChatResponse
+		if err := json.Unmarshal(bts, &resp); err != nil {
+			return err
+		}
+
+		return fn(resp)
+	})
+}
+
+// inputChunkSize is the size of the input read into each [InputChunk]
+const inputChunkSize = 64 << 10
+
+// inputBody returns a body of req followed by the contents of input as
+// input chunks
+func inputBody(req any, input io.Reader) io.Reader {
+	pr, pw := io.Pipe()
+	go func() {
+		enc := json.NewEncoder(pw)
+		if err := enc.Encode(req); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+
+		buf := make([]byte, inputChunkSize)
+		var n int
+		for {
+			m, err := io.ReadFull(input, buf[n:])
+			n += m
+
+			eof := errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+			if err != nil && !eof {
+				pw.CloseWithError(err)
+				return
+			}
+
+			// hold back a rune split between chunks so it isn't encoded as
+			// an invalid character
+			end := n
+			if !eof {
+				start := end - 1
+				for start > 0 && start > n-utf8.UTFMax && !utf8.RuneStart(buf[start]) {
+					start--
+				}
+
+				if !utf8.FullRune(buf[start:n]) {
+					end = start
+				}
+			}
+
+			if end > 0 {
+				if err := enc.Encode(InputChunk{Content: string(buf[:end])}); err != nil {
+					pw.CloseWithError(err)
+					return
+				}
+
+				n = copy(buf, buf[end:n])
+			}
+
+			if eof {
+				pw.Close()
+				return
+			}
+		}
+	}()
+
+	return pr
+}
+
 // PullProgressFunc is a function that [Client.Pull] invokes every time there
 // is progress with a "pull" request sent to the service. If this function
 // returns an error, [Client.Pull] will stop the process and return this error.
@@ -307,6 +501,89 @@ func (c *Client) ListRunning(ctx context.Context) (*ProcessResponse, error) {
 	return &lr, nil
 }
 
+// Plan simulates serving a set of models with an expected mix of requests on
+// the server's hardware.
+func (c *Client) Plan(ctx context.Context, req *PlanRequest) (*PlanResponse, error) {
+	var resp PlanResponse
+	if err := c.do(ctx, http.MethodPost, "/api/plan", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// PromptCache returns the statistics of the server's prompt token cache.
+func (c *Client) PromptCache(ctx context.Context) (*PromptCacheResponse, error) {
+	var resp PromptCacheResponse
+	if err := c.do(ctx, http.MethodGet, "/api/debug/prompt-cache", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Chaos returns the faults the server injects into models. It fails unless
+// the server was built with the chaos build tag.
+func (c *Client) Chaos(ctx context.Context) (*Chaos, error) {
+	var resp Chaos
+	if err := c.do(ctx, http.MethodGet, "/api/debug/chaos", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// SetChaos replaces the faults the server injects into models. Faults are
+// cleared by setting none. It fails unless the server was built with the
+// chaos build tag.
+func (c *Client) SetChaos(ctx context.Context, req *Chaos) error {
+	return c.do(ctx, http.MethodPut, "/api/debug/chaos", req, nil)
+}
+
+// Recommend suggests models suited to the server's hardware.
+func (c *Client) Recommend(ctx context.Context, req *RecommendRequest) (*RecommendResponse, error) {
+	var resp RecommendResponse
+	if err := c.do(ctx, http.MethodPost, "/api/recommend", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Agent runs a model in a loop that calls the server's registered tools
+// until the model answers or runs out of its budget. fn is called for each
+// step.
+func (c *Client) Agent(ctx context.Context, req *AgentRequest, fn AgentEventFunc) error {
+	return c.stream(ctx, http.MethodPost, "/api/agent", req, func(bts []byte) error {
+		var event AgentEvent
+		if err := json.Unmarshal(bts, &event); err != nil {
+			return err
+		}
+
+		return fn(event)
+	})
+}
+
+// Ensemble answers a chat with several models, or several samples of one
+// model, and has a judge model pick or merge the best answer.
+func (c *Client) Ensemble(ctx context.Context, req *EnsembleRequest) (*EnsembleResponse, error) {
+	var resp EnsembleResponse
+	if err := c.do(ctx, http.MethodPost, "/api/ensemble", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Batch runs the requests in req with one model. fn is called with the result
+// of each request as it completes, so results aren't in the order of the
+// requests.
+func (c *Client) Batch(ctx context.Context, req *BatchRequest, fn BatchResponseFunc) error {
+	return c.stream(ctx, http.MethodPost, "/api/batch", req, func(bts []byte) error {
+		var resp BatchResponse
+		if err := json.Unmarshal(bts, &resp); err != nil {
+			return err
+		}
+
+		return fn(resp)
+	})
+}
+
 // Copy copies a model - creating a model with another name from an existing
 // model.
 func (c *Client) Copy(ctx context.Context, req *CopyRequest) error {
@@ -333,6 +610,16 @@ func (c *Client) Show(ctx context.Context, req *ShowRequest) (*ShowResponse, err
 	return &resp, nil
 }
 
+// AcceptLicense records the acceptance of the license of a model, which lets
+// models that require it be run.
+func (c *Client) AcceptLicense(ctx context.Context, req *AcceptLicenseRequest) (*LicenseAcceptance, error) {
+	var resp LicenseAcceptance
+	if err := c.do(ctx, http.MethodPost, "/api/license/accept", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
 // Heartbeat checks if the server has started and is responsive; if yes, it
 // returns nil, otherwise an error.
 func (c *Client) Heartbeat(ctx context.Context) error {
@@ -351,6 +638,25 @@ func (c *Client) Embed(ctx context.Context, req *EmbedRequest) (*EmbedResponse,
 	return &resp, nil
 }
 
+// Rerank scores how relevant documents are to a query with a reranking model.
+func (c *Client) Rerank(ctx context.Context, req *RerankRequest) (*RerankResponse, error) {
+	var resp RerankResponse
+	if err := c.do(ctx, http.MethodPost, "/api/rerank", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Profile returns the profile of a generation with the profile option set,
+// by the ID in its final response.
+func (c *Client) Profile(ctx context.Context, id string) (*Profile, error) {
+	var resp Profile
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/api/profiles/%s", id), nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
 // Embeddings generates an embedding from a model.
 func (c *Client) Embeddings(ctx context.Context, req *EmbeddingRequest) (*EmbeddingResponse, error) {
 	var resp EmbeddingResponse
@@ -360,12 +666,222 @@ func (c *Client) Embeddings(ctx context.Context, req *EmbeddingRequest) (*Embedd
 	return &resp, nil
 }
 
+// Split splits text into chunks of at most a number of tokens of a model.
+func (c *Client) Split(ctx context.Context, req *SplitRequest) (*SplitResponse, error) {
+	var resp SplitResponse
+	if err := c.do(ctx, http.MethodPost, "/api/split", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Tokenize returns the token IDs of a text with a model's tokenizer.
+func (c *Client) Tokenize(ctx context.Context, req *TokenizeRequest) (*TokenizeResponse, error) {
+	var resp TokenizeResponse
+	if err := c.do(ctx, http.MethodPost, "/api/tokenize", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Detokenize returns the text of token IDs with a model's tokenizer.
+func (c *Client) Detokenize(ctx context.Context, req *DetokenizeRequest) (*DetokenizeResponse, error) {
+	var resp DetokenizeResponse
+	if err := c.do(ctx, http.MethodPost, "/api/detokenize", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Watermark checks whether text was generated with the server's watermark.
+func (c *Client) Watermark(ctx context.Context, req *WatermarkRequest) (*WatermarkResponse, error) {
+	var resp WatermarkResponse
+	if err := c.do(ctx, http.MethodPost, "/api/watermark", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// TokenizerCheck tokenizes and detokenizes texts with a model's tokenizer
+// and reports the texts that don't round trip.
+func (c *Client) TokenizerCheck(ctx context.Context, req *TokenizerCheckRequest) (*TokenizerCheckResponse, error) {
+	var resp TokenizerCheckResponse
+	if err := c.do(ctx, http.MethodPost, "/api/tokenizer/check", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
 // CreateBlob creates a blob from a file on the server. digest is the
 // expected SHA256 digest of the file, and r represents the file.
 func (c *Client) CreateBlob(ctx context.Context, digest string, r io.Reader) error {
 	return c.do(ctx, http.MethodPost, fmt.Sprintf("/api/blobs/%s", digest), r, nil)
 }
 
+// CreateUpload starts an upload session for the blobs in req. The response
+// reports how much of each blob the server already has so an interrupted
+// upload can resume.
+func (c *Client) CreateUpload(ctx context.Context, req *UploadRequest) (*UploadResponse, error) {
+	var resp UploadResponse
+	if err := c.do(ctx, http.MethodPost, "/api/uploads", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Upload returns the state of the upload session id.
+func (c *Client) Upload(ctx context.Context, id string) (*UploadResponse, error) {
+	var resp UploadResponse
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/api/uploads/%s", id), nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// UploadChunk sends a chunk of the blob with digest in upload session id.
+// offset must equal the number of bytes the server has received for the blob.
+func (c *Client) UploadChunk(ctx context.Context, id, digest string, offset int64, r io.Reader) (*UploadBlob, error) {
+	requestURL := c.base.JoinPath("api", "uploads", id, digest)
+	request, err := http.NewRequestWithContext(ctx, http.MethodPatch, requestURL.String(), r)
+	if err != nil {
+		return nil, err
+	}
+
+	request.Header.Set("Content-Type", "application/octet-stream")
+	request.Header.Set("Accept", "application/json")
+	request.Header.Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	request.Header.Set("User-Agent", fmt.Sprintf("ollama/%s (%s %s) Go/%s", version.Version, runtime.GOARCH, runtime.GOOS, runtime.Version()))
+	if c.apiKey != "" {
+		request.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+
+	response, err := c.http.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkError(response, body); err != nil {
+		return nil, err
+	}
+
+	var blob UploadBlob
+	if err := json.Unmarshal(body, &blob); err != nil {
+		return nil, err
+	}
+	return &blob, nil
+}
+
+// DeleteUpload cancels the upload session id and discards its partial blobs.
+func (c *Client) DeleteUpload(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, fmt.Sprintf("/api/uploads/%s", id), nil, nil)
+}
+
+// CreateSession starts a chat session with the history in req. Chat requests
+// with the session's ID only send new messages.
+func (c *Client) CreateSession(ctx context.Context, req *SessionRequest) (*SessionResponse, error) {
+	var resp SessionResponse
+	if err := c.do(ctx, http.MethodPost, "/api/sessions", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ForkSession starts a chat session with the start of the history of the
+// session id, so the two can continue differently. The fork's prompts start
+// like those of id, so the model reuses its cache of them.
+func (c *Client) ForkSession(ctx context.Context, id string, req *ForkSessionRequest) (*SessionResponse, error) {
+	var resp SessionResponse
+	if err := c.do(ctx, http.MethodPost, fmt.Sprintf("/api/sessions/%s/fork", id), req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Session returns the session id and its history.
+func (c *Client) Session(ctx context.Context, id string) (*SessionResponse, error) {
+	var resp SessionResponse
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/api/sessions/%s", id), nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ListSessions lists the sessions on the server without their history.
+func (c *Client) ListSessions(ctx context.Context) (*ListSessionsResponse, error) {
+	var resp ListSessionsResponse
+	if err := c.do(ctx, http.MethodGet, "/api/sessions", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// DeleteSession ends the session id and discards its history.
+func (c *Client) DeleteSession(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, fmt.Sprintf("/api/sessions/%s", id), nil, nil)
+}
+
+// Purge discards sessions and cached prompts, by model, by session or
+// memory key, or all of them.
+func (c *Client) Purge(ctx context.Context, req *PurgeRequest) (*PurgeResponse, error) {
+	var resp PurgeResponse
+	if err := c.do(ctx, http.MethodPost, "/api/purge", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Usage returns the tokens each client of the server has used.
+func (c *Client) Usage(ctx context.Context) (*UsageResponse, error) {
+	var resp UsageResponse
+	if err := c.do(ctx, http.MethodGet, "/api/usage", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ListMemories lists the memories of key, oldest first.
+func (c *Client) ListMemories(ctx context.Context, key string) (*ListMemoriesResponse, error) {
+	var resp ListMemoriesResponse
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/api/memories/%s", url.PathEscape(key)), nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// AddMemory adds a memory to key, as if it was extracted from a chat.
+func (c *Client) AddMemory(ctx context.Context, key string, req *MemoryRequest) (*Memory, error) {
+	var resp Memory
+	if err := c.do(ctx, http.MethodPost, fmt.Sprintf("/api/memories/%s", url.PathEscape(key)), req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// UpdateMemory replaces the content of the memory id of key.
+func (c *Client) UpdateMemory(ctx context.Context, key, id string, req *MemoryRequest) (*Memory, error) {
+	var resp Memory
+	if err := c.do(ctx, http.MethodPut, fmt.Sprintf("/api/memories/%s/%s", url.PathEscape(key), id), req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// DeleteMemory forgets the memory id of key.
+func (c *Client) DeleteMemory(ctx context.Context, key, id string) error {
+	return c.do(ctx, http.MethodDelete, fmt.Sprintf("/api/memories/%s/%s", url.PathEscape(key), id), nil, nil)
+}
+
+// DeleteMemories forgets every memory of key.
+func (c *Client) DeleteMemories(ctx context.Context, key string) error {
+	return c.do(ctx, http.MethodDelete, fmt.Sprintf("/api/memories/%s", url.PathEscape(key)), nil, nil)
+}
+
 // Version returns the Ollama server version as a string.
 func (c *Client) Version(ctx context.Context) (string, error) {
 	var version struct {
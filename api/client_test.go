@@ -1,7 +1,21 @@
 package api
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"testing/iotest"
+	"unicode/utf8"
 )
 
 func TestClientFromEnvironment(t *testing.T) {
@@ -43,3 +57,150 @@ func TestClientFromEnvironment(t *testing.T) {
 		})
 	}
 }
+
+func TestClientFromEnvironmentSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ollama.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Skip("unix sockets aren't supported:", err)
+	}
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"version":"1.2.3"}`)
+	}))
+	srv.Listener = ln
+	srv.Start()
+	defer srv.Close()
+
+	t.Setenv("OLLAMA_HOST", "unix://"+path)
+	client, err := ClientFromEnvironment()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	version, err := client.Version(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if version != "1.2.3" {
+		t.Errorf("expected version 1.2.3, got %s", version)
+	}
+}
+
+func TestClientFromEnvironmentKeyFile(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "keys")
+	if err := os.WriteFile(keyFile, []byte("# clients\n\n  key-one  \nkey-two\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("OLLAMA_HOST", "0.0.0.0:8080?key_file="+keyFile)
+	t.Setenv("OLLAMA_CLIENT_KEY", "")
+	client, err := ClientFromEnvironment()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if client.apiKey != "key-one" {
+		t.Errorf("expected the first key in the key file, got %q", client.apiKey)
+	}
+
+	t.Setenv("OLLAMA_CLIENT_KEY", "other-key")
+	if client, err = ClientFromEnvironment(); err != nil {
+		t.Fatal(err)
+	} else if client.apiKey != "other-key" {
+		t.Errorf("expected OLLAMA_CLIENT_KEY to take precedence, got %q", client.apiKey)
+	}
+
+	t.Setenv("OLLAMA_CLIENT_KEY", "")
+	t.Setenv("OLLAMA_HOST", "0.0.0.0:8080?key_file="+filepath.Join(t.TempDir(), "missing"))
+	if _, err := ClientFromEnvironment(); err == nil {
+		t.Error("expected an error for a missing key file")
+	}
+}
+
+func TestClientErrorCodes(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/show":
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error":"model 'test' not found","code":"model_not_found"}`)
+		case "/api/chat":
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprint(w, `{"error":"server busy","code":"queue_full"}`)
+		case "/api/generate":
+			fmt.Fprint(w, `{"error":"something went wrong"}`)
+		}
+	}))
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := NewClient(u, ts.Client())
+
+	_, err = client.Show(context.Background(), &ShowRequest{Model: "test"})
+	if !errors.Is(err, ErrModelNotFound) || errors.Is(err, ErrQueueFull) {
+		t.Errorf("expected model not found error, got %v", err)
+	}
+
+	if err.Error() != "model 'test' not found" {
+		t.Errorf("unexpected error message %q", err.Error())
+	}
+
+	err = client.Chat(context.Background(), &ChatRequest{Model: "test"}, func(ChatResponse) error { return nil })
+	var se StatusError
+	if !errors.Is(err, ErrQueueFull) || !errors.As(err, &se) || se.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected queue full error, got %v", err)
+	}
+
+	if err.Error() != "server busy" {
+		t.Errorf("unexpected error message %q", err.Error())
+	}
+
+	// errors without a code are unchanged
+	err = client.Generate(context.Background(), &GenerateRequest{Model: "test"}, func(GenerateResponse) error { return nil })
+	if err == nil || errors.As(err, &se) || err.Error() != "something went wrong" {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestInputBody(t *testing.T) {
+	input := strings.Repeat("héllo wörld 日本語 ", inputChunkSize/8)
+
+	// one byte reads split every multibyte character
+	body := inputBody(&ChatRequest{Model: "test"}, iotest.OneByteReader(strings.NewReader(input)))
+
+	dec := json.NewDecoder(body)
+	var req ChatRequest
+	if err := dec.Decode(&req); err != nil {
+		t.Fatal(err)
+	}
+
+	if req.Model != "test" {
+		t.Errorf("expected model test, got %q", req.Model)
+	}
+
+	var sb strings.Builder
+	for {
+		var chunk InputChunk
+		if err := dec.Decode(&chunk); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+
+		if strings.ContainsRune(chunk.Content, utf8.RuneError) {
+			t.Fatalf("chunk has an invalid character: %q", chunk.Content)
+		}
+
+		sb.WriteString(chunk.Content)
+	}
+
+	if sb.String() != input {
+		t.Errorf("input doesn't match, got %d bytes, expected %d", sb.Len(), len(input))
+	}
+}
@@ -2,6 +2,7 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"math"
@@ -17,6 +18,51 @@ type StatusError struct {
 	StatusCode   int
 	Status       string
 	ErrorMessage string `json:"error"`
+
+	// Code identifies the kind of error, if known. Use [errors.Is] with the
+	// Err* errors of this package to check for a specific code.
+	Code ErrorCode `json:"code,omitempty"`
+}
+
+// ErrorCode is a stable, machine readable identifier included in error
+// responses alongside the human readable message.
+type ErrorCode string
+
+const (
+	ErrorCodeModelNotFound         ErrorCode = "model_not_found"
+	ErrorCodeOutOfMemory           ErrorCode = "out_of_memory"
+	ErrorCodeContextExceeded       ErrorCode = "context_exceeded"
+	ErrorCodeQueueFull             ErrorCode = "queue_full"
+	ErrorCodeUnsupportedCapability ErrorCode = "unsupported_capability"
+	ErrorCodeRunnerCrashed         ErrorCode = "runner_crashed"
+	ErrorCodeLicenseNotAccepted    ErrorCode = "license_not_accepted"
+)
+
+var (
+	ErrModelNotFound         = errors.New("model not found")
+	ErrOutOfMemory           = errors.New("out of memory")
+	ErrContextExceeded       = errors.New("context length exceeded")
+	ErrQueueFull             = errors.New("queue full")
+	ErrUnsupportedCapability = errors.New("unsupported capability")
+	ErrRunnerCrashed         = errors.New("runner crashed")
+	ErrLicenseNotAccepted    = errors.New("license not accepted")
+)
+
+var errorCodes = map[error]ErrorCode{
+	ErrModelNotFound:         ErrorCodeModelNotFound,
+	ErrOutOfMemory:           ErrorCodeOutOfMemory,
+	ErrContextExceeded:       ErrorCodeContextExceeded,
+	ErrQueueFull:             ErrorCodeQueueFull,
+	ErrUnsupportedCapability: ErrorCodeUnsupportedCapability,
+	ErrRunnerCrashed:         ErrorCodeRunnerCrashed,
+	ErrLicenseNotAccepted:    ErrorCodeLicenseNotAccepted,
+}
+
+// Is reports whether target is the Err* error matching the code of e,
+// e.g. errors.Is(err, api.ErrModelNotFound).
+func (e StatusError) Is(target error) bool {
+	code, ok := errorCodes[target]
+	return ok && e.Code == code
 }
 
 func (e StatusError) Error() string {
@@ -50,6 +96,11 @@ type GenerateRequest struct {
 	// Suffix is the text that comes after the inserted text.
 	Suffix string `json:"suffix"`
 
+	// Prefix is the text that comes before the inserted text, which can be
+	// set instead of Prompt to fill in the middle between Prefix and Suffix,
+	// such as code around an editor's cursor.
+	Prefix string `json:"prefix,omitempty"`
+
 	// System overrides the model's default system message/prompt.
 	System string `json:"system"`
 
@@ -63,6 +114,11 @@ type GenerateRequest struct {
 	// Stream specifies whether the response is streaming; it is true by default.
 	Stream *bool `json:"stream,omitempty"`
 
+	// Events streams the response as [Event]s, one for each phase of the
+	// request, rather than as GenerateResponses. It's ignored unless the
+	// response is streamed.
+	Events bool `json:"events,omitempty"`
+
 	// Raw set to true means that no formatting will be applied to the prompt.
 	Raw bool `json:"raw,omitempty"`
 
@@ -77,6 +133,21 @@ type GenerateRequest struct {
 	// request, for multimodal models.
 	Images []ImageData `json:"images,omitempty"`
 
+	// Logprobs returns the log probability of each generated token in the
+	// responses' Logprobs, along with the TopLogprobs most likely tokens at
+	// each position, up to 20.
+	Logprobs    bool `json:"logprobs,omitempty"`
+	TopLogprobs int  `json:"top_logprobs,omitempty"`
+
+	// Metadata is an optional set of labels, such as trace or user IDs, that
+	// is logged with the request and returned in the final response.
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	// NoStore keeps the request's content out of the server's logs and
+	// caches, for sensitive inputs. Only aggregate counters such as token
+	// usage and metrics are recorded.
+	NoStore bool `json:"no_store,omitempty"`
+
 	// Options lists model-specific options. For example, temperature can be
 	// set through this field, if the model supports it.
 	Options map[string]interface{} `json:"options"`
@@ -90,9 +161,23 @@ type ChatRequest struct {
 	// Messages is the messages of the chat - can be used to keep a chat memory.
 	Messages []Message `json:"messages"`
 
+	// Session is the ID of a session created with [Client.CreateSession]
+	// whose history the messages continue. Only the new messages are sent,
+	// and they're added to the session along with the response.
+	Session string `json:"session,omitempty"`
+
+	// Memory is the key of the long-term memories the chat uses, such as a
+	// user ID. Memories relevant to the chat are added to its system
+	// message, and facts worth remembering are extracted from it afterwards.
+	// Chats in a session use the session's memory if they don't set one.
+	Memory string `json:"memory,omitempty"`
+
 	// Stream enables streaming of returned responses; true by default.
 	Stream *bool `json:"stream,omitempty"`
 
+	// Events streams the response as [Event]s, as in [GenerateRequest].
+	Events bool `json:"events,omitempty"`
+
 	// Format is the format to return the response in (e.g. "json").
 	Format json.RawMessage `json:"format,omitempty"`
 
@@ -103,10 +188,120 @@ type ChatRequest struct {
 	// Tools is an optional list of tools the model has access to.
 	Tools `json:"tools,omitempty"`
 
+	// ParallelToolCalls lets a response constrained by strict tools call
+	// several tools at once instead of one.
+	ParallelToolCalls bool `json:"parallel_tool_calls,omitempty"`
+
+	// ToolChoice controls whether the model calls tools and which. It
+	// defaults to "auto".
+	ToolChoice *ToolChoice `json:"tool_choice,omitempty"`
+
+	// Documents is an optional list of sources for the model to answer from.
+	// The model is asked to cite them and the citations are returned in the
+	// final [ChatResponse].
+	Documents []Document `json:"documents,omitempty"`
+
+	// Metadata is an optional set of labels, such as trace or user IDs, that
+	// is logged with the request and returned in the final response.
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	// NoStore keeps the chat's content out of the server's logs and caches,
+	// as in [GenerateRequest]. It can't be used with a session, and memories
+	// are recalled but nothing is remembered from the chat.
+	NoStore bool `json:"no_store,omitempty"`
+
+	// Raw set to true means that Prompt is sent to the model as is instead of
+	// the messages rendered with the model's template. Images are still taken
+	// from Messages, numbered in order, and referenced as [img-<n>] in Prompt.
+	Raw bool `json:"raw,omitempty"`
+
+	// Prompt is the prompt of a raw request.
+	Prompt string `json:"prompt,omitempty"`
+
+	// ToolValidation checks the tool calls in the response against the
+	// parameters of their tools: "error" reports the calls that don't match
+	// in the response's ToolCallErrors, and "retry" first generates the
+	// response again constrained to valid calls. Tool calls aren't checked by
+	// default.
+	ToolValidation string `json:"tool_validation,omitempty"`
+
+	// Truncation is how messages are dropped when the chat doesn't fit in
+	// the context window: "drop" drops the oldest messages, "middle-out"
+	// keeps the first message after the system messages, and "summarize"
+	// replaces the dropped messages with a summary generated by the model.
+	// System messages and the last message are always kept. It defaults to
+	// "drop".
+	Truncation string `json:"truncation,omitempty"`
+
+	// Logprobs and TopLogprobs return log probabilities, as in
+	// [GenerateRequest].
+	Logprobs    bool `json:"logprobs,omitempty"`
+	TopLogprobs int  `json:"top_logprobs,omitempty"`
+
+	// Think controls the reasoning of models that think before they
+	// answer. It's returned in the Thinking of the response's message, and
+	// false asks the model to answer without reasoning. It's true by
+	// default for models that reason, and can only be set for those models.
+	Think *bool `json:"think,omitempty"`
+
 	// Options lists model-specific options.
 	Options map[string]interface{} `json:"options"`
 }
 
+// Document is a source the model can cite in a chat response.
+type Document struct {
+	// ID identifies the document to the client, e.g. a URL or database key.
+	ID string `json:"id,omitempty"`
+
+	Title   string `json:"title,omitempty"`
+	Content string `json:"content"`
+}
+
+// TokenLogprob is the log probability of a token.
+type TokenLogprob struct {
+	Token   string  `json:"token"`
+	Logprob float64 `json:"logprob"`
+}
+
+// Logprob is the log probability of a generated token.
+type Logprob struct {
+	TokenLogprob
+
+	// Offset is the byte offset of the token in the response.
+	Offset int `json:"offset"`
+
+	// TopLogprobs are the most likely tokens at the token's position, most
+	// likely first.
+	TopLogprobs []TokenLogprob `json:"top_logprobs,omitempty"`
+}
+
+// Citation is a reference to one of the request's documents in a chat
+// response.
+type Citation struct {
+	// Index is the position of the document in the request's documents.
+	Index int `json:"index"`
+
+	// DocumentID is the ID of the document.
+	DocumentID string `json:"document_id,omitempty"`
+
+	// Start and End are the byte offsets of the source marker in the
+	// message content.
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// InputChunk is part of a request to [Client.Generate] or [Client.Chat] sent
+// after the request object in an application/x-ndjson body, so very long
+// inputs can be sent without building them in memory.
+type InputChunk struct {
+	// Content is appended to the prompt of a generate request, or the content
+	// of the last message of a chat request.
+	Content string `json:"content,omitempty"`
+
+	// Message is added to the messages of a chat request.
+	Message *Message `json:"message,omitempty"`
+}
+
 type Tools []Tool
 
 func (t Tools) String() string {
@@ -127,6 +322,40 @@ type Message struct {
 	Content   string      `json:"content"`
 	Images    []ImageData `json:"images,omitempty"`
 	ToolCalls []ToolCall  `json:"tool_calls,omitempty"`
+
+	// ToolCallDeltas are parts of tool calls streamed as they're generated.
+	// They're only set in streamed chat responses constrained to tool calls,
+	// and the complete calls are still sent in ToolCalls.
+	ToolCallDeltas []ToolCallDelta `json:"tool_call_deltas,omitempty"`
+
+	// Thinking is the reasoning of models that think before they answer,
+	// separated from Content. It's only set in chat responses.
+	Thinking string `json:"thinking,omitempty"`
+
+	// RawToolCalls is the text ToolCalls were parsed from, as the model
+	// generated it with its native tool call tokens, for frameworks that
+	// parse a model's output themselves. It's only set in chat responses.
+	RawToolCalls string `json:"raw_tool_calls,omitempty"`
+
+	// ImageRegions are parts of Images the model is shown in place of the
+	// whole images, so it can be pointed at part of a screenshot without
+	// cropping the image first. An image has at most one region.
+	ImageRegions []ImageRegion `json:"image_regions,omitempty"`
+}
+
+// ImageRegion is a part of one of a message's images. It's either a Crop
+// rectangle in pixels or a Box in fractions of the image's size.
+type ImageRegion struct {
+	// Image is the index of the image in the message's Images.
+	Image int `json:"image"`
+
+	// Crop is the region in pixels as [x, y, width, height], from the top
+	// left corner of the image.
+	Crop []int `json:"crop,omitempty"`
+
+	// Box is the region as [x0, y0, x1, y1], normalized to the width and
+	// height of the image so each coordinate is between 0 and 1.
+	Box []float64 `json:"box,omitempty"`
 }
 
 func (m *Message) UnmarshalJSON(b []byte) error {
@@ -153,6 +382,26 @@ type ToolCallFunction struct {
 
 type ToolCallFunctionArguments map[string]any
 
+// ToolCallDelta is part of a streamed tool call. The first delta of a call
+// has its Name and the deltas after it have fragments of its JSON encoded
+// Arguments.
+type ToolCallDelta struct {
+	Index     int    `json:"index"`
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments,omitempty"`
+}
+
+// ToolCallError describes a tool call that doesn't match its tool.
+type ToolCallError struct {
+	// Index is the position of the call in the message's tool calls.
+	Index int    `json:"index"`
+	Name  string `json:"name"`
+
+	// Errors name each argument that doesn't match the tool's parameters
+	// and why.
+	Errors []string `json:"errors"`
+}
+
 func (t *ToolCallFunctionArguments) String() string {
 	bts, _ := json.Marshal(t)
 	return string(bts)
@@ -164,17 +413,122 @@ type Tool struct {
 }
 
 type ToolFunction struct {
-	Name        string `json:"name"`
-	Description string `json:"description"`
-	Parameters  struct {
-		Type       string   `json:"type"`
-		Required   []string `json:"required"`
-		Properties map[string]struct {
-			Type        string   `json:"type"`
-			Description string   `json:"description"`
-			Enum        []string `json:"enum,omitempty"`
-		} `json:"properties"`
-	} `json:"parameters"`
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	Parameters  ToolParameters `json:"parameters"`
+
+	// Strict constrains the response to a call of one of the request's tools,
+	// with arguments that match the tool's parameters
+	Strict bool `json:"strict,omitempty"`
+}
+
+// ToolParameters is the JSON schema of a tool's arguments
+type ToolParameters struct {
+	Type       string                  `json:"type"`
+	Required   []string                `json:"required"`
+	Properties map[string]ToolProperty `json:"properties"`
+}
+
+// ToolProperty is the JSON schema of a tool argument. Objects and arrays
+// nest further properties.
+type ToolProperty struct {
+	Type        PropertyType `json:"type,omitempty"`
+	Description string       `json:"description"`
+	Enum        []any        `json:"enum,omitempty"`
+	Const       any          `json:"const,omitempty"`
+	Default     any          `json:"default,omitempty"`
+
+	// objects
+	Properties           map[string]ToolProperty `json:"properties,omitempty"`
+	Required             []string                `json:"required,omitempty"`
+	AdditionalProperties *bool                   `json:"additionalProperties,omitempty"`
+
+	// arrays
+	Items       *ToolProperty  `json:"items,omitempty"`
+	PrefixItems []ToolProperty `json:"prefixItems,omitempty"`
+	MinItems    *int           `json:"minItems,omitempty"`
+	MaxItems    *int           `json:"maxItems,omitempty"`
+
+	// strings
+	MinLength *int   `json:"minLength,omitempty"`
+	MaxLength *int   `json:"maxLength,omitempty"`
+	Pattern   string `json:"pattern,omitempty"`
+	Format    string `json:"format,omitempty"`
+
+	// numbers
+	Minimum          *float64 `json:"minimum,omitempty"`
+	Maximum          *float64 `json:"maximum,omitempty"`
+	ExclusiveMinimum *float64 `json:"exclusiveMinimum,omitempty"`
+	ExclusiveMaximum *float64 `json:"exclusiveMaximum,omitempty"`
+
+	// combinations
+	AnyOf []ToolProperty `json:"anyOf,omitempty"`
+	OneOf []ToolProperty `json:"oneOf,omitempty"`
+	AllOf []ToolProperty `json:"allOf,omitempty"`
+}
+
+// PropertyType is the type of a tool property. It's a single type, such as
+// "string", or a list of types, such as ["string", "null"].
+type PropertyType []string
+
+func (pt PropertyType) MarshalJSON() ([]byte, error) {
+	if len(pt) == 1 {
+		return json.Marshal(pt[0])
+	}
+
+	return json.Marshal([]string(pt))
+}
+
+func (pt *PropertyType) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*pt = PropertyType{s}
+		return nil
+	}
+
+	var ss []string
+	if err := json.Unmarshal(b, &ss); err != nil {
+		return err
+	}
+
+	*pt = ss
+	return nil
+}
+
+func (pt PropertyType) String() string {
+	return strings.Join(pt, " | ")
+}
+
+// ToolChoice is "auto", "none", "required" or a function the response must
+// call, in the form {"type": "function", "function": {"name": "..."}}.
+type ToolChoice struct {
+	Type     string              `json:"type"`
+	Function *ToolChoiceFunction `json:"function,omitempty"`
+}
+
+// ToolChoiceFunction names the function of a [ToolChoice].
+type ToolChoiceFunction struct {
+	Name string `json:"name"`
+}
+
+func (tc ToolChoice) MarshalJSON() ([]byte, error) {
+	if tc.Type != "function" {
+		return json.Marshal(tc.Type)
+	}
+
+	type toolChoice ToolChoice
+	return json.Marshal(toolChoice(tc))
+}
+
+func (tc *ToolChoice) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*tc = ToolChoice{Type: s}
+		return nil
+	}
+
+	type toolChoice ToolChoice
+	return json.Unmarshal(b, (*toolChoice)(tc))
 }
 
 func (t *ToolFunction) String() string {
@@ -190,6 +544,30 @@ type ChatResponse struct {
 	Message    Message   `json:"message"`
 	DoneReason string    `json:"done_reason,omitempty"`
 
+	// Logprobs are the log probabilities of the tokens of the message in
+	// this response, if the request asked for them.
+	Logprobs []Logprob `json:"logprobs,omitempty"`
+
+	// Citations are the documents cited in the message. They're only set on
+	// the final response.
+	Citations []Citation `json:"citations,omitempty"`
+
+	// Metadata is the request's metadata. It's only set on the final
+	// response.
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	// TruncatedMessages is the number of messages dropped to fit the chat
+	// in the context window. PromptTokens is the number of tokens in the
+	// prompt, ImageTokens of which are images. They're only set on the
+	// final response.
+	TruncatedMessages int `json:"truncated_messages,omitempty"`
+	PromptTokens      int `json:"prompt_tokens,omitempty"`
+	ImageTokens       int `json:"image_tokens,omitempty"`
+
+	// ToolCallErrors are the tool calls in Message that don't match their
+	// tools' parameters, if the request asked for tool calls to be validated
+	ToolCallErrors []ToolCallError `json:"tool_call_errors,omitempty"`
+
 	Done bool `json:"done"`
 
 	Metrics
@@ -202,6 +580,151 @@ type Metrics struct {
 	PromptEvalDuration time.Duration `json:"prompt_eval_duration,omitempty"`
 	EvalCount          int           `json:"eval_count,omitempty"`
 	EvalDuration       time.Duration `json:"eval_duration,omitempty"`
+
+	// DraftCount and DraftAcceptedCount are the tokens drafted for
+	// speculative decoding and the number of them the model accepted
+	DraftCount         int `json:"draft_count,omitempty"`
+	DraftAcceptedCount int `json:"draft_accepted_count,omitempty"`
+
+	// Degraded is set if the model was loaded with a reduced configuration
+	// after running out of memory
+	Degraded *DegradedConfig `json:"degraded,omitempty"`
+
+	// Advisories warn that the model's quantization is slow on the hardware
+	// it was loaded on. They're only set on the first response after the
+	// model is loaded.
+	Advisories []Advisory `json:"advisories,omitempty"`
+
+	// Diagnostics describe what happened if the generation ended abnormally
+	// or had to shift the context window
+	Diagnostics *Diagnostics `json:"diagnostics,omitempty"`
+
+	// ProfileID is the ID of the profile of the generation, which is
+	// returned by [Client.Profile], if the profile option was set
+	ProfileID string `json:"profile_id,omitempty"`
+}
+
+// Profile is the time a generation spent computing the operations of the
+// model's graphs, recorded with the profile option. It's returned by
+// [Client.Profile].
+type Profile struct {
+	ID        string    `json:"id"`
+	Model     string    `json:"model"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// Duration is the time spent in all of the operations.
+	Duration time.Duration `json:"duration"`
+
+	// Layers are the time spent in each layer of the model, in order.
+	Layers []ProfileLayer `json:"layers"`
+
+	// Ops are the time spent computing the tensors with each name and
+	// operation, slowest first. Names of tensors in a layer end with the
+	// layer's index, such as "attn_norm-3".
+	Ops []ProfileOp `json:"ops"`
+}
+
+type ProfileLayer struct {
+	Layer    int           `json:"layer"`
+	Duration time.Duration `json:"duration"`
+}
+
+type ProfileOp struct {
+	Name     string        `json:"name"`
+	Op       string        `json:"op"`
+	Count    int           `json:"count"`
+	Duration time.Duration `json:"duration"`
+}
+
+// The phases of a request streamed as events
+const (
+	PhaseQueued     = "queued"
+	PhaseLoading    = "loading"
+	PhasePrefill    = "prefill"
+	PhaseThinking   = "thinking"
+	PhaseGenerating = "generating"
+	PhaseToolCall   = "tool_call"
+	PhaseDone       = "done"
+)
+
+// Event is a chunk of a response streamed with events set. Phase is what the
+// request is doing, which decides the fields that are set:
+//
+//   - queued: the request is waiting for the model to be scheduled
+//   - loading: the model is being loaded
+//   - prefill: the prompt is being processed. PromptTokens is its length,
+//     which is estimated for generate requests.
+//   - thinking: Thinking is the reasoning generated since the last event
+//   - generating: Content is the text generated since the last event
+//   - tool_call: ToolCalls are the tool calls generated since the last
+//     event, with the ToolCallErrors of those that don't match their tools.
+//     ToolCallDeltas are the parts of calls streamed as they're generated,
+//     and RawToolCalls is the text the calls were parsed from.
+//   - done: Generate or Chat is the final response of the request, without
+//     the content already sent in other events
+type Event struct {
+	Phase     string    `json:"phase"`
+	Model     string    `json:"model"`
+	CreatedAt time.Time `json:"created_at"`
+
+	PromptTokens   int               `json:"prompt_tokens,omitempty"`
+	Thinking       string            `json:"thinking,omitempty"`
+	Content        string            `json:"content,omitempty"`
+	Logprobs       []Logprob         `json:"logprobs,omitempty"`
+	ToolCalls      []ToolCall        `json:"tool_calls,omitempty"`
+	ToolCallDeltas []ToolCallDelta   `json:"tool_call_deltas,omitempty"`
+	ToolCallErrors []ToolCallError   `json:"tool_call_errors,omitempty"`
+	RawToolCalls   string            `json:"raw_tool_calls,omitempty"`
+	Generate       *GenerateResponse `json:"generate,omitempty"`
+	Chat           *ChatResponse     `json:"chat,omitempty"`
+}
+
+// Diagnostics describe an abnormal end to a generation, so clients can decide
+// whether and how to retry it.
+type Diagnostics struct {
+	// Reason is why the generation was aborted, if it was:
+	// "context_shift_failed", "sampler_error", "repetition" or
+	// "runner_crashed"
+	Reason string `json:"reason,omitempty"`
+
+	// Message describes the error that aborted the generation
+	Message string `json:"message,omitempty"`
+
+	// ContextShifts is the number of times the oldest part of the context
+	// was discarded to keep generating
+	ContextShifts int `json:"context_shifts,omitempty"`
+
+	// PromptTruncated is the number of prompt tokens discarded from the
+	// middle of the prompt because it was longer than the context window
+	PromptTruncated int `json:"prompt_truncated,omitempty"`
+
+	// LogID identifies the excerpt of the runner's logs written to the
+	// server log when the runner crashed
+	LogID string `json:"log_id,omitempty"`
+}
+
+// DegradedConfig describes the reduced configuration of a model that ran out
+// of memory with its requested options.
+type DegradedConfig struct {
+	// NumGPU is the number of layers offloaded to the GPU
+	NumGPU int `json:"num_gpu"`
+
+	// NumBatch is the batch size used for prompt processing
+	NumBatch int `json:"num_batch"`
+}
+
+// Advisory is a warning that a model runs slower than it could on the
+// hardware it's loaded on because of its quantization.
+type Advisory struct {
+	// Code is the kind of advisory: "kquant_cpu" or "kquant_gpu" for
+	// K-quants on hardware without their fast paths, or "float_spill" for an
+	// unquantized model that mostly doesn't fit in VRAM
+	Code    string `json:"code"`
+	Message string `json:"message"`
+
+	// Suggestion is a local variant of the model that's likely to run
+	// faster, if one has been pulled
+	Suggestion string `json:"suggestion,omitempty"`
 }
 
 // Options specified in [GenerateRequest].  If you add a new option here, also
@@ -226,7 +749,26 @@ type Options struct {
 	MirostatTau      float32  `json:"mirostat_tau,omitempty"`
 	MirostatEta      float32  `json:"mirostat_eta,omitempty"`
 	PenalizeNewline  bool     `json:"penalize_newline,omitempty"`
+	TokenHealing     bool     `json:"token_healing,omitempty"`
 	Stop             []string `json:"stop,omitempty"`
+	StopRegex        []string `json:"stop_regex,omitempty"`
+	IncludeStop      bool     `json:"include_stop,omitempty"`
+	TrimWhitespace   bool     `json:"trim_whitespace,omitempty"`
+	IgnoreEOS        bool     `json:"ignore_eos,omitempty"`
+	PostProcess      []string `json:"post_process,omitempty"`
+	ToolResults      string   `json:"tool_results,omitempty"`
+	Fallback         string   `json:"fallback,omitempty"`
+	FallbackWait     float32  `json:"fallback_wait,omitempty"`
+	FallbackGPU      bool     `json:"fallback_gpu,omitempty"`
+	Redact           []string `json:"redact,omitempty"`
+	Watermark        bool     `json:"watermark,omitempty"`
+	Profile          bool     `json:"profile,omitempty"`
+	FormatExample    bool     `json:"format_example,omitempty"`
+	ThinkBudget      int      `json:"think_budget,omitempty"`
+	ResponseLanguage string   `json:"response_language,omitempty"`
+	StreamRate       float32  `json:"stream_rate,omitempty"`
+	Shadow           string   `json:"shadow,omitempty"`
+	ShadowFraction   float32  `json:"shadow_fraction,omitempty"`
 }
 
 // Runner options which must be set when the model is loaded into memory
@@ -242,6 +784,24 @@ type Runner struct {
 	UseMMap   *bool `json:"use_mmap,omitempty"`
 	UseMLock  bool  `json:"use_mlock,omitempty"`
 	NumThread int   `json:"num_thread,omitempty"`
+
+	// DraftModel is a smaller model with the same vocabulary that drafts
+	// tokens for speculative decoding, which the model checks in one batch
+	// instead of generating them one at a time.
+	DraftModel string `json:"draft_model,omitempty"`
+
+	// DraftMax is the most tokens drafted at a time
+	DraftMax int `json:"draft_max,omitempty"`
+
+	// TensorSplit is a comma-separated list of the number of layers to
+	// place on each GPU, in the order they're discovered, such as "24,8".
+	// GPUs with no layers aren't used. It overrides SplitPolicy.
+	TensorSplit string `json:"tensor_split,omitempty"`
+
+	// SplitPolicy is how layers are split across GPUs: "even", the
+	// default, spreads them round-robin and "fastest" fills the fastest
+	// GPU before using the next.
+	SplitPolicy string `json:"split_policy,omitempty"`
 }
 
 // EmbedRequest is the request passed to [Client.Embed].
@@ -258,6 +818,16 @@ type EmbedRequest struct {
 
 	Truncate *bool `json:"truncate,omitempty"`
 
+	// Dimensions truncates the embeddings to their first dimensions, which
+	// are renormalized. It's only meaningful for models trained to put the
+	// most information in the first dimensions, such as with matryoshka
+	// representation learning.
+	Dimensions int `json:"dimensions,omitempty"`
+
+	// Metadata is an optional set of labels, such as trace or user IDs, that
+	// is logged with the request and returned in the response.
+	Metadata map[string]string `json:"metadata,omitempty"`
+
 	// Options lists model-specific options.
 	Options map[string]interface{} `json:"options"`
 }
@@ -267,6 +837,60 @@ type EmbedResponse struct {
 	Model      string      `json:"model"`
 	Embeddings [][]float32 `json:"embeddings"`
 
+	// Metadata is the request's metadata.
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	TotalDuration   time.Duration `json:"total_duration,omitempty"`
+	LoadDuration    time.Duration `json:"load_duration,omitempty"`
+	PromptEvalCount int           `json:"prompt_eval_count,omitempty"`
+
+	// PromptEvalCounts are the number of tokens embedded for each input
+	PromptEvalCounts []int `json:"prompt_eval_counts,omitempty"`
+}
+
+// RerankRequest is the request passed to [Client.Rerank].
+type RerankRequest struct {
+	// Model is the model name of a reranker, such as a cross-encoder.
+	Model string `json:"model"`
+
+	// Query is the text documents are scored against.
+	Query string `json:"query"`
+
+	// Documents are the texts to score.
+	Documents []string `json:"documents"`
+
+	// TopN limits the results to the most relevant documents, if set.
+	TopN int `json:"top_n,omitempty"`
+
+	// Truncate truncates the end of documents that don't fit in the context
+	// window with the query; true by default.
+	Truncate *bool `json:"truncate,omitempty"`
+
+	// KeepAlive controls how long the model will stay loaded in memory following
+	// this request.
+	KeepAlive *Duration `json:"keep_alive,omitempty"`
+
+	// Options lists model-specific options.
+	Options map[string]interface{} `json:"options"`
+}
+
+// RerankResult is the score of a document in a [RerankResponse].
+type RerankResult struct {
+	// Index is the index of the document in the request.
+	Index int `json:"index"`
+
+	// RelevanceScore is how relevant the document is to the query, from 0
+	// to 1.
+	RelevanceScore float64 `json:"relevance_score"`
+}
+
+// RerankResponse is the response from [Client.Rerank].
+type RerankResponse struct {
+	Model string `json:"model"`
+
+	// Results are the scores of the documents, most relevant first.
+	Results []RerankResult `json:"results"`
+
 	TotalDuration   time.Duration `json:"total_duration,omitempty"`
 	LoadDuration    time.Duration `json:"load_duration,omitempty"`
 	PromptEvalCount int           `json:"prompt_eval_count,omitempty"`
@@ -293,6 +917,165 @@ type EmbeddingResponse struct {
 	Embedding []float64 `json:"embedding"`
 }
 
+// SplitRequest is the request passed to [Client.Split].
+type SplitRequest struct {
+	// Model is the model name. Input is split with its tokenizer.
+	Model string `json:"model"`
+
+	// Input is the text to split.
+	Input string `json:"input"`
+
+	// Size is the largest number of tokens in a chunk.
+	Size int `json:"size"`
+
+	// Overlap is the number of tokens at the end of a chunk that are repeated
+	// at the start of the next chunk.
+	Overlap int `json:"overlap,omitempty"`
+
+	// KeepAlive controls how long the model will stay loaded in memory following
+	// this request.
+	KeepAlive *Duration `json:"keep_alive,omitempty"`
+
+	// Options lists model-specific options.
+	Options map[string]interface{} `json:"options"`
+}
+
+// SplitResponse is the response from [Client.Split].
+type SplitResponse struct {
+	Model  string  `json:"model"`
+	Chunks []Chunk `json:"chunks"`
+}
+
+// Chunk is a segment of split text.
+type Chunk struct {
+	Text string `json:"text"`
+
+	// Tokens is the number of tokens in the chunk.
+	Tokens int `json:"tokens"`
+}
+
+// TokenizeRequest is the request passed to [Client.Tokenize].
+type TokenizeRequest struct {
+	// Model is the model name whose tokenizer is used.
+	Model string `json:"model"`
+
+	// Text is the text to tokenize.
+	Text string `json:"text"`
+
+	// KeepAlive controls how long the model will stay loaded in memory following
+	// this request.
+	KeepAlive *Duration `json:"keep_alive,omitempty"`
+
+	// Options lists model-specific options.
+	Options map[string]interface{} `json:"options"`
+}
+
+// TokenizeResponse is the response from [Client.Tokenize].
+type TokenizeResponse struct {
+	Model  string `json:"model"`
+	Tokens []int  `json:"tokens"`
+
+	// Count is the number of tokens in the text.
+	Count int `json:"count"`
+}
+
+// DetokenizeRequest is the request passed to [Client.Detokenize].
+type DetokenizeRequest struct {
+	// Model is the model name whose tokenizer is used.
+	Model string `json:"model"`
+
+	// Tokens are the token IDs to turn back into text.
+	Tokens []int `json:"tokens"`
+
+	// KeepAlive controls how long the model will stay loaded in memory following
+	// this request.
+	KeepAlive *Duration `json:"keep_alive,omitempty"`
+
+	// Options lists model-specific options.
+	Options map[string]interface{} `json:"options"`
+}
+
+// DetokenizeResponse is the response from [Client.Detokenize].
+type DetokenizeResponse struct {
+	Model string `json:"model"`
+	Text  string `json:"text"`
+}
+
+// WatermarkRequest is the request passed to [Client.Watermark].
+type WatermarkRequest struct {
+	// Model is the model name whose tokenizer the text is scored with.
+	Model string `json:"model"`
+
+	// Text is the text to check for the watermark.
+	Text string `json:"text"`
+
+	// KeepAlive controls how long the model will stay loaded in memory following
+	// this request.
+	KeepAlive *Duration `json:"keep_alive,omitempty"`
+}
+
+// WatermarkResponse is the response from [Client.Watermark].
+type WatermarkResponse struct {
+	Model string `json:"model"`
+
+	// Tokens is the number of tokens scored, and Green the number of them
+	// favored by the watermark at their position.
+	Tokens int `json:"tokens"`
+	Green  int `json:"green"`
+
+	// ZScore is the number of standard deviations Green is above what's
+	// expected of text generated without the watermark.
+	ZScore float64 `json:"z_score"`
+
+	// Watermarked reports whether the text was likely generated with the
+	// server's watermark.
+	Watermarked bool `json:"watermarked"`
+}
+
+// TokenizerCheckRequest is the request passed to [Client.TokenizerCheck].
+type TokenizerCheckRequest struct {
+	// Model is the model name whose tokenizer is checked.
+	Model string `json:"model"`
+
+	// Input is the corpus of texts to tokenize and detokenize.
+	Input []string `json:"input"`
+
+	// KeepAlive controls how long the model will stay loaded in memory following
+	// this request.
+	KeepAlive *Duration `json:"keep_alive,omitempty"`
+
+	// Options lists model-specific options.
+	Options map[string]interface{} `json:"options"`
+}
+
+// TokenizerCheckResponse is the response from [Client.TokenizerCheck].
+type TokenizerCheckResponse struct {
+	Model string `json:"model"`
+
+	// Tokens is the number of tokens in the corpus.
+	Tokens int `json:"tokens"`
+
+	// Mismatches are the texts that aren't the same after a round trip
+	// through the tokenizer.
+	Mismatches []TokenizerMismatch `json:"mismatches"`
+}
+
+// TokenizerMismatch is a text that changed when it was tokenized and
+// detokenized.
+type TokenizerMismatch struct {
+	// Index is the position of the text in the input.
+	Index int `json:"index"`
+
+	// Offset is the byte offset of the first difference.
+	Offset int `json:"offset"`
+
+	// Input is the text and Output is the detokenized tokens.
+	Input  string `json:"input"`
+	Output string `json:"output"`
+
+	Tokens []int `json:"tokens"`
+}
+
 // CreateRequest is the request passed to [Client.Create].
 type CreateRequest struct {
 	Model    string `json:"model"`
@@ -308,6 +1091,26 @@ type CreateRequest struct {
 	Parameters map[string]any    `json:"parameters,omitempty"`
 	Messages   []Message         `json:"messages,omitempty"`
 
+	// ToolPrompt is a template of instructions for using tools, added to
+	// the system message of chats with tools. The tools are .Tools.
+	ToolPrompt string `json:"tool_prompt,omitempty"`
+
+	// Card replaces the model card of the model it's created from
+	Card *ModelCard `json:"card,omitempty"`
+
+	// RequireLicenseAcceptance requires the license of the model to be
+	// accepted before it's run. Models created from a model that requires
+	// it require it too.
+	RequireLicenseAcceptance bool `json:"require_license_acceptance,omitempty"`
+
+	// Upload is the ID of an upload session containing the files and
+	// adapters. The session is closed once the model is created.
+	Upload string `json:"upload,omitempty"`
+
+	// Remote is a server the model generates with instead of running
+	// locally. It's used in place of From or Files.
+	Remote *RemoteModel `json:"remote,omitempty"`
+
 	// Deprecated: set with the other request options
 	Modelfile string `json:"modelfile"`
 
@@ -321,6 +1124,192 @@ type CreateRequest struct {
 	Quantization string `json:"quantization,omitempty"`
 }
 
+// RemoteModel is an OpenAI-compatible server, such as vLLM or TensorRT-LLM,
+// that generates the responses of a model. Prompts are still templated and
+// tool calls parsed locally, so the model is used like any other.
+type RemoteModel struct {
+	// URL is the base URL of the server's API, such as
+	// http://localhost:8000/v1
+	URL string `json:"url"`
+
+	// Model is the name of the model on the server
+	Model string `json:"model,omitempty"`
+}
+
+// UploadRequest is the request passed to [Client.CreateUpload]. It starts a
+// session for uploading blobs in resumable chunks which are later referenced
+// by a [CreateRequest].
+type UploadRequest struct {
+	Blobs []UploadBlob `json:"blobs"`
+}
+
+// UploadBlob is the state of a blob in an upload session.
+type UploadBlob struct {
+	Digest string `json:"digest"`
+	Size   int64  `json:"size"`
+
+	// Offset is the number of bytes received by the server. The blob is
+	// complete when Offset equals Size.
+	Offset int64 `json:"offset"`
+}
+
+// UploadResponse is the response returned by [Client.CreateUpload] and
+// [Client.Upload].
+type UploadResponse struct {
+	ID        string       `json:"id"`
+	Blobs     []UploadBlob `json:"blobs"`
+	ExpiresAt time.Time    `json:"expires_at"`
+}
+
+// SessionRequest is the request passed to [Client.CreateSession]. It starts
+// a conversation whose history is kept by the server so chat requests only
+// send new messages.
+type SessionRequest struct {
+	// Model is the model the session chats with
+	Model string `json:"model"`
+
+	// Messages is the history the session starts with, such as a system
+	// message
+	Messages []Message `json:"messages,omitempty"`
+
+	// KeepAlive is how long the model stays loaded after each chat in the
+	// session, unless the chat request sets its own
+	KeepAlive *Duration `json:"keep_alive,omitempty"`
+
+	// Options are the options of chats in the session, which chat requests
+	// override
+	Options map[string]interface{} `json:"options,omitempty"`
+
+	// Memory is the key of the long-term memories chats in the session use,
+	// as in [ChatRequest]
+	Memory string `json:"memory,omitempty"`
+
+	// Truncation is how chats in the session that don't fit in the context
+	// window are truncated, unless the chat request sets its own. Sessions
+	// that summarize truncated messages checkpoint a summary of their oldest
+	// exchanges as they near the end of the context window.
+	Truncation string `json:"truncation,omitempty"`
+}
+
+// ForkSessionRequest is the request passed to [Client.ForkSession].
+type ForkSessionRequest struct {
+	// Index is the number of messages at the start of the session's history
+	// the fork starts with. It defaults to the whole history.
+	Index *int `json:"index,omitempty"`
+}
+
+// SessionResponse is the response returned by [Client.CreateSession],
+// [Client.ForkSession] and [Client.Session].
+type SessionResponse struct {
+	ID       string    `json:"id"`
+	Model    string    `json:"model"`
+	Memory   string    `json:"memory,omitempty"`
+	Messages []Message `json:"messages,omitempty"`
+
+	// Parent is the ID of the session the session was forked from
+	Parent string `json:"parent,omitempty"`
+
+	// Truncated is the number of messages at the start of the history,
+	// other than system messages, that no longer fit in the context window
+	// and are left out of the prompt
+	Truncated int `json:"truncated,omitempty"`
+
+	// Summary is the summary of the first Summarized messages of the
+	// history, other than system messages, that takes their place in the
+	// prompt
+	Summary    string `json:"summary,omitempty"`
+	Summarized int    `json:"summarized,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// ListSessionsResponse is the response returned by [Client.ListSessions].
+type ListSessionsResponse struct {
+	Sessions []SessionResponse `json:"sessions"`
+}
+
+// PurgeRequest is the request passed to [Client.Purge]. Exactly one of its
+// fields is set.
+type PurgeRequest struct {
+	// Model purges the sessions of a model and the prompts of it that are
+	// cached
+	Model string `json:"model,omitempty"`
+
+	// Key purges the session with this ID, or the sessions that use this
+	// memory key
+	Key string `json:"key,omitempty"`
+
+	// All purges every session and cached prompt
+	All bool `json:"all,omitempty"`
+}
+
+// PurgeResponse is the response returned by [Client.Purge].
+type PurgeResponse struct {
+	// Sessions is the number of sessions purged
+	Sessions int `json:"sessions"`
+
+	// PromptCacheEntries is the number of entries purged from the prompt
+	// cache
+	PromptCacheEntries int `json:"prompt_cache_entries"`
+
+	// CacheSlots is the number of slots of loaded models' KV caches that
+	// were cleared
+	CacheSlots int `json:"cache_slots"`
+}
+
+// UsageResponse is the response returned by [Client.Usage].
+type UsageResponse struct {
+	// Client is the name of the client of the request, which quotas are
+	// set for
+	Client  string        `json:"client"`
+	Clients []ClientUsage `json:"clients"`
+}
+
+// ClientUsage is the tokens a client has used since the server started. A
+// client is named by a digest of its API key, or by its address if it
+// doesn't have one.
+type ClientUsage struct {
+	Client string `json:"client"`
+
+	// MinuteTokens and DayTokens are the tokens used in the current minute
+	// and day, with the quotas of each, or 0 if there's no quota. Days
+	// start at midnight UTC.
+	MinuteTokens int `json:"minute_tokens"`
+	MinuteQuota  int `json:"minute_quota,omitempty"`
+	DayTokens    int `json:"day_tokens"`
+	DayQuota     int `json:"day_quota,omitempty"`
+
+	Models []ModelUsage `json:"models"`
+}
+
+// ModelUsage is the tokens a client has used with a model.
+type ModelUsage struct {
+	Model            string `json:"model"`
+	Requests         int    `json:"requests"`
+	PromptTokens     int    `json:"prompt_tokens"`
+	CompletionTokens int    `json:"completion_tokens"`
+}
+
+// Memory is a fact remembered from chats with a memory key.
+type Memory struct {
+	ID        string    `json:"id"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// MemoryRequest is the request passed to [Client.AddMemory] and
+// [Client.UpdateMemory].
+type MemoryRequest struct {
+	Content string `json:"content"`
+}
+
+// ListMemoriesResponse is the response returned by [Client.ListMemories].
+type ListMemoriesResponse struct {
+	Memories []Memory `json:"memories"`
+}
+
 // DeleteRequest is the request passed to [Client.Delete].
 type DeleteRequest struct {
 	Model string `json:"model"`
@@ -340,6 +1329,13 @@ type ShowRequest struct {
 
 	Options map[string]interface{} `json:"options"`
 
+	// Tensors lists the model's tensors in the response.
+	Tensors bool `json:"tensors,omitempty"`
+
+	// Checksums computes a sha256 digest of each tensor's data. It requires
+	// Tensors and reads the entire model so it can be slow for large models.
+	Checksums bool `json:"checksums,omitempty"`
+
 	// Deprecated: set the model name with Model instead
 	Name string `json:"name"`
 }
@@ -351,11 +1347,109 @@ type ShowResponse struct {
 	Parameters    string         `json:"parameters,omitempty"`
 	Template      string         `json:"template,omitempty"`
 	System        string         `json:"system,omitempty"`
+	ToolPrompt    string         `json:"tool_prompt,omitempty"`
 	Details       ModelDetails   `json:"details,omitempty"`
 	Messages      []Message      `json:"messages,omitempty"`
 	ModelInfo     map[string]any `json:"model_info,omitempty"`
 	ProjectorInfo map[string]any `json:"projector_info,omitempty"`
+	Tensors       []Tensor       `json:"tensors,omitempty"`
 	ModifiedAt    time.Time      `json:"modified_at,omitempty"`
+
+	// LicenseAcceptance is set if the license of the model has to be
+	// accepted before it's run.
+	LicenseAcceptance *LicenseAcceptance `json:"license_acceptance,omitempty"`
+
+	// Capabilities is what the model supports, so clients don't have to
+	// find out by trial and error.
+	Capabilities *ModelCapabilities `json:"capabilities,omitempty"`
+
+	// Card describes the model, if it was created with a model card.
+	Card *ModelCard `json:"card,omitempty"`
+}
+
+// ModelCard describes how a model was made and what it's for, so the models
+// a server runs can be audited.
+type ModelCard struct {
+	Description  string `json:"description,omitempty"`
+	IntendedUse  string `json:"intended_use,omitempty"`
+	Limitations  string `json:"limitations,omitempty"`
+	TrainingData string `json:"training_data,omitempty"`
+
+	// Evals are the model's scores on benchmarks
+	Evals []ModelEval `json:"evals,omitempty"`
+
+	// Sources are the checkpoints the model was made from
+	Sources []ModelSource `json:"sources,omitempty"`
+}
+
+// ModelEval is a score of a model on a benchmark.
+type ModelEval struct {
+	Benchmark string  `json:"benchmark"`
+	Metric    string  `json:"metric,omitempty"`
+	Score     float64 `json:"score"`
+}
+
+// ModelSource is a checkpoint a model was made from, identified by the
+// SHA-256 digest of its file, such as "sha256:2b5e33...".
+type ModelSource struct {
+	Name   string `json:"name,omitempty"`
+	URL    string `json:"url,omitempty"`
+	Digest string `json:"digest"`
+}
+
+// ModelCapabilities describes what a model supports, as derived from its
+// metadata and template.
+type ModelCapabilities struct {
+	// Completion is set if the model generates text, and Embedding if it
+	// produces embeddings instead
+	Completion bool `json:"completion"`
+	Embedding  bool `json:"embedding"`
+
+	// Rerank is set if the model scores documents against a query
+	Rerank bool `json:"rerank"`
+
+	// Tools is set if the model's template renders tools
+	Tools bool `json:"tools"`
+
+	// Insert is set if the model's template fills in the middle between a
+	// prompt and a suffix
+	Insert bool `json:"insert"`
+
+	// Vision is set if the model's projector encodes images
+	Vision bool `json:"vision"`
+
+	// Thinking is set if the model reasons in thinking tags before it
+	// answers
+	Thinking bool `json:"thinking"`
+
+	// ContextLength is the longest context the model was trained on
+	ContextLength int `json:"context_length,omitempty"`
+
+	// ImageTokens is the number of tokens of the context an image takes,
+	// for vision models
+	ImageTokens int `json:"image_tokens,omitempty"`
+}
+
+// LicenseAcceptance is whether the license of a model has been accepted.
+type LicenseAcceptance struct {
+	Accepted   bool      `json:"accepted"`
+	AcceptedAt time.Time `json:"accepted_at,omitempty"`
+}
+
+// AcceptLicenseRequest is the request passed to [Client.AcceptLicense].
+type AcceptLicenseRequest struct {
+	Model string `json:"model"`
+}
+
+// Tensor describes a single tensor in a model. Tensors are only included in
+// a [ShowResponse] if [ShowRequest.Tensors] is set.
+type Tensor struct {
+	Name  string   `json:"name"`
+	Type  string   `json:"type"`
+	Shape []uint64 `json:"shape"`
+
+	// Digest is the sha256 digest of the tensor data, if requested
+	Digest string `json:"digest,omitempty"`
 }
 
 // CopyRequest is the request passed to [Client.Copy].
@@ -383,6 +1477,14 @@ type ProgressResponse struct {
 	Digest    string `json:"digest,omitempty"`
 	Total     int64  `json:"total,omitempty"`
 	Completed int64  `json:"completed,omitempty"`
+
+	// Stage, Tensor, Percent and Remaining are set during long running
+	// steps of a create such as converting and quantizing. Total and
+	// Completed are the estimated and written bytes of the output.
+	Stage     string        `json:"stage,omitempty"`
+	Tensor    string        `json:"tensor,omitempty"`
+	Percent   float64       `json:"percent,omitempty"`
+	Remaining time.Duration `json:"remaining,omitempty"`
 }
 
 // PushRequest is the request passed to [Client.Push].
@@ -407,6 +1509,349 @@ type ProcessResponse struct {
 	Models []ProcessModelResponse `json:"models"`
 }
 
+// PromptCacheResponse is the response from [Client.PromptCache]. It reports
+// how often the server reused the token counts of chat prompts it has seen.
+type PromptCacheResponse struct {
+	// Entries is the number of prompts cached and Size the most kept.
+	Entries int `json:"entries"`
+	Size    int `json:"size"`
+
+	Hits      int `json:"hits"`
+	Misses    int `json:"misses"`
+	Evictions int `json:"evictions"`
+
+	// TokensReused is the number of tokens that didn't have to be
+	// tokenized again.
+	TokensReused int `json:"tokens_reused"`
+}
+
+// Chaos is the set of faults injected into models by servers built with the
+// chaos build tag, for testing how clients handle failures. It's passed to
+// and returned by [Client.SetChaos] and [Client.Chaos].
+type Chaos struct {
+	Faults []ChaosFault `json:"faults"`
+}
+
+// ChaosFault is a fault injected into a model, or into every model if Model
+// is empty.
+type ChaosFault struct {
+	Model string `json:"model,omitempty"`
+
+	// LoadDelay is how much longer loading the model takes. Load faults
+	// apply to the next load of the model.
+	LoadDelay *Duration `json:"load_delay,omitempty"`
+
+	// OutOfMemory fails loading the model as if it ran out of memory.
+	OutOfMemory bool `json:"out_of_memory,omitempty"`
+
+	// CrashAfterTokens crashes the model's runner after it generates this
+	// many tokens of a response.
+	CrashAfterTokens int `json:"crash_after_tokens,omitempty"`
+}
+
+// RecommendRequest is the request passed to [Client.Recommend].
+type RecommendRequest struct {
+	// Capability is the kind of model to recommend: "chat", "code", "vision"
+	// or "embedding". Defaults to "chat".
+	Capability string `json:"capability,omitempty"`
+}
+
+// RecommendResponse is the response from [Client.Recommend].
+type RecommendResponse struct {
+	// Memory is the total system memory in bytes
+	Memory uint64 `json:"memory"`
+
+	// VRAM is the GPU memory in bytes available to a single model
+	VRAM uint64 `json:"vram"`
+
+	// Threads is the number of CPU threads
+	Threads int `json:"threads"`
+
+	// Models are ordered from most to least recommended
+	Models []Recommendation `json:"models"`
+}
+
+// Recommendation is a single model suggested in [RecommendResponse].
+type Recommendation struct {
+	Model         string `json:"model"`
+	Quantization  string `json:"quantization"`
+	ParameterSize string `json:"parameter_size"`
+
+	// Size is the estimated memory required to run the model in bytes
+	Size uint64 `json:"size"`
+
+	// Processor is where the model is expected to run: "gpu", "gpu/cpu"
+	// when split between GPU and system memory, or "cpu"
+	Processor string `json:"processor"`
+}
+
+// PlanRequest is the request passed to [Client.Plan].
+type PlanRequest struct {
+	// Models are the models to serve and the requests expected for each.
+	Models []PlanModel `json:"models"`
+
+	// Duration is the length of the simulated traffic. Defaults to 1 hour.
+	Duration *Duration `json:"duration,omitempty"`
+}
+
+// PlanModel is a model in [PlanRequest] with its expected requests.
+type PlanModel struct {
+	Model string `json:"model"`
+
+	// RequestsPerMinute is the rate requests for the model arrive at.
+	RequestsPerMinute float64 `json:"requests_per_minute"`
+
+	// RequestDuration is how long each request takes once it's running.
+	// Defaults to 10 seconds.
+	RequestDuration *Duration `json:"request_duration,omitempty"`
+
+	// KeepAlive is how long the model stays loaded after its last request,
+	// as in [GenerateRequest]. Defaults to the server's keep alive.
+	KeepAlive *Duration `json:"keep_alive,omitempty"`
+
+	// Options lists model-specific options, such as num_ctx, that affect
+	// the memory the model needs.
+	Options map[string]interface{} `json:"options,omitempty"`
+}
+
+// PlanResponse is the response from [Client.Plan].
+type PlanResponse struct {
+	// VRAM is the memory in bytes models can be loaded into, on the GPUs
+	// or in system memory when there are none.
+	VRAM uint64 `json:"vram"`
+
+	// PeakVRAM is the most memory used by loaded models at once and
+	// VRAMUtilization the average fraction of VRAM used over the
+	// simulation.
+	PeakVRAM        uint64  `json:"peak_vram"`
+	VRAMUtilization float64 `json:"vram_utilization"`
+
+	Models []PlanModelResponse `json:"models"`
+}
+
+// PlanModelResponse is the simulated placement and queueing of a model in
+// [PlanResponse].
+type PlanModelResponse struct {
+	Model string `json:"model"`
+
+	// Size is the estimated memory the model needs in bytes and SizeVRAM
+	// how much of it is loaded into VRAM.
+	Size     uint64 `json:"size"`
+	SizeVRAM uint64 `json:"size_vram"`
+
+	// Processor is where the model runs: "gpu", "gpu/cpu" when split
+	// between GPU and system memory, or "cpu".
+	Processor string `json:"processor"`
+
+	Requests int `json:"requests"`
+
+	// Loads is the number of times the model was loaded and Evictions the
+	// number of times it was unloaded to make room for another model.
+	Loads     int `json:"loads"`
+	Evictions int `json:"evictions"`
+
+	// QueueTime is the average time requests waited to start running,
+	// including loading the model, and MaxQueueTime the longest.
+	QueueTime    time.Duration `json:"queue_time"`
+	MaxQueueTime time.Duration `json:"max_queue_time"`
+}
+
+// AgentRequest is the request passed to [Client.Agent].
+type AgentRequest struct {
+	// Model is the model that runs the agent.
+	Model string `json:"model"`
+
+	// Messages is the chat the agent continues, as in [ChatRequest].
+	Messages []Message `json:"messages"`
+
+	// Tools are the names of the server's registered tools the model can
+	// call. All registered tools are available if it's empty.
+	Tools []string `json:"tools,omitempty"`
+
+	// MaxSteps is the largest number of model turns. Defaults to 10.
+	MaxSteps int `json:"max_steps,omitempty"`
+
+	// MaxTokens is the largest number of tokens the model can generate over
+	// all of its turns. Zero is unlimited.
+	MaxTokens int `json:"max_tokens,omitempty"`
+
+	// Timeout is the longest the agent can run. Zero is unlimited.
+	Timeout *Duration `json:"timeout,omitempty"`
+
+	// Stream enables streaming of each step; true by default.
+	Stream *bool `json:"stream,omitempty"`
+
+	// KeepAlive controls how long the model will stay loaded into memory
+	// following the request.
+	KeepAlive *Duration `json:"keep_alive,omitempty"`
+
+	// Options lists model-specific options.
+	Options map[string]any `json:"options,omitempty"`
+}
+
+// AgentEvent is a single step of an agent run returned by [Client.Agent].
+type AgentEvent struct {
+	Model     string    `json:"model"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// Type is "message" for the model's messages, "tool" for the results of
+	// its tool calls and "done" once the agent stops.
+	Type string `json:"type"`
+
+	// Step is the model turn the event belongs to, starting from 1.
+	Step int `json:"step"`
+
+	Message *Message `json:"message,omitempty"`
+
+	// DoneReason is why the agent stopped: "stop" if the model answered
+	// without calling a tool, or "max_steps", "max_tokens" or "timeout" if it
+	// ran out of its budget.
+	DoneReason string `json:"done_reason,omitempty"`
+
+	Done bool `json:"done"`
+
+	// Messages are the messages added by the agent. They're only set on the
+	// final event.
+	Messages []Message `json:"messages,omitempty"`
+
+	Metrics
+}
+
+// AgentEventFunc is a function that [Client.Agent] invokes for each step of
+// an agent run. If this function returns an error, [Client.Agent] will stop
+// and return this error.
+type AgentEventFunc func(AgentEvent) error
+
+// EnsembleRequest is the request passed to [Client.Ensemble].
+type EnsembleRequest struct {
+	// Models are the models that answer the messages. A model can be listed
+	// more than once.
+	Models []string `json:"models"`
+
+	// Samples is the number of answers from each model. Defaults to 1.
+	Samples int `json:"samples,omitempty"`
+
+	// Judge is the model that scores the answers.
+	Judge string `json:"judge"`
+
+	// Merge asks the judge to combine the answers into one, rather than
+	// select the best.
+	Merge bool `json:"merge,omitempty"`
+
+	// Messages is the chat the models answer, as in [ChatRequest].
+	Messages []Message `json:"messages"`
+
+	// KeepAlive controls how long the models stay loaded into memory
+	// following the request.
+	KeepAlive *Duration `json:"keep_alive,omitempty"`
+
+	// Options lists model-specific options for the models that answer. They
+	// don't apply to the judge.
+	Options map[string]any `json:"options,omitempty"`
+}
+
+// EnsembleResponse is the response from [Client.Ensemble].
+type EnsembleResponse struct {
+	Judge     string    `json:"judge"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// Message is the best answer, or the merged answer if the request set
+	// Merge.
+	Message Message `json:"message"`
+
+	// Winner is the index of the best scoring candidate.
+	Winner int `json:"winner"`
+
+	// Candidates are the answers in the order of the request's models, with
+	// each model's samples together.
+	Candidates []EnsembleCandidate `json:"candidates"`
+
+	TotalDuration time.Duration `json:"total_duration,omitempty"`
+}
+
+// EnsembleCandidate is a single answer in [EnsembleResponse].
+type EnsembleCandidate struct {
+	Model   string  `json:"model"`
+	Message Message `json:"message"`
+
+	// Score is the judge's score for the answer, from 0 to 10.
+	Score float64 `json:"score"`
+
+	// Error is set if the model failed to answer.
+	Error string `json:"error,omitempty"`
+}
+
+// BatchRequest is the request passed to [Client.Batch]. It runs many chat
+// and generate requests with one model, with as many at once as the model's
+// runner has parallel slots for.
+type BatchRequest struct {
+	// Model is the model that runs the requests.
+	Model string `json:"model"`
+
+	// Requests are the requests to run.
+	Requests []BatchItem `json:"requests"`
+
+	// KeepAlive controls how long the model stays loaded into memory
+	// following the batch.
+	KeepAlive *Duration `json:"keep_alive,omitempty"`
+
+	// Options lists model-specific options for every request, which the
+	// options of each request override.
+	Options map[string]any `json:"options,omitempty"`
+}
+
+// BatchItem is a single request in [BatchRequest]. It's a chat request if it
+// has Messages, or a generate request if it has a Prompt.
+type BatchItem struct {
+	// ID is an optional label returned with the result of the request.
+	ID string `json:"id,omitempty"`
+
+	// Messages are the messages of a chat request.
+	Messages []Message `json:"messages,omitempty"`
+
+	// Prompt and System are the prompt and system message of a generate
+	// request.
+	Prompt string `json:"prompt,omitempty"`
+	System string `json:"system,omitempty"`
+
+	// Format is the format to return the response in, as in [ChatRequest].
+	Format json.RawMessage `json:"format,omitempty"`
+
+	// Options lists model-specific options for the request.
+	Options map[string]any `json:"options,omitempty"`
+}
+
+// BatchResponse is the result of a single request in a batch, which
+// [Client.Batch] passes to its function as each request completes.
+type BatchResponse struct {
+	// Index is the position of the request in [BatchRequest.Requests].
+	Index int `json:"index"`
+
+	// ID is the ID of the request.
+	ID string `json:"id,omitempty"`
+
+	Model     string    `json:"model"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// Message is the response to a chat request.
+	Message *Message `json:"message,omitempty"`
+
+	// Response is the response to a generate request.
+	Response string `json:"response,omitempty"`
+
+	// DoneReason is why the response ended.
+	DoneReason string `json:"done_reason,omitempty"`
+
+	// Error is set if the request failed.
+	Error string `json:"error,omitempty"`
+
+	Metrics
+}
+
+// BatchResponseFunc is a function that [Client.Batch] invokes with the
+// result of each request.
+type BatchResponseFunc func(BatchResponse) error
+
 // ListModelResponse is a single model description in [ListResponse].
 type ListModelResponse struct {
 	Name       string       `json:"name"`
@@ -426,6 +1871,27 @@ type ProcessModelResponse struct {
 	Details   ModelDetails `json:"details,omitempty"`
 	ExpiresAt time.Time    `json:"expires_at"`
 	SizeVRAM  int64        `json:"size_vram"`
+
+	// Advisories warn that the model's quantization is slow on the hardware
+	// it's loaded on
+	Advisories []Advisory `json:"advisories,omitempty"`
+
+	// Swap is set while the model is replaced by a new version of it, such
+	// as after it's created again: "draining" while the old version finishes
+	// the requests it has, and "loading" while the new version loads beside
+	// it
+	Swap string `json:"swap,omitempty"`
+
+	// Placement is the part of the model on each GPU it's loaded on
+	Placement []GPUPlacement `json:"placement,omitempty"`
+}
+
+// GPUPlacement is the part of a model loaded on a GPU
+type GPUPlacement struct {
+	ID       string `json:"id"`
+	Name     string `json:"name,omitempty"`
+	Layers   int    `json:"layers"`
+	SizeVRAM int64  `json:"size_vram"`
 }
 
 type RetrieveModelResponse struct {
@@ -460,6 +1926,14 @@ type GenerateResponse struct {
 	// can be sent in the next request to keep a conversational memory.
 	Context []int `json:"context,omitempty"`
 
+	// Logprobs are the log probabilities of the tokens of Response, if the
+	// request asked for them.
+	Logprobs []Logprob `json:"logprobs,omitempty"`
+
+	// Metadata is the request's metadata. It's only set on the final
+	// response.
+	Metadata map[string]string `json:"metadata,omitempty"`
+
 	Metrics
 }
 
@@ -499,6 +1973,17 @@ func (m *Metrics) Summary() {
 		fmt.Fprintf(os.Stderr, "eval duration:        %s\n", m.EvalDuration)
 		fmt.Fprintf(os.Stderr, "eval rate:            %.2f tokens/s\n", float64(m.EvalCount)/m.EvalDuration.Seconds())
 	}
+
+	if m.Degraded != nil {
+		fmt.Fprintf(os.Stderr, "degraded:             num_gpu=%d num_batch=%d\n", m.Degraded.NumGPU, m.Degraded.NumBatch)
+	}
+
+	for _, a := range m.Advisories {
+		fmt.Fprintf(os.Stderr, "advisory:             %s\n", a.Message)
+		if a.Suggestion != "" {
+			fmt.Fprintf(os.Stderr, "                      try %s\n", a.Suggestion)
+		}
+	}
 }
 
 func (opts *Options) FromMap(m map[string]interface{}) error {
@@ -231,3 +231,32 @@ func TestMessage_UnmarshalJSON(t *testing.T) {
 		}
 	}
 }
+
+func TestToolChoiceMarshalUnmarshal(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected ToolChoice
+	}{
+		{`"auto"`, ToolChoice{Type: "auto"}},
+		{`"required"`, ToolChoice{Type: "required"}},
+		{`{"type":"function","function":{"name":"get_weather"}}`, ToolChoice{Type: "function", Function: &ToolChoiceFunction{Name: "get_weather"}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			var tc ToolChoice
+			if err := json.Unmarshal([]byte(test.input), &tc); err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, test.expected, tc)
+
+			b, err := json.Marshal(tc)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, test.input, string(b))
+		})
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"maps"
 	"math"
 	"net"
 	"net/http"
@@ -38,6 +39,7 @@ import (
 	"github.com/ollama/ollama/llama/runner"
 	"github.com/ollama/ollama/parser"
 	"github.com/ollama/ollama/progress"
+	"github.com/ollama/ollama/readline"
 	"github.com/ollama/ollama/server"
 	"github.com/ollama/ollama/types/model"
 	"github.com/ollama/ollama/version"
@@ -112,31 +114,34 @@ func CreateHandler(cmd *cobra.Command, args []string) error {
 		req.Quantize = quantize
 	}
 
+	req.RequireLicenseAcceptance, _ = cmd.Flags().GetBool("require-license-acceptance")
+
 	client, err := api.ClientFromEnvironment()
 	if err != nil {
 		return err
 	}
 
-	if len(req.Files) > 0 {
-		fileMap := map[string]string{}
-		for f, digest := range req.Files {
-			if _, err := createBlob(cmd, client, f, digest, p); err != nil {
-				return err
-			}
-			fileMap[filepath.Base(f)] = digest
+	if len(req.Files) > 0 || len(req.Adapters) > 0 {
+		files := maps.Clone(req.Files)
+		if files == nil {
+			files = make(map[string]string)
 		}
-		req.Files = fileMap
-	}
+		maps.Copy(files, req.Adapters)
 
-	if len(req.Adapters) > 0 {
-		fileMap := map[string]string{}
-		for f, digest := range req.Adapters {
-			if _, err := createBlob(cmd, client, f, digest, p); err != nil {
-				return err
+		req.Upload, err = uploadBlobs(cmd, client, files, p)
+		var se api.StatusError
+		if errors.As(err, &se) && se.StatusCode == http.StatusNotFound && req.Upload == "" {
+			// the server doesn't support upload sessions
+			for f, digest := range files {
+				if _, err := createBlob(cmd, client, f, digest, p); err != nil {
+					return err
+				}
 			}
-			fileMap[filepath.Base(f)] = digest
+		} else if err != nil {
+			return err
 		}
-		req.Adapters = fileMap
+
+		req.Files, req.Adapters = baseNames(req.Files), baseNames(req.Adapters)
 	}
 
 	bars := make(map[string]*progress.Bar)
@@ -149,6 +154,17 @@ func CreateHandler(cmd *cobra.Command, args []string) error {
 				p.Add(resp.Digest, bar)
 			}
 
+			bar.Set(resp.Completed)
+		} else if resp.Stage != "" && resp.Total > 0 {
+			key := resp.Stage + resp.Status
+			bar, ok := bars[key]
+			if !ok {
+				spinner.Stop()
+				bar = progress.NewBar(resp.Status, resp.Total, resp.Completed)
+				bars[key] = bar
+				p.Add(key, bar)
+			}
+
 			bar.Set(resp.Completed)
 		} else if status != resp.Status {
 			spinner.Stop()
@@ -216,6 +232,126 @@ func createBlob(cmd *cobra.Command, client *api.Client, path string, digest stri
 	return digest, nil
 }
 
+// baseNames returns files, a map of paths to digests, keyed by file name
+func baseNames(files map[string]string) map[string]string {
+	if len(files) == 0 {
+		return files
+	}
+
+	m := make(map[string]string, len(files))
+	for f, digest := range files {
+		m[filepath.Base(f)] = digest
+	}
+	return m
+}
+
+// uploadChunkSize is the size of each chunk sent in an upload session
+const uploadChunkSize = 64 << 20
+
+// uploadBlobs uploads files, a map of paths to digests, in a resumable upload
+// session and returns the session ID. Interrupted chunks are retried from
+// the offset the server last received.
+func uploadBlobs(cmd *cobra.Command, client *api.Client, files map[string]string, p *progress.Progress) (string, error) {
+	paths := make(map[string]string, len(files))
+	var blobs []api.UploadBlob
+	for f, digest := range files {
+		realPath, err := filepath.EvalSymlinks(f)
+		if err != nil {
+			return "", err
+		}
+
+		fi, err := os.Stat(realPath)
+		if err != nil {
+			return "", err
+		}
+
+		paths[digest] = realPath
+		blobs = append(blobs, api.UploadBlob{Digest: digest, Size: fi.Size()})
+	}
+
+	session, err := client.CreateUpload(cmd.Context(), &api.UploadRequest{Blobs: blobs})
+	if err != nil {
+		return "", err
+	}
+
+	for _, blob := range session.Blobs {
+		if blob.Offset == blob.Size {
+			continue
+		}
+
+		bar := progress.NewBar(fmt.Sprintf("copying file %s...", blob.Digest[7:19]), blob.Size, blob.Offset)
+		p.Add(blob.Digest, bar)
+
+		if err := uploadBlob(cmd, client, session.ID, paths[blob.Digest], blob, bar); err != nil {
+			return session.ID, err
+		}
+	}
+
+	return session.ID, nil
+}
+
+func uploadBlob(cmd *cobra.Command, client *api.Client, id, path string, blob api.UploadBlob, bar *progress.Bar) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	const maxRetries = 5
+	offset, retries := blob.Offset, 0
+	for offset < blob.Size {
+		var pw progressWriter
+		chunk := io.NewSectionReader(f, offset, min(uploadChunkSize, blob.Size-offset))
+		done := make(chan struct{})
+		go func() {
+			ticker := time.NewTicker(60 * time.Millisecond)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					bar.Set(offset + pw.n.Load())
+				case <-done:
+					return
+				}
+			}
+		}()
+
+		resp, err := client.UploadChunk(cmd.Context(), id, blob.Digest, offset, io.TeeReader(chunk, &pw))
+		close(done)
+		if err != nil {
+			var se api.StatusError
+			if errors.As(err, &se) && se.StatusCode < http.StatusInternalServerError && se.StatusCode != http.StatusConflict {
+				return err
+			}
+
+			if errors.Is(err, context.Canceled) || retries >= maxRetries {
+				return err
+			}
+			retries++
+
+			// resume from whatever the server received
+			session, serr := client.Upload(cmd.Context(), id)
+			if serr != nil {
+				return err
+			}
+
+			for _, b := range session.Blobs {
+				if b.Digest == blob.Digest {
+					offset = b.Offset
+				}
+			}
+
+			time.Sleep(time.Duration(retries) * time.Second)
+			continue
+		}
+
+		offset, retries = resp.Offset, 0
+		bar.Set(offset)
+	}
+
+	return nil
+}
+
 type progressWriter struct {
 	n atomic.Int64
 }
@@ -336,6 +472,12 @@ func RunHandler(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	if info.LicenseAcceptance != nil && !info.LicenseAcceptance.Accepted {
+		if err := acceptLicense(cmd, client, name, info.License); err != nil {
+			return err
+		}
+	}
+
 	opts.MultiModal = len(info.ProjectorInfo) != 0
 	opts.ParentModel = info.Details.ParentModel
 
@@ -656,6 +798,28 @@ func showInfo(resp *api.ShowResponse, w io.Writer) error {
 		return
 	})
 
+	if c := resp.Capabilities; c != nil {
+		tableRender("Capabilities", func() (rows [][]string) {
+			for _, capability := range []struct {
+				name      string
+				supported bool
+			}{
+				{"completion", c.Completion},
+				{"embedding", c.Embedding},
+				{"rerank", c.Rerank},
+				{"tools", c.Tools},
+				{"insert", c.Insert},
+				{"vision", c.Vision},
+				{"thinking", c.Thinking},
+			} {
+				if capability.supported {
+					rows = append(rows, []string{"", capability.name})
+				}
+			}
+			return
+		})
+	}
+
 	if resp.ProjectorInfo != nil {
 		tableRender("Projector", func() (rows [][]string) {
 			arch := resp.ProjectorInfo["general.architecture"].(string)
@@ -704,6 +868,79 @@ func showInfo(resp *api.ShowResponse, w io.Writer) error {
 	return nil
 }
 
+func LicenseHandler(cmd *cobra.Command, args []string) error {
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	accept, err := cmd.Flags().GetBool("accept")
+	if err != nil {
+		return err
+	}
+
+	info, err := client.Show(cmd.Context(), &api.ShowRequest{Name: args[0]})
+	if err != nil {
+		return err
+	}
+
+	if info.License == "" {
+		return fmt.Errorf("%s doesn't have a license", args[0])
+	}
+
+	fmt.Println(info.License)
+	fmt.Println()
+
+	acceptance := info.LicenseAcceptance
+	if accept && (acceptance == nil || !acceptance.Accepted) {
+		acceptance, err = client.AcceptLicense(cmd.Context(), &api.AcceptLicenseRequest{Model: args[0]})
+		if err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case acceptance == nil:
+		fmt.Printf("%s doesn't require its license to be accepted\n", args[0])
+	case acceptance.Accepted:
+		fmt.Printf("license accepted on %s\n", acceptance.AcceptedAt.Local().Format(time.DateTime))
+	default:
+		fmt.Printf("license not accepted, run 'ollama license --accept %s' to accept it\n", args[0])
+	}
+
+	return nil
+}
+
+// acceptLicense asks to accept the license of a model that requires it
+// before it's run, and records the acceptance
+func acceptLicense(cmd *cobra.Command, client *api.Client, name, license string) error {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return fmt.Errorf("%s requires its license to be accepted, run 'ollama license --accept %s' to accept it", name, name)
+	}
+
+	if !confirmLicense(os.Stdin, os.Stdout, name, license) {
+		return fmt.Errorf("%s can't be run without accepting its license", name)
+	}
+
+	_, err := client.AcceptLicense(cmd.Context(), &api.AcceptLicenseRequest{Model: name})
+	return err
+}
+
+// confirmLicense shows license and reports whether it's accepted
+func confirmLicense(r io.Reader, w io.Writer, name, license string) bool {
+	fmt.Fprintln(w, license)
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "%s requires its license to be accepted before it's run. Accept it? [y/N] ", name)
+
+	answer, _ := bufio.NewReader(r).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	default:
+		return false
+	}
+}
+
 func CopyHandler(cmd *cobra.Command, args []string) error {
 	client, err := api.ClientFromEnvironment()
 	if err != nil {
@@ -868,16 +1105,32 @@ func chat(cmd *cobra.Command, opts runOptions) (*api.Message, error) {
 	var latest api.ChatResponse
 	var fullResponse strings.Builder
 	var role string
+	var thinking bool
 
 	fn := func(response api.ChatResponse) error {
 		p.StopAndClear()
 
 		latest = response
 
+		// reasoning is shown in grey before the answer
+		if response.Message.Thinking != "" {
+			if !thinking {
+				fmt.Print(readline.ColorGrey)
+				thinking = true
+			}
+			displayResponse(response.Message.Thinking, opts.WordWrap, state)
+		}
+
 		role = response.Message.Role
 		content := response.Message.Content
 		fullResponse.WriteString(content)
 
+		if thinking && (content != "" || response.Done) {
+			fmt.Print(readline.ColorDefault + "\n\n")
+			state = &displayResponseState{}
+			thinking = false
+		}
+
 		displayResponse(content, opts.WordWrap, state)
 
 		return nil
@@ -1023,12 +1276,17 @@ func RunServer(_ *cobra.Command, _ []string) error {
 		return err
 	}
 
-	ln, err := net.Listen("tcp", envconfig.Host().Host)
-	if err != nil {
-		return err
+	var lns []net.Listener
+	for _, l := range envconfig.Listeners() {
+		ln, err := server.Listen(l)
+		if err != nil {
+			return err
+		}
+
+		lns = append(lns, ln)
 	}
 
-	err = server.Serve(ln)
+	err := server.Serve(lns...)
 	if errors.Is(err, http.ErrServerClosed) {
 		return nil
 	}
@@ -1171,6 +1429,7 @@ func NewCLI() *cobra.Command {
 
 	createCmd.Flags().StringP("file", "f", "", "Name of the Modelfile (default \"Modelfile\"")
 	createCmd.Flags().StringP("quantize", "q", "", "Quantize model to this level (e.g. q4_0)")
+	createCmd.Flags().Bool("require-license-acceptance", false, "Require the license to be accepted before the model is run")
 
 	showCmd := &cobra.Command{
 		Use:     "show MODEL",
@@ -1186,6 +1445,16 @@ func NewCLI() *cobra.Command {
 	showCmd.Flags().Bool("template", false, "Show template of a model")
 	showCmd.Flags().Bool("system", false, "Show system message of a model")
 
+	diffCmd := &cobra.Command{
+		Use:     "diff MODEL MODEL",
+		Short:   "Compare the metadata and tensors of two models",
+		Args:    cobra.ExactArgs(2),
+		PreRunE: checkServerHeartbeat,
+		RunE:    DiffHandler,
+	}
+
+	diffCmd.Flags().Bool("checksums", false, "Compare tensor checksums (reads the full model)")
+
 	runCmd := &cobra.Command{
 		Use:     "run MODEL [PROMPT]",
 		Short:   "Run a model",
@@ -1200,6 +1469,16 @@ func NewCLI() *cobra.Command {
 	runCmd.Flags().Bool("nowordwrap", false, "Don't wrap words to the next line automatically")
 	runCmd.Flags().String("format", "", "Response format (e.g. json)")
 
+	licenseCmd := &cobra.Command{
+		Use:     "license MODEL",
+		Short:   "Show or accept the license of a model",
+		Args:    cobra.ExactArgs(1),
+		PreRunE: checkServerHeartbeat,
+		RunE:    LicenseHandler,
+	}
+
+	licenseCmd.Flags().Bool("accept", false, "Accept the license")
+
 	stopCmd := &cobra.Command{
 		Use:     "stop MODEL",
 		Short:   "Stop a running model",
@@ -1287,6 +1566,8 @@ func NewCLI() *cobra.Command {
 	for _, cmd := range []*cobra.Command{
 		createCmd,
 		showCmd,
+		licenseCmd,
+		diffCmd,
 		runCmd,
 		stopCmd,
 		pullCmd,
@@ -1307,7 +1588,14 @@ func NewCLI() *cobra.Command {
 				envVars["OLLAMA_KEEP_ALIVE"],
 				envVars["OLLAMA_MAX_LOADED_MODELS"],
 				envVars["OLLAMA_MAX_QUEUE"],
+				envVars["OLLAMA_MAX_IMAGES"],
 				envVars["OLLAMA_MODELS"],
+				envVars["OLLAMA_SHARED_BLOBS"],
+				envVars["OLLAMA_PRELOAD"],
+				envVars["OLLAMA_AGENT_TOOLS"],
+				envVars["OLLAMA_HTTP2"],
+				envVars["OLLAMA_FLUSH_INTERVAL"],
+				envVars["OLLAMA_WRITE_TIMEOUT"],
 				envVars["OLLAMA_NUM_PARALLEL"],
 				envVars["OLLAMA_NOPRUNE"],
 				envVars["OLLAMA_ORIGINS"],
@@ -1317,7 +1605,16 @@ func NewCLI() *cobra.Command {
 				envVars["OLLAMA_KV_CACHE_TYPE"],
 				envVars["OLLAMA_LLM_LIBRARY"],
 				envVars["OLLAMA_GPU_OVERHEAD"],
+				envVars["OLLAMA_GPU_RESERVE"],
 				envVars["OLLAMA_LOAD_TIMEOUT"],
+				envVars["OLLAMA_TRANSFER_LIMIT"],
+				envVars["OLLAMA_TTFT_TARGET"],
+				envVars["OLLAMA_CAPABILITY_DEVICES"],
+				envVars["OLLAMA_REQUEST_LIMITS"],
+				envVars["OLLAMA_MEMORY_PRESSURE"],
+				envVars["OLLAMA_GPU_YIELD"],
+				envVars["OLLAMA_REGISTRY_CONFIG"],
+				envVars["OLLAMA_REGISTRY_CACHE"],
 			})
 		default:
 			appendEnvDocs(cmd, envs)
@@ -1328,6 +1625,8 @@ func NewCLI() *cobra.Command {
 		serveCmd,
 		createCmd,
 		showCmd,
+		licenseCmd,
+		diffCmd,
 		runCmd,
 		stopCmd,
 		pullCmd,
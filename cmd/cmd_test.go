@@ -67,6 +67,35 @@ func TestShowInfo(t *testing.T) {
     embedding length    0       
     quantization        FP16    
 
+`
+		if diff := cmp.Diff(expect, b.String()); diff != "" {
+			t.Errorf("unexpected output (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("capabilities", func(t *testing.T) {
+		var b bytes.Buffer
+		if err := showInfo(&api.ShowResponse{
+			Details: api.ModelDetails{
+				Family:            "test",
+				ParameterSize:     "7B",
+				QuantizationLevel: "FP16",
+			},
+			Capabilities: &api.ModelCapabilities{Completion: true, Tools: true, Vision: true},
+		}, &b); err != nil {
+			t.Fatal(err)
+		}
+
+		expect := `  Model
+    architecture    test    
+    parameters      7B      
+    quantization    FP16    
+
+  Capabilities
+    completion    
+    tools         
+    vision        
+
 `
 		if diff := cmp.Diff(expect, b.String()); diff != "" {
 			t.Errorf("unexpected output (-want +got):\n%s", diff)
@@ -489,3 +518,29 @@ func TestPushHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestConfirmLicense(t *testing.T) {
+	cases := []struct {
+		answer string
+		want   bool
+	}{
+		{"y\n", true},
+		{"Yes\n", true},
+		{"n\n", false},
+		{"\n", false},
+		{"", false},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.answer, func(t *testing.T) {
+			var b bytes.Buffer
+			if got := confirmLicense(strings.NewReader(tt.answer), &b, "test", "MIT"); got != tt.want {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+
+			if !strings.HasPrefix(b.String(), "MIT\n\ntest requires its license to be accepted") {
+				t.Errorf("unexpected output %q", b.String())
+			}
+		})
+	}
+}
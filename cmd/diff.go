@@ -0,0 +1,172 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ollama/ollama/api"
+)
+
+func DiffHandler(cmd *cobra.Command, args []string) error {
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	checksums, err := cmd.Flags().GetBool("checksums")
+	if err != nil {
+		return err
+	}
+
+	var resps [2]*api.ShowResponse
+	for i, name := range args {
+		resps[i], err = client.Show(cmd.Context(), &api.ShowRequest{Model: name, Verbose: true, Tensors: true, Checksums: checksums})
+		if err != nil {
+			return err
+		}
+	}
+
+	if n := diffModels(os.Stdout, resps[0], resps[1]); n == 0 {
+		fmt.Println("no differences")
+	}
+
+	return nil
+}
+
+// diffModels writes the differences between models a and b to w and returns
+// the number of differences found
+func diffModels(w io.Writer, a, b *api.ShowResponse) int {
+	var n int
+	section := func(title string, lines []string) {
+		if len(lines) == 0 {
+			return
+		}
+
+		fmt.Fprintf(w, "  %s\n", title)
+		for _, line := range lines {
+			fmt.Fprintf(w, "    %s\n", line)
+		}
+		fmt.Fprintln(w)
+		n += len(lines)
+	}
+
+	var details []string
+	for _, v := range []struct {
+		name string
+		a, b string
+	}{
+		{"format", a.Details.Format, b.Details.Format},
+		{"family", a.Details.Family, b.Details.Family},
+		{"parameters", a.Details.ParameterSize, b.Details.ParameterSize},
+		{"quantization", a.Details.QuantizationLevel, b.Details.QuantizationLevel},
+	} {
+		if v.a != v.b {
+			details = append(details, changed(v.name, v.a, v.b))
+		}
+	}
+	section("Details", details)
+
+	var rope, tokenizer, metadata []string
+	for _, k := range slices.Sorted(maps.Keys(merge(a.ModelInfo, b.ModelInfo))) {
+		va, oka := a.ModelInfo[k]
+		vb, okb := b.ModelInfo[k]
+
+		var line string
+		switch {
+		case !oka:
+			line = "+ " + k + " = " + formatValue(vb)
+		case !okb:
+			line = "- " + k + " = " + formatValue(va)
+		case !reflect.DeepEqual(va, vb):
+			line = changed(k, formatValue(va), formatValue(vb))
+		default:
+			continue
+		}
+
+		switch {
+		case strings.Contains(k, ".rope."):
+			rope = append(rope, line)
+		case strings.HasPrefix(k, "tokenizer."):
+			tokenizer = append(tokenizer, line)
+		default:
+			metadata = append(metadata, line)
+		}
+	}
+	section("RoPE", rope)
+	section("Tokenizer", tokenizer)
+	section("Metadata", metadata)
+
+	ta := make(map[string]api.Tensor, len(a.Tensors))
+	for _, t := range a.Tensors {
+		ta[t.Name] = t
+	}
+
+	var tensors []string
+	for _, t := range b.Tensors {
+		prev, ok := ta[t.Name]
+		delete(ta, t.Name)
+		switch {
+		case !ok:
+			tensors = append(tensors, fmt.Sprintf("+ %s %s %v", t.Name, t.Type, t.Shape))
+		case prev.Type != t.Type:
+			tensors = append(tensors, changed(t.Name, prev.Type, t.Type))
+		case !slices.Equal(prev.Shape, t.Shape):
+			tensors = append(tensors, changed(t.Name, fmt.Sprint(prev.Shape), fmt.Sprint(t.Shape)))
+		case prev.Digest != t.Digest:
+			tensors = append(tensors, changed(t.Name, prev.Digest, t.Digest))
+		}
+	}
+
+	for _, t := range a.Tensors {
+		if _, ok := ta[t.Name]; ok {
+			tensors = append(tensors, fmt.Sprintf("- %s %s %v", t.Name, t.Type, t.Shape))
+		}
+	}
+	section("Tensors", tensors)
+
+	var other []string
+	for _, v := range []struct {
+		name string
+		a, b string
+	}{
+		{"template", a.Template, b.Template},
+		{"system", a.System, b.System},
+		{"parameters", a.Parameters, b.Parameters},
+		{"license", a.License, b.License},
+	} {
+		if v.a != v.b {
+			other = append(other, v.name+" differs")
+		}
+	}
+	section("Modelfile", other)
+
+	return n
+}
+
+func merge(a, b map[string]any) map[string]any {
+	m := make(map[string]any, len(a)+len(b))
+	maps.Copy(m, a)
+	maps.Copy(m, b)
+	return m
+}
+
+func changed(name, a, b string) string {
+	return fmt.Sprintf("~ %s: %s -> %s", name, a, b)
+}
+
+// formatValue summarizes v so large arrays such as the vocabulary do not
+// flood the output
+func formatValue(v any) string {
+	if s, ok := v.([]any); ok {
+		return fmt.Sprintf("[%d items]", len(s))
+	}
+
+	return fmt.Sprint(v)
+}
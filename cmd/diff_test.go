@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestDiffModels(t *testing.T) {
+	a := &api.ShowResponse{
+		Details: api.ModelDetails{Family: "llama", ParameterSize: "8B", QuantizationLevel: "Q4_0"},
+		ModelInfo: map[string]any{
+			"llama.rope.freq_base":  float64(10000),
+			"llama.block_count":     float64(32),
+			"tokenizer.ggml.tokens": []any{"a", "b"},
+			"general.license":       "mit",
+		},
+		Tensors: []api.Tensor{
+			{Name: "output.weight", Type: "Q4_0", Shape: []uint64{4096, 128256}},
+			{Name: "token_embd.weight", Type: "Q4_0", Shape: []uint64{4096, 128256}, Digest: "sha256:aa"},
+			{Name: "rope_freqs.weight", Type: "F32", Shape: []uint64{64}},
+		},
+		Template: "{{ .Prompt }}",
+	}
+
+	b := &api.ShowResponse{
+		Details: api.ModelDetails{Family: "llama", ParameterSize: "8B", QuantizationLevel: "Q8_0"},
+		ModelInfo: map[string]any{
+			"llama.rope.freq_base":  float64(500000),
+			"llama.block_count":     float64(32),
+			"tokenizer.ggml.tokens": []any{"a", "b", "c"},
+			"general.basename":      "llama",
+		},
+		Tensors: []api.Tensor{
+			{Name: "output.weight", Type: "Q8_0", Shape: []uint64{4096, 128256}},
+			{Name: "token_embd.weight", Type: "Q4_0", Shape: []uint64{4096, 128256}, Digest: "sha256:bb"},
+		},
+		Template: "{{ .Prompt }}",
+	}
+
+	var buf bytes.Buffer
+	if n := diffModels(&buf, a, b); n != 8 {
+		t.Errorf("expected 8 differences, got %d", n)
+	}
+
+	expect := `  Details
+    ~ quantization: Q4_0 -> Q8_0
+
+  RoPE
+    ~ llama.rope.freq_base: 10000 -> 500000
+
+  Tokenizer
+    ~ tokenizer.ggml.tokens: [2 items] -> [3 items]
+
+  Metadata
+    + general.basename = llama
+    - general.license = mit
+
+  Tensors
+    ~ output.weight: Q4_0 -> Q8_0
+    ~ token_embd.weight: sha256:aa -> sha256:bb
+    - rope_freqs.weight F32 [64]
+
+`
+	if diff := cmp.Diff(expect, buf.String()); diff != "" {
+		t.Errorf("unexpected output (-want +got):\n%s", diff)
+	}
+
+	buf.Reset()
+	if n := diffModels(&buf, a, a); n != 0 || buf.Len() != 0 {
+		t.Errorf("expected no differences, got %d:\n%s", n, buf.String())
+	}
+}
@@ -189,6 +189,8 @@ func ConvertModel(fsys fs.FS, ws io.WriteSeeker) error {
 		conv = &phi3Model{}
 	case "BertModel":
 		conv = &bertModel{}
+	case "BertForSequenceClassification":
+		conv = &bertModel{rank: true}
 	default:
 		return errors.New("unsupported architecture")
 	}
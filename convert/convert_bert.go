@@ -3,6 +3,7 @@ package convert
 import (
 	"cmp"
 	"encoding/json"
+	"fmt"
 	"io/fs"
 	"path/filepath"
 	"slices"
@@ -29,15 +30,33 @@ type bertModel struct {
 	LayerNormEpsilon      float32 `json:"layer_norm_epsilon"`
 	NormEpsilon           float32 `json:"norm_epsilon"`
 
+	ID2Label map[string]string `json:"id2label"`
+
 	PoolingType uint32
+
+	// rank is set for rerankers, which score a query and document with a
+	// classification head on the CLS token
+	rank bool
 }
 
+// poolingTypeRank is the pooling type of rerankers
+const poolingTypeRank = 4
+
 var (
 	_ ModelConverter = (*bertModel)(nil)
 	_ moreParser     = (*bertModel)(nil)
 )
 
 func (p *bertModel) parseMore(fsys fs.FS) error {
+	if p.rank {
+		if len(p.ID2Label) > 1 {
+			return fmt.Errorf("rerankers must have one label, found %d", len(p.ID2Label))
+		}
+
+		p.PoolingType = poolingTypeRank
+		return nil
+	}
+
 	bts, err := fs.ReadFile(fsys, "modules.json")
 	if err != nil {
 		return err
@@ -154,8 +173,19 @@ func (p *bertModel) Tensors(ts []Tensor) []llm.Tensor {
 	return out
 }
 
-func (bertModel) Replacements() []string {
-	return []string{
+func (p *bertModel) Replacements() []string {
+	var r []string
+	if p.rank {
+		// sequence classification models nest the encoder in "bert" and
+		// replace the pooler with their classification head
+		r = []string{
+			"bert.", "",
+			"pooler.dense", "cls",
+			"classifier", "cls.output",
+		}
+	}
+
+	return append(r,
 		"encoder.layer", "blk",
 		"encoder.layers", "blk",
 		"embeddings.word_embeddings", "token_embd",
@@ -170,5 +200,5 @@ func (bertModel) Replacements() []string {
 		"intermediate.dense", "ffn_up",
 		"output.dense", "ffn_down",
 		"output.LayerNorm", "layer_output_norm",
-	}
+	)
 }
@@ -178,6 +178,36 @@ func TestConvertInvalidTensorNames(t *testing.T) {
 	}
 }
 
+func TestConvertBertRerank(t *testing.T) {
+	p := bertModel{rank: true, ID2Label: map[string]string{"0": "LABEL_0"}}
+	if err := p.parseMore(os.DirFS(t.TempDir())); err != nil {
+		t.Fatal(err)
+	}
+
+	if p.PoolingType != poolingTypeRank {
+		t.Errorf("expected pooling type %d, got %d", poolingTypeRank, p.PoolingType)
+	}
+
+	r := strings.NewReplacer(p.Replacements()...)
+	for name, want := range map[string]string{
+		"bert.embeddings.word_embeddings.weight":                  "token_embd.weight",
+		"bert.encoder.layer.0.attention.output.dense.weight":      "blk.0.attn_output.weight",
+		"bert.encoder.layer.0.output.dense.weight":                "blk.0.ffn_down.weight",
+		"bert.pooler.dense.weight":                                "cls.weight",
+		"classifier.bias":                                         "cls.output.bias",
+		"bert.encoder.layer.11.attention.output.LayerNorm.weight": "blk.11.attn_output_norm.weight",
+	} {
+		if got := r.Replace(name); got != want {
+			t.Errorf("%s: expected %s, got %s", name, want, got)
+		}
+	}
+
+	p = bertModel{rank: true, ID2Label: map[string]string{"0": "no", "1": "yes"}}
+	if err := p.parseMore(os.DirFS(t.TempDir())); err == nil {
+		t.Error("expected an error for a classifier with two labels")
+	}
+}
+
 func TestConvertInvalidDatatype(t *testing.T) {
 	f, err := os.CreateTemp(t.TempDir(), "testmodel")
 	if err != nil {
@@ -38,6 +38,9 @@ const (
 	DRMUniqueIDFile = "unique_id"
 	DRMVendorFile   = "vendor"
 	DRMDeviceFile   = "device"
+
+	// Device node the ROCm runtime opens for each GPU, by drm_render_minor
+	DRMRenderNodeFmt = "/dev/dri/renderD%d"
 )
 
 var (
@@ -110,7 +113,7 @@ func AMDGetGPUInfo() ([]RocmGPUInfo, error) {
 		scanner := bufio.NewScanner(fp)
 		isCPU := false
 		var major, minor, patch uint64
-		var vendor, device, uniqueID uint64
+		var vendor, device, uniqueID, renderMinor uint64
 		for scanner.Scan() {
 			line := strings.TrimSpace(scanner.Text())
 			// Note: we could also use "cpu_cores_count X" where X is greater than zero to detect CPUs
@@ -158,6 +161,16 @@ func AMDGetGPUInfo() ([]RocmGPUInfo, error) {
 				if err != nil {
 					slog.Debug("malformed", "device_id", line, "error", err)
 				}
+			} else if strings.HasPrefix(line, "drm_render_minor") {
+				ver := strings.Fields(line)
+				if len(ver) != 2 {
+					slog.Debug("malformed", "drm_render_minor", line)
+					continue
+				}
+				renderMinor, err = strconv.ParseUint(ver[1], 10, 64)
+				if err != nil {
+					slog.Debug("malformed", "drm_render_minor", line, "error", err)
+				}
 			} else if strings.HasPrefix(line, "unique_id") {
 				ver := strings.Fields(line)
 				if len(ver) != 2 {
@@ -188,6 +201,15 @@ func AMDGetGPUInfo() ([]RocmGPUInfo, error) {
 			continue
 		}
 
+		// Containers see every GPU in sysfs but only the device nodes passed
+		// through to them, and the ROCm runtime skips GPUs it can't open
+		if renderMinor > 0 {
+			if _, err := os.Stat(fmt.Sprintf(DRMRenderNodeFmt, renderMinor)); errors.Is(err, os.ErrNotExist) {
+				slog.Debug("skipping gpu without a render node, not visible in this container", "node", match, "render_minor", renderMinor)
+				continue
+			}
+		}
+
 		// Keep track of numeric IDs based on valid GPUs
 		gpuID := gpuCount
 		gpuCount += 1
@@ -0,0 +1,142 @@
+package discover
+
+import (
+	"bufio"
+	"bytes"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// cgroupRoot is where the cgroup filesystem is mounted. Container runtimes
+// mount the container's own cgroup here, so its limits apply to Ollama.
+var cgroupRoot = "/sys/fs/cgroup"
+
+// cgroupUnlimited is the smallest cgroup v1 memory limit treated as unlimited.
+// v1 reports an unset limit as a large page aligned value near MaxInt64.
+const cgroupUnlimited = 1 << 62
+
+type cgroupLimits struct {
+	// memoryLimit is the memory limit in bytes, or 0 if unlimited
+	memoryLimit uint64
+	// memoryUsage is the memory in use, excluding reclaimable page cache
+	memoryUsage uint64
+	// cpuQuota is the number of CPUs the cgroup may use, or 0 if unlimited
+	cpuQuota float64
+}
+
+// readCgroupLimits reads the memory and CPU limits of the cgroup mounted at
+// root, supporting both cgroup v1 and v2
+func readCgroupLimits(root string) cgroupLimits {
+	if _, err := os.Stat(filepath.Join(root, "cgroup.controllers")); err == nil {
+		return readCgroupV2Limits(root)
+	}
+
+	return readCgroupV1Limits(root)
+}
+
+func readCgroupV2Limits(root string) (l cgroupLimits) {
+	if limit, ok := readCgroupUint(filepath.Join(root, "memory.max")); ok {
+		l.memoryLimit = limit
+		usage, _ := readCgroupUint(filepath.Join(root, "memory.current"))
+		inactive := readCgroupStat(filepath.Join(root, "memory.stat"), "inactive_file")
+		l.memoryUsage = usage - min(usage, inactive)
+	}
+
+	// cpu.max is "$MAX $PERIOD" where $MAX is "max" if unlimited
+	if buf, err := os.ReadFile(filepath.Join(root, "cpu.max")); err == nil {
+		if fields := strings.Fields(string(buf)); len(fields) == 2 {
+			quota, err1 := strconv.ParseFloat(fields[0], 64)
+			period, err2 := strconv.ParseFloat(fields[1], 64)
+			if err1 == nil && err2 == nil && quota > 0 && period > 0 {
+				l.cpuQuota = quota / period
+			}
+		}
+	}
+
+	return l
+}
+
+func readCgroupV1Limits(root string) (l cgroupLimits) {
+	if limit, ok := readCgroupUint(filepath.Join(root, "memory", "memory.limit_in_bytes")); ok && limit < cgroupUnlimited {
+		l.memoryLimit = limit
+		usage, _ := readCgroupUint(filepath.Join(root, "memory", "memory.usage_in_bytes"))
+		inactive := readCgroupStat(filepath.Join(root, "memory", "memory.stat"), "total_inactive_file")
+		l.memoryUsage = usage - min(usage, inactive)
+	}
+
+	// a quota of -1 is unlimited
+	quota, err1 := readCgroupInt(filepath.Join(root, "cpu", "cpu.cfs_quota_us"))
+	period, err2 := readCgroupInt(filepath.Join(root, "cpu", "cpu.cfs_period_us"))
+	if err1 == nil && err2 == nil && quota > 0 && period > 0 {
+		l.cpuQuota = float64(quota) / float64(period)
+	}
+
+	return l
+}
+
+// readCgroupUint reads a byte count, returning false if it's unset or "max"
+func readCgroupUint(path string) (uint64, bool) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return 0, false
+	}
+
+	n, err := strconv.ParseUint(string(bytes.TrimSpace(buf)), 10, 64)
+	return n, err == nil
+}
+
+func readCgroupInt(path string) (int64, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseInt(string(bytes.TrimSpace(buf)), 10, 64)
+}
+
+// readCgroupStat returns the value of key in a memory.stat file, or 0
+func readCgroupStat(path, key string) uint64 {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		k, v, ok := strings.Cut(s.Text(), " ")
+		if ok && k == key {
+			n, _ := strconv.ParseUint(strings.TrimSpace(v), 10, 64)
+			return n
+		}
+	}
+
+	return 0
+}
+
+// applyCgroupMemoryLimit limits the system memory in mem to the cgroup limit
+func applyCgroupMemoryLimit(mem *memInfo, l cgroupLimits) {
+	if l.memoryLimit == 0 || l.memoryLimit >= mem.TotalMemory {
+		return
+	}
+
+	slog.Debug("limiting system memory to cgroup limit", "total", mem.TotalMemory, "limit", l.memoryLimit, "usage", l.memoryUsage)
+	mem.TotalMemory = l.memoryLimit
+	mem.FreeMemory = min(mem.FreeMemory, l.memoryLimit-min(l.memoryLimit, l.memoryUsage))
+}
+
+// CPULimit returns the number of CPUs Ollama may use according to its CPU
+// affinity and cgroup CPU quota
+func CPULimit() int {
+	limit := runtime.NumCPU()
+	if quota := readCgroupLimits(cgroupRoot).cpuQuota; quota > 0 {
+		limit = min(limit, max(int(math.Ceil(quota)), 1))
+	}
+
+	return limit
+}
@@ -0,0 +1,127 @@
+package discover
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeCgroupFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestReadCgroupLimits(t *testing.T) {
+	cases := []struct {
+		name  string
+		files map[string]string
+		want  cgroupLimits
+	}{
+		{
+			name: "none",
+		},
+		{
+			name: "v2 unlimited",
+			files: map[string]string{
+				"cgroup.controllers": "cpu memory",
+				"memory.max":         "max\n",
+				"memory.current":     "1048576\n",
+				"cpu.max":            "max 100000\n",
+			},
+		},
+		{
+			name: "v2 limited",
+			files: map[string]string{
+				"cgroup.controllers": "cpu memory",
+				"memory.max":         "8589934592\n",
+				"memory.current":     "3221225472\n",
+				"memory.stat":        "anon 1073741824\ninactive_file 1073741824\nactive_file 0\n",
+				"cpu.max":            "250000 100000\n",
+			},
+			want: cgroupLimits{memoryLimit: 8589934592, memoryUsage: 2147483648, cpuQuota: 2.5},
+		},
+		{
+			name: "v1 unlimited",
+			files: map[string]string{
+				"memory/memory.limit_in_bytes": "9223372036854771712\n",
+				"memory/memory.usage_in_bytes": "1048576\n",
+				"cpu/cpu.cfs_quota_us":         "-1\n",
+				"cpu/cpu.cfs_period_us":        "100000\n",
+			},
+		},
+		{
+			name: "v1 limited",
+			files: map[string]string{
+				"memory/memory.limit_in_bytes": "4294967296\n",
+				"memory/memory.usage_in_bytes": "2147483648\n",
+				"memory/memory.stat":           "cache 1073741824\ntotal_inactive_file 536870912\n",
+				"cpu/cpu.cfs_quota_us":         "400000\n",
+				"cpu/cpu.cfs_period_us":        "100000\n",
+			},
+			want: cgroupLimits{memoryLimit: 4294967296, memoryUsage: 1610612736, cpuQuota: 4},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			got := readCgroupLimits(writeCgroupFiles(t, tt.files))
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyCgroupMemoryLimit(t *testing.T) {
+	cases := []struct {
+		name   string
+		limits cgroupLimits
+		want   memInfo
+	}{
+		{
+			name:   "unlimited",
+			limits: cgroupLimits{},
+			want:   memInfo{TotalMemory: 64, FreeMemory: 32},
+		},
+		{
+			name:   "above host",
+			limits: cgroupLimits{memoryLimit: 128, memoryUsage: 100},
+			want:   memInfo{TotalMemory: 64, FreeMemory: 32},
+		},
+		{
+			name:   "limited",
+			limits: cgroupLimits{memoryLimit: 16, memoryUsage: 4},
+			want:   memInfo{TotalMemory: 16, FreeMemory: 12},
+		},
+		{
+			name:   "host has less free",
+			limits: cgroupLimits{memoryLimit: 48, memoryUsage: 4},
+			want:   memInfo{TotalMemory: 48, FreeMemory: 32},
+		},
+		{
+			name:   "over limit",
+			limits: cgroupLimits{memoryLimit: 16, memoryUsage: 20},
+			want:   memInfo{TotalMemory: 16, FreeMemory: 0},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			mem := memInfo{TotalMemory: 64, FreeMemory: 32}
+			applyCgroupMemoryLimit(&mem, tt.limits)
+			if mem != tt.want {
+				t.Errorf("got %+v, want %+v", mem, tt.want)
+			}
+		})
+	}
+}
@@ -81,6 +81,15 @@ func initCudaHandles() *cudaHandles {
 	// TODO - if the ollama build is CPU only, don't do these checks as they're irrelevant and confusing
 
 	cHandles := &cudaHandles{}
+
+	// The NVIDIA container runtime hides all GPUs with "void" or "none", but
+	// the driver libraries may still be mounted into the container
+	switch envconfig.NvidiaVisibleDevices() {
+	case "void", "none":
+		slog.Debug("NVIDIA GPUs are not visible in this container", "NVIDIA_VISIBLE_DEVICES", envconfig.NvidiaVisibleDevices())
+		return cHandles
+	}
+
 	// Short Circuit if we already know which library to use
 	// ignore bootstrap errors in this case since we already recorded them
 	if nvmlLibPath != "" {
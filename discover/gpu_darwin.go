@@ -65,6 +65,11 @@ func GetCPUMem() (memInfo, error) {
 	}, nil
 }
 
+// CPULimit returns the number of CPUs Ollama may use
+func CPULimit() int {
+	return runtime.NumCPU()
+}
+
 func (l GpuInfoList) GetVisibleDevicesEnv() (string, string) {
 	// No-op on darwin
 	return "", ""
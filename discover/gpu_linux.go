@@ -92,6 +92,7 @@ func GetCPUMem() (memInfo, error) {
 	} else {
 		mem.FreeMemory = (free + buffers + cached) * format.KibiByte
 	}
+	applyCgroupMemoryLimit(&mem, readCgroupLimits(cgroupRoot))
 	return mem, nil
 }
 
@@ -58,3 +58,17 @@ func TestByLibrary(t *testing.T) {
 }
 
 // TODO - add some logic to figure out card type through other means and actually verify we got back what we expected
+
+func TestComparePerformance(t *testing.T) {
+	gpu := func(compute string, total uint64) GpuInfo {
+		g := GpuInfo{Compute: compute}
+		g.TotalMemory = total
+		return g
+	}
+
+	assert.Equal(t, -1, ComparePerformance(gpu("7.5", 24), gpu("8.6", 12)))
+	assert.Equal(t, 1, ComparePerformance(gpu("8.10", 12), gpu("8.9", 12)))
+	assert.Equal(t, -1, ComparePerformance(gpu("8.6", 12), gpu("8.6", 24)))
+	assert.Equal(t, -1, ComparePerformance(gpu("gfx90a", 64), gpu("gfx1100", 24)))
+	assert.Equal(t, 0, ComparePerformance(gpu("", 8), gpu("unknown", 8)))
+}
@@ -3,6 +3,7 @@ package discover
 import (
 	"fmt"
 	"log/slog"
+	"runtime"
 	"syscall"
 	"unsafe"
 )
@@ -217,6 +218,11 @@ func processSystemLogicalProcessorInforationList(buf []byte) []*winPackage {
 	return packages
 }
 
+// CPULimit returns the number of CPUs Ollama may use
+func CPULimit() int {
+	return runtime.NumCPU()
+}
+
 func GetCPUDetails() ([]CPU, error) {
 	buf, err := getLogicalProcessorInformationEx()
 	if err != nil {
@@ -1,8 +1,11 @@
 package discover
 
 import (
+	"cmp"
 	"fmt"
 	"log/slog"
+	"strconv"
+	"strings"
 
 	"github.com/ollama/ollama/format"
 	"github.com/ollama/ollama/runners"
@@ -148,6 +151,36 @@ func (a ByFreeMemory) Len() int           { return len(a) }
 func (a ByFreeMemory) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
 func (a ByFreeMemory) Less(i, j int) bool { return a[i].FreeMemory < a[j].FreeMemory }
 
+// ComparePerformance compares how fast GPUs of the same library are likely
+// to run models. Newer compute capabilities or gfx versions are taken to be
+// faster, then more total memory, as it's usually paired with more cores.
+func ComparePerformance(a, b GpuInfo) int {
+	if c := cmp.Compare(computeVersion(a.Compute), computeVersion(b.Compute)); c != 0 {
+		return c
+	}
+
+	return cmp.Compare(a.TotalMemory, b.TotalMemory)
+}
+
+// computeVersion returns a compute capability such as "8.6", or a gfx
+// version such as "gfx1100", as a number that orders them, or 0 if it can't
+// be parsed
+func computeVersion(compute string) uint64 {
+	if gfx, ok := strings.CutPrefix(compute, "gfx"); ok {
+		v, _ := strconv.ParseUint(gfx, 16, 64)
+		return v
+	}
+
+	major, minor, _ := strings.Cut(compute, ".")
+	v, err := strconv.ParseUint(major, 10, 32)
+	if err != nil {
+		return 0
+	}
+
+	m, _ := strconv.ParseUint(minor, 10, 32)
+	return v<<32 | m
+}
+
 type SystemInfo struct {
 	System          CPUInfo              `json:"system"`
 	GPUs            []GpuInfo            `json:"gpus"`
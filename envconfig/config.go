@@ -15,11 +15,108 @@ import (
 )
 
 // Host returns the scheme and host. Host can be configured via the OLLAMA_HOST environment variable.
-// Default is scheme "http" and host "127.0.0.1:11434"
+// Default is scheme "http" and host "127.0.0.1:11434". If OLLAMA_HOST lists several addresses, Host
+// returns the first that isn't a Unix socket.
 func Host() *url.URL {
+	for _, s := range hosts() {
+		if !strings.HasPrefix(s, "unix://") {
+			return parseHost(s)
+		}
+	}
+
+	return parseHost("")
+}
+
+// Socket returns the path of the Unix socket clients connect to, which is the first one OLLAMA_HOST lists if it
+// doesn't list any other addresses. It returns "" if clients connect to Host.
+func Socket() string {
+	var socket string
+	for _, s := range hosts() {
+		path, ok := strings.CutPrefix(s, "unix://")
+		if !ok {
+			return ""
+		}
+
+		if socket == "" {
+			socket, _, _ = strings.Cut(path, "?")
+		}
+	}
+
+	return socket
+}
+
+// HostKeyFile returns the key_file of the address clients connect to, which is Socket if it's set and Host
+// otherwise, or "" if it doesn't require keys.
+func HostKeyFile() string {
+	socket := Socket() != ""
+	for _, l := range Listeners() {
+		if socket == (l.Network == "unix") {
+			return l.KeyFile
+		}
+	}
+
+	return ""
+}
+
+// Listener is an address the server listens on.
+type Listener struct {
+	// Network is "tcp" or "unix".
+	Network string
+
+	// Address is the host and port, or the path of a Unix socket.
+	Address string
+
+	// KeyFile is the path to a file of API keys, one per line. Clients of the listener must
+	// present one of them as a bearer token. No key is required if it's empty.
+	KeyFile string
+}
+
+// Listeners returns the addresses the server listens on. OLLAMA_HOST can be a comma-separated
+// list of addresses, including Unix sockets such as "unix:///run/ollama.sock". Each address can
+// be followed by "?key_file=<path>" to require API keys from its clients.
+func Listeners() []Listener {
+	hosts := hosts()
+	if len(hosts) == 0 {
+		hosts = []string{""}
+	}
+
+	listeners := make([]Listener, 0, len(hosts))
+	for _, s := range hosts {
+		s, query, _ := strings.Cut(s, "?")
+		q, err := url.ParseQuery(query)
+		if err != nil {
+			slog.Warn("invalid listener options, ignoring", "options", query, "error", err)
+		}
+
+		l := Listener{Network: "tcp", KeyFile: q.Get("key_file")}
+		if path, ok := strings.CutPrefix(s, "unix://"); ok {
+			l.Network, l.Address = "unix", path
+		} else {
+			l.Address = parseHost(s).Host
+		}
+
+		listeners = append(listeners, l)
+	}
+
+	return listeners
+}
+
+// hosts splits OLLAMA_HOST into its addresses
+func hosts() []string {
+	var hosts []string
+	for _, s := range strings.Split(Var("OLLAMA_HOST"), ",") {
+		if s = strings.Trim(strings.TrimSpace(s), "\"'"); s != "" {
+			hosts = append(hosts, s)
+		}
+	}
+
+	return hosts
+}
+
+func parseHost(s string) *url.URL {
 	defaultPort := "11434"
 
-	s := strings.TrimSpace(Var("OLLAMA_HOST"))
+	s, _, _ = strings.Cut(strings.TrimSpace(s), "?")
 	scheme, hostport, ok := strings.Cut(s, "://")
 	switch {
 	case !ok:
@@ -78,8 +175,59 @@ func Origins() (origins []string) {
 	return origins
 }
 
+// TrustedProxies returns the addresses and networks of proxies whose X-Forwarded-For headers name the client of a
+// request. Without any, the client is the address the request comes from. TrustedProxies can be configured via the
+// OLLAMA_TRUSTED_PROXIES environment variable.
+func TrustedProxies() (proxies []string) {
+	for _, proxy := range strings.Split(Var("OLLAMA_TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+
+	return proxies
+}
+
+// MetricsLabels returns the request metadata keys completion metrics are labeled with. MetricsLabels can be configured
+// via the OLLAMA_METRICS_LABELS environment variable.
+func MetricsLabels() (keys []string) {
+	for _, key := range strings.Split(Var("OLLAMA_METRICS_LABELS"), ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+// FallbackHosts returns the hosts of the remote servers models may fall back to. FallbackHosts can be configured via the OLLAMA_FALLBACK_HOSTS environment variable.
+func FallbackHosts() (hosts []string) {
+	for _, s := range strings.Split(Var("OLLAMA_FALLBACK_HOSTS"), ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			hosts = append(hosts, s)
+		}
+	}
+
+	return hosts
+}
+
 // Models returns the path to the models directory. Models directory can be configured via the OLLAMA_MODELS environment variable.
 // Default is $HOME/.ollama/models
+// OtelEndpoint returns the URL traces are exported to with OTLP over HTTP. It's set with
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT, or OTEL_EXPORTER_OTLP_ENDPOINT with /v1/traces appended.
+// Traces aren't recorded if neither is set.
+func OtelEndpoint() string {
+	if s := Var("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"); s != "" {
+		return s
+	}
+
+	if s := Var("OTEL_EXPORTER_OTLP_ENDPOINT"); s != "" {
+		return strings.TrimSuffix(s, "/") + "/v1/traces"
+	}
+
+	return ""
+}
+
 func Models() string {
 	if s := Var("OLLAMA_MODELS"); s != "" {
 		return s
@@ -113,6 +261,83 @@ func KeepAlive() (keepAlive time.Duration) {
 	return keepAlive
 }
 
+// TTFTTarget returns the target time to first token for interactive requests. Requests projected to exceed it are rejected
+// while the server is busy. TTFTTarget can be configured via the OLLAMA_TTFT_TARGET environment variable.
+// Zero or negative values disable the target. Default is disabled.
+func TTFTTarget() (target time.Duration) {
+	if s := Var("OLLAMA_TTFT_TARGET"); s != "" {
+		if d, err := time.ParseDuration(s); err == nil {
+			target = d
+		} else if n, err := strconv.ParseFloat(s, 64); err == nil {
+			target = time.Duration(n * float64(time.Second))
+		}
+	}
+
+	return max(target, 0)
+}
+
+// SessionTTL returns how long chat sessions are kept after they were last used. SessionTTL can be configured via the
+// OLLAMA_SESSION_TTL environment variable. Zero or negative values keep sessions until they're deleted. Default is 24 hours.
+func SessionTTL() (ttl time.Duration) {
+	ttl = 24 * time.Hour
+	if s := Var("OLLAMA_SESSION_TTL"); s != "" {
+		if d, err := time.ParseDuration(s); err == nil {
+			ttl = d
+		} else if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			ttl = time.Duration(n) * time.Second
+		}
+	}
+
+	if ttl <= 0 {
+		return time.Duration(math.MaxInt64)
+	}
+
+	return ttl
+}
+
+// CacheTTL returns how long prompts are kept in the prompt caches of the server and runners after they were last
+// used. CacheTTL can be configured via the OLLAMA_CACHE_TTL environment variable. Zero or negative values keep them
+// until they're evicted to make room. Default is unlimited.
+func CacheTTL() (ttl time.Duration) {
+	if s := Var("OLLAMA_CACHE_TTL"); s != "" {
+		if d, err := time.ParseDuration(s); err == nil {
+			ttl = d
+		} else if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			ttl = time.Duration(n) * time.Second
+		}
+	}
+
+	return max(ttl, 0)
+}
+
+// WriteTimeout returns how long writing a chunk of a streaming response may take before the client is considered
+// stalled and the stream is closed. WriteTimeout can be configured via the OLLAMA_WRITE_TIMEOUT environment variable.
+// Zero or negative values disable the timeout. Default is disabled.
+func WriteTimeout() (timeout time.Duration) {
+	if s := Var("OLLAMA_WRITE_TIMEOUT"); s != "" {
+		if d, err := time.ParseDuration(s); err == nil {
+			timeout = d
+		} else if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			timeout = time.Duration(n) * time.Second
+		}
+	}
+
+	return max(timeout, 0)
+}
+
+// FlushInterval returns how long chunks of a streaming response may be buffered before they're flushed to the client.
+// Longer intervals send fewer, larger writes under high concurrency. FlushInterval can be configured via the
+// OLLAMA_FLUSH_INTERVAL environment variable. Zero or negative values flush every chunk. Default is 0.
+func FlushInterval() (interval time.Duration) {
+	if s := Var("OLLAMA_FLUSH_INTERVAL"); s != "" {
+		if d, err := time.ParseDuration(s); err == nil {
+			interval = d
+		}
+	}
+
+	return max(interval, 0)
+}
+
 // LoadTimeout returns the duration for stall detection during model loads. LoadTimeout can be configured via the OLLAMA_LOAD_TIMEOUT environment variable.
 // Zero or Negative values are treated as infinite.
 // Default is 5 minutes.
@@ -165,6 +390,19 @@ var (
 	IntelGPU = Bool("OLLAMA_INTEL_GPU")
 	// MultiUserCache optimizes prompt caching for multi-user scenarios
 	MultiUserCache = Bool("OLLAMA_MULTIUSER_CACHE")
+	// RegistryCache serves local models to other Ollama instances as a pull-through registry cache.
+	RegistryCache = Bool("OLLAMA_REGISTRY_CACHE")
+	// GpuYield releases VRAM while a full-screen application such as a game is running on Windows.
+	GpuYield = Bool("OLLAMA_GPU_YIELD")
+	// HTTP2 enables HTTP/2 without TLS (h2c) so many concurrent streams can share one connection.
+	// HTTP2 can be configured via the OLLAMA_HTTP2 environment variable.
+	HTTP2 = Bool("OLLAMA_HTTP2")
+	// PredictivePreload loads models before they're requested, based on the times of day and the order they're usually used in.
+	// PredictivePreload can be configured via the OLLAMA_PREDICTIVE_PRELOAD environment variable.
+	PredictivePreload = Bool("OLLAMA_PREDICTIVE_PRELOAD")
+	// Provenance adds headers identifying the model, server and request to generate and chat responses.
+	// Provenance can be configured via the OLLAMA_PROVENANCE environment variable.
+	Provenance = Bool("OLLAMA_PROVENANCE")
 )
 
 func String(s string) func() string {
@@ -175,8 +413,81 @@ func String(s string) func() string {
 
 var (
 	LLMLibrary = String("OLLAMA_LLM_LIBRARY")
+	// TransferLimit caps the bandwidth used by pulls and pushes, optionally per time of day, e.g. "09:00-17:00=10MB,unlimited".
+	// TransferLimit can be configured via the OLLAMA_TRANSFER_LIMIT environment variable.
+	TransferLimit = String("OLLAMA_TRANSFER_LIMIT")
+	// CapabilityDevices assigns embedding and completion models to the CPU or specific GPUs, e.g. "embedding=cpu:4;completion=0".
+	// CapabilityDevices can be configured via the OLLAMA_CAPABILITY_DEVICES environment variable.
+	CapabilityDevices = String("OLLAMA_CAPABILITY_DEVICES")
+	// RequestLimits sets concurrency and rate limits for groups of endpoints, e.g. "/v1/*:concurrency=4,rate=60/m".
+	// RequestLimits can be configured via the OLLAMA_REQUEST_LIMITS environment variable.
+	RequestLimits = String("OLLAMA_REQUEST_LIMITS")
+	// TokenQuotas sets the tokens each client can use per minute and per day, e.g. "*:minute=10000,day=1000000".
+	// TokenQuotas can be configured via the OLLAMA_TOKEN_QUOTAS environment variable.
+	TokenQuotas = String("OLLAMA_TOKEN_QUOTAS")
+	// APIKeys is a comma separated list of API keys with every scope. Requests must send one of them, or a key in APIKeysFile, if either is set.
+	// APIKeys can be configured via the OLLAMA_API_KEYS environment variable.
+	APIKeys = String("OLLAMA_API_KEYS")
+	// APIKeysFile is the path to a JSON file of API keys and their scopes.
+	// APIKeysFile can be configured via the OLLAMA_API_KEYS_FILE environment variable.
+	APIKeysFile = String("OLLAMA_API_KEYS_FILE")
+	// ClientKey is the API key the client sends to the server. Unlike APIKeys and APIKeysFile, which set the keys a server accepts, it is only read by clients.
+	// ClientKey can be configured via the OLLAMA_CLIENT_KEY environment variable.
+	ClientKey = String("OLLAMA_CLIENT_KEY")
+	// RegistryConfig is the path to a JSON file with per registry proxy and certificate settings.
+	// RegistryConfig can be configured via the OLLAMA_REGISTRY_CONFIG environment variable.
+	RegistryConfig = String("OLLAMA_REGISTRY_CONFIG")
+	// MemoryPressure sets the percentages of available system memory below which idle models are shrunk or evicted on macOS, e.g. "shrink=20,evict=10".
+	// MemoryPressure can be configured via the OLLAMA_MEMORY_PRESSURE environment variable.
+	MemoryPressure = String("OLLAMA_MEMORY_PRESSURE")
+	// MemoryEmbedModel is the embedding model that embeds the long-term memories of chats, e.g. "nomic-embed-text". The chat model embeds them if it isn't set.
+	// MemoryEmbedModel can be configured via the OLLAMA_MEMORY_EMBED_MODEL environment variable.
+	MemoryEmbedModel = String("OLLAMA_MEMORY_EMBED_MODEL")
+	// GpuReserve is the VRAM left free on each GPU for other applications, in bytes or as a percentage of total VRAM, e.g. "2GiB" or "15%".
+	// GpuReserve can be configured via the OLLAMA_GPU_RESERVE environment variable.
+	GpuReserve = String("OLLAMA_GPU_RESERVE")
+	// SharedBlobs is the path to a read-only directory of model blobs shared between servers, e.g. a network volume.
+	// SharedBlobs can be configured via the OLLAMA_SHARED_BLOBS environment variable.
+	SharedBlobs = String("OLLAMA_SHARED_BLOBS")
+	// SharedModels is the path to a read-only models directory, laid out like OLLAMA_MODELS, of models shared between users.
+	// Models not in OLLAMA_MODELS are looked up here. SharedModels can be configured via the OLLAMA_SHARED_MODELS environment variable.
+	SharedModels = String("OLLAMA_SHARED_MODELS")
+	// Store is the URL of a storage backend models are mirrored to, such as s3://bucket/models. Models not in OLLAMA_MODELS
+	// are copied from it when they're first used. Store can be configured via the OLLAMA_STORE environment variable.
+	Store = String("OLLAMA_STORE")
+	// Backend selects the inference backends models are run with, for every model or per model family, e.g. "custom" or "llama=custom,gemma2=llama".
+	// Backend can be configured via the OLLAMA_BACKEND environment variable.
+	Backend = String("OLLAMA_BACKEND")
+	// RemoteKeys are the API keys sent to the servers remote models generate with, as comma-separated host=key pairs.
+	// RemoteKeys can be configured via the OLLAMA_REMOTE_KEYS environment variable.
+	RemoteKeys = String("OLLAMA_REMOTE_KEYS")
+	// Preload is the path to a JSON file listing models to pull and load before the server reports ready.
+	// Preload can be configured via the OLLAMA_PRELOAD environment variable.
+	Preload = String("OLLAMA_PRELOAD")
+	// AgentTools is the path to a JSON file of tools and MCP servers the agent endpoint can call.
+	// AgentTools can be configured via the OLLAMA_AGENT_TOOLS environment variable.
+	AgentTools = String("OLLAMA_AGENT_TOOLS")
+	// Instance identifies the server in provenance headers. It defaults to the host name.
+	// Instance can be configured via the OLLAMA_INSTANCE environment variable.
+	Instance = String("OLLAMA_INSTANCE")
+	// WatermarkKey is the secret key of the watermark models sample with when their watermark parameter is set.
+	// WatermarkKey can be configured via the OLLAMA_WATERMARK_KEY environment variable.
+	WatermarkKey = String("OLLAMA_WATERMARK_KEY")
+	// OtelHeaders are the comma separated key=value headers sent with exported traces, e.g. for authentication.
+	// OtelHeaders can be configured via the OTEL_EXPORTER_OTLP_HEADERS environment variable.
+	OtelHeaders = String("OTEL_EXPORTER_OTLP_HEADERS")
+	// OtelServiceName is the service name of exported traces. It defaults to "ollama".
+	// OtelServiceName can be configured via the OTEL_SERVICE_NAME environment variable.
+	OtelServiceName = String("OTEL_SERVICE_NAME")
+	// AuditLog is where a record of each request to the endpoints that run models is written: the path of a JSON lines
+	// file, or the http(s) URL of a webhook each record is posted to. AuditLog can be configured via the OLLAMA_AUDIT_LOG environment variable.
+	AuditLog = String("OLLAMA_AUDIT_LOG")
+	// AuditLogContent is whether audit records include prompts and responses: "none", "redacted" or "full". It defaults to "none".
+	// AuditLogContent can be configured via the OLLAMA_AUDIT_LOG_CONTENT environment variable.
+	AuditLogContent = String("OLLAMA_AUDIT_LOG_CONTENT")
 
 	CudaVisibleDevices    = String("CUDA_VISIBLE_DEVICES")
+	NvidiaVisibleDevices  = String("NVIDIA_VISIBLE_DEVICES")
 	HipVisibleDevices     = String("HIP_VISIBLE_DEVICES")
 	RocrVisibleDevices    = String("ROCR_VISIBLE_DEVICES")
 	GpuDeviceOrdinal      = String("GPU_DEVICE_ORDINAL")
@@ -200,12 +511,16 @@ func Uint(key string, defaultValue uint) func() uint {
 var (
 	// NumParallel sets the number of parallel model requests. NumParallel can be configured via the OLLAMA_NUM_PARALLEL environment variable.
 	NumParallel = Uint("OLLAMA_NUM_PARALLEL", 0)
+	// MaxParallel enables scaling the parallel requests of each loaded model between 1 and this many, with the number of requests waiting for it and the VRAM free. MaxParallel can be configured via the OLLAMA_MAX_PARALLEL environment variable.
+	MaxParallel = Uint("OLLAMA_MAX_PARALLEL", 0)
 	// MaxRunners sets the maximum number of loaded models. MaxRunners can be configured via the OLLAMA_MAX_LOADED_MODELS environment variable.
 	MaxRunners = Uint("OLLAMA_MAX_LOADED_MODELS", 0)
 	// MaxQueue sets the maximum number of queued requests. MaxQueue can be configured via the OLLAMA_MAX_QUEUE environment variable.
 	MaxQueue = Uint("OLLAMA_MAX_QUEUE", 512)
 	// MaxVRAM sets a maximum VRAM override in bytes. MaxVRAM can be configured via the OLLAMA_MAX_VRAM environment variable.
 	MaxVRAM = Uint("OLLAMA_MAX_VRAM", 0)
+	// MaxImages sets the number of images a message can have for vision models that attend to images with cross attention, such as Llama 3.2 Vision. MaxImages can be configured via the OLLAMA_MAX_IMAGES environment variable.
+	MaxImages = Uint("OLLAMA_MAX_IMAGES", 1)
 )
 
 func Uint64(key string, defaultValue uint64) func() uint64 {
@@ -225,31 +540,87 @@ func Uint64(key string, defaultValue uint64) func() uint64 {
 // Set aside VRAM per GPU
 var GpuOverhead = Uint64("OLLAMA_GPU_OVERHEAD", 0)
 
+// AuditLogMaxSize is the size in bytes an audit log file grows to before it's rotated. AuditLogMaxSize can be configured via
+// the OLLAMA_AUDIT_LOG_MAX_SIZE environment variable.
+var AuditLogMaxSize = Uint64("OLLAMA_AUDIT_LOG_MAX_SIZE", 100<<20)
+
 type EnvVar struct {
 	Name        string
 	Value       any
 	Description string
 }
 
+// redacted hides the value of a secret, such as API keys, from the server's
+// configuration in logs
+func redacted(s string) string {
+	if s == "" {
+		return ""
+	}
+
+	return "[redacted]"
+}
+
 func AsMap() map[string]EnvVar {
 	ret := map[string]EnvVar{
-		"OLLAMA_DEBUG":             {"OLLAMA_DEBUG", Debug(), "Show additional debug information (e.g. OLLAMA_DEBUG=1)"},
-		"OLLAMA_FLASH_ATTENTION":   {"OLLAMA_FLASH_ATTENTION", FlashAttention(), "Enabled flash attention"},
-		"OLLAMA_KV_CACHE_TYPE":     {"OLLAMA_KV_CACHE_TYPE", KvCacheType(), "Quantization type for the K/V cache (default: f16)"},
-		"OLLAMA_GPU_OVERHEAD":      {"OLLAMA_GPU_OVERHEAD", GpuOverhead(), "Reserve a portion of VRAM per GPU (bytes)"},
-		"OLLAMA_HOST":              {"OLLAMA_HOST", Host(), "IP Address for the ollama server (default 127.0.0.1:11434)"},
-		"OLLAMA_KEEP_ALIVE":        {"OLLAMA_KEEP_ALIVE", KeepAlive(), "The duration that models stay loaded in memory (default \"5m\")"},
-		"OLLAMA_LLM_LIBRARY":       {"OLLAMA_LLM_LIBRARY", LLMLibrary(), "Set LLM library to bypass autodetection"},
-		"OLLAMA_LOAD_TIMEOUT":      {"OLLAMA_LOAD_TIMEOUT", LoadTimeout(), "How long to allow model loads to stall before giving up (default \"5m\")"},
-		"OLLAMA_MAX_LOADED_MODELS": {"OLLAMA_MAX_LOADED_MODELS", MaxRunners(), "Maximum number of loaded models per GPU"},
-		"OLLAMA_MAX_QUEUE":         {"OLLAMA_MAX_QUEUE", MaxQueue(), "Maximum number of queued requests"},
-		"OLLAMA_MODELS":            {"OLLAMA_MODELS", Models(), "The path to the models directory"},
-		"OLLAMA_NOHISTORY":         {"OLLAMA_NOHISTORY", NoHistory(), "Do not preserve readline history"},
-		"OLLAMA_NOPRUNE":           {"OLLAMA_NOPRUNE", NoPrune(), "Do not prune model blobs on startup"},
-		"OLLAMA_NUM_PARALLEL":      {"OLLAMA_NUM_PARALLEL", NumParallel(), "Maximum number of parallel requests"},
-		"OLLAMA_ORIGINS":           {"OLLAMA_ORIGINS", Origins(), "A comma separated list of allowed origins"},
-		"OLLAMA_SCHED_SPREAD":      {"OLLAMA_SCHED_SPREAD", SchedSpread(), "Always schedule model across all GPUs"},
-		"OLLAMA_MULTIUSER_CACHE":   {"OLLAMA_MULTIUSER_CACHE", MultiUserCache(), "Optimize prompt caching for multi-user scenarios"},
+		"OLLAMA_AGENT_TOOLS":        {"OLLAMA_AGENT_TOOLS", AgentTools(), "Path to a JSON file of tools and MCP servers the agent endpoint can call"},
+		"OLLAMA_AUDIT_LOG":          {"OLLAMA_AUDIT_LOG", AuditLog(), "File or webhook URL requests are audited to"},
+		"OLLAMA_AUDIT_LOG_CONTENT":  {"OLLAMA_AUDIT_LOG_CONTENT", AuditLogContent(), "Whether audit records include prompts and responses: none, redacted or full (default: none)"},
+		"OLLAMA_AUDIT_LOG_MAX_SIZE": {"OLLAMA_AUDIT_LOG_MAX_SIZE", AuditLogMaxSize(), "Size in bytes audit log files are rotated at (default: 100MiB)"},
+		"OLLAMA_DEBUG":              {"OLLAMA_DEBUG", Debug(), "Show additional debug information (e.g. OLLAMA_DEBUG=1)"},
+		"OLLAMA_FLASH_ATTENTION":    {"OLLAMA_FLASH_ATTENTION", FlashAttention(), "Enabled flash attention"},
+		"OLLAMA_KV_CACHE_TYPE":      {"OLLAMA_KV_CACHE_TYPE", KvCacheType(), "Quantization type for the K/V cache (default: f16)"},
+		"OLLAMA_GPU_OVERHEAD":       {"OLLAMA_GPU_OVERHEAD", GpuOverhead(), "Reserve a portion of VRAM per GPU (bytes)"},
+		"OLLAMA_GPU_RESERVE":        {"OLLAMA_GPU_RESERVE", GpuReserve(), "VRAM left free for other applications (e.g. 2GiB or 15%)"},
+		"OLLAMA_GPU_YIELD":          {"OLLAMA_GPU_YIELD", GpuYield(), "Release VRAM while a full-screen application is running on Windows"},
+		"OLLAMA_HTTP2":              {"OLLAMA_HTTP2", HTTP2(), "Enable HTTP/2 without TLS (h2c)"},
+		"OLLAMA_FLUSH_INTERVAL":     {"OLLAMA_FLUSH_INTERVAL", FlushInterval(), "How long streamed responses are buffered before they are flushed (default 0)"},
+		"OLLAMA_WRITE_TIMEOUT":      {"OLLAMA_WRITE_TIMEOUT", WriteTimeout(), "How long writing to a streaming client may stall before the stream is closed"},
+		"OLLAMA_HOST":               {"OLLAMA_HOST", Host(), "Comma-separated addresses for the ollama server (default 127.0.0.1:11434)"},
+		"OLLAMA_KEEP_ALIVE":         {"OLLAMA_KEEP_ALIVE", KeepAlive(), "The duration that models stay loaded in memory (default \"5m\")"},
+		"OLLAMA_LLM_LIBRARY":        {"OLLAMA_LLM_LIBRARY", LLMLibrary(), "Set LLM library to bypass autodetection"},
+		"OLLAMA_LOAD_TIMEOUT":       {"OLLAMA_LOAD_TIMEOUT", LoadTimeout(), "How long to allow model loads to stall before giving up (default \"5m\")"},
+		"OLLAMA_MAX_LOADED_MODELS":  {"OLLAMA_MAX_LOADED_MODELS", MaxRunners(), "Maximum number of loaded models per GPU"},
+		"OLLAMA_MAX_IMAGES":         {"OLLAMA_MAX_IMAGES", MaxImages(), "Maximum number of images per message for Llama 3.2 Vision models"},
+		"OLLAMA_MAX_QUEUE":          {"OLLAMA_MAX_QUEUE", MaxQueue(), "Maximum number of queued requests"},
+		"OLLAMA_MODELS":             {"OLLAMA_MODELS", Models(), "The path to the models directory"},
+		"OLLAMA_PRELOAD":            {"OLLAMA_PRELOAD", Preload(), "Path to a JSON file of models to pull and load at startup"},
+		"OLLAMA_PREDICTIVE_PRELOAD": {"OLLAMA_PREDICTIVE_PRELOAD", PredictivePreload(), "Load models before they're requested based on usage patterns"},
+		"OLLAMA_PROVENANCE":         {"OLLAMA_PROVENANCE", Provenance(), "Add provenance headers to generate and chat responses"},
+		"OLLAMA_INSTANCE":           {"OLLAMA_INSTANCE", Instance(), "Name of the server in provenance headers (default: host name)"},
+		"OLLAMA_WATERMARK_KEY":      {"OLLAMA_WATERMARK_KEY", redact(WatermarkKey()), "Secret key of the watermark models sample with"},
+		"OLLAMA_SHARED_BLOBS":       {"OLLAMA_SHARED_BLOBS", SharedBlobs(), "The path to a read-only directory of model blobs shared between servers"},
+		"OLLAMA_SHARED_MODELS":      {"OLLAMA_SHARED_MODELS", SharedModels(), "The path to a read-only models directory shared between users"},
+		"OLLAMA_STORE":              {"OLLAMA_STORE", Store(), "The URL of a storage backend models are mirrored to"},
+		"OLLAMA_BACKEND":            {"OLLAMA_BACKEND", Backend(), "Inference backends models are run with (e.g. llama=custom,gemma2=llama)"},
+		"OLLAMA_FALLBACK_HOSTS":     {"OLLAMA_FALLBACK_HOSTS", FallbackHosts(), "Hosts of the remote servers models may fall back to (comma-separated)"},
+		"OLLAMA_REMOTE_KEYS":        {"OLLAMA_REMOTE_KEYS", redact(RemoteKeys()), "API keys of the servers remote models generate with (host=key, comma-separated)"},
+		"OLLAMA_NOHISTORY":          {"OLLAMA_NOHISTORY", NoHistory(), "Do not preserve readline history"},
+		"OLLAMA_NOPRUNE":            {"OLLAMA_NOPRUNE", NoPrune(), "Do not prune model blobs on startup"},
+		"OLLAMA_NUM_PARALLEL":       {"OLLAMA_NUM_PARALLEL", NumParallel(), "Maximum number of parallel requests"},
+		"OLLAMA_MAX_PARALLEL":       {"OLLAMA_MAX_PARALLEL", MaxParallel(), "Scale parallel requests of each model up to this many with load (default 0, off)"},
+		"OLLAMA_ORIGINS":            {"OLLAMA_ORIGINS", Origins(), "A comma separated list of allowed origins"},
+		"OLLAMA_TRUSTED_PROXIES":    {"OLLAMA_TRUSTED_PROXIES", TrustedProxies(), "A comma separated list of proxies whose X-Forwarded-For headers are trusted"},
+		"OLLAMA_SCHED_SPREAD":       {"OLLAMA_SCHED_SPREAD", SchedSpread(), "Always schedule model across all GPUs"},
+		"OLLAMA_MULTIUSER_CACHE":    {"OLLAMA_MULTIUSER_CACHE", MultiUserCache(), "Optimize prompt caching for multi-user scenarios"},
+		"OLLAMA_REGISTRY_CACHE":     {"OLLAMA_REGISTRY_CACHE", RegistryCache(), "Serve models to other Ollama instances as a registry cache"},
+		"OLLAMA_REGISTRY_CONFIG":    {"OLLAMA_REGISTRY_CONFIG", RegistryConfig(), "Path to per registry proxy and CA certificate settings"},
+		"OLLAMA_TRANSFER_LIMIT":     {"OLLAMA_TRANSFER_LIMIT", TransferLimit(), "Bandwidth limit for pulls and pushes (e.g. 10MB or 09:00-17:00=10MB)"},
+		"OLLAMA_CAPABILITY_DEVICES": {"OLLAMA_CAPABILITY_DEVICES", CapabilityDevices(), "Devices used by embedding and completion models (e.g. embedding=cpu;completion=0)"},
+		"OLLAMA_REQUEST_LIMITS":     {"OLLAMA_REQUEST_LIMITS", RequestLimits(), "Concurrency and rate limits per endpoint (e.g. /v1/*:concurrency=4,rate=60/m)"},
+		"OLLAMA_TOKEN_QUOTAS":       {"OLLAMA_TOKEN_QUOTAS", TokenQuotas(), "Tokens each client can use per minute and per day (e.g. *:minute=10000,day=1000000)"},
+		"OLLAMA_API_KEYS":           {"OLLAMA_API_KEYS", redacted(APIKeys()), "Comma separated API keys with every scope that clients must send"},
+		"OLLAMA_API_KEYS_FILE":      {"OLLAMA_API_KEYS_FILE", APIKeysFile(), "Path to a JSON file of API keys and their scopes"},
+		"OLLAMA_METRICS_LABELS":     {"OLLAMA_METRICS_LABELS", MetricsLabels(), "Comma separated request metadata keys completion metrics are labeled with"},
+		"OLLAMA_MEMORY_PRESSURE":    {"OLLAMA_MEMORY_PRESSURE", MemoryPressure(), "Available memory thresholds for shrinking and evicting idle models on macOS (e.g. shrink=20,evict=10, default off)"},
+		"OLLAMA_MEMORY_EMBED_MODEL": {"OLLAMA_MEMORY_EMBED_MODEL", MemoryEmbedModel(), "Embedding model that embeds chat memories (default the chat model)"},
+		"OLLAMA_TTFT_TARGET":        {"OLLAMA_TTFT_TARGET", TTFTTarget(), "Reject streaming requests projected to wait longer for a first token (e.g. \"2s\")"},
+		"OLLAMA_SESSION_TTL":        {"OLLAMA_SESSION_TTL", SessionTTL(), "How long chat sessions are kept after they were last used (default \"24h\")"},
+		"OLLAMA_CACHE_TTL":          {"OLLAMA_CACHE_TTL", CacheTTL(), "How long cached prompts are kept after they were last used (default: unlimited)"},
+
+		// OpenTelemetry
+		"OTEL_EXPORTER_OTLP_ENDPOINT":        {"OTEL_EXPORTER_OTLP_ENDPOINT", String("OTEL_EXPORTER_OTLP_ENDPOINT")(), "URL of the OpenTelemetry collector traces are exported to"},
+		"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT": {"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", String("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")(), "URL traces are exported to, overriding OTEL_EXPORTER_OTLP_ENDPOINT"},
+		"OTEL_SERVICE_NAME":                  {"OTEL_SERVICE_NAME", OtelServiceName(), "Service name of exported traces (default: ollama)"},
 
 		// Informational
 		"HTTP_PROXY":  {"HTTP_PROXY", String("HTTP_PROXY")(), "HTTP proxy"},
@@ -266,6 +637,7 @@ func AsMap() map[string]EnvVar {
 
 	if runtime.GOOS != "darwin" {
 		ret["CUDA_VISIBLE_DEVICES"] = EnvVar{"CUDA_VISIBLE_DEVICES", CudaVisibleDevices(), "Set which NVIDIA devices are visible"}
+		ret["NVIDIA_VISIBLE_DEVICES"] = EnvVar{"NVIDIA_VISIBLE_DEVICES", NvidiaVisibleDevices(), "Set which NVIDIA devices the container runtime exposes"}
 		ret["HIP_VISIBLE_DEVICES"] = EnvVar{"HIP_VISIBLE_DEVICES", HipVisibleDevices(), "Set which AMD devices are visible by numeric ID"}
 		ret["ROCR_VISIBLE_DEVICES"] = EnvVar{"ROCR_VISIBLE_DEVICES", RocrVisibleDevices(), "Set which AMD devices are visible by UUID or numeric ID"}
 		ret["GPU_DEVICE_ORDINAL"] = EnvVar{"GPU_DEVICE_ORDINAL", GpuDeviceOrdinal(), "Set which AMD devices are visible by numeric ID"}
@@ -284,6 +656,16 @@ func Values() map[string]string {
 	return vals
 }
 
+// redact hides the value of a variable holding secrets, which are logged
+// with the other values
+func redact(s string) string {
+	if s == "" {
+		return ""
+	}
+
+	return "redacted"
+}
+
 // Var returns an environment variable stripped of leading and trailing quotes or spaces
 func Var(key string) string {
 	return strings.Trim(strings.TrimSpace(os.Getenv(key)), "\"'")
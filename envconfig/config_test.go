@@ -47,6 +47,99 @@ func TestHost(t *testing.T) {
 	}
 }
 
+func TestSocket(t *testing.T) {
+	cases := map[string]string{
+		"":                        "",
+		"127.0.0.1:11434":         "",
+		"unix:///run/ollama.sock": "/run/ollama.sock",
+		"unix:///run/a.sock?key_file=k,unix:///b": "/run/a.sock",
+		"unix:///run/ollama.sock,127.0.0.1:11434": "",
+	}
+
+	for value, expect := range cases {
+		t.Run(value, func(t *testing.T) {
+			t.Setenv("OLLAMA_HOST", value)
+			if socket := Socket(); socket != expect {
+				t.Errorf("expected %q, got %q", expect, socket)
+			}
+		})
+	}
+}
+
+func TestHostKeyFile(t *testing.T) {
+	cases := map[string]string{
+		"":                                    "",
+		"127.0.0.1:11434":                     "",
+		"0.0.0.0:8080?key_file=/etc/keys":     "/etc/keys",
+		"127.0.0.1,0.0.0.0?key_file=k":        "",
+		"unix:///a.sock?key_file=a,unix:///b": "a",
+		"unix:///a.sock?key_file=a,[::]?key_file=b": "b",
+	}
+
+	for value, expect := range cases {
+		t.Run(value, func(t *testing.T) {
+			t.Setenv("OLLAMA_HOST", value)
+			if path := HostKeyFile(); path != expect {
+				t.Errorf("expected %q, got %q", expect, path)
+			}
+		})
+	}
+}
+
+func TestListeners(t *testing.T) {
+	cases := map[string]struct {
+		value  string
+		expect []Listener
+		host   string
+	}{
+		"empty": {"", []Listener{{Network: "tcp", Address: "127.0.0.1:11434"}}, "http://127.0.0.1:11434"},
+		"one":   {"0.0.0.0", []Listener{{Network: "tcp", Address: "0.0.0.0:11434"}}, "http://0.0.0.0:11434"},
+		"several": {
+			"127.0.0.1:11434, [::1]:11434,unix:///run/ollama.sock",
+			[]Listener{
+				{Network: "tcp", Address: "127.0.0.1:11434"},
+				{Network: "tcp", Address: "[::1]:11434"},
+				{Network: "unix", Address: "/run/ollama.sock"},
+			},
+			"http://127.0.0.1:11434",
+		},
+		"socket first": {
+			"unix:///run/ollama.sock,[::]:8080",
+			[]Listener{
+				{Network: "unix", Address: "/run/ollama.sock"},
+				{Network: "tcp", Address: "[::]:8080"},
+			},
+			"http://[::]:8080",
+		},
+		"key file": {
+			"127.0.0.1,0.0.0.0:8080?key_file=/etc/ollama/keys",
+			[]Listener{
+				{Network: "tcp", Address: "127.0.0.1:11434"},
+				{Network: "tcp", Address: "0.0.0.0:8080", KeyFile: "/etc/ollama/keys"},
+			},
+			"http://127.0.0.1:11434",
+		},
+		"https key file": {
+			"https://example.com?key_file=keys",
+			[]Listener{{Network: "tcp", Address: "example.com:443", KeyFile: "keys"}},
+			"https://example.com:443",
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv("OLLAMA_HOST", tt.value)
+			if diff := cmp.Diff(Listeners(), tt.expect); diff != "" {
+				t.Errorf("%s: mismatch (-got +want):\n%s", name, diff)
+			}
+
+			if host := Host(); host.String() != tt.host {
+				t.Errorf("%s: expected host %s, got %s", name, tt.host, host.String())
+			}
+		})
+	}
+}
+
 func TestOrigins(t *testing.T) {
 	cases := []struct {
 		value  string
@@ -253,6 +346,67 @@ func TestLoadTimeout(t *testing.T) {
 	}
 }
 
+func TestSessionTTL(t *testing.T) {
+	cases := map[string]time.Duration{
+		"":    24 * time.Hour,
+		"1h":  time.Hour,
+		"90":  90 * time.Second,
+		"0":   time.Duration(math.MaxInt64),
+		"-1m": time.Duration(math.MaxInt64),
+		"???": 24 * time.Hour,
+	}
+
+	for tt, expect := range cases {
+		t.Run(tt, func(t *testing.T) {
+			t.Setenv("OLLAMA_SESSION_TTL", tt)
+			if actual := SessionTTL(); actual != expect {
+				t.Errorf("%s: expected %s, got %s", tt, expect, actual)
+			}
+		})
+	}
+}
+
+func TestCacheTTL(t *testing.T) {
+	cases := map[string]time.Duration{
+		"":    0,
+		"10m": 10 * time.Minute,
+		"30":  30 * time.Second,
+		"-1":  0,
+		"???": 0,
+	}
+
+	for tt, expect := range cases {
+		t.Run(tt, func(t *testing.T) {
+			t.Setenv("OLLAMA_CACHE_TTL", tt)
+			if actual := CacheTTL(); actual != expect {
+				t.Errorf("%s: expected %s, got %s", tt, expect, actual)
+			}
+		})
+	}
+}
+
+func TestTTFTTarget(t *testing.T) {
+	cases := map[string]time.Duration{
+		"":      0,
+		"2s":    2 * time.Second,
+		"500ms": 500 * time.Millisecond,
+		"3":     3 * time.Second,
+		"1.5":   1500 * time.Millisecond,
+		"0":     0,
+		"-1s":   0,
+		"???":   0,
+	}
+
+	for tt, expect := range cases {
+		t.Run(tt, func(t *testing.T) {
+			t.Setenv("OLLAMA_TTFT_TARGET", tt)
+			if actual := TTFTTarget(); actual != expect {
+				t.Errorf("%s: expected %s, got %s", tt, expect, actual)
+			}
+		})
+	}
+}
+
 func TestVar(t *testing.T) {
 	cases := map[string]string{
 		"value":       "value",
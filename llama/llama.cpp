@@ -2805,6 +2805,13 @@ struct llama_cparams {
     // and not set on the context for all batches.
     bool cross_attn = false;
 
+    // mllama models can attend to several consecutive images. cross_attn_image is
+    // the slot the next image is stored in and n_cross_attn_images is the number
+    // of slots attended to.
+    uint32_t n_cross_attn_images_max = 1;
+    int32_t  cross_attn_image        = 0;
+    int32_t  n_cross_attn_images     = 1;
+
     enum llama_pooling_type pooling_type;
 
     ggml_backend_sched_eval_callback cb_eval;
@@ -3735,8 +3742,8 @@ static bool llama_kv_cache_init(
                 LLAMA_LOG_ERROR("%s: failed to create ggml context for kv cache\n", __func__);
                 return false;
             }
-            ggml_tensor * k = ggml_new_tensor_3d(ctx, GGML_TYPE_F32, hparams.n_embd_head_k, 6404, hparams.n_head_kv(i));
-            ggml_tensor * v = ggml_new_tensor_3d(ctx, GGML_TYPE_F32, hparams.n_embd_head_v, 6404, hparams.n_head_kv(i));
+            ggml_tensor * k = ggml_new_tensor_3d(ctx, GGML_TYPE_F32, hparams.n_embd_head_k, 6404*cparams.n_cross_attn_images_max, hparams.n_head_kv(i));
+            ggml_tensor * v = ggml_new_tensor_3d(ctx, GGML_TYPE_F32, hparams.n_embd_head_v, 6404*cparams.n_cross_attn_images_max, hparams.n_head_kv(i));
             ggml_format_name(k, "cache_k_l%d", i);
             ggml_format_name(v, "cache_v_l%d", i);
             cache.k_l.push_back(k);
@@ -11307,7 +11314,10 @@ struct llm_build_context {
                     Kcur = llm_build_norm(ctx0, Kcur, hparams, model.layers[il].cross_attn_k_norm, NULL, LLM_NORM_RMS, cb, il);
                     cb(Kcur, "Kcur", il);
 
-                    ggml_build_forward_expand(gf, ggml_cpy(ctx0, Kcur, kv_self.k_l[il]));
+                    ggml_build_forward_expand(gf, ggml_cpy(ctx0, Kcur, ggml_view_3d(ctx0, kv_self.k_l[il],
+                                    n_embd_head, 6404, n_head_kv,
+                                    kv_self.k_l[il]->nb[1], kv_self.k_l[il]->nb[2],
+                                    kv_self.k_l[il]->nb[1]*6404*cparams.cross_attn_image)));
 
                     Vcur = ggml_mul_mat(ctx0, model.layers[il].cross_attn_v_proj, inpCAS);
                     cb(Vcur, "Vcur", il);
@@ -11318,13 +11328,27 @@ struct llm_build_context {
                     Vcur = ggml_permute(ctx0, Vcur, 0, 2, 1, 3);
                     cb(Vcur, "Vcur", il);
 
-                    ggml_build_forward_expand(gf, ggml_cpy(ctx0, Vcur, kv_self.v_l[il]));
-                } else {
+                    ggml_build_forward_expand(gf, ggml_cpy(ctx0, Vcur, ggml_view_3d(ctx0, kv_self.v_l[il],
+                                    n_embd_head, 6404, n_head_kv,
+                                    kv_self.v_l[il]->nb[1], kv_self.v_l[il]->nb[2],
+                                    kv_self.v_l[il]->nb[1]*6404*cparams.cross_attn_image)));
+                } else if ((uint32_t) cparams.n_cross_attn_images == cparams.n_cross_attn_images_max) {
                     Kcur = ggml_view_tensor(ctx0, kv_self.k_l[il]);
                     cb(Kcur, "Kcur (view)", il);
 
                     Vcur = ggml_view_tensor(ctx0, kv_self.v_l[il]);
                     cb(Vcur, "Vcur (view)", il);
+                } else {
+                    // attend to the images in the first n_cross_attn_images slots
+                    Kcur = ggml_cont(ctx0, ggml_view_3d(ctx0, kv_self.k_l[il],
+                                n_embd_head, 6404*cparams.n_cross_attn_images, n_head_kv,
+                                kv_self.k_l[il]->nb[1], kv_self.k_l[il]->nb[2], 0));
+                    cb(Kcur, "Kcur (view)", il);
+
+                    Vcur = ggml_view_3d(ctx0, kv_self.v_l[il],
+                                n_embd_head, 6404*cparams.n_cross_attn_images, n_head_kv,
+                                kv_self.v_l[il]->nb[1], kv_self.v_l[il]->nb[2], 0);
+                    cb(Vcur, "Vcur (view)", il);
                 }
 
                 struct ggml_tensor * kq = ggml_mul_mat(ctx0, Kcur, Qcur);
@@ -20166,6 +20190,7 @@ struct llama_context_params llama_context_default_params() {
         /*.cb_eval_user_data           =*/ nullptr,
         /*.type_k                      =*/ GGML_TYPE_F16,
         /*.type_v                      =*/ GGML_TYPE_F16,
+        /*.n_cross_attn_images         =*/ 1,
         /*.logits_all                  =*/ false,
         /*.embeddings                  =*/ false,
         /*.offload_kqv                 =*/ true,
@@ -20442,6 +20467,9 @@ struct llama_context * llama_new_context_with_model(
     cparams.offload_kqv      = params.offload_kqv;
     cparams.flash_attn       = params.flash_attn;
     cparams.no_perf          = params.no_perf;
+
+    cparams.n_cross_attn_images_max = std::max(params.n_cross_attn_images, 1u);
+    cparams.n_cross_attn_images     = cparams.n_cross_attn_images_max;
     cparams.pooling_type     = params.pooling_type;
 
     cparams.n_ctx            = params.n_ctx           == 0    ? hparams.n_ctx_train           : params.n_ctx;
@@ -22235,6 +22263,11 @@ void llama_set_abort_callback(struct llama_context * ctx, bool (*abort_callback)
     }
 }
 
+void llama_set_eval_callback(struct llama_context * ctx, ggml_backend_sched_eval_callback cb_eval, void * cb_eval_user_data) {
+    ctx->cparams.cb_eval           = cb_eval;
+    ctx->cparams.cb_eval_user_data = cb_eval_user_data;
+}
+
 void llama_set_embeddings(struct llama_context * ctx, bool embeddings) {
     ctx->cparams.embeddings = embeddings;
 }
@@ -22247,6 +22280,14 @@ void llama_set_cross_attention(struct llama_context * ctx, bool cross_attention)
     ctx->cparams.cross_attn = cross_attention;
 }
 
+void llama_set_cross_attention_images(struct llama_context * ctx, int32_t image, int32_t n_images) {
+    GGML_ASSERT(image >= 0 && (uint32_t) image < ctx->cparams.n_cross_attn_images_max);
+    GGML_ASSERT(n_images > 0 && (uint32_t) n_images <= ctx->cparams.n_cross_attn_images_max);
+
+    ctx->cparams.cross_attn_image    = image;
+    ctx->cparams.n_cross_attn_images = n_images;
+}
+
 struct llama_batch llama_batch_get_one(
              llama_token * tokens,
                  int32_t   n_tokens) {
@@ -67,6 +67,7 @@ package llama
 #include "llava.h"
 #include "mllama.h"
 #include "sampling_ext.h"
+#include "profile_ext.h"
 
 extern bool llamaProgressCallback(float progress, void *user_data);
 extern void llamaLog(int level, char* text, void* user_data);
@@ -95,6 +96,7 @@ import (
 	"slices"
 	"strings"
 	"sync/atomic"
+	"time"
 	"unsafe"
 )
 
@@ -176,6 +178,12 @@ func NewContextParams(numCtx int, batchSize int, numSeqMax int, threads int, fla
 	return ContextParams{c: params}
 }
 
+// SetCrossAttentionImages sets the number of consecutive images the cross
+// attention state of mllama models can hold
+func (p *ContextParams) SetCrossAttentionImages(n int) {
+	p.c.n_cross_attn_images = C.uint(n)
+}
+
 // kvCacheTypeFromStr converts a string cache type to the corresponding GGML type value
 func kvCacheTypeFromStr(s string) C.enum_ggml_type {
 	if s == "" {
@@ -195,10 +203,62 @@ func kvCacheTypeFromStr(s string) C.enum_ggml_type {
 type Context struct {
 	c          *C.struct_llama_context
 	numThreads int
+
+	// profile records the graphs computed while profiling, if it is
+	profile *C.struct_llama_profile
 }
 
 var ErrKvCacheFull = errors.New("could not find a kv cache slot")
 
+// ProfileOp is the time spent computing the tensors of a graph with the same
+// name and operation while a context was profiled. Names of tensors in a
+// layer end with the layer's index, such as "attn_norm-3".
+type ProfileOp struct {
+	Name     string
+	Op       string
+	Count    int
+	Duration time.Duration
+}
+
+// StartProfile starts recording the time spent computing each tensor of the
+// graphs the context decodes. Tensors are computed one at a time while
+// profiling, so decoding is slower.
+func (c *Context) StartProfile() {
+	if c.profile != nil {
+		return
+	}
+
+	c.profile = C.llama_profile_init()
+	C.llama_set_eval_callback(c.c, C.ggml_backend_sched_eval_callback(C.llama_profile_eval), unsafe.Pointer(c.profile))
+}
+
+// StopProfile stops profiling, returning the operations recorded since
+// StartProfile
+func (c *Context) StopProfile() []ProfileOp {
+	if c.profile == nil {
+		return nil
+	}
+
+	C.llama_set_eval_callback(c.c, nil, nil)
+
+	ops := make([]ProfileOp, C.llama_profile_n_ops(c.profile))
+	for i := range ops {
+		var name, op *C.char
+		var count, ns C.int64_t
+		C.llama_profile_op(c.profile, C.int(i), &name, &op, &count, &ns)
+		ops[i] = ProfileOp{
+			Name:     C.GoString(name),
+			Op:       C.GoString(op),
+			Count:    int(count),
+			Duration: time.Duration(ns),
+		}
+	}
+
+	C.llama_profile_free(c.profile)
+	c.profile = nil
+	return ops
+}
+
 func (c *Context) Decode(batch *Batch) error {
 	// Positive return values does not mean a fatal error, but rather a warning.
 	//   0 - success
@@ -233,6 +293,33 @@ func (c *Context) KvCacheSeqCp(srcSeqId int, dstSeqId int, p0 int, p1 int) {
 	C.llama_kv_cache_seq_cp(c.c, C.int(srcSeqId), C.int(dstSeqId), C.int(p0), C.int(p1))
 }
 
+// StateSeqGetData returns the KV cache of a sequence, which can be restored
+// to another context of the same model with StateSeqSetData
+func (c *Context) StateSeqGetData(seqId int) []byte {
+	size := C.llama_state_seq_get_size(c.c, C.llama_seq_id(seqId))
+	if size == 0 {
+		return nil
+	}
+
+	data := make([]byte, size)
+	n := C.llama_state_seq_get_data(c.c, (*C.uint8_t)(unsafe.Pointer(&data[0])), size, C.llama_seq_id(seqId))
+	return data[:n]
+}
+
+// StateSeqSetData restores the KV cache of a sequence returned by
+// StateSeqGetData to the sequence seqId
+func (c *Context) StateSeqSetData(data []byte, seqId int) error {
+	if len(data) == 0 {
+		return nil
+	}
+
+	if C.llama_state_seq_set_data(c.c, (*C.uint8_t)(unsafe.Pointer(&data[0])), C.size_t(len(data)), C.llama_seq_id(seqId)) == 0 {
+		return fmt.Errorf("failed to restore the KV cache of sequence %d", seqId)
+	}
+
+	return nil
+}
+
 func (c *Context) KvCacheClear() {
 	C.llama_kv_cache_clear(c.c)
 }
@@ -241,16 +328,27 @@ func (c *Context) KvCacheDefrag() {
 	C.llama_kv_cache_defrag(c.c)
 }
 
-// Get the embeddings for a sequence id
+// Get the embeddings for a sequence id. Reranking models return the score of
+// the sequence instead.
 func (c *Context) GetEmbeddingsSeq(seqId int) []float32 {
 	embeddings := unsafe.Pointer(C.llama_get_embeddings_seq(c.c, C.int(seqId)))
 	if embeddings == nil {
 		return nil
 	}
 
+	if c.Ranks() {
+		return unsafe.Slice((*float32)(embeddings), 1)
+	}
+
 	return unsafe.Slice((*float32)(embeddings), c.Model().NEmbd())
 }
 
+// Ranks reports whether the model is a reranker, which scores sequences
+// rather than embedding them
+func (c *Context) Ranks() bool {
+	return C.llama_pooling_type(c.c) == C.LLAMA_POOLING_TYPE_RANK
+}
+
 func (c *Context) GetEmbeddingsIth(i int) []float32 {
 	embeddings := unsafe.Pointer(C.llama_get_embeddings_ith(c.c, C.int32_t(i)))
 	if embeddings == nil {
@@ -260,6 +358,17 @@ func (c *Context) GetEmbeddingsIth(i int) []float32 {
 	return unsafe.Slice((*float32)(embeddings), c.Model().NEmbd())
 }
 
+// GetLogitsIth returns the logits of the ith token in the last batch, which
+// can be modified before sampling
+func (c *Context) GetLogitsIth(i int) []float32 {
+	logits := unsafe.Pointer(C.llama_get_logits_ith(c.c, C.int32_t(i)))
+	if logits == nil {
+		return nil
+	}
+
+	return unsafe.Slice((*float32)(logits), c.Model().NumVocab())
+}
+
 type ModelParams struct {
 	NumGpuLayers int
 	MainGpu      int
@@ -332,6 +441,12 @@ func NewContextWithModel(model *Model, params ContextParams) (*Context, error) {
 	return &c, nil
 }
 
+// Free releases the context and its KV cache. The model it was created with
+// stays loaded.
+func (c *Context) Free() {
+	C.llama_free(c.c)
+}
+
 func (m *Model) NumVocab() int {
 	return int(C.llama_n_vocab(m.c))
 }
@@ -648,6 +763,13 @@ func (c *Context) SetCrossAttention(state bool) {
 	C.llama_set_cross_attention(c.c, C.bool(state))
 }
 
+// SetCrossAttentionImages sets the slot of the cross attention state the next
+// image embedding is stored in, and the number of images following tokens
+// attend to
+func (c *Context) SetCrossAttentionImages(image, n int) {
+	C.llama_set_cross_attention_images(c.c, C.int32_t(image), C.int32_t(n))
+}
+
 func (c *Context) Synchronize() {
 	C.llama_synchronize(c.c)
 }
@@ -719,6 +841,19 @@ func (s *SamplingContext) Accept(id int, applyGrammar bool) {
 	C.common_sampler_caccept(s.c, C.llama_token(id), C.bool(applyGrammar))
 }
 
+// ValidateGrammar returns an error if grammar isn't a GBNF grammar with a
+// root rule. The reason it's invalid is logged.
+func ValidateGrammar(grammar string) error {
+	cStr := C.CString(grammar)
+	defer C.free(unsafe.Pointer(cStr))
+
+	if !C.grammar_validate(cStr) {
+		return errors.New("invalid grammar, it must be valid GBNF with a root rule")
+	}
+
+	return nil
+}
+
 // SchemaToGrammar converts the provided JSON schema to a grammar. It returns
 // nil if the provided schema is invalid JSON or an invalid JSON schema.
 func SchemaToGrammar(schema []byte) []byte {
@@ -366,6 +366,8 @@ extern "C" {
         enum ggml_type type_k; // data type for K cache [EXPERIMENTAL]
         enum ggml_type type_v; // data type for V cache [EXPERIMENTAL]
 
+        uint32_t n_cross_attn_images; // number of images the cross attention state can hold
+
         // Keep the booleans together and at the end of the struct to avoid misalignment during copy-by-value.
         // TODO: move at the end of the struct
         bool logits_all;  // the llama_decode() call computes all logits, not just the last one (DEPRECATED - set llama_batch.logits instead)
@@ -454,6 +456,10 @@ extern "C" {
     // and not set on the context for all batches.
     LLAMA_API void llama_set_cross_attention(struct llama_context * ctx, bool cross_attn_state);
 
+    // Set the slot of the cross attention state the next image is stored in, and the
+    // number of images from the first slot that following tokens attend to
+    LLAMA_API void llama_set_cross_attention_images(struct llama_context * ctx, int32_t image, int32_t n_images);
+
     // Frees all allocated memory
     LLAMA_API void llama_free(struct llama_context * ctx);
 
@@ -874,6 +880,10 @@ extern "C" {
     // Set abort callback
     LLAMA_API void llama_set_abort_callback(struct llama_context * ctx, ggml_abort_callback abort_callback, void * abort_callback_data);
 
+    // Set the callback called for the tensors of the graphs computed by the context, replacing
+    // cb_eval of the context params. A null callback computes graphs without observing them.
+    LLAMA_API void llama_set_eval_callback(struct llama_context * ctx, ggml_backend_sched_eval_callback cb_eval, void * cb_eval_user_data);
+
     // Wait until all computations are finished
     // This is automatically done when using one of the functions below to obtain the computation results
     // and is not necessary to call it explicitly in most cases
@@ -0,0 +1,66 @@
+// TODO: this is a temporary wrapper to allow calling C++ code from CGo
+#include <chrono>
+#include <map>
+#include <string>
+#include <utility>
+#include <vector>
+
+#include "ggml.h"
+#include "profile_ext.h"
+
+struct llama_profile_record {
+    std::string name;
+    std::string op;
+    int64_t count = 0;
+    int64_t ns = 0;
+};
+
+struct llama_profile {
+    std::chrono::steady_clock::time_point start;
+    std::map<std::pair<std::string, std::string>, size_t> index;
+    std::vector<llama_profile_record> records;
+};
+
+struct llama_profile *llama_profile_init(void) {
+    return new llama_profile;
+}
+
+void llama_profile_free(struct llama_profile *profile) {
+    delete profile;
+}
+
+bool llama_profile_eval(struct ggml_tensor *t, bool ask, void *user_data) {
+    auto *profile = static_cast<llama_profile *>(user_data);
+    auto now = std::chrono::steady_clock::now();
+
+    // asking for every tensor makes the scheduler compute and synchronize
+    // each one on its own, so the time between the calls is its own
+    if (ask) {
+        profile->start = now;
+        return true;
+    }
+
+    auto key = std::make_pair(std::string(ggml_get_name(t)), std::string(ggml_op_desc(t)));
+    auto it = profile->index.find(key);
+    if (it == profile->index.end()) {
+        it = profile->index.emplace(key, profile->records.size()).first;
+        profile->records.push_back({key.first, key.second, 0, 0});
+    }
+
+    auto &record = profile->records[it->second];
+    record.count++;
+    record.ns += std::chrono::duration_cast<std::chrono::nanoseconds>(now - profile->start).count();
+    return true;
+}
+
+int llama_profile_n_ops(struct llama_profile *profile) {
+    return (int) profile->records.size();
+}
+
+void llama_profile_op(struct llama_profile *profile, int i, const char **name, const char **op, int64_t *count, int64_t *ns) {
+    const auto &record = profile->records[i];
+    *name = record.name.c_str();
+    *op = record.op.c_str();
+    *count = record.count;
+    *ns = record.ns;
+}
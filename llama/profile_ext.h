@@ -0,0 +1,36 @@
+// TODO: this is a temporary wrapper to allow calling C++ code from CGo
+#ifndef PROFILE_EXT_H
+#define PROFILE_EXT_H
+
+#include <stdbool.h>
+#include <stdint.h>
+
+#ifdef __cplusplus
+extern "C"
+{
+#endif
+
+    struct ggml_tensor;
+
+    // llama_profile records the time spent computing each tensor of the graphs
+    // of a context while it's set as the context's eval callback
+    struct llama_profile;
+
+    struct llama_profile *llama_profile_init(void);
+    void llama_profile_free(struct llama_profile *profile);
+
+    // llama_profile_eval is the eval callback of a profile, which computes
+    // each tensor on its own and times it
+    bool llama_profile_eval(struct ggml_tensor *t, bool ask, void *user_data);
+
+    // llama_profile_n_ops returns the number of operations recorded, which
+    // are looked up with llama_profile_op. The names are valid until the
+    // profile is freed.
+    int llama_profile_n_ops(struct llama_profile *profile);
+    void llama_profile_op(struct llama_profile *profile, int i, const char **name, const char **op, int64_t *count, int64_t *ns);
+
+#ifdef __cplusplus
+}
+#endif
+
+#endif // PROFILE_EXT_H
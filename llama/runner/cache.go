@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"log/slog"
 	"reflect"
+	"slices"
+	"sync/atomic"
 	"time"
 
 	"github.com/ollama/ollama/llama"
@@ -20,6 +22,10 @@ type InputCache struct {
 	// optimize cache eviction for multiple users
 	multiUserCache bool
 
+	// used is the number of inputs stored in all slots as of the last
+	// batch, so it can be read atomically without holding the lock
+	used int64
+
 	lc *llama.Context
 }
 
@@ -45,6 +51,97 @@ func NewInputCache(lc *llama.Context, kvSize int, numSlots int, multiUserCache b
 	}, nil
 }
 
+// updateUsage records the number of inputs stored in the cache. It must be
+// called with the lock that serializes cache operations held.
+func (c *InputCache) updateUsage() {
+	var used int
+	for _, slot := range c.slots {
+		used += len(slot.Inputs)
+	}
+
+	atomic.StoreInt64(&c.used, int64(used))
+}
+
+// Usage returns the number of inputs stored in the cache as of the last
+// batch and the number it can hold
+func (c *InputCache) Usage() (used, size int) {
+	return int(atomic.LoadInt64(&c.used)), c.numCtx * len(c.slots)
+}
+
+// ClearCacheSlot discards the inputs of a slot, so later prompts can't reuse
+// them
+func (c *InputCache) ClearCacheSlot(slot *InputCacheSlot) {
+	c.lc.KvCacheSeqRm(slot.Id, 0, -1)
+	slot.Inputs = slot.Inputs[:0]
+}
+
+// ClearIdleSlots discards the inputs of the slots that aren't in use and
+// were last used before cutoff, returning the number of slots cleared
+func (c *InputCache) ClearIdleSlots(cutoff time.Time) int {
+	var cleared int
+	for i := range c.slots {
+		slot := &c.slots[i]
+		if slot.InUse || len(slot.Inputs) == 0 || !slot.lastUsed.Before(cutoff) {
+			continue
+		}
+
+		c.ClearCacheSlot(slot)
+		cleared++
+	}
+
+	c.updateUsage()
+	return cleared
+}
+
+// resize returns a cache of numSlots slots in lc. The slots in use, and the
+// most recently used of the others that fit, are moved to it with their
+// inputs, calling move to move the KV cache of a slot to its new ID. It
+// returns the moved slots by their previous ID.
+func (c *InputCache) resize(lc *llama.Context, numSlots int, move func(from, to int) error) (*InputCache, map[int]*InputCacheSlot, error) {
+	cache, err := NewInputCache(lc, c.numCtx*numSlots, numSlots, c.multiUserCache)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	slots := make([]*InputCacheSlot, len(c.slots))
+	for i := range c.slots {
+		slots[i] = &c.slots[i]
+	}
+
+	slices.SortStableFunc(slots, func(a, b *InputCacheSlot) int {
+		if a.InUse != b.InUse {
+			if a.InUse {
+				return -1
+			}
+			return 1
+		}
+
+		return b.lastUsed.Compare(a.lastUsed)
+	})
+
+	if len(slots) > numSlots && slots[numSlots].InUse {
+		return nil, nil, fmt.Errorf("more than %d slots are in use", numSlots)
+	}
+
+	moved := make(map[int]*InputCacheSlot, min(len(slots), numSlots))
+	for i, old := range slots[:min(len(slots), numSlots)] {
+		slot := &cache.slots[i]
+		if len(old.Inputs) > 0 {
+			if err := move(old.Id, slot.Id); err != nil {
+				return nil, nil, err
+			}
+		}
+
+		slot.Inputs = old.Inputs
+		slot.InUse = old.InUse
+		slot.lastUsed = old.lastUsed
+		moved[old.Id] = slot
+	}
+
+	cache.updateUsage()
+	return cache, moved, nil
+}
+
 // Locking: Operations on InputCacheSlot (including finding one
 // through LoadCacheSlot) require a lock to be be held that serializes
 // these operations with each other and llama.Decode
@@ -1,6 +1,8 @@
 package runner
 
 import (
+	"errors"
+	"reflect"
 	"testing"
 	"time"
 )
@@ -290,3 +292,76 @@ func TestShiftDiscard(t *testing.T) {
 		})
 	}
 }
+
+func TestResize(t *testing.T) {
+	now := time.Now()
+	c := InputCache{
+		numCtx: 10,
+		slots: []InputCacheSlot{
+			{Id: 0, Inputs: []input{{token: 1}}, lastUsed: now.Add(-3 * time.Second)},
+			{Id: 1, Inputs: []input{{token: 2}}, InUse: true, lastUsed: now.Add(-4 * time.Second)},
+			{Id: 2},
+			{Id: 3, Inputs: []input{{token: 3}, {token: 4}}, lastUsed: now.Add(-time.Second)},
+		},
+	}
+
+	t.Run("down", func(t *testing.T) {
+		moves := make(map[int]int)
+		cache, moved, err := c.resize(nil, 2, func(from, to int) error {
+			moves[from] = to
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if cache.numCtx != 10 || len(cache.slots) != 2 {
+			t.Fatalf("numCtx %d, slots %d; want 10, 2", cache.numCtx, len(cache.slots))
+		}
+
+		// the slot in use first, then the most recently used
+		if want := map[int]int{1: 0, 3: 1}; !reflect.DeepEqual(moves, want) {
+			t.Errorf("moves %v; want %v", moves, want)
+		}
+
+		if moved[1] != &cache.slots[0] || !cache.slots[0].InUse || cache.slots[0].Inputs[0].token != 2 {
+			t.Errorf("slot in use not moved: %+v", cache.slots[0])
+		}
+
+		if len(cache.slots[1].Inputs) != 2 || cache.slots[1].InUse {
+			t.Errorf("cached prompt not moved: %+v", cache.slots[1])
+		}
+
+		if used, _ := cache.Usage(); used != 3 {
+			t.Errorf("used %d; want 3", used)
+		}
+	})
+
+	t.Run("up", func(t *testing.T) {
+		var moves int
+		cache, moved, err := c.resize(nil, 6, func(from, to int) error {
+			moves++
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(cache.slots) != 6 || len(moved) != 4 || moves != 3 {
+			t.Errorf("slots %d, moved %d, moves %d; want 6, 4, 3", len(cache.slots), len(moved), moves)
+		}
+	})
+
+	t.Run("in use", func(t *testing.T) {
+		c := InputCache{numCtx: 10, slots: []InputCacheSlot{{Id: 0, InUse: true}, {Id: 1, InUse: true}}}
+		if _, _, err := c.resize(nil, 1, func(from, to int) error { return nil }); err == nil {
+			t.Error("expected an error")
+		}
+	})
+
+	t.Run("move error", func(t *testing.T) {
+		if _, _, err := c.resize(nil, 2, func(from, to int) error { return errors.New("no memory") }); err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
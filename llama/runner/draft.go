@@ -0,0 +1,118 @@
+package runner
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/ollama/ollama/llama"
+)
+
+// draftModel drafts tokens for speculative decoding. It's a smaller model with
+// the same vocabulary as the model being run, which greedily predicts the next
+// few tokens of a sequence. The model then decodes them in one batch and keeps
+// the ones it would have sampled itself, so several tokens can be generated
+// for the cost of one decode.
+type draftModel struct {
+	model *llama.Model
+	lc    *llama.Context
+	batch *llama.Batch
+
+	// max is the most tokens drafted at a time
+	max int
+
+	// inputs are the tokens in the draft model's cache for each slot
+	inputs [][]int
+}
+
+func newDraftModel(path string, params llama.ModelParams, target *llama.Model, kvSize, batchSize, parallel, threads int, flashAttention bool, kvCacheType string, maxDraft int) (*draftModel, error) {
+	model, err := llama.LoadModelFromFile(path, params)
+	if err != nil {
+		return nil, err
+	}
+
+	if model.NumVocab() != target.NumVocab() {
+		return nil, fmt.Errorf("draft model has a vocabulary of %d tokens, the model has %d", model.NumVocab(), target.NumVocab())
+	}
+
+	ctxParams := llama.NewContextParams(kvSize, batchSize, parallel, threads, flashAttention, kvCacheType)
+	lc, err := llama.NewContextWithModel(model, ctxParams)
+	if err != nil {
+		return nil, err
+	}
+
+	batch, err := llama.NewBatch(batchSize, 1, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return &draftModel{
+		model:  model,
+		lc:     lc,
+		batch:  batch,
+		max:    maxDraft,
+		inputs: make([][]int, parallel),
+	}, nil
+}
+
+// propose returns up to n tokens the draft model predicts follow tokens, the
+// inputs of the sequence in slot. The draft model's cache of the slot is
+// updated to tokens first, reusing what it has in common with them.
+func (d *draftModel) propose(slot int, tokens []int, n int) ([]int, error) {
+	cached := d.inputs[slot]
+
+	var numPast int
+	for numPast < len(cached) && numPast < len(tokens) && cached[numPast] == tokens[numPast] {
+		numPast++
+	}
+
+	// the last token is always decoded again for its logits
+	numPast = min(numPast, len(tokens)-1)
+	if !d.lc.KvCacheSeqRm(slot, numPast, -1) {
+		d.lc.KvCacheSeqRm(slot, 0, -1)
+		numPast = 0
+	}
+
+	d.inputs[slot] = slices.Clone(tokens[:numPast])
+
+	for i := numPast; i < len(tokens); i += d.batch.Size() {
+		d.batch.Clear()
+		for j := i; j < min(i+d.batch.Size(), len(tokens)); j++ {
+			d.batch.Add(tokens[j], nil, j, j+1 == len(tokens), slot)
+		}
+
+		if err := d.lc.Decode(d.batch); err != nil {
+			return nil, fmt.Errorf("failed to decode draft batch: %w", err)
+		}
+
+		d.inputs[slot] = append(d.inputs[slot], tokens[i:i+d.batch.NumTokens()]...)
+	}
+
+	drafts := make([]int, 0, n)
+	for {
+		token := argmax(d.lc.GetLogitsIth(d.batch.NumTokens() - 1))
+		drafts = append(drafts, token)
+		if len(drafts) == n || d.model.TokenIsEog(token) {
+			return drafts, nil
+		}
+
+		d.batch.Clear()
+		d.batch.Add(token, nil, len(d.inputs[slot]), true, slot)
+		if err := d.lc.Decode(d.batch); err != nil {
+			return nil, fmt.Errorf("failed to decode draft batch: %w", err)
+		}
+
+		d.inputs[slot] = append(d.inputs[slot], token)
+	}
+}
+
+// argmax returns the index of the largest of logits
+func argmax(logits []float32) int {
+	var best int
+	for i, l := range logits {
+		if l > logits[best] {
+			best = i
+		}
+	}
+
+	return best
+}
@@ -0,0 +1,52 @@
+package runner
+
+import (
+	"log/slog"
+	"math"
+	"strings"
+)
+
+// healPiece checks a sampled piece against the text removed from the end of
+// the prompt that hasn't been generated again yet. ok is false if the piece
+// can't follow the prompt. Otherwise out is the part of the piece that is new
+// text and rest is the removed text that still has to be generated.
+func healPiece(piece, healing string) (out, rest string, ok bool) {
+	switch {
+	case piece == "":
+		return "", healing, false
+	case strings.HasPrefix(piece, healing):
+		return piece[len(healing):], "", true
+	case strings.HasPrefix(healing, piece):
+		return "", healing[len(piece):], true
+	default:
+		return "", healing, false
+	}
+}
+
+// vocabulary returns the text of every token in the model
+func (s *Server) vocabulary() []string {
+	s.vocabOnce.Do(func() {
+		s.vocab = make([]string, s.model.NumVocab())
+		for i := range s.vocab {
+			s.vocab[i] = s.model.TokenToPiece(i)
+		}
+	})
+
+	return s.vocab
+}
+
+// constrainHealing masks the logits of the tokens that can't continue the
+// prompt so the next token sampled generates the removed text again
+func (s *Server) constrainHealing(seq *Sequence) {
+	logits := s.lc.GetLogitsIth(seq.iBatch)
+	if logits == nil {
+		slog.Debug("no logits for token healing", "healing", seq.healing)
+		return
+	}
+
+	for id, piece := range s.vocabulary() {
+		if _, _, ok := healPiece(piece, seq.healing); !ok {
+			logits[id] = float32(math.Inf(-1))
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package runner
+
+import "testing"
+
+func TestHealPiece(t *testing.T) {
+	cases := []struct {
+		piece, healing string
+		out, rest      string
+		ok             bool
+	}{
+		{" world", " wor", "ld", "", true},
+		{" wor", " wor", "", "", true},
+		{" w", " wor", "", "or", true},
+		{" hello", " wor", "", " wor", false},
+		{"", " wor", "", " wor", false},
+	}
+
+	for _, tt := range cases {
+		out, rest, ok := healPiece(tt.piece, tt.healing)
+		if out != tt.out || rest != tt.rest || ok != tt.ok {
+			t.Errorf("healPiece(%q, %q) = %q, %q, %v, want %q, %q, %v", tt.piece, tt.healing, out, rest, ok, tt.out, tt.rest, tt.ok)
+		}
+	}
+}
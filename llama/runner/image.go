@@ -134,6 +134,51 @@ func (c *ImageContext) NeedCrossAttention(inputs ...input) bool {
 	})
 }
 
+// consecutiveImages returns the number of images at the end of inputs
+func consecutiveImages(inputs []input) int {
+	var n int
+	for i := len(inputs) - 1; i >= 0 && inputs[i].embed != nil; i-- {
+		n++
+	}
+
+	return n
+}
+
+// lastImageGroup returns the number of images in the last run of consecutive
+// images in inputs. Tokens in mllama models attend to the images in the
+// group before them.
+func lastImageGroup(inputs []input) int {
+	i := len(inputs)
+	for i > 0 && inputs[i-1].embed == nil {
+		i--
+	}
+
+	return consecutiveImages(inputs[:i])
+}
+
+// CheckImageGroups returns an error if inputs has more consecutive images
+// than the cross attention state of the model can hold
+func (c *ImageContext) CheckImageGroups(inputs []input, maxImages int) error {
+	if c == nil || c.mllama == nil {
+		return nil
+	}
+
+	var n int
+	for _, input := range inputs {
+		if input.embed == nil {
+			n = 0
+			continue
+		}
+
+		n++
+		if n > maxImages {
+			return fmt.Errorf("too many consecutive images, the limit is %d", maxImages)
+		}
+	}
+
+	return nil
+}
+
 type imageCache struct {
 	key      uint64
 	val      [][]float32
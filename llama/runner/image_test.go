@@ -3,6 +3,8 @@ package runner
 import (
 	"reflect"
 	"testing"
+
+	"github.com/ollama/ollama/llama"
 )
 
 func TestImageCache(t *testing.T) {
@@ -78,3 +80,40 @@ func TestImageCache(t *testing.T) {
 		t.Errorf("failed to find expected value: result %v, err %v", result, err)
 	}
 }
+
+func TestImageGroups(t *testing.T) {
+	embed := input{embed: []float32{0.1}}
+	token := input{token: 1}
+
+	cases := []struct {
+		inputs      []input
+		consecutive int
+		lastGroup   int
+	}{
+		{nil, 0, 0},
+		{[]input{token, token}, 0, 0},
+		{[]input{embed, token}, 0, 1},
+		{[]input{embed, embed, token, embed}, 1, 1},
+		{[]input{token, embed, embed}, 2, 2},
+		{[]input{embed, token, embed, embed, token, token}, 0, 2},
+	}
+
+	for i, tt := range cases {
+		if got := consecutiveImages(tt.inputs); got != tt.consecutive {
+			t.Errorf("%d: expected %d consecutive images, got %d", i, tt.consecutive, got)
+		}
+
+		if got := lastImageGroup(tt.inputs); got != tt.lastGroup {
+			t.Errorf("%d: expected %d images in the last group, got %d", i, tt.lastGroup, got)
+		}
+	}
+
+	c := &ImageContext{mllama: &llama.MllamaContext{}}
+	if err := c.CheckImageGroups([]input{embed, embed, token, embed, embed}, 2); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	if err := c.CheckImageGroups([]input{embed, embed, embed, token}, 2); err == nil {
+		t.Error("expected an error for too many consecutive images")
+	}
+}
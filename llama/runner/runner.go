@@ -1,6 +1,7 @@
 package runner
 
 import (
+	"cmp"
 	"context"
 	"encoding/json"
 	"errors"
@@ -8,12 +9,14 @@ import (
 	"fmt"
 	"log"
 	"log/slog"
+	"math"
 	"net"
 	"net/http"
 	"os"
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -24,6 +27,7 @@ import (
 
 	"github.com/ollama/ollama/api"
 	"github.com/ollama/ollama/llama"
+	"github.com/ollama/ollama/watermark"
 )
 
 // input is an element of the prompt to process, either
@@ -51,15 +55,25 @@ type Sequence struct {
 	// tokens that have been generated but not returned yet (e.g. for stop sequences)
 	pendingResponses []string
 
+	// log probabilities of the pending responses, if requested
+	pendingLogprobs []api.Logprob
+
 	// input cache being used by this sequence
 	cache *InputCacheSlot
 
+	// clearCache discards the sequence's inputs from its cache slot once
+	// it's removed, so they aren't kept or logged for later prompts
+	clearCache bool
+
 	// does this sequence require cross-attention layers to be processed? - if we have seen
 	// an image for certain multi-modal models
 	crossAttention bool
 
+	// number of consecutive images in the cross attention state that tokens attend to
+	crossAttentionImages int
+
 	// channel to send responses over
-	responses chan string
+	responses chan response
 
 	// channel to stop decoding (such as if the remote connection is closed)
 	quit chan bool
@@ -69,11 +83,37 @@ type Sequence struct {
 
 	samplingCtx *llama.SamplingContext
 
+	// text removed from the end of the prompt for token healing that the
+	// generated tokens have to start with
+	healing string
+
 	// channel to send back the embedding if embedding only
 	embedding chan []float32
 
 	// stop sequences
-	stop []string
+	stop *stopMatcher
+
+	// keep the matched stop sequence in the response
+	includeStop bool
+
+	// keep generating past end of generation tokens
+	ignoreEOS bool
+
+	// return the log probabilities of generated tokens and of the
+	// topLogprobs most likely tokens at each position
+	logprobs    bool
+	topLogprobs int
+
+	// key of the watermark sampling is biased with, if not zero
+	watermarkKey uint64
+
+	// tokens penalized for having letters outside the scripts of the
+	// response language, if one is set
+	offScript []bool
+
+	// profile is the time spent in each operation while the sequence was
+	// profiled, set once it's removed
+	profile []api.ProfileOp
 
 	// number of inputs to keep at the beginning when shifting context window
 	numKeep int
@@ -83,19 +123,43 @@ type Sequence struct {
 
 	doneReason string
 
+	// why the sequence was aborted, if it couldn't finish
+	abortReason  string
+	abortMessage string
+
+	// number of times the context window was shifted to keep generating
+	contextShifts int
+
+	// number of prompt inputs discarded because the prompt didn't fit in
+	// the context window
+	promptTruncated int
+
+	// tokens proposed by the draft model, at the end of inputs
+	drafts []int
+
 	// Metrics
 	startProcessingTime time.Time
 	startGenerationTime time.Time
 	numDecoded          int
 	numPromptInputs     int
+	numDrafted          int
+	numDraftAccepted    int
 }
 
 type NewSequenceParams struct {
 	numPredict     int
 	stop           []string
+	stopRegex      []string
 	numKeep        int
 	samplingParams *llama.SamplingParams
 	embedding      bool
+	tokenHealing   bool
+	includeStop    bool
+	ignoreEOS      bool
+	logprobs       bool
+	topLogprobs    int
+	watermarkKey   uint64
+	scripts        []string
 }
 
 func (s *Server) NewSequence(prompt string, images []ImageData, params NewSequenceParams) (*Sequence, error) {
@@ -110,6 +174,24 @@ func (s *Server) NewSequence(prompt string, images []ImageData, params NewSequen
 		return nil, errors.New("no input provided")
 	}
 
+	if err := s.image.CheckImageGroups(inputs, s.maxImages); err != nil {
+		return nil, err
+	}
+
+	// token healing removes the last token of the prompt and makes generation
+	// start by producing its text again, so a prompt that ends part way through
+	// a word can be continued with the tokens the model would normally use
+	var healing string
+	if params.tokenHealing && len(inputs) > 1 && inputs[len(inputs)-1].embed == nil {
+		healing = s.model.TokenToPiece(inputs[len(inputs)-1].token)
+		inputs = inputs[:len(inputs)-1]
+	}
+
+	stop, err := newStopMatcher(params.stop, params.stopRegex)
+	if err != nil {
+		return nil, err
+	}
+
 	if params.numKeep < 0 {
 		params.numKeep = len(inputs)
 	}
@@ -121,8 +203,10 @@ func (s *Server) NewSequence(prompt string, images []ImageData, params NewSequen
 	// Ensure that at least 1 input can be discarded during shift
 	params.numKeep = min(params.numKeep, s.cache.numCtx-1)
 
+	var truncated int
 	if len(inputs) > s.cache.numCtx {
 		discard := len(inputs) - s.cache.numCtx
+		truncated = discard
 		newInputs := inputs[:params.numKeep]
 		newInputs = append(newInputs, inputs[params.numKeep+discard:]...)
 
@@ -143,19 +227,32 @@ func (s *Server) NewSequence(prompt string, images []ImageData, params NewSequen
 		}
 	}
 
+	var offScript []bool
+	if len(params.scripts) > 0 {
+		offScript = s.offScriptTokens(params.scripts)
+	}
+
 	return &Sequence{
 		inputs:              inputs,
 		numPromptInputs:     len(inputs),
 		startProcessingTime: startTime,
 		numPredict:          params.numPredict,
 		pendingResponses:    make([]string, 0),
-		responses:           make(chan string, 100),
+		responses:           make(chan response, 100),
 		quit:                make(chan bool, 1),
 		embedding:           make(chan []float32, 1),
 		samplingCtx:         sc,
+		healing:             healing,
 		embeddingOnly:       params.embedding,
-		stop:                params.stop,
+		stop:                stop,
+		includeStop:         params.includeStop,
+		ignoreEOS:           params.ignoreEOS,
+		logprobs:            params.logprobs,
+		topLogprobs:         params.topLogprobs,
+		watermarkKey:        params.watermarkKey,
+		offScript:           offScript,
 		numKeep:             params.numKeep,
+		promptTruncated:     truncated,
 	}, nil
 }
 
@@ -177,7 +274,7 @@ func (s *Server) inputs(prompt string, images []ImageData) ([]input, error) {
 
 	for i, part := range parts {
 		// text - tokenize
-		tokens, err := s.lc.Model().Tokenize(part, i == 0, true)
+		tokens, err := s.model.Tokenize(part, i == 0, true)
 		if err != nil {
 			return nil, err
 		}
@@ -227,6 +324,9 @@ type Server struct {
 	// image model context for multi-modal models
 	image *ImageContext
 
+	// draft model for speculative decoding, if one is loaded
+	draft *draftModel
+
 	// status for external health reporting - loading, ready to serve, etc.
 	status ServerStatus
 
@@ -236,6 +336,21 @@ type Server struct {
 	// number of simultaneous requests to handle
 	parallel int
 
+	// maximum number of simultaneous requests the runner can be changed to
+	// handle
+	maxParallel int
+
+	// newContext creates a context with a KV cache partitioned for
+	// numParallel sequences, or is nil if the number of sequences can't be
+	// changed without reloading the model
+	newContext func(numParallel int) (*llama.Context, error)
+
+	// serializes changes to the number of simultaneous requests
+	resizeMu sync.Mutex
+
+	// maximum number of consecutive images for models with cross attention
+	maxImages int
+
 	// maximum number of elements in a batch (per sequence)
 	// TODO (jmorganca): make this n_batch
 	batchSize int
@@ -253,6 +368,10 @@ type Server struct {
 	// the list of simultaneous sequences being evaluated
 	seqs []*Sequence
 
+	// the sequence being profiled, if any. Batches decoded while it's
+	// running are profiled, including the inputs of other sequences.
+	profiling *Sequence
+
 	// seqs can have a maximum of parallel entries, which
 	// is enfoced by seqSem
 	seqsSem *semaphore.Weighted
@@ -262,6 +381,19 @@ type Server struct {
 
 	// next sequence for prompt processing to avoid starvation
 	nextSeq int
+
+	// text of every token, used to constrain sampling for token healing
+	vocab     []string
+	vocabOnce sync.Once
+
+	// end of generation tokens, suppressed when ignoring them
+	eog     []int
+	eogOnce sync.Once
+
+	// tokens with letters outside each set of scripts responses have been
+	// constrained to, by the comma separated names of the scripts
+	offScript map[string][]bool
+	scriptsMu sync.Mutex
 }
 
 func (s *Server) allNil() bool {
@@ -273,9 +405,27 @@ func (s *Server) allNil() bool {
 	return true
 }
 
+// response is text generated for a sequence and the log probabilities of
+// its tokens, if requested
+type response struct {
+	content  string
+	logprobs []api.Logprob
+}
+
 func flushPending(seq *Sequence) bool {
-	joined := strings.Join(seq.pendingResponses, "")
-	seq.pendingResponses = []string{}
+	return flushPieces(seq, len(seq.pendingResponses))
+}
+
+// flushPieces returns the first n pending responses of seq
+func flushPieces(seq *Sequence, n int) bool {
+	joined := strings.Join(seq.pendingResponses[:n], "")
+	seq.pendingResponses = seq.pendingResponses[n:]
+
+	var logprobs []api.Logprob
+	if seq.logprobs {
+		logprobs = seq.pendingLogprobs[:n:n]
+		seq.pendingLogprobs = seq.pendingLogprobs[n:]
+	}
 
 	// Check if there are any partial UTF-8 characters remaining.
 	// We already check and queue as we are generating but some may
@@ -287,12 +437,12 @@ func flushPending(seq *Sequence) bool {
 		joined = joined[:len(joined)-1]
 	}
 
-	if len(joined) == 0 {
+	if len(joined) == 0 && len(logprobs) == 0 {
 		return true
 	}
 
 	select {
-	case seq.responses <- joined:
+	case seq.responses <- response{content: joined, logprobs: logprobs}:
 		return true
 	case <-seq.quit:
 		return false
@@ -302,21 +452,162 @@ func flushPending(seq *Sequence) bool {
 func (s *Server) removeSequence(seqIndex int, reason string) {
 	seq := s.seqs[seqIndex]
 
+	if seq == s.profiling {
+		for _, op := range s.lc.StopProfile() {
+			seq.profile = append(seq.profile, api.ProfileOp{Name: op.Name, Op: op.Op, Count: op.Count, Duration: op.Duration})
+		}
+		s.profiling = nil
+	}
+
 	flushPending(seq)
 	seq.doneReason = reason
 	close(seq.responses)
 	close(seq.embedding)
+	if seq.clearCache {
+		s.cache.ClearCacheSlot(seq.cache)
+	}
 	seq.cache.InUse = false
 	s.seqs[seqIndex] = nil
 	s.seqsSem.Release(1)
 }
 
+// abortSequence ends a sequence that can't continue generating, leaving the
+// other sequences running
+func (s *Server) abortSequence(seqIndex int, reason string, err error) {
+	slog.Error("aborting sequence", "reason", reason, "error", err)
+
+	seq := s.seqs[seqIndex]
+	seq.abortReason = reason
+	seq.abortMessage = err.Error()
+	s.removeSequence(seqIndex, "abort")
+}
+
+// suppressEOG masks the logits of end of generation tokens at iBatch, so the
+// sequence only ends at a stop sequence or the prediction limit
+func (s *Server) suppressEOG(iBatch int) {
+	s.eogOnce.Do(func() {
+		for i := range s.model.NumVocab() {
+			if s.model.TokenIsEog(i) {
+				s.eog = append(s.eog, i)
+			}
+		}
+	})
+
+	logits := s.lc.GetLogitsIth(iBatch)
+	if logits == nil {
+		return
+	}
+
+	for _, id := range s.eog {
+		logits[id] = float32(math.Inf(-1))
+	}
+}
+
+// watermark raises the logits at iBatch of the tokens on the green list of
+// the watermark with key after prev, so the generated text can be detected
+func (s *Server) watermark(iBatch, prev int, key uint64) {
+	logits := s.lc.GetLogitsIth(iBatch)
+	for i := range logits {
+		if watermark.Green(key, prev, i) {
+			logits[i] += watermark.Delta
+		}
+	}
+}
+
+// logprob returns the log probability of token, sampled at iBatch, and of
+// the top most likely tokens there. Probabilities are of the model's
+// distribution, before sampling parameters such as temperature are applied.
+func (s *Server) logprob(iBatch, token, top int) api.Logprob {
+	logits := s.lc.GetLogitsIth(iBatch)
+
+	maxLogit := math.Inf(-1)
+	for _, l := range logits {
+		maxLogit = max(maxLogit, float64(l))
+	}
+
+	var sum float64
+	for _, l := range logits {
+		sum += math.Exp(float64(l) - maxLogit)
+	}
+
+	norm := maxLogit + math.Log(sum)
+	lp := api.Logprob{TokenLogprob: api.TokenLogprob{
+		Token:   s.model.TokenToPiece(token),
+		Logprob: float64(logits[token]) - norm,
+	}}
+
+	// keep the indexes of the top logits in descending order
+	best := make([]int, 0, top+1)
+	for i, l := range logits {
+		if len(best) == top && (top == 0 || l <= logits[best[top-1]]) {
+			continue
+		}
+
+		j, _ := slices.BinarySearchFunc(best, l, func(b int, l float32) int {
+			return cmp.Compare(l, logits[b])
+		})
+		best = slices.Insert(best, j, i)
+		if len(best) > top {
+			best = best[:top]
+		}
+	}
+
+	for _, i := range best {
+		lp.TopLogprobs = append(lp.TopLogprobs, api.TokenLogprob{
+			Token:   s.model.TokenToPiece(i),
+			Logprob: float64(logits[i]) - norm,
+		})
+	}
+
+	return lp
+}
+
+// canDraft reports whether the draft model can propose tokens for seq, which
+// has to be generating text and have nothing left of its prompt to process
+func (s *Server) canDraft(seq *Sequence, batch *llama.Batch) bool {
+	return s.draft != nil && !seq.embeddingOnly && seq.numDecoded > 0 &&
+		len(seq.inputs) == 1 && seq.healing == "" &&
+		(batch == nil || !batch.IsEmbedding())
+}
+
+// proposeDrafts adds the tokens the draft model predicts follow seq to its
+// inputs. There are only as many as fit in a batch, in the context window
+// without shifting it, and in the prediction limit.
+func (s *Server) proposeDrafts(seq *Sequence) error {
+	n := min(s.draft.max, s.batchSize-1, s.cache.numCtx-len(seq.cache.Inputs)-1)
+	if seq.numPredict > 0 {
+		n = min(n, seq.numPredict-seq.numPredicted-1)
+	}
+
+	if n <= 0 {
+		return nil
+	}
+
+	tokens := make([]int, 0, len(seq.cache.Inputs)+1)
+	for _, input := range seq.cache.Inputs {
+		tokens = append(tokens, input.token)
+	}
+	tokens = append(tokens, seq.inputs[0].token)
+
+	drafts, err := s.draft.propose(seq.cache.Id, tokens, n)
+	if err != nil {
+		return err
+	}
+
+	for _, token := range drafts {
+		seq.inputs = append(seq.inputs, input{token: token})
+	}
+
+	seq.drafts = drafts
+	return nil
+}
+
 func (s *Server) run(ctx context.Context) {
 	s.ready.Wait()
 
 	// Logically these batches are used only within the context of processBatch
 	// but it is better for performance to allocate them once here
-	tokenBatch, err := llama.NewBatch(s.batchSize, len(s.seqs), 0)
+	tokenBatch, err := llama.NewBatch(s.batchSize, s.maxParallel, 0)
 	if err != nil {
 		panic(err)
 	}
@@ -325,7 +616,7 @@ func (s *Server) run(ctx context.Context) {
 	var embedBatch *llama.Batch
 	embedBatchSize := s.image.BatchSize(s.batchSize)
 	if embedBatchSize != 0 {
-		embedBatch, err = llama.NewBatch(embedBatchSize, len(s.seqs), s.image.EmbedSize(s.lc))
+		embedBatch, err = llama.NewBatch(embedBatchSize, s.maxParallel, s.image.EmbedSize(s.lc))
 		if err != nil {
 			panic(err)
 		}
@@ -363,9 +654,11 @@ func (s *Server) processBatch(tokenBatch *llama.Batch, embedBatch *llama.Batch)
 		s.cond.Wait() // Wait until an item is added
 	}
 	defer s.mu.Unlock()
+	defer s.cache.updateUsage()
 
 	var batch *llama.Batch
 	crossAttention := false
+	crossAttentionImage, crossAttentionImages := 0, 0
 
 	seqIdx := s.nextSeq - 1
 	for range s.seqs {
@@ -382,13 +675,23 @@ func (s *Server) processBatch(tokenBatch *llama.Batch, embedBatch *llama.Batch)
 			continue
 		}
 
+		if s.canDraft(seq, batch) {
+			if err := s.proposeDrafts(seq); err != nil {
+				s.abortSequence(seqIdx, "draft_failed", err)
+				continue
+			}
+		}
+
 		for i, input := range seq.inputs {
 			if len(seq.cache.Inputs)+len(seq.pendingInputs)+1 > s.cache.numCtx {
 				if len(seq.pendingInputs) == 0 {
 					err := s.cache.ShiftCacheSlot(seq.cache, seq.numKeep)
 					if err != nil {
-						return err
+						s.abortSequence(seqIdx, "context_shift_failed", err)
+						break
 					}
+
+					seq.contextShifts++
 				} else {
 					break
 				}
@@ -406,8 +709,19 @@ func (s *Server) processBatch(tokenBatch *llama.Batch, embedBatch *llama.Batch)
 				} else {
 					batch = embedBatch
 					seq.crossAttention = s.image.NeedCrossAttention(input)
+					if seq.crossAttention {
+						// images following other images are stored in the next
+						// slot of the cross attention state and attended to together
+						crossAttentionImage = consecutiveImages(seq.pendingInputs)
+						if crossAttentionImage == len(seq.pendingInputs) {
+							crossAttentionImage += consecutiveImages(seq.cache.Inputs)
+						}
+
+						seq.crossAttentionImages = crossAttentionImage + 1
+					}
 				}
-			} else if embedding != batch.IsEmbedding() || crossAttention != seq.crossAttention {
+			} else if embedding != batch.IsEmbedding() || crossAttention != seq.crossAttention ||
+				(crossAttention && !embedding && crossAttentionImages != seq.crossAttentionImages) {
 				s.nextSeq = seqIdx
 				break
 			}
@@ -417,7 +731,10 @@ func (s *Server) processBatch(tokenBatch *llama.Batch, embedBatch *llama.Batch)
 			}
 
 			crossAttention = seq.crossAttention
-			batch.Add(input.token, input.embed, len(seq.cache.Inputs)+len(seq.pendingInputs), i+1 == len(seq.inputs), seq.cache.Id)
+			crossAttentionImages = seq.crossAttentionImages
+			// drafts are checked with the logits of every input before them
+			logits := i+1 >= len(seq.inputs)-len(seq.drafts)
+			batch.Add(input.token, input.embed, len(seq.cache.Inputs)+len(seq.pendingInputs), logits, seq.cache.Id)
 			seq.pendingInputs = append(seq.pendingInputs, input)
 			seq.iBatch = batch.NumTokens() - 1
 		}
@@ -430,6 +747,9 @@ func (s *Server) processBatch(tokenBatch *llama.Batch, embedBatch *llama.Batch)
 	}
 
 	s.lc.SetCrossAttention(crossAttention)
+	if crossAttention {
+		s.lc.SetCrossAttentionImages(crossAttentionImage, crossAttentionImages)
+	}
 
 	err := s.lc.Decode(batch)
 	if err != nil {
@@ -476,68 +796,193 @@ func (s *Server) processBatch(tokenBatch *llama.Batch, embedBatch *llama.Batch)
 			continue
 		}
 
-		// sample a token
-		token := seq.samplingCtx.Sample(s.lc, seq.iBatch)
-		seq.samplingCtx.Accept(token, true)
-		piece := s.model.TokenToPiece(token)
+		if seq.healing != "" {
+			s.constrainHealing(seq)
+		}
+
+		// the model checks the drafts by sampling after the last input and
+		// each draft, up to the first draft it disagrees with. The drafts
+		// it agrees with and the token sampled after them are all generated
+		// by this batch.
+		drafts := seq.drafts
+		seq.drafts = nil
+
+		var tokens []int
+		var logprobs []api.Logprob
+		for j := 0; j <= len(drafts); j++ {
+			iBatch := seq.iBatch - len(drafts) + j
+			if seq.ignoreEOS {
+				s.suppressEOG(iBatch)
+			}
 
-		seq.numPredicted++
+			if seq.watermarkKey != 0 {
+				// the logits at iBatch are those of the input there
+				prev := seq.cache.Inputs[len(seq.cache.Inputs)-1-len(drafts)+j]
+				s.watermark(iBatch, prev.token, seq.watermarkKey)
+			}
 
-		// if it's an end of sequence token, break
-		if s.model.TokenIsEog(token) {
-			// TODO (jmorganca): we should send this back
-			// as it's important for the /api/generate context
-			// seq.responses <- piece
+			if seq.offScript != nil {
+				s.penalizeOffScript(iBatch, seq.offScript)
+			}
 
-			s.removeSequence(i, "stop")
-			continue
+			// sample a token
+			token := seq.samplingCtx.Sample(s.lc, iBatch)
+			if token < 0 || token >= s.model.NumVocab() {
+				s.abortSequence(i, "sampler_error", fmt.Errorf("sampled invalid token %d", token))
+				break
+			}
+
+			seq.samplingCtx.Accept(token, true)
+			tokens = append(tokens, token)
+			if seq.logprobs {
+				logprobs = append(logprobs, s.logprob(iBatch, token, seq.topLogprobs))
+			}
+			if j == len(drafts) || token != drafts[j] {
+				break
+			}
 		}
 
-		seq.inputs = []input{{token: token}}
+		if s.seqs[i] == nil {
+			continue
+		}
 
-		seq.pendingResponses = append(seq.pendingResponses, piece)
-		sequence := strings.Join(seq.pendingResponses, "")
+		if len(drafts) > 0 {
+			accepted := len(tokens) - 1
+			seq.numDrafted += len(drafts)
+			seq.numDraftAccepted += accepted
+			seq.numDecoded += accepted
+
+			// remove the rejected drafts from the cache. The accepted ones are
+			// left out of its inputs until they are generated below, as the
+			// token being generated is never in the cache.
+			numPast := len(seq.cache.Inputs) - len(drafts)
+			if !s.lc.KvCacheSeqRm(seq.cache.Id, numPast+accepted, -1) {
+				s.abortSequence(i, "draft_failed", errors.New("model doesn't support removing rejected drafts from its cache"))
+				continue
+			}
 
-		if ok, stop := findStop(sequence, seq.stop); ok {
-			slog.Debug("hit stop token", "pending", seq.pendingResponses, "stop", stop)
+			seq.cache.Inputs = seq.cache.Inputs[:numPast]
+		}
 
-			var tokenTruncated bool
-			origLen := len(seq.pendingResponses)
-			seq.pendingResponses, tokenTruncated = truncateStop(seq.pendingResponses, stop)
-			newLen := len(seq.pendingResponses)
+		for j, token := range tokens {
+			var logprob *api.Logprob
+			if seq.logprobs {
+				logprob = &logprobs[j]
+			}
 
-			// Update the cache based on the tokens that will be returned:
-			// - We have 1 token more than is currently in the cache because
-			// the last one generated wasn't submitted to Decode
-			// - Remove any stop sequences that we stripped out
-			// - If truncateStop removed a portion of a token, drop that
-			// - As defense-in-depth, if truncatedToken didn't find a stop token
-			// remove the extra one that we added to the cache len
-			tokenLen := len(seq.cache.Inputs) + 1
-			tokenLen -= origLen - newLen
-			if tokenTruncated || origLen == newLen {
-				tokenLen--
+			if !s.generate(i, seq, token, logprob) {
+				break
 			}
-			seq.cache.Inputs = seq.cache.Inputs[:tokenLen]
 
-			s.removeSequence(i, "stop")
-			continue
+			// accepted drafts have already been decoded
+			if j+1 < len(tokens) {
+				seq.inputs = nil
+				seq.cache.Inputs = append(seq.cache.Inputs, input{token: token})
+			}
 		}
+	}
 
-		if containsStopSuffix(sequence, seq.stop) {
-			continue
+	return nil
+}
+
+// generate adds token, sampled for the sequence at seqIndex, to its response
+// along with its log probability, if requested. It returns false if the
+// token ended the sequence.
+func (s *Server) generate(seqIndex int, seq *Sequence, token int, logprob *api.Logprob) bool {
+	piece := s.model.TokenToPiece(token)
+
+	if seq.healing != "" {
+		out, rest, ok := healPiece(piece, seq.healing)
+		if !ok {
+			if !seq.clearCache {
+				slog.Debug("sampled token doesn't heal the prompt", "healing", seq.healing, "piece", piece)
+			}
+			out, rest = piece, ""
 		}
 
-		if incompleteUnicode(sequence) {
-			continue
+		piece, seq.healing = out, rest
+	}
+
+	seq.numPredicted++
+
+	// if it's an end of sequence token, break
+	if s.model.TokenIsEog(token) {
+		// TODO (jmorganca): we should send this back
+		// as it's important for the /api/generate context
+		// seq.responses <- piece
+
+		s.removeSequence(seqIndex, "stop")
+		return false
+	}
+
+	seq.inputs = []input{{token: token}}
+
+	seq.pendingResponses = append(seq.pendingResponses, piece)
+	if logprob != nil {
+		// the token is the text it adds to the response, which differs from
+		// its piece while healing the prompt
+		logprob.Token = piece
+		seq.pendingLogprobs = append(seq.pendingLogprobs, *logprob)
+	}
+
+	sequence := strings.Join(seq.pendingResponses, "")
+
+	if start, end, ok := seq.stop.find(sequence); ok {
+		slog.Debug("hit stop token", "pending", seq.pendingResponses, "stop", sequence[start:end])
+
+		var tokenTruncated bool
+		origLen := len(seq.pendingResponses)
+		seq.pendingResponses, tokenTruncated = truncateStop(seq.pendingResponses, seq.stop, seq.includeStop)
+		newLen := len(seq.pendingResponses)
+		if seq.logprobs {
+			seq.pendingLogprobs = seq.pendingLogprobs[:newLen]
+			for j := range seq.pendingLogprobs {
+				seq.pendingLogprobs[j].Token = seq.pendingResponses[j]
+			}
 		}
 
-		if !flushPending(seq) {
-			s.removeSequence(i, "connection")
+		// Update the cache based on the tokens that will be returned:
+		// - We have 1 token more than is currently in the cache because
+		// the last one generated wasn't submitted to Decode
+		// - Remove any stop sequences that we stripped out
+		// - If truncateStop removed a portion of a token, drop that
+		// - As defense-in-depth, if truncatedToken didn't find a stop token
+		// remove the extra one that we added to the cache len
+		tokenLen := len(seq.cache.Inputs) + 1
+		tokenLen -= origLen - newLen
+		if tokenTruncated || origLen == newLen {
+			tokenLen--
 		}
+		seq.cache.Inputs = seq.cache.Inputs[:tokenLen]
+
+		s.removeSequence(seqIndex, "stop")
+		return false
 	}
 
-	return nil
+	// return the pieces before the text that may start a stop, holding back
+	// any that end part way through a character
+	ready := len(sequence) - seq.stop.lookahead(sequence)
+	var n, size int
+	for n < len(seq.pendingResponses) && size+len(seq.pendingResponses[n]) <= ready {
+		size += len(seq.pendingResponses[n])
+		n++
+	}
+
+	for n > 0 && incompleteUnicode(sequence[:size]) {
+		n--
+		size -= len(seq.pendingResponses[n])
+	}
+
+	if n == 0 {
+		return true
+	}
+
+	if !flushPieces(seq, n) {
+		s.removeSequence(seqIndex, "connection")
+		return false
+	}
+
+	return true
 }
 
 // TODO (jmorganca): use structs from the api package to avoid duplication
@@ -562,7 +1007,48 @@ type Options struct {
 	MirostatTau      float32  `json:"mirostat_tau"`
 	MirostatEta      float32  `json:"mirostat_eta"`
 	PenalizeNewline  bool     `json:"penalize_nl"`
+	TokenHealing     bool     `json:"token_healing"`
 	Stop             []string `json:"stop"`
+	StopRegex        []string `json:"stop_regex"`
+	IncludeStop      bool     `json:"include_stop"`
+	IgnoreEOS        bool     `json:"ignore_eos"`
+	Watermark        bool     `json:"watermark"` // set with watermark_key
+	Profile          bool     `json:"profile"`
+	ResponseLanguage string   `json:"response_language"` // set with scripts
+}
+
+// defaultOptions returns the options the server defaults to. Options only
+// applied by the server, such as fallbacks and post-processing, aren't sent
+// to the runner.
+func defaultOptions() Options {
+	opts := api.DefaultOptions()
+	return Options{
+		Runner:           opts.Runner,
+		NumKeep:          opts.NumKeep,
+		Seed:             opts.Seed,
+		NumPredict:       opts.NumPredict,
+		TopK:             opts.TopK,
+		TopP:             opts.TopP,
+		MinP:             opts.MinP,
+		TypicalP:         opts.TypicalP,
+		RepeatLastN:      opts.RepeatLastN,
+		Temperature:      opts.Temperature,
+		RepeatPenalty:    opts.RepeatPenalty,
+		PresencePenalty:  opts.PresencePenalty,
+		FrequencyPenalty: opts.FrequencyPenalty,
+		Mirostat:         opts.Mirostat,
+		MirostatTau:      opts.MirostatTau,
+		MirostatEta:      opts.MirostatEta,
+		PenalizeNewline:  opts.PenalizeNewline,
+		TokenHealing:     opts.TokenHealing,
+		Stop:             opts.Stop,
+		StopRegex:        opts.StopRegex,
+		IncludeStop:      opts.IncludeStop,
+		IgnoreEOS:        opts.IgnoreEOS,
+		Watermark:        opts.Watermark,
+		Profile:          opts.Profile,
+		ResponseLanguage: opts.ResponseLanguage,
+	}
 }
 
 type ImageData struct {
@@ -576,6 +1062,16 @@ type CompletionRequest struct {
 	Images      []ImageData `json:"image_data"`
 	Grammar     string      `json:"grammar"`
 	CachePrompt bool        `json:"cache_prompt"`
+	Logprobs    bool        `json:"logprobs"`
+	TopLogprobs int         `json:"top_logprobs"`
+
+	// WatermarkKey is the key of the watermark sampling is biased with, if
+	// not zero
+	WatermarkKey uint64 `json:"watermark_key"`
+
+	// Scripts are the Unicode scripts of the response language. Tokens with
+	// letters in other scripts are penalized.
+	Scripts []string `json:"scripts"`
 
 	Options
 }
@@ -585,6 +1081,8 @@ type Timings struct {
 	PredictedMS float64 `json:"predicted_ms"`
 	PromptN     int     `json:"prompt_n"`
 	PromptMS    float64 `json:"prompt_ms"`
+	DraftN      int     `json:"draft_n,omitempty"`
+	DraftAccN   int     `json:"draft_accepted_n,omitempty"`
 }
 
 type CompletionResponse struct {
@@ -599,12 +1097,25 @@ type CompletionResponse struct {
 	PromptN      int     `json:"prompt_n,omitempty"`
 	PromptMS     float64 `json:"prompt_ms,omitempty"`
 
+	// AbortReason and AbortMessage are set if the sequence couldn't finish
+	AbortReason   string `json:"abort_reason,omitempty"`
+	AbortMessage  string `json:"abort_message,omitempty"`
+	ContextShifts int    `json:"context_shifts,omitempty"`
+
+	// PromptTruncated is the number of prompt inputs discarded to fit the
+	// prompt in the context window
+	PromptTruncated int `json:"prompt_truncated,omitempty"`
+
+	Logprobs []api.Logprob `json:"logprobs,omitempty"`
+
+	Profile []api.ProfileOp `json:"profile,omitempty"`
+
 	Timings Timings `json:"timings"`
 }
 
 func (s *Server) completion(w http.ResponseWriter, r *http.Request) {
 	var req CompletionRequest
-	req.Options = Options(api.DefaultOptions())
+	req.Options = defaultOptions()
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Bad request", http.StatusBadRequest)
 		return
@@ -640,8 +1151,16 @@ func (s *Server) completion(w http.ResponseWriter, r *http.Request) {
 	seq, err := s.NewSequence(req.Prompt, req.Images, NewSequenceParams{
 		numPredict:     req.NumPredict,
 		stop:           req.Stop,
+		stopRegex:      req.StopRegex,
 		numKeep:        req.NumKeep,
 		samplingParams: &samplingParams,
+		tokenHealing:   req.TokenHealing,
+		includeStop:    req.IncludeStop,
+		ignoreEOS:      req.IgnoreEOS,
+		logprobs:       req.Logprobs,
+		topLogprobs:    req.TopLogprobs,
+		watermarkKey:   req.WatermarkKey,
+		scripts:        req.Scripts,
 		embedding:      false,
 	})
 	if err != nil {
@@ -660,6 +1179,13 @@ func (s *Server) completion(w http.ResponseWriter, r *http.Request) {
 	}
 
 	s.mu.Lock()
+	if req.Profile && s.profiling != nil {
+		s.mu.Unlock()
+		s.seqsSem.Release(1)
+		http.Error(w, "another request is being profiled", http.StatusConflict)
+		return
+	}
+
 	found := false
 	for i, sq := range s.seqs {
 		if sq == nil {
@@ -670,9 +1196,16 @@ func (s *Server) completion(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 
+			// prompts that aren't cached aren't kept for later prompts either
+			seq.clearCache = !req.CachePrompt
 			seq.crossAttention = s.image.NeedCrossAttention(seq.cache.Inputs...)
+			seq.crossAttentionImages = lastImageGroup(seq.cache.Inputs)
 
 			s.seqs[i] = seq
+			if req.Profile {
+				s.profiling = seq
+				s.lc.StartProfile()
+			}
 			s.cond.Signal()
 			found = true
 			break
@@ -690,10 +1223,11 @@ func (s *Server) completion(w http.ResponseWriter, r *http.Request) {
 		case <-r.Context().Done():
 			close(seq.quit)
 			return
-		case content, ok := <-seq.responses:
+		case resp, ok := <-seq.responses:
 			if ok {
 				if err := json.NewEncoder(w).Encode(&CompletionResponse{
-					Content: content,
+					Content:  resp.content,
+					Logprobs: resp.logprobs,
 				}); err != nil {
 					http.Error(w, fmt.Sprintf("failed to encode response: %v", err), http.StatusInternalServerError)
 					close(seq.quit)
@@ -704,13 +1238,20 @@ func (s *Server) completion(w http.ResponseWriter, r *http.Request) {
 			} else {
 				// Send the final response
 				if err := json.NewEncoder(w).Encode(&CompletionResponse{
-					Stop:         true,
-					StoppedLimit: seq.doneReason == "limit",
+					Stop:            true,
+					StoppedLimit:    seq.doneReason == "limit",
+					AbortReason:     seq.abortReason,
+					AbortMessage:    seq.abortMessage,
+					ContextShifts:   seq.contextShifts,
+					PromptTruncated: seq.promptTruncated,
+					Profile:         seq.profile,
 					Timings: Timings{
 						PromptN:     seq.numPromptInputs,
 						PromptMS:    float64(seq.startGenerationTime.Sub(seq.startProcessingTime).Milliseconds()),
 						PredictedN:  seq.numDecoded,
 						PredictedMS: float64(time.Since(seq.startGenerationTime).Milliseconds()),
+						DraftN:      seq.numDrafted,
+						DraftAccN:   seq.numDraftAccepted,
 					},
 				}); err != nil {
 					http.Error(w, fmt.Sprintf("failed to encode final response: %v", err), http.StatusInternalServerError)
@@ -793,6 +1334,11 @@ func (s *Server) embeddings(w http.ResponseWriter, r *http.Request) {
 type HealthResponse struct {
 	Status   string  `json:"status"`
 	Progress float32 `json:"progress"`
+
+	// KvCacheUsed and KvCacheSize are the tokens stored in the KV cache and
+	// the tokens it can hold, once the model has loaded
+	KvCacheUsed int `json:"kv_cache_used,omitempty"`
+	KvCacheSize int `json:"kv_cache_size,omitempty"`
 }
 
 type ServerStatus int
@@ -814,12 +1360,189 @@ func (s ServerStatus) ToString() string {
 	}
 }
 
+type PurgeResponse struct {
+	Slots int `json:"slots"`
+}
+
+type ParallelRequest struct {
+	Parallel int `json:"parallel"`
+}
+
+type ParallelResponse struct {
+	Parallel int `json:"parallel"`
+}
+
+// setParallel changes the number of sequences handled simultaneously,
+// re-partitioning the KV cache without reloading the model
+func (s *Server) setParallel(w http.ResponseWriter, r *http.Request) {
+	var req ParallelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("bad request: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	if s.status != ServerStatusReady {
+		http.Error(w, "model is still loading", http.StatusServiceUnavailable)
+		return
+	}
+
+	if s.newContext == nil {
+		http.Error(w, "the number of parallel sequences can't be changed with adapters, projectors or draft models", http.StatusNotImplemented)
+		return
+	}
+
+	if req.Parallel < 1 || req.Parallel > s.maxParallel {
+		http.Error(w, fmt.Sprintf("parallel must be between 1 and %d", s.maxParallel), http.StatusBadRequest)
+		return
+	}
+
+	s.resizeMu.Lock()
+	defer s.resizeMu.Unlock()
+
+	if req.Parallel != s.parallel {
+		if err := s.resize(r.Context(), req.Parallel); err != nil {
+			http.Error(w, fmt.Sprintf("failed to change parallel sequences: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&ParallelResponse{Parallel: s.parallel}); err != nil {
+		http.Error(w, fmt.Sprintf("failed to encode response: %v", err), http.StatusInternalServerError)
+	}
+}
+
+// resize recreates the context with a KV cache partitioned for numParallel
+// sequences of the same length. The new context is created before the
+// current one is freed, which is kept if it can't be, and the KV cache of
+// the sequences in progress and of cached prompts is moved to it, so
+// sequences continue where they were. Scaling down waits for enough
+// sequences to finish for the rest to fit.
+func (s *Server) resize(ctx context.Context, numParallel int) error {
+	// slots past the runner's parallel are always held, so scaling down
+	// holds the slots it removes
+	prev := s.parallel
+	if numParallel < prev {
+		if err := s.seqsSem.Acquire(ctx, int64(prev-numParallel)); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.replaceContext(numParallel); err != nil {
+		if numParallel < prev {
+			s.seqsSem.Release(int64(prev - numParallel))
+		}
+		return err
+	}
+
+	if numParallel > prev {
+		s.seqsSem.Release(int64(numParallel - prev))
+	}
+
+	slog.Info("changed parallel sequences", "parallel", numParallel, "previous", prev, "kv_size", s.cache.numCtx*numParallel)
+	return nil
+}
+
+// replaceContext replaces the context with one for numParallel sequences,
+// moving the KV cache and sequences to it. s.mu must be held.
+func (s *Server) replaceContext(numParallel int) error {
+	if s.profiling != nil {
+		return errors.New("a request is being profiled")
+	}
+
+	lc, err := s.newContext(numParallel)
+	if err != nil {
+		return err
+	}
+
+	cache, moved, err := s.cache.resize(lc, numParallel, func(from, to int) error {
+		return lc.StateSeqSetData(s.lc.StateSeqGetData(from), to)
+	})
+	if err != nil {
+		lc.Free()
+		return err
+	}
+
+	seqs := make([]*Sequence, numParallel)
+	var n int
+	for _, seq := range s.seqs {
+		if seq != nil {
+			seq.cache = moved[seq.cache.Id]
+			seqs[n] = seq
+			n++
+		}
+	}
+
+	s.lc.Free()
+	s.lc = lc
+	s.cache = cache
+	s.seqs = seqs
+	s.nextSeq = 0
+	s.parallel = numParallel
+	return nil
+}
+
+// purge discards the prompts kept in the cache, so later prompts can't
+// reuse them. The prompts of sequences in progress are discarded once they
+// finish.
+func (s *Server) purge(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var resp PurgeResponse
+	if s.status == ServerStatusReady && s.cache != nil {
+		for _, seq := range s.seqs {
+			if seq != nil {
+				seq.clearCache = true
+			}
+		}
+
+		resp.Slots = s.cache.ClearIdleSlots(time.Now())
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&resp); err != nil {
+		http.Error(w, fmt.Sprintf("failed to encode response: %v", err), http.StatusInternalServerError)
+	}
+}
+
+// expireCache discards the prompts that haven't been reused for ttl from
+// the cache until ctx is done
+func (s *Server) expireCache(ctx context.Context, ttl time.Duration) {
+	ticker := time.NewTicker(min(ttl, time.Minute))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.mu.Lock()
+			if s.status == ServerStatusReady && s.cache != nil {
+				if n := s.cache.ClearIdleSlots(now.Add(-ttl)); n > 0 {
+					slog.Debug("expired cached prompts", "slots", n)
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
 func (s *Server) health(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(&HealthResponse{
+	resp := HealthResponse{
 		Status:   s.status.ToString(),
 		Progress: s.progress,
-	}); err != nil {
+	}
+
+	if s.status == ServerStatusReady && s.cache != nil {
+		resp.KvCacheUsed, resp.KvCacheSize = s.cache.Usage()
+	}
+
+	if err := json.NewEncoder(w).Encode(&resp); err != nil {
 		http.Error(w, fmt.Sprintf("failed to encode response: %v", err), http.StatusInternalServerError)
 	}
 }
@@ -845,6 +1568,9 @@ func (s *Server) loadModel(
 	flashAttention bool,
 	threads int,
 	multiUserCache bool,
+	draftPath string,
+	draftParams llama.ModelParams,
+	draftMax int,
 ) {
 	llama.BackendInit()
 
@@ -855,6 +1581,7 @@ func (s *Server) loadModel(
 	}
 
 	ctxParams := llama.NewContextParams(kvSize, s.batchSize*s.parallel, s.parallel, threads, flashAttention, kvCacheType)
+	ctxParams.SetCrossAttentionImages(s.maxImages)
 	s.lc, err = llama.NewContextWithModel(s.model, ctxParams)
 	if err != nil {
 		panic(err)
@@ -882,6 +1609,27 @@ func (s *Server) loadModel(
 		panic(err)
 	}
 
+	// adapters, projectors and draft models are tied to the context they
+	// were loaded with
+	if lpath.String() == "" && ppath == "" && draftPath == "" {
+		numCtx := kvSize / s.parallel
+		s.newContext = func(numParallel int) (*llama.Context, error) {
+			params := llama.NewContextParams(numCtx*numParallel, s.batchSize*numParallel, numParallel, threads, flashAttention, kvCacheType)
+			return llama.NewContextWithModel(s.model, params)
+		}
+	}
+
+	if draftPath != "" {
+		if s.image != nil {
+			slog.Warn("draft models aren't supported with projectors, not using speculative decoding")
+		} else {
+			s.draft, err = newDraftModel(draftPath, draftParams, s.model, kvSize, s.batchSize, s.parallel, threads, flashAttention, kvCacheType, draftMax)
+			if err != nil {
+				panic(err)
+			}
+		}
+	}
+
 	s.status = ServerStatusReady
 	s.ready.Done()
 }
@@ -894,6 +1642,7 @@ func Execute(args []string) error {
 	mpath := fs.String("model", "", "Path to model binary file")
 	ppath := fs.String("mmproj", "", "Path to projector binary file")
 	parallel := fs.Int("parallel", 1, "Number of sequences to handle simultaneously")
+	maxParallel := fs.Int("max-parallel", 0, "Maximum number of sequences the runner can be changed to handle simultaneously (default: parallel)")
 	batchSize := fs.Int("batch-size", 512, "Batch size")
 	nGpuLayers := fs.Int("n-gpu-layers", 0, "Number of layers to offload to GPU")
 	mainGpu := fs.Int("main-gpu", 0, "Main GPU")
@@ -907,6 +1656,11 @@ func Execute(args []string) error {
 	mlock := fs.Bool("mlock", false, "force system to keep model in RAM rather than swapping or compressing")
 	tensorSplit := fs.String("tensor-split", "", "fraction of the model to offload to each GPU, comma-separated list of proportions")
 	multiUserCache := fs.Bool("multiuser-cache", false, "optimize input cache algorithm for multiple users")
+	maxImages := fs.Int("max-images", 1, "Maximum number of consecutive images for models with cross attention")
+	draftPath := fs.String("draft-model", "", "Path to draft model binary file for speculative decoding")
+	draftMax := fs.Int("draft-max", 16, "Maximum number of tokens to draft at a time")
+	nGpuLayersDraft := fs.Int("n-gpu-layers-draft", 0, "Number of layers of the draft model to offload to GPU")
+	cacheTTL := fs.Duration("cache-ttl", 0, "Discard cached prompts that haven't been reused for this long (default: never)")
 
 	var lpaths multiLPath
 	fs.Var(&lpaths, "lora", "Path to lora layer file (can be specified multiple times)")
@@ -939,13 +1693,19 @@ func Execute(args []string) error {
 	slog.Info("system", "info", llama.PrintSystemInfo(), "threads", *threads)
 
 	server := &Server{
-		batchSize: *batchSize,
-		parallel:  *parallel,
-		seqs:      make([]*Sequence, *parallel),
-		seqsSem:   semaphore.NewWeighted(int64(*parallel)),
-		status:    ServerStatusLoadingModel,
+		batchSize:   *batchSize,
+		parallel:    *parallel,
+		maxParallel: max(*maxParallel, *parallel),
+		maxImages:   max(*maxImages, 1),
+		seqs:        make([]*Sequence, *parallel),
+		status:      ServerStatusLoadingModel,
 	}
 
+	// the slots past parallel are held until the runner is changed to
+	// handle more sequences
+	server.seqsSem = semaphore.NewWeighted(int64(server.maxParallel))
+	server.seqsSem.TryAcquire(int64(server.maxParallel - server.parallel))
+
 	var tensorSplitFloats []float32
 	if *tensorSplit != "" {
 		stringFloats := regexp.MustCompile(",").Split(*tensorSplit, -1)
@@ -968,14 +1728,23 @@ func Execute(args []string) error {
 		},
 	}
 
+	draftParams := params
+	draftParams.NumGpuLayers = *nGpuLayersDraft
+	draftParams.UseMmap = !*noMmap
+	draftParams.Progress = nil
+
 	server.ready.Add(1)
-	go server.loadModel(params, *mpath, lpaths, *ppath, *kvSize, *kvCacheType, *flashAttention, *threads, *multiUserCache)
+	go server.loadModel(params, *mpath, lpaths, *ppath, *kvSize, *kvCacheType, *flashAttention, *threads, *multiUserCache, *draftPath, draftParams, *draftMax)
 
 	server.cond = sync.NewCond(&server.mu)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	go server.run(ctx)
 
+	if *cacheTTL > 0 {
+		go server.expireCache(ctx, *cacheTTL)
+	}
+
 	addr := "127.0.0.1:" + strconv.Itoa(*port)
 	listener, err := net.Listen("tcp", addr)
 	if err != nil {
@@ -989,6 +1758,8 @@ func Execute(args []string) error {
 	mux.HandleFunc("/embedding", server.embeddings)
 	mux.HandleFunc("/completion", server.completion)
 	mux.HandleFunc("/health", server.health)
+	mux.HandleFunc("/purge", server.purge)
+	mux.HandleFunc("/parallel", server.setParallel)
 
 	httpServer := http.Server{
 		Handler: mux,
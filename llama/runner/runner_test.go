@@ -0,0 +1,22 @@
+package runner
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestDefaultOptions(t *testing.T) {
+	got := reflect.ValueOf(defaultOptions())
+	want := reflect.ValueOf(api.DefaultOptions())
+	for i := range got.NumField() {
+		name := got.Type().Field(i).Name
+		w := want.FieldByName(name)
+		if !w.IsValid() {
+			t.Errorf("option %s isn't an api option", name)
+		} else if !reflect.DeepEqual(got.Field(i).Interface(), w.Interface()) {
+			t.Errorf("option %s: expected default %v, got %v", name, w, got.Field(i))
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package runner
+
+import (
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// scriptPenalty is subtracted from the logits of tokens with letters outside
+// the scripts of a sequence's response language. It's large enough that the
+// model rarely drifts into another script, but not a hard constraint, so
+// names and code it's certain of can still be generated.
+const scriptPenalty = 10
+
+// offScript reports whether piece has a letter that isn't in any of
+// scripts. Letters common to every script are always allowed.
+func offScript(piece string, scripts []*unicode.RangeTable) bool {
+	for _, r := range piece {
+		if unicode.IsLetter(r) && !unicode.In(r, scripts...) && !unicode.In(r, unicode.Common, unicode.Inherited) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// offScriptTokens returns which tokens have letters outside the named
+// Unicode scripts, such as "Latin" or "Han". It's nil if none of the names
+// are known scripts.
+func (s *Server) offScriptTokens(names []string) []bool {
+	names = slices.Sorted(slices.Values(names))
+	key := strings.Join(names, ",")
+
+	s.scriptsMu.Lock()
+	defer s.scriptsMu.Unlock()
+
+	if off, ok := s.offScript[key]; ok {
+		return off
+	}
+
+	var scripts []*unicode.RangeTable
+	for _, name := range names {
+		if table, ok := unicode.Scripts[name]; ok {
+			scripts = append(scripts, table)
+		}
+	}
+
+	var off []bool
+	if len(scripts) > 0 {
+		off = make([]bool, s.model.NumVocab())
+		for id, piece := range s.vocabulary() {
+			off[id] = offScript(piece, scripts)
+		}
+	}
+
+	if s.offScript == nil {
+		s.offScript = make(map[string][]bool)
+	}
+	s.offScript[key] = off
+	return off
+}
+
+// penalizeOffScript lowers the logits at iBatch of the tokens marked in off
+func (s *Server) penalizeOffScript(iBatch int, off []bool) {
+	logits := s.lc.GetLogitsIth(iBatch)
+	for id := range min(len(logits), len(off)) {
+		if off[id] {
+			logits[id] -= scriptPenalty
+		}
+	}
+}
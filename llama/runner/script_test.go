@@ -0,0 +1,30 @@
+package runner
+
+import (
+	"testing"
+	"unicode"
+)
+
+func TestOffScript(t *testing.T) {
+	japanese := []*unicode.RangeTable{unicode.Han, unicode.Hiragana, unicode.Katakana}
+	tests := []struct {
+		piece   string
+		scripts []*unicode.RangeTable
+		off     bool
+	}{
+		{piece: " hello", scripts: []*unicode.RangeTable{unicode.Latin}},
+		{piece: "привет", scripts: []*unicode.RangeTable{unicode.Latin}, off: true},
+		{piece: "こんにちは", scripts: japanese},
+		{piece: "カタカナ漢字", scripts: japanese},
+		{piece: "hello", scripts: japanese, off: true},
+		{piece: "123, ...!", scripts: japanese},
+		{piece: "ー", scripts: []*unicode.RangeTable{unicode.Han}},
+		{piece: "", scripts: japanese},
+	}
+
+	for _, tt := range tests {
+		if got := offScript(tt.piece, tt.scripts); got != tt.off {
+			t.Errorf("%q: expected %t, got %t", tt.piece, tt.off, got)
+		}
+	}
+}
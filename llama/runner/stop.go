@@ -1,42 +1,99 @@
 package runner
 
 import (
+	"regexp"
 	"strings"
+
+	"github.com/ollama/ollama/stop"
 )
 
-func findStop(sequence string, stops []string) (bool, string) {
-	for _, stop := range stops {
-		if strings.Contains(sequence, stop) {
-			return true, stop
+// patternLookahead is the text held back for stop patterns, since whether
+// text starts a match of a regular expression can't be known before the
+// rest is generated. Matches longer than this may be partly returned before
+// they are found.
+const patternLookahead = 64
+
+// stopMatcher finds the stop sequences of a sequence in its response, which
+// are matched as is, and its stop patterns, which are regular expressions
+type stopMatcher struct {
+	literals []string
+	patterns []*regexp.Regexp
+}
+
+func newStopMatcher(stops, patterns []string) (*stopMatcher, error) {
+	res, err := stop.Compile(patterns)
+	if err != nil {
+		return nil, err
+	}
+
+	m := stopMatcher{patterns: res}
+	for _, s := range stops {
+		if s != "" {
+			m.literals = append(m.literals, s)
 		}
 	}
 
-	return false, ""
+	return &m, nil
 }
 
-func containsStopSuffix(sequence string, stops []string) bool {
-	for _, stop := range stops {
-		for i := 1; i <= len(stop); i++ {
+// find returns the start and end of the first stop in sequence
+func (m *stopMatcher) find(sequence string) (start, end int, ok bool) {
+	start = -1
+	for _, stop := range m.literals {
+		if i := strings.Index(sequence, stop); i >= 0 && (start < 0 || i < start) {
+			start, end = i, i+len(stop)
+		}
+	}
+
+	for _, re := range m.patterns {
+		if loc := re.FindStringIndex(sequence); loc != nil && (start < 0 || loc[0] < start) {
+			start, end = loc[0], loc[1]
+		}
+	}
+
+	if start < 0 {
+		return 0, 0, false
+	}
+
+	return start, end, true
+}
+
+// lookahead returns the length of the end of sequence that may be the start
+// of a stop, which is held back until more of the response is generated
+func (m *stopMatcher) lookahead(sequence string) int {
+	var n int
+	if len(m.patterns) > 0 {
+		n = min(len(sequence), patternLookahead)
+	}
+
+	for _, stop := range m.literals {
+		for i := min(len(stop)-1, len(sequence)); i > n; i-- {
 			if strings.HasSuffix(sequence, stop[:i]) {
-				return true
+				n = i
+				break
 			}
 		}
 	}
 
-	return false
+	return n
 }
 
-// truncateStop removes the provided stop string from pieces,
-// returning the partial pieces with stop removed, including truncating
-// the last piece if required (and signalling if this was the case)
-func truncateStop(pieces []string, stop string) ([]string, bool) {
+// truncateStop removes the first stop, and anything after it, from pieces,
+// returning the partial pieces with stop removed, including truncating the
+// last piece if required (and signalling if this was the case). If include
+// is set, the stop itself is kept.
+func truncateStop(pieces []string, m *stopMatcher, include bool) ([]string, bool) {
 	joined := strings.Join(pieces, "")
 
-	index := strings.Index(joined, stop)
-	if index == -1 {
+	index, end, ok := m.find(joined)
+	if !ok {
 		return pieces, false
 	}
 
+	if include {
+		index = end
+	}
+
 	joined = joined[:index]
 
 	// Split truncated string back into pieces of original lengths
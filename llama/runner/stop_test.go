@@ -2,6 +2,7 @@ package runner
 
 import (
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -10,6 +11,7 @@ func TestTruncateStop(t *testing.T) {
 		name          string
 		pieces        []string
 		stop          string
+		pattern       string
 		expected      []string
 		expectedTrunc bool
 	}{
@@ -48,11 +50,74 @@ func TestTruncateStop(t *testing.T) {
 			expected:      []string{"he"},
 			expectedTrunc: true,
 		},
+		{
+			name:          "Pattern",
+			pieces:        []string{"Answer: 4", "2", ".", " Next"},
+			pattern:       `\d+\.`,
+			expected:      []string{"Answer: "},
+			expectedTrunc: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var patterns []string
+			if tt.pattern != "" {
+				patterns = []string{tt.pattern}
+			}
+
+			m, err := newStopMatcher([]string{tt.stop}, patterns)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			result, resultTrunc := truncateStop(tt.pieces, m, false)
+			if !reflect.DeepEqual(result, tt.expected) || resultTrunc != tt.expectedTrunc {
+				t.Errorf("truncateStop(%v, %s): have %v (%v); want %v (%v)", tt.pieces, tt.stop, result, resultTrunc, tt.expected, tt.expectedTrunc)
+			}
+		})
+	}
+}
+
+func TestTruncateStopInclude(t *testing.T) {
+	tests := []struct {
+		name          string
+		pieces        []string
+		stop          string
+		expected      []string
+		expectedTrunc bool
+	}{
+		{
+			name:          "Single word",
+			pieces:        []string{"hello", "world"},
+			stop:          "world",
+			expected:      []string{"hello", "world"},
+			expectedTrunc: false,
+		},
+		{
+			name:          "Partial",
+			pieces:        []string{"hello", "world!"},
+			stop:          "wor",
+			expected:      []string{"hello", "wor"},
+			expectedTrunc: true,
+		},
+		{
+			name:          "Middle",
+			pieces:        []string{"hello", " wor", "ld"},
+			stop:          "llo w",
+			expected:      []string{"hello", " w"},
+			expectedTrunc: true,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result, resultTrunc := truncateStop(tt.pieces, tt.stop)
+			m, err := newStopMatcher([]string{tt.stop}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			result, resultTrunc := truncateStop(tt.pieces, m, true)
 			if !reflect.DeepEqual(result, tt.expected) || resultTrunc != tt.expectedTrunc {
 				t.Errorf("truncateStop(%v, %s): have %v (%v); want %v (%v)", tt.pieces, tt.stop, result, resultTrunc, tt.expected, tt.expectedTrunc)
 			}
@@ -60,6 +125,78 @@ func TestTruncateStop(t *testing.T) {
 	}
 }
 
+func TestStopMatcher(t *testing.T) {
+	m, err := newStopMatcher([]string{"</s>", "User:", ""}, []string{`\n{2,}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		sequence   string
+		start, end int
+		ok         bool
+	}{
+		{"hello</s> User:", 5, 9, true},
+		{"hello\n\n\nUser:", 5, 8, true},
+		{"User: hi\n\n", 0, 5, true},
+		{"hello\nworld", 0, 0, false},
+	} {
+		start, end, ok := m.find(tt.sequence)
+		if start != tt.start || end != tt.end || ok != tt.ok {
+			t.Errorf("find(%q): have %d, %d, %v; want %d, %d, %v", tt.sequence, start, end, ok, tt.start, tt.end, tt.ok)
+		}
+	}
+
+	m, err = newStopMatcher([]string{"</s>", "User:"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for sequence, want := range map[string]int{
+		"hello":       0,
+		"hello<":      1,
+		"hello</":     2,
+		"hello Use":   3,
+		"hello</s":    3,
+		"hello User":  4,
+		"hello User!": 0,
+	} {
+		if n := m.lookahead(sequence); n != want {
+			t.Errorf("lookahead(%q): have %d; want %d", sequence, n, want)
+		}
+	}
+
+	m, err = newStopMatcher(nil, []string{"[.!?] "})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	long := strings.Repeat("a", 2*patternLookahead)
+	if n := m.lookahead(long); n != patternLookahead {
+		t.Errorf("expected patterns to hold back %d bytes, have %d", patternLookahead, n)
+	}
+
+	if n := m.lookahead("short"); n != 5 {
+		t.Errorf("expected patterns to hold back short text, have %d", n)
+	}
+
+	// a literal stop that looks like a pattern is matched as is
+	m, err = newStopMatcher([]string{"/a*/"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if start, _, ok := m.find("x /a*/"); !ok || start != 2 {
+		t.Errorf("expected a literal match at 2, have %d, %v", start, ok)
+	}
+
+	for _, patterns := range [][]string{{"("}, {"a*"}} {
+		if _, err := newStopMatcher(nil, patterns); err == nil {
+			t.Errorf("expected %q to be invalid", patterns)
+		}
+	}
+}
+
 func TestIncompleteUnicode(t *testing.T) {
 	tests := []struct {
 		name     string
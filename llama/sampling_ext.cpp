@@ -2,6 +2,7 @@
 #include "sampling.h"
 #include "sampling_ext.h"
 #include "json-schema-to-grammar.h"
+#include "llama-grammar.h"
 
 struct common_sampler *common_sampler_cinit(const struct llama_model *model, struct common_sampler_cparams *params) {
     try {
@@ -65,3 +66,14 @@ int schema_to_grammar(const char *json_schema, char *grammar, size_t max_len)
         return 0;
     }
 }
+
+bool grammar_validate(const char *grammar)
+{
+    llama_grammar_parser parser;
+    if (!parser.parse(grammar))
+    {
+        return false;
+    }
+
+    return parser.symbol_ids.find("root") != parser.symbol_ids.end();
+}
@@ -36,6 +36,8 @@ extern "C"
 
     int schema_to_grammar(const char *json_schema, char *grammar, size_t max_len);
 
+    bool grammar_validate(const char *grammar);
+
 #ifdef __cplusplus
 }
 #endif
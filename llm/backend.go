@@ -0,0 +1,140 @@
+package llm
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/discover"
+	"github.com/ollama/ollama/envconfig"
+)
+
+// DefaultBackend is the backend models are run with unless another is
+// registered for their family or selected with OLLAMA_BACKEND. It runs models
+// with the bundled llama.cpp runners.
+const DefaultBackend = "llama"
+
+// ServerFunc starts a server running model on gpus. Backends are ServerFuncs
+// returning a LlamaServer, so the scheduler manages their servers like any
+// other.
+type ServerFunc func(gpus discover.GpuInfoList, model string, ggml *GGML, adapters, projectors []string, opts api.Options, numParallel int) (LlamaServer, error)
+
+var backends = struct {
+	mu sync.Mutex
+
+	// funcs are the registered backends by name
+	funcs map[string]ServerFunc
+
+	// families are the backends registered for model families
+	families map[string]string
+}{
+	funcs:    map[string]ServerFunc{DefaultBackend: NewLlamaServer},
+	families: map[string]string{},
+}
+
+// RegisterBackend registers fn as the backend name, replacing any backend
+// already registered with that name. Models of families are run with it
+// unless OLLAMA_BACKEND selects another backend for them.
+func RegisterBackend(name string, fn ServerFunc, families ...string) {
+	backends.mu.Lock()
+	defer backends.mu.Unlock()
+
+	backends.funcs[name] = fn
+	for _, family := range families {
+		backends.families[family] = name
+	}
+}
+
+// Backends returns the names of the registered backends, sorted
+func Backends() []string {
+	backends.mu.Lock()
+	defer backends.mu.Unlock()
+
+	names := make([]string, 0, len(backends.funcs))
+	for name := range backends.funcs {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+	return names
+}
+
+// NewServer starts a server running model with the backend selected for its
+// family
+func NewServer(gpus discover.GpuInfoList, model string, ggml *GGML, adapters, projectors []string, opts api.Options, numParallel int) (LlamaServer, error) {
+	family := ggml.KV().Architecture()
+	name, fn, err := backendFor(family)
+	if err != nil {
+		return nil, err
+	}
+
+	if name != DefaultBackend {
+		slog.Info("running model with backend", "model", model, "family", family, "backend", name)
+	}
+
+	return fn(gpus, model, ggml, adapters, projectors, opts, numParallel)
+}
+
+// backendFor returns the backend models of family are run with. Backends
+// selected with OLLAMA_BACKEND for the family take precedence over one
+// selected for every family, which takes precedence over one registered for
+// the family.
+func backendFor(family string) (string, ServerFunc, error) {
+	selected, err := parseBackends(envconfig.Backend())
+	if err != nil {
+		return "", nil, err
+	}
+
+	backends.mu.Lock()
+	defer backends.mu.Unlock()
+
+	name := DefaultBackend
+	if s, ok := selected[family]; ok {
+		name = s
+	} else if s, ok := selected[""]; ok {
+		name = s
+	} else if s, ok := backends.families[family]; ok {
+		name = s
+	}
+
+	fn, ok := backends.funcs[name]
+	if !ok {
+		return "", nil, fmt.Errorf("no backend is registered as %q", name)
+	}
+
+	return name, fn, nil
+}
+
+// parseBackends parses OLLAMA_BACKEND, a comma-separated list of backends
+// for every family and family=backend pairs, into backends by family. The
+// backend for every family has an empty key.
+func parseBackends(s string) (map[string]string, error) {
+	selected := make(map[string]string)
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		family, name, ok := strings.Cut(entry, "=")
+		if !ok {
+			family, name = "", family
+		}
+
+		family, name = strings.TrimSpace(family), strings.TrimSpace(name)
+		if name == "" || (ok && family == "") {
+			return nil, fmt.Errorf("OLLAMA_BACKEND: invalid entry %q", entry)
+		}
+
+		if _, ok := selected[family]; ok {
+			return nil, fmt.Errorf("OLLAMA_BACKEND: duplicate entry %q", entry)
+		}
+
+		selected[family] = name
+	}
+
+	return selected, nil
+}
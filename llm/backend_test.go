@@ -0,0 +1,73 @@
+package llm
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/discover"
+)
+
+func TestBackendFor(t *testing.T) {
+	fake := func(discover.GpuInfoList, string, *GGML, []string, []string, api.Options, int) (LlamaServer, error) {
+		return nil, nil
+	}
+
+	RegisterBackend("mlx", fake, "gemma2")
+	RegisterBackend("vllm", fake)
+	t.Cleanup(func() {
+		backends.mu.Lock()
+		defer backends.mu.Unlock()
+		delete(backends.funcs, "mlx")
+		delete(backends.funcs, "vllm")
+		delete(backends.families, "gemma2")
+	})
+
+	if diff := cmp.Diff([]string{"llama", "mlx", "vllm"}, Backends()); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+
+	cases := []struct {
+		env    string
+		family string
+		want   string
+		err    bool
+	}{
+		{family: "llama", want: "llama"},
+		{family: "gemma2", want: "mlx"},
+		{env: "vllm", family: "llama", want: "vllm"},
+		{env: "vllm", family: "gemma2", want: "vllm"},
+		{env: "llama=vllm", family: "llama", want: "vllm"},
+		{env: "llama=vllm", family: "gemma2", want: "mlx"},
+		{env: "llama, gemma2 = vllm", family: "gemma2", want: "vllm"},
+		{env: "llama, gemma2 = vllm", family: "qwen2", want: "llama"},
+		{env: "tensorrt", family: "llama", err: true},
+		{env: "=vllm", family: "llama", err: true},
+		{env: "llama=", family: "llama", err: true},
+		{env: "llama=vllm,llama=mlx", family: "llama", err: true},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.env+"/"+tt.family, func(t *testing.T) {
+			t.Setenv("OLLAMA_BACKEND", tt.env)
+			got, fn, err := backendFor(tt.family)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("expected error, got %q", got)
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+
+			if fn == nil {
+				t.Error("expected a backend")
+			}
+		})
+	}
+}
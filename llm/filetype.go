@@ -183,3 +183,39 @@ func (t fileType) String() string {
 func (t fileType) Value() uint32 {
 	return uint32(t)
 }
+
+// TensorKind returns the tensor type used for the bulk of the weights in a
+// file of type t. Files with mixed types use some other types for a few
+// tensors so this is only suitable for estimates.
+func (t fileType) TensorKind() (uint32, bool) {
+	switch t {
+	case fileTypeF32:
+		return 0, true
+	case fileTypeF16:
+		return 1, true
+	case fileTypeQ4_0:
+		return 2, true
+	case fileTypeQ4_1:
+		return 3, true
+	case fileTypeQ5_0:
+		return 6, true
+	case fileTypeQ5_1:
+		return 7, true
+	case fileTypeQ8_0:
+		return 8, true
+	case fileTypeQ2_K, fileTypeQ2_K_S:
+		return 10, true
+	case fileTypeQ3_K_S, fileTypeQ3_K_M, fileTypeQ3_K_L:
+		return 11, true
+	case fileTypeQ4_K_S, fileTypeQ4_K_M:
+		return 12, true
+	case fileTypeQ5_K_S, fileTypeQ5_K_M:
+		return 13, true
+	case fileTypeQ6_K:
+		return 14, true
+	case fileTypeBF16:
+		return 30, true
+	default:
+		return 0, false
+	}
+}
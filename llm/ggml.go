@@ -5,10 +5,12 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math/bits"
 	"slices"
 	"strings"
 	"sync"
 
+	"github.com/ollama/ollama/envconfig"
 	"github.com/ollama/ollama/util/bufioutil"
 )
 
@@ -30,6 +32,8 @@ func (kv KV) u64(key string) uint64 {
 		return v
 	case uint32:
 		return uint64(v)
+	case int32:
+		return uint64(max(v, 0))
 	case float64:
 		return uint64(v)
 	default:
@@ -122,6 +126,153 @@ func (kv KV) ChatTemplate() string {
 	return s
 }
 
+func (kv KV) TokenizerPre() string {
+	s, _ := kv["tokenizer.ggml.pre"].(string)
+	return s
+}
+
+// SpecialToken returns the id of the tokenizer's special token of kind, such
+// as "eos", if it has one
+func (kv KV) SpecialToken(kind string) (int, bool) {
+	key := fmt.Sprintf("tokenizer.ggml.%s_token_id", kind)
+	if _, ok := kv[key]; !ok {
+		return 0, false
+	}
+
+	return int(kv.u64(key)), true
+}
+
+// AddSpecialToken reports whether the tokenizer adds its special token of
+// kind, "bos" or "eos", to each sequence it tokenizes with special tokens
+func (kv KV) AddSpecialToken(kind string) bool {
+	if _, ok := kv.SpecialToken(kind); !ok {
+		return false
+	}
+
+	if add, ok := kv[fmt.Sprintf("tokenizer.ggml.add_%s_token", kind)].(bool); ok {
+		return add
+	}
+
+	return kind == "bos"
+}
+
+// PoolingTypeRank is the pooling type of rerankers, which score sequences
+// with a classification head rather than embedding them
+const PoolingTypeRank = 4
+
+// PoolingType returns how the model pools the embeddings of a sequence, such
+// as PoolingTypeRank for rerankers
+func (kv KV) PoolingType() uint64 {
+	return kv.u64(fmt.Sprintf("%s.pooling_type", kv.Architecture()))
+}
+
+func (kv KV) VocabSize() uint64 {
+	if a, ok := kv["tokenizer.ggml.tokens"].(*array); ok {
+		return uint64(a.size)
+	}
+
+	return 0
+}
+
+// Token returns the text of the token with id. The vocabulary is only read
+// if the model was loaded without limiting the size of arrays.
+func (kv KV) Token(id int) (string, bool) {
+	if a, ok := kv["tokenizer.ggml.tokens"].(*array); ok && id >= 0 && id < len(a.values) {
+		s, ok := a.values[id].(string)
+		return s, ok
+	}
+
+	return "", false
+}
+
+// HasToken reports whether the vocabulary has token. The vocabulary is only
+// read if the model was loaded without limiting the size of arrays.
+func (kv KV) HasToken(token string) bool {
+	if a, ok := kv["tokenizer.ggml.tokens"].(*array); ok {
+		return slices.Contains(a.values, any(token))
+	}
+
+	return false
+}
+
+// VisionEncoder reports whether a projector encodes images
+func (kv KV) VisionEncoder() bool {
+	if kv.Architecture() == "mllama" {
+		return true
+	}
+
+	v, _ := kv["clip.has_vision_encoder"].(bool)
+	return v
+}
+
+// ImageTokens returns the number of embeddings a projector produces for an
+// image, each taking a position in the context. Projectors that slice images
+// into tiles produce a number of embeddings that depends on the image, in
+// which case the largest is returned. It returns 0 if the projector isn't
+// known.
+func (kv KV) ImageTokens() uint64 {
+	switch kv.Architecture() {
+	case "mllama":
+		// the mllama runner packs all of the embeddings of an image into a single token
+		return 1
+	case "clip":
+	default:
+		return 0
+	}
+
+	imageSize, patchSize := kv.u64("clip.vision.image_size"), kv.u64("clip.vision.patch_size")
+	if imageSize == 0 || patchSize == 0 {
+		return 0
+	}
+
+	patchesPerSide := imageSize / patchSize
+	n := patchesPerSide * patchesPerSide
+
+	projectorType, _ := kv["clip.projector_type"].(string)
+	if b, _ := kv["clip.has_minicpmv_projector"].(bool); b {
+		projectorType = "resampler"
+	} else if b, _ := kv["clip.has_qwen2vl_merger"].(bool); b {
+		projectorType = "qwen2vl_merger"
+	}
+
+	switch projectorType {
+	case "ldp", "ldpv2":
+		n /= 4
+	case "resampler":
+		switch kv.u64("clip.minicpmv_version") {
+		case 0, 2:
+			n = 96
+		case 3:
+			n = 64
+		}
+
+		// minicpmv encodes an overview of the image and up to 9 slices of it
+		return n * 10
+	case "qwen2vl_merger":
+		// neighbouring patches are merged in 2x2 groups. the count is for an
+		// image at the projector's resolution since images aren't resized
+		side := (imageSize + 2*patchSize - 1) / (2 * patchSize)
+		n = side * side
+	}
+
+	if kv["clip.vision.mm_patch_merge_type"] == "spatial_unpad" {
+		// the image is encoded once at the projector's resolution and once as
+		// a grid of tiles at the largest resolution it fits
+		var tiles uint64
+		if a, ok := kv["clip.vision.image_grid_pinpoints"].(*array); ok {
+			for i := 0; i+1 < len(a.values); i += 2 {
+				w, _ := a.values[i].(int32)
+				h, _ := a.values[i+1].(int32)
+				tiles = max(tiles, uint64(max(w, 0))/imageSize*(uint64(max(h, 0))/imageSize))
+			}
+		}
+
+		n *= 1 + tiles
+	}
+
+	return n
+}
+
 type Tensors struct {
 	Items  []*Tensor
 	Offset uint64
@@ -184,6 +335,76 @@ func (t Tensor) block() (n int) {
 	return
 }
 
+// Type returns the name of the tensor's data type, e.g. Q4_K
+func (t Tensor) Type() string {
+	switch t.Kind {
+	case 0:
+		return "F32"
+	case 1:
+		return "F16"
+	case 2:
+		return "Q4_0"
+	case 3:
+		return "Q4_1"
+	case 4:
+		return "Q4_2"
+	case 5:
+		return "Q4_3"
+	case 6:
+		return "Q5_0"
+	case 7:
+		return "Q5_1"
+	case 8:
+		return "Q8_0"
+	case 9:
+		return "Q8_1"
+	case 10:
+		return "Q2_K"
+	case 11:
+		return "Q3_K"
+	case 12:
+		return "Q4_K"
+	case 13:
+		return "Q5_K"
+	case 14:
+		return "Q6_K"
+	case 15:
+		return "Q8_K"
+	case 16:
+		return "IQ2_XXS"
+	case 17:
+		return "IQ2_XS"
+	case 18:
+		return "IQ3_XXS"
+	case 19:
+		return "IQ1_S"
+	case 20:
+		return "IQ4_NL"
+	case 21:
+		return "IQ3_S"
+	case 22:
+		return "IQ2_S"
+	case 23:
+		return "IQ4_XS"
+	case 24:
+		return "I8"
+	case 25:
+		return "I16"
+	case 26:
+		return "I32"
+	case 27:
+		return "I64"
+	case 28:
+		return "F64"
+	case 29:
+		return "IQ1_M"
+	case 30:
+		return "BF16"
+	default:
+		return "unknown"
+	}
+}
+
 func (t Tensor) blockSize() uint64 {
 	switch t.Kind {
 	case 0, 1, 24, 25, 26, 27, 28, 30: // F32, F16, I8, I16, I32, I64, F64, BF16
@@ -270,6 +491,25 @@ func (t Tensor) parameters() uint64 {
 	return count
 }
 
+// checkedParameters returns the number of parameters in the tensor and false
+// if computing it or the tensor's size in bytes would overflow
+func (t Tensor) checkedParameters() (uint64, bool) {
+	var count uint64 = 1
+	for _, n := range t.Shape {
+		hi, lo := bits.Mul64(count, n)
+		if hi != 0 {
+			return 0, false
+		}
+		count = lo
+	}
+
+	if hi, _ := bits.Mul64(count, t.typeSize()); hi != 0 {
+		return 0, false
+	}
+
+	return count, true
+}
+
 func (t Tensor) Size() uint64 {
 	return t.parameters() * t.typeSize() / t.blockSize()
 }
@@ -407,6 +647,9 @@ func (llm GGML) GraphSize(context, batch uint64, kvCacheType string) (kv, partia
 	case "mllama":
 		var visionTokens, tiles uint64 = 1601, 4
 
+		// the cross attention state holds every image of a message
+		images := uint64(max(envconfig.MaxImages(), 1))
+
 		if crossAttentionLayers, ok := llm.KV()["mllama.attention.cross_attention_layers"].(*array); ok {
 			kv = headsKV *
 				(embeddingHeadsK + embeddingHeadsV) * // one for K, one for V
@@ -416,7 +659,8 @@ func (llm GGML) GraphSize(context, batch uint64, kvCacheType string) (kv, partia
 					4* // sizeof(float32)
 						uint64(crossAttentionLayers.size)* // num cross attention layers
 						visionTokens*
-						tiles)
+						tiles*
+						images)
 		}
 
 		fullOffload = max(
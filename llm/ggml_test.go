@@ -1 +1,52 @@
 package llm
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestImageTokens(t *testing.T) {
+	clip := func(kv KV) KV {
+		kv["general.architecture"] = "clip"
+		kv["clip.vision.image_size"] = uint32(336)
+		kv["clip.vision.patch_size"] = uint32(14)
+		return kv
+	}
+
+	cases := []struct {
+		name string
+		kv   KV
+		want uint64
+	}{
+		{"mllama", KV{"general.architecture": "mllama"}, 1},
+		{"llava", clip(KV{"clip.projector_type": "mlp"}), 576},
+		{"llava without projector type", clip(KV{}), 576},
+		{"ldp", clip(KV{"clip.projector_type": "ldpv2"}), 144},
+		{"minicpmv 2.5", clip(KV{"clip.projector_type": "resampler", "clip.minicpmv_version": int32(2)}), 960},
+		{"minicpmv 2.6", clip(KV{"clip.has_minicpmv_projector": true, "clip.minicpmv_version": int32(3)}), 640},
+		{"qwen2vl", clip(KV{"clip.has_qwen2vl_merger": true}), 144},
+		{
+			"llava 1.6",
+			clip(KV{
+				"clip.vision.mm_patch_merge_type":  "spatial_unpad",
+				"clip.vision.image_grid_pinpoints": []int32{336, 672, 672, 336, 672, 672, 1008, 336, 336, 1008},
+			}),
+			576 * 5,
+		},
+		{"missing sizes", KV{"general.architecture": "clip"}, 0},
+		{"unknown", KV{"general.architecture": "llama"}, 0},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			ggml, _, err := DecodeGGML(bytes.NewReader(writeTestGGUF(t, tt.kv, nil)), 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := ggml.KV().ImageTokens(); got != tt.want {
+				t.Errorf("expected %d image tokens, got %d", tt.want, got)
+			}
+		})
+	}
+}
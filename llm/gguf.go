@@ -5,9 +5,12 @@ import (
 	"cmp"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"math"
+	"math/bits"
 	"slices"
 	"strings"
 
@@ -50,13 +53,31 @@ func (c *containerGGUF) Decode(rs io.ReadSeeker) (model, error) {
 		return nil, err
 	}
 
+	// Some tools running on big-endian hosts keep the little-endian magic
+	// but byte swap everything that follows. Versions are small so a value
+	// with only the high bytes set means the rest of the file is swapped.
+	if c.Version != 0 && c.Version&0xffff == 0 {
+		c.Version = bits.ReverseBytes32(c.Version)
+		if c.ByteOrder == binary.LittleEndian {
+			c.ByteOrder = binary.BigEndian
+		} else {
+			c.ByteOrder = binary.LittleEndian
+		}
+	}
+
 	var err error
-	switch c.Version {
-	case 1:
+	switch {
+	case c.Version == 0:
+		return nil, fmt.Errorf("%w: gguf version %d", ErrUnsupportedFormat, c.Version)
+	case c.Version == 1:
 		err = binary.Read(rs, c.ByteOrder, &c.V1)
-	case 2:
+	case c.Version == 2:
 		err = binary.Read(rs, c.ByteOrder, &c.V2)
 	default:
+		// v3 and later revisions share the same header layout
+		if c.Version > ggufVersion {
+			slog.Debug("gguf version is newer than expected, decoding as v3", "version", c.Version)
+		}
 		err = binary.Read(rs, c.ByteOrder, &c.V3)
 	}
 	if err != nil {
@@ -71,6 +92,23 @@ func (c *containerGGUF) Decode(rs io.ReadSeeker) (model, error) {
 	return model, nil
 }
 
+const (
+	// ggufVersion is the version written by WriteGGUF
+	ggufVersion uint32 = 3
+
+	// ggufMaxDims is the maximum number of dimensions in a tensor
+	ggufMaxDims = 4
+
+	// ggufMaxStringLength bounds the size of a single string to guard against
+	// corrupt or malicious files requesting huge allocations
+	ggufMaxStringLength = 1 << 30
+
+	// ggufDefaultAlignment is the tensor data alignment when general.alignment is unset
+	ggufDefaultAlignment = 32
+)
+
+var errGGUFTooLarge = errors.New("gguf value too large")
+
 const (
 	ggufTypeUint8 uint32 = iota
 	ggufTypeInt8
@@ -204,6 +242,10 @@ func (llm *gguf) Decode(rs io.ReadSeeker) error {
 			return fmt.Errorf("failed to read tensor dimensions: %w", err)
 		}
 
+		if dims > ggufMaxDims {
+			return fmt.Errorf("tensor %q has %d dimensions, maximum is %d", name, dims, ggufMaxDims)
+		}
+
 		shape := make([]uint64, dims)
 		for i := 0; uint32(i) < dims; i++ {
 			shape[i], err = readGGUF[uint64](llm, rs)
@@ -229,6 +271,10 @@ func (llm *gguf) Decode(rs io.ReadSeeker) error {
 			Shape:  shape[:],
 		}
 
+		if _, ok := tensor.checkedParameters(); !ok {
+			return fmt.Errorf("%w: tensor %q shape %v", errGGUFTooLarge, name, shape)
+		}
+
 		llm.tensors = append(llm.tensors, &tensor)
 		llm.parameters += tensor.parameters()
 	}
@@ -238,7 +284,9 @@ func (llm *gguf) Decode(rs io.ReadSeeker) error {
 
 	alignment, ok := llm.kv["general.alignment"].(uint32)
 	if !ok {
-		alignment = 32
+		alignment = ggufDefaultAlignment
+	} else if alignment == 0 || alignment&(alignment-1) != 0 {
+		return fmt.Errorf("invalid alignment: %d", alignment)
 	}
 
 	offset, err := rs.Seek(0, io.SeekCurrent)
@@ -260,7 +308,12 @@ func (llm *gguf) Decode(rs io.ReadSeeker) error {
 			return fmt.Errorf("failed to seek to init padding: %w", err)
 		}
 
-		if _, err := rs.Seek(int64(tensor.Size()), io.SeekCurrent); err != nil {
+		size := tensor.Size()
+		if size > math.MaxInt64 {
+			return fmt.Errorf("%w: tensor %q size %d", errGGUFTooLarge, tensor.Name, size)
+		}
+
+		if _, err := rs.Seek(int64(size), io.SeekCurrent); err != nil {
 			return fmt.Errorf("failed to seek to tensor: %w", err)
 		}
 	}
@@ -288,13 +341,19 @@ func readGGUFV1String(llm *gguf, r io.Reader) (string, error) {
 		return "", err
 	}
 
+	if length > ggufMaxStringLength {
+		return "", fmt.Errorf("%w: string length %d", errGGUFTooLarge, length)
+	}
+
 	var b bytes.Buffer
 	if _, err := io.CopyN(&b, r, int64(length)); err != nil {
 		return "", err
 	}
 
 	// gguf v1 strings are null-terminated
-	b.Truncate(b.Len() - 1)
+	if b.Len() > 0 {
+		b.Truncate(b.Len() - 1)
+	}
 
 	return b.String(), nil
 }
@@ -306,7 +365,12 @@ func discardGGUFString(llm *gguf, r io.Reader) error {
 		return err
 	}
 
-	size := int(llm.ByteOrder.Uint64(buf))
+	length := llm.ByteOrder.Uint64(buf)
+	if length > ggufMaxStringLength {
+		return fmt.Errorf("%w: string length %d", errGGUFTooLarge, length)
+	}
+
+	size := int(length)
 	for size > 0 {
 		n, err := r.Read(llm.scratch[:min(size, cap(llm.scratch))])
 		if err != nil {
@@ -328,7 +392,12 @@ func readGGUFString(llm *gguf, r io.Reader) (string, error) {
 		return "", err
 	}
 
-	length := int(llm.ByteOrder.Uint64(buf))
+	n := llm.ByteOrder.Uint64(buf)
+	if n > ggufMaxStringLength {
+		return "", fmt.Errorf("%w: string length %d", errGGUFTooLarge, n)
+	}
+
+	length := int(n)
 	if length > len(llm.scratch) {
 		buf = make([]byte, length)
 	} else {
@@ -365,6 +434,18 @@ func (a *array) MarshalJSON() ([]byte, error) {
 	return json.Marshal(a.values)
 }
 
+// newArray returns an array of size n which collects its values if n is within
+// the container's limit. Capacity is grown as values are read rather than
+// trusting n so a corrupt length can't force a large allocation up front.
+func newArray(llm *gguf, n uint64) *array {
+	a := &array{size: int(n)}
+	if llm.canCollectArray(int(n)) {
+		a.values = make([]any, 0, min(n, 1<<16))
+	}
+
+	return a
+}
+
 func readGGUFV1Array(llm *gguf, r io.Reader) (*array, error) {
 	t, err := readGGUF[uint32](llm, r)
 	if err != nil {
@@ -376,12 +457,9 @@ func readGGUFV1Array(llm *gguf, r io.Reader) (*array, error) {
 		return nil, err
 	}
 
-	a := &array{size: int(n)}
-	if llm.canCollectArray(int(n)) {
-		a.values = make([]any, 0, int(n))
-	}
+	a := newArray(llm, uint64(n))
 
-	for i := range n {
+	for range n {
 		var e any
 		switch t {
 		case ggufTypeUint8:
@@ -416,7 +494,7 @@ func readGGUFV1Array(llm *gguf, r io.Reader) (*array, error) {
 		}
 
 		if a.values != nil {
-			a.values[i] = e
+			a.values = append(a.values, e)
 		}
 	}
 
@@ -438,12 +516,13 @@ func readGGUFArray(llm *gguf, r io.Reader) (*array, error) {
 		return nil, err
 	}
 
-	a := &array{size: int(n)}
-	if llm.canCollectArray(int(n)) {
-		a.values = make([]any, int(n))
+	if n > math.MaxInt32 {
+		return nil, fmt.Errorf("%w: array length %d", errGGUFTooLarge, n)
 	}
 
-	for i := range n {
+	a := newArray(llm, n)
+
+	for range n {
 		var e any
 		switch t {
 		case ggufTypeUint8:
@@ -482,7 +561,7 @@ func readGGUFArray(llm *gguf, r io.Reader) (*array, error) {
 		}
 
 		if a.values != nil {
-			a.values[i] = e
+			a.values = append(a.values, e)
 		}
 	}
 
@@ -511,7 +590,7 @@ func WriteGGUF(ws io.WriteSeeker, kv KV, ts []Tensor) error {
 		return err
 	}
 
-	if err := binary.Write(ws, binary.LittleEndian, uint32(3)); err != nil {
+	if err := binary.Write(ws, binary.LittleEndian, ggufVersion); err != nil {
 		return err
 	}
 
@@ -542,6 +621,13 @@ func WriteGGUF(ws io.WriteSeeker, kv KV, ts []Tensor) error {
 		}
 	})
 
+	var alignment int64 = ggufDefaultAlignment
+	if a, ok := kv["general.alignment"].(uint32); ok && a > 0 && a&(a-1) == 0 {
+		alignment = int64(a)
+	}
+
+	// tensor offsets are relative to the start of the aligned data section so
+	// each one must include the padding written before it
 	var s uint64
 	for _, t := range ts {
 		t.Offset = s
@@ -549,9 +635,9 @@ func WriteGGUF(ws io.WriteSeeker, kv KV, ts []Tensor) error {
 			return err
 		}
 		s += t.Size()
+		s += uint64(ggufPadding(int64(s), alignment))
 	}
 
-	var alignment int64 = 32
 	for _, t := range ts {
 		if err := ggufWriteTensor(ws, t, alignment); err != nil {
 			return err
@@ -573,10 +659,26 @@ func ggufWriteKV(ws io.WriteSeeker, k string, v any) error {
 
 	var err error
 	switch v := v.(type) {
+	case uint8:
+		err = writeGGUF(ws, ggufTypeUint8, v)
+	case int8:
+		err = writeGGUF(ws, ggufTypeInt8, v)
+	case uint16:
+		err = writeGGUF(ws, ggufTypeUint16, v)
+	case int16:
+		err = writeGGUF(ws, ggufTypeInt16, v)
 	case uint32:
 		err = writeGGUF(ws, ggufTypeUint32, v)
+	case int32:
+		err = writeGGUF(ws, ggufTypeInt32, v)
+	case uint64:
+		err = writeGGUF(ws, ggufTypeUint64, v)
+	case int64:
+		err = writeGGUF(ws, ggufTypeInt64, v)
 	case float32:
 		err = writeGGUF(ws, ggufTypeFloat32, v)
+	case float64:
+		err = writeGGUF(ws, ggufTypeFloat64, v)
 	case bool:
 		err = writeGGUF(ws, ggufTypeBool, v)
 	case string:
@@ -585,6 +687,10 @@ func ggufWriteKV(ws io.WriteSeeker, k string, v any) error {
 		err = writeGGUFArray(ws, ggufTypeInt32, v)
 	case []uint32:
 		err = writeGGUFArray(ws, ggufTypeUint32, v)
+	case []int64:
+		err = writeGGUFArray(ws, ggufTypeInt64, v)
+	case []uint64:
+		err = writeGGUFArray(ws, ggufTypeUint64, v)
 	case []float32:
 		err = writeGGUFArray(ws, ggufTypeFloat32, v)
 	case []string:
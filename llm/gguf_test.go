@@ -0,0 +1,193 @@
+package llm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func writeTestGGUF(t testing.TB, kv KV, ts []Tensor) []byte {
+	t.Helper()
+
+	f, err := os.CreateTemp(t.TempDir(), "*.gguf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := WriteGGUF(f, kv, ts); err != nil {
+		t.Fatal(err)
+	}
+
+	bts, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return bts
+}
+
+// swappedGGUF builds a minimal gguf file in the given byte order while
+// keeping the little endian magic, like files produced on big endian hosts
+func swappedGGUF(t testing.TB, order binary.ByteOrder, version uint32) []byte {
+	t.Helper()
+
+	var b bytes.Buffer
+	write := func(v any) {
+		if err := binary.Write(&b, order, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b.WriteString("GGUF")
+	write(version)
+	write(uint64(1)) // tensors
+	write(uint64(2)) // kvs
+
+	write(uint64(len("general.architecture")))
+	b.WriteString("general.architecture")
+	write(ggufTypeString)
+	write(uint64(len("llama")))
+	b.WriteString("llama")
+
+	write(uint64(len("llama.block_count")))
+	b.WriteString("llama.block_count")
+	write(ggufTypeUint32)
+	write(uint32(1))
+
+	write(uint64(len("output.weight")))
+	b.WriteString("output.weight")
+	write(uint32(2))
+	write(uint64(2))
+	write(uint64(2))
+	write(uint32(0))
+	write(uint64(0))
+
+	b.Write(make([]byte, ggufPadding(int64(b.Len()), ggufDefaultAlignment)))
+	b.Write(make([]byte, 16))
+	return b.Bytes()
+}
+
+func TestWriteGGUFRoundTrip(t *testing.T) {
+	kv := KV{
+		"general.architecture":  "llama",
+		"general.alignment":     uint32(64),
+		"llama.block_count":     uint32(1),
+		"test.uint64":           uint64(1 << 40),
+		"test.int64":            int64(-1),
+		"test.float64":          float64(0.5),
+		"test.uint8":            uint8(7),
+		"tokenizer.ggml.tokens": []string{"a", "b"},
+	}
+
+	bts := writeTestGGUF(t, kv, []Tensor{
+		{Name: "blk.0.attn_q.weight", Kind: 0, Shape: []uint64{3}, WriterTo: bytes.NewReader(make([]byte, 12))},
+		{Name: "output.weight", Kind: 0, Shape: []uint64{5}, WriterTo: bytes.NewReader(make([]byte, 20))},
+	})
+
+	ggml, _, err := DecodeGGML(bytes.NewReader(bts), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, k := range []string{"test.uint64", "test.int64", "test.float64", "test.uint8"} {
+		if diff := cmp.Diff(kv[k], ggml.KV()[k]); diff != "" {
+			t.Errorf("%s mismatch (-want +got):\n%s", k, diff)
+		}
+	}
+
+	for _, tensor := range ggml.Tensors().Items {
+		if tensor.Offset%64 != 0 {
+			t.Errorf("tensor %s offset %d is not aligned", tensor.Name, tensor.Offset)
+		}
+	}
+}
+
+func TestDecodeGGUFByteOrder(t *testing.T) {
+	for name, order := range map[string]binary.ByteOrder{
+		"little": binary.LittleEndian,
+		"big":    binary.BigEndian,
+	} {
+		t.Run(name, func(t *testing.T) {
+			ggml, _, err := DecodeGGML(bytes.NewReader(swappedGGUF(t, order, 3)), 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := ggml.KV().Architecture(); got != "llama" {
+				t.Errorf("expected architecture llama, got %q", got)
+			}
+
+			if got := ggml.KV().BlockCount(); got != 1 {
+				t.Errorf("expected block count 1, got %d", got)
+			}
+
+			if diff := cmp.Diff([]uint64{2, 2}, ggml.Tensors().Items[0].Shape); diff != "" {
+				t.Errorf("shape mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDecodeGGUFVersions(t *testing.T) {
+	if _, _, err := DecodeGGML(bytes.NewReader(swappedGGUF(t, binary.LittleEndian, 4)), 0); err != nil {
+		t.Errorf("expected newer version to decode, got %v", err)
+	}
+
+	if _, _, err := DecodeGGML(bytes.NewReader(swappedGGUF(t, binary.LittleEndian, 0)), 0); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("expected %v, got %v", ErrUnsupportedFormat, err)
+	}
+}
+
+func TestDecodeGGUFLimits(t *testing.T) {
+	cases := map[string]func(*bytes.Buffer){
+		"dims": func(b *bytes.Buffer) {
+			binary.Write(b, binary.LittleEndian, uint32(ggufMaxDims+1))
+		},
+		"overflow": func(b *bytes.Buffer) {
+			binary.Write(b, binary.LittleEndian, uint32(2))
+			binary.Write(b, binary.LittleEndian, uint64(1<<40))
+			binary.Write(b, binary.LittleEndian, uint64(1<<40))
+			binary.Write(b, binary.LittleEndian, uint32(0))
+			binary.Write(b, binary.LittleEndian, uint64(0))
+		},
+	}
+
+	for name, fn := range cases {
+		t.Run(name, func(t *testing.T) {
+			var b bytes.Buffer
+			b.WriteString("GGUF")
+			binary.Write(&b, binary.LittleEndian, uint32(3))
+			binary.Write(&b, binary.LittleEndian, uint64(1))
+			binary.Write(&b, binary.LittleEndian, uint64(0))
+			binary.Write(&b, binary.LittleEndian, uint64(len("output.weight")))
+			b.WriteString("output.weight")
+			fn(&b)
+
+			if _, _, err := DecodeGGML(bytes.NewReader(b.Bytes()), 0); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
+
+func FuzzDecodeGGML(f *testing.F) {
+	f.Add(writeTestGGUF(f, KV{
+		"general.architecture":  "llama",
+		"tokenizer.ggml.tokens": []string{"a", "b", "c"},
+		"tokenizer.ggml.scores": []float32{0, 1, 2},
+	}, []Tensor{
+		{Name: "output.weight", Kind: 0, Shape: []uint64{2, 2}, WriterTo: bytes.NewReader(make([]byte, 16))},
+	}))
+	f.Add(swappedGGUF(f, binary.BigEndian, 3))
+	f.Add(swappedGGUF(f, binary.LittleEndian, 1))
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		// decoding arbitrary input must never panic
+		DecodeGGML(bytes.NewReader(b), -1) //nolint:errcheck
+	})
+}
@@ -1,9 +1,11 @@
 package llm
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -21,7 +23,15 @@ func PredictServerFit(allGpus discover.GpuInfoList, ggml *GGML, adapters, projec
 		var layerCount int
 		estimate := EstimateGPULayers(gpus, ggml, projectors, opts)
 		layerCount, estimatedVRAM = estimate.Layers, estimate.VRAMSize
-		if opts.NumGPU < 0 {
+		if estimate.overcommitted {
+			continue
+		}
+
+		if split, _ := ParseTensorSplit(opts.TensorSplit); len(split) == len(gpus) && opts.NumGPU < 0 {
+			if layerCount > 0 && layerCount >= min(sum(split), int(ggml.KV().BlockCount()+1)) {
+				return true, estimatedVRAM
+			}
+		} else if opts.NumGPU < 0 {
 			if layerCount > 0 && layerCount >= int(ggml.KV().BlockCount()+1) {
 				return true, estimatedVRAM
 			}
@@ -53,6 +63,17 @@ type MemoryEstimate struct {
 	// For multi-GPU scenarios, this is the size in bytes per GPU
 	GPUSizes []uint64
 
+	// GPULayers is the number of layers placed on each GPU
+	GPULayers []int
+
+	// DraftLayers is how many layers of the draft model are offloaded,
+	// which is all of them or none
+	DraftLayers int
+
+	// overcommitted is set if layers placed with an explicit tensor split
+	// don't fit in the GPUs' free memory
+	overcommitted bool
+
 	// internal fields for logging purposes
 	inferenceLibrary    string
 	layersRequested     int
@@ -66,6 +87,39 @@ type MemoryEstimate struct {
 	graphPartialOffload uint64
 
 	projectorWeights, projectorGraph uint64
+
+	draftSize uint64
+}
+
+// ParseTensorSplit parses a comma-separated list of the number of layers to
+// place on each GPU, such as "24,8"
+func ParseTensorSplit(s string) ([]int, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	var split []int
+	for _, f := range strings.Split(s, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(f))
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid layer count %q", f)
+		}
+		split = append(split, n)
+	}
+
+	if sum(split) == 0 {
+		return nil, errors.New("no layers are placed on a GPU")
+	}
+
+	return split, nil
+}
+
+func sum(s []int) (n int) {
+	for _, v := range s {
+		n += v
+	}
+
+	return n
 }
 
 // Given a model and one or more GPU targets, predict how many layers and bytes we can load, and the total size
@@ -167,6 +221,22 @@ func EstimateGPULayers(gpus []discover.GpuInfo, ggml *GGML, projectors []string,
 	// Output layer handled at the end if we have space
 	gpuZeroOverhead := projectorWeights + projectorGraph
 
+	// An explicit split places the layers it lists on each GPU whether they
+	// fit or not, in place of the number of layers requested
+	split, _ := ParseTensorSplit(opts.TensorSplit)
+	if len(split) != len(gpus) {
+		if split != nil {
+			slog.Debug("ignoring tensor split that doesn't list a layer count for each GPU", "split", opts.TensorSplit, "gpu_count", len(gpus))
+		}
+		split = nil
+	} else if opts.NumGPU < 0 {
+		opts.NumGPU = sum(split)
+	}
+
+	// Layers fill each GPU in turn rather than round-robin with an explicit
+	// split or when filling the fastest first
+	fill := split != nil || opts.SplitPolicy == "fastest"
+
 	// Reduce set of GPUs to only those that have sufficient space to fit overhead and at least one layer
 	var layerCount int
 	layerCounts := make([]int, len(gpus))
@@ -181,8 +251,12 @@ func EstimateGPULayers(gpus []discover.GpuInfo, ggml *GGML, projectors []string,
 		if len(gpusWithSpace) == 0 {
 			gzo = gpuZeroOverhead
 		}
+		if split != nil && split[i] == 0 {
+			continue
+		}
+
 		// Only include GPUs that can fit the graph, gpu minimum, the layer buffer and at least more layer
-		if gpus[i].FreeMemory < overhead+gzo+max(graphPartialOffload, graphFullOffload)+gpus[i].MinimumMemory+2*layerSize {
+		if split == nil && gpus[i].FreeMemory < overhead+gzo+max(graphPartialOffload, graphFullOffload)+gpus[i].MinimumMemory+2*layerSize {
 			slog.Debug("gpu has too little memory to allocate any layers",
 				"id", gpus[i].ID,
 				"library", gpus[i].Library,
@@ -204,12 +278,40 @@ func EstimateGPULayers(gpus []discover.GpuInfo, ggml *GGML, projectors []string,
 		gpuAllocations[i] += gpus[i].MinimumMemory + layerSize // We hold off on graph until we know partial vs. full
 	}
 
+	if split == nil && opts.SplitPolicy == "fastest" {
+		slices.SortStableFunc(gpusWithSpace, func(a, b gs) int {
+			return discover.ComparePerformance(*b.g, *a.g)
+		})
+	}
+
+	// The main GPU holds the projectors, if it has space
 	var gpuZeroID int
 	if len(gpusWithSpace) > 0 {
 		gpuZeroID = gpusWithSpace[0].i
+		if slices.ContainsFunc(gpusWithSpace, func(g gs) bool { return g.i == opts.MainGPU }) {
+			gpuZeroID = opts.MainGPU
+		}
 		gpuAllocations[gpuZeroID] += gpuZeroOverhead
 	}
 
+	// hasRoom reports whether size more bytes of layers can be placed on g
+	hasRoom := func(g gs, size uint64) bool {
+		if split != nil {
+			return layerCounts[g.i] < split[g.i]
+		}
+
+		used := gpuAllocations[g.i] + max(graphPartialOffload, graphFullOffload)
+		return g.g.FreeMemory > overhead+used+size
+	}
+
+	// next returns which of j GPUs with space to try to place layer n on
+	next := func(n, j int) int {
+		if fill {
+			return 0
+		}
+		return n % j
+	}
+
 	// For all the layers, find where they can fit on the GPU(s)
 	for i := range int(ggml.KV().BlockCount()) {
 		// Some models have inconsistent layer sizes
@@ -226,15 +328,15 @@ func EstimateGPULayers(gpus []discover.GpuInfo, ggml *GGML, projectors []string,
 
 		// distribute the layers across the GPU(s) that have space
 		for j := len(gpusWithSpace); j > 0; j-- {
-			g := gpusWithSpace[i%j]
-			used := gpuAllocations[g.i] + max(graphPartialOffload, graphFullOffload)
-			if g.g.FreeMemory > overhead+used+layerSize {
+			k := next(i, j)
+			g := gpusWithSpace[k]
+			if hasRoom(g, layerSize) {
 				gpuAllocations[g.i] += layerSize
 				layerCounts[g.i]++
 				layerCount++
 				break
 			} else {
-				gpusWithSpace = append(gpusWithSpace[:i%j], gpusWithSpace[i%j+1:]...)
+				gpusWithSpace = append(gpusWithSpace[:k], gpusWithSpace[k+1:]...)
 			}
 		}
 	}
@@ -249,13 +351,15 @@ func EstimateGPULayers(gpus []discover.GpuInfo, ggml *GGML, projectors []string,
 	// Determine if we need to consider output then find where it fits
 	if memoryLayerOutput > 0 && (opts.NumGPU < 0 || layerCount < opts.NumGPU) {
 		for j := len(gpusWithSpace); j > 0; j-- {
-			g := gpusWithSpace[layerCount%j]
-			used := gpuAllocations[g.i] + max(graphPartialOffload, graphFullOffload)
-			if g.g.FreeMemory > overhead+used+memoryLayerOutput {
+			k := next(layerCount, j)
+			g := gpusWithSpace[k]
+			if hasRoom(g, memoryLayerOutput) {
 				gpuAllocations[g.i] += memoryLayerOutput
 				layerCounts[g.i]++
 				layerCount++
 				break
+			} else if fill {
+				gpusWithSpace = append(gpusWithSpace[:k], gpusWithSpace[k+1:]...)
 			}
 		}
 
@@ -265,6 +369,29 @@ func EstimateGPULayers(gpus []discover.GpuInfo, ggml *GGML, projectors []string,
 		}
 	}
 
+	// The draft model is only offloaded if the model itself fits, and then
+	// only as a whole on one GPU, as a partially offloaded draft is too slow
+	// to speed up decoding
+	var draftLayers int
+	var draftSize uint64
+	if opts.DraftModel != "" && len(projectors) == 0 {
+		var layers int
+		layers, draftSize = draftMemoryRequirements(opts.DraftModel, opts, kvct)
+		if fullyLoaded {
+			for _, g := range gpusWithSpace {
+				if hasRoom(g, draftSize) {
+					gpuAllocations[g.i] += draftSize
+					draftLayers = layers
+					break
+				}
+			}
+		}
+
+		if draftLayers == 0 {
+			overflow += draftSize
+		}
+	}
+
 	// Add the applicable (full or partial) graph allocations
 	for i := range gpus {
 		if layerCounts[i] <= 0 {
@@ -284,8 +411,12 @@ func EstimateGPULayers(gpus []discover.GpuInfo, ggml *GGML, projectors []string,
 
 	// Summaries for the log
 	var memoryRequiredPartial, memoryRequiredTotal uint64
+	var overcommitted bool
 	for i := range gpuAllocations {
 		memoryRequiredPartial += gpuAllocations[i]
+		if layerCounts[i] > 0 && gpus[i].FreeMemory < overhead+gpuAllocations[i] {
+			overcommitted = true
+		}
 	}
 	memoryRequiredTotal = memoryRequiredPartial + overflow
 
@@ -321,6 +452,7 @@ func EstimateGPULayers(gpus []discover.GpuInfo, ggml *GGML, projectors []string,
 		graphPartialOffload: graphPartialOffload,
 		projectorWeights:    projectorWeights,
 		projectorGraph:      projectorGraph,
+		draftSize:           draftSize,
 	}
 
 	if gpus[0].Library == "cpu" {
@@ -336,6 +468,9 @@ func EstimateGPULayers(gpus []discover.GpuInfo, ggml *GGML, projectors []string,
 	estimate.TotalSize = memoryRequiredTotal
 	estimate.TensorSplit = tensorSplit
 	estimate.GPUSizes = gpuAllocations
+	estimate.GPULayers = layerCounts
+	estimate.DraftLayers = draftLayers
+	estimate.overcommitted = split != nil && overcommitted
 	return estimate
 }
 
@@ -353,6 +488,16 @@ func (m MemoryEstimate) log() {
 		)
 	}
 
+	if m.draftSize > 0 {
+		log = log.With(
+			slog.Group(
+				"draft",
+				"size", format.HumanBytes2(m.draftSize),
+				"offload", m.DraftLayers,
+			),
+		)
+	}
+
 	log.Info(
 		"offload to "+m.inferenceLibrary,
 		slog.Group(
@@ -402,6 +547,24 @@ func (m MemoryEstimate) log() {
 	)
 }
 
+// draftMemoryRequirements returns the number of layers of the draft model at
+// filename, including its output layer, and the memory its weights, KV cache
+// and graph take with the context and KV cache type of the model it drafts for
+func draftMemoryRequirements(filename string, opts api.Options, kvct string) (layers int, size uint64) {
+	ggml, err := LoadModel(filename, 0)
+	if err != nil {
+		slog.Warn("couldn't estimate the memory of the draft model", "model", filename, "error", err)
+		return 0, 0
+	}
+
+	for _, layer := range ggml.Tensors().Layers() {
+		size += layer.size()
+	}
+
+	kv, _, graph := ggml.GraphSize(uint64(opts.NumCtx), uint64(min(opts.NumCtx, opts.NumBatch)), kvct)
+	return int(ggml.KV().BlockCount()) + 1, size + kv + graph
+}
+
 func projectorMemoryRequirements(filename string) (weights, graphSize uint64) {
 	file, err := os.Open(filename)
 	if err != nil {
@@ -127,3 +127,154 @@ func TestEstimateGPULayers(t *testing.T) {
 		})
 	}
 }
+
+func TestEstimateGPULayersPlacement(t *testing.T) {
+	t.Setenv("OLLAMA_KV_CACHE_TYPE", "")
+
+	f, err := os.CreateTemp(t.TempDir(), "dummy")
+	require.NoError(t, err)
+	defer f.Close()
+
+	var tensors []Tensor
+	for i := range 5 {
+		tensors = append(tensors, Tensor{Name: fmt.Sprintf("blk.%d.attn.weight", i), Kind: uint32(0), Offset: uint64(0), Shape: []uint64{1, 1, 1, 1}, WriterTo: bytes.NewReader(make([]byte, 32))})
+	}
+	tensors = append(tensors, Tensor{Name: "output.weight", Kind: uint32(0), Offset: uint64(0), Shape: []uint64{1, 1, 1, 1}, WriterTo: bytes.NewReader(make([]byte, 32))})
+	require.NoError(t, WriteGGUF(f, KV{
+		"general.architecture":          "llama",
+		"llama.context_length":          uint32(32),
+		"llama.embedding_length":        uint32(4096),
+		"llama.block_count":             uint32(5),
+		"llama.attention.head_count":    uint32(32),
+		"llama.attention.head_count_kv": uint32(32),
+		"tokenizer.ggml.tokens":         []string{" "},
+		"tokenizer.ggml.scores":         []float32{0},
+		"tokenizer.ggml.token_type":     []int32{0},
+	}, tensors))
+
+	ggml, err := LoadModel(f.Name(), 0)
+	require.NoError(t, err)
+
+	// derived from the dummy ggml file above
+	graph := uint64(202377216)
+	layerSize := uint64(33554436)
+
+	// gpus returns GPUs with room for the graph and layers layers each
+	gpus := func(layers ...uint64) []discover.GpuInfo {
+		var gpus []discover.GpuInfo
+		for i, n := range layers {
+			gpus = append(gpus, discover.GpuInfo{
+				ID:      fmt.Sprint(i),
+				Library: "cuda",
+				Compute: fmt.Sprintf("%d.0", 7+i),
+			})
+			gpus[i].TotalMemory = 1 << 30
+			gpus[i].FreeMemory = layerSize + n*layerSize + graph + 1
+		}
+		return gpus
+	}
+
+	cases := []struct {
+		name         string
+		gpus         []discover.GpuInfo
+		split        string
+		policy       string
+		expectSplit  string
+		expectLayers []int
+		expectFit    bool
+	}{
+		{name: "even", gpus: gpus(6, 6), expectSplit: "3,3", expectLayers: []int{3, 3}, expectFit: true},
+		{name: "fastest", gpus: gpus(6, 6), policy: "fastest", expectSplit: "0,6", expectLayers: []int{0, 6}, expectFit: true},
+		{name: "fastest full", gpus: gpus(6, 4), policy: "fastest", expectSplit: "2,4", expectLayers: []int{2, 4}, expectFit: true},
+		{name: "split", gpus: gpus(6, 6), split: "5,1", expectSplit: "5,1", expectLayers: []int{5, 1}, expectFit: true},
+		{name: "split partial", gpus: gpus(6, 6), split: "2,1", expectSplit: "2,1", expectLayers: []int{2, 1}, expectFit: true},
+		{name: "split unused gpu", gpus: gpus(6, 6, 6), split: "0,4,2", expectSplit: "0,4,2", expectLayers: []int{0, 4, 2}, expectFit: true},
+		{name: "split overcommitted", gpus: gpus(6, 2), split: "2,4", expectSplit: "2,4", expectLayers: []int{2, 4}},
+		{name: "split mismatched", gpus: gpus(6, 6), split: "6", expectSplit: "3,3", expectLayers: []int{3, 3}, expectFit: true},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := api.DefaultOptions()
+			opts.TensorSplit = tt.split
+			opts.SplitPolicy = tt.policy
+
+			estimate := EstimateGPULayers(tt.gpus, ggml, nil, opts)
+			assert.Equal(t, tt.expectSplit, estimate.TensorSplit)
+			assert.Equal(t, tt.expectLayers, estimate.GPULayers)
+
+			fit, _ := PredictServerFit(tt.gpus, ggml, nil, nil, opts)
+			assert.Equal(t, tt.expectFit, fit)
+		})
+	}
+}
+
+func TestEstimateGPULayersDraft(t *testing.T) {
+	t.Setenv("OLLAMA_KV_CACHE_TYPE", "")
+
+	f, err := os.CreateTemp(t.TempDir(), "dummy")
+	require.NoError(t, err)
+	defer f.Close()
+
+	var tensors []Tensor
+	for i := range 5 {
+		tensors = append(tensors, Tensor{Name: fmt.Sprintf("blk.%d.attn.weight", i), Kind: uint32(0), Offset: uint64(0), Shape: []uint64{1, 1, 1, 1}, WriterTo: bytes.NewReader(make([]byte, 32))})
+	}
+	tensors = append(tensors, Tensor{Name: "output.weight", Kind: uint32(0), Offset: uint64(0), Shape: []uint64{1, 1, 1, 1}, WriterTo: bytes.NewReader(make([]byte, 32))})
+	require.NoError(t, WriteGGUF(f, KV{
+		"general.architecture":          "llama",
+		"llama.context_length":          uint32(32),
+		"llama.embedding_length":        uint32(4096),
+		"llama.block_count":             uint32(5),
+		"llama.attention.head_count":    uint32(32),
+		"llama.attention.head_count_kv": uint32(32),
+		"tokenizer.ggml.tokens":         []string{" "},
+		"tokenizer.ggml.scores":         []float32{0},
+		"tokenizer.ggml.token_type":     []int32{0},
+	}, tensors))
+
+	ggml, err := LoadModel(f.Name(), 0)
+	require.NoError(t, err)
+
+	// the model drafts for itself
+	opts := api.DefaultOptions()
+	layers, draftSize := draftMemoryRequirements(f.Name(), opts, "")
+	require.Equal(t, 6, layers)
+	require.Positive(t, draftSize)
+
+	opts.DraftModel = f.Name()
+	gpus := []discover.GpuInfo{{ID: "0", Library: "cuda"}}
+	gpus[0].TotalMemory = 1 << 40
+	gpus[0].FreeMemory = 1 << 40
+
+	without := EstimateGPULayers(gpus, ggml, nil, api.DefaultOptions())
+	with := EstimateGPULayers(gpus, ggml, nil, opts)
+	assert.Equal(t, 6, with.DraftLayers)
+	assert.Equal(t, without.VRAMSize+draftSize, with.VRAMSize)
+	assert.Equal(t, without.TotalSize+draftSize, with.TotalSize)
+
+	// the draft isn't offloaded if only the model fits, but still takes
+	// memory. These sizes are derived from the dummy ggml file above.
+	graph := uint64(202377216)
+	layerSize := uint64(33554436)
+	gpus[0].FreeMemory = layerSize + 6*layerSize + graph + 1
+	without = EstimateGPULayers(gpus, ggml, nil, api.DefaultOptions())
+	require.Equal(t, 6, without.Layers)
+	with = EstimateGPULayers(gpus, ggml, nil, opts)
+	assert.Zero(t, with.DraftLayers)
+	assert.Equal(t, without.Layers, with.Layers)
+	assert.Equal(t, without.VRAMSize, with.VRAMSize)
+	assert.Equal(t, without.TotalSize+draftSize, with.TotalSize)
+}
+
+func TestParseTensorSplit(t *testing.T) {
+	split, err := ParseTensorSplit(" 24, 8,0")
+	require.NoError(t, err)
+	assert.Equal(t, []int{24, 8, 0}, split)
+
+	for _, s := range []string{"24,x", "-1,2", "0,0", "24,,8"} {
+		if _, err := ParseTensorSplit(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
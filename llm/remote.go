@@ -0,0 +1,394 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/discover"
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/tracing"
+)
+
+var errRemoteImages = errors.New("remote models don't support images")
+
+// remoteServer is a LlamaServer that generates with an OpenAI-compatible
+// server instead of a local runner. Prompts are sent to its completions
+// endpoint already templated, so tools and formats are handled as they are
+// for local models.
+type remoteServer struct {
+	base  *url.URL
+	model string
+	key   string
+}
+
+// NewRemoteServer returns a server generating with the model of the
+// OpenAI-compatible server at rawURL. The API key of the server's host is
+// read from OLLAMA_REMOTE_KEYS.
+func NewRemoteServer(rawURL, model string) (LlamaServer, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("remote server %q must be an http or https url", rawURL)
+	}
+
+	keys, err := parseRemoteKeys(envconfig.RemoteKeys())
+	if err != nil {
+		return nil, err
+	}
+
+	key, ok := keys[u.Host]
+	if !ok {
+		key = keys[u.Hostname()]
+	}
+
+	return &remoteServer{base: u, model: model, key: key}, nil
+}
+
+// parseRemoteKeys parses OLLAMA_REMOTE_KEYS, a comma-separated list of
+// host=key pairs, into keys by host
+func parseRemoteKeys(s string) (map[string]string, error) {
+	keys := make(map[string]string)
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		host, key, ok := strings.Cut(entry, "=")
+		host, key = strings.TrimSpace(host), strings.TrimSpace(key)
+		if !ok || host == "" || key == "" {
+			// the entry isn't included as it may hold a key
+			return nil, errors.New("OLLAMA_REMOTE_KEYS: entries must be host=key pairs")
+		}
+
+		keys[host] = key
+	}
+
+	return keys, nil
+}
+
+// do sends a request with body to path, relative to the server's base URL,
+// and returns the response if it succeeded
+func (s *remoteServer) do(ctx context.Context, method, path string, body any) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+
+		r = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, s.base.JoinPath(path).String(), r)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	tracing.Inject(ctx, req.Header)
+	if s.key != "" {
+		req.Header.Set("Authorization", "Bearer "+s.key)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+
+		var e struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		msg := strings.TrimSpace(string(b))
+		if err := json.Unmarshal(b, &e); err == nil && e.Error.Message != "" {
+			msg = e.Error.Message
+		}
+
+		return nil, api.StatusError{StatusCode: resp.StatusCode, Status: resp.Status, ErrorMessage: fmt.Sprintf("remote server: %s", msg)}
+	}
+
+	return resp, nil
+}
+
+func (s *remoteServer) Ping(ctx context.Context) error {
+	resp, err := s.do(ctx, http.MethodGet, "models", nil)
+	if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
+
+func (s *remoteServer) WaitUntilRunning(ctx context.Context) error {
+	return s.Ping(ctx)
+}
+
+func (s *remoteServer) Completion(ctx context.Context, req CompletionRequest, fn func(CompletionResponse)) error {
+	if len(req.Images) > 0 {
+		return errRemoteImages
+	}
+
+	opts := req.Options
+	request := map[string]any{
+		"model":              s.model,
+		"prompt":             req.Prompt,
+		"stream":             true,
+		"stream_options":     map[string]any{"include_usage": true},
+		"temperature":        opts.Temperature,
+		"top_p":              opts.TopP,
+		"top_k":              opts.TopK,
+		"min_p":              opts.MinP,
+		"presence_penalty":   opts.PresencePenalty,
+		"frequency_penalty":  opts.FrequencyPenalty,
+		"repetition_penalty": opts.RepeatPenalty,
+	}
+
+	if opts.NumPredict > 0 {
+		request["max_tokens"] = opts.NumPredict
+	}
+
+	if opts.Seed >= 0 {
+		request["seed"] = opts.Seed
+	}
+
+	if len(opts.Stop) > 0 {
+		request["stop"] = opts.Stop
+	}
+
+	if err := remoteFormat(request, req.Format); err != nil {
+		return err
+	}
+
+	start := time.Now()
+	resp, err := s.do(ctx, http.MethodPost, "completions", request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var first time.Time
+	var usage struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	}
+	doneReason := "stop"
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, maxBufferSize), maxBufferSize)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			break
+		}
+
+		var chunk struct {
+			Choices []struct {
+				Text         string `json:"text"`
+				FinishReason string `json:"finish_reason"`
+			} `json:"choices"`
+			Usage *struct {
+				PromptTokens     int `json:"prompt_tokens"`
+				CompletionTokens int `json:"completion_tokens"`
+			} `json:"usage"`
+		}
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return fmt.Errorf("error unmarshalling remote response: %w", err)
+		}
+
+		if chunk.Usage != nil {
+			usage = *chunk.Usage
+		}
+
+		for _, choice := range chunk.Choices {
+			if first.IsZero() {
+				first = time.Now()
+			}
+
+			if choice.FinishReason == "length" {
+				doneReason = "length"
+			}
+
+			if choice.Text != "" {
+				fn(CompletionResponse{Content: choice.Text})
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading remote response: %w", err)
+	}
+
+	if first.IsZero() {
+		first = time.Now()
+	}
+
+	fn(CompletionResponse{
+		Done:               true,
+		DoneReason:         doneReason,
+		PromptEvalCount:    usage.PromptTokens,
+		PromptEvalDuration: first.Sub(start),
+		EvalCount:          usage.CompletionTokens,
+		EvalDuration:       time.Since(first),
+	})
+	return nil
+}
+
+// remoteFormat sets the fields of request constraining the response to
+// format, which is "json", a grammar, or a JSON schema
+func remoteFormat(request map[string]any, format json.RawMessage) error {
+	switch string(format) {
+	case ``, `null`, `""`:
+		return nil
+	case `"json"`:
+		request["response_format"] = map[string]any{"type": "json_object"}
+		return nil
+	}
+
+	if format[0] != '{' {
+		return fmt.Errorf("invalid format: %q; expected \"json\" or a valid JSON Schema object", format)
+	}
+
+	var grammar struct {
+		Type  string `json:"type"`
+		Value string `json:"value"`
+	}
+	if err := json.Unmarshal(format, &grammar); err == nil && grammar.Type == "grammar" {
+		request["guided_grammar"] = grammar.Value
+		return nil
+	}
+
+	request["response_format"] = map[string]any{
+		"type":        "json_schema",
+		"json_schema": map[string]any{"name": "response", "schema": format},
+	}
+	return nil
+}
+
+func (s *remoteServer) Embedding(ctx context.Context, input string) ([]float32, error) {
+	resp, err := s.do(ctx, http.MethodPost, "embeddings", map[string]any{"model": s.model, "input": input})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var embeddings struct {
+		Data []struct {
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&embeddings); err != nil {
+		return nil, fmt.Errorf("error unmarshalling remote embedding: %w", err)
+	}
+
+	if len(embeddings.Data) == 0 {
+		return nil, errors.New("remote server returned no embedding")
+	}
+
+	return embeddings.Data[0].Embedding, nil
+}
+
+// root returns the URL of the server without its API version, where servers
+// such as vLLM have their tokenize and detokenize endpoints
+func (s *remoteServer) root() *remoteServer {
+	root := *s
+	u := *s.base
+	u.Path = strings.TrimSuffix(strings.TrimSuffix(u.Path, "/"), "/v1")
+	root.base = &u
+	return &root
+}
+
+// Tokenize tokenizes content with the server's tokenize endpoint. Servers
+// without one have the tokens estimated at four bytes each, which is enough
+// for counting them to fit prompts in the context window.
+func (s *remoteServer) Tokenize(ctx context.Context, content string) ([]int, error) {
+	resp, err := s.root().do(ctx, http.MethodPost, "tokenize", map[string]any{"model": s.model, "prompt": content, "add_special_tokens": false})
+	var se api.StatusError
+	if errors.As(err, &se) && se.StatusCode == http.StatusNotFound {
+		return make([]int, (len(content)+3)/4), nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var tokens struct {
+		Tokens []int `json:"tokens"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return nil, fmt.Errorf("error unmarshalling remote tokens: %w", err)
+	}
+
+	return tokens.Tokens, nil
+}
+
+func (s *remoteServer) Detokenize(ctx context.Context, tokens []int) (string, error) {
+	resp, err := s.root().do(ctx, http.MethodPost, "detokenize", map[string]any{"model": s.model, "tokens": tokens})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var text struct {
+		Prompt string `json:"prompt"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&text); err != nil {
+		return "", fmt.Errorf("error unmarshalling remote text: %w", err)
+	}
+
+	return text.Prompt, nil
+}
+
+func (s *remoteServer) Close() error {
+	return nil
+}
+
+func (s *remoteServer) EstimatedVRAM() uint64 {
+	return 0
+}
+
+func (s *remoteServer) EstimatedTotal() uint64 {
+	return 0
+}
+
+func (s *remoteServer) EstimatedVRAMByGPU(gpuID string) uint64 {
+	return 0
+}
+
+func (s *remoteServer) EstimatedLayersByGPU(gpuID string) int {
+	return 0
+}
+
+func (s *remoteServer) KvCacheUsage(ctx context.Context) (int, int, error) {
+	return 0, 0, nil
+}
+
+func (s *remoteServer) PurgeCache(ctx context.Context) (int, error) {
+	return 0, nil
+}
+
+func (s *remoteServer) SetParallel(ctx context.Context, gpus discover.GpuInfoList, numParallel int) error {
+	return ErrParallelUnsupported
+}
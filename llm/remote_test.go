@@ -0,0 +1,171 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestRemoteServerCompletion(t *testing.T) {
+	var got map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, `{"error":{"message":"invalid api key"}}`, http.StatusUnauthorized)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/v1/completions":
+			if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+				t.Error(err)
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			for _, text := range []string{"Hello", ", world"} {
+				fmt.Fprintf(w, "data: {\"choices\":[{\"text\":%q}]}\n\n", text)
+			}
+			fmt.Fprint(w, "data: {\"choices\":[{\"text\":\"\",\"finish_reason\":\"length\"}]}\n\n")
+			fmt.Fprint(w, "data: {\"choices\":[],\"usage\":{\"prompt_tokens\":7,\"completion_tokens\":3}}\n\n")
+			fmt.Fprint(w, "data: [DONE]\n\n")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	host := strings.TrimPrefix(srv.URL, "http://")
+	t.Setenv("OLLAMA_REMOTE_KEYS", "other.example.com=nope, "+host+"=secret")
+
+	s, err := NewRemoteServer(srv.URL+"/v1", "served-model")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := api.DefaultOptions()
+	opts.NumPredict = 3
+	opts.Stop = []string{"\n"}
+
+	var sb strings.Builder
+	var final CompletionResponse
+	if err := s.Completion(context.Background(), CompletionRequest{
+		Prompt:  "user: hi\nassistant: ",
+		Format:  json.RawMessage(`{"type":"object"}`),
+		Options: &opts,
+	}, func(cr CompletionResponse) {
+		sb.WriteString(cr.Content)
+		if cr.Done {
+			final = cr
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if sb.String() != "Hello, world" {
+		t.Errorf("expected %q, got %q", "Hello, world", sb.String())
+	}
+
+	if final.DoneReason != "length" || final.PromptEvalCount != 7 || final.EvalCount != 3 {
+		t.Errorf("unexpected final response %+v", final)
+	}
+
+	for k, v := range map[string]any{
+		"model":      "served-model",
+		"prompt":     "user: hi\nassistant: ",
+		"max_tokens": float64(3),
+		"stop":       []any{"\n"},
+		"response_format": map[string]any{
+			"type":        "json_schema",
+			"json_schema": map[string]any{"name": "response", "schema": map[string]any{"type": "object"}},
+		},
+	} {
+		if diff := cmp.Diff(v, got[k]); diff != "" {
+			t.Errorf("%s mismatch (-want +got):\n%s", k, diff)
+		}
+	}
+
+	if _, ok := got["seed"]; ok {
+		t.Error("expected no seed")
+	}
+
+	// tokens are estimated without a tokenize endpoint
+	tokens, err := s.Tokenize(context.Background(), "twelve bytes")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tokens) != 3 {
+		t.Errorf("expected 3 tokens, got %d", len(tokens))
+	}
+
+	t.Setenv("OLLAMA_REMOTE_KEYS", "")
+	s, err = NewRemoteServer(srv.URL+"/v1", "served-model")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.Completion(context.Background(), CompletionRequest{Options: &opts}, func(CompletionResponse) {})
+	if err == nil || !strings.Contains(err.Error(), "invalid api key") {
+		t.Errorf("expected an invalid api key error, got %v", err)
+	}
+}
+
+func TestRemoteServerTokenize(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/tokenize":
+			fmt.Fprint(w, `{"tokens":[1,2,3,4]}`)
+		case "/detokenize":
+			fmt.Fprint(w, `{"prompt":"text"}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	s, err := NewRemoteServer(srv.URL+"/v1/", "served-model")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokens, err := s.Tokenize(context.Background(), "text")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff([]int{1, 2, 3, 4}, tokens); diff != "" {
+		t.Errorf("tokens mismatch (-want +got):\n%s", diff)
+	}
+
+	text, err := s.Detokenize(context.Background(), tokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if text != "text" {
+		t.Errorf("expected %q, got %q", "text", text)
+	}
+}
+
+func TestParseRemoteKeys(t *testing.T) {
+	keys, err := parseRemoteKeys(" a.example.com=1 ,b.example.com:8000=2,")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(map[string]string{"a.example.com": "1", "b.example.com:8000": "2"}, keys); diff != "" {
+		t.Errorf("keys mismatch (-want +got):\n%s", diff)
+	}
+
+	if _, err := parseRemoteKeys("secret"); err == nil || strings.Contains(err.Error(), "secret") {
+		t.Errorf("expected an error without the entry, got %v", err)
+	}
+}
@@ -30,6 +30,8 @@ import (
 	"github.com/ollama/ollama/format"
 	"github.com/ollama/ollama/llama"
 	"github.com/ollama/ollama/runners"
+	"github.com/ollama/ollama/tracing"
+	"github.com/ollama/ollama/watermark"
 )
 
 type LlamaServer interface {
@@ -43,8 +45,28 @@ type LlamaServer interface {
 	EstimatedVRAM() uint64 // Total VRAM across all GPUs
 	EstimatedTotal() uint64
 	EstimatedVRAMByGPU(gpuID string) uint64
+	EstimatedLayersByGPU(gpuID string) int
+	KvCacheUsage(ctx context.Context) (used, size int, err error)
+	PurgeCache(ctx context.Context) (int, error)
+	SetParallel(ctx context.Context, gpus discover.GpuInfoList, numParallel int) error
 }
 
+var (
+	// ErrInsufficientMemory is returned when there isn't enough memory to load a model
+	ErrInsufficientMemory = errors.New("model requires more system memory")
+
+	// ErrRunnerTerminated is returned when the runner exits while loading a
+	// model or generating
+	ErrRunnerTerminated = errors.New("llama runner process has terminated")
+
+	// ErrRunnerExited is returned when the runner is found to have exited
+	ErrRunnerExited = errors.New("llama runner process no longer running")
+
+	// ErrParallelUnsupported is returned when the number of parallel
+	// requests a runner handles can't be changed without reloading it
+	ErrParallelUnsupported = errors.New("runner can't change its parallel requests")
+)
+
 // llmServer is an instance of the llama.cpp server
 type llmServer struct {
 	port        int
@@ -54,6 +76,13 @@ type llmServer struct {
 	options     api.Options
 	numParallel int
 	modelPath   string
+
+	// maxParallel is the most parallel requests the runner can be changed to
+	// handle, and parallelMu serializes changes to it
+	maxParallel int
+	parallelMu  sync.Mutex
+	ggml        *GGML
+	projectors  []string
 	modelLock   sync.Mutex   // Temporary until we switch fully to Go server
 	model       *llama.Model // If non-nil, the runner is a new Go server
 
@@ -64,6 +93,12 @@ type llmServer struct {
 	loadDuration time.Duration        // Record how long it took the model to load
 	loadProgress float32
 
+	// kvCacheUsed and kvCacheSize are the tokens in the runner's cache
+	// when its health was last checked
+	kvCacheMu   sync.Mutex
+	kvCacheUsed int
+	kvCacheSize int
+
 	sem *semaphore.Weighted
 }
 
@@ -134,7 +169,7 @@ func NewLlamaServer(gpus discover.GpuInfoList, model string, ggml *GGML, adapter
 		available := systemFreeMemory + systemSwapFreeMemory
 		if systemMemoryRequired > available {
 			slog.Warn("model request too large for system", "requested", format.HumanBytes2(systemMemoryRequired), "available", available, "total", format.HumanBytes2(systemTotalMemory), "free", format.HumanBytes2(systemFreeMemory), "swap", format.HumanBytes2(systemSwapFreeMemory))
-			return nil, fmt.Errorf("model requires more system memory (%s) than is available (%s)", format.HumanBytes2(systemMemoryRequired), format.HumanBytes2(available))
+			return nil, fmt.Errorf("%w (%s) than is available (%s)", ErrInsufficientMemory, format.HumanBytes2(systemMemoryRequired), format.HumanBytes2(available))
 		}
 	}
 
@@ -197,9 +232,19 @@ func NewLlamaServer(gpus discover.GpuInfoList, model string, ggml *GGML, adapter
 	if len(projectors) > 0 {
 		// TODO: applying multiple projectors is not supported by the llama.cpp server yet
 		params = append(params, "--mmproj", projectors[0])
+
+		if ggml.KV().Architecture() == "mllama" {
+			params = append(params, "--max-images", strconv.FormatUint(uint64(max(envconfig.MaxImages(), 1)), 10))
+		}
 	}
 
+	params = append(params, draftParams(opts, projectors, estimate.DraftLayers)...)
+
 	defaultThreads := systemInfo.GetOptimalThreadCount()
+	if limit := discover.CPULimit(); defaultThreads == 0 || limit < defaultThreads {
+		// size the thread pool to the CPUs available inside a container
+		defaultThreads = limit
+	}
 	if opts.NumThread > 0 {
 		params = append(params, "--threads", strconv.Itoa(opts.NumThread))
 	} else if defaultThreads > 0 {
@@ -262,6 +307,11 @@ func NewLlamaServer(gpus discover.GpuInfoList, model string, ggml *GGML, adapter
 
 	params = append(params, "--parallel", strconv.Itoa(numParallel))
 
+	maxParallel := max(int(envconfig.MaxParallel()), numParallel)
+	if maxParallel > numParallel {
+		params = append(params, "--max-parallel", strconv.Itoa(maxParallel))
+	}
+
 	if estimate.TensorSplit != "" {
 		params = append(params, "--tensor-split", estimate.TensorSplit)
 	}
@@ -270,6 +320,10 @@ func NewLlamaServer(gpus discover.GpuInfoList, model string, ggml *GGML, adapter
 		params = append(params, "--multiuser-cache")
 	}
 
+	if ttl := envconfig.CacheTTL(); ttl > 0 {
+		params = append(params, "--cache-ttl", ttl.String())
+	}
+
 	for i := range servers {
 		builtin := servers[i] == runners.BuiltinName()
 		server := availableServers[servers[i]]
@@ -329,12 +383,19 @@ func NewLlamaServer(gpus discover.GpuInfoList, model string, ggml *GGML, adapter
 			modelPath:   model,
 			estimate:    estimate,
 			numParallel: numParallel,
-			sem:         semaphore.NewWeighted(int64(numParallel)),
+			maxParallel: maxParallel,
+			ggml:        ggml,
+			projectors:  projectors,
+			sem:         semaphore.NewWeighted(int64(maxParallel)),
 			totalLayers: ggml.KV().BlockCount() + 1,
 			gpus:        gpus,
 			done:        make(chan error, 1),
 		}
 
+		// the requests past numParallel are held until the runner is changed
+		// to handle more
+		s.sem.TryAcquire(int64(maxParallel - numParallel))
+
 		s.cmd.Env = os.Environ()
 		s.cmd.Stdout = os.Stdout
 		s.cmd.Stderr = s.status
@@ -461,6 +522,8 @@ type ServerStatusResp struct {
 	SlotsProcessing int     `json:"slots_processing"`
 	Error           string  `json:"error"`
 	Progress        float32 `json:"progress"`
+	KvCacheUsed     int     `json:"kv_cache_used"`
+	KvCacheSize     int     `json:"kv_cache_size"`
 }
 
 func (s *llmServer) getServerStatus(ctx context.Context) (ServerStatus, error) {
@@ -474,7 +537,7 @@ func (s *llmServer) getServerStatus(ctx context.Context) (ServerStatus, error) {
 			// Most likely a signal killed it, log some more details to try to help troubleshoot
 			slog.Warn("llama runner process no longer running", "sys", s.cmd.ProcessState.Sys(), "string", s.cmd.ProcessState.String())
 		}
-		return ServerStatusError, fmt.Errorf("llama runner process no longer running: %d %s", s.cmd.ProcessState.ExitCode(), msg)
+		return ServerStatusError, fmt.Errorf("%w: %d %s", ErrRunnerExited, s.cmd.ProcessState.ExitCode(), msg)
 	}
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("http://127.0.0.1:%d/health", s.port), nil)
@@ -504,6 +567,9 @@ func (s *llmServer) getServerStatus(ctx context.Context) (ServerStatus, error) {
 
 	switch status.Status {
 	case "ok":
+		s.kvCacheMu.Lock()
+		s.kvCacheUsed, s.kvCacheSize = status.KvCacheUsed, status.KvCacheSize
+		s.kvCacheMu.Unlock()
 		return ServerStatusReady, nil
 	case "no slot available":
 		return ServerStatusNoSlotsAvailable, nil
@@ -562,7 +628,7 @@ func (s *llmServer) WaitUntilRunning(ctx context.Context) error {
 			slog.Warn("client connection closed before server finished loading, aborting load")
 			return fmt.Errorf("timed out waiting for llama runner to start: %w", ctx.Err())
 		case err := <-s.done:
-			return fmt.Errorf("llama runner process has terminated: %w", err)
+			return fmt.Errorf("%w: %w", ErrRunnerTerminated, err)
 		default:
 		}
 		if time.Now().After(stallTimer) {
@@ -578,7 +644,7 @@ func (s *llmServer) WaitUntilRunning(ctx context.Context) error {
 			if s.status != nil && s.status.LastErrMsg != "" {
 				msg = s.status.LastErrMsg
 			}
-			return fmt.Errorf("llama runner process no longer running: %d %s", s.cmd.ProcessState.ExitCode(), msg)
+			return fmt.Errorf("%w: %d %s", ErrRunnerExited, s.cmd.ProcessState.ExitCode(), msg)
 		}
 		ctx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
 		defer cancel()
@@ -641,6 +707,30 @@ type ImageData struct {
 	AspectRatioID int    `json:"aspect_ratio_id"`
 }
 
+// draftParams returns the runner parameters for the draft model of opts,
+// with layers of it offloaded to the GPU
+func draftParams(opts api.Options, projectors []string, layers int) []string {
+	if opts.DraftModel == "" {
+		return nil
+	}
+
+	if len(projectors) > 0 {
+		slog.Warn("draft models aren't supported with projectors, not using speculative decoding")
+		return nil
+	}
+
+	params := []string{"--draft-model", opts.DraftModel}
+	if opts.DraftMax > 0 {
+		params = append(params, "--draft-max", strconv.Itoa(opts.DraftMax))
+	}
+
+	if layers > 0 {
+		params = append(params, "--n-gpu-layers-draft", strconv.Itoa(layers))
+	}
+
+	return params
+}
+
 type completion struct {
 	Content      string `json:"content"`
 	Model        string `json:"model"`
@@ -648,11 +738,22 @@ type completion struct {
 	Stop         bool   `json:"stop"`
 	StoppedLimit bool   `json:"stopped_limit"`
 
+	AbortReason     string `json:"abort_reason"`
+	AbortMessage    string `json:"abort_message"`
+	ContextShifts   int    `json:"context_shifts"`
+	PromptTruncated int    `json:"prompt_truncated"`
+
+	Logprobs []api.Logprob `json:"logprobs"`
+
+	Profile []api.ProfileOp `json:"profile"`
+
 	Timings struct {
 		PredictedN  int     `json:"predicted_n"`
 		PredictedMS float64 `json:"predicted_ms"`
 		PromptN     int     `json:"prompt_n"`
 		PromptMS    float64 `json:"prompt_ms"`
+		DraftN      int     `json:"draft_n"`
+		DraftAccN   int     `json:"draft_accepted_n"`
 	}
 }
 
@@ -661,6 +762,19 @@ type CompletionRequest struct {
 	Format  json.RawMessage
 	Images  []ImageData
 	Options *api.Options
+
+	// Logprobs returns the log probabilities of the generated tokens and
+	// of the TopLogprobs most likely tokens at each position
+	Logprobs    bool
+	TopLogprobs int
+
+	// NoStore keeps the prompt out of the runner's cache once the
+	// completion is done, so later requests can't reuse it
+	NoStore bool
+
+	// Scripts are the Unicode scripts of the response language, such as
+	// "Latin" or "Han". Tokens with letters in other scripts are penalized.
+	Scripts []string
 }
 
 type CompletionResponse struct {
@@ -671,9 +785,56 @@ type CompletionResponse struct {
 	PromptEvalDuration time.Duration
 	EvalCount          int
 	EvalDuration       time.Duration
+	DraftCount         int
+	DraftAcceptedCount int
+
+	// Logprobs are the log probabilities of the tokens of Content, if
+	// requested, with offsets into the whole response
+	Logprobs []api.Logprob
+
+	// Diagnostics are set on the final response if the generation was
+	// aborted or the context window shifted
+	Diagnostics *api.Diagnostics
+
+	// Profile is the time spent in each operation of the model's graphs, set
+	// on the final response if the profile option was set
+	Profile []api.ProfileOp
 }
 
+// Completion generates a completion of req.Prompt, recording a span of it
+// with spans of the runner's prefill and decode
 func (s *llmServer) Completion(ctx context.Context, req CompletionRequest, fn func(CompletionResponse)) error {
+	ctx, span := tracing.StartSpan(ctx, "completion", tracing.KindClient)
+	defer span.End()
+
+	err := s.completion(ctx, req, func(cr CompletionResponse) {
+		if cr.Done {
+			span.SetAttributes(
+				tracing.String("gen_ai.response.finish_reason", cr.DoneReason),
+				tracing.Int("gen_ai.usage.input_tokens", cr.PromptEvalCount),
+				tracing.Int("gen_ai.usage.output_tokens", cr.EvalCount),
+			)
+
+			// the runner times prefill and decode, which end when it
+			// sends its final response
+			end := time.Now()
+			decodeStart := end.Add(-cr.EvalDuration)
+			prefillStart := decodeStart.Add(-cr.PromptEvalDuration)
+
+			_, prefill := tracing.StartSpanAt(ctx, "prefill", tracing.KindInternal, prefillStart, tracing.Int("gen_ai.usage.input_tokens", cr.PromptEvalCount))
+			prefill.EndAt(decodeStart)
+
+			_, decode := tracing.StartSpanAt(ctx, "decode", tracing.KindInternal, decodeStart, tracing.Int("gen_ai.usage.output_tokens", cr.EvalCount))
+			decode.EndAt(end)
+		}
+
+		fn(cr)
+	})
+	span.SetError(err)
+	return err
+}
+
+func (s *llmServer) completion(ctx context.Context, req CompletionRequest, fn func(CompletionResponse)) error {
 	request := map[string]any{
 		"prompt":            req.Prompt,
 		"stream":            true,
@@ -693,10 +854,31 @@ func (s *llmServer) Completion(ctx context.Context, req CompletionRequest, fn fu
 		"mirostat_tau":      req.Options.MirostatTau,
 		"mirostat_eta":      req.Options.MirostatEta,
 		"penalize_nl":       req.Options.PenalizeNewline,
+		"token_healing":     req.Options.TokenHealing,
 		"seed":              req.Options.Seed,
 		"stop":              req.Options.Stop,
+		"stop_regex":        req.Options.StopRegex,
+		"include_stop":      req.Options.IncludeStop,
+		"ignore_eos":        req.Options.IgnoreEOS,
 		"image_data":        req.Images,
-		"cache_prompt":      true,
+		"cache_prompt":      !req.NoStore,
+	}
+
+	if req.Logprobs {
+		request["logprobs"] = true
+		request["top_logprobs"] = req.TopLogprobs
+	}
+
+	if req.Options.Profile {
+		request["profile"] = true
+	}
+
+	if len(req.Scripts) > 0 {
+		request["scripts"] = req.Scripts
+	}
+
+	if req.Options.Watermark {
+		request["watermark_key"] = watermark.Key(envconfig.WatermarkKey())
 	}
 
 	if len(req.Format) > 0 {
@@ -712,6 +894,20 @@ func (s *llmServer) Completion(ctx context.Context, req CompletionRequest, fn fu
 				return fmt.Errorf("invalid format: %q; expected \"json\" or a valid JSON Schema object", req.Format)
 			}
 
+			// User provided a GBNF grammar
+			var grammar struct {
+				Type  string `json:"type"`
+				Value string `json:"value"`
+			}
+			if err := json.Unmarshal(req.Format, &grammar); err == nil && grammar.Type == "grammar" {
+				if err := llama.ValidateGrammar(grammar.Value); err != nil {
+					return fmt.Errorf("invalid format: %w", err)
+				}
+
+				request["grammar"] = grammar.Value
+				break
+			}
+
 			// User provided a JSON schema
 			g := llama.SchemaToGrammar(req.Format)
 			if g == nil {
@@ -759,6 +955,7 @@ func (s *llmServer) Completion(ctx context.Context, req CompletionRequest, fn fu
 		return fmt.Errorf("error creating POST request: %v", err)
 	}
 	serverReq.Header.Set("Content-Type", "application/json")
+	tracing.Inject(ctx, serverReq.Header)
 
 	res, err := http.DefaultClient.Do(serverReq)
 	if err != nil {
@@ -783,6 +980,14 @@ func (s *llmServer) Completion(ctx context.Context, req CompletionRequest, fn fu
 	var lastToken string
 	var tokenRepeat int
 
+	// generated is set once a response has been passed to fn, after which
+	// errors are reported in a final response instead
+	var generated bool
+
+	// offset is the length of the response so far, which the offsets of
+	// logprobs are relative to
+	var offset int
+
 	for scanner.Scan() {
 		select {
 		case <-ctx.Done():
@@ -815,21 +1020,48 @@ func (s *llmServer) Completion(ctx context.Context, req CompletionRequest, fn fu
 			// 30 picked as an arbitrary max token repeat limit, modify as needed
 			if tokenRepeat > 30 {
 				slog.Debug("prediction aborted, token repeat limit reached")
-				return ctx.Err()
+				fn(CompletionResponse{
+					Done:        true,
+					DoneReason:  "abort",
+					Diagnostics: &api.Diagnostics{Reason: "repetition", Message: fmt.Sprintf("token %q repeated too many times", lastToken)},
+				})
+				return nil
 			}
 
-			if c.Content != "" {
+			if c.Content != "" || len(c.Logprobs) > 0 {
+				tokenOffset := offset
+				for i := range c.Logprobs {
+					c.Logprobs[i].Offset = tokenOffset
+					tokenOffset += len(c.Logprobs[i].Token)
+				}
+				offset += len(c.Content)
+
+				generated = true
 				fn(CompletionResponse{
-					Content: c.Content,
+					Content:  c.Content,
+					Logprobs: c.Logprobs,
 				})
 			}
 
 			if c.Stop {
 				doneReason := "stop"
-				if c.StoppedLimit {
+				switch {
+				case c.AbortReason != "":
+					doneReason = "abort"
+				case c.StoppedLimit:
 					doneReason = "length"
 				}
 
+				var diagnostics *api.Diagnostics
+				if c.AbortReason != "" || c.ContextShifts > 0 || c.PromptTruncated > 0 {
+					diagnostics = &api.Diagnostics{
+						Reason:          c.AbortReason,
+						Message:         c.AbortMessage,
+						ContextShifts:   c.ContextShifts,
+						PromptTruncated: c.PromptTruncated,
+					}
+				}
+
 				fn(CompletionResponse{
 					Done:               true,
 					DoneReason:         doneReason,
@@ -837,6 +1069,10 @@ func (s *llmServer) Completion(ctx context.Context, req CompletionRequest, fn fu
 					PromptEvalDuration: parseDurationMs(c.Timings.PromptMS),
 					EvalCount:          c.Timings.PredictedN,
 					EvalDuration:       parseDurationMs(c.Timings.PredictedMS),
+					DraftCount:         c.Timings.DraftN,
+					DraftAcceptedCount: c.Timings.DraftAccN,
+					Diagnostics:        diagnostics,
+					Profile:            c.Profile,
 				})
 				return nil
 			}
@@ -852,7 +1088,18 @@ func (s *llmServer) Completion(ctx context.Context, req CompletionRequest, fn fu
 			} else {
 				msg = err.Error()
 			}
-			return fmt.Errorf("an error was encountered while running the model: %s", msg)
+
+			logID := s.logCrash()
+			if generated {
+				fn(CompletionResponse{
+					Done:        true,
+					DoneReason:  "abort",
+					Diagnostics: &api.Diagnostics{Reason: "runner_crashed", Message: msg, LogID: logID},
+				})
+				return nil
+			}
+
+			return fmt.Errorf("%w: an error was encountered while running the model: %s (log id %s)", ErrRunnerTerminated, msg, logID)
 		}
 
 		return fmt.Errorf("error reading llm response: %v", err)
@@ -898,6 +1145,7 @@ func (s *llmServer) Embedding(ctx context.Context, input string) ([]float32, err
 		return nil, fmt.Errorf("error creating embed request: %w", err)
 	}
 	r.Header.Set("Content-Type", "application/json")
+	tracing.Inject(ctx, r.Header)
 
 	resp, err := http.DefaultClient.Do(r)
 	if err != nil {
@@ -931,7 +1179,14 @@ type TokenizeResponse struct {
 	Tokens []int `json:"tokens"`
 }
 
-func (s *llmServer) Tokenize(ctx context.Context, content string) ([]int, error) {
+func (s *llmServer) Tokenize(ctx context.Context, content string) (tokens []int, err error) {
+	ctx, span := tracing.StartSpan(ctx, "tokenize", tracing.KindInternal)
+	defer func() {
+		span.SetAttributes(tracing.Int("ollama.tokens", len(tokens)))
+		span.SetError(err)
+		span.End()
+	}()
+
 	s.modelLock.Lock()
 	defer s.modelLock.Unlock()
 	if s.model != nil {
@@ -1068,6 +1323,19 @@ func (s *llmServer) Detokenize(ctx context.Context, tokens []int) (string, error
 	return decoded.Content, nil
 }
 
+// logCrash writes the runner's last output to the log after it crashed,
+// returning an ID to find the excerpt by
+func (s *llmServer) logCrash() string {
+	id := fmt.Sprintf("%08x", rand.Uint32())
+	if s.status != nil {
+		slog.Error("llama runner crashed", "log_id", id, "output", s.status.Tail())
+	} else {
+		slog.Error("llama runner crashed", "log_id", id)
+	}
+
+	return id
+}
+
 func (s *llmServer) Close() error {
 	s.modelLock.Lock()
 	if s.model != nil {
@@ -1101,6 +1369,144 @@ func (s *llmServer) EstimatedTotal() uint64 {
 	return s.estimate.TotalSize
 }
 
+// KvCacheUsage returns the number of tokens in the runner's KV cache and
+// the number it can hold across all of its slots
+func (s *llmServer) KvCacheUsage(ctx context.Context) (int, int, error) {
+	if _, err := s.getServerStatus(ctx); err != nil {
+		return 0, 0, err
+	}
+
+	s.kvCacheMu.Lock()
+	defer s.kvCacheMu.Unlock()
+	return s.kvCacheUsed, s.kvCacheSize, nil
+}
+
+type PurgeResponse struct {
+	Slots int `json:"slots"`
+}
+
+// PurgeCache discards the prompts kept in the runner's KV cache, returning
+// the number of its slots that were cleared. The prompts of requests in
+// progress are discarded once they finish.
+func (s *llmServer) PurgeCache(ctx context.Context) (int, error) {
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("http://127.0.0.1:%d/purge", s.port), nil)
+	if err != nil {
+		return 0, fmt.Errorf("error creating purge request: %w", err)
+	}
+
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		return 0, fmt.Errorf("do purge request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, fmt.Errorf("error reading purge response: %w", err)
+	}
+
+	if resp.StatusCode >= 400 {
+		return 0, fmt.Errorf("%s", body)
+	}
+
+	var p PurgeResponse
+	if err := json.Unmarshal(body, &p); err != nil {
+		return 0, fmt.Errorf("unmarshal purge response: %w", err)
+	}
+
+	return p.Slots, nil
+}
+
+type ParallelRequest struct {
+	Parallel int `json:"parallel"`
+}
+
+// SetParallel changes the number of requests the runner handles at once,
+// re-partitioning its KV cache without reloading the model. gpus are those
+// the runner was loaded on, with the memory available to it. The runner
+// waits for the requests it's handling to finish before it changes.
+func (s *llmServer) SetParallel(ctx context.Context, gpus discover.GpuInfoList, numParallel int) error {
+	s.parallelMu.Lock()
+	defer s.parallelMu.Unlock()
+
+	prev := s.numParallel
+	if numParallel == prev {
+		return nil
+	}
+
+	if numParallel < 1 || numParallel > s.maxParallel {
+		return fmt.Errorf("parallel must be between 1 and %d", s.maxParallel)
+	}
+
+	opts := s.options
+	opts.NumCtx = s.options.NumCtx / prev * numParallel
+	estimate := EstimateGPULayers(gpus, s.ggml, s.projectors, opts)
+	if numParallel > prev {
+		if estimate.Layers < s.estimate.Layers {
+			return fmt.Errorf("not enough VRAM for %d parallel requests", numParallel)
+		}
+
+		if was, now := s.estimate.TotalSize-s.estimate.VRAMSize, estimate.TotalSize-estimate.VRAMSize; now > was && now-was > discover.GetSystemInfo().System.FreeMemory {
+			return fmt.Errorf("not enough system memory for %d parallel requests", numParallel)
+		}
+	} else {
+		// fewer requests are let through before the runner is changed, so it
+		// isn't sent more than it can handle after
+		if err := s.sem.Acquire(ctx, int64(prev-numParallel)); err != nil {
+			return err
+		}
+	}
+
+	if err := s.postParallel(ctx, numParallel); err != nil {
+		if numParallel < prev {
+			s.sem.Release(int64(prev - numParallel))
+		}
+		return err
+	}
+
+	if numParallel > prev {
+		s.sem.Release(int64(numParallel - prev))
+	}
+
+	s.numParallel = numParallel
+	s.options = opts
+	s.estimate = estimate
+	return nil
+}
+
+func (s *llmServer) postParallel(ctx context.Context, numParallel int) error {
+	data, err := json.Marshal(ParallelRequest{Parallel: numParallel})
+	if err != nil {
+		return fmt.Errorf("error marshaling parallel data: %w", err)
+	}
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("http://127.0.0.1:%d/parallel", s.port), bytes.NewBuffer(data))
+	if err != nil {
+		return fmt.Errorf("error creating parallel request: %w", err)
+	}
+	r.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		return fmt.Errorf("do parallel request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error reading parallel response: %w", err)
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotImplemented:
+		return fmt.Errorf("%w: %s", ErrParallelUnsupported, bytes.TrimSpace(body))
+	case resp.StatusCode >= 400:
+		return fmt.Errorf("%s", bytes.TrimSpace(body))
+	}
+
+	return nil
+}
+
 func (s *llmServer) EstimatedVRAMByGPU(gpuID string) uint64 {
 	for i, gpu := range s.gpus {
 		if gpu.ID == gpuID {
@@ -1112,6 +1518,17 @@ func (s *llmServer) EstimatedVRAMByGPU(gpuID string) uint64 {
 	return 0
 }
 
+func (s *llmServer) EstimatedLayersByGPU(gpuID string) int {
+	for i, gpu := range s.gpus {
+		if gpu.ID == gpuID {
+			if i < len(s.estimate.GPULayers) {
+				return s.estimate.GPULayers[i]
+			}
+		}
+	}
+	return 0
+}
+
 func parseDurationMs(ms float64) time.Duration {
 	dur, err := time.ParseDuration(fmt.Sprintf("%fms", ms))
 	if err != nil {
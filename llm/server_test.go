@@ -4,11 +4,20 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
 	"strings"
 	"testing"
 
-	"github.com/ollama/ollama/api"
+	"github.com/google/go-cmp/cmp"
 	"golang.org/x/sync/semaphore"
+
+	"github.com/ollama/ollama/api"
 )
 
 func TestLLMServerCompletionFormat(t *testing.T) {
@@ -37,6 +46,14 @@ func TestLLMServerCompletionFormat(t *testing.T) {
 	checkInvalid("X")   // invalid format
 	checkInvalid(`"X"`) // invalid JSON Schema
 
+	err := s.Completion(ctx, CompletionRequest{
+		Options: new(api.Options),
+		Format:  []byte(`{"type":"grammar","value":"expr ::= [0-9]+"}`),
+	}, nil)
+	if err == nil || !strings.Contains(err.Error(), "invalid grammar") {
+		t.Fatalf("err = %v; want invalid grammar", err)
+	}
+
 	cancel() // prevent further processing if request makes it past the format check
 
 	checkValid := func(err error) {
@@ -55,6 +72,9 @@ func TestLLMServerCompletionFormat(t *testing.T) {
 		// JSON
 		`"json"`,
 		`{"type":"object"}`,
+
+		// grammar
+		`{"type":"grammar","value":"root ::= \"SELECT \" [a-z]+"}`,
 	}
 	for _, valid := range valids {
 		err := s.Completion(ctx, CompletionRequest{
@@ -64,9 +84,127 @@ func TestLLMServerCompletionFormat(t *testing.T) {
 		checkValid(err)
 	}
 
-	err := s.Completion(ctx, CompletionRequest{
+	err = s.Completion(ctx, CompletionRequest{
 		Options: new(api.Options),
 		Format:  nil, // missing format
 	}, nil)
 	checkValid(err)
 }
+
+func TestLLMServerCompletionDiagnostics(t *testing.T) {
+	cases := []struct {
+		name   string
+		lines  []string
+		reason string
+		diag   *api.Diagnostics
+	}{
+		{
+			name:   "stop",
+			lines:  []string{`{"content":"hi"}`, `{"stop":true}`},
+			reason: "stop",
+		},
+		{
+			name:   "context shift",
+			lines:  []string{`{"content":"hi"}`, `{"stop":true,"stopped_limit":true,"context_shifts":2}`},
+			reason: "length",
+			diag:   &api.Diagnostics{ContextShifts: 2},
+		},
+		{
+			name:   "context shift failed",
+			lines:  []string{`{"content":"hi"}`, `{"stop":true,"abort_reason":"context_shift_failed","abort_message":"unable to shift context","context_shifts":1}`},
+			reason: "abort",
+			diag:   &api.Diagnostics{Reason: "context_shift_failed", Message: "unable to shift context", ContextShifts: 1},
+		},
+		{
+			name:   "repetition",
+			lines:  slices.Repeat([]string{`{"content":"again"}`}, 40),
+			reason: "abort",
+			diag:   &api.Diagnostics{Reason: "repetition", Message: `token "again" repeated too many times`},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/health":
+					fmt.Fprint(w, `{"status":"ok"}`)
+				case "/completion":
+					for _, line := range tt.lines {
+						fmt.Fprintln(w, line)
+					}
+				}
+			}))
+			defer srv.Close()
+
+			u, err := url.Parse(srv.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			port, err := strconv.Atoi(u.Port())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			s := &llmServer{port: port, cmd: &exec.Cmd{}, sem: semaphore.NewWeighted(1)}
+
+			var final CompletionResponse
+			err = s.Completion(context.Background(), CompletionRequest{Options: new(api.Options)}, func(r CompletionResponse) {
+				if r.Done {
+					final = r
+				}
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if final.DoneReason != tt.reason {
+				t.Errorf("expected done reason %q, got %q", tt.reason, final.DoneReason)
+			}
+
+			if diff := cmp.Diff(tt.diag, final.Diagnostics); diff != "" {
+				t.Errorf("diagnostics mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestStatusWriterTail(t *testing.T) {
+	f, err := os.CreateTemp(t.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	w := NewStatusWriter(f)
+	for i := range 1000 {
+		fmt.Fprintf(w, "line %d\n", i)
+	}
+
+	tail := w.Tail()
+	if !strings.HasPrefix(tail, "line ") || !strings.HasSuffix(tail, "\nline 999") || len(tail) > statusTailSize {
+		t.Errorf("unexpected tail %q", tail)
+	}
+}
+
+func TestDraftParams(t *testing.T) {
+	opts := api.DefaultOptions()
+	if params := draftParams(opts, nil, 6); params != nil {
+		t.Errorf("expected no params without a draft model, got %v", params)
+	}
+
+	opts.DraftModel = "/models/draft"
+	if diff := cmp.Diff([]string{"--draft-model", "/models/draft"}, draftParams(opts, nil, 0)); diff != "" {
+		t.Errorf("params mismatch (-want +got):\n%s", diff)
+	}
+
+	opts.DraftMax = 8
+	if diff := cmp.Diff([]string{"--draft-model", "/models/draft", "--draft-max", "8", "--n-gpu-layers-draft", "6"}, draftParams(opts, nil, 6)); diff != "" {
+		t.Errorf("params mismatch (-want +got):\n%s", diff)
+	}
+
+	if params := draftParams(opts, []string{"projector"}, 6); params != nil {
+		t.Errorf("expected no params with a projector, got %v", params)
+	}
+}
@@ -3,12 +3,20 @@ package llm
 import (
 	"bytes"
 	"os"
+	"sync"
 )
 
+// statusTailSize is the number of bytes of the runner's most recent output
+// kept for diagnosing crashes
+const statusTailSize = 4096
+
 // StatusWriter is a writer that captures error messages from the llama runner process
 type StatusWriter struct {
 	LastErrMsg string
 	out        *os.File
+
+	mu   sync.Mutex
+	tail []byte
 }
 
 func NewStatusWriter(out *os.File) *StatusWriter {
@@ -41,5 +49,27 @@ func (w *StatusWriter) Write(b []byte) (int, error) {
 		w.LastErrMsg = errMsg
 	}
 
+	w.mu.Lock()
+	w.tail = append(w.tail, b...)
+	if len(w.tail) > statusTailSize {
+		w.tail = w.tail[len(w.tail)-statusTailSize:]
+	}
+	w.mu.Unlock()
+
 	return w.out.Write(b)
 }
+
+// Tail returns the most recent complete lines the runner wrote
+func (w *StatusWriter) Tail() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	tail := w.tail
+	if len(tail) == statusTailSize {
+		if _, after, ok := bytes.Cut(tail, []byte("\n")); ok {
+			tail = after
+		}
+	}
+
+	return string(bytes.TrimSpace(tail))
+}
@@ -64,15 +64,21 @@ type Usage struct {
 type ResponseFormat struct {
 	Type       string      `json:"type"`
 	JsonSchema *JsonSchema `json:"json_schema,omitempty"`
+
+	// Value is the regular expression of the regex type
+	Value string `json:"value,omitempty"`
 }
 
 type JsonSchema struct {
+	Name   string          `json:"name,omitempty"`
 	Schema json.RawMessage `json:"schema"`
+	Strict bool            `json:"strict,omitempty"`
 }
 
 type EmbedRequest struct {
-	Input any    `json:"input"`
-	Model string `json:"model"`
+	Input      any    `json:"input"`
+	Model      string `json:"model"`
+	Dimensions int    `json:"dimensions,omitempty"`
 }
 
 type StreamOptions struct {
@@ -80,19 +86,22 @@ type StreamOptions struct {
 }
 
 type ChatCompletionRequest struct {
-	Model            string          `json:"model"`
-	Messages         []Message       `json:"messages"`
-	Stream           bool            `json:"stream"`
-	StreamOptions    *StreamOptions  `json:"stream_options"`
-	MaxTokens        *int            `json:"max_tokens"`
-	Seed             *int            `json:"seed"`
-	Stop             any             `json:"stop"`
-	Temperature      *float64        `json:"temperature"`
-	FrequencyPenalty *float64        `json:"frequency_penalty"`
-	PresencePenalty  *float64        `json:"presence_penalty"`
-	TopP             *float64        `json:"top_p"`
-	ResponseFormat   *ResponseFormat `json:"response_format"`
-	Tools            []api.Tool      `json:"tools"`
+	Model             string            `json:"model"`
+	Messages          []Message         `json:"messages"`
+	Stream            bool              `json:"stream"`
+	StreamOptions     *StreamOptions    `json:"stream_options"`
+	MaxTokens         *int              `json:"max_tokens"`
+	Seed              *int              `json:"seed"`
+	Stop              any               `json:"stop"`
+	Temperature       *float64          `json:"temperature"`
+	FrequencyPenalty  *float64          `json:"frequency_penalty"`
+	PresencePenalty   *float64          `json:"presence_penalty"`
+	TopP              *float64          `json:"top_p"`
+	ResponseFormat    *ResponseFormat   `json:"response_format"`
+	Tools             []api.Tool        `json:"tools"`
+	ParallelToolCalls *bool             `json:"parallel_tool_calls"`
+	ToolChoice        *api.ToolChoice   `json:"tool_choice"`
+	Metadata          map[string]string `json:"metadata"`
 }
 
 type ChatCompletion struct {
@@ -196,8 +205,14 @@ func NewError(code int, message string) ErrorResponse {
 	switch code {
 	case http.StatusBadRequest:
 		etype = "invalid_request_error"
+	case http.StatusUnauthorized:
+		etype = "authentication_error"
+	case http.StatusForbidden:
+		etype = "permission_error"
 	case http.StatusNotFound:
 		etype = "not_found_error"
+	case http.StatusTooManyRequests:
+		etype = "rate_limit_error"
 	default:
 		etype = "api_error"
 	}
@@ -241,6 +256,23 @@ func toToolCalls(tc []api.ToolCall) []ToolCall {
 	return toolCalls
 }
 
+// toToolCallDeltas converts streamed tool call deltas. Like OpenAI, the ID
+// and type of a call are only sent with its first delta, the one with its name.
+func toToolCallDeltas(deltas []api.ToolCallDelta) []ToolCall {
+	toolCalls := make([]ToolCall, len(deltas))
+	for i, d := range deltas {
+		toolCalls[i].Index = d.Index
+		if d.Name != "" {
+			toolCalls[i].ID = toolCallId()
+			toolCalls[i].Type = "function"
+		}
+
+		toolCalls[i].Function.Name = d.Name
+		toolCalls[i].Function.Arguments = d.Arguments
+	}
+	return toolCalls
+}
+
 func toChatCompletion(id string, r api.ChatResponse) ChatCompletion {
 	toolCalls := toToolCalls(r.Message.ToolCalls)
 	return ChatCompletion{
@@ -509,10 +541,19 @@ func fromChatRequest(r ChatCompletionRequest) (*api.ChatRequest, error) {
 		// Support the old "json_object" type for OpenAI compatibility
 		case "json_object":
 			format = json.RawMessage(`"json"`)
-		case "json_schema":
-			if r.ResponseFormat.JsonSchema != nil {
-				format = r.ResponseFormat.JsonSchema.Schema
+		case "json_schema", "regex":
+			rf := *r.ResponseFormat
+			rf.Type = strings.ToLower(strings.TrimSpace(rf.Type))
+			if rf.Type == "json_schema" && rf.JsonSchema == nil {
+				break
 			}
+
+			// the server handles these like OpenAI does
+			b, err := json.Marshal(rf)
+			if err != nil {
+				return nil, err
+			}
+			format = b
 		}
 	}
 
@@ -523,6 +564,10 @@ func fromChatRequest(r ChatCompletionRequest) (*api.ChatRequest, error) {
 		Options:  options,
 		Stream:   &r.Stream,
 		Tools:    r.Tools,
+		Metadata: r.Metadata,
+
+		ParallelToolCalls: r.ParallelToolCalls == nil || *r.ParallelToolCalls,
+		ToolChoice:        r.ToolChoice,
 	}, nil
 }
 
@@ -585,6 +630,9 @@ type ChatWriter struct {
 	stream        bool
 	streamOptions *StreamOptions
 	id            string
+	// toolCallDeltas is set once tool calls are streamed as deltas, so the
+	// complete calls that follow them aren't sent again
+	toolCallDeltas bool
 	BaseWriter
 }
 
@@ -616,8 +664,14 @@ func (w *BaseWriter) writeError(data []byte) (int, error) {
 		return 0, err
 	}
 
+	resp := NewError(http.StatusInternalServerError, serr.Error())
+	if serr.Code != "" {
+		code := string(serr.Code)
+		resp.Error.Code = &code
+	}
+
 	w.ResponseWriter.Header().Set("Content-Type", "application/json")
-	err = json.NewEncoder(w.ResponseWriter).Encode(NewError(http.StatusInternalServerError, serr.Error()))
+	err = json.NewEncoder(w.ResponseWriter).Encode(resp)
 	if err != nil {
 		return 0, err
 	}
@@ -635,6 +689,14 @@ func (w *ChatWriter) writeResponse(data []byte) (int, error) {
 	// chat chunk
 	if w.stream {
 		c := toChunk(w.id, chatResponse)
+		if len(chatResponse.Message.ToolCallDeltas) > 0 {
+			w.toolCallDeltas = true
+		}
+
+		if w.toolCallDeltas {
+			c.Choices[0].Delta.ToolCalls = toToolCallDeltas(chatResponse.Message.ToolCallDeltas)
+		}
+
 		d, err := json.Marshal(c)
 		if err != nil {
 			return 0, err
@@ -923,7 +985,7 @@ func EmbeddingsMiddleware() gin.HandlerFunc {
 		}
 
 		var b bytes.Buffer
-		if err := json.NewEncoder(&b).Encode(api.EmbedRequest{Model: req.Model, Input: req.Input}); err != nil {
+		if err := json.NewEncoder(&b).Encode(api.EmbedRequest{Model: req.Model, Input: req.Input, Dimensions: req.Dimensions}); err != nil {
 			c.AbortWithStatusJSON(http.StatusInternalServerError, NewError(http.StatusInternalServerError, err.Error()))
 			return
 		}
@@ -60,7 +60,8 @@ func TestChatMiddleware(t *testing.T) {
 				]
 			}`,
 			req: api.ChatRequest{
-				Model: "test-model",
+				ParallelToolCalls: true,
+				Model:             "test-model",
 				Messages: []api.Message{
 					{
 						Role:    "user",
@@ -92,7 +93,8 @@ func TestChatMiddleware(t *testing.T) {
 				"response_format":   {"type": "json_object"}
 			}`,
 			req: api.ChatRequest{
-				Model: "test-model",
+				ParallelToolCalls: true,
+				Model:             "test-model",
 				Messages: []api.Message{
 					{
 						Role:    "user",
@@ -131,7 +133,8 @@ func TestChatMiddleware(t *testing.T) {
 				"response_format":   {"type": "json_object"}
 			}`,
 			req: api.ChatRequest{
-				Model: "test-model",
+				ParallelToolCalls: true,
+				Model:             "test-model",
 				Messages: []api.Message{
 					{
 						Role:    "user",
@@ -151,6 +154,32 @@ func TestChatMiddleware(t *testing.T) {
 				Stream: &True,
 			},
 		},
+		{
+			name: "chat handler with strict json schema",
+			body: `{
+				"model": "test-model",
+				"messages": [
+					{"role": "user", "content": "Hello"}
+				],
+				"response_format": {"type": "json_schema", "json_schema": {"name": "greeting", "schema": {"type":"object"}, "strict": true}}
+			}`,
+			req: api.ChatRequest{
+				ParallelToolCalls: true,
+				Model:             "test-model",
+				Messages: []api.Message{
+					{
+						Role:    "user",
+						Content: "Hello",
+					},
+				},
+				Options: map[string]any{
+					"temperature": 1.0,
+					"top_p":       1.0,
+				},
+				Format: json.RawMessage(`{"type":"json_schema","json_schema":{"name":"greeting","schema":{"type":"object"},"strict":true}}`),
+				Stream: &False,
+			},
+		},
 		{
 			name: "chat handler with image content",
 			body: `{
@@ -174,7 +203,8 @@ func TestChatMiddleware(t *testing.T) {
 				]
 			}`,
 			req: api.ChatRequest{
-				Model: "test-model",
+				ParallelToolCalls: true,
+				Model:             "test-model",
 				Messages: []api.Message{
 					{
 						Role:    "user",
@@ -207,7 +237,8 @@ func TestChatMiddleware(t *testing.T) {
 				]
 			}`,
 			req: api.ChatRequest{
-				Model: "test-model",
+				ParallelToolCalls: true,
+				Model:             "test-model",
 				Messages: []api.Message{
 					{
 						Role:    "user",
@@ -235,6 +266,42 @@ func TestChatMiddleware(t *testing.T) {
 				Stream: &False,
 			},
 		},
+		{
+			name: "chat handler with tool choice",
+			body: `{
+				"model": "test-model",
+				"messages": [{"role": "user", "content": "Hello"}],
+				"tool_choice": {"type": "function", "function": {"name": "get_weather"}}
+			}`,
+			req: api.ChatRequest{
+				ParallelToolCalls: true,
+				Model:             "test-model",
+				Messages:          []api.Message{{Role: "user", Content: "Hello"}},
+				Options: map[string]any{
+					"temperature": 1.0,
+					"top_p":       1.0,
+				},
+				Stream:     &False,
+				ToolChoice: &api.ToolChoice{Type: "function", Function: &api.ToolChoiceFunction{Name: "get_weather"}},
+			},
+		},
+		{
+			name: "chat handler without parallel tool calls",
+			body: `{
+				"model": "test-model",
+				"messages": [{"role": "user", "content": "Hello"}],
+				"parallel_tool_calls": false
+			}`,
+			req: api.ChatRequest{
+				Model:    "test-model",
+				Messages: []api.Message{{Role: "user", Content: "Hello"}},
+				Options: map[string]any{
+					"temperature": 1.0,
+					"top_p":       1.0,
+				},
+				Stream: &False,
+			},
+		},
 		{
 			name: "chat handler with streaming tools",
 			body: `{
@@ -266,7 +333,8 @@ func TestChatMiddleware(t *testing.T) {
 				}]
 			}`,
 			req: api.ChatRequest{
-				Model: "test-model",
+				ParallelToolCalls: true,
+				Model:             "test-model",
 				Messages: []api.Message{
 					{
 						Role:    "user",
@@ -279,29 +347,17 @@ func TestChatMiddleware(t *testing.T) {
 						Function: api.ToolFunction{
 							Name:        "get_weather",
 							Description: "Get the current weather",
-							Parameters: struct {
-								Type       string   `json:"type"`
-								Required   []string `json:"required"`
-								Properties map[string]struct {
-									Type        string   `json:"type"`
-									Description string   `json:"description"`
-									Enum        []string `json:"enum,omitempty"`
-								} `json:"properties"`
-							}{
+							Parameters: api.ToolParameters{
 								Type:     "object",
 								Required: []string{"location"},
-								Properties: map[string]struct {
-									Type        string   `json:"type"`
-									Description string   `json:"description"`
-									Enum        []string `json:"enum,omitempty"`
-								}{
+								Properties: map[string]api.ToolProperty{
 									"location": {
-										Type:        "string",
+										Type:        api.PropertyType{"string"},
 										Description: "The city and state",
 									},
 									"unit": {
-										Type: "string",
-										Enum: []string{"celsius", "fahrenheit"},
+										Type: api.PropertyType{"string"},
+										Enum: []any{"celsius", "fahrenheit"},
 									},
 								},
 							},
@@ -368,6 +424,76 @@ func TestChatMiddleware(t *testing.T) {
 	}
 }
 
+func TestChatMiddlewareToolCallDeltas(t *testing.T) {
+	responses := []api.ChatResponse{
+		{Message: api.Message{Role: "assistant", ToolCallDeltas: []api.ToolCallDelta{{Name: "get_weather"}}}},
+		{Message: api.Message{Role: "assistant", ToolCallDeltas: []api.ToolCallDelta{{Arguments: `{"location":`}}}},
+		{Message: api.Message{
+			Role:           "assistant",
+			ToolCallDeltas: []api.ToolCallDelta{{Arguments: `"Paris"}`}},
+			ToolCalls:      []api.ToolCall{{Function: api.ToolCallFunction{Name: "get_weather", Arguments: api.ToolCallFunctionArguments{"location": "Paris"}}}},
+		}},
+		{Message: api.Message{Role: "assistant"}, Done: true, DoneReason: "stop"},
+	}
+
+	endpoint := func(c *gin.Context) {
+		c.Status(http.StatusOK)
+		for _, r := range responses {
+			b, err := json.Marshal(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := c.Writer.Write(b); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(ChatMiddleware())
+	router.Handle(http.MethodPost, "/api/chat", endpoint)
+
+	req, _ := http.NewRequest(http.MethodPost, "/api/chat", strings.NewReader(`{"model":"test-model","messages":[{"role":"user","content":"Hello"}],"stream":true}`))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+
+	var toolCalls []ToolCall
+	for _, line := range strings.Split(resp.Body.String(), "\n") {
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok || data == "[DONE]" {
+			continue
+		}
+
+		var chunk ChatCompletionChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			t.Fatal(err)
+		}
+
+		toolCalls = append(toolCalls, chunk.Choices[0].Delta.ToolCalls...)
+	}
+
+	if len(toolCalls) != 3 {
+		t.Fatalf("expected 3 tool call deltas, got %d", len(toolCalls))
+	}
+
+	if toolCalls[0].ID == "" || toolCalls[0].Type != "function" || toolCalls[0].Function.Name != "get_weather" {
+		t.Errorf("unexpected first delta %+v", toolCalls[0])
+	}
+
+	var arguments string
+	for _, tc := range toolCalls {
+		arguments += tc.Function.Arguments
+	}
+
+	if arguments != `{"location":"Paris"}` {
+		t.Errorf("unexpected arguments %q", arguments)
+	}
+}
+
 func TestCompletionsMiddleware(t *testing.T) {
 	type testCase struct {
 		name string
@@ -46,6 +46,14 @@ func (f Modelfile) CreateRequest() (*api.CreateRequest, error) {
 	for _, c := range f.Commands {
 		switch c.Name {
 		case "model":
+			// models can generate with a remote server, named as a url with
+			// the server's name of the model as its fragment
+			if strings.HasPrefix(c.Args, "http://") || strings.HasPrefix(c.Args, "https://") {
+				url, model, _ := strings.Cut(c.Args, "#")
+				req.Remote = &api.RemoteModel{URL: url, Model: model}
+				continue
+			}
+
 			path, err := expandPath(c.Args)
 			if err != nil {
 				return nil, err
@@ -76,6 +84,8 @@ func (f Modelfile) CreateRequest() (*api.CreateRequest, error) {
 			req.Template = c.Args
 		case "system":
 			req.System = c.Args
+		case "toolprompt":
+			req.ToolPrompt = c.Args
 		case "license":
 			licenses = append(licenses, c.Args)
 		case "message":
@@ -262,7 +272,7 @@ func (c Command) String() string {
 	switch c.Name {
 	case "model":
 		fmt.Fprintf(&sb, "FROM %s", c.Args)
-	case "license", "template", "system", "adapter":
+	case "license", "template", "system", "adapter", "toolprompt":
 		fmt.Fprintf(&sb, "%s %s", strings.ToUpper(c.Name), quote(c.Args))
 	case "message":
 		role, message, _ := strings.Cut(c.Args, ": ")
@@ -548,7 +558,7 @@ func isValidMessageRole(role string) bool {
 
 func isValidCommand(cmd string) bool {
 	switch strings.ToLower(cmd) {
-	case "from", "license", "template", "system", "adapter", "parameter", "message":
+	case "from", "license", "template", "system", "adapter", "parameter", "message", "toolprompt":
 		return true
 	default:
 		return false
@@ -734,6 +734,25 @@ MESSAGE assistant Hi! How are you?
 				},
 			},
 		},
+		{
+			`FROM test
+TOOLPROMPT """Call these tools:
+{{- range .Tools }} {{ .Function.Name }}{{ end }}"""
+`,
+			&api.CreateRequest{
+				From:       "test",
+				ToolPrompt: "Call these tools:\n{{- range .Tools }} {{ .Function.Name }}{{ end }}",
+			},
+		},
+		{
+			`FROM http://localhost:8000/v1#meta-llama/Llama-3.1-70B-Instruct
+PARAMETER num_ctx 32768
+`,
+			&api.CreateRequest{
+				Remote:     &api.RemoteModel{URL: "http://localhost:8000/v1", Model: "meta-llama/Llama-3.1-70B-Instruct"},
+				Parameters: map[string]any{"num_ctx": int64(32768)},
+			},
+		},
 	}
 
 	for _, c := range cases {
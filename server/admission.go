@@ -0,0 +1,154 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/llm"
+)
+
+var ErrTTFTTarget = errors.New("server busy, please try again.  projected time to first token exceeds target")
+
+// admissionError rejects a request whose time to first token would exceed
+// the target. RetryAfter is how long until the current backlog is expected
+// to clear.
+type admissionError struct {
+	projected  time.Duration
+	retryAfter time.Duration
+}
+
+func (e *admissionError) Error() string {
+	return fmt.Sprintf("%s (projected %s)", ErrTTFTTarget, e.projected.Round(time.Millisecond))
+}
+
+func (e *admissionError) Unwrap() error {
+	return ErrTTFTTarget
+}
+
+const (
+	// bytesPerToken approximates the tokens in a prompt without tokenizing it
+	bytesPerToken = 4
+
+	// imageTokens approximates the tokens used by an image embedding
+	imageTokens = 576
+
+	// minPrefillSample is the fewest prompt tokens a completion must evaluate
+	// to update the prefill rate. Smaller samples are dominated by overhead or
+	// the prompt cache.
+	minPrefillSample = 32
+)
+
+// prefillTracker projects how long a new request waits for its first token
+// on a model from the prompt tokens waiting to be evaluated and the rate
+// recent requests were evaluated at
+type prefillTracker struct {
+	mu sync.Mutex
+
+	// pending is the estimated prompt tokens of requests yet to produce a
+	// first token
+	pending int
+
+	// rate is a moving average of prompt tokens evaluated per second. It is
+	// zero until a request has completed.
+	rate float64
+}
+
+// prefillTrackers maps model paths to their *prefillTracker
+var prefillTrackers sync.Map
+
+func prefillTrackerFor(modelPath string) *prefillTracker {
+	t, _ := prefillTrackers.LoadOrStore(modelPath, &prefillTracker{})
+	return t.(*prefillTracker)
+}
+
+// estimateTokens estimates the prompt tokens needed for prompt and images
+func estimateTokens(prompt string, images []llm.ImageData) int {
+	return len(prompt)/bytesPerToken + len(images)*imageTokens
+}
+
+// admit accounts for a request with tokens prompt tokens. Interactive requests
+// are rejected if the projected time to first token exceeds target, unless
+// nothing else is waiting. Other requests are always admitted but still count
+// toward the backlog of later requests. A target of zero disables rejection.
+func (t *prefillTracker) admit(tokens int, interactive bool, target time.Duration) (*prefillTicket, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if interactive && target > 0 && t.rate > 0 && t.pending > 0 {
+		projected := time.Duration(float64(t.pending+tokens) / t.rate * float64(time.Second))
+		if projected > target {
+			drain := time.Duration(float64(t.pending) / t.rate * float64(time.Second))
+			return nil, &admissionError{
+				projected:  projected,
+				retryAfter: max(time.Second, drain.Round(time.Second)),
+			}
+		}
+	}
+
+	t.pending += tokens
+	return &prefillTicket{tracker: t, tokens: tokens}, nil
+}
+
+// observe updates the prefill rate from a completed request's metrics
+func (t *prefillTracker) observe(count int, duration time.Duration) {
+	if count < minPrefillSample || duration <= 0 {
+		return
+	}
+
+	rate := float64(count) / duration.Seconds()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.rate == 0 {
+		t.rate = rate
+	} else {
+		t.rate = 0.8*t.rate + 0.2*rate
+	}
+}
+
+// prefillTicket is an admitted request's share of the prefill backlog
+type prefillTicket struct {
+	tracker *prefillTracker
+	tokens  int
+	once    sync.Once
+}
+
+// done removes the ticket from the backlog. It's called when the first token
+// arrives or the request ends, whichever is first.
+func (p *prefillTicket) done() {
+	p.once.Do(func() {
+		p.tracker.mu.Lock()
+		defer p.tracker.mu.Unlock()
+		p.tracker.pending -= p.tokens
+	})
+}
+
+// track wraps a completion callback to release the ticket on the first
+// response and learn the prefill rate from the final one
+func (p *prefillTicket) track(fn func(llm.CompletionResponse)) func(llm.CompletionResponse) {
+	return func(cr llm.CompletionResponse) {
+		p.done()
+		if cr.Done {
+			p.tracker.observe(cr.PromptEvalCount, cr.PromptEvalDuration)
+		}
+
+		fn(cr)
+	}
+}
+
+// admitPrefill admits a completion request for the model at modelPath against
+// the time to first token target set by OLLAMA_TTFT_TARGET. The returned
+// ticket must be released with done.
+func admitPrefill(modelPath, prompt string, images []llm.ImageData, interactive bool) (*prefillTicket, error) {
+	return prefillTrackerFor(modelPath).admit(estimateTokens(prompt, images), interactive, envconfig.TTFTTarget())
+}
+
+// retryAfterSeconds formats d for a Retry-After header
+func retryAfterSeconds(d time.Duration) string {
+	return fmt.Sprint(int64(math.Ceil(d.Seconds())))
+}
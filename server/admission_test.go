@@ -0,0 +1,105 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/llm"
+)
+
+func TestPrefillAdmission(t *testing.T) {
+	var tracker prefillTracker
+	target := 2 * time.Second
+
+	// requests are admitted until a prefill rate has been observed
+	first, err := tracker.admit(4000, true, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// too few tokens to be a useful sample
+	tracker.observe(10, time.Second)
+	if tracker.rate != 0 {
+		t.Fatalf("expected no rate, got %f", tracker.rate)
+	}
+
+	tracker.observe(1000, time.Second)
+	if tracker.rate != 1000 {
+		t.Fatalf("expected rate 1000, got %f", tracker.rate)
+	}
+
+	// 4000 pending tokens at 1000 tokens/s is over the target
+	_, err = tracker.admit(100, true, target)
+	var ae *admissionError
+	if !errors.As(err, &ae) || !errors.Is(err, ErrTTFTTarget) {
+		t.Fatalf("expected admission error, got %v", err)
+	}
+
+	if ae.retryAfter != 4*time.Second {
+		t.Errorf("expected retry after 4s, got %s", ae.retryAfter)
+	}
+
+	// non-interactive requests are queued behind the backlog
+	batch, err := tracker.admit(500, false, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first.done()
+	first.done()
+	if tracker.pending != 500 {
+		t.Fatalf("expected 500 pending tokens, got %d", tracker.pending)
+	}
+
+	if _, err := tracker.admit(1000, true, target); err != nil {
+		t.Fatalf("expected request within target to be admitted, got %v", err)
+	}
+
+	var called bool
+	fn := batch.track(func(llm.CompletionResponse) { called = true })
+	fn(llm.CompletionResponse{Done: true, PromptEvalCount: 2000, PromptEvalDuration: time.Second})
+	if !called {
+		t.Error("expected callback to be called")
+	}
+
+	if tracker.pending != 1000 {
+		t.Errorf("expected 1000 pending tokens, got %d", tracker.pending)
+	}
+
+	if tracker.rate != 1200 {
+		t.Errorf("expected rate 1200, got %f", tracker.rate)
+	}
+
+	// a single request is never rejected
+	var idle prefillTracker
+	idle.rate = 10
+	if _, err := idle.admit(100000, true, target); err != nil {
+		t.Errorf("expected request to be admitted, got %v", err)
+	}
+
+	// no target disables rejection
+	if _, err := tracker.admit(100000, true, 0); err != nil {
+		t.Errorf("expected request to be admitted, got %v", err)
+	}
+}
+
+func TestAdmissionRetryAfter(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	handleScheduleError(c, "test", &admissionError{projected: 5 * time.Second, retryAfter: 3 * time.Second})
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status 503, got %d", w.Code)
+	}
+
+	if got := w.Header().Get("Retry-After"); got != "3" {
+		t.Errorf("expected Retry-After 3, got %q", got)
+	}
+}
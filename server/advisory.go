@@ -0,0 +1,173 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/discover"
+	"github.com/ollama/ollama/runners"
+	"github.com/ollama/ollama/types/model"
+)
+
+const (
+	advisoryKQuantCPU  = "kquant_cpu"
+	advisoryKQuantGPU  = "kquant_gpu"
+	advisoryFloatSpill = "float_spill"
+)
+
+// legacyQuants are the quantizations with fast paths on hardware that lacks
+// the ones K-quants use
+var legacyQuants = []string{"Q4_0", "Q4_1", "Q5_0", "Q5_1", "Q8_0"}
+
+func isKQuant(fileType string) bool {
+	return strings.Contains(fileType, "_K")
+}
+
+func isFloat(fileType string) bool {
+	return slices.Contains([]string{"F32", "F16", "BF16"}, fileType)
+}
+
+// computeAtLeast reports whether a CUDA compute capability such as "6.1" is
+// at least major.minor
+func computeAtLeast(compute string, major, minor int) bool {
+	majStr, minStr, ok := strings.Cut(compute, ".")
+	if !ok {
+		return true
+	}
+
+	m, err1 := strconv.Atoi(majStr)
+	n, err2 := strconv.Atoi(minStr)
+	if err1 != nil || err2 != nil {
+		return true
+	}
+
+	return m > major || m == major && n >= minor
+}
+
+// quantizationAdvisories returns warnings for a model of fileType that runs
+// slowly on gpus and cpu of architecture arch, with vram of its total memory
+// on the GPUs
+func quantizationAdvisories(fileType string, gpus discover.GpuInfoList, arch string, cpu runners.CPUCapability, vram, total uint64) []api.Advisory {
+	var advisories []api.Advisory
+
+	onGPU := len(gpus) > 0 && gpus[0].Library != "cpu" && vram > 0
+	onCPU := !onGPU || vram < total
+
+	// only x86 CPUs lack the K-quant fast path without AVX2; others, such
+	// as arm64 with NEON, report no capability
+	if isKQuant(fileType) && onCPU && arch == "amd64" && cpu != runners.CPUCapabilityAVX2 {
+		advisories = append(advisories, api.Advisory{
+			Code:    advisoryKQuantCPU,
+			Message: fmt.Sprintf("%s is a K-quant, which has no fast path on CPUs without AVX2; Q4_0 or Q8_0 run faster on this CPU", fileType),
+		})
+	}
+
+	if isKQuant(fileType) && onGPU {
+		for _, gpu := range gpus {
+			if gpu.Library == "cuda" && !computeAtLeast(gpu.Compute, 6, 1) {
+				advisories = append(advisories, api.Advisory{
+					Code:    advisoryKQuantGPU,
+					Message: fmt.Sprintf("%s is a K-quant, whose CUDA kernels need compute capability 6.1 for their fast path but %s has %s; Q4_0 or Q8_0 run faster on this GPU", fileType, gpu.Name, gpu.Compute),
+				})
+				break
+			}
+		}
+	}
+
+	if isFloat(fileType) && onGPU && vram*2 < total {
+		advisories = append(advisories, api.Advisory{
+			Code:    advisoryFloatSpill,
+			Message: fmt.Sprintf("only %d%% of this %s model fits in VRAM, so most of it runs on the CPU; a quantized variant fits more of it on the GPU", vram*100/total, fileType),
+		})
+	}
+
+	return advisories
+}
+
+// localVariant returns the local model with the same name as n but another
+// tag that best fits the advisory, or "" if none is pulled. The variant of
+// a float model has to fit into vram.
+func localVariant(n model.Name, advisory string, vram uint64) string {
+	ms, err := Manifests(true)
+	if err != nil {
+		slog.Debug("couldn't list local models", "error", err)
+		return ""
+	}
+
+	var best string
+	var bestSize int64
+	for name, m := range ms {
+		if !strings.EqualFold(name.Host, n.Host) || !strings.EqualFold(name.Namespace, n.Namespace) || !strings.EqualFold(name.Model, n.Model) || strings.EqualFold(name.Tag, n.Tag) {
+			continue
+		}
+
+		var cf ConfigV2
+		if m.Config.Digest != "" {
+			f, err := m.Config.Open()
+			if err != nil {
+				continue
+			}
+
+			err = json.NewDecoder(f).Decode(&cf)
+			f.Close()
+			if err != nil {
+				continue
+			}
+		}
+
+		switch advisory {
+		case advisoryKQuantCPU, advisoryKQuantGPU:
+			if !slices.Contains(legacyQuants, cf.FileType) {
+				continue
+			}
+		case advisoryFloatSpill:
+			if cf.FileType == "" || isFloat(cf.FileType) || uint64(m.Size()) > vram {
+				continue
+			}
+		}
+
+		// the largest variant is the most accurate
+		if m.Size() > bestSize {
+			best, bestSize = name.DisplayShortest(), m.Size()
+		}
+	}
+
+	return best
+}
+
+// adviseQuantization sets the advisories of a runner that was just loaded,
+// suggesting a local variant of its model for each
+func (runner *runnerRef) adviseQuantization() {
+	runner.advisories = quantizationAdvisories(runner.model.Config.FileType, runner.gpus, runtime.GOARCH, runners.GetCPUCapability(), runner.estimatedVRAM, runner.estimatedTotal)
+	for i, a := range runner.advisories {
+		runner.advisories[i].Suggestion = localVariant(model.ParseName(runner.model.Name), a.Code, runner.estimatedVRAM)
+		slog.Warn("model is quantized suboptimally for this hardware", "model", runner.modelPath, "advisory", a.Message, "suggestion", runner.advisories[i].Suggestion)
+	}
+}
+
+// takeAdvisories returns the advisories of the loaded runner for a model
+// the first time they're asked for, so they're attached to the first
+// response after it's loaded
+func (s *Scheduler) takeAdvisories(model *Model) []api.Advisory {
+	s.loadedMu.Lock()
+	runner := s.loaded[model.ModelPath]
+	s.loadedMu.Unlock()
+	if runner == nil {
+		return nil
+	}
+
+	runner.refMu.Lock()
+	defer runner.refMu.Unlock()
+	if runner.advised {
+		return nil
+	}
+
+	runner.advised = true
+	return runner.advisories
+}
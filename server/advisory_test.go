@@ -0,0 +1,115 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/discover"
+	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/runners"
+	"github.com/ollama/ollama/types/model"
+)
+
+func TestQuantizationAdvisories(t *testing.T) {
+	cpu := discover.GpuInfoList{{Library: "cpu"}}
+	pascal := discover.GpuInfoList{{Library: "cuda", Name: "GTX 1080", Compute: "6.1"}}
+	maxwell := discover.GpuInfoList{{Library: "cuda", Name: "GTX 980", Compute: "5.2"}}
+
+	cases := []struct {
+		name       string
+		fileType   string
+		gpus       discover.GpuInfoList
+		arch       string
+		cpu        runners.CPUCapability
+		vram       uint64
+		total      uint64
+		advisories []string
+	}{
+		{"k-quant on avx2", "Q4_K_M", cpu, "amd64", runners.CPUCapabilityAVX2, 0, 100, nil},
+		{"k-quant on avx", "Q4_K_M", cpu, "amd64", runners.CPUCapabilityAVX, 0, 100, []string{advisoryKQuantCPU}},
+		{"legacy quant on avx", "Q4_0", cpu, "amd64", runners.CPUCapabilityAVX, 0, 100, nil},
+		{"k-quant fully offloaded", "Q4_K_M", pascal, "amd64", runners.CPUCapabilityAVX, 100, 100, nil},
+		{"k-quant partly offloaded", "Q4_K_M", pascal, "amd64", runners.CPUCapabilityAVX, 50, 100, []string{advisoryKQuantCPU}},
+		{"k-quant on old cuda", "Q6_K", maxwell, "amd64", runners.CPUCapabilityAVX2, 100, 100, []string{advisoryKQuantGPU}},
+		{"f16 spilling", "F16", pascal, "amd64", runners.CPUCapabilityAVX2, 30, 100, []string{advisoryFloatSpill}},
+		{"f16 mostly offloaded", "F16", pascal, "amd64", runners.CPUCapabilityAVX2, 80, 100, nil},
+		{"f16 on cpu", "F16", cpu, "amd64", runners.CPUCapabilityAVX2, 0, 100, nil},
+		{"k-quant on arm64", "Q4_K_M", cpu, "arm64", runners.CPUCapabilityNone, 0, 100, nil},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			var codes []string
+			for _, a := range quantizationAdvisories(tt.fileType, tt.gpus, tt.arch, tt.cpu, tt.vram, tt.total) {
+				codes = append(codes, a.Code)
+			}
+
+			if diff := cmp.Diff(tt.advisories, codes); diff != "" {
+				t.Errorf("advisories mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestLocalVariant(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	var s Server
+	for name, fileType := range map[string]uint32{"test:q4_k_m": 15, "test:q4_0": 2, "test:f16": 1, "other:q8_0": 7} {
+		_, digest := createBinFile(t, llm.KV{
+			"general.architecture": "llama",
+			"general.file_type":    fileType,
+		}, []llm.Tensor{
+			{Name: "token_embd.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		})
+
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:  name,
+			Files:  map[string]string{"file.gguf": digest},
+			Stream: &stream,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+	}
+
+	if got := localVariant(model.ParseName("test:q4_k_m"), advisoryKQuantCPU, 0); got != "test:q4_0" {
+		t.Errorf("expected test:q4_0, got %q", got)
+	}
+
+	if got := localVariant(model.ParseName("test:f16"), advisoryFloatSpill, 1<<30); got != "test:q4_k_m" && got != "test:q4_0" {
+		t.Errorf("expected a quantized variant of test, got %q", got)
+	}
+
+	if got := localVariant(model.ParseName("test:f16"), advisoryFloatSpill, 0); got != "" {
+		t.Errorf("expected no variant to fit, got %q", got)
+	}
+
+	if got := localVariant(model.ParseName("other:q4_k_m"), advisoryKQuantGPU, 0); got != "other:q8_0" {
+		t.Errorf("expected other:q8_0, got %q", got)
+	}
+}
+
+func TestTakeAdvisories(t *testing.T) {
+	advisories := []api.Advisory{{Code: advisoryKQuantCPU, Message: "slow"}}
+	s := Scheduler{loaded: map[string]*runnerRef{"test": {advisories: advisories}}}
+	m := &Model{ModelPath: "test"}
+
+	if diff := cmp.Diff(advisories, s.takeAdvisories(m)); diff != "" {
+		t.Errorf("advisories mismatch (-want +got):\n%s", diff)
+	}
+
+	if got := s.takeAdvisories(m); got != nil {
+		t.Errorf("expected advisories only on the first response, got %v", got)
+	}
+
+	if got := s.takeAdvisories(&Model{ModelPath: "unloaded"}); got != nil {
+		t.Errorf("expected no advisories for an unloaded model, got %v", got)
+	}
+}
@@ -0,0 +1,326 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
+)
+
+// defaultAgentSteps is the number of model turns an agent gets if the request
+// doesn't set one
+const defaultAgentSteps = 10
+
+// agentToolTimeout bounds a single tool call
+const agentToolTimeout = time.Minute
+
+// maxToolResult is the largest tool result in bytes passed back to the model
+const maxToolResult = 1 << 20
+
+// agentTool is a tool registered in OLLAMA_AGENT_TOOLS. Calls to the tool are
+// sent to URL as a POST of the arguments as a JSON object, and the response
+// body is the result, unless the tool was listed by an MCP server.
+type agentTool struct {
+	api.Tool
+	URL string `json:"url"`
+
+	// mcp is the session with the MCP server the tool is called with, or
+	// nil for a tool with a URL
+	mcp *mcpClient
+}
+
+// readAgentTools reads the tools registered with OLLAMA_AGENT_TOOLS by name,
+// including those listed by its MCP servers
+func readAgentTools(ctx context.Context) (map[string]agentTool, error) {
+	path := envconfig.AgentTools()
+	if path == "" {
+		return nil, nil
+	}
+
+	bts, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("agent tools: %w", err)
+	}
+
+	var f struct {
+		Tools      []agentTool `json:"tools"`
+		MCPServers []mcpServer `json:"mcp_servers"`
+	}
+	if err := json.Unmarshal(bts, &f); err != nil {
+		return nil, fmt.Errorf("agent tools %s: %w", path, err)
+	}
+
+	tools := make(map[string]agentTool, len(f.Tools))
+	for _, t := range f.Tools {
+		if t.Function.Name == "" || t.URL == "" {
+			return nil, fmt.Errorf("agent tools %s: tools need a name and url", path)
+		}
+
+		if t.Type == "" {
+			t.Type = "function"
+		}
+
+		tools[t.Function.Name] = t
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, agentToolTimeout)
+	defer cancel()
+
+	for _, srv := range f.MCPServers {
+		if srv.URL == "" {
+			return nil, fmt.Errorf("agent tools %s: mcp servers need a url", path)
+		}
+
+		c, err := srv.connect(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		listed, err := c.tools(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, t := range listed {
+			if _, ok := tools[t.Function.Name]; ok {
+				return nil, fmt.Errorf("agent tools %s: tool %q is registered twice", path, t.Function.Name)
+			}
+
+			tools[t.Function.Name] = agentTool{Tool: t, mcp: c}
+		}
+	}
+
+	return tools, nil
+}
+
+// call sends args to the tool and returns its result
+func (t agentTool) call(ctx context.Context, args api.ToolCallFunctionArguments) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, agentToolTimeout)
+	defer cancel()
+
+	if t.mcp != nil {
+		return t.mcp.call(ctx, t.Function.Name, args)
+	}
+
+	bts, err := json.Marshal(args)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.URL, bytes.NewReader(bts))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	result, err := io.ReadAll(io.LimitReader(resp.Body, maxToolResult))
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return "", fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(result))
+	}
+
+	return string(result), nil
+}
+
+// AgentHandler runs a model in a loop that calls registered tools and passes
+// their results back to the model, until the model answers without calling a
+// tool or runs out of steps, tokens or time
+func (s *Server) AgentHandler(c *gin.Context) {
+	checkpointStart := time.Now()
+
+	var req api.AgentRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if len(req.Messages) == 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "messages are required"})
+		return
+	}
+
+	m, err := GetModel(req.Model)
+	if err != nil {
+		handleScheduleError(c, req.Model, err)
+		return
+	}
+
+	registered, err := readAgentTools(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	names := req.Tools
+	if len(names) == 0 {
+		names = slices.Sorted(maps.Keys(registered))
+	}
+
+	tools := make(map[string]agentTool, len(names))
+	var defs []api.Tool
+	for _, name := range names {
+		t, ok := registered[name]
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("tool %q is not registered", name)})
+			return
+		}
+
+		tools[name] = t
+		defs = append(defs, t.Tool)
+	}
+
+	maxSteps := req.MaxSteps
+	if maxSteps <= 0 {
+		maxSteps = defaultAgentSteps
+	}
+
+	ctx := c.Request.Context()
+	if req.Timeout != nil && req.Timeout.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, req.Timeout.Duration)
+		defer cancel()
+	}
+
+	ch := make(chan any)
+	go func() {
+		defer close(ch)
+
+		msgs := req.Messages
+		done := api.AgentEvent{Model: req.Model, Type: "done", Done: true}
+		event := func(e api.AgentEvent) {
+			e.Model = req.Model
+			e.CreatedAt = time.Now().UTC()
+			ch <- e
+		}
+
+		for step := 1; ; step++ {
+			done.Step = step - 1
+			if step > maxSteps {
+				done.DoneReason = "max_steps"
+				break
+			}
+
+			options := req.Options
+			if req.MaxTokens > 0 {
+				remaining := req.MaxTokens - done.EvalCount
+				if remaining <= 0 {
+					done.DoneReason = "max_tokens"
+					break
+				}
+
+				options = maps.Clone(options)
+				if options == nil {
+					options = map[string]any{}
+				}
+
+				if n, ok := options["num_predict"].(float64); !ok || n < 0 || int(n) > remaining {
+					options["num_predict"] = float64(remaining)
+				}
+			}
+
+			cr, err := s.chatCompletion(ctx, req.Model, msgs, defs, nil, options, req.KeepAlive)
+			if errors.Is(err, context.DeadlineExceeded) {
+				done.DoneReason = "timeout"
+				break
+			} else if err != nil {
+				ch <- errorResponse(err)
+				return
+			}
+
+			done.PromptEvalCount += cr.PromptEvalCount
+			done.PromptEvalDuration += cr.PromptEvalDuration
+			done.EvalCount += cr.EvalCount
+			done.EvalDuration += cr.EvalDuration
+			done.DraftCount += cr.DraftCount
+			done.DraftAcceptedCount += cr.DraftAcceptedCount
+
+			msg := api.Message{Role: "assistant", Content: cr.Content}
+			if toolCalls, ok := m.parseToolCalls(cr.Content); ok {
+				msg = api.Message{Role: "assistant", ToolCalls: toolCalls}
+			}
+
+			msgs = append(msgs, msg)
+			done.Messages = append(done.Messages, msg)
+			event(api.AgentEvent{Type: "message", Step: step, Message: &msg})
+
+			if len(msg.ToolCalls) == 0 {
+				done.Step = step
+				done.DoneReason = "stop"
+				break
+			}
+
+			for _, tc := range msg.ToolCalls {
+				result := api.Message{Role: "tool"}
+				if t, ok := tools[tc.Function.Name]; !ok {
+					result.Content = fmt.Sprintf("error: tool %q doesn't exist", tc.Function.Name)
+				} else if out, err := t.call(ctx, tc.Function.Arguments); err != nil {
+					slog.Debug("agent tool call failed", "tool", tc.Function.Name, "error", err)
+					result.Content = "error: " + err.Error()
+				} else {
+					result.Content = out
+				}
+
+				msgs = append(msgs, result)
+				done.Messages = append(done.Messages, result)
+				event(api.AgentEvent{Type: "tool", Step: step, Message: &result})
+			}
+
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				done.Step = step
+				done.DoneReason = "timeout"
+				break
+			}
+		}
+
+		done.TotalDuration = time.Since(checkpointStart)
+		event(done)
+	}()
+
+	if req.Stream != nil && !*req.Stream {
+		var resp api.AgentEvent
+		for r := range ch {
+			switch t := r.(type) {
+			case api.AgentEvent:
+				resp = t
+			case gin.H:
+				msg, ok := t["error"].(string)
+				if !ok {
+					msg = "unexpected error format in response"
+				}
+
+				c.JSON(http.StatusInternalServerError, errorBody(errorCodeOf(t), msg))
+				return
+			}
+		}
+
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	streamResponse(c, ch)
+}
@@ -0,0 +1,275 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+)
+
+func TestAgent(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var toolArgs string
+	tool := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bts, _ := io.ReadAll(r.Body)
+		toolArgs = string(bts)
+		io.WriteString(w, "sunny")
+	}))
+	defer tool.Close()
+
+	p := filepath.Join(t.TempDir(), "tools.json")
+	if err := os.WriteFile(p, []byte(`{"tools": [
+		{"function": {"name": "get_weather", "description": "Get the weather"}, "url": "`+tool.URL+`"},
+		{"function": {"name": "get_time", "description": "Get the time"}, "url": "`+tool.URL+`"}
+	]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("OLLAMA_AGENT_TOOLS", p)
+
+	// the model calls a tool until it has a result, and always calls a tool
+	// if its prompt asks it to loop
+	mock := mockRunner{
+		CompletionFn: func(_ context.Context, r llm.CompletionRequest, fn func(llm.CompletionResponse)) error {
+			resp := llm.CompletionResponse{Done: true, EvalCount: 10}
+			if strings.Contains(r.Prompt, "tool: sunny") && !strings.Contains(r.Prompt, "loop") {
+				resp.Content = "It's sunny in Paris."
+			} else {
+				resp.Content = `{"name": "get_weather", "arguments": {"city": "Paris"}}`
+			}
+
+			fn(resp)
+			return nil
+		},
+	}
+
+	s := Server{sched: newMockScheduler(t, &mock)}
+	createMockModel(t, &s, "test", `
+{{- if .Tools }}{{ .Tools }}
+{{ end }}
+{{- range .Messages }}{{ .Role }}: {{ .Content }}
+{{- range .ToolCalls }}{"name": "{{ .Function.Name }}", "arguments": {{ .Function.Arguments }}}
+{{- end }}
+{{ end }}`)
+
+	run := func(t *testing.T, req api.AgentRequest) api.AgentEvent {
+		t.Helper()
+		w := createRequest(t, s.AgentHandler, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+
+		var events []api.AgentEvent
+		for dec := json.NewDecoder(w.Body); ; {
+			var e api.AgentEvent
+			if err := dec.Decode(&e); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			events = append(events, e)
+		}
+
+		if len(events) == 0 || !events[len(events)-1].Done {
+			t.Fatalf("expected a final event, got %v", events)
+		}
+
+		return events[len(events)-1]
+	}
+
+	weather := api.ToolCall{Function: api.ToolCallFunction{Name: "get_weather", Arguments: api.ToolCallFunctionArguments{"city": "Paris"}}}
+
+	t.Run("stop", func(t *testing.T) {
+		done := run(t, api.AgentRequest{
+			Model:    "test",
+			Messages: []api.Message{{Role: "user", Content: "What's the weather in Paris?"}},
+		})
+
+		want := []api.Message{
+			{Role: "assistant", ToolCalls: []api.ToolCall{weather}},
+			{Role: "tool", Content: "sunny"},
+			{Role: "assistant", Content: "It's sunny in Paris."},
+		}
+		if diff := cmp.Diff(done.Messages, want); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+
+		if done.DoneReason != "stop" || done.Step != 2 || done.EvalCount != 20 {
+			t.Errorf("expected stop after 2 steps and 20 tokens, got %q after %d steps and %d tokens", done.DoneReason, done.Step, done.EvalCount)
+		}
+
+		if toolArgs != `{"city":"Paris"}` {
+			t.Errorf("unexpected tool arguments %s", toolArgs)
+		}
+
+		if !strings.Contains(mock.CompletionRequest.Prompt, "get_time") {
+			t.Errorf("expected all registered tools in the prompt, got %q", mock.CompletionRequest.Prompt)
+		}
+	})
+
+	t.Run("max steps", func(t *testing.T) {
+		done := run(t, api.AgentRequest{
+			Model:    "test",
+			Messages: []api.Message{{Role: "user", Content: "loop"}},
+			Tools:    []string{"get_weather"},
+			MaxSteps: 3,
+		})
+
+		if done.DoneReason != "max_steps" || done.Step != 3 || len(done.Messages) != 6 {
+			t.Errorf("expected max_steps after 3 steps and 6 messages, got %q after %d steps and %d messages", done.DoneReason, done.Step, len(done.Messages))
+		}
+
+		if strings.Contains(mock.CompletionRequest.Prompt, "get_time") {
+			t.Errorf("expected only requested tools in the prompt, got %q", mock.CompletionRequest.Prompt)
+		}
+	})
+
+	t.Run("max tokens", func(t *testing.T) {
+		done := run(t, api.AgentRequest{
+			Model:     "test",
+			Messages:  []api.Message{{Role: "user", Content: "loop"}},
+			MaxTokens: 25,
+		})
+
+		if done.DoneReason != "max_tokens" || done.Step != 3 {
+			t.Errorf("expected max_tokens after 3 steps, got %q after %d steps", done.DoneReason, done.Step)
+		}
+
+		if mock.CompletionRequest.Options.NumPredict != 5 {
+			t.Errorf("expected the last step to predict the remaining 5 tokens, got %d", mock.CompletionRequest.Options.NumPredict)
+		}
+	})
+
+	t.Run("unknown tool", func(t *testing.T) {
+		w := createRequest(t, s.AgentHandler, api.AgentRequest{
+			Model:    "test",
+			Messages: []api.Message{{Role: "user", Content: "Hello!"}},
+			Tools:    []string{"rm"},
+		})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", w.Code)
+		}
+	})
+
+	t.Run("missing model", func(t *testing.T) {
+		w := createRequest(t, s.AgentHandler, api.AgentRequest{
+			Model:    "missing",
+			Messages: []api.Message{{Role: "user", Content: "Hello!"}},
+		})
+		if w.Code != http.StatusNotFound {
+			t.Errorf("expected status 404, got %d", w.Code)
+		}
+	})
+}
+
+func TestAgentMCP(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var calls []string
+	mcp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg struct {
+			ID     *int            `json:"id"`
+			Method string          `json:"method"`
+			Params json.RawMessage `json:"params"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+			t.Error(err)
+			return
+		}
+
+		if msg.Method != "initialize" && r.Header.Get("Mcp-Session-Id") != "session" {
+			t.Errorf("expected the session of %s, got %q", msg.Method, r.Header.Get("Mcp-Session-Id"))
+		}
+
+		var result string
+		switch msg.Method {
+		case "initialize":
+			w.Header().Set("Mcp-Session-Id", "session")
+			result = `{"protocolVersion": "2025-03-26", "capabilities": {"tools": {}}, "serverInfo": {"name": "weather"}}`
+		case "notifications/initialized":
+			w.WriteHeader(http.StatusAccepted)
+			return
+		case "tools/list":
+			result = `{"tools": [{"name": "get_weather", "description": "Get the weather", "inputSchema": {"type": "object", "properties": {"city": {"type": "string"}}, "required": ["city"]}}]}`
+		case "tools/call":
+			calls = append(calls, string(msg.Params))
+
+			// tool results can be streamed after the server's own requests
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprintf(w, "data: {\"jsonrpc\": \"2.0\", \"method\": \"notifications/progress\"}\n\n")
+			fmt.Fprintf(w, "data: {\"jsonrpc\": \"2.0\", \"id\": %d, \"result\": {\"content\": [{\"type\": \"text\", \"text\": \"sunny\"}]}}\n\n", *msg.ID)
+			return
+		default:
+			t.Errorf("unexpected method %s", msg.Method)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"jsonrpc": "2.0", "id": %d, "result": %s}`, *msg.ID, result)
+	}))
+	defer mcp.Close()
+
+	p := filepath.Join(t.TempDir(), "tools.json")
+	if err := os.WriteFile(p, []byte(`{"mcp_servers": [{"url": "`+mcp.URL+`"}]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("OLLAMA_AGENT_TOOLS", p)
+
+	mock := mockRunner{
+		CompletionFn: func(_ context.Context, r llm.CompletionRequest, fn func(llm.CompletionResponse)) error {
+			resp := llm.CompletionResponse{Done: true}
+			if strings.Contains(r.Prompt, "tool: sunny") {
+				resp.Content = "It's sunny in Paris."
+			} else {
+				resp.Content = `{"name": "get_weather", "arguments": {"city": "Paris"}}`
+			}
+
+			fn(resp)
+			return nil
+		},
+	}
+
+	s := Server{sched: newMockScheduler(t, &mock)}
+	createMockModel(t, &s, "test", `
+{{- if .Tools }}{{ .Tools }}
+{{ end }}
+{{- range .Messages }}{{ .Role }}: {{ .Content }}
+{{- range .ToolCalls }}{"name": "{{ .Function.Name }}", "arguments": {{ .Function.Arguments }}}
+{{- end }}
+{{ end }}`)
+
+	stream := false
+	w := createRequest(t, s.AgentHandler, api.AgentRequest{
+		Model:    "test",
+		Messages: []api.Message{{Role: "user", Content: "What's the weather in Paris?"}},
+		Stream:   &stream,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+	}
+
+	var done api.AgentEvent
+	if err := json.NewDecoder(w.Body).Decode(&done); err != nil {
+		t.Fatal(err)
+	}
+
+	if done.DoneReason != "stop" || len(done.Messages) != 3 || done.Messages[1].Content != "sunny" {
+		t.Errorf("expected the tool's result to be passed back, got %q with %v", done.DoneReason, done.Messages)
+	}
+
+	if diff := cmp.Diff([]string{`{"arguments":{"city":"Paris"},"name":"get_weather"}`}, calls); diff != "" {
+		t.Errorf("tool calls mismatch (-want +got):\n%s", diff)
+	}
+}
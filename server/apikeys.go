@@ -0,0 +1,318 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/openai"
+)
+
+// apiScope is what requests with an API key may do
+type apiScope string
+
+const (
+	// scopePull allows pulling models and accepting their licenses
+	scopePull apiScope = "pull"
+	// scopeGenerate allows generating with models, and the endpoints that
+	// go with it such as tokenizing and sessions
+	scopeGenerate apiScope = "generate"
+	// scopeAdmin allows everything, including creating, pushing and
+	// deleting models
+	scopeAdmin apiScope = "admin"
+)
+
+// publicRoutes don't require an API key, so health checks work without one
+var publicRoutes = []string{"/", "/api/version", "/api/ready"}
+
+// routeScopes are the scopes required by each route. Routes that aren't
+// listed require scopeAdmin, and routes with an empty scope require any key.
+var routeScopes = map[string]apiScope{
+	"/api/tags":              "",
+	"/api/show":              "",
+	"/api/ps":                "",
+	"/v1/models":             "",
+	"/v1/models/:model":      "",
+	"/api/pull":              scopePull,
+	"/api/license/accept":    scopePull,
+	"/api/generate":          scopeGenerate,
+	"/api/chat":              scopeGenerate,
+	"/api/ensemble":          scopeGenerate,
+	"/api/batch":             scopeGenerate,
+	"/api/agent":             scopeGenerate,
+	"/api/embed":             scopeGenerate,
+	"/api/embeddings":        scopeGenerate,
+	"/api/rerank":            scopeGenerate,
+	"/api/split":             scopeGenerate,
+	"/api/tokenize":          scopeGenerate,
+	"/api/detokenize":        scopeGenerate,
+	"/api/tokenizer/check":   scopeGenerate,
+	"/api/watermark":         scopeGenerate,
+	"/api/sessions":          scopeGenerate,
+	"/api/sessions/:id":      scopeGenerate,
+	"/api/sessions/:id/fork": scopeGenerate,
+	"/api/memories/:key":     scopeGenerate,
+	"/api/memories/:key/:id": scopeGenerate,
+	"/api/recommend":         scopeGenerate,
+	"/api/plan":              scopeGenerate,
+	"/api/profiles/:id":      scopeGenerate,
+	"/api/usage":             "",
+	"/v1/chat/completions":   scopeGenerate,
+	"/v1/completions":        scopeGenerate,
+	"/v1/embeddings":         scopeGenerate,
+}
+
+// routeScope returns the scope required by a route, named by its pattern.
+// Models served as a registry cache are pulled by other servers.
+func routeScope(route string) apiScope {
+	if scope, ok := routeScopes[route]; ok {
+		return scope
+	}
+
+	if strings.HasPrefix(route, "/v2/") {
+		return scopePull
+	}
+
+	return scopeAdmin
+}
+
+// apiKey is an entry of the API keys file. Key is the key itself, or the
+// hex encoded SHA-256 digest of it prefixed with "sha256:" so the file
+// doesn't have to hold the key.
+type apiKey struct {
+	Name   string     `json:"name,omitempty"`
+	Key    string     `json:"key"`
+	Scopes []apiScope `json:"scopes"`
+}
+
+func (k apiKey) allows(scope apiScope) bool {
+	return scope == "" || slices.Contains(k.Scopes, scope) || slices.Contains(k.Scopes, scopeAdmin)
+}
+
+// apiKeyDigest returns the hex encoded SHA-256 digest keys are looked up by
+func apiKeyDigest(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// parseAPIKeys parses a JSON list of API keys into a map of their digests
+func parseAPIKeys(bts []byte) (map[string]apiKey, error) {
+	var entries []apiKey
+	if err := json.Unmarshal(bts, &entries); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]apiKey, len(entries))
+	for i, k := range entries {
+		if k.Key == "" {
+			return nil, fmt.Errorf("key %d: missing key", i)
+		}
+
+		for _, scope := range k.Scopes {
+			if !slices.Contains([]apiScope{scopePull, scopeGenerate, scopeAdmin}, scope) {
+				return nil, fmt.Errorf("key %d: unknown scope %q", i, scope)
+			}
+		}
+
+		digest := apiKeyDigest(k.Key)
+		if hexDigest, ok := strings.CutPrefix(k.Key, "sha256:"); ok {
+			if b, err := hex.DecodeString(hexDigest); err != nil || len(b) != sha256.Size {
+				return nil, fmt.Errorf("key %d: invalid digest %q", i, k.Key)
+			}
+
+			digest = strings.ToLower(hexDigest)
+		}
+
+		k.Key = ""
+		keys[digest] = k
+	}
+
+	return keys, nil
+}
+
+// apiKeys are the keys requests must send if any are configured. Keys set
+// in the environment have every scope. The keys file is read again when it
+// changes, so keys can be added and revoked without restarting the server.
+type apiKeys struct {
+	static map[string]apiKey
+	path   string
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	file    map[string]apiKey
+}
+
+type listenerKeysKey struct{}
+
+// withListenerKeys returns a context of a request to a listener whose
+// clients must present one of keys, in addition to the keys configured for
+// every listener
+func withListenerKeys(ctx context.Context, keys map[string]apiKey) context.Context {
+	return context.WithValue(ctx, listenerKeysKey{}, keys)
+}
+
+// listenerKeys returns the keys of the listener of ctx's request, or nil if
+// it doesn't have its own
+func listenerKeys(ctx context.Context) map[string]apiKey {
+	keys, _ := ctx.Value(listenerKeysKey{}).(map[string]apiKey)
+	return keys
+}
+
+// loadAPIKeys returns the keys configured with OLLAMA_API_KEYS and
+// OLLAMA_API_KEYS_FILE, or nil if there are none
+func loadAPIKeys() *apiKeys {
+	keys := apiKeys{static: make(map[string]apiKey), path: envconfig.APIKeysFile()}
+	for _, key := range strings.Split(envconfig.APIKeys(), ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys.static[apiKeyDigest(key)] = apiKey{Scopes: []apiScope{scopeAdmin}}
+		}
+	}
+
+	if len(keys.static) == 0 && keys.path == "" {
+		return nil
+	}
+
+	return &keys
+}
+
+// lookup returns the entry of key, reading the keys file again if it has
+// changed. A file that can't be read keeps the keys read last, or none.
+func (k *apiKeys) lookup(key string) (apiKey, bool) {
+	digest := apiKeyDigest(key)
+	if entry, ok := k.static[digest]; ok {
+		return entry, true
+	}
+
+	if k.path == "" {
+		return apiKey{}, false
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if fi, err := os.Stat(k.path); err != nil {
+		slog.Warn("couldn't read API keys file", "path", k.path, "error", err)
+	} else if !fi.ModTime().Equal(k.modTime) || fi.Size() != k.size {
+		bts, err := os.ReadFile(k.path)
+		if err == nil {
+			var keys map[string]apiKey
+			keys, err = parseAPIKeys(bts)
+			if err == nil {
+				k.file, k.modTime, k.size = keys, fi.ModTime(), fi.Size()
+			}
+		}
+
+		if err != nil {
+			slog.Warn("couldn't read API keys file", "path", k.path, "error", err)
+		}
+	}
+
+	entry, ok := k.file[digest]
+	return entry, ok
+}
+
+var errMissingAPIKey = errors.New("missing or invalid API key")
+
+// apiKeyContextKey is the context key of the requestKey of a request with a
+// valid API key
+const apiKeyContextKey = "apiKey"
+
+// requestKey is the valid API key of a request, and its digest
+type requestKey struct {
+	apiKey
+	digest string
+}
+
+// keyOf returns the API key of a request, if API keys are required and it
+// has a valid one
+func keyOf(c *gin.Context) (requestKey, bool) {
+	v, ok := c.Get(apiKeyContextKey)
+	if !ok {
+		return requestKey{}, false
+	}
+
+	k, ok := v.(requestKey)
+	return k, ok
+}
+
+// ownerOf returns the owner of the sessions, memories and profiles a request
+// creates, which is the digest of its API key. It's empty if API keys aren't
+// required or the key has the admin scope.
+func ownerOf(c *gin.Context) string {
+	k, ok := keyOf(c)
+	if !ok || k.allows(scopeAdmin) {
+		return ""
+	}
+
+	return k.digest
+}
+
+// owns reports whether a request whose owner is requester may use what owner
+// created. Requests without an owner may use everything.
+func owns(requester, owner string) bool {
+	return requester == "" || requester == owner
+}
+
+// apiKeysMiddleware rejects requests without a valid API key in their
+// Authorization header with 401 Unauthorized, and requests with a key
+// without the scope of their route with 403 Forbidden. Keys are required if
+// keys is set or the request's listener has its own, and either may be
+// presented. Requests to the OpenAI compatible endpoints receive OpenAI
+// formatted errors.
+func apiKeysMiddleware(keys *apiKeys) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		listener := listenerKeys(c.Request.Context())
+		route := c.FullPath()
+		if (keys == nil && listener == nil) || slices.Contains(publicRoutes, route) {
+			c.Next()
+			return
+		}
+
+		abort := func(code int, msg string) {
+			if strings.HasPrefix(c.Request.URL.Path, "/v1/") {
+				c.AbortWithStatusJSON(code, openai.NewError(code, msg))
+			} else {
+				c.AbortWithStatusJSON(code, gin.H{"error": msg})
+			}
+		}
+
+		var entry apiKey
+		var digest string
+		var ok bool
+		if key, found := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); found && key != "" {
+			digest = apiKeyDigest(key)
+			entry, ok = listener[digest]
+			if !ok && keys != nil {
+				entry, ok = keys.lookup(key)
+			}
+		}
+
+		if !ok {
+			c.Header("WWW-Authenticate", "Bearer")
+			abort(http.StatusUnauthorized, errMissingAPIKey.Error())
+			return
+		}
+
+		if scope := routeScope(route); !entry.allows(scope) {
+			abort(http.StatusForbidden, fmt.Sprintf("API key doesn't have the %s scope", scope))
+			return
+		}
+
+		c.Set(apiKeyContextKey, requestKey{apiKey: entry, digest: digest})
+		c.Next()
+	}
+}
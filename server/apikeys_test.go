@@ -0,0 +1,232 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+)
+
+func TestParseAPIKeys(t *testing.T) {
+	digest := apiKeyDigest("secret")
+	cases := []struct {
+		in   string
+		want map[string]apiKey
+		err  bool
+	}{
+		{in: `[]`, want: map[string]apiKey{}},
+		{
+			in: `[{"name": "ci", "key": "secret", "scopes": ["generate"]}, {"key": "sha256:` + strings.ToUpper(apiKeyDigest("other")) + `", "scopes": ["pull", "admin"]}]`,
+			want: map[string]apiKey{
+				digest:                {Name: "ci", Scopes: []apiScope{scopeGenerate}},
+				apiKeyDigest("other"): {Scopes: []apiScope{scopePull, scopeAdmin}},
+			},
+		},
+		{in: `[{"scopes": ["generate"]}]`, err: true},
+		{in: `[{"key": "secret", "scopes": ["push"]}]`, err: true},
+		{in: `[{"key": "sha256:1234", "scopes": ["pull"]}]`, err: true},
+		{in: `{"key": "secret"}`, err: true},
+	}
+
+	for _, tt := range cases {
+		got, err := parseAPIKeys([]byte(tt.in))
+		if tt.err != (err != nil) {
+			t.Fatalf("%s: unexpected error %v", tt.in, err)
+		}
+
+		if tt.err {
+			continue
+		}
+
+		if len(got) != len(tt.want) {
+			t.Fatalf("%s: expected %v, got %v", tt.in, tt.want, got)
+		}
+
+		for digest, want := range tt.want {
+			if got := got[digest]; got.Name != want.Name || !slices.Equal(got.Scopes, want.Scopes) {
+				t.Errorf("%s: expected %+v, got %+v", tt.in, want, got)
+			}
+		}
+	}
+}
+
+func TestAPIKeysMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	path := filepath.Join(t.TempDir(), "keys.json")
+	if err := os.WriteFile(path, []byte(`[
+		{"name": "puller", "key": "pull-key", "scopes": ["pull"]},
+		{"name": "app", "key": "generate-key", "scopes": ["generate"]}
+	]`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("OLLAMA_API_KEYS", "admin-key")
+	t.Setenv("OLLAMA_API_KEYS_FILE", path)
+
+	var s Server
+	srv := httptest.NewServer(s.GenerateRoutes())
+	defer srv.Close()
+
+	do := func(method, path, key string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(`{}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	cases := []struct {
+		method, path, key string
+		want              int
+	}{
+		{http.MethodGet, "/api/version", "", http.StatusOK},
+		{http.MethodGet, "/api/tags", "", http.StatusUnauthorized},
+		{http.MethodGet, "/api/tags", "wrong-key", http.StatusUnauthorized},
+		{http.MethodPost, "/v1/chat/completions", "", http.StatusUnauthorized},
+		{http.MethodGet, "/api/tags", "pull-key", http.StatusOK},
+		{http.MethodPost, "/api/chat", "pull-key", http.StatusForbidden},
+		{http.MethodPost, "/v1/embeddings", "pull-key", http.StatusForbidden},
+		{http.MethodPost, "/api/pull", "generate-key", http.StatusForbidden},
+		{http.MethodDelete, "/api/delete", "generate-key", http.StatusForbidden},
+		{http.MethodGet, "/api/usage", "generate-key", http.StatusOK},
+		{http.MethodGet, "/api/profiles/1", "pull-key", http.StatusForbidden},
+		{http.MethodGet, "/api/profiles/1", "generate-key", http.StatusNotFound},
+		{http.MethodGet, "/api/usage", "admin-key", http.StatusOK},
+	}
+
+	for _, tt := range cases {
+		if resp := do(tt.method, tt.path, tt.key); resp.StatusCode != tt.want {
+			t.Errorf("%s %s with %q: expected status %d, got %d", tt.method, tt.path, tt.key, tt.want, resp.StatusCode)
+		} else if tt.want == http.StatusUnauthorized && resp.Header.Get("WWW-Authenticate") != "Bearer" {
+			t.Errorf("%s %s: expected a WWW-Authenticate header", tt.method, tt.path)
+		}
+	}
+
+	// keys are revoked by changing the file
+	if err := os.WriteFile(path, []byte(`[{"key": "new-key", "scopes": ["admin"]}]`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp := do(http.MethodGet, "/api/tags", "pull-key"); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected a revoked key to be rejected, got %d", resp.StatusCode)
+	}
+
+	if resp := do(http.MethodGet, "/api/usage", "new-key"); resp.StatusCode != http.StatusOK {
+		t.Errorf("expected an added key to be allowed, got %d", resp.StatusCode)
+	}
+}
+
+func TestAPIKeyOwnership(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	path := filepath.Join(t.TempDir(), "keys.json")
+	if err := os.WriteFile(path, []byte(`[
+		{"name": "alice", "key": "alice-key", "scopes": ["generate"]},
+		{"name": "bob", "key": "bob-key", "scopes": ["generate"]}
+	]`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("OLLAMA_API_KEYS", "admin-key")
+	t.Setenv("OLLAMA_API_KEYS_FILE", path)
+
+	mock := mockRunner{CompletionFn: func(_ context.Context, _ llm.CompletionRequest, fn func(llm.CompletionResponse)) error {
+		fn(llm.CompletionResponse{Content: "hi", Done: true, DoneReason: "stop"})
+		return nil
+	}}
+
+	s := Server{sched: newMockScheduler(t, &mock), memories: newMemoryStore()}
+	createMockModel(t, &s, "test", `{{ .Prompt }}`)
+
+	srv := httptest.NewServer(s.GenerateRoutes())
+	defer srv.Close()
+
+	do := func(method, path, key, body string, v any) int {
+		t.Helper()
+		req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+key)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		if v != nil && resp.StatusCode < 300 {
+			if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return resp.StatusCode
+	}
+
+	var session api.SessionResponse
+	if code := do(http.MethodPost, "/api/sessions", "alice-key", `{"model": "test"}`, &session); code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d", code)
+	}
+
+	cases := []struct {
+		method, path, key, body string
+		want                    int
+	}{
+		{http.MethodGet, "/api/sessions/" + session.ID, "alice-key", "", http.StatusOK},
+		{http.MethodGet, "/api/sessions/" + session.ID, "bob-key", "", http.StatusNotFound},
+		{http.MethodGet, "/api/sessions/" + session.ID, "admin-key", "", http.StatusOK},
+		{http.MethodPost, "/api/sessions/" + session.ID + "/fork", "bob-key", "{}", http.StatusNotFound},
+		{http.MethodDelete, "/api/sessions/" + session.ID, "bob-key", "", http.StatusNotFound},
+		{http.MethodPost, "/api/chat", "bob-key", `{"session": "` + session.ID + `", "messages": [{"role": "user", "content": "hi"}], "stream": false}`, http.StatusNotFound},
+		{http.MethodPost, "/api/memories/notes", "alice-key", `{"content": "likes tea"}`, http.StatusCreated},
+		{http.MethodGet, "/api/memories/notes", "alice-key", "", http.StatusOK},
+		{http.MethodGet, "/api/memories/notes", "bob-key", "", http.StatusForbidden},
+		{http.MethodDelete, "/api/memories/notes", "bob-key", "", http.StatusForbidden},
+		{http.MethodPost, "/api/chat", "bob-key", `{"model": "test", "memory": "notes", "messages": [{"role": "user", "content": "hi"}], "stream": false}`, http.StatusForbidden},
+		{http.MethodGet, "/api/memories/notes", "admin-key", "", http.StatusOK},
+	}
+
+	for _, tt := range cases {
+		if code := do(tt.method, tt.path, tt.key, tt.body, nil); code != tt.want {
+			t.Errorf("%s %s with %q: expected status %d, got %d", tt.method, tt.path, tt.key, tt.want, code)
+		}
+	}
+
+	for key, want := range map[string]int{"alice-key": 1, "bob-key": 0, "admin-key": 1} {
+		var list api.ListSessionsResponse
+		do(http.MethodGet, "/api/sessions", key, "", &list)
+		if len(list.Sessions) != want {
+			t.Errorf("expected %s to list %d sessions, got %d", key, want, len(list.Sessions))
+		}
+	}
+
+	id := profiles.add("test", apiKeyDigest("alice-key"), nil)
+	for key, want := range map[string]int{"alice-key": http.StatusOK, "bob-key": http.StatusNotFound, "admin-key": http.StatusOK} {
+		if code := do(http.MethodGet, "/api/profiles/"+id, key, "", nil); code != want {
+			t.Errorf("profile with %s: expected status %d, got %d", key, want, code)
+		}
+	}
+}
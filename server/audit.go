@@ -0,0 +1,415 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
+)
+
+const (
+	// auditLogFiles is the number of rotated audit log files kept beside the
+	// one being written
+	auditLogFiles = 5
+
+	// maxQueuedAuditRecords is the number of records waiting to be posted to
+	// a webhook after which more are dropped
+	maxQueuedAuditRecords = 1024
+)
+
+// auditContent is what audit records capture of prompts and responses
+type auditContent string
+
+const (
+	auditContentNone     auditContent = "none"
+	auditContentRedacted auditContent = "redacted"
+	auditContentFull     auditContent = "full"
+)
+
+// auditRecord is a line of the audit log
+type auditRecord struct {
+	Time     time.Time     `json:"time"`
+	Endpoint string        `json:"endpoint"`
+	Client   string        `json:"client"`
+	Status   int           `json:"status"`
+	Duration time.Duration `json:"duration"`
+
+	Model    string            `json:"model,omitempty"`
+	Options  map[string]any    `json:"options,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	PromptTokens     int `json:"prompt_tokens,omitempty"`
+	CompletionTokens int `json:"completion_tokens,omitempty"`
+
+	Prompt   string `json:"prompt,omitempty"`
+	Response string `json:"response,omitempty"`
+}
+
+// auditSink is where audit records are written
+type auditSink interface {
+	write(line []byte) error
+	close() error
+}
+
+// auditLog writes a record of each request to the endpoints that run models
+type auditLog struct {
+	sink    auditSink
+	content auditContent
+}
+
+// openAuditLog opens the audit log configured with OLLAMA_AUDIT_LOG, or
+// returns nil if requests aren't audited
+func openAuditLog() (*auditLog, error) {
+	dest := envconfig.AuditLog()
+	if dest == "" {
+		return nil, nil
+	}
+
+	content := auditContent(strings.ToLower(envconfig.AuditLogContent()))
+	switch content {
+	case "":
+		content = auditContentNone
+	case auditContentNone, auditContentRedacted, auditContentFull:
+	default:
+		return nil, fmt.Errorf("invalid OLLAMA_AUDIT_LOG_CONTENT %q, expected none, redacted or full", content)
+	}
+
+	var sink auditSink
+	if strings.HasPrefix(dest, "http://") || strings.HasPrefix(dest, "https://") {
+		sink = newAuditWebhook(dest)
+	} else {
+		f, err := openAuditFile(dest, int64(envconfig.AuditLogMaxSize()))
+		if err != nil {
+			return nil, err
+		}
+		sink = f
+	}
+
+	return &auditLog{sink: sink, content: content}, nil
+}
+
+// auditLogOrNil opens the audit log, logging why requests can't be audited
+// if it can't be opened
+func auditLogOrNil() *auditLog {
+	a, err := openAuditLog()
+	if err != nil {
+		slog.Error("requests won't be audited", "error", err)
+		return nil
+	}
+
+	if a != nil {
+		slog.Info("auditing requests", "destination", envconfig.AuditLog(), "content", a.content)
+	}
+
+	return a
+}
+
+func (a *auditLog) close() {
+	if a == nil {
+		return
+	}
+
+	if err := a.sink.close(); err != nil {
+		slog.Warn("couldn't close the audit log", "error", err)
+	}
+}
+
+func (a *auditLog) write(r *auditRecord) {
+	bts, err := json.Marshal(r)
+	if err != nil {
+		slog.Warn("couldn't write an audit record", "error", err)
+		return
+	}
+
+	if err := a.sink.write(append(bts, '\n')); err != nil {
+		slog.Warn("couldn't write an audit record", "error", err)
+	}
+}
+
+// auditKey is the key of a request's audit entry in its gin context
+const auditKey = "ollama.audit"
+
+// auditEntry is the audit record of a request in progress, which handlers
+// add to as they learn more of it
+type auditEntry struct {
+	mu      sync.Mutex
+	record  auditRecord
+	content auditContent
+}
+
+// auditOf returns the audit entry of the request c, or nil if it isn't
+// audited
+func auditOf(c *gin.Context) *auditEntry {
+	if e, ok := c.Get(auditKey); ok {
+		return e.(*auditEntry)
+	}
+
+	return nil
+}
+
+// request records the model and options of the request. Its prompt and
+// response aren't captured if noStore is set.
+func (e *auditEntry) request(model string, options map[string]any, md map[string]string, noStore bool) {
+	if e == nil {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.record.Model = model
+	e.record.Options = options
+	e.record.Metadata = md
+	if noStore {
+		e.content = auditContentNone
+	}
+}
+
+// completed records the prompt and response of the request and the tokens
+// they used
+func (e *auditEntry) completed(prompt, response string, m api.Metrics) {
+	if e == nil {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.record.PromptTokens = m.PromptEvalCount
+	e.record.CompletionTokens = m.EvalCount
+
+	switch e.content {
+	case auditContentFull:
+		e.record.Prompt, e.record.Response = prompt, response
+	case auditContentRedacted:
+		e.record.Prompt, e.record.Response = redactContent(prompt), redactContent(response)
+	}
+}
+
+// auditMiddleware writes a record of each request to the endpoints that
+// generate tokens to a, once the request is complete
+func auditMiddleware(a *auditLog) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if a == nil || !slices.Contains(quotaPaths, c.Request.URL.Path) {
+			c.Next()
+			return
+		}
+
+		e := &auditEntry{
+			record: auditRecord{
+				Time:     time.Now().UTC(),
+				Endpoint: c.Request.URL.Path,
+			},
+			content: a.content,
+		}
+		c.Set(auditKey, e)
+		c.Next()
+
+		e.mu.Lock()
+		defer e.mu.Unlock()
+
+		// the API key of the request is only checked once it's handled
+		e.record.Client = clientOf(c)
+		e.record.Status = c.Writer.Status()
+		e.record.Duration = time.Since(e.record.Time)
+		a.write(&e.record)
+	}
+}
+
+// redactions replace personal information and secrets in captured content
+var redactions = []struct {
+	re   *regexp.Regexp
+	with string
+}{
+	{regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`), "[email]"},
+	{regexp.MustCompile(`\b(?:sk|pk|rk|api|key|token)[-_][A-Za-z0-9_-]{16,}`), "[secret]"},
+	{regexp.MustCompile(`\bBearer\s+[A-Za-z0-9._~+/=-]+`), "Bearer [secret]"},
+	{regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b`), "[ip]"},
+	{regexp.MustCompile(`\+?\b\d(?:[ .-]?\d){8,}\b`), "[number]"},
+}
+
+// redactContent replaces email addresses, secrets such as API keys, IP
+// addresses, and long numbers such as phone and card numbers in s
+func redactContent(s string) string {
+	for _, r := range redactions {
+		s = r.re.ReplaceAllString(s, r.with)
+	}
+
+	return s
+}
+
+// auditFile is an audit log file that's rotated once it grows to maxSize
+type auditFile struct {
+	path    string
+	maxSize int64
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+func openAuditFile(path string, maxSize int64) (*auditFile, error) {
+	a := &auditFile{path: path, maxSize: maxSize}
+	if err := a.open(); err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+func (a *auditFile) open() error {
+	f, err := os.OpenFile(a.path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	a.f, a.size = f, fi.Size()
+	return nil
+}
+
+func (a *auditFile) write(line []byte) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.maxSize > 0 && a.size > 0 && a.size+int64(len(line)) > a.maxSize {
+		if err := a.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := a.f.Write(line)
+	a.size += int64(n)
+	return err
+}
+
+// rotate renames the file to path.1, path.1 to path.2 and so on, removing
+// the oldest, and opens a new file at path. Records keep being appended to
+// the file if it can't be renamed.
+func (a *auditFile) rotate() error {
+	if err := a.f.Close(); err != nil {
+		return err
+	}
+
+	for i := auditLogFiles; i > 0; i-- {
+		newer := a.path + "." + strconv.Itoa(i-1)
+		if i == 1 {
+			newer = a.path
+		}
+
+		if err := os.Rename(newer, a.path+"."+strconv.Itoa(i)); err != nil && !os.IsNotExist(err) {
+			slog.Warn("couldn't rotate the audit log", "error", err)
+			break
+		}
+	}
+
+	return a.open()
+}
+
+func (a *auditFile) close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.f.Close()
+}
+
+// auditWebhook posts each audit record to a URL in the background, so
+// requests aren't held up by it
+type auditWebhook struct {
+	url    string
+	client *http.Client
+
+	records chan []byte
+	dropped atomic.Int64
+
+	done chan struct{}
+	once sync.Once
+}
+
+func newAuditWebhook(url string) *auditWebhook {
+	a := &auditWebhook{
+		url:     url,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		records: make(chan []byte, maxQueuedAuditRecords),
+		done:    make(chan struct{}),
+	}
+
+	go a.run()
+	return a
+}
+
+// write queues line to be posted. Records are dropped rather than block the
+// request they're of if the queue is full.
+func (a *auditWebhook) write(line []byte) error {
+	select {
+	case a.records <- line:
+	default:
+		a.dropped.Add(1)
+	}
+
+	return nil
+}
+
+func (a *auditWebhook) run() {
+	defer close(a.done)
+
+	for line := range a.records {
+		if dropped := a.dropped.Swap(0); dropped > 0 {
+			slog.Warn("dropped audit records, the webhook can't keep up", "records", dropped)
+		}
+
+		a.post(line)
+	}
+}
+
+func (a *auditWebhook) post(line []byte) {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, a.url, bytes.NewReader(line))
+	if err != nil {
+		slog.Warn("couldn't post an audit record", "error", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		slog.Warn("couldn't post an audit record", "error", err)
+		return
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		slog.Warn("couldn't post an audit record", "status", resp.Status)
+	}
+}
+
+// close posts the queued records and stops the webhook
+func (a *auditWebhook) close() error {
+	a.once.Do(func() { close(a.records) })
+
+	select {
+	case <-a.done:
+		return nil
+	case <-time.After(5 * time.Second):
+		return fmt.Errorf("timed out posting %d audit records", len(a.records))
+	}
+}
@@ -0,0 +1,190 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/llm"
+)
+
+func TestRedactContent(t *testing.T) {
+	cases := []struct {
+		in, want string
+	}{
+		{in: "mail jane.doe+x@example.co.uk today", want: "mail [email] today"},
+		{in: "my key is sk-abcdefghijklmnop1234", want: "my key is [secret]"},
+		{in: "Authorization: Bearer abc.def-123", want: "Authorization: Bearer [secret]"},
+		{in: "from 192.168.1.10", want: "from [ip]"},
+		{in: "call +1 555-123-4567 or 4111 1111 1111 1111", want: "call [number] or [number]"},
+		{in: "on 2024-01-01 at 12:30, 3 items cost 42", want: "on 2024-01-01 at 12:30, 3 items cost 42"},
+	}
+
+	for _, tt := range cases {
+		if got := redactContent(tt.in); got != tt.want {
+			t.Errorf("%q: expected %q, got %q", tt.in, tt.want, got)
+		}
+	}
+}
+
+func TestAuditFileRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	f, err := openAuditFile(path, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.close()
+
+	for i := range auditLogFiles + 2 {
+		if err := f.write([]byte(strings.Repeat("x", i) + "0123456\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// each line is over the limit, so each is in its own file and the
+	// oldest were removed
+	for i := range auditLogFiles + 1 {
+		name := path
+		if i > 0 {
+			name += "." + strconv.Itoa(i)
+		}
+
+		bts, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want := strings.Repeat("x", auditLogFiles+1-i) + "0123456\n"; string(bts) != want {
+			t.Errorf("%s: expected %q, got %q", name, want, bts)
+		}
+	}
+
+	if _, err := os.Stat(path + "." + strconv.Itoa(auditLogFiles+1)); !os.IsNotExist(err) {
+		t.Errorf("expected the oldest file to be removed, got %v", err)
+	}
+}
+
+func TestAuditMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	t.Setenv("OLLAMA_AUDIT_LOG", path)
+	t.Setenv("OLLAMA_AUDIT_LOG_CONTENT", "redacted")
+
+	mock := mockRunner{CompletionFn: func(_ context.Context, r llm.CompletionRequest, fn func(llm.CompletionResponse)) error {
+		fn(llm.CompletionResponse{Content: "write to bob@example.com", Done: true, DoneReason: "stop", PromptEvalCount: 4, EvalCount: 3})
+		return nil
+	}}
+
+	s := Server{sched: newMockScheduler(t, &mock)}
+	createMockModel(t, &s, "test", `{{ .Prompt }}`)
+
+	srv := httptest.NewServer(s.GenerateRoutes())
+	defer srv.Close()
+
+	for _, body := range []string{
+		`{"model": "test", "prompt": "email alice@example.com", "options": {"seed": 1}, "metadata": {"team": "a"}, "stream": false}`,
+		`{"model": "test", "prompt": "email alice@example.com", "no_store": true, "stream": false}`,
+		`{"model": "missing", "prompt": "hi", "stream": false}`,
+	} {
+		resp, err := http.Post(srv.URL+"/api/generate", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	resp, err := http.Get(srv.URL + "/api/tags")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	s.audit.close()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var records []auditRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var r auditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, r)
+	}
+
+	if len(records) != 3 {
+		t.Fatalf("expected 3 records, got %+v", records)
+	}
+
+	r := records[0]
+	if r.Endpoint != "/api/generate" || r.Model != "test" || r.Status != http.StatusOK || r.Client == "" {
+		t.Errorf("unexpected record %+v", r)
+	}
+
+	if r.Options["seed"] != float64(1) || r.Metadata["team"] != "a" || r.PromptTokens != 4 || r.CompletionTokens != 3 {
+		t.Errorf("unexpected request details %+v", r)
+	}
+
+	if r.Prompt != "email [email]" || r.Response != "write to [email]" {
+		t.Errorf("expected redacted content, got %q and %q", r.Prompt, r.Response)
+	}
+
+	if r := records[1]; r.Prompt != "" || r.Response != "" || r.CompletionTokens != 3 {
+		t.Errorf("expected no content of a no_store request, got %+v", r)
+	}
+
+	if r := records[2]; r.Model != "missing" || r.Status != http.StatusNotFound {
+		t.Errorf("expected a record of the failed request, got %+v", r)
+	}
+}
+
+func TestAuditWebhook(t *testing.T) {
+	var mu sync.Mutex
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bts, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+
+		mu.Lock()
+		bodies = append(bodies, string(bts))
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	t.Setenv("OLLAMA_AUDIT_LOG", srv.URL)
+	a, err := openAuditLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a.write(&auditRecord{Endpoint: "/api/chat", Model: "a"})
+	a.write(&auditRecord{Endpoint: "/api/chat", Model: "b"})
+	a.close()
+
+	if len(bodies) != 2 || !strings.Contains(bodies[0], `"model":"a"`) || !strings.Contains(bodies[1], `"model":"b"`) {
+		t.Errorf("unexpected posts %q", bodies)
+	}
+
+	t.Setenv("OLLAMA_AUDIT_LOG_CONTENT", "everything")
+	if _, err := openAuditLog(); err == nil {
+		t.Error("expected an invalid content setting to fail")
+	}
+}
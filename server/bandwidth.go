@@ -0,0 +1,241 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/ollama/ollama/envconfig"
+)
+
+// bandwidthWindow limits transfers to rate bytes per second between start and
+// end, expressed as offsets from local midnight. Windows where end is before
+// start wrap around midnight.
+type bandwidthWindow struct {
+	start, end time.Duration
+	rate       int64
+}
+
+func (w bandwidthWindow) contains(t time.Time) bool {
+	h, m, s := t.Clock()
+	d := time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(s)*time.Second
+	if w.start <= w.end {
+		return d >= w.start && d < w.end
+	}
+
+	return d >= w.start || d < w.end
+}
+
+// bandwidthSchedule is a set of time windows with a default rate that applies
+// outside of any window. A rate of 0 is unlimited.
+type bandwidthSchedule struct {
+	windows []bandwidthWindow
+	rate    int64
+}
+
+// parseBandwidthSchedule parses a comma separated list of rates. Each entry is
+// either a rate, which becomes the default, or a time window and rate, e.g.
+// "09:00-17:00=10MB,20MB" limits transfers to 10MB/s during work hours and
+// 20MB/s otherwise. Rates are bytes per second with an optional unit suffix;
+// 0 or "unlimited" removes the limit.
+func parseBandwidthSchedule(s string) (bandwidthSchedule, error) {
+	var sched bandwidthSchedule
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		window, rate, ok := strings.Cut(entry, "=")
+		if !ok {
+			n, err := parseRate(entry)
+			if err != nil {
+				return bandwidthSchedule{}, err
+			}
+
+			sched.rate = n
+			continue
+		}
+
+		from, to, ok := strings.Cut(window, "-")
+		if !ok {
+			return bandwidthSchedule{}, fmt.Errorf("invalid time window %q", window)
+		}
+
+		var w bandwidthWindow
+		var err error
+		if w.start, err = parseClock(from); err != nil {
+			return bandwidthSchedule{}, err
+		}
+
+		if w.end, err = parseClock(to); err != nil {
+			return bandwidthSchedule{}, err
+		}
+
+		if w.rate, err = parseRate(rate); err != nil {
+			return bandwidthSchedule{}, err
+		}
+
+		sched.windows = append(sched.windows, w)
+	}
+
+	return sched, nil
+}
+
+// limit returns the rate in effect at t. The first matching window wins.
+func (s bandwidthSchedule) limit(t time.Time) int64 {
+	for _, w := range s.windows {
+		if w.contains(t) {
+			return w.rate
+		}
+	}
+
+	return s.rate
+}
+
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q: expected HH:MM", s)
+	}
+
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func parseRate(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if strings.EqualFold(s, "unlimited") {
+		return 0, nil
+	}
+
+	i := strings.IndexFunc(s, func(r rune) bool { return !unicode.IsDigit(r) && r != '.' })
+	if i < 0 {
+		i = len(s)
+	}
+
+	n, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid rate %q", s)
+	}
+
+	unit := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s[i:])), "/S")
+	switch unit {
+	case "", "B":
+	case "KB":
+		n *= 1000
+	case "MB":
+		n *= 1000 * 1000
+	case "GB":
+		n *= 1000 * 1000 * 1000
+	case "KIB":
+		n *= 1 << 10
+	case "MIB":
+		n *= 1 << 20
+	case "GIB":
+		n *= 1 << 30
+	default:
+		return 0, fmt.Errorf("invalid rate %q: unknown unit %q", s, s[i:])
+	}
+
+	return int64(n), nil
+}
+
+// bandwidthLimiter paces reads so the combined throughput of all readers
+// sharing it stays within the schedule's current limit
+type bandwidthLimiter struct {
+	schedule bandwidthSchedule
+	now      func() time.Time
+
+	mu sync.Mutex
+	// next is the earliest time the next read may proceed
+	next time.Time
+}
+
+func newBandwidthLimiter(s bandwidthSchedule) *bandwidthLimiter {
+	return &bandwidthLimiter{schedule: s, now: time.Now}
+}
+
+// transferLimiter is shared by all blob downloads and uploads. It is
+// configured with OLLAMA_TRANSFER_LIMIT.
+var transferLimiter = sync.OnceValue(func() *bandwidthLimiter {
+	s, err := parseBandwidthSchedule(envconfig.TransferLimit())
+	if err != nil {
+		slog.Warn("invalid OLLAMA_TRANSFER_LIMIT, transfers will not be limited", "error", err)
+	}
+
+	return newBandwidthLimiter(s)
+})
+
+// reserve accounts for n bytes and returns how long the caller must wait
+// before transferring them
+func (l *bandwidthLimiter) reserve(n int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	rate := l.schedule.limit(now)
+	if rate <= 0 {
+		l.next = time.Time{}
+		return 0
+	}
+
+	if l.next.Before(now) {
+		l.next = now
+	}
+
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(n) * time.Second / time.Duration(rate))
+	return delay
+}
+
+// chunk returns the largest read size to request at once. Keeping reads small
+// relative to the rate prevents long sleeps which would otherwise trip the
+// stall detection of parallel parts.
+func (l *bandwidthLimiter) chunk(n int) int {
+	rate := l.schedule.limit(l.now())
+	if rate <= 0 {
+		return n
+	}
+
+	return min(n, max(1, int(rate/16)))
+}
+
+// Reader returns r paced by l. A nil limiter returns r unchanged.
+func (l *bandwidthLimiter) Reader(ctx context.Context, r io.Reader) io.Reader {
+	if l == nil || (l.schedule.rate == 0 && len(l.schedule.windows) == 0) {
+		return r
+	}
+
+	return &limitedReader{ctx: ctx, r: r, l: l}
+}
+
+type limitedReader struct {
+	ctx context.Context
+	r   io.Reader
+	l   *bandwidthLimiter
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	p = p[:r.l.chunk(len(p))]
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if delay := r.l.reserve(n); delay > 0 {
+			t := time.NewTimer(delay)
+			defer t.Stop()
+
+			select {
+			case <-t.C:
+			case <-r.ctx.Done():
+				return n, r.ctx.Err()
+			}
+		}
+	}
+
+	return n, err
+}
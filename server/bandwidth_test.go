@@ -0,0 +1,144 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseBandwidthSchedule(t *testing.T) {
+	cases := []struct {
+		in      string
+		want    bandwidthSchedule
+		wantErr bool
+	}{
+		{in: "", want: bandwidthSchedule{}},
+		{in: "1024", want: bandwidthSchedule{rate: 1024}},
+		{in: "10MB", want: bandwidthSchedule{rate: 10_000_000}},
+		{in: "1.5 MiB/s", want: bandwidthSchedule{rate: 3 << 19}},
+		{
+			in: "09:00-17:00=10MB, 22:00-06:00=unlimited, 2kb",
+			want: bandwidthSchedule{
+				rate: 2000,
+				windows: []bandwidthWindow{
+					{start: 9 * time.Hour, end: 17 * time.Hour, rate: 10_000_000},
+					{start: 22 * time.Hour, end: 6 * time.Hour, rate: 0},
+				},
+			},
+		},
+		{in: "10XB", wantErr: true},
+		{in: "-1", wantErr: true},
+		{in: "9am-5pm=1MB", wantErr: true},
+		{in: "09:00=1MB", wantErr: true},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseBandwidthSchedule(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(tt.want, got, cmp.AllowUnexported(bandwidthSchedule{}, bandwidthWindow{})); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestBandwidthScheduleLimit(t *testing.T) {
+	s, err := parseBandwidthSchedule("09:00-17:00=10MB,22:00-06:00=1MB,unlimited")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for clock, want := range map[string]int64{
+		"08:59": 0,
+		"09:00": 10_000_000,
+		"16:59": 10_000_000,
+		"17:00": 0,
+		"23:30": 1_000_000,
+		"05:59": 1_000_000,
+		"06:00": 0,
+	} {
+		now, err := time.Parse("15:04", clock)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := s.limit(now); got != want {
+			t.Errorf("%s: expected %d, got %d", clock, want, got)
+		}
+	}
+}
+
+func TestBandwidthLimiter(t *testing.T) {
+	l := newBandwidthLimiter(bandwidthSchedule{rate: 1000})
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)
+	l.now = func() time.Time { return now }
+
+	if d := l.reserve(500); d != 0 {
+		t.Errorf("expected first reservation to proceed immediately, got %s", d)
+	}
+
+	if d := l.reserve(500); d != 500*time.Millisecond {
+		t.Errorf("expected 500ms delay, got %s", d)
+	}
+
+	// idle time is not banked as a burst
+	now = now.Add(10 * time.Second)
+	if d := l.reserve(1000); d != 0 {
+		t.Errorf("expected no delay after idle, got %s", d)
+	}
+
+	if d := l.reserve(1); d != time.Second {
+		t.Errorf("expected 1s delay, got %s", d)
+	}
+
+	if n := l.chunk(32 * 1024); n != 62 {
+		t.Errorf("expected chunk of 62, got %d", n)
+	}
+}
+
+func TestBandwidthLimiterReader(t *testing.T) {
+	src := bytes.Repeat([]byte("a"), 1000)
+
+	r := newBandwidthLimiter(bandwidthSchedule{}).Reader(context.Background(), bytes.NewReader(src))
+	if _, ok := r.(*limitedReader); ok {
+		t.Error("expected unlimited reader to be returned unchanged")
+	}
+
+	l := newBandwidthLimiter(bandwidthSchedule{rate: 10_000})
+	start := time.Now()
+	b, err := io.ReadAll(l.Reader(context.Background(), bytes.NewReader(src)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(b, src) {
+		t.Error("data mismatch")
+	}
+
+	// 1000 bytes at 10KB/s should take around 100ms
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("expected reads to be paced, took %s", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	l = newBandwidthLimiter(bandwidthSchedule{rate: 1})
+	l.reserve(10)
+	if _, err := io.ReadAll(l.Reader(ctx, bytes.NewReader(src))); err == nil {
+		t.Error("expected canceled read to fail")
+	}
+}
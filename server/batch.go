@@ -0,0 +1,210 @@
+package server
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/template"
+)
+
+// maxBatchRequests is the largest number of requests in a batch
+const maxBatchRequests = 1000
+
+// batchWorkers is the number of requests of a batch queued with the
+// scheduler at once. It's twice the default parallel slots of a runner so the
+// runner has a request to start as soon as one of its sequences finishes,
+// while one batch can't fill the scheduler's queue.
+const batchWorkers = 8
+
+// BatchHandler runs many chat and generate requests with one model, streaming
+// the result of each as it completes. The requests are fanned out by the
+// server like as many separate requests, each checked against and counted
+// towards the client's quota; the runner decodes them together in its
+// parallel slots as it would any concurrent requests.
+func (s *Server) BatchHandler(c *gin.Context) {
+	var req api.BatchRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	switch {
+	case req.Model == "":
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "model is required"})
+		return
+	case len(req.Requests) == 0:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "requests are required"})
+		return
+	case len(req.Requests) > maxBatchRequests:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("at most %d requests can be batched", maxBatchRequests)})
+		return
+	}
+
+	for i, item := range req.Requests {
+		if (len(item.Messages) > 0) == (item.Prompt != "") {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("request %d needs either messages or a prompt", i)})
+			return
+		}
+
+		var err error
+		if req.Requests[i].Format, err = responseFormat(item.Format); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("request %d: %v", i, err)})
+			return
+		}
+	}
+
+	if _, err := GetModel(req.Model); err != nil {
+		handleScheduleError(c, req.Model, err)
+		return
+	}
+
+	items := make(chan int)
+	go func() {
+		defer close(items)
+		for i := range req.Requests {
+			select {
+			case items <- i:
+			case <-c.Request.Context().Done():
+				return
+			}
+		}
+	}()
+
+	ch := make(chan any)
+	var wg sync.WaitGroup
+	for range min(batchWorkers, len(req.Requests)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range items {
+				res := s.batchCompletion(c.Request.Context(), req, i)
+				select {
+				case ch <- res:
+				case <-c.Request.Context().Done():
+					return
+				}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(ch)
+	}()
+
+	streamResponse(c, ch)
+}
+
+// batchCompletion runs the ith request of a batch
+func (s *Server) batchCompletion(ctx context.Context, req api.BatchRequest, i int) api.BatchResponse {
+	start := time.Now()
+	item := req.Requests[i]
+
+	options := req.Options
+	if len(item.Options) > 0 {
+		options = maps.Clone(req.Options)
+		if options == nil {
+			options = make(map[string]any)
+		}
+		maps.Copy(options, item.Options)
+	}
+
+	var cr llm.CompletionResponse
+	var err error
+	if len(item.Messages) > 0 {
+		cr, err = s.chatCompletion(ctx, req.Model, item.Messages, nil, item.Format, options, req.KeepAlive)
+	} else {
+		cr, err = s.generateCompletion(ctx, req.Model, item.Prompt, item.System, item.Format, options, req.KeepAlive)
+	}
+
+	res := api.BatchResponse{Index: i, ID: item.ID, Model: req.Model, CreatedAt: time.Now().UTC()}
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+
+	if len(item.Messages) > 0 {
+		res.Message = &api.Message{Role: "assistant", Content: cr.Content}
+	} else {
+		res.Response = cr.Content
+	}
+
+	res.DoneReason = cr.DoneReason
+	res.Metrics = api.Metrics{
+		TotalDuration:      time.Since(start),
+		PromptEvalCount:    cr.PromptEvalCount,
+		PromptEvalDuration: cr.PromptEvalDuration,
+		EvalCount:          cr.EvalCount,
+		EvalDuration:       cr.EvalDuration,
+		DraftCount:         cr.DraftCount,
+		DraftAcceptedCount: cr.DraftAcceptedCount,
+	}
+
+	return res
+}
+
+// generateCompletion answers prompt with the named model, as a generate
+// request would. The returned response has the full content and the metrics
+// of the final response. Its tokens count towards the quota of ctx's client.
+func (s *Server) generateCompletion(ctx context.Context, name, prompt, system string, format json.RawMessage, options map[string]any, keepAlive *api.Duration) (llm.CompletionResponse, error) {
+	if err := s.checkQuota(ctx); err != nil {
+		return llm.CompletionResponse{}, err
+	}
+
+	// the runner is held until the completion is done
+	ctx, release := context.WithCancel(ctx)
+	defer release()
+
+	r, m, opts, err := s.scheduleRunner(ctx, name, []Capability{CapabilityCompletion}, options, keepAlive)
+	if err != nil {
+		return llm.CompletionResponse{}, err
+	}
+
+	var msgs []api.Message
+	if system = cmp.Or(system, m.System); system != "" {
+		msgs = append(msgs, api.Message{Role: "system", Content: system})
+	}
+	msgs = append(msgs, m.Messages...)
+	msgs = append(msgs, api.Message{Role: "user", Content: prompt})
+
+	var b bytes.Buffer
+	if err := m.Template.Execute(&b, template.Values{Messages: msgs}); err != nil {
+		return llm.CompletionResponse{}, err
+	}
+
+	var sb strings.Builder
+	var final llm.CompletionResponse
+	if err := r.Completion(ctx, llm.CompletionRequest{
+		Prompt:  b.String(),
+		Format:  format,
+		Options: opts,
+	}, func(cr llm.CompletionResponse) {
+		sb.WriteString(cr.Content)
+		if cr.Done {
+			final = cr
+		}
+	}); err != nil {
+		return llm.CompletionResponse{}, err
+	}
+
+	s.recordUsage(ctx, name, final.PromptEvalCount, final.EvalCount)
+	final.Content = sb.String()
+	return final, nil
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+)
+
+func TestBatch(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mock := mockRunner{
+		CompletionFn: func(_ context.Context, r llm.CompletionRequest, fn func(llm.CompletionResponse)) error {
+			if strings.Contains(r.Prompt, "fail") {
+				return errors.New("failed")
+			}
+
+			fn(llm.CompletionResponse{Content: strings.TrimSpace(r.Prompt)})
+			fn(llm.CompletionResponse{Content: fmt.Sprintf(" temperature=%g", r.Options.Temperature), Done: true, DoneReason: "stop", EvalCount: 1})
+			return nil
+		},
+	}
+
+	s := Server{sched: newMockScheduler(t, &mock)}
+	createMockModel(t, &s, "test", `{{ range .Messages }}{{ .Role }}: {{ .Content }}{{ "\n" }}{{ end }}`)
+
+	t.Run("results", func(t *testing.T) {
+		w := createRequest(t, s.BatchHandler, api.BatchRequest{
+			Model:   "test",
+			Options: map[string]any{"temperature": 0.5},
+			Requests: []api.BatchItem{
+				{ID: "chat", Messages: []api.Message{{Role: "user", Content: "Hello!"}}},
+				{ID: "generate", Prompt: "Why?", System: "Be brief.", Options: map[string]any{"temperature": 1}},
+				{ID: "failed", Prompt: "fail"},
+			},
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+
+		var got []api.BatchResponse
+		scanner := bufio.NewScanner(w.Body)
+		for scanner.Scan() {
+			var resp api.BatchResponse
+			if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, resp)
+		}
+
+		// results are streamed as they complete
+		slices.SortFunc(got, func(a, b api.BatchResponse) int { return a.Index - b.Index })
+
+		want := []api.BatchResponse{
+			{Index: 0, ID: "chat", Model: "test", Message: &api.Message{Role: "assistant", Content: "user: Hello! temperature=0.5"}, DoneReason: "stop", Metrics: api.Metrics{EvalCount: 1}},
+			{Index: 1, ID: "generate", Model: "test", Response: "system: Be brief.\nuser: Why? temperature=1", DoneReason: "stop", Metrics: api.Metrics{EvalCount: 1}},
+			{Index: 2, ID: "failed", Model: "test", Error: "failed"},
+		}
+
+		if diff := cmp.Diff(want, got, cmpopts.IgnoreFields(api.BatchResponse{}, "CreatedAt"), cmpopts.IgnoreFields(api.Metrics{}, "TotalDuration")); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("queued", func(t *testing.T) {
+		var running, most atomic.Int32
+		mock.CompletionFn = func(_ context.Context, r llm.CompletionRequest, fn func(llm.CompletionResponse)) error {
+			n := running.Add(1)
+			defer running.Add(-1)
+			for m := most.Load(); n > m && !most.CompareAndSwap(m, n); m = most.Load() {
+			}
+
+			time.Sleep(time.Millisecond)
+			fn(llm.CompletionResponse{Done: true, DoneReason: "stop"})
+			return nil
+		}
+
+		items := make([]api.BatchItem, 4*batchWorkers)
+		for i := range items {
+			items[i].Prompt = "Why?"
+		}
+
+		if w := createRequest(t, s.BatchHandler, api.BatchRequest{Model: "test", Requests: items}); w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+
+		if n := most.Load(); n > batchWorkers {
+			t.Errorf("expected at most %d requests at once, got %d", batchWorkers, n)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		cases := []api.BatchRequest{
+			{Requests: []api.BatchItem{{Prompt: "Why?"}}},
+			{Model: "test"},
+			{Model: "test", Requests: []api.BatchItem{{}}},
+			{Model: "test", Requests: []api.BatchItem{{Prompt: "Why?", Messages: []api.Message{{Role: "user", Content: "Why?"}}}}},
+			{Model: "test", Requests: []api.BatchItem{{Prompt: "Why?", Format: json.RawMessage(`{"type":"regex","value":"("}`)}}},
+			{Model: "test", Requests: slices.Repeat([]api.BatchItem{{Prompt: "Why?"}}, maxBatchRequests+1)},
+		}
+
+		for _, req := range cases {
+			if w := createRequest(t, s.BatchHandler, req); w.Code != http.StatusBadRequest {
+				t.Errorf("expected status 400, got %d: %s", w.Code, w.Body)
+			}
+		}
+
+		if w := createRequest(t, s.BatchHandler, api.BatchRequest{Model: "missing", Requests: []api.BatchItem{{Prompt: "Why?"}}}); w.Code != http.StatusNotFound {
+			t.Errorf("expected status 404, got %d: %s", w.Code, w.Body)
+		}
+	})
+}
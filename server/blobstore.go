@@ -0,0 +1,114 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/types/model"
+)
+
+var errSharedModel = errors.New("model is in the read-only shared model store")
+
+// localStore returns the store of the local models directory, which models
+// are written to
+func localStore() *diskStore {
+	return &diskStore{root: envconfig.Models()}
+}
+
+// sharedStores returns the read-only stores models are read from when they
+// aren't in the local models directory: the shared blob directory configured
+// with OLLAMA_SHARED_BLOBS and the shared models directory configured with
+// OLLAMA_SHARED_MODELS
+func sharedStores() []*diskStore {
+	var stores []*diskStore
+	if dir := envconfig.SharedBlobs(); dir != "" {
+		stores = append(stores, &diskStore{root: dir, blobsOnly: true, readOnly: true})
+	}
+
+	if dir := envconfig.SharedModels(); dir != "" {
+		stores = append(stores, &diskStore{root: dir, readOnly: true})
+	}
+
+	return stores
+}
+
+// diskStores returns the local store followed by the shared stores, in the
+// order models are looked up in them
+func diskStores() []*diskStore {
+	return append([]*diskStore{localStore()}, sharedStores()...)
+}
+
+// blobStore returns the first store with the blob of digest, or nil if it
+// isn't on disk
+func blobStore(digest string) *diskStore {
+	if digest == "" {
+		return nil
+	}
+
+	for _, s := range diskStores() {
+		if _, err := s.stat(blobKey(digest)); err == nil {
+			return s
+		}
+	}
+
+	return nil
+}
+
+// manifestStore returns the first store with the manifest of n, or nil if it
+// isn't on disk. Models in the local store take precedence over shared models
+// of the same name.
+func manifestStore(n model.Name) *diskStore {
+	if !n.IsValid() {
+		return nil
+	}
+
+	for _, s := range diskStores() {
+		if _, err := s.stat(manifestKey(n)); err == nil {
+			return s
+		}
+	}
+
+	return nil
+}
+
+// validateSharedBlobs checks the shared stores are readable and reports
+// local models whose blobs are in no store on disk
+func validateSharedBlobs() error {
+	shared := sharedStores()
+	if len(shared) == 0 {
+		return nil
+	}
+
+	for _, s := range shared {
+		dir := s.root
+		if !s.blobsOnly {
+			dir = s.path("blobs")
+		}
+
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return fmt.Errorf("shared blob store %s: %w", dir, err)
+		}
+
+		slog.Info("using shared blob store", "path", dir, "blobs", len(entries))
+	}
+
+	ms, err := Manifests(true)
+	if err != nil {
+		return err
+	}
+
+	for n, m := range ms {
+		for _, layer := range append(m.Layers, m.Config) {
+			if layer.Digest != "" && blobStore(layer.Digest) == nil {
+				slog.Warn("model blob missing from local and shared blob stores", "model", n.DisplayShortest(), "digest", layer.Digest)
+				break
+			}
+		}
+	}
+
+	return nil
+}
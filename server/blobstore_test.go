@@ -0,0 +1,124 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ollama/ollama/types/model"
+)
+
+func TestSharedBlobs(t *testing.T) {
+	models := t.TempDir()
+	shared := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", models)
+	t.Setenv("OLLAMA_SHARED_BLOBS", shared)
+
+	digest := "sha256:456402914e838a953e0cf80caa6adbe75383d9e63584a964f504a7bbb8f7aad9"
+	sharedPath := filepath.Join(shared, "sha256-456402914e838a953e0cf80caa6adbe75383d9e63584a964f504a7bbb8f7aad9")
+	localPath := filepath.Join(models, "blobs", "sha256-456402914e838a953e0cf80caa6adbe75383d9e63584a964f504a7bbb8f7aad9")
+
+	// blobs missing from both stores are written locally
+	p, err := GetBlobsPath(digest)
+	require.NoError(t, err)
+	require.Equal(t, localPath, p)
+
+	require.NoError(t, os.WriteFile(sharedPath, []byte("shared"), 0o644))
+	p, err = GetBlobsPath(digest)
+	require.NoError(t, err)
+	require.Equal(t, sharedPath, p)
+	require.True(t, blobStore(digest).readOnly)
+
+	// shared blobs are never removed
+	layer := Layer{Digest: digest}
+	require.NoError(t, layer.Remove())
+	require.FileExists(t, sharedPath)
+	require.ErrorIs(t, blobStore(digest).Delete(context.Background(), blobKey(digest)), errReadOnlyStore)
+
+	// shared blobs are read in place rather than copied from a mirror
+	require.NoError(t, fetchBlob(context.Background(), &diskStore{root: t.TempDir()}, digest))
+	require.NoFileExists(t, localPath)
+
+	// local copies take precedence
+	require.NoError(t, os.WriteFile(localPath, []byte("local"), 0o644))
+	p, err = GetBlobsPath(digest)
+	require.NoError(t, err)
+	require.Equal(t, localPath, p)
+	require.False(t, blobStore(digest).readOnly)
+}
+
+func TestValidateSharedBlobs(t *testing.T) {
+	models := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", models)
+
+	t.Setenv("OLLAMA_SHARED_BLOBS", "")
+	require.NoError(t, validateSharedBlobs())
+
+	t.Setenv("OLLAMA_SHARED_BLOBS", filepath.Join(models, "missing"))
+	require.Error(t, validateSharedBlobs())
+
+	shared := t.TempDir()
+	t.Setenv("OLLAMA_SHARED_BLOBS", shared)
+	require.NoError(t, os.WriteFile(filepath.Join(shared, "sha256-456402914e838a953e0cf80caa6adbe75383d9e63584a964f504a7bbb8f7aad9"), nil, 0o644))
+
+	config := Layer{Digest: "sha256:456402914e838a953e0cf80caa6adbe75383d9e63584a964f504a7bbb8f7aad9"}
+	require.NoError(t, WriteManifest(model.ParseName("shared"), config, nil))
+	require.NoError(t, validateSharedBlobs())
+}
+
+func TestSharedModels(t *testing.T) {
+	shared := t.TempDir()
+	digest := "sha256:456402914e838a953e0cf80caa6adbe75383d9e63584a964f504a7bbb8f7aad9"
+	config := Layer{Digest: digest}
+
+	// the shared models directory is laid out like a models directory
+	t.Setenv("OLLAMA_MODELS", shared)
+	name := model.ParseName("base")
+	require.NoError(t, WriteManifest(name, config, nil))
+	sharedBlob, err := GetBlobsPath(digest)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(sharedBlob, []byte("{}"), 0o644))
+
+	models := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", models)
+	t.Setenv("OLLAMA_SHARED_MODELS", shared)
+
+	ms, err := Manifests(false)
+	require.NoError(t, err)
+	require.Contains(t, ms, name)
+	require.True(t, ms[name].store.readOnly)
+
+	m, err := GetModel("base")
+	require.NoError(t, err)
+	require.Equal(t, name.String(), m.Name)
+
+	p, err := GetBlobsPath(digest)
+	require.NoError(t, err)
+	require.Equal(t, sharedBlob, p)
+	require.True(t, blobStore(digest).readOnly)
+
+	// shared models can't be removed but can be copied to the local store
+	require.ErrorIs(t, ms[name].Remove(), errSharedModel)
+	require.NoError(t, ms[name].RemoveLayers())
+	require.FileExists(t, sharedBlob)
+
+	require.NoError(t, CopyModel(name, model.ParseName("copy")))
+	copied, err := ParseNamedManifest(model.ParseName("copy"))
+	require.NoError(t, err)
+	require.False(t, copied.store.readOnly)
+	require.Equal(t, digest, copied.Config.Digest)
+
+	// local models take precedence over shared models of the same name
+	require.NoError(t, WriteManifest(name, Layer{}, nil))
+	local, err := ParseNamedManifest(name)
+	require.NoError(t, err)
+	require.False(t, local.store.readOnly)
+	require.NoError(t, local.Remove())
+
+	local, err = ParseNamedManifest(name)
+	require.NoError(t, err)
+	require.True(t, local.store.readOnly)
+}
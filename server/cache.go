@@ -0,0 +1,273 @@
+package server
+
+import (
+	"cmp"
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/types/model"
+)
+
+// registryCachePulls tracks models being pulled into the local store on
+// behalf of registry cache clients so each model is only pulled once
+var registryCachePulls sync.Map
+
+// registryCacheFetches tracks blobs being fetched into the local store on
+// behalf of registry cache clients so each blob is only fetched once
+var registryCacheFetches sync.Map
+
+// registryCacheDigestsSize is the number of blobs registryCacheDigests keeps
+const registryCacheDigestsSize = 4096
+
+// registryCacheDigests is an LRU cache of the blobs referenced by the
+// upstream manifests served for each repository, which may not be stored
+// locally yet. Clients choose the repositories, so the cache is bounded.
+var registryCacheDigests = struct {
+	sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+}{order: list.New(), entries: make(map[string]*list.Element)}
+
+// rememberDigest records that a manifest served for repository references digest
+func rememberDigest(repository, digest string) {
+	key := repository + "@" + digest
+
+	registryCacheDigests.Lock()
+	defer registryCacheDigests.Unlock()
+
+	if e, ok := registryCacheDigests.entries[key]; ok {
+		registryCacheDigests.order.MoveToFront(e)
+		return
+	}
+
+	registryCacheDigests.entries[key] = registryCacheDigests.order.PushFront(key)
+	for registryCacheDigests.order.Len() > registryCacheDigestsSize {
+		oldest := registryCacheDigests.order.Back()
+		registryCacheDigests.order.Remove(oldest)
+		delete(registryCacheDigests.entries, oldest.Value.(string))
+	}
+}
+
+// rememberedDigest reports whether a manifest served for repository
+// references digest
+func rememberedDigest(repository, digest string) bool {
+	registryCacheDigests.Lock()
+	defer registryCacheDigests.Unlock()
+
+	e, ok := registryCacheDigests.entries[repository+"@"+digest]
+	if ok {
+		registryCacheDigests.order.MoveToFront(e)
+	}
+
+	return ok
+}
+
+// registryCacheUpstream is the registry a registry cache pulls from
+var registryCacheUpstream = ModelPath{ProtocolScheme: DefaultProtocolScheme, Registry: DefaultRegistry}
+
+// registryCachePath returns the upstream model path for a registry request
+func registryCachePath(c *gin.Context) (ModelPath, model.Name, error) {
+	mp := registryCacheUpstream
+	mp.Namespace = c.Param("namespace")
+	mp.Repository = c.Param("model")
+	mp.Tag = DefaultTag
+
+	if tag := c.Param("tag"); tag != "" {
+		mp.Tag = tag
+	}
+
+	n := model.ParseName(mp.GetFullTagname())
+	if !n.IsValid() {
+		return ModelPath{}, model.Name{}, errModelPathInvalid
+	}
+
+	return mp, n, nil
+}
+
+// RegistryManifestHandler serves model manifests to other Ollama instances
+// using this server as a pull-through cache. The manifest is fetched from the
+// upstream registry when possible and the model pulled in the background so
+// later requests are served locally. If the upstream registry is unreachable,
+// the local manifest is served instead.
+func (s *Server) RegistryManifestHandler(c *gin.Context) {
+	mp, n, err := registryCachePath(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	m, err := pullModelManifest(c.Request.Context(), mp, &registryOptions{})
+	if err != nil {
+		slog.Warn("registry cache: upstream manifest unavailable, using local copy", "model", n.DisplayShortest(), "error", err)
+		m, err = ParseNamedManifest(n)
+		if errors.Is(err, os.ErrNotExist) {
+			c.JSON(http.StatusNotFound, errorBody(api.ErrorCodeModelNotFound, fmt.Sprintf("model '%s' not found", n.DisplayShortest())))
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	} else {
+		for _, layer := range append(m.Layers, m.Config) {
+			rememberDigest(mp.GetNamespaceRepository(), layer.Digest)
+		}
+
+		if !manifestCached(m) {
+			registryCachePull(n)
+		}
+	}
+
+	bts, err := json.Marshal(m)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Data(http.StatusOK, cmp.Or(m.MediaType, "application/vnd.docker.distribution.manifest.v2+json"), bts)
+}
+
+// RegistryBlobHandler serves blobs to other Ollama instances using this
+// server as a pull-through cache. Only blobs referenced by a manifest of the
+// repository are served. Blobs not yet in the local store are fetched into
+// it in the background while the request is redirected to the upstream
+// registry.
+func (s *Server) RegistryBlobHandler(c *gin.Context) {
+	mp, n, err := registryCachePath(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	digest := c.Param("digest")
+	if digest == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": ErrInvalidDigestFormat.Error()})
+		return
+	}
+
+	fp, err := GetBlobsPath(digest)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !manifestReferences(mp, n, digest) {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("blob %s not found in %s", digest, mp.GetNamespaceRepository())})
+		return
+	}
+
+	if _, err := os.Stat(fp); err == nil {
+		c.File(fp)
+		return
+	}
+
+	registryCacheFetch(mp, digest)
+	c.Redirect(http.StatusTemporaryRedirect, mp.BaseURL().JoinPath("v2", mp.GetNamespaceRepository(), "blobs", digest).String())
+}
+
+// manifestCached reports whether every blob referenced by m is in the local store
+func manifestCached(m *Manifest) bool {
+	for _, layer := range append(m.Layers, m.Config) {
+		fp, err := GetBlobsPath(layer.Digest)
+		if err != nil {
+			return false
+		}
+
+		if _, err := os.Stat(fp); err != nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+// manifestReferences reports whether a manifest of the repository of mp,
+// either served from upstream or stored locally with any tag, references
+// digest
+func manifestReferences(mp ModelPath, n model.Name, digest string) bool {
+	if rememberedDigest(mp.GetNamespaceRepository(), digest) {
+		return true
+	}
+
+	manifests, err := GetManifestPath()
+	if err != nil {
+		return false
+	}
+
+	entries, err := os.ReadDir(filepath.Join(manifests, n.Host, n.Namespace, n.Model))
+	if err != nil {
+		return false
+	}
+
+	for _, e := range entries {
+		n.Tag = e.Name()
+		m, err := ParseNamedManifest(n)
+		if err != nil {
+			continue
+		}
+
+		for _, layer := range append(m.Layers, m.Config) {
+			if layer.Digest == digest {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// registryCacheFetch fetches a blob of the repository of mp from upstream
+// into the local store in the background
+func registryCacheFetch(mp ModelPath, digest string) {
+	if _, loaded := registryCacheFetches.LoadOrStore(digest, struct{}{}); loaded {
+		return
+	}
+
+	go func() {
+		defer registryCacheFetches.Delete(digest)
+
+		slog.Info("registry cache: fetching blob", "repository", mp.GetNamespaceRepository(), "digest", digest)
+		if _, err := downloadBlob(context.Background(), downloadOpts{
+			mp:      mp,
+			digest:  digest,
+			regOpts: &registryOptions{},
+			fn:      func(api.ProgressResponse) {},
+		}); err != nil {
+			slog.Warn("registry cache: fetch failed", "digest", digest, "error", err)
+			return
+		}
+
+		if err := verifyBlob(digest); err != nil {
+			slog.Warn("registry cache: removing corrupt blob", "digest", digest, "error", err)
+			if fp, err := GetBlobsPath(digest); err == nil {
+				os.Remove(fp)
+			}
+		}
+	}()
+}
+
+func registryCachePull(n model.Name) {
+	name := n.String()
+	if _, loaded := registryCachePulls.LoadOrStore(name, struct{}{}); loaded {
+		return
+	}
+
+	go func() {
+		defer registryCachePulls.Delete(name)
+
+		slog.Info("registry cache: pulling model", "model", n.DisplayShortest())
+		if err := PullModel(context.Background(), name, &registryOptions{}, func(api.ProgressResponse) {}); err != nil {
+			slog.Warn("registry cache: pull failed", "model", n.DisplayShortest(), "error", err)
+		}
+	}()
+}
@@ -0,0 +1,305 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/types/model"
+)
+
+func TestRegistryCache(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	t.Setenv("OLLAMA_REGISTRY_CACHE", "1")
+
+	var upstreamManifest []byte
+	upstreamBlob := []byte("upstream blob")
+	upstreamDigest := fmt.Sprintf("sha256:%x", sha256.Sum256(upstreamBlob))
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/blobs/"+upstreamDigest):
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(upstreamBlob))
+		case upstreamManifest != nil && strings.Contains(r.URL.Path, "/manifests/"):
+			w.Write(upstreamManifest)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer upstream.Close()
+
+	testMakeRequestDialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "tcp", upstream.Listener.Addr().String())
+	}
+	t.Cleanup(func() { testMakeRequestDialContext = nil })
+
+	upstreamPath := registryCacheUpstream
+	registryCacheUpstream.ProtocolScheme = "http"
+	t.Cleanup(func() { registryCacheUpstream = upstreamPath })
+
+	var s Server
+	_, digest := createBinFile(t, nil, nil)
+	if w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Name:  "test",
+		Files: map[string]string{"test.gguf": digest},
+	}); w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	m, err := ParseNamedManifest(model.ParseName("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(s.GenerateRoutes())
+	defer srv.Close()
+
+	// don't follow redirects to the upstream registry
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+
+	get := func(t *testing.T, path string, header http.Header) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if header != nil {
+			req.Header = header
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	t.Run("local manifest", func(t *testing.T) {
+		resp := get(t, "/v2/library/test/manifests/latest", nil)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", resp.StatusCode)
+		}
+
+		var got Manifest
+		if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+
+		if got.Layers[0].Digest != m.Layers[0].Digest {
+			t.Errorf("expected layer %s, got %s", m.Layers[0].Digest, got.Layers[0].Digest)
+		}
+	})
+
+	t.Run("upstream manifest", func(t *testing.T) {
+		upstreamManifest, err = json.Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { upstreamManifest = nil })
+
+		resp := get(t, "/v2/library/other/manifests/latest", nil)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", resp.StatusCode)
+		}
+
+		if _, ok := registryCachePulls.Load(model.ParseName("other").String()); ok {
+			t.Error("expected no pull when all blobs are cached")
+		}
+	})
+
+	t.Run("missing manifest", func(t *testing.T) {
+		if resp := get(t, "/v2/library/missing/manifests/latest", nil); resp.StatusCode != http.StatusNotFound {
+			t.Errorf("expected status 404, got %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("cached blob", func(t *testing.T) {
+		layer := m.Layers[0]
+		resp := get(t, "/v2/library/test/blobs/"+layer.Digest, http.Header{"Range": {"bytes=0-3"}})
+		if resp.StatusCode != http.StatusPartialContent {
+			t.Fatalf("expected status 206, got %d", resp.StatusCode)
+		}
+
+		bts, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(bts) != "GGUF" {
+			t.Errorf("expected GGUF magic, got %q", bts)
+		}
+	})
+
+	t.Run("unreferenced blob", func(t *testing.T) {
+		digest := "sha256:" + strings.Repeat("a", 64)
+		if resp := get(t, "/v2/library/test/blobs/"+digest, nil); resp.StatusCode != http.StatusNotFound {
+			t.Errorf("expected status 404, got %d", resp.StatusCode)
+		}
+
+		// blobs are only served to the repositories that reference them
+		if resp := get(t, "/v2/library/missing/blobs/"+m.Layers[0].Digest, nil); resp.StatusCode != http.StatusNotFound {
+			t.Errorf("expected status 404, got %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("uncached blob", func(t *testing.T) {
+		upstreamManifest, err = json.Marshal(Manifest{
+			SchemaVersion: 2,
+			Config:        m.Config,
+			Layers:        []Layer{{MediaType: "application/vnd.ollama.image.model", Digest: upstreamDigest, Size: int64(len(upstreamBlob))}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { upstreamManifest = nil })
+
+		if resp := get(t, "/v2/library/uncached/manifests/latest", nil); resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", resp.StatusCode)
+		}
+
+		resp := get(t, "/v2/library/uncached/blobs/"+upstreamDigest, nil)
+		if resp.StatusCode != http.StatusTemporaryRedirect {
+			t.Fatalf("expected status 307, got %d", resp.StatusCode)
+		}
+
+		if want := "http://registry.ollama.ai/v2/library/uncached/blobs/" + upstreamDigest; resp.Header.Get("Location") != want {
+			t.Errorf("expected redirect to %s, got %s", want, resp.Header.Get("Location"))
+		}
+
+		fp, err := GetBlobsPath(upstreamDigest)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the blob is fetched into the local store
+		for deadline := time.Now().Add(10 * time.Second); ; {
+			if bts, err := os.ReadFile(fp); err == nil && bytes.Equal(bts, upstreamBlob) {
+				break
+			} else if time.Now().After(deadline) {
+				t.Fatalf("expected the blob to be fetched, got %q, %v", bts, err)
+			}
+
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		if resp := get(t, "/v2/library/test/blobs/sha256:bad", nil); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", resp.StatusCode)
+		}
+	})
+}
+
+func TestRegistryCacheDigests(t *testing.T) {
+	rememberDigest("library/first", "sha256:0")
+	if !rememberedDigest("library/first", "sha256:0") {
+		t.Fatal("expected the digest to be remembered")
+	}
+
+	if rememberedDigest("library/other", "sha256:0") {
+		t.Error("expected digests to be remembered per repository")
+	}
+
+	for i := range registryCacheDigestsSize {
+		rememberDigest("library/test", fmt.Sprintf("sha256:%d", i+1))
+	}
+
+	registryCacheDigests.Lock()
+	n := registryCacheDigests.order.Len()
+	registryCacheDigests.Unlock()
+
+	if n != registryCacheDigestsSize {
+		t.Errorf("expected %d remembered digests, got %d", registryCacheDigestsSize, n)
+	}
+
+	if rememberedDigest("library/first", "sha256:0") {
+		t.Error("expected the least recently used digest to be evicted")
+	}
+}
+
+func TestRegistryMirror(t *testing.T) {
+	configs := registryTransports.configs
+	t.Cleanup(func() { registryTransports.configs = configs })
+
+	registryTransports.configs = func() (registryConfigs, error) {
+		return registryConfigs{
+			"registry.ollama.ai": {Mirror: "http://cache.local:11434", MirrorKey: "pull-key"},
+			"bad.example.com":    {Mirror: "cache.local"},
+		}, nil
+	}
+
+	if u := registryMirror("registry.ollama.ai"); u == nil || u.String() != "http://cache.local:11434" {
+		t.Errorf("expected mirror, got %v", u)
+	}
+
+	if opts := mirrorOptions("registry.ollama.ai"); opts.Token != "pull-key" {
+		t.Errorf("expected the mirror's key, got %q", opts.Token)
+	}
+
+	for _, host := range []string{"bad.example.com", "example.com"} {
+		if u := registryMirror(host); u != nil {
+			t.Errorf("%s: expected no mirror, got %v", host, u)
+		}
+	}
+
+	t.Setenv("OLLAMA_REGISTRY_CACHE", "1")
+	if u := registryMirror("registry.ollama.ai"); u != nil {
+		t.Errorf("expected mirror to be ignored by a registry cache, got %v", u)
+	}
+}
+
+func TestPullModelManifestMirrorFallback(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.Host+r.URL.Path)
+		if strings.HasPrefix(r.Host, "cache.local") {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+
+		json.NewEncoder(w).Encode(Manifest{SchemaVersion: 2})
+	}))
+	defer srv.Close()
+
+	testMakeRequestDialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "tcp", srv.Listener.Addr().String())
+	}
+	t.Cleanup(func() { testMakeRequestDialContext = nil })
+
+	configs := registryTransports.configs
+	t.Cleanup(func() { registryTransports.configs = configs })
+	registryTransports.configs = func() (registryConfigs, error) {
+		return registryConfigs{"example.com": {Mirror: "http://cache.local"}}, nil
+	}
+
+	mp := ParseModelPath("http://example.com/library/test:latest")
+	m, err := pullModelManifest(context.Background(), mp, &registryOptions{Insecure: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.SchemaVersion != 2 {
+		t.Errorf("unexpected manifest %+v", m)
+	}
+
+	want := []string{"cache.local/v2/library/test/manifests/latest", "example.com/v2/library/test/manifests/latest"}
+	if strings.Join(paths, ",") != strings.Join(want, ",") {
+		t.Errorf("expected requests %v, got %v", want, paths)
+	}
+}
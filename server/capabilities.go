@@ -0,0 +1,64 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+)
+
+// capabilities returns what m supports, derived from its metadata and
+// template. Remote models have no metadata, so only what their template
+// supports is known. Capabilities of the template, such as tools, are only
+// reported for models that generate completions.
+func (m *Model) capabilities() *api.ModelCapabilities {
+	supports := func(c Capability) bool {
+		return m.CheckCapabilities(c) == nil
+	}
+
+	caps := api.ModelCapabilities{
+		Completion: supports(CapabilityCompletion),
+		Rerank:     supports(CapabilityRerank),
+	}
+
+	if caps.Completion {
+		caps.Tools = supports(CapabilityTools)
+		caps.Insert = supports(CapabilityInsert)
+		caps.Thinking = supports(CapabilityThinking)
+	}
+
+	if m.Config.Remote != nil || m.ModelPath == "" {
+		return &caps
+	}
+
+	ggml, err := llm.LoadModel(m.ModelPath, 0)
+	if err != nil {
+		slog.Warn("couldn't read model metadata", "model", m.ShortName, "error", err)
+		return &caps
+	}
+
+	kv := ggml.KV()
+	caps.ContextLength = int(kv.ContextLength())
+
+	// models that pool their outputs into a vector embed text, except
+	// rerankers, whose pooled output is a score
+	if _, ok := kv[fmt.Sprintf("%s.pooling_type", kv.Architecture())]; ok {
+		caps.Embedding = kv.PoolingType() != llm.PoolingTypeRank
+	}
+
+	for _, p := range m.ProjectorPaths {
+		projector, err := llm.LoadModel(p, 0)
+		if err != nil {
+			slog.Debug("couldn't read projector metadata", "projector", p, "error", err)
+			continue
+		}
+
+		if projector.KV().VisionEncoder() {
+			caps.Vision = true
+			caps.ImageTokens = m.ImageTokens()
+		}
+	}
+
+	return &caps
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/template"
+)
+
+// writeGGUF writes a GGUF file of kv to a temporary file and returns its path
+func writeGGUF(t *testing.T, kv llm.KV) string {
+	t.Helper()
+	f, err := os.CreateTemp(t.TempDir(), "*.gguf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := llm.WriteGGUF(f, kv, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	return f.Name()
+}
+
+func TestModelCapabilities(t *testing.T) {
+	parse := func(s string) *template.Template {
+		t.Helper()
+		tmpl, err := template.Parse(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tmpl
+	}
+
+	reasoning := writeGGUF(t, llm.KV{
+		"general.architecture":  "qwen2",
+		"qwen2.context_length":  uint32(32768),
+		"tokenizer.ggml.tokens": []string{"a", "<think>", "</think>"},
+	})
+
+	embedding := writeGGUF(t, llm.KV{
+		"general.architecture": "bert",
+		"bert.context_length":  uint32(512),
+		"bert.pooling_type":    uint32(1),
+	})
+
+	reranker := writeGGUF(t, llm.KV{
+		"general.architecture": "bert",
+		"bert.context_length":  uint32(512),
+		"bert.pooling_type":    uint32(llm.PoolingTypeRank),
+	})
+
+	vision := writeGGUF(t, llm.KV{
+		"general.architecture":    "clip",
+		"clip.has_vision_encoder": true,
+		"clip.vision.image_size":  uint32(28),
+		"clip.vision.patch_size":  uint32(14),
+	})
+
+	cases := []struct {
+		name  string
+		model Model
+		want  api.ModelCapabilities
+	}{
+		{
+			name: "tools and thinking",
+			model: Model{
+				ModelPath: reasoning,
+				Template:  parse(`{{ if .Tools }}{{ .Tools }}{{ end }}{{ .Prompt }}`),
+			},
+			want: api.ModelCapabilities{Completion: true, Tools: true, Thinking: true, ContextLength: 32768},
+		},
+		{
+			name: "insert and thinking template",
+			model: Model{
+				ModelPath: reasoning,
+				Template:  parse(`{{ .Prompt }}<thinking>{{ .Suffix }}`),
+			},
+			want: api.ModelCapabilities{Completion: true, Insert: true, Thinking: true, ContextLength: 32768},
+		},
+		{
+			name: "embedding",
+			model: Model{
+				ModelPath: embedding,
+				Template:  parse(`{{ if .Tools }}{{ .Tools }}{{ end }}{{ .Prompt }}<thinking>{{ .Suffix }}`),
+			},
+			want: api.ModelCapabilities{Embedding: true, ContextLength: 512},
+		},
+		{
+			name:  "rerank",
+			model: Model{ModelPath: reranker, Template: parse(`{{ .Prompt }}`)},
+			want:  api.ModelCapabilities{Rerank: true, ContextLength: 512},
+		},
+		{
+			name: "projectors",
+			model: Model{
+				ModelPath:      reasoning,
+				ProjectorPaths: []string{vision},
+				Template:       parse(`{{ .Prompt }}`),
+			},
+			want: api.ModelCapabilities{Completion: true, Thinking: true, Vision: true, ContextLength: 32768, ImageTokens: 4},
+		},
+		{
+			name: "remote",
+			model: Model{
+				Template: parse(`{{ .Prompt }}`),
+				Config:   ConfigV2{Remote: &api.RemoteModel{}},
+			},
+			want: api.ModelCapabilities{Completion: true},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			if diff := cmp.Diff(&tt.want, tt.model.capabilities()); diff != "" {
+				t.Errorf("capabilities mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestShowCapabilities(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("OLLAMA_MODELS", filepath.Join(t.TempDir(), "models"))
+
+	var s Server
+	createMockModel(t, &s, "test", `{{ .Prompt }}`)
+
+	resp, err := GetModelInfo(api.ShowRequest{Model: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(&api.ModelCapabilities{Completion: true, ContextLength: 8192}, resp.Capabilities); diff != "" {
+		t.Errorf("capabilities mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+
+	"github.com/ollama/ollama/api"
+)
+
+var errBadModelCard = errors.New("invalid model card")
+
+// sourceDigest matches the digests of the checkpoints in model cards
+var sourceDigest = regexp.MustCompile(`^sha256:[0-9a-f]{64}$`)
+
+// checkModelCard returns an error if card has evals without a benchmark or
+// score, or sources without a valid digest
+func checkModelCard(card *api.ModelCard) error {
+	for i, e := range card.Evals {
+		switch {
+		case e.Benchmark == "":
+			return fmt.Errorf("%w: eval %d has no benchmark", errBadModelCard, i)
+		case math.IsNaN(e.Score) || math.IsInf(e.Score, 0):
+			return fmt.Errorf("%w: eval %q has an invalid score", errBadModelCard, e.Benchmark)
+		}
+	}
+
+	for i, s := range card.Sources {
+		if !sourceDigest.MatchString(s.Digest) {
+			return fmt.Errorf("%w: source %d has invalid digest %q, expected sha256:<64 hex digits>", errBadModelCard, i, s.Digest)
+		}
+	}
+
+	return nil
+}
+
+// setModelCard replaces the model card in layers with card
+func setModelCard(layers []Layer, card *api.ModelCard) ([]Layer, error) {
+	if err := checkModelCard(card); err != nil {
+		return nil, err
+	}
+
+	layers = removeLayer(layers, "application/vnd.ollama.image.card")
+
+	var b bytes.Buffer
+	if err := json.NewEncoder(&b).Encode(card); err != nil {
+		return nil, err
+	}
+
+	layer, err := NewLayer(&b, "application/vnd.ollama.image.card")
+	if err != nil {
+		return nil, err
+	}
+
+	return append(layers, layer), nil
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestCreateModelCard(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	var s Server
+	_, digest := createBinFile(t, nil, nil)
+
+	card := api.ModelCard{
+		Description:  "A small model for tests",
+		IntendedUse:  "Unit tests",
+		TrainingData: "None",
+		Evals:        []api.ModelEval{{Benchmark: "mmlu", Metric: "accuracy", Score: 0.25}},
+		Sources:      []api.ModelSource{{Name: "test.gguf", Digest: digest}},
+	}
+
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:  "test",
+		Files:  map[string]string{"test.gguf": digest},
+		Card:   &card,
+		Stream: &stream,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+	}
+
+	// models created from a model keep its card unless they replace it
+	w = createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:  "derived",
+		From:   "test",
+		System: "You are a bot.",
+		Stream: &stream,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+	}
+
+	for _, name := range []string{"test", "derived"} {
+		resp, err := GetModelInfo(api.ShowRequest{Model: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if diff := cmp.Diff(&card, resp.Card); diff != "" {
+			t.Errorf("%s: card mismatch (-want +got):\n%s", name, diff)
+		}
+	}
+
+	replaced := api.ModelCard{Description: "Fine-tuned for chat"}
+	w = createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:  "derived",
+		From:   "test",
+		Card:   &replaced,
+		Stream: &stream,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+	}
+
+	resp, err := GetModelInfo(api.ShowRequest{Model: "derived"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(&replaced, resp.Card); diff != "" {
+		t.Errorf("card mismatch (-want +got):\n%s", diff)
+	}
+
+	cases := []struct {
+		name string
+		card api.ModelCard
+	}{
+		{"eval without benchmark", api.ModelCard{Evals: []api.ModelEval{{Score: 1}}}},
+		{"short digest", api.ModelCard{Sources: []api.ModelSource{{Digest: "sha256:1234"}}}},
+		{"digest without algorithm", api.ModelCard{Sources: []api.ModelSource{{Digest: strings.TrimPrefix(digest, "sha256:")}}}},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			w := createRequest(t, s.CreateHandler, api.CreateRequest{
+				Model:  "bad",
+				From:   "test",
+				Card:   &tt.card,
+				Stream: &stream,
+			})
+			if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "invalid model card") {
+				t.Errorf("expected status 400, got %d: %s", w.Code, w.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,214 @@
+//go:build chaos
+
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/discover"
+	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/types/model"
+)
+
+// errChaosOutOfMemory is the error of loads failed by a chaos fault. It's
+// reported like the allocation failures of GPU libraries.
+var errChaosOutOfMemory = errors.New("chaos: cudaMalloc failed: out of memory")
+
+// chaosFault is a fault injected into the model at modelPath, or into every
+// model if it's empty
+type chaosFault struct {
+	api.ChaosFault
+	modelPath string
+}
+
+// chaos is the faults injected into the models the server loads
+var chaos struct {
+	mu     sync.Mutex
+	faults []chaosFault
+}
+
+// chaosFaultFor returns the fault injected into the model at modelPath,
+// combining the faults for every model with the model's own
+func chaosFaultFor(modelPath string) api.ChaosFault {
+	chaos.mu.Lock()
+	defer chaos.mu.Unlock()
+
+	var fault api.ChaosFault
+	for _, f := range chaos.faults {
+		if f.modelPath != "" && f.modelPath != modelPath {
+			continue
+		}
+
+		if f.LoadDelay != nil {
+			fault.LoadDelay = f.LoadDelay
+		}
+
+		fault.OutOfMemory = fault.OutOfMemory || f.OutOfMemory
+		if f.CrashAfterTokens > 0 {
+			fault.CrashAfterTokens = f.CrashAfterTokens
+		}
+	}
+
+	return fault
+}
+
+// chaosServerFn returns fn with the runners it starts wrapped to inject the
+// faults set for their models
+func chaosServerFn(fn newServerFunc) newServerFunc {
+	return func(gpus discover.GpuInfoList, modelPath string, ggml *llm.GGML, adapters, projectors []string, opts api.Options, numParallel int) (llm.LlamaServer, error) {
+		s, err := fn(gpus, modelPath, ggml, adapters, projectors, opts, numParallel)
+		if err != nil {
+			return nil, err
+		}
+
+		return &chaosServer{LlamaServer: s, modelPath: modelPath}, nil
+	}
+}
+
+// chaosServer is a runner with faults injected
+type chaosServer struct {
+	llm.LlamaServer
+	modelPath string
+}
+
+func (s *chaosServer) WaitUntilRunning(ctx context.Context) error {
+	fault := chaosFaultFor(s.modelPath)
+	if fault.LoadDelay != nil {
+		slog.Warn("chaos: delaying load", "model", s.modelPath, "delay", fault.LoadDelay.Duration)
+		select {
+		case <-time.After(fault.LoadDelay.Duration):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	if fault.OutOfMemory {
+		slog.Warn("chaos: failing load", "model", s.modelPath)
+		return errChaosOutOfMemory
+	}
+
+	return s.LlamaServer.WaitUntilRunning(ctx)
+}
+
+// Completion crashes the runner once it has generated the number of tokens
+// set by the model's fault. Like a real crash, the response ends with
+// diagnostics and the runner is closed, so it's loaded again by the next
+// request.
+func (s *chaosServer) Completion(ctx context.Context, req llm.CompletionRequest, fn func(llm.CompletionResponse)) error {
+	n := chaosFaultFor(s.modelPath).CrashAfterTokens
+	if n <= 0 {
+		return s.LlamaServer.Completion(ctx, req, fn)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var tokens int
+	var crashed bool
+	err := s.LlamaServer.Completion(ctx, req, func(r llm.CompletionResponse) {
+		if crashed {
+			return
+		}
+
+		if tokens >= n {
+			crashed = true
+			cancel()
+			return
+		}
+
+		if r.Content != "" {
+			tokens++
+		}
+
+		fn(r)
+	})
+
+	if !crashed {
+		return err
+	}
+
+	slog.Warn("chaos: crashing runner", "model", s.modelPath, "tokens", tokens)
+	s.LlamaServer.Close()
+
+	fn(llm.CompletionResponse{
+		Done:        true,
+		DoneReason:  "abort",
+		Diagnostics: &api.Diagnostics{Reason: "runner_crashed", Message: fmt.Sprintf("chaos: runner crashed after %d tokens", tokens)},
+	})
+	return nil
+}
+
+// chaosRoutes adds the routes that set the faults to inject
+func (s *Server) chaosRoutes(r *gin.Engine) {
+	slog.Warn("chaos: fault injection is enabled, don't use this server in production")
+	r.GET("/api/debug/chaos", s.ChaosHandler)
+	r.PUT("/api/debug/chaos", s.SetChaosHandler)
+}
+
+// ChaosHandler returns the faults injected into models
+func (s *Server) ChaosHandler(c *gin.Context) {
+	chaos.mu.Lock()
+	defer chaos.mu.Unlock()
+
+	resp := api.Chaos{Faults: make([]api.ChaosFault, len(chaos.faults))}
+	for i, f := range chaos.faults {
+		resp.Faults[i] = f.ChaosFault
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// SetChaosHandler replaces the faults injected into models
+func (s *Server) SetChaosHandler(c *gin.Context) {
+	var req api.Chaos
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	faults := make([]chaosFault, len(req.Faults))
+	for i, f := range req.Faults {
+		if f.CrashAfterTokens < 0 || (f.LoadDelay != nil && f.LoadDelay.Duration < 0) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "faults must not be negative"})
+			return
+		}
+
+		faults[i] = chaosFault{ChaosFault: f}
+		if f.Model == "" {
+			continue
+		}
+
+		name, err := getExistingName(model.ParseName(f.Model))
+		if err != nil || !name.IsValid() {
+			c.JSON(http.StatusNotFound, errorBody(api.ErrorCodeModelNotFound, fmt.Sprintf("model '%s' not found", f.Model)))
+			return
+		}
+
+		m, err := GetModel(name.String())
+		if err != nil {
+			handleScheduleError(c, f.Model, err)
+			return
+		}
+
+		faults[i].modelPath = m.ModelPath
+	}
+
+	chaos.mu.Lock()
+	chaos.faults = faults
+	chaos.mu.Unlock()
+
+	c.Status(http.StatusOK)
+}
@@ -0,0 +1,13 @@
+//go:build !chaos
+
+package server
+
+import "github.com/gin-gonic/gin"
+
+// chaosServerFn returns fn as is, since faults are only injected in builds
+// with the chaos tag
+func chaosServerFn(fn newServerFunc) newServerFunc {
+	return fn
+}
+
+func (s *Server) chaosRoutes(*gin.Engine) {}
@@ -0,0 +1,134 @@
+//go:build chaos
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/discover"
+	"github.com/ollama/ollama/llm"
+)
+
+// closingRunner is a mock runner that records being closed
+type closingRunner struct {
+	*mockRunner
+	closed bool
+}
+
+func (r *closingRunner) WaitUntilRunning(context.Context) error { return nil }
+
+func (r *closingRunner) Close() error {
+	r.closed = true
+	return nil
+}
+
+func TestChaosServer(t *testing.T) {
+	t.Cleanup(func() { chaos.faults = nil })
+
+	runner := &closingRunner{mockRunner: &mockRunner{
+		CompletionFn: func(ctx context.Context, r llm.CompletionRequest, fn func(llm.CompletionResponse)) error {
+			for _, s := range []string{"one", "two", "three", "four"} {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+
+				fn(llm.CompletionResponse{Content: s})
+			}
+
+			fn(llm.CompletionResponse{Done: true, DoneReason: "stop"})
+			return nil
+		},
+	}}
+
+	start := chaosServerFn(func(_ discover.GpuInfoList, _ string, _ *llm.GGML, _, _ []string, _ api.Options, _ int) (llm.LlamaServer, error) {
+		return runner, nil
+	})
+
+	s, err := start(nil, "model", nil, nil, nil, api.Options{}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	complete := func() []llm.CompletionResponse {
+		t.Helper()
+
+		var resps []llm.CompletionResponse
+		if err := s.Completion(context.Background(), llm.CompletionRequest{}, func(r llm.CompletionResponse) {
+			resps = append(resps, r)
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		return resps
+	}
+
+	if resps := complete(); len(resps) != 5 || runner.closed {
+		t.Fatalf("expected the response without faults, got %+v", resps)
+	}
+
+	chaos.faults = []chaosFault{
+		{ChaosFault: api.ChaosFault{CrashAfterTokens: 2}},
+		{ChaosFault: api.ChaosFault{OutOfMemory: true}, modelPath: "other"},
+	}
+
+	resps := complete()
+	if len(resps) != 3 || resps[1].Content != "two" || !runner.closed {
+		t.Fatalf("expected the runner to crash after two tokens, got %+v", resps)
+	}
+
+	if d := resps[2].Diagnostics; !resps[2].Done || d == nil || d.Reason != "runner_crashed" {
+		t.Errorf("expected a final response with diagnostics, got %+v", resps[2])
+	}
+
+	if err := s.WaitUntilRunning(context.Background()); err != nil {
+		t.Errorf("expected faults for other models not to apply, got %v", err)
+	}
+
+	chaos.faults = []chaosFault{{ChaosFault: api.ChaosFault{OutOfMemory: true, LoadDelay: &api.Duration{Duration: 50 * time.Millisecond}}}}
+
+	begin := time.Now()
+	err = s.WaitUntilRunning(context.Background())
+	if !errors.Is(err, errChaosOutOfMemory) || errorCode(err) != api.ErrorCodeOutOfMemory {
+		t.Errorf("expected an out of memory error, got %v", err)
+	}
+
+	if time.Since(begin) < 50*time.Millisecond {
+		t.Errorf("expected the load to be delayed")
+	}
+}
+
+func TestChaosHandlers(t *testing.T) {
+	t.Cleanup(func() { chaos.faults = nil })
+
+	var s Server
+	w := createRequest(t, s.SetChaosHandler, api.Chaos{Faults: []api.ChaosFault{{CrashAfterTokens: 10}}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+	}
+
+	w = createRequest(t, s.ChaosHandler, nil)
+	var resp api.Chaos
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(resp.Faults) != 1 || resp.Faults[0].CrashAfterTokens != 10 {
+		t.Errorf("unexpected faults %+v", resp)
+	}
+
+	w = createRequest(t, s.SetChaosHandler, api.Chaos{Faults: []api.ChaosFault{{Model: "missing", OutOfMemory: true}}})
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", w.Code)
+	}
+
+	w = createRequest(t, s.SetChaosHandler, api.Chaos{Faults: []api.ChaosFault{{CrashAfterTokens: -1}}})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", w.Code)
+	}
+}
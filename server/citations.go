@@ -0,0 +1,64 @@
+package server
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/ollama/ollama/api"
+)
+
+// documentsPrompt is added to the system message when a chat request has
+// documents. Documents are numbered from 1 so the markers look like the
+// footnotes models have seen in training.
+const documentsPrompt = "Answer using the following sources. When you use a source, cite it with its number in square brackets, e.g. [1] or [1, 3]."
+
+// withDocuments adds docs to the system message of msgs, adding a system
+// message if there isn't one
+func withDocuments(msgs []api.Message, docs []api.Document) []api.Message {
+	if len(docs) == 0 {
+		return msgs
+	}
+
+	var sb strings.Builder
+	sb.WriteString(documentsPrompt)
+	for i, doc := range docs {
+		fmt.Fprintf(&sb, "\n\n[%d]", i+1)
+		if doc.Title != "" {
+			sb.WriteString(" " + doc.Title)
+		}
+		sb.WriteString("\n" + doc.Content)
+	}
+
+	if len(msgs) > 0 && msgs[0].Role == "system" {
+		return append([]api.Message{{Role: "system", Content: msgs[0].Content + "\n\n" + sb.String()}}, msgs[1:]...)
+	}
+
+	return append([]api.Message{{Role: "system", Content: sb.String()}}, msgs...)
+}
+
+var citationMarker = regexp.MustCompile(`\[(\d+(?:\s*,\s*\d+)*)\]`)
+
+// parseCitations finds the source markers in content and maps them to docs.
+// Markers for documents that don't exist are ignored.
+func parseCitations(content string, docs []api.Document) []api.Citation {
+	var citations []api.Citation
+	for _, m := range citationMarker.FindAllStringSubmatchIndex(content, -1) {
+		for _, s := range strings.Split(content[m[2]:m[3]], ",") {
+			n, err := strconv.Atoi(strings.TrimSpace(s))
+			if err != nil || n < 1 || n > len(docs) {
+				continue
+			}
+
+			citations = append(citations, api.Citation{
+				Index:      n - 1,
+				DocumentID: docs[n-1].ID,
+				Start:      m[0],
+				End:        m[1],
+			})
+		}
+	}
+
+	return citations
+}
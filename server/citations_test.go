@@ -0,0 +1,56 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestParseCitations(t *testing.T) {
+	docs := []api.Document{
+		{ID: "a", Content: "a"},
+		{ID: "b", Content: "b"},
+		{Content: "c"},
+	}
+
+	cases := []struct {
+		content string
+		want    []api.Citation
+	}{
+		{
+			content: "No sources.",
+		},
+		{
+			content: "One [1].",
+			want:    []api.Citation{{Index: 0, DocumentID: "a", Start: 4, End: 7}},
+		},
+		{
+			content: "Two [2] and [3]",
+			want: []api.Citation{
+				{Index: 1, DocumentID: "b", Start: 4, End: 7},
+				{Index: 2, Start: 12, End: 15},
+			},
+		},
+		{
+			content: "Both [1, 2].",
+			want: []api.Citation{
+				{Index: 0, DocumentID: "a", Start: 5, End: 11},
+				{Index: 1, DocumentID: "b", Start: 5, End: 11},
+			},
+		},
+		{
+			content: "Out of range [0] [4] [x] [1,9]",
+			want:    []api.Citation{{Index: 0, DocumentID: "a", Start: 25, End: 30}},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.content, func(t *testing.T) {
+			if diff := cmp.Diff(parseCitations(tt.content, docs), tt.want); diff != "" {
+				t.Errorf("mismatch (-got +want):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,235 @@
+package server
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/klauspost/compress/zstd"
+)
+
+// maxDecompressedBody bounds the size of a compressed request body once it's
+// decompressed, so a small request can't expand without limit
+const maxDecompressedBody = 512 << 20
+
+// errBodyTooLarge is returned reading a decompressed body larger than
+// maxDecompressedBody
+var errBodyTooLarge = fmt.Errorf("decompressed request body is larger than %d bytes", maxDecompressedBody)
+
+// compressionMiddleware decodes gzip and zstd request bodies and compresses
+// JSON responses for clients that accept it. Streamed responses aren't
+// compressed since compression would hold back tokens until a block fills.
+func compressionMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if enc := c.Request.Header.Get("Content-Encoding"); enc != "" && c.Request.Body != nil {
+			body, err := decodeBody(enc, c.Request.Body)
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusUnsupportedMediaType, gin.H{"error": err.Error()})
+				return
+			}
+			defer body.Close()
+
+			c.Request.Body = body
+			c.Request.ContentLength = -1
+			c.Request.Header.Del("Content-Encoding")
+			c.Request.Header.Del("Content-Length")
+		}
+
+		if c.Request.Method == http.MethodHead {
+			c.Next()
+			return
+		}
+
+		enc := acceptedEncoding(c.Request.Header.Get("Accept-Encoding"))
+		if enc == "" {
+			c.Next()
+			return
+		}
+
+		c.Writer.Header().Add("Vary", "Accept-Encoding")
+
+		w := &compressWriter{ResponseWriter: c.Writer, encoding: enc}
+		c.Writer = w
+		defer w.Close()
+
+		c.Next()
+	}
+}
+
+// decodeBody returns a reader of the decompressed body
+func decodeBody(enc string, body io.ReadCloser) (io.ReadCloser, error) {
+	switch strings.ToLower(strings.TrimSpace(enc)) {
+	case "identity":
+		return body, nil
+	case "gzip", "x-gzip":
+		zr, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gzip body: %w", err)
+		}
+
+		return &decodedBody{Reader: zr, closers: []io.Closer{zr, body}, n: maxDecompressedBody}, nil
+	case "zstd":
+		zr, err := zstd.NewReader(body, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(maxDecompressedBody))
+		if err != nil {
+			return nil, fmt.Errorf("invalid zstd body: %w", err)
+		}
+
+		return &decodedBody{Reader: zr, closers: []io.Closer{zr.IOReadCloser(), body}, n: maxDecompressedBody}, nil
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q, supported encodings are gzip and zstd", enc)
+	}
+}
+
+// decodedBody is a decompressed request body. Reads fail once more than n
+// bytes have been read.
+type decodedBody struct {
+	io.Reader
+	closers []io.Closer
+	n       int64
+}
+
+func (b *decodedBody) Read(p []byte) (int, error) {
+	if b.n <= 0 {
+		// check whether the body ends exactly at the limit
+		var buf [1]byte
+		if n, _ := b.Reader.Read(buf[:]); n > 0 {
+			return 0, errBodyTooLarge
+		}
+
+		return 0, io.EOF
+	}
+
+	if int64(len(p)) > b.n {
+		p = p[:b.n]
+	}
+
+	n, err := b.Reader.Read(p)
+	b.n -= int64(n)
+	return n, err
+}
+
+func (b *decodedBody) Close() error {
+	var errs []error
+	for _, c := range b.closers {
+		errs = append(errs, c.Close())
+	}
+
+	return errors.Join(errs...)
+}
+
+// acceptedEncoding returns the preferred response encoding in an
+// Accept-Encoding header, or "" if the client doesn't accept gzip or zstd
+func acceptedEncoding(header string) string {
+	var best string
+	var bestQ float64
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+
+		if q <= 0 {
+			continue
+		}
+
+		switch name {
+		case "zstd":
+			// zstd is preferred over gzip when both are equally acceptable
+			if q >= bestQ {
+				best, bestQ = name, q
+			}
+		case "gzip":
+			if q > bestQ {
+				best, bestQ = name, q
+			}
+		}
+	}
+
+	return best
+}
+
+// compressWriter compresses JSON responses. Whether a response is compressed
+// is decided on its first write, once the handler has set its headers.
+type compressWriter struct {
+	gin.ResponseWriter
+	encoding string
+
+	decided bool
+	w       io.WriteCloser
+}
+
+func (w *compressWriter) decide() {
+	if w.decided {
+		return
+	}
+	w.decided = true
+
+	h := w.ResponseWriter.Header()
+	if h.Get("Content-Encoding") != "" {
+		return
+	}
+
+	if mediaType, _, _ := mime.ParseMediaType(h.Get("Content-Type")); mediaType != "application/json" {
+		return
+	}
+
+	h.Set("Content-Encoding", w.encoding)
+	h.Del("Content-Length")
+
+	switch w.encoding {
+	case "zstd":
+		// the encoder only fails on invalid options
+		w.w, _ = zstd.NewWriter(w.ResponseWriter, zstd.WithEncoderConcurrency(1))
+	case "gzip":
+		w.w = gzip.NewWriter(w.ResponseWriter)
+	}
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	w.decide()
+	if w.w == nil {
+		return w.ResponseWriter.Write(b)
+	}
+
+	w.ResponseWriter.WriteHeaderNow()
+	return w.w.Write(b)
+}
+
+func (w *compressWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *compressWriter) Flush() {
+	w.decide()
+	if f, ok := w.w.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+
+	w.ResponseWriter.Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Close writes the end of the compressed response
+func (w *compressWriter) Close() error {
+	if w.w == nil {
+		return nil
+	}
+
+	return w.w.Close()
+}
@@ -0,0 +1,194 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestAcceptedEncoding(t *testing.T) {
+	cases := map[string]string{
+		"":                       "",
+		"identity":               "",
+		"br":                     "",
+		"gzip":                   "gzip",
+		"gzip, deflate, br":      "gzip",
+		"zstd":                   "zstd",
+		"gzip, zstd":             "zstd",
+		"gzip;q=1.0, zstd;q=0.5": "gzip",
+		"zstd;q=0, gzip":         "gzip",
+		"GZIP":                   "gzip",
+		"gzip;q=invalid":         "",
+	}
+
+	for header, want := range cases {
+		if got := acceptedEncoding(header); got != want {
+			t.Errorf("acceptedEncoding(%q) = %q, want %q", header, got, want)
+		}
+	}
+}
+
+func compressionRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(compressionMiddleware())
+	r.POST("/echo", func(c *gin.Context) {
+		var req map[string]any
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, req)
+	})
+	r.POST("/stream", func(c *gin.Context) {
+		ch := make(chan any, 1)
+		ch <- gin.H{"response": "hello"}
+		close(ch)
+		streamResponse(c, ch)
+	})
+	return r
+}
+
+func TestCompressedRequest(t *testing.T) {
+	body := `{"prompt":"` + strings.Repeat("a", 4096) + `"}`
+
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte(body))
+	zw.Close()
+
+	zs, _ := zstd.NewWriter(nil)
+	zbody := zs.EncodeAll([]byte(body), nil)
+
+	cases := []struct {
+		name     string
+		encoding string
+		body     []byte
+		status   int
+	}{
+		{"gzip", "gzip", gz.Bytes(), http.StatusOK},
+		{"zstd", "zstd", zbody, http.StatusOK},
+		{"identity", "identity", []byte(body), http.StatusOK},
+		{"unsupported", "br", []byte(body), http.StatusUnsupportedMediaType},
+		{"invalid gzip", "gzip", []byte(body), http.StatusUnsupportedMediaType},
+	}
+
+	r := compressionRouter()
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/echo", bytes.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Content-Encoding", tt.encoding)
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Fatalf("expected status %d, got %d: %s", tt.status, w.Code, w.Body)
+			}
+
+			if tt.status == http.StatusOK && w.Body.String() != body {
+				t.Errorf("unexpected body %.64q", w.Body)
+			}
+		})
+	}
+}
+
+func TestDecodedBodyLimit(t *testing.T) {
+	body := &decodedBody{Reader: strings.NewReader("abcd"), n: 3}
+	if _, err := io.ReadAll(body); err != errBodyTooLarge {
+		t.Fatalf("expected %v, got %v", errBodyTooLarge, err)
+	}
+
+	body = &decodedBody{Reader: strings.NewReader("abc"), n: 3}
+	if bts, err := io.ReadAll(body); err != nil || string(bts) != "abc" {
+		t.Fatalf("expected abc, got %q, %v", bts, err)
+	}
+}
+
+func TestCompressedResponse(t *testing.T) {
+	r := compressionRouter()
+	body := `{"prompt":"hello"}`
+
+	t.Run("gzip", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/echo", strings.NewReader(body))
+		req.Header.Set("Accept-Encoding", "gzip")
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if got := w.Header().Get("Content-Encoding"); got != "gzip" {
+			t.Fatalf("expected gzip encoding, got %q", got)
+		}
+
+		zr, err := gzip.NewReader(w.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if bts, _ := io.ReadAll(zr); string(bts) != body {
+			t.Errorf("unexpected body %q", bts)
+		}
+	})
+
+	t.Run("zstd", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/echo", strings.NewReader(body))
+		req.Header.Set("Accept-Encoding", "gzip, zstd")
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if got := w.Header().Get("Content-Encoding"); got != "zstd" {
+			t.Fatalf("expected zstd encoding, got %q", got)
+		}
+
+		zr, err := zstd.NewReader(w.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer zr.Close()
+
+		if bts, _ := io.ReadAll(zr); string(bts) != body {
+			t.Errorf("unexpected body %q", bts)
+		}
+	})
+
+	t.Run("not accepted", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/echo", strings.NewReader(body))
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if got := w.Header().Get("Content-Encoding"); got != "" {
+			t.Errorf("expected no encoding, got %q", got)
+		}
+
+		if w.Body.String() != body {
+			t.Errorf("unexpected body %q", w.Body)
+		}
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/stream", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if got := w.Header().Get("Content-Encoding"); got != "" {
+			t.Errorf("expected streams not to be compressed, got %q", got)
+		}
+
+		if !strings.Contains(w.Body.String(), `"response":"hello"`) {
+			t.Errorf("unexpected body %q", w.Body)
+		}
+	})
+}
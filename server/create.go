@@ -10,6 +10,7 @@ import (
 	"io"
 	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"slices"
@@ -57,6 +58,19 @@ func (s *Server) CreateHandler(c *gin.Context) {
 		return
 	}
 
+	if r.Upload != "" {
+		session, err := getUploadSession(r.Upload)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+
+		if !session.complete() {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": errUploadIncomplete.Error()})
+			return
+		}
+	}
+
 	ch := make(chan any)
 	go func() {
 		defer close(ch)
@@ -82,6 +96,12 @@ func (s *Server) CreateHandler(c *gin.Context) {
 			if err != nil {
 				ch <- gin.H{"error": err.Error()}
 			}
+
+			// models created from a model that requires its license to be
+			// accepted require it too
+			if base, err := GetModel(fromName.String()); err == nil && base.Config.RequireLicenseAcceptance {
+				r.RequireLicenseAcceptance = true
+			}
 		} else if r.Files != nil {
 			baseLayers, err = convertModelFromFiles(r.Files, baseLayers, false, fn)
 			if err != nil {
@@ -94,6 +114,11 @@ func (s *Server) CreateHandler(c *gin.Context) {
 				ch <- gin.H{"error": err.Error()}
 				return
 			}
+		} else if r.Remote != nil {
+			if u, err := url.Parse(r.Remote.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+				ch <- gin.H{"error": fmt.Sprintf("remote server %q must be an http or https url", r.Remote.URL), "status": http.StatusBadRequest}
+				return
+			}
 		} else {
 			ch <- gin.H{"error": "neither 'from' or 'files' was specified", "status": http.StatusBadRequest}
 			return
@@ -119,7 +144,7 @@ func (s *Server) CreateHandler(c *gin.Context) {
 		}
 
 		if err := createModel(r, name, baseLayers, fn); err != nil {
-			if errors.Is(err, errBadTemplate) {
+			if errors.Is(err, errBadTemplate) || errors.Is(err, errNoLicense) || errors.Is(err, errBadModelCard) {
 				ch <- gin.H{"error": err.Error(), "status": http.StatusBadRequest}
 				return
 			}
@@ -133,6 +158,10 @@ func (s *Server) CreateHandler(c *gin.Context) {
 			}
 		}
 
+		if r.Upload != "" {
+			deleteUploadSession(r.Upload)
+		}
+
 		ch <- api.ProgressResponse{Status: "success"}
 	}()
 
@@ -216,9 +245,15 @@ func convertFromSafetensors(files map[string]string, baseLayers []*layerGGML, is
 	if !isAdapter {
 		fn(api.ProgressResponse{Status: "converting model"})
 		mediaType = "application/vnd.ollama.image.model"
-		if err := convert.ConvertModel(os.DirFS(tmpDir), t); err != nil {
+
+		progress := writeProgress{stage: "converting", status: "converting model", fn: fn}
+		stop := progress.watch(t.Name(), progressInterval)
+		err := convert.ConvertModel(os.DirFS(tmpDir), t)
+		stop()
+		if err != nil {
 			return nil, err
 		}
+		progress.complete()
 	} else {
 		kv, err := kvFromLayers(baseLayers)
 		if err != nil {
@@ -226,9 +261,15 @@ func convertFromSafetensors(files map[string]string, baseLayers []*layerGGML, is
 		}
 		fn(api.ProgressResponse{Status: "converting adapter"})
 		mediaType = "application/vnd.ollama.image.adapter"
-		if err := convert.ConvertAdapter(os.DirFS(tmpDir), t, kv); err != nil {
+
+		progress := writeProgress{stage: "converting", status: "converting adapter", fn: fn}
+		stop := progress.watch(t.Name(), progressInterval)
+		err = convert.ConvertAdapter(os.DirFS(tmpDir), t, kv)
+		stop()
+		if err != nil {
 			return nil, err
 		}
+		progress.complete()
 	}
 
 	if _, err := t.Seek(0, io.SeekStart); err != nil {
@@ -318,6 +359,20 @@ func createModel(r api.CreateRequest, name model.Name, baseLayers []*layerGGML,
 		}
 	}
 
+	if r.ToolPrompt != "" {
+		layers, err = setToolPrompt(layers, r.ToolPrompt)
+		if err != nil {
+			return err
+		}
+	}
+
+	if r.Card != nil {
+		layers, err = setModelCard(layers, r.Card)
+		if err != nil {
+			return err
+		}
+	}
+
 	if r.License != nil {
 		switch l := r.License.(type) {
 		case string:
@@ -344,6 +399,16 @@ func createModel(r api.CreateRequest, name model.Name, baseLayers []*layerGGML,
 		}
 	}
 
+	if r.RequireLicenseAcceptance {
+		if !slices.ContainsFunc(layers, func(l Layer) bool { return l.MediaType == "application/vnd.ollama.image.license" }) {
+			return fmt.Errorf("%w to require accepting", errNoLicense)
+		}
+
+		config.RequireLicenseAcceptance = true
+	}
+
+	config.Remote = r.Remote
+
 	layers, err = setParameters(layers, r.Parameters)
 	if err != nil {
 		return err
@@ -375,7 +440,8 @@ func createModel(r api.CreateRequest, name model.Name, baseLayers []*layerGGML,
 
 func quantizeLayer(layer *layerGGML, quantizeType string, fn func(resp api.ProgressResponse)) (*layerGGML, error) {
 	ft := layer.GGML.KV().FileType()
-	fn(api.ProgressResponse{Status: fmt.Sprintf("quantizing %s model to %s", ft, quantizeType)})
+	status := fmt.Sprintf("quantizing %s model to %s", ft, quantizeType)
+	fn(api.ProgressResponse{Status: status})
 
 	want, err := llm.ParseFileType(quantizeType)
 	if err != nil {
@@ -394,9 +460,18 @@ func quantizeLayer(layer *layerGGML, quantizeType string, fn func(resp api.Progr
 	defer temp.Close()
 	defer os.Remove(temp.Name())
 
-	if err := llama.Quantize(blob, temp.Name(), uint32(want)); err != nil {
+	progress := writeProgress{stage: "quantizing", status: status, fn: fn}
+	if kind, ok := want.TensorKind(); ok {
+		progress.tensors = quantizeSpans(layer.GGML, kind)
+	}
+
+	stop := progress.watch(temp.Name(), progressInterval)
+	err = llama.Quantize(blob, temp.Name(), uint32(want))
+	stop()
+	if err != nil {
 		return nil, err
 	}
+	progress.complete()
 
 	newLayer, err := NewLayer(temp, layer.MediaType)
 	if err != nil {
@@ -534,6 +609,20 @@ func setSystem(layers []Layer, s string) ([]Layer, error) {
 	return layers, nil
 }
 
+func setToolPrompt(layers []Layer, t string) ([]Layer, error) {
+	layers = removeLayer(layers, "application/vnd.ollama.image.toolprompt")
+	if _, err := template.ParseToolPrompt(t); err != nil {
+		return nil, fmt.Errorf("%w: %s", errBadTemplate, err)
+	}
+
+	layer, err := NewLayer(strings.NewReader(t), "application/vnd.ollama.image.toolprompt")
+	if err != nil {
+		return nil, err
+	}
+
+	return append(layers, layer), nil
+}
+
 func setLicense(layers []Layer, l string) ([]Layer, error) {
 	blob := strings.NewReader(l)
 	layer, err := NewLayer(blob, "application/vnd.ollama.image.license")
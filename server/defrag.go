@@ -0,0 +1,140 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/discover"
+)
+
+// displacedRunner is a model unloaded to defragment VRAM that's reloaded once
+// the model that needed the space has loaded
+type displacedRunner struct {
+	model           *Model
+	opts            api.Options
+	sessionDuration time.Duration
+	loadedAt        time.Time
+}
+
+// recordLayout records the VRAM the runner allocates on each of its GPUs
+func (runner *runnerRef) recordLayout() {
+	runner.layout = make(map[string]uint64, len(runner.gpus))
+	for _, gpu := range runner.gpus {
+		runner.layout[gpu.ID] = runner.llama.EstimatedVRAMByGPU(gpu.ID)
+	}
+}
+
+// usesVRAMOn reports whether a runner has memory allocated on any of gpus
+func (runner *runnerRef) usesVRAMOn(gpus discover.GpuInfoList) bool {
+	for _, gpu := range gpus {
+		if runner.layout[gpu.ID] > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// shouldDefragment reports whether a load on gpus that ran out of memory may
+// succeed after unloading and reloading idle models on the same GPUs. Free
+// VRAM was estimated to be sufficient when the load was scheduled, so the
+// failure is likely caused by fragmentation rather than a lack of memory.
+func (s *Scheduler) shouldDefragment(req *LlmRequest, runner *runnerRef) bool {
+	if req.defragment != nil || len(runner.gpus) == 0 || runner.gpus[0].Library == "cpu" {
+		return false
+	}
+
+	s.loadedMu.Lock()
+	defer s.loadedMu.Unlock()
+	for _, r := range s.loaded {
+		if r == runner {
+			continue
+		}
+
+		r.refMu.Lock()
+		idle := r.refCount == 0 && !r.loading && r.usesVRAMOn(runner.gpus)
+		r.refMu.Unlock()
+		if idle {
+			return true
+		}
+	}
+
+	return false
+}
+
+// findRunnerToDefragment returns an idle runner on the GPUs a pending request
+// is being defragmented for, recording it to be reloaded once the request's
+// model has loaded. It returns nil once no idle runners are left.
+func (s *Scheduler) findRunnerToDefragment(pending *LlmRequest) *runnerRef {
+	if len(pending.defragment) == 0 {
+		return nil
+	}
+
+	s.loadedMu.Lock()
+	defer s.loadedMu.Unlock()
+
+	var idle []*runnerRef
+	for _, r := range s.loaded {
+		r.refMu.Lock()
+		if r.refCount == 0 && !r.loading && r.Options != nil && r.usesVRAMOn(pending.defragment) {
+			idle = append(idle, r)
+		}
+		r.refMu.Unlock()
+	}
+
+	if len(idle) == 0 {
+		pending.defragment = pending.defragment[:0]
+		return nil
+	}
+
+	// unload the most recently loaded model first
+	runner := slices.MaxFunc(idle, func(a, b *runnerRef) int { return a.loadedAt.Compare(b.loadedAt) })
+
+	runner.refMu.Lock()
+	defer runner.refMu.Unlock()
+	slog.Info("unloading model to defragment VRAM", "model", runner.modelPath, "for", pending.model.ModelPath)
+	pending.displaced = append(pending.displaced, runner.displace())
+	return runner
+}
+
+// displace returns the model, options and remaining keep alive needed to
+// reload a runner that's about to be unloaded. The refMu must already be held.
+func (runner *runnerRef) displace() displacedRunner {
+	opts := *runner.Options
+	opts.NumCtx = opts.NumCtx / max(runner.numParallel, 1)
+	sessionDuration := runner.sessionDuration
+	if !runner.expiresAt.IsZero() {
+		sessionDuration = max(time.Until(runner.expiresAt), time.Second)
+	}
+
+	return displacedRunner{
+		model:           runner.model,
+		opts:            opts,
+		sessionDuration: sessionDuration,
+		loadedAt:        runner.loadedAt,
+	}
+}
+
+// reloadDisplaced reloads models unloaded to defragment VRAM in the order
+// they were originally loaded, so their allocations are laid out the same way
+// without the gaps that caused the fragmentation
+func (s *Scheduler) reloadDisplaced(ctx context.Context, displaced []displacedRunner) {
+	slices.SortFunc(displaced, func(a, b displacedRunner) int { return a.loadedAt.Compare(b.loadedAt) })
+	for _, d := range displaced {
+		ctx, cancel := context.WithCancel(ctx)
+		successCh, errCh := s.GetRunner(ctx, d.model, d.opts, &api.Duration{Duration: d.sessionDuration})
+		select {
+		case <-successCh:
+			slog.Info("reloaded model after defragmenting VRAM", "model", d.model.ModelPath)
+		case err := <-errCh:
+			slog.Warn("failed to reload model after defragmenting VRAM", "model", d.model.ModelPath, "error", err)
+		case <-ctx.Done():
+		}
+
+		// release the runner so its keep alive timer starts
+		cancel()
+	}
+}
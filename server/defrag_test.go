@@ -0,0 +1,118 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/discover"
+	"github.com/ollama/ollama/format"
+	"github.com/ollama/ollama/llm"
+)
+
+func TestDefragment(t *testing.T) {
+	ctx, done := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer done()
+	s := InitScheduler(ctx)
+	s.getGpuFn = getGpuFn
+	s.getCpuFn = getCpuFn
+
+	a := newScenarioRequest(t, ctx, "ollama-model-a", 2*format.GigaByte, &api.Duration{Duration: time.Minute})
+	b := newScenarioRequest(t, ctx, "ollama-model-b", 4*format.GigaByte, &api.Duration{Duration: time.Minute})
+
+	var mu sync.Mutex
+	loads := make(map[string]int)
+	s.newServerFn = func(gpus discover.GpuInfoList, model string, ggml *llm.GGML, adapters []string, projectors []string, opts api.Options, numParallel int) (llm.LlamaServer, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		loads[model]++
+		switch {
+		case model == a.req.model.ModelPath:
+			return &mockLlm{estimatedVRAM: a.srv.estimatedVRAM, estimatedVRAMByGPU: a.srv.estimatedVRAMByGPU}, nil
+		case loads[model] == 1:
+			// estimated free VRAM is sufficient, but the first load fails
+			return &mockLlm{waitResp: errors.New("cudaMalloc failed: out of memory"), estimatedVRAMByGPU: b.srv.estimatedVRAMByGPU}, nil
+		default:
+			require.Equal(t, -1, opts.NumGPU, "defragmenting shouldn't reduce offload")
+			return b.srv, nil
+		}
+	}
+
+	s.Run(ctx)
+	s.pendingReqCh <- a.req
+	select {
+	case resp := <-a.req.successCh:
+		a.ctxDone()
+		require.Eventually(t, func() bool {
+			resp.refMu.Lock()
+			defer resp.refMu.Unlock()
+			return resp.refCount == 0
+		}, 200*time.Millisecond, time.Millisecond)
+	case err := <-a.req.errCh:
+		t.Fatal(err)
+	case <-ctx.Done():
+		t.Fatal("timeout")
+	}
+
+	s.pendingReqCh <- b.req
+	select {
+	case resp := <-b.req.successCh:
+		require.Equal(t, b.srv, resp.llama)
+		require.Nil(t, resp.degraded)
+	case err := <-b.req.errCh:
+		t.Fatal(err)
+	case <-ctx.Done():
+		t.Fatal("timeout")
+	}
+
+	// the idle model is reloaded once the new model has loaded
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return loads[a.req.model.ModelPath] == 2
+	}, 200*time.Millisecond, time.Millisecond)
+
+	require.Eventually(t, func() bool {
+		s.loadedMu.Lock()
+		defer s.loadedMu.Unlock()
+		return len(s.loaded) == 2
+	}, 200*time.Millisecond, time.Millisecond)
+
+	mu.Lock()
+	require.Equal(t, 2, loads[b.req.model.ModelPath])
+	mu.Unlock()
+}
+
+func TestShouldDefragment(t *testing.T) {
+	ctx, done := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer done()
+	s := InitScheduler(ctx)
+
+	gpus := discover.GpuInfoList{{ID: "0", Library: "cuda"}}
+	failed := &runnerRef{modelPath: "b", gpus: gpus}
+	req := &LlmRequest{model: &Model{ModelPath: "b"}}
+	s.loaded["b"] = failed
+	require.False(t, s.shouldDefragment(req, failed))
+
+	// models on other GPUs don't fragment the failed load's VRAM
+	other := &runnerRef{modelPath: "a", gpus: gpus, layout: map[string]uint64{"1": 10}}
+	s.loaded["a"] = other
+	require.False(t, s.shouldDefragment(req, failed))
+
+	other.layout = map[string]uint64{"0": 10}
+	require.True(t, s.shouldDefragment(req, failed))
+
+	// busy models can't be unloaded
+	other.refCount = 1
+	require.False(t, s.shouldDefragment(req, failed))
+	other.refCount = 0
+
+	// only defragment once per request
+	req.defragment = discover.GpuInfoList{}
+	require.False(t, s.shouldDefragment(req, failed))
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/discover"
+	"github.com/ollama/ollama/envconfig"
+)
+
+// capabilityEmbedding is the device assignment key for embedding models.
+// Models are either embedding models or support completion.
+const capabilityEmbedding = Capability("embedding")
+
+// deviceAssignment restricts the models with a capability to the CPU or to a
+// set of GPUs
+type deviceAssignment struct {
+	cpu bool
+
+	// threads is the number of threads used by CPU runners, or 0 for the default
+	threads int
+
+	// gpus are the IDs of GPUs models may be loaded on
+	gpus []string
+}
+
+// parseDeviceAssignments parses semicolon separated capability=device entries,
+// e.g. "embedding=cpu:4;completion=0". A device is "cpu" with an optional
+// thread count or a comma separated list of GPU IDs. Capabilities are
+// "embedding" for embedding models and "completion" for all other models.
+func parseDeviceAssignments(s string) (map[Capability]deviceAssignment, error) {
+	assignments := make(map[Capability]deviceAssignment)
+	for _, entry := range strings.Split(s, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		capability, device, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid device assignment %q: expected capability=device", entry)
+		}
+
+		c := Capability(strings.ToLower(strings.TrimSpace(capability)))
+		if c != CapabilityCompletion && c != capabilityEmbedding {
+			return nil, fmt.Errorf("invalid device assignment %q: unknown capability %q", entry, capability)
+		}
+
+		var a deviceAssignment
+		device = strings.TrimSpace(device)
+		if name, threads, _ := strings.Cut(device, ":"); strings.EqualFold(name, "cpu") {
+			a.cpu = true
+			if threads != "" {
+				n, err := strconv.Atoi(threads)
+				if err != nil || n <= 0 {
+					return nil, fmt.Errorf("invalid device assignment %q: invalid thread count %q", entry, threads)
+				}
+
+				a.threads = n
+			}
+		} else {
+			for _, id := range strings.Split(device, ",") {
+				if id = strings.TrimSpace(id); id != "" {
+					a.gpus = append(a.gpus, id)
+				}
+			}
+
+			if len(a.gpus) == 0 {
+				return nil, fmt.Errorf("invalid device assignment %q: no device", entry)
+			}
+		}
+
+		assignments[c] = a
+	}
+
+	return assignments, nil
+}
+
+// assignDevices applies the device assignment configured with
+// OLLAMA_CAPABILITY_DEVICES for model to opts and returns the GPU IDs the
+// model is restricted to, if any
+func assignDevices(model *Model, opts *api.Options) []string {
+	s := envconfig.CapabilityDevices()
+	if s == "" {
+		return nil
+	}
+
+	assignments, err := parseDeviceAssignments(s)
+	if err != nil {
+		slog.Warn("ignoring OLLAMA_CAPABILITY_DEVICES", "error", err)
+		return nil
+	}
+
+	capability := CapabilityCompletion
+	if model.CheckCapabilities(CapabilityCompletion) != nil {
+		capability = capabilityEmbedding
+	}
+
+	a, ok := assignments[capability]
+	if !ok {
+		return nil
+	}
+
+	if a.cpu {
+		opts.NumGPU = 0
+		if a.threads > 0 && opts.NumThread == 0 {
+			opts.NumThread = a.threads
+		}
+
+		return nil
+	}
+
+	return a.gpus
+}
+
+// filterDevices returns the GPUs in gpus with one of ids. If none match, all
+// of gpus are returned so the model can still be loaded.
+func filterDevices(gpus discover.GpuInfoList, ids []string) discover.GpuInfoList {
+	if len(ids) == 0 {
+		return gpus
+	}
+
+	var filtered discover.GpuInfoList
+	for _, g := range gpus {
+		if slices.Contains(ids, g.ID) {
+			filtered = append(filtered, g)
+		}
+	}
+
+	if len(filtered) == 0 {
+		slog.Warn("no GPUs match device assignment, using all GPUs", "devices", ids)
+		return gpus
+	}
+
+	return filtered
+}
+
+// usesDevices reports whether a runner is loaded on any of gpus
+func (runner *runnerRef) usesDevices(gpus discover.GpuInfoList) bool {
+	for _, g := range runner.gpus {
+		if slices.ContainsFunc(gpus, func(o discover.GpuInfo) bool { return o.Library == g.Library && o.ID == g.ID }) {
+			return true
+		}
+	}
+
+	return false
+}
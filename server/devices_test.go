@@ -0,0 +1,117 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/discover"
+)
+
+func TestParseDeviceAssignments(t *testing.T) {
+	cases := []struct {
+		in   string
+		want map[Capability]deviceAssignment
+		err  bool
+	}{
+		{in: "", want: map[Capability]deviceAssignment{}},
+		{in: "embedding=cpu", want: map[Capability]deviceAssignment{capabilityEmbedding: {cpu: true}}},
+		{
+			in: " embedding = CPU:4 ; completion=0 ",
+			want: map[Capability]deviceAssignment{
+				capabilityEmbedding:  {cpu: true, threads: 4},
+				CapabilityCompletion: {gpus: []string{"0"}},
+			},
+		},
+		{in: "completion=GPU-1234,GPU-5678", want: map[Capability]deviceAssignment{CapabilityCompletion: {gpus: []string{"GPU-1234", "GPU-5678"}}}},
+		{in: "embedding", err: true},
+		{in: "vision=cpu", err: true},
+		{in: "embedding=cpu:0", err: true},
+		{in: "embedding=cpu:x", err: true},
+		{in: "embedding=", err: true},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseDeviceAssignments(tt.in)
+			if tt.err {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestAssignDevices(t *testing.T) {
+	ctx, done := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer done()
+
+	a := newScenarioRequest(t, ctx, "ollama-model-1", 10, nil)
+
+	t.Setenv("OLLAMA_CAPABILITY_DEVICES", "completion=cpu:2")
+	opts := api.DefaultOptions()
+	require.Nil(t, assignDevices(a.req.model, &opts))
+	require.Equal(t, 0, opts.NumGPU)
+	require.Equal(t, 2, opts.NumThread)
+
+	// explicit thread counts are kept
+	opts = api.DefaultOptions()
+	opts.NumThread = 8
+	assignDevices(a.req.model, &opts)
+	require.Equal(t, 8, opts.NumThread)
+
+	t.Setenv("OLLAMA_CAPABILITY_DEVICES", "completion=1,2")
+	opts = api.DefaultOptions()
+	require.Equal(t, []string{"1", "2"}, assignDevices(a.req.model, &opts))
+	require.Equal(t, -1, opts.NumGPU)
+
+	t.Setenv("OLLAMA_CAPABILITY_DEVICES", "embedding=cpu")
+	opts = api.DefaultOptions()
+	require.Nil(t, assignDevices(a.req.model, &opts))
+	require.Equal(t, api.DefaultOptions(), opts)
+
+	t.Setenv("OLLAMA_CAPABILITY_DEVICES", "invalid")
+	require.Nil(t, assignDevices(a.req.model, &opts))
+}
+
+func TestFilterDevices(t *testing.T) {
+	gpus := discover.GpuInfoList{
+		{Library: "cuda", ID: "0"},
+		{Library: "cuda", ID: "1"},
+	}
+
+	require.Equal(t, gpus, filterDevices(gpus, nil))
+	require.Equal(t, gpus[1:], filterDevices(gpus, []string{"1"}))
+	require.Equal(t, gpus, filterDevices(gpus, []string{"2"}))
+}
+
+func TestFindRunnerToUnloadOn(t *testing.T) {
+	ctx, done := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer done()
+
+	gpus := discover.GpuInfoList{
+		{Library: "cuda", ID: "0"},
+		{Library: "cuda", ID: "1"},
+	}
+
+	chat := &runnerRef{sessionDuration: 1, numParallel: 1, gpus: gpus[:1]}
+	embed := &runnerRef{sessionDuration: 2, numParallel: 1, gpus: gpus[1:]}
+
+	s := InitScheduler(ctx)
+	s.loadedMu.Lock()
+	s.loaded["chat"] = chat
+	s.loaded["embed"] = embed
+	s.loadedMu.Unlock()
+
+	require.Equal(t, chat, s.findRunnerToUnload())
+	require.Equal(t, embed, s.findRunnerToUnloadOn(gpus[1:]))
+
+	// fall back to any runner if none are on the requested GPUs
+	require.Equal(t, chat, s.findRunnerToUnloadOn(discover.GpuInfoList{{Library: "cuda", ID: "2"}}))
+}
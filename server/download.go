@@ -39,6 +39,11 @@ type blobDownload struct {
 	Name   string
 	Digest string
 
+	// registry is the host of the registry the blob is pulled from, whose
+	// configuration applies to the requests for it even once they're
+	// redirected to another host
+	registry string
+
 	Total     int64
 	Completed atomic.Int64
 
@@ -256,10 +261,15 @@ func (b *blobDownload) run(ctx context.Context, requestURL *url.URL, opts *regis
 				continue
 			}
 			defer resp.Body.Close()
-			if resp.StatusCode != http.StatusTemporaryRedirect && resp.StatusCode != http.StatusOK {
+			switch resp.StatusCode {
+			case http.StatusTemporaryRedirect:
+				return resp.Location()
+			case http.StatusOK:
+				// the blob is served directly, e.g. by a registry cache
+				return resp.Request.URL, nil
+			default:
 				return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
 			}
-			return resp.Location()
 		}
 	}()
 	if err != nil {
@@ -330,13 +340,19 @@ func (b *blobDownload) downloadChunk(ctx context.Context, requestURL *url.URL, w
 			return err
 		}
 		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", part.StartsAt(), part.StopsAt()-1))
-		resp, err := http.DefaultClient.Do(req)
+
+		c, err := registryClient(b.registry)
+		if err != nil {
+			return err
+		}
+
+		resp, err := c.Do(req)
 		if err != nil {
 			return err
 		}
 		defer resp.Body.Close()
 
-		n, err := io.CopyN(w, io.TeeReader(resp.Body, part), part.Size-part.Completed.Load())
+		n, err := io.CopyN(w, io.TeeReader(transferLimiter().Reader(ctx, resp.Body), part), part.Size-part.Completed.Load())
 		if err != nil && !errors.Is(err, context.Canceled) && !errors.Is(err, io.ErrUnexpectedEOF) {
 			// rollback progress
 			b.Completed.Add(-n)
@@ -481,18 +497,33 @@ func downloadBlob(ctx context.Context, opts downloadOpts) (cacheHit bool, _ erro
 		return true, nil
 	}
 
-	data, ok := blobDownloadManager.LoadOrStore(opts.digest, &blobDownload{Name: fp, Digest: opts.digest})
+	data, ok := blobDownloadManager.LoadOrStore(opts.digest, &blobDownload{Name: fp, Digest: opts.digest, registry: opts.mp.Registry})
 	download := data.(*blobDownload)
 	if !ok {
 		requestURL := opts.mp.BaseURL()
 		requestURL = requestURL.JoinPath("v2", opts.mp.GetNamespaceRepository(), "blobs", opts.digest)
-		if err := download.Prepare(ctx, requestURL, opts.regOpts); err != nil {
-			blobDownloadManager.Delete(opts.digest)
-			return false, err
+		regOpts := opts.regOpts
+
+		var prepared bool
+		if mirror := registryMirror(opts.mp.Registry); mirror != nil {
+			mirrorURL := mirror.JoinPath("v2", opts.mp.GetNamespaceRepository(), "blobs", opts.digest)
+			mirrorOpts := mirrorOptions(opts.mp.Registry)
+			if err := download.Prepare(ctx, mirrorURL, mirrorOpts); err != nil {
+				slog.Warn("registry mirror unavailable, pulling from registry", "mirror", mirror, "error", err)
+			} else {
+				requestURL, regOpts, prepared = mirrorURL, mirrorOpts, true
+			}
+		}
+
+		if !prepared {
+			if err := download.Prepare(ctx, requestURL, regOpts); err != nil {
+				blobDownloadManager.Delete(opts.digest)
+				return false, err
+			}
 		}
 
 		//nolint:contextcheck
-		go download.Run(context.Background(), requestURL, opts.regOpts)
+		go download.Run(context.Background(), requestURL, regOpts)
 	}
 
 	return false, download.Wait(ctx, opts.fn)
@@ -0,0 +1,344 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/discover"
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/runners"
+	"github.com/ollama/ollama/version"
+)
+
+// Options configures a server created with New. Zero values fall back to the
+// matching OLLAMA_* environment variables. Like those variables, options apply
+// to the whole process until the server's Run returns, so New fails while
+// another server is created and not yet stopped.
+type Options struct {
+	// ModelsDir is the directory models are stored in (OLLAMA_MODELS)
+	ModelsDir string
+
+	// Listeners are the listeners the server serves the API on. A server
+	// without listeners is only reachable through its Client.
+	Listeners []net.Listener
+
+	// MaxLoadedModels is the maximum number of models loaded at once
+	// (OLLAMA_MAX_LOADED_MODELS)
+	MaxLoadedModels int
+
+	// NumParallel is the number of requests each model serves in parallel
+	// (OLLAMA_NUM_PARALLEL)
+	NumParallel int
+
+	// MaxQueue is the maximum number of requests waiting for a model
+	// (OLLAMA_MAX_QUEUE)
+	MaxQueue int
+
+	// Store is the storage backend models are mirrored to (OLLAMA_STORE)
+	Store Store
+}
+
+// errServerExists is returned by New while another server hasn't stopped
+var errServerExists = errors.New("another server is already running in this process")
+
+// serverExists is set from New until the server's Run returns
+var serverExists atomic.Bool
+
+// apply sets the environment variables and store the options override. The
+// returned function restores them.
+func (o Options) apply() (func(), error) {
+	vars := map[string]string{}
+	if o.ModelsDir != "" {
+		vars["OLLAMA_MODELS"] = o.ModelsDir
+	}
+	if o.MaxLoadedModels > 0 {
+		vars["OLLAMA_MAX_LOADED_MODELS"] = strconv.Itoa(o.MaxLoadedModels)
+	}
+	if o.NumParallel > 0 {
+		vars["OLLAMA_NUM_PARALLEL"] = strconv.Itoa(o.NumParallel)
+	}
+	if o.MaxQueue > 0 {
+		vars["OLLAMA_MAX_QUEUE"] = strconv.Itoa(o.MaxQueue)
+	}
+
+	prev := map[string]*string{}
+	restore := func() {
+		for k, v := range prev {
+			if v != nil {
+				os.Setenv(k, *v)
+			} else {
+				os.Unsetenv(k)
+			}
+		}
+
+		storeOverride.mu.Lock()
+		storeOverride.store = nil
+		storeOverride.mu.Unlock()
+	}
+
+	for k, v := range vars {
+		if old, ok := os.LookupEnv(k); ok {
+			prev[k] = &old
+		} else {
+			prev[k] = nil
+		}
+
+		if err := os.Setenv(k, v); err != nil {
+			restore()
+			return nil, err
+		}
+	}
+
+	storeOverride.mu.Lock()
+	storeOverride.store = o.Store
+	storeOverride.mu.Unlock()
+	return restore, nil
+}
+
+// New creates a server for embedding Ollama in another program. It prepares
+// the models directory but doesn't load any models or serve requests until
+// Run is called. Only one server can exist in a process at a time, so a
+// server that's created must be run.
+func New(opts Options) (_ *Server, err error) {
+	if !serverExists.CompareAndSwap(false, true) {
+		return nil, errServerExists
+	}
+
+	restore, err := opts.apply()
+	if err != nil {
+		serverExists.Store(false)
+		return nil, err
+	}
+
+	defer func() {
+		if err != nil {
+			restore()
+			serverExists.Store(false)
+		}
+	}()
+
+	if err := prepareModels(); err != nil {
+		return nil, err
+	}
+
+	preload, err := readPreloadManifest()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{lns: opts.Listeners, preloadManifest: preload, pipe: newPipeListener(), restore: restore}
+	if len(opts.Listeners) > 0 {
+		s.addr = opts.Listeners[0].Addr()
+	}
+	if preload != nil {
+		s.preloadState = &preloadState{}
+	}
+
+	s.memories, err = readMemories()
+	if err != nil {
+		slog.Warn("failed to read memories, starting without any", "error", err)
+		s.memories = newMemoryStore()
+	}
+
+	if envconfig.PredictivePreload() {
+		s.usage, err = readUsageHistory()
+		if err != nil {
+			slog.Warn("failed to read usage history, starting a new one", "error", err)
+			s.usage = newUsageHistory()
+		}
+	}
+
+	return s, nil
+}
+
+// Run serves the API on the server's listeners until ctx is done, then
+// unloads every model and restores the environment its options overrode. It
+// returns nil once ctx is done, or the error of a listener that failed.
+func (s *Server) Run(ctx context.Context) error {
+	if s.restore != nil {
+		defer func() {
+			s.restore()
+			s.restore = nil
+			serverExists.Store(false)
+		}()
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	s.sched = InitScheduler(ctx)
+
+	handler := s.mux
+	if handler == nil {
+		handler = s.GenerateRoutes()
+	}
+
+	lns := append([]net.Listener{s.pipe}, s.lns...)
+	srvrs := make([]*http.Server, len(lns))
+	for i, ln := range lns {
+		if ln != s.pipe {
+			slog.Info(fmt.Sprintf("Listening on %s (version %s)", ln.Addr(), version.Version))
+		}
+		srvrs[i] = &http.Server{Handler: listenerHandler(ln, handler)}
+		configureHTTP(srvrs[i])
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-stopped:
+		}
+		for _, srvr := range srvrs {
+			srvr.Close()
+		}
+	}()
+
+	// Locate and log what runners are present at startup
+	var runnerNames []string
+	for v := range runners.GetAvailableServers() {
+		runnerNames = append(runnerNames, v)
+	}
+	slog.Info("Dynamic LLM libraries", "runners", runnerNames)
+	slog.Debug("Override detection logic by setting OLLAMA_LLM_LIBRARY")
+
+	s.sched.Run(ctx)
+
+	// At startup we retrieve GPU information so we can get log messages before loading a model
+	// This will log warnings to the log in case we have problems with detected GPUs
+	gpus := discover.GetGPUInfo()
+	gpus.LogDetails()
+
+	if s.preloadManifest != nil {
+		go s.preload(ctx, s.preloadManifest)
+	}
+
+	if s.usage != nil {
+		go s.predictivePreload(ctx)
+	}
+
+	go expire(ctx)
+
+	err := serveAll(srvrs, lns)
+	close(stopped)
+	cancel()
+	s.sched.unloadAllRunners()
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
+
+// Client returns a client for the server's API that doesn't go through its
+// listeners, for managing and running models in-process. Requests made with
+// it wait for the server to run, and fail once it has stopped.
+func (s *Server) Client() *api.Client {
+	base := &url.URL{Scheme: "http", Host: "ollama.internal"}
+	return api.NewClient(base, &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return s.pipe.dial(ctx)
+			},
+		},
+	})
+}
+
+// prepareModels fixes blobs left by older versions and prunes unused layers
+// from the models directory
+func prepareModels() error {
+	blobsDir, err := GetBlobsPath("")
+	if err != nil {
+		return err
+	}
+	if err := fixBlobs(blobsDir); err != nil {
+		return err
+	}
+
+	if err := validateSharedBlobs(); err != nil {
+		return err
+	}
+
+	if !envconfig.NoPrune() {
+		if _, err := Manifests(false); err != nil {
+			slog.Warn("corrupt manifests detected, skipping prune operation.  Re-pull or delete to clear", "error", err)
+		} else {
+			// clean up unused layers and manifests
+			if err := PruneLayers(); err != nil {
+				return err
+			}
+
+			manifestsPath, err := GetManifestPath()
+			if err != nil {
+				return err
+			}
+
+			if err := PruneDirectory(manifestsPath); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// pipeListener is a listener for in-process connections made with dial
+type pipeListener struct {
+	conns chan net.Conn
+
+	once   sync.Once
+	closed chan struct{}
+}
+
+func newPipeListener() *pipeListener {
+	return &pipeListener{conns: make(chan net.Conn), closed: make(chan struct{})}
+}
+
+func (l *pipeListener) dial(ctx context.Context) (net.Conn, error) {
+	client, server := net.Pipe()
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.closed:
+		client.Close()
+		server.Close()
+		return nil, net.ErrClosed
+	case <-ctx.Done():
+		client.Close()
+		server.Close()
+		return nil, ctx.Err()
+	}
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *pipeListener) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr {
+	return pipeAddr{}
+}
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
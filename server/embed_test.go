@@ -0,0 +1,100 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ollama/ollama/envconfig"
+)
+
+func TestEmbeddedServer(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", "")
+	t.Setenv("OLLAMA_NUM_PARALLEL", "")
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := New(Options{ModelsDir: dir, Listeners: []net.Listener{ln}, NumParallel: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if envconfig.Models() != dir {
+		t.Errorf("models = %q; want %q", envconfig.Models(), dir)
+	}
+	if envconfig.NumParallel() != 2 {
+		t.Errorf("num parallel = %d; want 2", envconfig.NumParallel())
+	}
+
+	if _, err := New(Options{ModelsDir: t.TempDir()}); !errors.Is(err, errServerExists) {
+		t.Errorf("expected a second server to be rejected, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- s.Run(ctx)
+	}()
+
+	client := s.Client()
+	if _, err := client.Version(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := client.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Models) != 0 {
+		t.Errorf("models = %v; want none", list.Models)
+	}
+
+	resp, err := http.Get("http://" + ln.Addr().String() + "/api/version")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d; want 200", resp.StatusCode)
+	}
+
+	cancel()
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server didn't stop")
+	}
+
+	if _, err := client.Version(context.Background()); err == nil {
+		t.Error("expected an error from a stopped server")
+	}
+
+	// the options only apply while the server runs
+	if envconfig.Models() == dir {
+		t.Errorf("expected the models directory to be restored, got %q", envconfig.Models())
+	}
+	if envconfig.NumParallel() != 0 {
+		t.Errorf("num parallel = %d; want 0", envconfig.NumParallel())
+	}
+
+	s, err = New(Options{ModelsDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("expected a server once the last stopped, got %v", err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if err := s.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/ollama/ollama/llm"
+)
+
+var (
+	errDimensions      = errors.New("invalid dimensions")
+	errContextExceeded = errors.New("input length exceeds maximum context length")
+)
+
+// checkDimensions checks that embeddings of length can be truncated to
+// dimensions. A length of 0 is unknown.
+func checkDimensions(dimensions, length int) error {
+	if dimensions < 0 {
+		return fmt.Errorf("%w: %d must be positive", errDimensions, dimensions)
+	}
+
+	if length > 0 && dimensions > length {
+		return fmt.Errorf("%w: %d is more than the model's %d", errDimensions, dimensions, length)
+	}
+
+	return nil
+}
+
+// fitContext truncates the end of each input to fit ctxLen tokens along with
+// the special tokens the runner adds, returning the number of tokens in each.
+// Inputs that don't fit are an error unless truncate is set.
+func fitContext(ctx context.Context, r llm.LlamaServer, kv llm.KV, input []string, ctxLen int, truncate bool) ([]int, error) {
+	bos, addBOS := kv.SpecialToken("bos")
+	addBOS = addBOS && kv.AddSpecialToken("bos")
+
+	var special int
+	if addBOS {
+		special++
+	}
+	if kv.AddSpecialToken("eos") {
+		special++
+	}
+
+	if ctxLen <= special {
+		return nil, errContextExceeded
+	}
+
+	counts := make([]int, len(input))
+	for i, s := range input {
+		tokens, err := r.Tokenize(ctx, s)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(tokens) > ctxLen-special {
+			if !truncate {
+				return nil, errContextExceeded
+			}
+
+			// the runner adds its own BOS, so one rendered back into the
+			// text would be doubled
+			for addBOS && len(tokens) > 0 && tokens[0] == bos {
+				tokens = tokens[1:]
+			}
+
+			tokens = tokens[:min(len(tokens), ctxLen-special)]
+			s, err = r.Detokenize(ctx, tokens)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		counts[i] = len(tokens) + special
+		input[i] = s
+	}
+
+	return counts, nil
+}
+
+// embedBatches splits inputs of counts tokens into micro-batches of at most
+// size tokens, so large requests don't queue more than the runner's context
+// holds. Inputs of size tokens or more are batched alone.
+func embedBatches(counts []int, size int) [][]int {
+	var batches [][]int
+	var batch []int
+	var total int
+	for i, count := range counts {
+		if len(batch) > 0 && total+count > size {
+			batches = append(batches, batch)
+			batch, total = nil, 0
+		}
+
+		batch = append(batch, i)
+		total += count
+	}
+
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+
+	return batches
+}
+
+// embed returns the normalized embeddings of input, truncated to dimensions
+// if set
+func embed(ctx context.Context, r llm.LlamaServer, input []string, batches [][]int, dimensions int) ([][]float32, error) {
+	embeddings := make([][]float32, len(input))
+	err := forBatches(ctx, batches, func(ctx context.Context, i int) error {
+		embedding, err := r.Embedding(ctx, input[i])
+		if err != nil {
+			return err
+		}
+
+		if dimensions > 0 && dimensions < len(embedding) {
+			embedding = embedding[:dimensions]
+		}
+
+		embeddings[i] = normalize(embedding)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return embeddings, nil
+}
+
+// forBatches calls fn with each input of batches, concurrently within a batch,
+// starting each batch once the last is done
+func forBatches(ctx context.Context, batches [][]int, fn func(context.Context, int) error) error {
+	for _, batch := range batches {
+		g, ctx := errgroup.WithContext(ctx)
+		for _, i := range batch {
+			g.Go(func() error { return fn(ctx, i) })
+		}
+
+		if err := g.Wait(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+)
+
+// wordTokenizer tokenizes each word of its input as its index in vocab
+type wordTokenizer struct {
+	llm.LlamaServer
+	vocab []string
+}
+
+func (w wordTokenizer) Tokenize(_ context.Context, s string) ([]int, error) {
+	var tokens []int
+	for _, word := range strings.Fields(s) {
+		tokens = append(tokens, slices.Index(w.vocab, word))
+	}
+	return tokens, nil
+}
+
+func (w wordTokenizer) Detokenize(_ context.Context, tokens []int) (string, error) {
+	words := make([]string, len(tokens))
+	for i, token := range tokens {
+		words[i] = w.vocab[token]
+	}
+	return strings.Join(words, " "), nil
+}
+
+func TestFitContext(t *testing.T) {
+	r := wordTokenizer{vocab: []string{"<s>", "</s>", "a", "b", "c", "d"}}
+	bert := llm.KV{
+		"tokenizer.ggml.bos_token_id":  uint32(0),
+		"tokenizer.ggml.eos_token_id":  uint32(1),
+		"tokenizer.ggml.add_eos_token": true,
+	}
+
+	cases := []struct {
+		name       string
+		kv         llm.KV
+		input      []string
+		ctxLen     int
+		truncate   bool
+		want       []string
+		wantCounts []int
+		wantErr    error
+	}{
+		{"fits", llm.KV{}, []string{"a b c"}, 3, false, []string{"a b c"}, []int{3}, nil},
+		{"truncated", llm.KV{}, []string{"a b c d"}, 3, true, []string{"a b c"}, []int{3}, nil},
+		{"exceeded", llm.KV{}, []string{"a b c d"}, 3, false, nil, nil, errContextExceeded},
+		{"special tokens", bert, []string{"a b c"}, 5, false, []string{"a b c"}, []int{5}, nil},
+		{"special tokens exceeded", bert, []string{"a b c d"}, 5, false, nil, nil, errContextExceeded},
+		{"special tokens truncated", bert, []string{"a b c d"}, 4, true, []string{"a b"}, []int{4}, nil},
+		{"bos truncated", bert, []string{"<s> a b c d"}, 4, true, []string{"a b"}, []int{4}, nil},
+		{"no room", bert, []string{"a"}, 2, true, nil, nil, errContextExceeded},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			input := slices.Clone(tt.input)
+			counts, err := fitContext(context.Background(), r, tt.kv, input, tt.ctxLen, tt.truncate)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			} else if err != nil {
+				return
+			}
+
+			if diff := cmp.Diff(tt.want, input); diff != "" {
+				t.Errorf("input mismatch (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tt.wantCounts, counts); diff != "" {
+				t.Errorf("counts mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestEmbedBatches(t *testing.T) {
+	cases := []struct {
+		counts []int
+		size   int
+		want   [][]int
+	}{
+		{nil, 8, nil},
+		{[]int{2, 3, 3}, 8, [][]int{{0, 1, 2}}},
+		{[]int{2, 3, 4, 1}, 8, [][]int{{0, 1}, {2, 3}}},
+		{[]int{9, 1, 8, 1}, 8, [][]int{{0}, {1}, {2}, {3}}},
+	}
+
+	for _, tt := range cases {
+		if diff := cmp.Diff(tt.want, embedBatches(tt.counts, tt.size)); diff != "" {
+			t.Errorf("%v: mismatch (-want +got):\n%s", tt.counts, diff)
+		}
+	}
+}
+
+func TestEmbedDimensions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mock := mockRunner{
+		EmbeddingFn: func(context.Context, string) ([]float32, error) {
+			return []float32{3, 4, 12, 0}, nil
+		},
+	}
+
+	s := Server{sched: newMockScheduler(t, &mock)}
+	createMockModel(t, &s, "test", "")
+
+	t.Run("truncated", func(t *testing.T) {
+		w := createRequest(t, s.EmbedHandler, api.EmbedRequest{
+			Model:      "test",
+			Input:      []string{"one two", "three"},
+			Dimensions: 2,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		var resp api.EmbedResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, embedding := range resp.Embeddings {
+			if len(embedding) != 2 || math.Abs(float64(embedding[0])-.6) > 1e-6 || math.Abs(float64(embedding[1])-.8) > 1e-6 {
+				t.Errorf("expected [0.6 0.8], got %v", embedding)
+			}
+		}
+
+		if diff := cmp.Diff([]int{2, 1}, resp.PromptEvalCounts); diff != "" || resp.PromptEvalCount != 3 {
+			t.Errorf("unexpected counts %d %v", resp.PromptEvalCount, resp.PromptEvalCounts)
+		}
+	})
+
+	for _, dimensions := range []int{-1, 5000} {
+		w := createRequest(t, s.EmbedHandler, api.EmbedRequest{
+			Model:      "test",
+			Input:      "one",
+			Dimensions: dimensions,
+		})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%d: expected status 400, got %d: %s", dimensions, w.Code, w.Body.String())
+		}
+	}
+
+	if err := checkDimensions(4096, 4096); err != nil {
+		t.Error(err)
+	}
+
+	if err := checkDimensions(8, 4); !errors.Is(err, errDimensions) {
+		t.Errorf("expected errDimensions, got %v", err)
+	}
+}
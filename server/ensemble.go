@@ -0,0 +1,248 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+)
+
+// maxEnsembleCandidates is the largest number of answers an ensemble request
+// can ask for
+const maxEnsembleCandidates = 16
+
+const judgeSelectPrompt = `You are judging answers to the last message of a conversation. Score each answer from 0 to 10 for correctness, helpfulness and clarity. Respond with JSON: {"scores": [<score of answer 1>, <score of answer 2>, ...]}`
+
+const judgeMergePrompt = `You are judging answers to the last message of a conversation. Score each answer from 0 to 10 for correctness, helpfulness and clarity, then write the best possible answer, combining the strengths of the answers and correcting their mistakes. Respond with JSON: {"scores": [<score of answer 1>, <score of answer 2>, ...], "answer": "<the combined answer>"}`
+
+// judgeFormat constrains the judge's response to the verdict
+var judgeFormat = json.RawMessage(`{"type":"object","properties":{"scores":{"type":"array","items":{"type":"number"}},"answer":{"type":"string"}},"required":["scores"]}`)
+
+type verdict struct {
+	Scores []float64 `json:"scores"`
+	Answer string    `json:"answer"`
+}
+
+// EnsembleHandler answers a chat with several models and has a judge model
+// select or merge the best answer
+func (s *Server) EnsembleHandler(c *gin.Context) {
+	checkpointStart := time.Now()
+
+	var req api.EnsembleRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	samples := max(req.Samples, 1)
+	switch {
+	case len(req.Models) == 0:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "models are required"})
+		return
+	case req.Judge == "":
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "judge is required"})
+		return
+	case len(req.Messages) == 0:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "messages are required"})
+		return
+	case len(req.Models)*samples > maxEnsembleCandidates:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("at most %d answers can be requested", maxEnsembleCandidates)})
+		return
+	}
+
+	for _, name := range append(req.Models, req.Judge) {
+		if _, err := GetModel(name); err != nil {
+			handleScheduleError(c, name, err)
+			return
+		}
+	}
+
+	candidates := make([]api.EnsembleCandidate, 0, len(req.Models)*samples)
+	for _, m := range req.Models {
+		for range samples {
+			candidates = append(candidates, api.EnsembleCandidate{Model: m})
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := range candidates {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			cr, err := s.chatCompletion(c.Request.Context(), candidates[i].Model, req.Messages, nil, nil, sampleOptions(req.Options, i), req.KeepAlive)
+			if err != nil {
+				slog.Warn("ensemble candidate failed", "model", candidates[i].Model, "error", err)
+				candidates[i].Error = err.Error()
+				return
+			}
+
+			candidates[i].Message = api.Message{Role: "assistant", Content: cr.Content}
+		}(i)
+	}
+	wg.Wait()
+
+	var answered []int
+	for i, candidate := range candidates {
+		if candidate.Error == "" {
+			answered = append(answered, i)
+		}
+	}
+
+	if len(answered) == 0 {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "no model answered: " + candidates[0].Error})
+		return
+	}
+
+	v, err := s.judge(c.Request.Context(), req, candidates, answered)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp := api.EnsembleResponse{
+		Judge:      req.Judge,
+		CreatedAt:  time.Now().UTC(),
+		Winner:     answered[0],
+		Candidates: candidates,
+	}
+
+	for i, n := range answered {
+		candidates[n].Score = v.Scores[i]
+		if candidates[n].Score > candidates[resp.Winner].Score {
+			resp.Winner = n
+		}
+	}
+
+	resp.Message = candidates[resp.Winner].Message
+	if req.Merge {
+		resp.Message = api.Message{Role: "assistant", Content: v.Answer}
+	}
+
+	resp.TotalDuration = time.Since(checkpointStart)
+	c.JSON(http.StatusOK, resp)
+}
+
+// sampleOptions returns the options for the ith answer. Answers get
+// different seeds so samples of a model with a fixed seed aren't the same.
+func sampleOptions(opts map[string]any, i int) map[string]any {
+	seed, ok := opts["seed"].(float64)
+	if !ok || i == 0 {
+		return opts
+	}
+
+	opts = maps.Clone(opts)
+	opts["seed"] = seed + float64(i)
+	return opts
+}
+
+// judge asks the judge model to score the answered candidates
+func (s *Server) judge(ctx context.Context, req api.EnsembleRequest, candidates []api.EnsembleCandidate, answered []int) (*verdict, error) {
+	var sb strings.Builder
+	sb.WriteString("Conversation:\n")
+	for _, msg := range req.Messages {
+		fmt.Fprintf(&sb, "\n%s: %s\n", msg.Role, msg.Content)
+	}
+
+	sb.WriteString("\nAnswers:\n")
+	for i, n := range answered {
+		fmt.Fprintf(&sb, "\n[%d]\n%s\n", i+1, candidates[n].Message.Content)
+	}
+
+	prompt := judgeSelectPrompt
+	if req.Merge {
+		prompt = judgeMergePrompt
+	}
+
+	msgs := []api.Message{
+		{Role: "system", Content: prompt},
+		{Role: "user", Content: sb.String()},
+	}
+
+	cr, err := s.chatCompletion(ctx, req.Judge, msgs, nil, judgeFormat, map[string]any{"temperature": 0.0}, req.KeepAlive)
+	if err != nil {
+		return nil, fmt.Errorf("judge: %w", err)
+	}
+
+	var v verdict
+	if err := json.Unmarshal([]byte(cr.Content), &v); err != nil {
+		return nil, fmt.Errorf("judge returned an invalid verdict: %w", err)
+	}
+
+	if len(v.Scores) != len(answered) {
+		return nil, fmt.Errorf("judge scored %d answers, expected %d", len(v.Scores), len(answered))
+	}
+
+	if req.Merge && v.Answer == "" {
+		return nil, errors.New("judge didn't merge the answers")
+	}
+
+	return &v, nil
+}
+
+// chatCompletion answers msgs with the named model. The returned response
+// has the full content and the metrics of the final response. Its tokens
+// count towards the quota of ctx's client.
+func (s *Server) chatCompletion(ctx context.Context, name string, msgs []api.Message, tools []api.Tool, format json.RawMessage, options map[string]any, keepAlive *api.Duration) (llm.CompletionResponse, error) {
+	if err := s.checkQuota(ctx); err != nil {
+		return llm.CompletionResponse{}, err
+	}
+
+	// the runner is held until the completion is done
+	ctx, release := context.WithCancel(ctx)
+	defer release()
+
+	caps := []Capability{CapabilityCompletion}
+	if len(tools) > 0 {
+		caps = append(caps, CapabilityTools)
+	}
+
+	r, m, opts, err := s.scheduleRunner(ctx, name, caps, options, keepAlive)
+	if err != nil {
+		return llm.CompletionResponse{}, err
+	}
+
+	all := append(m.Messages, msgs...)
+	if msgs[0].Role != "system" && m.System != "" {
+		all = append([]api.Message{{Role: "system", Content: m.System}}, all...)
+	}
+
+	prompt, images, _, err := chatPrompt(ctx, m, r.Tokenize, opts, all, tools, truncation{})
+	if err != nil {
+		return llm.CompletionResponse{}, err
+	}
+
+	var sb strings.Builder
+	var final llm.CompletionResponse
+	if err := r.Completion(ctx, llm.CompletionRequest{
+		Prompt:  prompt,
+		Images:  images,
+		Format:  format,
+		Options: opts,
+	}, func(cr llm.CompletionResponse) {
+		sb.WriteString(cr.Content)
+		if cr.Done {
+			final = cr
+		}
+	}); err != nil {
+		return llm.CompletionResponse{}, err
+	}
+
+	s.recordUsage(ctx, name, final.PromptEvalCount, final.EvalCount)
+	final.Content = sb.String()
+	return final, nil
+}
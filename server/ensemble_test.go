@@ -0,0 +1,122 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+)
+
+func TestEnsemble(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var judged string
+	mock := mockRunner{
+		CompletionFn: func(_ context.Context, r llm.CompletionRequest, fn func(llm.CompletionResponse)) error {
+			if r.Format != nil {
+				judged = r.Prompt
+				fn(llm.CompletionResponse{Content: `{"scores": [3, 8, 5], "answer": "merged"}`, Done: true})
+				return nil
+			}
+
+			fn(llm.CompletionResponse{Content: fmt.Sprintf("answer %d", r.Options.Seed), Done: true})
+			return nil
+		},
+	}
+
+	s := Server{sched: newMockScheduler(t, &mock)}
+	createMockModel(t, &s, "test", `{{ range .Messages }}{{ .Role }}: {{ .Content }}{{ "\n" }}{{ end }}`)
+
+	req := api.EnsembleRequest{
+		Models:   []string{"test"},
+		Samples:  3,
+		Judge:    "test",
+		Messages: []api.Message{{Role: "user", Content: "Hello!"}},
+		Options:  map[string]any{"seed": 1},
+	}
+
+	t.Run("select", func(t *testing.T) {
+		w := createRequest(t, s.EnsembleHandler, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+
+		var resp api.EnsembleResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		want := api.EnsembleResponse{
+			Judge:   "test",
+			Message: api.Message{Role: "assistant", Content: "answer 2"},
+			Winner:  1,
+			Candidates: []api.EnsembleCandidate{
+				{Model: "test", Message: api.Message{Role: "assistant", Content: "answer 1"}, Score: 3},
+				{Model: "test", Message: api.Message{Role: "assistant", Content: "answer 2"}, Score: 8},
+				{Model: "test", Message: api.Message{Role: "assistant", Content: "answer 3"}, Score: 5},
+			},
+		}
+
+		if diff := cmp.Diff(resp, want, cmpopts.IgnoreFields(api.EnsembleResponse{}, "CreatedAt", "TotalDuration")); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+
+		wantJudged := "system: " + judgeSelectPrompt + "\nuser: Conversation:\n\nuser: Hello!\n\nAnswers:\n\n[1]\nanswer 1\n\n[2]\nanswer 2\n\n[3]\nanswer 3\n\n"
+		if diff := cmp.Diff(judged, wantJudged); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+	})
+
+	t.Run("merge", func(t *testing.T) {
+		req := req
+		req.Merge = true
+		w := createRequest(t, s.EnsembleHandler, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+
+		var resp api.EnsembleResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.Message.Content != "merged" || resp.Winner != 1 {
+			t.Errorf("expected merged answer with winner 1, got %q and %d", resp.Message.Content, resp.Winner)
+		}
+	})
+
+	t.Run("too many", func(t *testing.T) {
+		req := req
+		req.Samples = maxEnsembleCandidates + 1
+		w := createRequest(t, s.EnsembleHandler, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", w.Code)
+		}
+	})
+
+	t.Run("missing judge", func(t *testing.T) {
+		req := req
+		req.Judge = ""
+		w := createRequest(t, s.EnsembleHandler, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", w.Code)
+		}
+	})
+
+	t.Run("missing model", func(t *testing.T) {
+		req := req
+		req.Models = []string{"missing"}
+		w := createRequest(t, s.EnsembleHandler, req)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("expected status 404, got %d", w.Code)
+		}
+	})
+}
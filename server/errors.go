@@ -0,0 +1,75 @@
+package server
+
+import (
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+)
+
+// outOfMemoryMessages are fragments of allocation failures reported by the
+// runner and its GPU libraries
+var outOfMemoryMessages = []string{
+	"out of memory",
+	"failed to allocate",
+	"unable to allocate",
+}
+
+// errorCode returns the error code for err, or "" if it has none
+func errorCode(err error) api.ErrorCode {
+	var se api.StatusError
+	switch {
+	case errors.As(err, &se) && se.Code != "":
+		return se.Code
+	case errors.Is(err, os.ErrNotExist):
+		return api.ErrorCodeModelNotFound
+	case errors.Is(err, errCapabilities):
+		return api.ErrorCodeUnsupportedCapability
+	case errors.Is(err, errLicenseNotAccepted):
+		return api.ErrorCodeLicenseNotAccepted
+	case errors.Is(err, errTruncated):
+		return api.ErrorCodeContextExceeded
+	case errors.Is(err, ErrMaxQueue), errors.Is(err, ErrTTFTTarget):
+		return api.ErrorCodeQueueFull
+	case errors.Is(err, llm.ErrInsufficientMemory):
+		return api.ErrorCodeOutOfMemory
+	}
+
+	msg := strings.ToLower(err.Error())
+	for _, s := range outOfMemoryMessages {
+		if strings.Contains(msg, s) {
+			return api.ErrorCodeOutOfMemory
+		}
+	}
+
+	if errors.Is(err, llm.ErrRunnerTerminated) || errors.Is(err, llm.ErrRunnerExited) {
+		return api.ErrorCodeRunnerCrashed
+	}
+
+	return ""
+}
+
+// errorBody returns an error response body with msg and code, if set
+func errorBody(code api.ErrorCode, msg string) gin.H {
+	h := gin.H{"error": msg}
+	if code != "" {
+		h["code"] = code
+	}
+
+	return h
+}
+
+// errorResponse returns an error response body for err
+func errorResponse(err error) gin.H {
+	return errorBody(errorCode(err), err.Error())
+}
+
+// errorCodeOf returns the code of an error response body
+func errorCodeOf(h gin.H) api.ErrorCode {
+	code, _ := h["code"].(api.ErrorCode)
+	return code
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+)
+
+func TestErrorCode(t *testing.T) {
+	cases := []struct {
+		err  error
+		want api.ErrorCode
+	}{
+		{fmt.Errorf("open model: %w", os.ErrNotExist), api.ErrorCodeModelNotFound},
+		{fmt.Errorf("test %w", errCapabilities), api.ErrorCodeUnsupportedCapability},
+		{fmt.Errorf("test %w", errLicenseNotAccepted), api.ErrorCodeLicenseNotAccepted},
+		{ErrMaxQueue, api.ErrorCodeQueueFull},
+		{&admissionError{}, api.ErrorCodeQueueFull},
+		{fmt.Errorf("%w (10 GiB) than is available (8 GiB)", llm.ErrInsufficientMemory), api.ErrorCodeOutOfMemory},
+		{fmt.Errorf("%w: %w", llm.ErrRunnerTerminated, errors.New("cudaMalloc failed: out of memory")), api.ErrorCodeOutOfMemory},
+		{fmt.Errorf("%w: %w", llm.ErrRunnerTerminated, errors.New("signal: killed")), api.ErrorCodeRunnerCrashed},
+		{fmt.Errorf("%w: 2 error loading model", llm.ErrRunnerExited), api.ErrorCodeRunnerCrashed},
+		{api.StatusError{Code: api.ErrorCodeContextExceeded}, api.ErrorCodeContextExceeded},
+		{errors.New("something else"), ""},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.err.Error(), func(t *testing.T) {
+			if got := errorCode(tt.err); got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+
+	if body := errorResponse(errors.New("test")); len(body) != 1 || body["error"] != "test" {
+		t.Errorf("unexpected body %v", body)
+	}
+
+	body := errorResponse(ErrMaxQueue)
+	if body["error"] != ErrMaxQueue.Error() || errorCodeOf(body) != api.ErrorCodeQueueFull {
+		t.Errorf("unexpected body %v", body)
+	}
+}
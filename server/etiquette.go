@@ -0,0 +1,161 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ollama/ollama/discover"
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/format"
+)
+
+// fullscreenInterval is how often the desktop is checked for full-screen
+// applications
+const fullscreenInterval = 2 * time.Second
+
+// gpuReserve is the VRAM left free on each GPU for other applications, either
+// a number of bytes or a percentage of the GPU's total VRAM
+type gpuReserve struct {
+	bytes   uint64
+	percent float64
+}
+
+// parseGPUReserve parses a size such as "2GiB" or a percentage such as "15%"
+func parseGPUReserve(s string) (gpuReserve, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return gpuReserve{}, nil
+	}
+
+	if p, ok := strings.CutSuffix(s, "%"); ok {
+		n, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil || n < 0 || n >= 100 {
+			return gpuReserve{}, fmt.Errorf("invalid GPU reserve %q: percentage must be between 0 and 100", s)
+		}
+
+		return gpuReserve{percent: n}, nil
+	}
+
+	n, err := parseRate(s)
+	if err != nil {
+		return gpuReserve{}, fmt.Errorf("invalid GPU reserve %q", s)
+	}
+
+	return gpuReserve{bytes: uint64(n)}, nil
+}
+
+// gpuReserveFromEnv returns the GPU reserve configured with OLLAMA_GPU_RESERVE
+func gpuReserveFromEnv() gpuReserve {
+	r, err := parseGPUReserve(envconfig.GpuReserve())
+	if err != nil {
+		slog.Warn("ignoring GPU reserve", "error", err)
+	}
+
+	return r
+}
+
+// apply removes the reserve from the total and free memory of each GPU, so
+// models are scheduled as if the reserved VRAM was in use by other
+// applications
+func (r gpuReserve) apply(gpus discover.GpuInfoList) {
+	if r == (gpuReserve{}) {
+		return
+	}
+
+	for i := range gpus {
+		if gpus[i].Library == "cpu" {
+			continue
+		}
+
+		reserve := r.bytes
+		if r.percent > 0 {
+			reserve = uint64(float64(gpus[i].TotalMemory) * r.percent / 100)
+		}
+
+		slog.Debug("reserving VRAM for other applications", "gpu", gpus[i].ID, "library", gpus[i].Library, "reserve", format.HumanBytes2(reserve))
+		gpus[i].TotalMemory -= min(gpus[i].TotalMemory, reserve)
+		gpus[i].FreeMemory -= min(gpus[i].FreeMemory, reserve)
+	}
+}
+
+// monitorFullscreen releases VRAM while a full-screen application such as a
+// game is running, if enabled with OLLAMA_GPU_YIELD
+func (s *Scheduler) monitorFullscreen(ctx context.Context) {
+	if !envconfig.GpuYield() || s.fullscreenFn == nil {
+		return
+	}
+
+	if _, err := s.fullscreenFn(); errors.Is(err, errors.ErrUnsupported) {
+		slog.Warn("OLLAMA_GPU_YIELD is not supported on this platform")
+		return
+	}
+
+	ticker := time.NewTicker(fullscreenInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			fullscreen, err := s.fullscreenFn()
+			if err != nil {
+				slog.Debug("unable to check for full-screen applications", "error", err)
+				continue
+			}
+
+			if fullscreen != s.yielding.Load() {
+				slog.Info("full-screen application state changed", "running", fullscreen)
+				s.yielding.Store(fullscreen)
+				if !fullscreen {
+					s.reclaimGPUs()
+				}
+			}
+
+			if fullscreen {
+				s.yieldGPUs()
+			}
+		}
+	}
+}
+
+// yieldGPUs unloads models using GPUs. Idle models are unloaded immediately
+// and busy models once their current requests complete. Models loaded while
+// yielding are loaded on the CPU, so they aren't unloaded again.
+func (s *Scheduler) yieldGPUs() {
+	s.loadedMu.Lock()
+	var models []*Model
+	for _, r := range s.loaded {
+		r.refMu.Lock()
+		if r.model != nil && r.estimatedVRAM > 0 && r.sessionDuration > 0 {
+			models = append(models, r.model)
+		}
+		r.refMu.Unlock()
+	}
+	s.loadedMu.Unlock()
+
+	for _, m := range models {
+		slog.Info("releasing VRAM for full-screen application", "model", m.ModelPath)
+		s.expireRunner(m)
+	}
+}
+
+// reclaimGPUs marks the runners loaded on the CPU while yielding to be
+// replaced by the next request for their model, so it's loaded on the GPU
+// again
+func (s *Scheduler) reclaimGPUs() {
+	s.loadedMu.Lock()
+	defer s.loadedMu.Unlock()
+	for _, r := range s.loaded {
+		r.refMu.Lock()
+		if r.yielded {
+			r.stale = true
+		}
+		r.refMu.Unlock()
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !windows
+
+package server
+
+import "errors"
+
+// fullscreenApp is only implemented on Windows
+func fullscreenApp() (bool, error) {
+	return false, errors.ErrUnsupported
+}
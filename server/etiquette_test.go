@@ -0,0 +1,128 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/discover"
+	"github.com/ollama/ollama/format"
+	"github.com/ollama/ollama/llm"
+)
+
+func TestParseGPUReserve(t *testing.T) {
+	cases := []struct {
+		in   string
+		want gpuReserve
+		err  bool
+	}{
+		{in: ""},
+		{in: "2GiB", want: gpuReserve{bytes: 2 * format.GibiByte}},
+		{in: "512 MB", want: gpuReserve{bytes: 512 * format.MegaByte}},
+		{in: "15%", want: gpuReserve{percent: 15}},
+		{in: "100%", err: true},
+		{in: "lots", err: true},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseGPUReserve(tt.in)
+			if tt.err {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestGPUReserveApply(t *testing.T) {
+	newGPUs := func() discover.GpuInfoList {
+		gpus := discover.GpuInfoList{{Library: "cuda", ID: "0"}, {Library: "cpu"}}
+		gpus[0].TotalMemory = 24 * format.GibiByte
+		gpus[0].FreeMemory = 20 * format.GibiByte
+		gpus[1].TotalMemory = 32 * format.GibiByte
+		gpus[1].FreeMemory = 16 * format.GibiByte
+		return gpus
+	}
+
+	gpus := newGPUs()
+	gpuReserve{bytes: 4 * format.GibiByte}.apply(gpus)
+	require.Equal(t, uint64(20*format.GibiByte), gpus[0].TotalMemory)
+	require.Equal(t, uint64(16*format.GibiByte), gpus[0].FreeMemory)
+	require.Equal(t, uint64(16*format.GibiByte), gpus[1].FreeMemory)
+
+	gpus = newGPUs()
+	gpuReserve{percent: 25}.apply(gpus)
+	require.Equal(t, uint64(18*format.GibiByte), gpus[0].TotalMemory)
+	require.Equal(t, uint64(14*format.GibiByte), gpus[0].FreeMemory)
+
+	gpus = newGPUs()
+	gpuReserve{bytes: 22 * format.GibiByte}.apply(gpus)
+	require.Zero(t, gpus[0].FreeMemory)
+}
+
+func TestYieldGPUs(t *testing.T) {
+	ctx, done := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer done()
+	s := InitScheduler(ctx)
+
+	gpu := &runnerRef{model: &Model{ModelPath: "gpu"}, modelPath: "gpu", estimatedVRAM: 10, sessionDuration: time.Minute}
+	busy := &runnerRef{model: &Model{ModelPath: "busy"}, modelPath: "busy", estimatedVRAM: 10, sessionDuration: time.Minute, refCount: 1}
+	cpu := &runnerRef{model: &Model{ModelPath: "cpu"}, modelPath: "cpu", sessionDuration: time.Minute}
+	s.loaded["gpu"] = gpu
+	s.loaded["busy"] = busy
+	s.loaded["cpu"] = cpu
+
+	s.yieldGPUs()
+	require.Equal(t, gpu, <-s.expiredCh)
+	require.Empty(t, s.expiredCh)
+
+	// busy models are unloaded once their requests complete
+	require.Zero(t, busy.sessionDuration)
+	require.Equal(t, time.Minute, cpu.sessionDuration)
+}
+
+func TestYieldingLoadsOnCPU(t *testing.T) {
+	ctx, done := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer done()
+	s := InitScheduler(ctx)
+	s.getGpuFn = getGpuFn
+	s.getCpuFn = getCpuFn
+	s.yielding.Store(true)
+
+	a := newScenarioRequest(t, ctx, "ollama-model-1", 10, nil)
+	var loaded discover.GpuInfoList
+	s.newServerFn = func(gpus discover.GpuInfoList, model string, ggml *llm.GGML, adapters []string, projectors []string, opts api.Options, numParallel int) (llm.LlamaServer, error) {
+		loaded = gpus
+		return a.srv, nil
+	}
+
+	s.pendingReqCh <- a.req
+	s.Run(ctx)
+
+	var runner *runnerRef
+	select {
+	case runner = <-a.req.successCh:
+	case err := <-a.req.errCh:
+		t.Fatal(err)
+	case <-ctx.Done():
+		t.Fatal("timeout")
+	}
+
+	require.Len(t, loaded, 1)
+	require.Equal(t, "cpu", loaded[0].Library)
+
+	// models loaded on the CPU are replaced once GPUs are reclaimed
+	s.yielding.Store(false)
+	s.reclaimGPUs()
+	runner.refMu.Lock()
+	defer runner.refMu.Unlock()
+	require.True(t, runner.yielded)
+	require.True(t, runner.stale)
+}
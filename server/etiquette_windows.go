@@ -0,0 +1,33 @@
+package server
+
+import (
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var procSHQueryUserNotificationState = windows.NewLazySystemDLL("shell32.dll").NewProc("SHQueryUserNotificationState")
+
+// QUERY_USER_NOTIFICATION_STATE values set while a full-screen application is
+// running
+const (
+	qunsBusy                 = 2 // full-screen application or presentation mode
+	qunsRunningD3DFullScreen = 3 // exclusive full-screen Direct3D application
+)
+
+// fullscreenApp reports whether a full-screen application such as a game is
+// running on the user's desktop
+func fullscreenApp() (bool, error) {
+	if err := procSHQueryUserNotificationState.Find(); err != nil {
+		return false, err
+	}
+
+	var state uint32
+	hr, _, _ := procSHQueryUserNotificationState.Call(uintptr(unsafe.Pointer(&state)))
+	if hr != 0 {
+		return false, fmt.Errorf("SHQueryUserNotificationState failed: %#x", hr)
+	}
+
+	return state == qunsBusy || state == qunsRunningD3DFullScreen, nil
+}
@@ -0,0 +1,232 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
+)
+
+type phasesKey struct{}
+
+// phases reports the phases of a request before it's generating, while its
+// model is scheduled
+type phases struct {
+	report func(phase string)
+	// loading is signalled by the scheduler when it starts loading a model
+	// for the request
+	loading chan struct{}
+	// queued is set while the queued phase is held back
+	queued bool
+}
+
+// withPhases returns a context whose requests report their scheduling
+// phases to report
+func withPhases(ctx context.Context, report func(phase string)) context.Context {
+	return context.WithValue(ctx, phasesKey{}, &phases{report: report, loading: make(chan struct{}, 1)})
+}
+
+// phasesFrom returns the phases reported by ctx's requests, or nil
+func phasesFrom(ctx context.Context) *phases {
+	p, _ := ctx.Value(phasesKey{}).(*phases)
+	return p
+}
+
+// reportPhase reports that the request entered phase. The queued phase is
+// held back until the request gets further, as reporting it sends the
+// response's headers and requests that can't be scheduled should still be
+// answered with the status of their error.
+func (p *phases) reportPhase(phase string) {
+	if p == nil {
+		return
+	}
+
+	if phase == api.PhaseQueued {
+		p.queued = true
+		return
+	}
+
+	p.flush()
+	p.report(phase)
+}
+
+// flush reports the queued phase if it was held back
+func (p *phases) flush() {
+	if p != nil && p.queued {
+		p.queued = false
+		p.report(api.PhaseQueued)
+	}
+}
+
+// loadingC returns the channel signalled when the request's model starts
+// loading, which is nil if phases aren't reported
+func (p *phases) loadingC() <-chan struct{} {
+	if p == nil {
+		return nil
+	}
+
+	return p.loading
+}
+
+// signalLoading signals that the request's model is loading without blocking
+// the scheduler
+func (p *phases) signalLoading() {
+	if p == nil {
+		return
+	}
+
+	select {
+	case p.loading <- struct{}{}:
+	default:
+	}
+}
+
+// writeEvent writes event to a streamed response before its other chunks,
+// such as while the model is scheduled
+func writeEvent(c *gin.Context, event api.Event) {
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Header("X-Accel-Buffering", "no")
+
+	bts, err := json.Marshal(event)
+	if err != nil {
+		slog.Info(fmt.Sprintf("writeEvent: json.Marshal failed with %s", err))
+		return
+	}
+
+	sw := newStreamWriter(c.Writer, 0, envconfig.WriteTimeout())
+	if err := sw.write(append(bts, '\n')); err != nil {
+		slog.Info(fmt.Sprintf("writeEvent: w.Write failed with %s", err))
+	}
+}
+
+// writeEventError writes err to a response whose events have started, and
+// so whose status can no longer be changed
+func writeEventError(c *gin.Context, err error) {
+	bts, err := json.Marshal(errorResponse(err))
+	if err != nil {
+		slog.Info(fmt.Sprintf("writeEventError: json.Marshal failed with %s", err))
+		return
+	}
+
+	sw := newStreamWriter(c.Writer, 0, envconfig.WriteTimeout())
+	if err := sw.write(append(bts, '\n')); err != nil {
+		slog.Info(fmt.Sprintf("writeEventError: w.Write failed with %s", err))
+	}
+}
+
+// phaseWriter returns a function writing the scheduling phases of a request
+// for model as events
+func phaseWriter(c *gin.Context, model string) func(string) {
+	return func(phase string) {
+		writeEvent(c, api.Event{Phase: phase, Model: model, CreatedAt: time.Now().UTC()})
+	}
+}
+
+// streamEvents converts the responses streamed on ch to events, starting with
+// the prefill event. The responses left on ch are discarded once ctx is done
+// so the goroutine sending them isn't blocked.
+func streamEvents(ctx context.Context, ch chan any, prefill api.Event) chan any {
+	events := make(chan any)
+	go func() {
+		defer close(events)
+		defer func() {
+			for range ch {
+			}
+		}()
+
+		send := func(v any) bool {
+			select {
+			case events <- v:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		if !send(prefill) {
+			return
+		}
+
+		for v := range ch {
+			for _, event := range responseEvents(v) {
+				if !send(event) {
+					return
+				}
+			}
+		}
+	}()
+
+	return events
+}
+
+// responseEvents returns the events of a streamed response. The final
+// response is sent in the done event without the content already sent in
+// generating and tool_call events. Errors are passed through.
+func responseEvents(v any) []any {
+	var events []any
+	switch res := v.(type) {
+	case api.GenerateResponse:
+		if res.Response != "" || len(res.Logprobs) > 0 {
+			events = append(events, api.Event{
+				Phase:     api.PhaseGenerating,
+				Model:     res.Model,
+				CreatedAt: res.CreatedAt,
+				Content:   res.Response,
+				Logprobs:  res.Logprobs,
+			})
+		}
+
+		if res.Done {
+			res.Response, res.Logprobs = "", nil
+			events = append(events, api.Event{Phase: api.PhaseDone, Model: res.Model, CreatedAt: res.CreatedAt, Generate: &res})
+		}
+	case api.ChatResponse:
+		if res.Message.Thinking != "" {
+			events = append(events, api.Event{
+				Phase:     api.PhaseThinking,
+				Model:     res.Model,
+				CreatedAt: res.CreatedAt,
+				Thinking:  res.Message.Thinking,
+			})
+		}
+
+		if res.Message.Content != "" || len(res.Logprobs) > 0 {
+			events = append(events, api.Event{
+				Phase:     api.PhaseGenerating,
+				Model:     res.Model,
+				CreatedAt: res.CreatedAt,
+				Content:   res.Message.Content,
+				Logprobs:  res.Logprobs,
+			})
+		}
+
+		if len(res.Message.ToolCalls) > 0 || len(res.Message.ToolCallDeltas) > 0 {
+			events = append(events, api.Event{
+				Phase:          api.PhaseToolCall,
+				Model:          res.Model,
+				CreatedAt:      res.CreatedAt,
+				ToolCalls:      res.Message.ToolCalls,
+				ToolCallDeltas: res.Message.ToolCallDeltas,
+				ToolCallErrors: res.ToolCallErrors,
+				RawToolCalls:   res.Message.RawToolCalls,
+			})
+		}
+
+		if res.Done {
+			res.Message.Thinking, res.Message.Content = "", ""
+			res.Message.ToolCalls, res.Message.ToolCallDeltas, res.Message.RawToolCalls = nil, nil, ""
+			res.Logprobs, res.ToolCallErrors = nil, nil
+			events = append(events, api.Event{Phase: api.PhaseDone, Model: res.Model, CreatedAt: res.CreatedAt, Chat: &res})
+		}
+	default:
+		events = append(events, v)
+	}
+
+	return events
+}
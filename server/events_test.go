@@ -0,0 +1,197 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/discover"
+	"github.com/ollama/ollama/llm"
+)
+
+func TestEvents(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var content []string
+	mock := mockRunner{
+		CompletionFn: func(_ context.Context, _ llm.CompletionRequest, fn func(llm.CompletionResponse)) error {
+			for i, s := range content {
+				done := i == len(content)-1
+				resp := llm.CompletionResponse{Content: s, Done: done}
+				if done {
+					resp.DoneReason, resp.EvalCount = "stop", len(content)
+				}
+				fn(resp)
+			}
+			return nil
+		},
+	}
+
+	s := Server{sched: newMockScheduler(t, &mock)}
+	createMockModel(t, &s, "test", `
+{{- if .Tools }}{{ .Tools }}
+{{ end }}
+{{- range .Messages }}{{ .Role }}: {{ .Content }}
+{{- range .ToolCalls }}{"name": "{{ .Function.Name }}", "arguments": {{ .Function.Arguments }}}
+{{- end }}
+{{ end }}`)
+
+	events := func(t *testing.T, w *httptest.ResponseRecorder) []api.Event {
+		t.Helper()
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+
+		var events []api.Event
+		for dec := json.NewDecoder(w.Body); ; {
+			var e api.Event
+			if err := dec.Decode(&e); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			events = append(events, e)
+		}
+
+		return events
+	}
+
+	phases := func(events []api.Event) []string {
+		var phases []string
+		for _, e := range events {
+			phases = append(phases, e.Phase)
+		}
+		return phases
+	}
+
+	t.Run("generate", func(t *testing.T) {
+		content = []string{"Hello", ", world", "!"}
+		got := events(t, createRequest(t, s.GenerateHandler, api.GenerateRequest{
+			Model:  "test",
+			Prompt: "Hi",
+			Events: true,
+		}))
+
+		want := []string{api.PhaseQueued, api.PhaseLoading, api.PhasePrefill, api.PhaseGenerating, api.PhaseGenerating, api.PhaseGenerating, api.PhaseDone}
+		if diff := cmp.Diff(want, phases(got)); diff != "" {
+			t.Fatalf("phases mismatch (-want +got):\n%s", diff)
+		}
+
+		if got[2].PromptTokens == 0 || got[3].Content != "Hello" {
+			t.Errorf("unexpected events %+v", got[2:4])
+		}
+
+		done := got[len(got)-1].Generate
+		if done == nil || !done.Done || done.Response != "" || done.EvalCount != 3 || len(done.Context) == 0 {
+			t.Errorf("unexpected final response %+v", done)
+		}
+	})
+
+	t.Run("chat tool call", func(t *testing.T) {
+		content = []string{`{"name": "get_weather", `, `"arguments": {"city": "Paris"}}`}
+		got := events(t, createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model:    "test",
+			Messages: []api.Message{{Role: "user", Content: "What's the weather in Paris?"}},
+			Tools: []api.Tool{{Type: "function", Function: api.ToolFunction{
+				Name: "get_weather",
+			}}},
+			Events: true,
+		}))
+
+		want := []string{api.PhaseQueued, api.PhaseLoading, api.PhasePrefill, api.PhaseToolCall, api.PhaseDone}
+		if diff := cmp.Diff(want, phases(got)); diff != "" {
+			t.Fatalf("phases mismatch (-want +got):\n%s", diff)
+		}
+
+		calls := []api.ToolCall{{Function: api.ToolCallFunction{Name: "get_weather", Arguments: api.ToolCallFunctionArguments{"city": "Paris"}}}}
+		if diff := cmp.Diff(calls, got[3].ToolCalls); diff != "" {
+			t.Errorf("tool calls mismatch (-want +got):\n%s", diff)
+		}
+
+		if done := got[4].Chat; done == nil || done.DoneReason != "stop" || len(done.Message.ToolCalls) > 0 {
+			t.Errorf("unexpected final response %+v", done)
+		}
+	})
+
+	t.Run("not streamed", func(t *testing.T) {
+		content = []string{"Hello"}
+		w := createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model:    "test",
+			Messages: []api.Message{{Role: "user", Content: "Hi"}},
+			Stream:   &stream,
+			Events:   true,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+
+		var resp api.ChatResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.Message.Content != "Hello" || !resp.Done {
+			t.Errorf("expected a chat response, got %+v", resp)
+		}
+	})
+
+	t.Run("queue full", func(t *testing.T) {
+		sched := s.sched
+		t.Cleanup(func() { s.sched = sched })
+		s.sched = &Scheduler{pendingReqCh: make(chan *LlmRequest)}
+
+		w := createRequest(t, s.GenerateHandler, api.GenerateRequest{
+			Model:  "test",
+			Prompt: "Hi",
+			Events: true,
+		})
+		if w.Code != http.StatusServiceUnavailable {
+			t.Fatalf("expected status 503, got %d: %s", w.Code, w.Body)
+		}
+	})
+
+	t.Run("load failure", func(t *testing.T) {
+		loadFn := s.sched.loadFn
+		t.Cleanup(func() { s.sched.loadFn = loadFn })
+		s.sched.loadFn = func(req *LlmRequest, _ *llm.GGML, _ discover.GpuInfoList, _ int) {
+			req.errCh <- errors.New("load failed")
+		}
+
+		// the request for a new model's loading event starts the response
+		s.sched.loadedMu.Lock()
+		clear(s.sched.loaded)
+		s.sched.loadedMu.Unlock()
+
+		w := createRequest(t, s.GenerateHandler, api.GenerateRequest{
+			Model:  "test",
+			Prompt: "Hi",
+			Events: true,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+
+		dec := json.NewDecoder(w.Body)
+		for _, phase := range []string{api.PhaseQueued, api.PhaseLoading} {
+			var e api.Event
+			if err := dec.Decode(&e); err != nil || e.Phase != phase {
+				t.Fatalf("expected %s event, got %+v: %v", phase, e, err)
+			}
+		}
+
+		var resp struct {
+			Error string `json:"error"`
+		}
+		if err := dec.Decode(&resp); err != nil || resp.Error != "load failed" {
+			t.Errorf("expected the error, got %+v: %v", resp, err)
+		}
+	})
+}
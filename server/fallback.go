@@ -0,0 +1,221 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/llm"
+)
+
+var errFallback = errors.New("invalid fallback")
+
+// fallback is a remote server the requests of a model are sent to when they
+// can't be run well locally. Only servers on hosts in OLLAMA_FALLBACK_HOSTS
+// are used, and prompts are redacted before they're sent.
+type fallback struct {
+	remote api.RemoteModel
+
+	// wait is the longest a request waits for the model to be loaded
+	// locally, if set
+	wait time.Duration
+
+	// gpu falls back if the model can't be loaded entirely in GPU memory
+	gpu bool
+
+	redact redactor
+}
+
+// newFallback returns the fallback set by opts, or nil if they don't set one
+func newFallback(opts api.Options) (*fallback, error) {
+	if opts.Fallback == "" {
+		return nil, nil
+	}
+
+	rawURL, name, _ := strings.Cut(opts.Fallback, "#")
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("%w: %q must be an http or https url", errFallback, rawURL)
+	}
+
+	hosts := envconfig.FallbackHosts()
+	if !slices.Contains(hosts, u.Host) && !slices.Contains(hosts, u.Hostname()) {
+		return nil, fmt.Errorf("%w: %s isn't in OLLAMA_FALLBACK_HOSTS", errFallback, u.Host)
+	}
+
+	redact, err := newRedactor(opts.Redact)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fallback{
+		remote: api.RemoteModel{URL: rawURL, Model: name},
+		wait:   time.Duration(float64(opts.FallbackWait) * float64(time.Second)),
+		gpu:    opts.FallbackGPU,
+		redact: redact,
+	}, nil
+}
+
+// shouldFallback reports whether err scheduling a model means it can't be
+// run locally right now
+func shouldFallback(err error) bool {
+	return errors.Is(err, llm.ErrInsufficientMemory) || errors.Is(err, ErrMaxQueue) || errors.Is(err, ErrTTFTTarget)
+}
+
+func (f *fallback) server() (llm.LlamaServer, error) {
+	r, err := llm.NewRemoteServer(f.remote.URL, f.remote.Model)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(f.redact) == 0 {
+		return r, nil
+	}
+
+	return &redactedServer{LlamaServer: r, redact: f.redact}, nil
+}
+
+// fitsGPU reports whether m could be loaded entirely in GPU memory if it had
+// the GPUs to itself
+func (s *Server) fitsGPU(m *Model, opts api.Options) bool {
+	gpus := slices.Clone(s.sched.getGpuFn())
+	if len(gpus) == 0 || gpus[0].Library == "cpu" {
+		return false
+	}
+
+	ggml, err := llm.LoadModel(m.ModelPath, 0)
+	if err != nil {
+		// scheduling the model reports the error
+		return true
+	}
+
+	for i := range gpus {
+		gpus[i].FreeMemory = gpus[i].TotalMemory
+	}
+
+	estimate := llm.EstimateGPULayers(gpus, ggml, m.ProjectorPaths, opts)
+	return estimate.Layers >= int(ggml.KV().BlockCount())+1
+}
+
+// scheduleFallback schedules m like the scheduler does, but falls back to a
+// remote server if it can't be loaded as fb requires
+func (s *Server) scheduleFallback(ctx context.Context, m *Model, opts api.Options, keepAlive *api.Duration, fb *fallback) (llm.LlamaServer, error) {
+	if fb.gpu && !s.fitsGPU(m, opts) {
+		slog.Info("falling back to remote server", "model", m.ShortName, "reason", "doesn't fit in GPU memory")
+		return fb.server()
+	}
+
+	// the runner is released when ctx is done, so the scheduler is only
+	// cancelled if the request falls back
+	schedCtx, cancel := context.WithCancel(ctx)
+
+	var timeout <-chan time.Time
+	if fb.wait > 0 {
+		timer := time.NewTimer(fb.wait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	runnerCh, errCh := s.sched.getRunnerFor(schedCtx, m, opts, keepAlive)
+	select {
+	case runner := <-runnerCh:
+		context.AfterFunc(ctx, cancel)
+		return runner.llama, nil
+	case err := <-errCh:
+		cancel()
+		if !shouldFallback(err) {
+			return nil, err
+		}
+
+		slog.Info("falling back to remote server", "model", m.ShortName, "reason", err)
+		return fb.server()
+	case <-timeout:
+		cancel()
+		slog.Info("falling back to remote server", "model", m.ShortName, "reason", "waited too long to load", "wait", fb.wait)
+		return fb.server()
+	}
+}
+
+// redactedServer redacts the text sent to a remote server
+type redactedServer struct {
+	llm.LlamaServer
+	redact redactor
+}
+
+func (s *redactedServer) Completion(ctx context.Context, req llm.CompletionRequest, fn func(llm.CompletionResponse)) error {
+	req.Prompt = s.redact.apply(req.Prompt)
+	return s.LlamaServer.Completion(ctx, req, fn)
+}
+
+func (s *redactedServer) Embedding(ctx context.Context, input string) ([]float32, error) {
+	return s.LlamaServer.Embedding(ctx, s.redact.apply(input))
+}
+
+func (s *redactedServer) Tokenize(ctx context.Context, content string) ([]int, error) {
+	return s.LlamaServer.Tokenize(ctx, s.redact.apply(content))
+}
+
+// redactors are the hooks that can remove private data from the prompts sent
+// to a fallback with the redact parameter
+var redactors = struct {
+	sync.Mutex
+	m map[string]func(string) string
+}{m: map[string]func(string) string{
+	"email":       replaceAll(`[\w.+-]+@[\w-]+(\.[\w-]+)+`, "[email]"),
+	"phone":       replaceAll(`(\+\d{1,3}[ .-]?)?\(?\d{3}\)?[ .-]?\d{3}[ .-]?\d{4}\b`, "[phone]"),
+	"ipv4":        replaceAll(`\b(\d{1,3}\.){3}\d{1,3}\b`, "[ip]"),
+	"credit_card": replaceAll(`\b(\d[ -]?){12,15}\d\b`, "[card]"),
+}}
+
+// RegisterRedactor registers fn as the redactor name, replacing any redactor
+// already registered with that name. Models set the redactors applied to
+// prompts sent to their fallback with the redact parameter.
+func RegisterRedactor(name string, fn func(string) string) {
+	redactors.Lock()
+	defer redactors.Unlock()
+	redactors.m[name] = fn
+}
+
+func replaceAll(expr, repl string) func(string) string {
+	re := regexp.MustCompile(expr)
+	return func(s string) string {
+		return re.ReplaceAllString(s, repl)
+	}
+}
+
+// redactor applies redactors in order
+type redactor []func(string) string
+
+func newRedactor(names []string) (redactor, error) {
+	redactors.Lock()
+	defer redactors.Unlock()
+
+	var r redactor
+	for _, name := range names {
+		fn, ok := redactors.m[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown redactor %q", errFallback, name)
+		}
+
+		r = append(r, fn)
+	}
+
+	return r, nil
+}
+
+func (r redactor) apply(s string) string {
+	for _, fn := range r {
+		s = fn(s)
+	}
+
+	return s
+}
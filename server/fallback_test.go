@@ -0,0 +1,204 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/discover"
+	"github.com/ollama/ollama/llm"
+)
+
+func TestRedactors(t *testing.T) {
+	cases := []struct {
+		names []string
+		input string
+		want  string
+	}{
+		{[]string{"email"}, "write to jane.doe+x@mail.example.com today", "write to [email] today"},
+		{[]string{"phone"}, "call +1 (555) 123-4567 or 555.123.4567", "call [phone] or [phone]"},
+		{[]string{"ipv4"}, "host 192.168.0.1 is down", "host [ip] is down"},
+		{[]string{"credit_card"}, "card 4111 1111 1111 1111 expires", "card [card] expires"},
+		{[]string{"email", "ipv4"}, "a@b.io at 10.0.0.1", "[email] at [ip]"},
+		{nil, "a@b.io", "a@b.io"},
+	}
+
+	for _, tt := range cases {
+		r, err := newRedactor(tt.names)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := r.apply(tt.input); got != tt.want {
+			t.Errorf("%v: expected %q, got %q", tt.names, tt.want, got)
+		}
+	}
+
+	if _, err := newRedactor([]string{"unknown"}); !errors.Is(err, errFallback) {
+		t.Errorf("expected errFallback, got %v", err)
+	}
+
+	RegisterRedactor("secret", func(s string) string { return strings.ReplaceAll(s, "hunter2", "[secret]") })
+	r, err := newRedactor([]string{"secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := r.apply("password hunter2"); got != "password [secret]" {
+		t.Errorf("expected the registered redactor to apply, got %q", got)
+	}
+}
+
+func TestNewFallback(t *testing.T) {
+	t.Setenv("OLLAMA_FALLBACK_HOSTS", "api.example.com, localhost:8000")
+
+	cases := []struct {
+		fallback string
+		err      bool
+	}{
+		{"", false},
+		{"https://api.example.com/v1#model", false},
+		{"https://api.example.com:8443/v1#model", false},
+		{"http://localhost:8000/v1#model", false},
+		{"http://localhost:9000/v1#model", true},
+		{"https://other.example.com/v1#model", true},
+		{"ftp://api.example.com#model", true},
+	}
+
+	for _, tt := range cases {
+		opts := api.DefaultOptions()
+		opts.Fallback = tt.fallback
+		opts.FallbackWait = 1.5
+
+		fb, err := newFallback(opts)
+		if tt.err {
+			if !errors.Is(err, errFallback) {
+				t.Errorf("%q: expected errFallback, got %v", tt.fallback, err)
+			}
+			continue
+		} else if err != nil {
+			t.Fatalf("%q: %v", tt.fallback, err)
+		}
+
+		if tt.fallback == "" {
+			if fb != nil {
+				t.Errorf("expected no fallback, got %+v", fb)
+			}
+			continue
+		}
+
+		if fb.remote.Model != "model" || fb.wait != 1500*time.Millisecond {
+			t.Errorf("%q: unexpected fallback %+v", tt.fallback, fb)
+		}
+	}
+}
+
+func TestChatFallback(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	var prompt string
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/completions" {
+			http.NotFound(w, r)
+			return
+		}
+
+		var req struct {
+			Prompt string `json:"prompt"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		prompt = req.Prompt
+		fmt.Fprint(w, "data: {\"choices\":[{\"text\":\"Hi!\",\"finish_reason\":\"stop\"}]}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer remote.Close()
+
+	t.Setenv("OLLAMA_FALLBACK_HOSTS", strings.TrimPrefix(remote.URL, "http://"))
+
+	var mock mockRunner
+	s := Server{sched: newMockScheduler(t, &mock)}
+	createMockModel(t, &s, "test", `{{- range .Messages }}{{ .Role }}: {{ .Content }} {{ end }}`)
+
+	chat := func(opts map[string]any) api.ChatResponse {
+		t.Helper()
+		w := createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model:    "test",
+			Messages: []api.Message{{Role: "user", Content: "mail me at jane@example.com"}},
+			Options:  opts,
+			Stream:   &stream,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		var resp api.ChatResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		return resp
+	}
+
+	opts := map[string]any{
+		"fallback": remote.URL + "/v1#served-model",
+		"redact":   []any{"email"},
+	}
+
+	t.Run("insufficient memory", func(t *testing.T) {
+		s.sched.loadFn = func(req *LlmRequest, _ *llm.GGML, _ discover.GpuInfoList, _ int) {
+			req.errCh <- fmt.Errorf("%w: test", llm.ErrInsufficientMemory)
+		}
+
+		resp := chat(opts)
+		if resp.Message.Content != "Hi!" {
+			t.Errorf("expected the remote response, got %q", resp.Message.Content)
+		}
+
+		if prompt != "user: mail me at [email] " {
+			t.Errorf("expected the prompt to be redacted, got %q", prompt)
+		}
+	})
+
+	t.Run("wait", func(t *testing.T) {
+		prompt = ""
+		s.sched.loadFn = func(*LlmRequest, *llm.GGML, discover.GpuInfoList, int) {}
+
+		resp := chat(map[string]any{
+			"fallback":      remote.URL + "/v1#served-model",
+			"fallback_wait": 0.05,
+		})
+		if resp.Message.Content != "Hi!" {
+			t.Errorf("expected the remote response, got %q", resp.Message.Content)
+		}
+
+		if prompt != "user: mail me at jane@example.com " {
+			t.Errorf("expected the prompt unredacted, got %q", prompt)
+		}
+	})
+
+	t.Run("not allowed", func(t *testing.T) {
+		w := createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model:    "test",
+			Messages: []api.Message{{Role: "user", Content: "Hello!"}},
+			Options:  map[string]any{"fallback": "https://other.example.com/v1#served-model"},
+			Stream:   &stream,
+		})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d: %s", w.Code, w.Body.String())
+		}
+	})
+}
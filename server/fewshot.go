@@ -0,0 +1,521 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/template"
+)
+
+const formatExamplePrompt = "Respond with JSON in the same format as this example:"
+
+// maxExampleDepth is how deep examples of recursive schemas are generated
+// before only required properties are included and choices that don't
+// recurse are preferred
+const maxExampleDepth = 8
+
+var errExampleDepth = errors.New("schema is nested too deeply for an example")
+
+// withFormatExample adds an example of a response in format to msgs, as a
+// system message or, if tmpl doesn't render system messages, to the latest
+// user message. Smaller models follow a schema more closely when
+// they're shown what a response looks like rather than only being
+// constrained to it. msgs are returned as is if format isn't a JSON schema.
+func withFormatExample(msgs []api.Message, tmpl *template.Template, format json.RawMessage) []api.Message {
+	if len(format) == 0 || format[0] != '{' {
+		return msgs
+	}
+
+	var grammar struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(format, &grammar); err == nil && grammar.Type == "grammar" {
+		return msgs
+	}
+
+	example, err := schemaExample(format)
+	if err != nil {
+		slog.Debug("couldn't generate an example of format", "error", err)
+		return msgs
+	}
+
+	prompt := formatExamplePrompt + "\n" + string(example)
+	if !tmpl.HandlesRole("system") {
+		for i := len(msgs) - 1; i >= 0; i-- {
+			if msgs[i].Role == "user" {
+				msgs = append([]api.Message(nil), msgs...)
+				msgs[i].Content = prompt + "\n\n" + msgs[i].Content
+				return msgs
+			}
+		}
+
+		return msgs
+	}
+
+	if len(msgs) > 0 && msgs[0].Role == "system" {
+		return append([]api.Message{{Role: "system", Content: msgs[0].Content + "\n\n" + prompt}}, msgs[1:]...)
+	}
+
+	return append([]api.Message{{Role: "system", Content: prompt}}, msgs...)
+}
+
+// schemaExample returns a compact value that conforms to a JSON schema,
+// except for string patterns, which are left to the grammar
+func schemaExample(schema json.RawMessage) (json.RawMessage, error) {
+	var root struct {
+		Defs        map[string]json.RawMessage `json:"$defs"`
+		Definitions map[string]json.RawMessage `json:"definitions"`
+	}
+	if err := json.Unmarshal(schema, &root); err != nil {
+		return nil, err
+	}
+
+	defs := root.Defs
+	if defs == nil {
+		defs = root.Definitions
+	}
+
+	v, err := example(schema, defs, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	if err := json.Compact(&b, v); err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
+
+// keywordsOf returns the keywords of schema by key, or nil if schema is a
+// boolean
+func keywordsOf(schema json.RawMessage) (map[string]json.RawMessage, error) {
+	keywords, err := schemaKeywords(schema)
+	if err != nil || keywords == nil {
+		return nil, err
+	}
+
+	byKey := make(map[string]json.RawMessage, len(keywords))
+	for _, kw := range keywords {
+		byKey[kw.key] = kw.value
+	}
+
+	return byKey, nil
+}
+
+func example(schema json.RawMessage, defs map[string]json.RawMessage, depth int) (json.RawMessage, error) {
+	// schemas that require themselves have no finite example
+	if depth > 2*maxExampleDepth {
+		return nil, errExampleDepth
+	}
+
+	kw, err := keywordsOf(schema)
+	if err != nil {
+		return nil, err
+	} else if kw == nil {
+		// the schema true allows any value
+		return json.RawMessage("null"), nil
+	}
+
+	if v, ok := kw["const"]; ok {
+		return v, nil
+	}
+
+	if v, ok := kw["enum"]; ok {
+		var values []json.RawMessage
+		if err := json.Unmarshal(v, &values); err != nil {
+			return nil, err
+		} else if len(values) > 0 {
+			return values[0], nil
+		}
+	}
+
+	if v, ok := kw["$ref"]; ok {
+		def, err := resolveRef(v, defs)
+		if err != nil {
+			return nil, err
+		}
+
+		return example(def, defs, depth+1)
+	}
+
+	for _, key := range []string{"anyOf", "oneOf"} {
+		if v, ok := kw[key]; ok {
+			var subs []json.RawMessage
+			if err := json.Unmarshal(v, &subs); err != nil {
+				return nil, err
+			}
+
+			if len(subs) > 0 {
+				return example(choice(subs, depth), defs, depth+1)
+			}
+		}
+	}
+
+	if v, ok := kw["allOf"]; ok {
+		var subs []json.RawMessage
+		if err := json.Unmarshal(v, &subs); err != nil {
+			return nil, err
+		}
+
+		merged, err := mergeSchemas(schema, subs, defs)
+		if err != nil {
+			return nil, err
+		}
+
+		return example(merged, defs, depth+1)
+	}
+
+	switch schemaType(kw) {
+	case "object":
+		return objectExample(kw, defs, depth)
+	case "array":
+		return arrayExample(kw, defs, depth)
+	case "string":
+		return stringExample(kw)
+	case "integer", "number":
+		return numberExample(kw)
+	case "boolean":
+		return json.RawMessage("true"), nil
+	default:
+		return json.RawMessage("null"), nil
+	}
+}
+
+// resolveRef returns the definition a $ref refers to
+func resolveRef(v json.RawMessage, defs map[string]json.RawMessage) (json.RawMessage, error) {
+	var ref string
+	if err := json.Unmarshal(v, &ref); err != nil {
+		return nil, err
+	}
+
+	name := ref[strings.LastIndexByte(ref, '/')+1:]
+	def, ok := defs[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown reference %q", ref)
+	}
+
+	return def, nil
+}
+
+// choice returns which of the subschemas of anyOf or oneOf an example is
+// generated from. null is the least useful example, so it's only chosen if
+// it's the only choice until the example is deep enough that the other
+// choices might recurse forever. Past that, null or a choice without a
+// reference is chosen.
+func choice(subs []json.RawMessage, depth int) json.RawMessage {
+	if depth >= maxExampleDepth {
+		for _, sub := range subs {
+			if kw, err := keywordsOf(sub); err == nil && kw != nil && schemaType(kw) == "null" {
+				return sub
+			}
+		}
+
+		for _, sub := range subs {
+			if kw, err := keywordsOf(sub); err == nil && kw["$ref"] == nil {
+				return sub
+			}
+		}
+
+		return subs[0]
+	}
+
+	for _, sub := range subs {
+		if kw, err := keywordsOf(sub); err == nil && schemaType(kw) != "null" {
+			return sub
+		}
+	}
+
+	return subs[0]
+}
+
+// mergeSchemas merges schema, without its allOf, with the subschemas of its
+// allOf, so an example conforms to all of them. Their properties and
+// required properties are combined, and otherwise the first schema with a
+// keyword sets it.
+func mergeSchemas(schema json.RawMessage, subs []json.RawMessage, defs map[string]json.RawMessage) (json.RawMessage, error) {
+	var merged, props []schemaKeyword
+	var required []string
+	for _, sub := range append([]json.RawMessage{schema}, subs...) {
+		keywords, err := schemaKeywords(sub)
+		if err != nil {
+			return nil, err
+		}
+
+		// a reference is merged as the definition it refers to
+		if i := slices.IndexFunc(keywords, func(kw schemaKeyword) bool { return kw.key == "$ref" }); i >= 0 {
+			def, err := resolveRef(keywords[i].value, defs)
+			if err != nil {
+				return nil, err
+			}
+
+			if keywords, err = schemaKeywords(def); err != nil {
+				return nil, err
+			}
+		}
+
+		for _, kw := range keywords {
+			switch kw.key {
+			case "allOf":
+				// already being merged
+			case "properties":
+				p, err := schemaKeywords(kw.value)
+				if err != nil {
+					return nil, err
+				}
+
+				for _, p := range p {
+					if !slices.ContainsFunc(props, func(q schemaKeyword) bool { return q.key == p.key }) {
+						props = append(props, p)
+					}
+				}
+			case "required":
+				var r []string
+				if err := json.Unmarshal(kw.value, &r); err != nil {
+					return nil, err
+				}
+
+				for _, name := range r {
+					if !slices.Contains(required, name) {
+						required = append(required, name)
+					}
+				}
+			default:
+				if !slices.ContainsFunc(merged, func(q schemaKeyword) bool { return q.key == kw.key }) {
+					merged = append(merged, kw)
+				}
+			}
+		}
+	}
+
+	if len(props) > 0 {
+		v, err := marshalKeywords(props)
+		if err != nil {
+			return nil, err
+		}
+
+		merged = append(merged, schemaKeyword{"properties", v})
+	}
+
+	if len(required) > 0 {
+		v, err := json.Marshal(required)
+		if err != nil {
+			return nil, err
+		}
+
+		merged = append(merged, schemaKeyword{"required", v})
+	}
+
+	return marshalKeywords(merged)
+}
+
+// schemaType returns the type of a schema, or the first type other than
+// null if it has several. Schemas without a type are typed by their
+// keywords.
+func schemaType(kw map[string]json.RawMessage) string {
+	if v, ok := kw["type"]; ok {
+		var types []string
+		if err := json.Unmarshal(v, &types); err != nil {
+			var t string
+			if err := json.Unmarshal(v, &t); err != nil {
+				return ""
+			}
+			types = []string{t}
+		}
+
+		for _, t := range types {
+			if t != "null" {
+				return t
+			}
+		}
+
+		return "null"
+	}
+
+	switch {
+	case kw["properties"] != nil:
+		return "object"
+	case kw["items"] != nil, kw["prefixItems"] != nil:
+		return "array"
+	}
+
+	return ""
+}
+
+func objectExample(kw map[string]json.RawMessage, defs map[string]json.RawMessage, depth int) (json.RawMessage, error) {
+	var required []string
+	if v, ok := kw["required"]; ok {
+		if err := json.Unmarshal(v, &required); err != nil {
+			return nil, err
+		}
+	}
+
+	var props []schemaKeyword
+	if v, ok := kw["properties"]; ok {
+		var err error
+		if props, err = schemaKeywords(v); err != nil {
+			return nil, err
+		}
+	}
+
+	var values []schemaKeyword
+	for _, p := range props {
+		// deeply nested schemas are usually recursive, so only what's
+		// required is included to keep the example finite
+		if depth >= maxExampleDepth && !slices.Contains(required, p.key) {
+			continue
+		}
+
+		v, err := example(p.value, defs, depth+1)
+		if err != nil {
+			return nil, err
+		}
+
+		values = append(values, schemaKeyword{p.key, v})
+	}
+
+	return marshalKeywords(values)
+}
+
+func arrayExample(kw map[string]json.RawMessage, defs map[string]json.RawMessage, depth int) (json.RawMessage, error) {
+	var bounds struct {
+		MinItems *int `json:"minItems"`
+		MaxItems *int `json:"maxItems"`
+	}
+	if err := unmarshalKeywords(kw, &bounds); err != nil {
+		return nil, err
+	}
+
+	var schemas []json.RawMessage
+	if v, ok := kw["prefixItems"]; ok {
+		if err := json.Unmarshal(v, &schemas); err != nil {
+			return nil, err
+		}
+	}
+
+	// one item shows what items look like
+	n := max(len(schemas), 1)
+	if bounds.MinItems != nil {
+		n = max(n, *bounds.MinItems)
+	}
+	if bounds.MaxItems != nil {
+		n = min(n, *bounds.MaxItems)
+	}
+
+	items, ok := kw["items"]
+	if !ok || bytes.Equal(bytes.TrimSpace(items), []byte("false")) {
+		n = min(n, len(schemas))
+	}
+
+	values := make([]json.RawMessage, n)
+	for i := range values {
+		schema := items
+		if i < len(schemas) {
+			schema = schemas[i]
+		}
+
+		if len(schema) == 0 {
+			values[i] = json.RawMessage("null")
+			continue
+		}
+
+		v, err := example(schema, defs, depth+1)
+		if err != nil {
+			return nil, err
+		}
+
+		values[i] = v
+	}
+
+	return json.Marshal(values)
+}
+
+// stringFormats are examples of strings in the formats of JSON schema
+var stringFormats = map[string]string{
+	"date":      "2024-01-01",
+	"date-time": "2024-01-01T00:00:00Z",
+	"time":      "00:00:00",
+	"duration":  "P1D",
+	"email":     "user@example.com",
+	"hostname":  "example.com",
+	"ipv4":      "127.0.0.1",
+	"ipv6":      "::1",
+	"uri":       "https://example.com",
+	"url":       "https://example.com",
+	"uuid":      "00000000-0000-0000-0000-000000000000",
+}
+
+func stringExample(kw map[string]json.RawMessage) (json.RawMessage, error) {
+	var bounds struct {
+		Format    string `json:"format"`
+		MinLength *int   `json:"minLength"`
+		MaxLength *int   `json:"maxLength"`
+	}
+	if err := unmarshalKeywords(kw, &bounds); err != nil {
+		return nil, err
+	}
+
+	s, ok := stringFormats[bounds.Format]
+	if !ok {
+		s = "string"
+		if bounds.MinLength != nil && len(s) < *bounds.MinLength {
+			s += strings.Repeat("x", *bounds.MinLength-len(s))
+		}
+		if bounds.MaxLength != nil && len(s) > *bounds.MaxLength {
+			s = s[:max(*bounds.MaxLength, 0)]
+		}
+	}
+
+	return json.Marshal(s)
+}
+
+func numberExample(kw map[string]json.RawMessage) (json.RawMessage, error) {
+	var bounds struct {
+		Minimum          *float64 `json:"minimum"`
+		ExclusiveMinimum *float64 `json:"exclusiveMinimum"`
+		Maximum          *float64 `json:"maximum"`
+		ExclusiveMaximum *float64 `json:"exclusiveMaximum"`
+	}
+	if err := unmarshalKeywords(kw, &bounds); err != nil {
+		return nil, err
+	}
+
+	integer := schemaType(kw) == "integer"
+	var v float64
+	switch {
+	case bounds.Minimum != nil:
+		v = max(v, *bounds.Minimum)
+	case bounds.ExclusiveMinimum != nil:
+		v = max(v, *bounds.ExclusiveMinimum+1)
+	}
+
+	switch {
+	case bounds.Maximum != nil:
+		v = min(v, *bounds.Maximum)
+	case bounds.ExclusiveMaximum != nil:
+		v = min(v, *bounds.ExclusiveMaximum-1)
+	}
+
+	if integer {
+		return json.Marshal(int64(v))
+	}
+
+	return json.Marshal(v)
+}
+
+// unmarshalKeywords decodes the keywords of a schema into v
+func unmarshalKeywords(kw map[string]json.RawMessage, v any) error {
+	b, err := json.Marshal(kw)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, v)
+}
@@ -0,0 +1,195 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/template"
+)
+
+func TestSchemaExample(t *testing.T) {
+	cases := []struct {
+		name   string
+		schema string
+		want   string
+	}{
+		{
+			name:   "object",
+			schema: `{"type": "object", "properties": {"name": {"type": "string"}, "age": {"type": "integer"}, "score": {"type": "number"}, "member": {"type": "boolean"}}}`,
+			want:   `{"name":"string","age":0,"score":0,"member":true}`,
+		},
+		{
+			name:   "enum and const",
+			schema: `{"properties": {"color": {"enum": ["red", "green"]}, "kind": {"const": {"a": 1}}}}`,
+			want:   `{"color":"red","kind":{"a":1}}`,
+		},
+		{
+			name:   "bounds",
+			schema: `{"type": "object", "properties": {"code": {"type": "string", "minLength": 8}, "id": {"type": "string", "maxLength": 2}, "n": {"type": "integer", "minimum": 3}, "x": {"type": "number", "maximum": -1.5}, "y": {"type": "integer", "exclusiveMinimum": 0}}}`,
+			want:   `{"code":"stringxx","id":"st","n":3,"x":-1.5,"y":1}`,
+		},
+		{
+			name:   "formats",
+			schema: `{"type": "object", "properties": {"when": {"type": "string", "format": "date-time"}, "email": {"type": "string", "format": "email"}}}`,
+			want:   `{"when":"2024-01-01T00:00:00Z","email":"user@example.com"}`,
+		},
+		{
+			name:   "arrays",
+			schema: `{"type": "object", "properties": {"tags": {"type": "array", "items": {"type": "string"}}, "pair": {"type": "array", "items": {"type": "integer"}, "minItems": 2}, "none": {"type": "array", "maxItems": 0}, "tuple": {"prefixItems": [{"type": "string"}, {"type": "boolean"}], "items": false}}}`,
+			want:   `{"tags":["string"],"pair":[0,0],"none":[],"tuple":["string",true]}`,
+		},
+		{
+			name:   "nullable",
+			schema: `{"type": "object", "properties": {"a": {"type": ["null", "string"]}, "b": {"anyOf": [{"type": "null"}, {"type": "integer"}]}, "c": {"type": "null"}}}`,
+			want:   `{"a":"string","b":0,"c":null}`,
+		},
+		{
+			name:   "references",
+			schema: `{"$defs": {"node": {"type": "object", "properties": {"value": {"type": "integer"}, "next": {"$ref": "#/$defs/node"}}, "required": ["value"]}}, "$ref": "#/$defs/node"}`,
+			want:   `{"value":0,"next":{"value":0,"next":{"value":0,"next":{"value":0,"next":{"value":0}}}}}`,
+		},
+		{
+			name:   "required recursive references",
+			schema: `{"$defs": {"node": {"type": "object", "properties": {"value": {"type": "integer"}, "next": {"anyOf": [{"$ref": "#/$defs/node"}, {"type": "null"}]}}, "required": ["value", "next"]}}, "$ref": "#/$defs/node"}`,
+			want:   `{"value":0,"next":{"value":0,"next":{"value":0,"next":null}}}`,
+		},
+		{
+			name:   "all of",
+			schema: `{"$defs": {"named": {"properties": {"name": {"type": "string"}}, "required": ["name"]}}, "type": "object", "allOf": [{"$ref": "#/$defs/named"}, {"properties": {"age": {"type": "integer"}}}]}`,
+			want:   `{"name":"string","age":0}`,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := schemaExample(json.RawMessage(tt.schema))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(got) != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+
+	if _, err := schemaExample(json.RawMessage(`{"$ref": "#/$defs/missing"}`)); err == nil {
+		t.Error("expected an unknown reference to fail")
+	}
+
+	if _, err := schemaExample(json.RawMessage(`{"$defs": {"node": {"properties": {"next": {"$ref": "#/$defs/node"}}, "required": ["next"]}}, "$ref": "#/$defs/node"}`)); !errors.Is(err, errExampleDepth) {
+		t.Errorf("expected a schema that requires itself to fail with errExampleDepth, got %v", err)
+	}
+}
+
+func TestWithFormatExample(t *testing.T) {
+	parse := func(s string) *template.Template {
+		t.Helper()
+		tmpl, err := template.Parse(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tmpl
+	}
+
+	system := parse(`{{ range .Messages }}{{ .Role }}: {{ .Content }}{{ end }}`)
+	user := parse(`{{ range .Messages }}{{ if eq .Role "user" }}{{ .Content }}{{ end }}{{ end }}`)
+	schema := json.RawMessage(`{"type": "object", "properties": {"ok": {"type": "boolean"}}}`)
+	prompt := formatExamplePrompt + "\n" + `{"ok":true}`
+
+	cases := []struct {
+		name   string
+		tmpl   *template.Template
+		format json.RawMessage
+		msgs   []api.Message
+		want   []api.Message
+	}{
+		{
+			name:   "system message",
+			tmpl:   system,
+			format: schema,
+			msgs:   []api.Message{{Role: "user", Content: "is it?"}},
+			want:   []api.Message{{Role: "system", Content: prompt}, {Role: "user", Content: "is it?"}},
+		},
+		{
+			name:   "existing system message",
+			tmpl:   system,
+			format: schema,
+			msgs:   []api.Message{{Role: "system", Content: "Be brief."}, {Role: "user", Content: "is it?"}},
+			want:   []api.Message{{Role: "system", Content: "Be brief.\n\n" + prompt}, {Role: "user", Content: "is it?"}},
+		},
+		{
+			name:   "no system role",
+			tmpl:   user,
+			format: schema,
+			msgs:   []api.Message{{Role: "user", Content: "first"}, {Role: "assistant", Content: "{}"}, {Role: "user", Content: "is it?"}},
+			want:   []api.Message{{Role: "user", Content: "first"}, {Role: "assistant", Content: "{}"}, {Role: "user", Content: prompt + "\n\nis it?"}},
+		},
+		{
+			name:   "json mode",
+			tmpl:   system,
+			format: json.RawMessage(`"json"`),
+			msgs:   []api.Message{{Role: "user", Content: "is it?"}},
+			want:   []api.Message{{Role: "user", Content: "is it?"}},
+		},
+		{
+			name:   "grammar",
+			tmpl:   system,
+			format: json.RawMessage(`{"type": "grammar", "value": "root ::= \"yes\""}`),
+			msgs:   []api.Message{{Role: "user", Content: "is it?"}},
+			want:   []api.Message{{Role: "user", Content: "is it?"}},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			if diff := cmp.Diff(tt.want, withFormatExample(tt.msgs, tt.tmpl, tt.format)); diff != "" {
+				t.Errorf("messages mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestChatFormatExample(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var prompt string
+	mock := mockRunner{
+		CompletionFn: func(_ context.Context, r llm.CompletionRequest, fn func(llm.CompletionResponse)) error {
+			prompt = r.Prompt
+			fn(llm.CompletionResponse{Content: `{"ok":true}`, Done: true, DoneReason: "stop"})
+			return nil
+		},
+	}
+
+	s := Server{sched: newMockScheduler(t, &mock)}
+	createMockModel(t, &s, "test", `{{- range .Messages }}{{ .Role }}: {{ .Content }}
+{{ end }}`)
+
+	format := json.RawMessage(`{"type": "object", "properties": {"ok": {"type": "boolean"}}}`)
+	for _, example := range []bool{false, true} {
+		w := createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model:    "test",
+			Messages: []api.Message{{Role: "user", Content: "is it?"}},
+			Format:   format,
+			Options:  map[string]any{"format_example": example},
+			Stream:   &stream,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+
+		if got := strings.Contains(prompt, `{"ok":true}`); got != example {
+			t.Errorf("format_example %t: unexpected prompt %q", example, prompt)
+		}
+	}
+}
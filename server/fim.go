@@ -0,0 +1,91 @@
+package server
+
+import (
+	"log/slog"
+	"sync"
+
+	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/template"
+)
+
+// fimTokens are the fill-in-the-middle tokens of code models that don't name
+// them in their metadata, as their prefix, suffix and middle tokens
+var fimTokens = [][3]string{
+	{"<|fim_prefix|>", "<|fim_suffix|>", "<|fim_middle|>"}, // Qwen2.5-Coder
+	{"<fim_prefix>", "<fim_suffix>", "<fim_middle>"},       // StarCoder, StarCoder2
+	{"<fim-prefix>", "<fim-suffix>", "<fim-middle>"},
+	{"<｜fim▁begin｜>", "<｜fim▁hole｜>", "<｜fim▁end｜>"}, // DeepSeek-Coder
+	{"<PRE>", "<SUF>", "<MID>"},                      // CodeLlama
+}
+
+// fimTemplates caches the fill-in-the-middle template of each model file, or
+// nil if it has no FIM tokens. Model files are stored by digest so the
+// template never changes.
+var fimTemplates sync.Map
+
+// fimTemplate returns a template that arranges a prompt and suffix around
+// the FIM tokens of m, for models whose template doesn't handle a suffix
+// itself. It returns nil if m has no FIM tokens.
+func (m *Model) fimTemplate() *template.Template {
+	if m.Config.Remote != nil || m.ModelPath == "" {
+		return nil
+	}
+
+	if t, ok := fimTemplates.Load(m.ModelPath); ok {
+		return t.(*template.Template)
+	}
+
+	ggml, err := llm.LoadModel(m.ModelPath, -1)
+	if err != nil {
+		slog.Debug("couldn't read model metadata", "model", m.ModelPath, "error", err)
+		return nil
+	}
+
+	var tmpl *template.Template
+	if pre, suf, mid, ok := fimTokensOf(ggml.KV()); ok {
+		s := pre + "{{ .Prompt }}" + suf + "{{ .Suffix }}" + mid
+		if pre == "<PRE>" {
+			// CodeLlama was trained with spaces around its prefix and
+			// before its middle token
+			s = pre + " {{ .Prompt }} " + suf + "{{ .Suffix }} " + mid
+		}
+
+		tmpl, err = template.Parse(s)
+		if err != nil {
+			slog.Warn("couldn't parse fill-in-the-middle template", "model", m.ModelPath, "error", err)
+			return nil
+		}
+	}
+
+	fimTemplates.Store(m.ModelPath, tmpl)
+	return tmpl
+}
+
+// fimTokensOf returns the prefix, suffix and middle tokens of a model, named
+// by their ids in its metadata or found in its vocabulary
+func fimTokensOf(kv llm.KV) (pre, suf, mid string, ok bool) {
+	for _, kinds := range [][3]string{{"fim_pre", "fim_suf", "fim_mid"}, {"prefix", "suffix", "middle"}} {
+		var tokens [3]string
+		var found int
+		for i, kind := range kinds {
+			if id, ok := kv.SpecialToken(kind); ok {
+				if s, ok := kv.Token(id); ok {
+					tokens[i] = s
+					found++
+				}
+			}
+		}
+
+		if found == len(kinds) {
+			return tokens[0], tokens[1], tokens[2], true
+		}
+	}
+
+	for _, tokens := range fimTokens {
+		if kv.HasToken(tokens[0]) && kv.HasToken(tokens[1]) && kv.HasToken(tokens[2]) {
+			return tokens[0], tokens[1], tokens[2], true
+		}
+	}
+
+	return "", "", "", false
+}
@@ -0,0 +1,189 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/template"
+)
+
+func TestFIMTemplate(t *testing.T) {
+	cases := []struct {
+		name string
+		kv   llm.KV
+		want string
+	}{
+		{
+			name: "metadata",
+			kv: llm.KV{
+				"tokenizer.ggml.tokens":           []string{"a", "[P]", "[S]", "[M]"},
+				"tokenizer.ggml.fim_pre_token_id": uint32(1),
+				"tokenizer.ggml.fim_suf_token_id": uint32(2),
+				"tokenizer.ggml.fim_mid_token_id": uint32(3),
+			},
+			want: "[P]def f(x):[S]return y[M]",
+		},
+		{
+			name: "legacy metadata",
+			kv: llm.KV{
+				"tokenizer.ggml.tokens":          []string{"<PRE>", "<SUF>", "<MID>"},
+				"tokenizer.ggml.prefix_token_id": uint32(0),
+				"tokenizer.ggml.suffix_token_id": uint32(1),
+				"tokenizer.ggml.middle_token_id": uint32(2),
+			},
+			want: "<PRE> def f(x): <SUF>return y <MID>",
+		},
+		{
+			name: "starcoder vocabulary",
+			kv:   llm.KV{"tokenizer.ggml.tokens": []string{"a", "<fim_prefix>", "<fim_middle>", "<fim_suffix>"}},
+			want: "<fim_prefix>def f(x):<fim_suffix>return y<fim_middle>",
+		},
+		{
+			name: "deepseek vocabulary",
+			kv:   llm.KV{"tokenizer.ggml.tokens": []string{"<｜fim▁hole｜>", "<｜fim▁begin｜>", "<｜fim▁end｜>"}},
+			want: "<｜fim▁begin｜>def f(x):<｜fim▁hole｜>return y<｜fim▁end｜>",
+		},
+		{
+			name: "partial metadata",
+			kv: llm.KV{
+				"tokenizer.ggml.tokens":           []string{"<|fim_prefix|>", "<|fim_suffix|>", "<|fim_middle|>"},
+				"tokenizer.ggml.fim_pre_token_id": uint32(0),
+			},
+			want: "<|fim_prefix|>def f(x):<|fim_suffix|>return y<|fim_middle|>",
+		},
+		{
+			name: "no tokens",
+			kv:   llm.KV{"tokenizer.ggml.tokens": []string{"a", "<fim_prefix>"}},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.kv["general.architecture"] = "llama"
+			m := Model{ModelPath: writeGGUF(t, tt.kv)}
+
+			tmpl := m.fimTemplate()
+			if tt.want == "" {
+				if tmpl != nil {
+					t.Fatalf("expected no template, got %q", tmpl.String())
+				}
+				return
+			} else if tmpl == nil {
+				t.Fatal("expected a template")
+			}
+
+			var b bytes.Buffer
+			if err := tmpl.Execute(&b, template.Values{Prompt: "def f(x):", Suffix: "return y"}); err != nil {
+				t.Fatal(err)
+			}
+
+			if b.String() != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, b.String())
+			}
+
+			if m.fimTemplate() != tmpl {
+				t.Error("expected the template to be cached")
+			}
+		})
+	}
+}
+
+func TestGenerateInfill(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var prompt string
+	mock := mockRunner{
+		CompletionFn: func(_ context.Context, r llm.CompletionRequest, fn func(llm.CompletionResponse)) error {
+			prompt = r.Prompt
+			fn(llm.CompletionResponse{Content: "x + 1", Done: true, DoneReason: "stop"})
+			return nil
+		},
+	}
+
+	s := Server{sched: newMockScheduler(t, &mock)}
+	createMockModel(t, &s, "chat", `{{ .Prompt }}`)
+
+	_, digest := createBinFile(t, llm.KV{
+		"general.architecture":      "starcoder2",
+		"starcoder2.block_count":    uint32(1),
+		"starcoder2.context_length": uint32(4096),
+		"tokenizer.ggml.tokens":     []string{"a", "<fim_prefix>", "<fim_suffix>", "<fim_middle>"},
+		"tokenizer.ggml.scores":     []float32{0, 0, 0, 0},
+		"tokenizer.ggml.token_type": []int32{0, 3, 3, 3},
+	}, []llm.Tensor{
+		{Name: "token_embd.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+	})
+
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:    "coder",
+		Files:    map[string]string{"file.gguf": digest},
+		Template: `{{ .Prompt }}`,
+		Stream:   &stream,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+	}
+
+	t.Run("prefix and suffix", func(t *testing.T) {
+		w := createRequest(t, s.GenerateHandler, api.GenerateRequest{
+			Model:  "coder",
+			Prefix: "def inc(x):\n    return ",
+			Suffix: "\n\nprint(inc(1))",
+			Stream: &stream,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+
+		if want := "<fim_prefix>def inc(x):\n    return <fim_suffix>\n\nprint(inc(1))<fim_middle>"; prompt != want {
+			t.Errorf("expected prompt %q, got %q", want, prompt)
+		}
+	})
+
+	t.Run("prompt and suffix", func(t *testing.T) {
+		w := createRequest(t, s.GenerateHandler, api.GenerateRequest{
+			Model:  "coder",
+			Prompt: "def inc(x):",
+			Suffix: "print(inc(1))",
+			Stream: &stream,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+
+		if want := "<fim_prefix>def inc(x):<fim_suffix>print(inc(1))<fim_middle>"; prompt != want {
+			t.Errorf("expected prompt %q, got %q", want, prompt)
+		}
+	})
+
+	t.Run("prefix and prompt", func(t *testing.T) {
+		w := createRequest(t, s.GenerateHandler, api.GenerateRequest{
+			Model:  "coder",
+			Prompt: "def inc(x):",
+			Prefix: "def inc(x):",
+			Stream: &stream,
+		})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d: %s", w.Code, w.Body)
+		}
+	})
+
+	t.Run("no fim tokens", func(t *testing.T) {
+		w := createRequest(t, s.GenerateHandler, api.GenerateRequest{
+			Model:  "chat",
+			Prefix: "def inc(x):",
+			Suffix: "print(inc(1))",
+			Stream: &stream,
+		})
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "does not support insert") {
+			t.Errorf("expected status 400, got %d: %s", w.Code, w.Body)
+		}
+	})
+}
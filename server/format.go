@@ -0,0 +1,458 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/ollama/ollama/api"
+)
+
+var errStrictTools = errors.New("strict tools aren't supported by this model's template")
+
+// chatFormat returns the format that constrains a chat response to a call of
+// one of tools, or nil if the response isn't constrained. Responses are
+// constrained if choice requires a tool call or, by default, if any of the
+// tools are strict. The call uses the keys the model's template uses for tool
+// calls, so it's parsed like any other. If parallel is set, the response is a
+// list of one or more calls.
+func (m *Model) chatFormat(tools []api.Tool, choice *api.ToolChoice, parallel bool) (json.RawMessage, error) {
+	switch {
+	case choice == nil || choice.Type == "auto":
+		if !slices.ContainsFunc(tools, func(t api.Tool) bool { return t.Function.Strict }) {
+			return nil, nil
+		}
+	case choice.Type == "none":
+		return nil, nil
+	case choice.Type == "required":
+		if len(tools) == 0 {
+			return nil, errors.New("tool_choice requires tools")
+		}
+	case choice.Type == "function":
+		if choice.Function == nil || choice.Function.Name == "" {
+			return nil, errors.New("tool_choice function requires a name")
+		}
+
+		i := slices.IndexFunc(tools, func(t api.Tool) bool { return t.Function.Name == choice.Function.Name })
+		if i < 0 {
+			return nil, fmt.Errorf("tool_choice function %q isn't in tools", choice.Function.Name)
+		}
+
+		tools = tools[i : i+1]
+	default:
+		return nil, fmt.Errorf("invalid tool_choice %q", choice.Type)
+	}
+
+	name, arguments, ok := m.toolCallKeys()
+	if !ok {
+		return nil, errStrictTools
+	}
+
+	calls := make([]map[string]any, len(tools))
+	for i, t := range tools {
+		// arguments of tools that aren't strict can be any object
+		args := api.ToolProperty{Type: api.PropertyType{"object"}}
+		if t.Function.Strict {
+			args = parametersFormat(t.Function.Parameters)
+		}
+
+		calls[i] = map[string]any{
+			"type":                 "object",
+			"properties":           callProperties{name, arguments, t.Function.Name, args},
+			"required":             []string{name, arguments},
+			"additionalProperties": false,
+		}
+	}
+
+	format := map[string]any{"anyOf": calls}
+	if parallel {
+		format = map[string]any{"type": "array", "items": format, "minItems": 1}
+	}
+
+	return json.Marshal(format)
+}
+
+// callProperties are the properties of a tool call. They're marshaled with
+// the name first so it's generated, and streamed, before the arguments.
+type callProperties struct {
+	name, arguments string
+	tool            string
+	args            api.ToolProperty
+}
+
+func (p callProperties) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, kv := range []struct {
+		k string
+		v any
+	}{
+		{p.name, map[string]any{"const": p.tool}},
+		{p.arguments, p.args},
+	} {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+
+		k, err := json.Marshal(kv.k)
+		if err != nil {
+			return nil, err
+		}
+
+		v, err := json.Marshal(kv.v)
+		if err != nil {
+			return nil, err
+		}
+
+		b.Write(k)
+		b.WriteByte(':')
+		b.Write(v)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// toolCallStream reads a response constrained by chatFormat as it's
+// generated and returns the tool calls in it as deltas: the name of a call
+// once it's complete and then fragments of its JSON arguments.
+type toolCallStream struct {
+	name, arguments string
+
+	// callDepth is the depth of call objects, 1 for a single call or 2 for a
+	// list of calls
+	callDepth int
+	depth     int
+	index     int
+
+	inString, escaped bool
+	// str is a string in a call object, a key or the tool name
+	str       strings.Builder
+	key       string
+	expectKey bool
+	inArgs    bool
+}
+
+func (s *toolCallStream) write(text string) []api.ToolCallDelta {
+	var deltas []api.ToolCallDelta
+	var args strings.Builder
+	flush := func() {
+		if args.Len() > 0 {
+			deltas = append(deltas, api.ToolCallDelta{Index: s.index, Arguments: args.String()})
+			args.Reset()
+		}
+	}
+
+	for i := range len(text) {
+		c := text[i]
+		wasInArgs := s.inArgs
+		inCall := s.depth == s.callDepth && !s.inArgs
+
+		switch {
+		case s.inString:
+			if inCall {
+				s.str.WriteByte(c)
+			}
+
+			if s.escaped {
+				s.escaped = false
+			} else if c == '\\' {
+				s.escaped = true
+			} else if c == '"' {
+				s.inString = false
+				if inCall {
+					var v string
+					if err := json.Unmarshal([]byte(s.str.String()), &v); err != nil {
+						break
+					}
+
+					if s.expectKey {
+						s.key, s.expectKey = v, false
+					} else if s.key == s.name {
+						flush()
+						deltas = append(deltas, api.ToolCallDelta{Index: s.index, Name: v})
+					}
+				}
+			}
+		case c == '"':
+			s.inString = true
+			if inCall {
+				s.str.Reset()
+				s.str.WriteByte(c)
+			}
+		case c == '{' || c == '[':
+			if s.callDepth == 0 {
+				s.callDepth = 1
+				if c == '[' {
+					s.callDepth = 2
+				}
+			}
+
+			if inCall && s.key == s.arguments && !s.expectKey {
+				s.inArgs = true
+			}
+
+			s.depth++
+			if s.depth == s.callDepth && c == '{' {
+				s.key, s.expectKey = "", true
+			}
+		case c == '}' || c == ']':
+			s.depth--
+			if s.inArgs && s.depth == s.callDepth {
+				s.inArgs = false
+			} else if s.depth == s.callDepth-1 && c == '}' {
+				flush()
+				s.index++
+			}
+		case c == ',' && inCall:
+			s.expectKey = true
+		}
+
+		if s.inArgs || wasInArgs {
+			args.WriteByte(c)
+		}
+	}
+
+	flush()
+	return deltas
+}
+
+// parametersFormat returns the JSON schema for the arguments of a tool
+func parametersFormat(p api.ToolParameters) api.ToolProperty {
+	return propertyFormat(api.ToolProperty{
+		Type:       api.PropertyType{"object"},
+		Properties: p.Properties,
+		Required:   p.Required,
+	})
+}
+
+// propertyFormat returns the JSON schema for a tool argument. Objects with
+// properties don't allow other properties unless the tool says otherwise, so
+// the arguments generated are only the ones the tool accepts.
+func propertyFormat(p api.ToolProperty) api.ToolProperty {
+	if p.Properties != nil {
+		properties := make(map[string]api.ToolProperty, len(p.Properties))
+		for k, v := range p.Properties {
+			properties[k] = propertyFormat(v)
+		}
+		p.Properties = properties
+
+		if p.AdditionalProperties == nil {
+			p.AdditionalProperties = new(bool)
+		}
+	}
+
+	if p.Items != nil {
+		items := propertyFormat(*p.Items)
+		p.Items = &items
+	}
+
+	p.PrefixItems = propertiesFormat(p.PrefixItems)
+	p.AnyOf = propertiesFormat(p.AnyOf)
+	p.OneOf = propertiesFormat(p.OneOf)
+	p.AllOf = propertiesFormat(p.AllOf)
+	return p
+}
+
+func propertiesFormat(ps []api.ToolProperty) []api.ToolProperty {
+	if ps == nil {
+		return nil
+	}
+
+	formatted := make([]api.ToolProperty, len(ps))
+	for i, p := range ps {
+		formatted[i] = propertyFormat(p)
+	}
+
+	return formatted
+}
+
+// responseFormat returns the format for a response format preset, which are
+// the same as OpenAI's response_format objects with an added regex type:
+//
+//	{"type": "json_object"}
+//	{"type": "json_schema", "json_schema": {"schema": {...}, "strict": true}}
+//	{"type": "regex", "value": "[0-9]{3}-[0-9]{4}"}
+//
+// Other formats are returned as is.
+func responseFormat(format json.RawMessage) (json.RawMessage, error) {
+	if len(format) == 0 || format[0] != '{' {
+		return format, nil
+	}
+
+	var preset struct {
+		Type       string `json:"type"`
+		Value      string `json:"value"`
+		JSONSchema *struct {
+			Schema json.RawMessage `json:"schema"`
+			Strict bool            `json:"strict"`
+		} `json:"json_schema"`
+	}
+	if err := json.Unmarshal(format, &preset); err != nil {
+		// not a preset, such as a schema with several types
+		return format, nil
+	}
+
+	switch preset.Type {
+	case "json_object":
+		return json.RawMessage(`"json"`), nil
+	case "json_schema":
+		if preset.JSONSchema == nil || len(preset.JSONSchema.Schema) == 0 {
+			return nil, errors.New("json_schema format requires a schema")
+		}
+
+		if !preset.JSONSchema.Strict {
+			return preset.JSONSchema.Schema, nil
+		}
+
+		return strictSchema(preset.JSONSchema.Schema)
+	case "regex":
+		g, err := regexGrammar(preset.Value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex in format: %w", err)
+		}
+
+		return json.Marshal(map[string]string{"type": "grammar", "value": g})
+	}
+
+	return format, nil
+}
+
+// schemaKeyword is a key and value of a JSON schema
+type schemaKeyword struct {
+	key   string
+	value json.RawMessage
+}
+
+// strictSchema returns a JSON schema where, like OpenAI's strict mode, every
+// property of an object is required and objects don't allow other properties
+// unless the schema says otherwise. Optional properties can still be null if
+// their schema allows it. Keywords keep their order, so properties are
+// generated in the order they're listed.
+func strictSchema(schema json.RawMessage) (json.RawMessage, error) {
+	keywords, err := schemaKeywords(schema)
+	if err != nil || keywords == nil {
+		return schema, err
+	}
+
+	var names []string
+	var required, additional bool
+	for i, kw := range keywords {
+		switch kw.key {
+		case "properties", "$defs", "definitions":
+			props, err := schemaKeywords(kw.value)
+			if err != nil {
+				return nil, err
+			}
+
+			if kw.key == "properties" {
+				names = make([]string, 0, len(props))
+			}
+
+			for j, p := range props {
+				if props[j].value, err = strictSchema(p.value); err != nil {
+					return nil, err
+				}
+
+				if kw.key == "properties" {
+					names = append(names, p.key)
+				}
+			}
+
+			if keywords[i].value, err = marshalKeywords(props); err != nil {
+				return nil, err
+			}
+		case "items", "additionalProperties", "not":
+			if keywords[i].value, err = strictSchema(kw.value); err != nil {
+				return nil, err
+			}
+		case "prefixItems", "anyOf", "oneOf", "allOf":
+			var subs []json.RawMessage
+			if err := json.Unmarshal(kw.value, &subs); err != nil {
+				return nil, err
+			}
+
+			for j, sub := range subs {
+				if subs[j], err = strictSchema(sub); err != nil {
+					return nil, err
+				}
+			}
+
+			if keywords[i].value, err = json.Marshal(subs); err != nil {
+				return nil, err
+			}
+		}
+
+		required = required || kw.key == "required"
+		additional = additional || kw.key == "additionalProperties"
+	}
+
+	if names != nil {
+		if !required {
+			r, err := json.Marshal(names)
+			if err != nil {
+				return nil, err
+			}
+
+			keywords = append(keywords, schemaKeyword{"required", r})
+		}
+
+		if !additional {
+			keywords = append(keywords, schemaKeyword{"additionalProperties", json.RawMessage("false")})
+		}
+	}
+
+	return marshalKeywords(keywords)
+}
+
+// schemaKeywords returns the keywords of a JSON schema object in order, or
+// nil if it isn't an object, such as the schema true
+func schemaKeywords(schema json.RawMessage) ([]schemaKeyword, error) {
+	d := json.NewDecoder(bytes.NewReader(schema))
+	if t, err := d.Token(); err != nil {
+		return nil, err
+	} else if t != json.Delim('{') {
+		return nil, nil
+	}
+
+	keywords := []schemaKeyword{}
+	for d.More() {
+		t, err := d.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		var kw schemaKeyword
+		kw.key, _ = t.(string)
+		if err := d.Decode(&kw.value); err != nil {
+			return nil, err
+		}
+
+		keywords = append(keywords, kw)
+	}
+
+	return keywords, nil
+}
+
+func marshalKeywords(keywords []schemaKeyword) (json.RawMessage, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, kw := range keywords {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+
+		k, err := json.Marshal(kw.key)
+		if err != nil {
+			return nil, err
+		}
+
+		b.Write(k)
+		b.WriteByte(':')
+		b.Write(kw.value)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
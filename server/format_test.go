@@ -0,0 +1,355 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/template"
+)
+
+func TestChatFormat(t *testing.T) {
+	tmpl, err := template.Parse(readFile(t, filepath.Join("testdata", "tools"), "command-r-plus.gotmpl").String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := Model{Template: tmpl}
+
+	var tools []api.Tool
+	if err := json.Unmarshal([]byte(`[
+		{"type":"function","function":{"name":"search","strict":true,"parameters":{
+			"type":"object",
+			"required":["query"],
+			"properties":{
+				"query":{"type":"string","description":"search terms","minLength":1},
+				"filters":{"type":"array","items":{"type":"object","properties":{"field":{"type":"string"},"value":{"type":["string","number"]}}}},
+				"limit":{"type":"integer","minimum":1,"maximum":10}
+			}
+		}}},
+		{"type":"function","function":{"name":"time","parameters":{"type":"object","properties":{}}}}
+	]`), &tools); err != nil {
+		t.Fatal(err)
+	}
+
+	format, err := m.chatFormat(tools, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got, want any
+	if err := json.Unmarshal(format, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := json.Unmarshal([]byte(`{"anyOf":[
+		{
+			"type":"object",
+			"properties":{
+				"tool_name":{"const":"search"},
+				"parameters":{
+					"type":"object",
+					"description":"",
+					"required":["query"],
+					"additionalProperties":false,
+					"properties":{
+						"query":{"type":"string","description":"search terms","minLength":1},
+						"filters":{"type":"array","description":"","items":{
+							"type":"object",
+							"description":"",
+							"additionalProperties":false,
+							"properties":{"field":{"type":"string","description":""},"value":{"type":["string","number"],"description":""}}
+						}},
+						"limit":{"type":"integer","description":"","minimum":1,"maximum":10}
+					}
+				}
+			},
+			"required":["tool_name","parameters"],
+			"additionalProperties":false
+		},
+		{
+			"type":"object",
+			"properties":{"tool_name":{"const":"time"},"parameters":{"type":"object","description":""}},
+			"required":["tool_name","parameters"],
+			"additionalProperties":false
+		}
+	]}`), &want); err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+
+	t.Run("name first", func(t *testing.T) {
+		if name, arguments := bytes.Index(format, []byte(`"tool_name"`)), bytes.Index(format, []byte(`"parameters"`)); name > arguments {
+			t.Errorf("expected the tool name before the arguments, got %s", format)
+		}
+	})
+
+	t.Run("parallel", func(t *testing.T) {
+		format, err := m.chatFormat(tools, nil, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got map[string]any
+		if err := json.Unmarshal(format, &got); err != nil {
+			t.Fatal(err)
+		}
+
+		if diff := cmp.Diff(map[string]any{"type": "array", "items": want, "minItems": 1.0}, got); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("not strict", func(t *testing.T) {
+		format, err := m.chatFormat(tools[1:], nil, false)
+		if err != nil || format != nil {
+			t.Errorf("expected no format, got %s, %v", format, err)
+		}
+	})
+
+	t.Run("tool choice", func(t *testing.T) {
+		calls := want.(map[string]any)["anyOf"].([]any)
+
+		cases := []struct {
+			name   string
+			tools  []api.Tool
+			choice api.ToolChoice
+			want   any
+		}{
+			{"auto", tools, api.ToolChoice{Type: "auto"}, want},
+			{"none", tools, api.ToolChoice{Type: "none"}, nil},
+			{"required", tools[1:], api.ToolChoice{Type: "required"}, map[string]any{"anyOf": calls[1:]}},
+			{"function", tools, api.ToolChoice{Type: "function", Function: &api.ToolChoiceFunction{Name: "time"}}, map[string]any{"anyOf": calls[1:]}},
+		}
+
+		for _, tt := range cases {
+			t.Run(tt.name, func(t *testing.T) {
+				format, err := m.chatFormat(tt.tools, &tt.choice, false)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				var got any
+				if format != nil {
+					if err := json.Unmarshal(format, &got); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				if diff := cmp.Diff(tt.want, got); diff != "" {
+					t.Errorf("mismatch (-want +got):\n%s", diff)
+				}
+			})
+		}
+	})
+
+	t.Run("tool choice errors", func(t *testing.T) {
+		cases := []struct {
+			name   string
+			tools  []api.Tool
+			choice api.ToolChoice
+			err    string
+		}{
+			{"required without tools", nil, api.ToolChoice{Type: "required"}, "tool_choice requires tools"},
+			{"function without name", tools, api.ToolChoice{Type: "function"}, "tool_choice function requires a name"},
+			{"unknown function", tools, api.ToolChoice{Type: "function", Function: &api.ToolChoiceFunction{Name: "weather"}}, `tool_choice function "weather" isn't in tools`},
+			{"invalid", tools, api.ToolChoice{Type: "any"}, `invalid tool_choice "any"`},
+		}
+
+		for _, tt := range cases {
+			t.Run(tt.name, func(t *testing.T) {
+				_, err := m.chatFormat(tt.tools, &tt.choice, false)
+				if err == nil || err.Error() != tt.err {
+					t.Errorf("expected error %q, got %v", tt.err, err)
+				}
+			})
+		}
+	})
+
+	t.Run("unsupported template", func(t *testing.T) {
+		tmpl, err := template.Parse("{{ .Prompt }}")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		m := Model{Template: tmpl}
+		if _, err := m.chatFormat(tools, nil, false); err != errStrictTools {
+			t.Errorf("expected %v, got %v", errStrictTools, err)
+		}
+	})
+}
+
+func TestChatToolChoiceNone(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var prompt string
+	mock := mockRunner{
+		CompletionFn: func(_ context.Context, r llm.CompletionRequest, fn func(llm.CompletionResponse)) error {
+			prompt = r.Prompt
+			fn(llm.CompletionResponse{Content: `{"name":"search","arguments":{}}`, Done: true, DoneReason: "stop"})
+			return nil
+		},
+	}
+
+	s := Server{sched: newMockScheduler(t, &mock)}
+	createMockModel(t, &s, "test", `{{- if .Tools }}tools: {{ .Tools }} {{ end }}{{ range .Messages }}{{ .Content }}{{ end }}`)
+
+	w := createRequest(t, s.ChatHandler, api.ChatRequest{
+		Model:      "test",
+		Messages:   []api.Message{{Role: "user", Content: "hi"}},
+		Tools:      []api.Tool{{Type: "function", Function: api.ToolFunction{Name: "search"}}},
+		ToolChoice: &api.ToolChoice{Type: "none"},
+		Stream:     &stream,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if strings.Contains(prompt, "tools:") {
+		t.Errorf("expected the tools to be left out of the prompt, got %q", prompt)
+	}
+
+	var resp api.ChatResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(resp.Message.ToolCalls) > 0 || resp.Message.Content == "" {
+		t.Errorf("expected the response as content, got %+v", resp.Message)
+	}
+}
+
+func TestToolCallStream(t *testing.T) {
+	cases := []struct {
+		name   string
+		output string
+		want   []api.ToolCallDelta
+	}{
+		{
+			"call",
+			`{"tool_name": "search", "parameters": {"query": "a \"b\" {c}", "limit": [1, 2]}}`,
+			[]api.ToolCallDelta{{Name: "search"}, {Arguments: `{"query": "a \"b\" {c}", "limit": [1, 2]}`}},
+		},
+		{
+			"parallel",
+			`[{"tool_name":"search","parameters":{"query":"a"}}, {"tool_name":"time","parameters":{}}]`,
+			[]api.ToolCallDelta{
+				{Name: "search"},
+				{Arguments: `{"query":"a"}`},
+				{Index: 1, Name: "time"},
+				{Index: 1, Arguments: `{}`},
+			},
+		},
+		{
+			"escaped name",
+			`{"tool_name":"s\u00e9arch","parameters":{}}`,
+			[]api.ToolCallDelta{{Name: "séarch"}, {Arguments: `{}`}},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, size := range []int{1, 3, len(tt.output)} {
+				s := toolCallStream{name: "tool_name", arguments: "parameters"}
+
+				var got []api.ToolCallDelta
+				for i := 0; i < len(tt.output); i += size {
+					for _, d := range s.write(tt.output[i:min(i+size, len(tt.output))]) {
+						// merge argument fragments written in pieces
+						if n := len(got); n > 0 && d.Name == "" && got[n-1].Name == "" && got[n-1].Index == d.Index {
+							got[n-1].Arguments += d.Arguments
+							continue
+						}
+
+						got = append(got, d)
+					}
+				}
+
+				if diff := cmp.Diff(tt.want, got); diff != "" {
+					t.Errorf("size %d: mismatch (-want +got):\n%s", size, diff)
+				}
+			}
+		})
+	}
+}
+
+func TestResponseFormat(t *testing.T) {
+	cases := []struct {
+		name   string
+		format string
+		want   string
+	}{
+		{"json", `"json"`, `"json"`},
+		{"schema", `{"type":"object"}`, `{"type":"object"}`},
+		{"schema with several types", `{"type":["string","null"]}`, `{"type":["string","null"]}`},
+		{"grammar", `{"type":"grammar","value":"root ::= \"x\""}`, `{"type":"grammar","value":"root ::= \"x\""}`},
+		{"json object", `{"type":"json_object"}`, `"json"`},
+		{
+			"json schema",
+			`{"type":"json_schema","json_schema":{"name":"person","schema":{"type":"object","properties":{"name":{"type":"string"}}}}}`,
+			`{"type":"object","properties":{"name":{"type":"string"}}}`,
+		},
+		{
+			"strict json schema",
+			`{"type":"json_schema","json_schema":{"strict":true,"schema":{
+				"type":"object",
+				"properties":{
+					"name":{"type":"string"},
+					"age":{"type":["integer","null"]},
+					"pets":{"type":"array","items":{"type":"object","properties":{"kind":{"type":"string"}}}},
+					"extra":{"type":"object","properties":{},"additionalProperties":true}
+				}
+			}}}`,
+			`{
+				"type":"object",
+				"properties":{
+					"name":{"type":"string"},
+					"age":{"type":["integer","null"]},
+					"pets":{"type":"array","items":{"type":"object","properties":{"kind":{"type":"string"}},"required":["kind"],"additionalProperties":false}},
+					"extra":{"type":"object","properties":{},"additionalProperties":true,"required":[]}
+				},
+				"required":["name","age","pets","extra"],
+				"additionalProperties":false
+			}`,
+		},
+		{"regex", `{"type":"regex","value":"(yes|no)"}`, `{"type":"grammar","value":"root ::= (\"yes\" | \"no\")"}`},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := responseFormat(json.RawMessage(tt.format))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var want bytes.Buffer
+			if err := json.Compact(&want, []byte(tt.want)); err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(want.String(), string(got)); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	for _, format := range []string{
+		`{"type":"json_schema"}`,
+		`{"type":"regex","value":"("}`,
+	} {
+		if _, err := responseFormat(json.RawMessage(format)); err == nil {
+			t.Errorf("%s: expected an error", format)
+		}
+	}
+}
@@ -20,6 +20,7 @@ import (
 	"slices"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/ollama/ollama/api"
 	"github.com/ollama/ollama/envconfig"
@@ -35,6 +36,8 @@ var (
 	errCapabilityCompletion = errors.New("completion")
 	errCapabilityTools      = errors.New("tools")
 	errCapabilityInsert     = errors.New("insert")
+	errCapabilityRerank     = errors.New("rerank")
+	errCapabilityThinking   = errors.New("thinking")
 )
 
 type Capability string
@@ -43,6 +46,8 @@ const (
 	CapabilityCompletion = Capability("completion")
 	CapabilityTools      = Capability("tools")
 	CapabilityInsert     = Capability("insert")
+	CapabilityRerank     = Capability("rerank")
+	CapabilityThinking   = Capability("thinking")
 )
 
 type registryOptions struct {
@@ -64,11 +69,18 @@ type Model struct {
 	ProjectorPaths []string
 	System         string
 	License        []string
+	LicenseDigests []string
 	Digest         string
 	Options        map[string]interface{}
 	Messages       []api.Message
 
 	Template *template.Template
+
+	// ToolPrompt is the model's instructions for using tools, or nil
+	ToolPrompt *template.Template
+
+	// Card describes the model, or is nil
+	Card *api.ModelCard
 }
 
 // CheckCapabilities checks if the model has the specified capabilities returning an error describing
@@ -78,6 +90,10 @@ func (m *Model) CheckCapabilities(caps ...Capability) error {
 	for _, cap := range caps {
 		switch cap {
 		case CapabilityCompletion:
+			if m.Config.Remote != nil {
+				continue
+			}
+
 			f, err := os.Open(m.ModelPath)
 			if err != nil {
 				slog.Error("couldn't open model file", "error", err)
@@ -101,9 +117,28 @@ func (m *Model) CheckCapabilities(caps ...Capability) error {
 			}
 		case CapabilityInsert:
 			vars := m.Template.Vars()
-			if !slices.Contains(vars, "suffix") {
+			if !slices.Contains(vars, "suffix") && m.fimTemplate() == nil {
 				errs = append(errs, errCapabilityInsert)
 			}
+		case CapabilityRerank:
+			if m.Config.Remote != nil {
+				errs = append(errs, errCapabilityRerank)
+				continue
+			}
+
+			ggml, err := llm.LoadModel(m.ModelPath, 0)
+			if err != nil {
+				slog.Error("couldn't decode ggml", "error", err)
+				continue
+			}
+
+			if ggml.KV().PoolingType() != llm.PoolingTypeRank {
+				errs = append(errs, errCapabilityRerank)
+			}
+		case CapabilityThinking:
+			if m.thinkingTag() == "" {
+				errs = append(errs, errCapabilityThinking)
+			}
 		default:
 			slog.Error("unknown capability", "capability", cap)
 			return fmt.Errorf("unknown capability: %s", cap)
@@ -117,12 +152,54 @@ func (m *Model) CheckCapabilities(caps ...Capability) error {
 	return nil
 }
 
+// projectorImageTokens caches the number of tokens per image for each
+// projector. Projectors are stored by digest so the count never changes.
+var projectorImageTokens sync.Map
+
+// ImageTokens returns the number of tokens an image takes in the context of
+// the model, read from the metadata of its projector. If the projector can't
+// be read, the count for the most common projectors is used instead.
+func (m *Model) ImageTokens() int {
+	for _, p := range m.ProjectorPaths {
+		if n, ok := projectorImageTokens.Load(p); ok {
+			return n.(int)
+		}
+
+		ggml, err := llm.LoadModel(p, 0)
+		if err != nil {
+			slog.Debug("couldn't read projector metadata", "projector", p, "error", err)
+			continue
+		}
+
+		if n := int(ggml.KV().ImageTokens()); n > 0 {
+			projectorImageTokens.Store(p, n)
+			return n
+		}
+	}
+
+	if checkMllamaModelFamily(m) {
+		// Our mllama implementation packs all of the embeddings into a single token
+		return 1
+	}
+
+	// Clip images are represented as 768 tokens, each an embedding
+	return 768
+}
+
 func (m *Model) String() string {
 	var modelfile parser.Modelfile
 
+	from := m.ModelPath
+	if m.Config.Remote != nil {
+		from = m.Config.Remote.URL
+		if m.Config.Remote.Model != "" {
+			from += "#" + m.Config.Remote.Model
+		}
+	}
+
 	modelfile.Commands = append(modelfile.Commands, parser.Command{
 		Name: "model",
-		Args: m.ModelPath,
+		Args: from,
 	})
 
 	for _, adapter := range m.AdapterPaths {
@@ -153,6 +230,13 @@ func (m *Model) String() string {
 		})
 	}
 
+	if m.ToolPrompt != nil {
+		modelfile.Commands = append(modelfile.Commands, parser.Command{
+			Name: "toolprompt",
+			Args: m.ToolPrompt.String(),
+		})
+	}
+
 	for k, v := range m.Options {
 		switch v := v.(type) {
 		case []any:
@@ -194,6 +278,13 @@ type ConfigV2 struct {
 	ModelType     string   `json:"model_type"`
 	FileType      string   `json:"file_type"`
 
+	// RequireLicenseAcceptance requires the license to be accepted before the
+	// model is run
+	RequireLicenseAcceptance bool `json:"require_license_acceptance,omitempty"`
+
+	// Remote is the server the model generates with, if it isn't run locally
+	Remote *api.RemoteModel `json:"remote,omitempty"`
+
 	// required by spec
 	Architecture string `json:"architecture"`
 	OS           string `json:"os"`
@@ -206,12 +297,17 @@ type RootFS struct {
 }
 
 func GetManifest(mp ModelPath) (*Manifest, string, error) {
-	fp, err := mp.GetManifestPath()
-	if err != nil {
+	if _, err := mp.GetManifestPath(); err != nil {
 		return nil, "", err
 	}
 
-	f, err := os.Open(fp)
+	n := model.ParseName(mp.GetFullTagname())
+	s := manifestStore(n)
+	if s == nil {
+		s = localStore()
+	}
+
+	f, err := s.Open(context.Background(), manifestKey(n))
 	if err != nil {
 		return nil, "", err
 	}
@@ -283,10 +379,12 @@ func GetModel(name string) (*Model, error) {
 				return nil, err
 			}
 
-			model.Template, err = template.Parse(string(bts))
+			tmpl, err := template.Parse(string(bts))
 			if err != nil {
 				return nil, err
 			}
+
+			model.Template = tmpl.Sandbox(template.DefaultLimits)
 		case "application/vnd.ollama.image.system":
 			bts, err := os.ReadFile(filename)
 			if err != nil {
@@ -294,6 +392,18 @@ func GetModel(name string) (*Model, error) {
 			}
 
 			model.System = string(bts)
+		case "application/vnd.ollama.image.toolprompt":
+			bts, err := os.ReadFile(filename)
+			if err != nil {
+				return nil, err
+			}
+
+			tmpl, err := template.ParseToolPrompt(string(bts))
+			if err != nil {
+				return nil, err
+			}
+
+			model.ToolPrompt = tmpl.Sandbox(template.DefaultLimits)
 		case "application/vnd.ollama.image.params":
 			params, err := os.Open(filename)
 			if err != nil {
@@ -315,12 +425,22 @@ func GetModel(name string) (*Model, error) {
 			if err = json.NewDecoder(msgs).Decode(&model.Messages); err != nil {
 				return nil, err
 			}
+		case "application/vnd.ollama.image.card":
+			bts, err := os.ReadFile(filename)
+			if err != nil {
+				return nil, err
+			}
+
+			if err := json.Unmarshal(bts, &model.Card); err != nil {
+				return nil, err
+			}
 		case "application/vnd.ollama.image.license":
 			bts, err := os.ReadFile(filename)
 			if err != nil {
 				return nil, err
 			}
 			model.License = append(model.License, string(bts))
+			model.LicenseDigests = append(model.LicenseDigests, layer.Digest)
 		}
 	}
 
@@ -339,31 +459,34 @@ func CopyModel(src, dst model.Name) error {
 		return nil
 	}
 
-	manifests, err := GetManifestPath()
+	s := manifestStore(src)
+	if s == nil {
+		if err := fetchFromStore(context.Background(), src); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		s = localStore()
+	}
+
+	srcfile, err := s.Open(context.Background(), manifestKey(src))
 	if err != nil {
 		return err
 	}
+	defer srcfile.Close()
 
-	dstpath := filepath.Join(manifests, dst.Filepath())
-	if err := os.MkdirAll(filepath.Dir(dstpath), 0o755); err != nil {
+	if err := localStore().Put(context.Background(), manifestKey(dst), srcfile, -1); err != nil {
 		return err
 	}
 
-	srcpath := filepath.Join(manifests, src.Filepath())
-	srcfile, err := os.Open(srcpath)
+	m, err := ParseNamedManifest(dst)
 	if err != nil {
 		return err
 	}
-	defer srcfile.Close()
 
-	dstfile, err := os.Create(dstpath)
-	if err != nil {
-		return err
+	if err := pushToStore(context.Background(), dst, m); err != nil {
+		slog.Warn("couldn't copy model to the model store", "model", dst.DisplayShortest(), "error", err)
 	}
-	defer dstfile.Close()
 
-	_, err = io.Copy(dstfile, srcfile)
-	return err
+	return nil
 }
 
 func deleteUnusedLayers(deleteMap map[string]struct{}) error {
@@ -381,15 +504,14 @@ func deleteUnusedLayers(deleteMap map[string]struct{}) error {
 		delete(deleteMap, manifest.Config.Digest)
 	}
 
-	// only delete the files which are still in the deleteMap
+	// only delete the files which are still in the deleteMap, and never
+	// from the read-only shared stores
 	for k := range deleteMap {
-		fp, err := GetBlobsPath(k)
-		if err != nil {
-			slog.Info(fmt.Sprintf("couldn't get file path for '%s': %v", k, err))
+		if s := blobStore(k); s != nil && s.readOnly {
 			continue
 		}
-		if err := os.Remove(fp); err != nil {
-			slog.Info(fmt.Sprintf("couldn't remove file '%s': %v", fp, err))
+		if err := localStore().Delete(context.Background(), blobKey(k)); err != nil {
+			slog.Info(fmt.Sprintf("couldn't remove blob '%s': %v", k, err))
 			continue
 		}
 	}
@@ -583,13 +705,9 @@ func PullModel(ctx context.Context, name string, regOpts *registryOptions, fn fu
 		if err := verifyBlob(layer.Digest); err != nil {
 			if errors.Is(err, errDigestMismatch) {
 				// something went wrong, delete the blob
-				fp, err := GetBlobsPath(layer.Digest)
-				if err != nil {
-					return err
-				}
-				if err := os.Remove(fp); err != nil {
+				if err := localStore().Delete(ctx, blobKey(layer.Digest)); err != nil {
 					// log this, but return the original error
-					slog.Info(fmt.Sprintf("couldn't remove file with digest mismatch '%s': %v", fp, err))
+					slog.Info(fmt.Sprintf("couldn't remove blob with digest mismatch '%s': %v", layer.Digest, err))
 				}
 			}
 			return err
@@ -603,18 +721,18 @@ func PullModel(ctx context.Context, name string, regOpts *registryOptions, fn fu
 		return err
 	}
 
-	fp, err := mp.GetManifestPath()
-	if err != nil {
+	if _, err := mp.GetManifestPath(); err != nil {
 		return err
 	}
-	if err := os.MkdirAll(filepath.Dir(fp), 0o755); err != nil {
+
+	n := model.Name{Host: mp.Registry, Namespace: mp.Namespace, Model: mp.Repository, Tag: mp.Tag}
+	if err := localStore().Put(ctx, manifestKey(n), bytes.NewReader(manifestJSON), int64(len(manifestJSON))); err != nil {
+		slog.Info(fmt.Sprintf("couldn't write manifest of %s", n.DisplayShortest()))
 		return err
 	}
 
-	err = os.WriteFile(fp, manifestJSON, 0o644)
-	if err != nil {
-		slog.Info(fmt.Sprintf("couldn't write to %s", fp))
-		return err
+	if err := pushToStore(ctx, n, manifest); err != nil {
+		slog.Warn("couldn't copy model to the model store", "model", n.DisplayShortest(), "error", err)
 	}
 
 	if !envconfig.NoPrune() && len(deleteMap) > 0 {
@@ -630,8 +748,19 @@ func PullModel(ctx context.Context, name string, regOpts *registryOptions, fn fu
 }
 
 func pullModelManifest(ctx context.Context, mp ModelPath, regOpts *registryOptions) (*Manifest, error) {
-	requestURL := mp.BaseURL().JoinPath("v2", mp.GetNamespaceRepository(), "manifests", mp.Tag)
+	if mirror := registryMirror(mp.Registry); mirror != nil {
+		m, err := getManifest(ctx, mirror.JoinPath("v2", mp.GetNamespaceRepository(), "manifests", mp.Tag), mirrorOptions(mp.Registry))
+		if err == nil {
+			return m, nil
+		}
+
+		slog.Warn("registry mirror unavailable, pulling from registry", "mirror", mirror, "error", err)
+	}
+
+	return getManifest(ctx, mp.BaseURL().JoinPath("v2", mp.GetNamespaceRepository(), "manifests", mp.Tag), regOpts)
+}
 
+func getManifest(ctx context.Context, requestURL *url.URL, regOpts *registryOptions) (*Manifest, error) {
 	headers := make(http.Header)
 	headers.Set("Accept", "application/vnd.docker.distribution.manifest.v2+json")
 	resp, err := makeRequestWithRetry(ctx, http.MethodGet, requestURL, headers, nil, regOpts)
@@ -755,15 +884,38 @@ func makeRequest(ctx context.Context, method string, requestURL *url.URL, header
 		req.ContentLength = contentLength
 	}
 
-	c := &http.Client{
-		CheckRedirect: regOpts.CheckRedirect,
+	c, err := registryClient(requestURL.Host)
+	if err != nil {
+		return nil, err
 	}
+
+	c.CheckRedirect = regOpts.CheckRedirect
+	return c.Do(req)
+}
+
+// registryClient returns a client for requests to host using its registry
+// configuration, if any
+func registryClient(host string) (*http.Client, error) {
+	tr, err := registryTransport(host)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &http.Client{}
 	if testMakeRequestDialContext != nil {
-		tr := http.DefaultTransport.(*http.Transport).Clone()
+		if tr == nil {
+			tr = http.DefaultTransport.(*http.Transport)
+		}
+
+		tr = tr.Clone()
 		tr.DialContext = testMakeRequestDialContext
+	}
+
+	if tr != nil {
 		c.Transport = tr
 	}
-	return c.Do(req)
+
+	return c, nil
 }
 
 func getValue(header, key string) string {
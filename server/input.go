@@ -0,0 +1,87 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+)
+
+// isStreamedInput reports whether the body of c is a stream of JSON objects,
+// the request followed by input chunks
+func isStreamedInput(c *gin.Context) bool {
+	mediaType, _, _ := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	return mediaType == "application/x-ndjson"
+}
+
+// bindStreamedInput decodes a request followed by input chunks into v. The
+// body is decoded as it arrives, so only the assembled input is held in
+// memory rather than the whole body.
+func bindStreamedInput(c *gin.Context, v any) error {
+	dec := json.NewDecoder(c.Request.Body)
+
+	var request json.RawMessage
+	if err := dec.Decode(&request); err != nil {
+		return err
+	}
+
+	if err := decodeRequest(request, v); err != nil {
+		return err
+	}
+
+	// content is collected in a builder and added to the request when the
+	// input ends or a new message starts, so long inputs aren't copied for
+	// every chunk
+	var sb strings.Builder
+	flush := func() error {
+		if sb.Len() == 0 {
+			return nil
+		}
+		defer sb.Reset()
+
+		switch req := v.(type) {
+		case *api.GenerateRequest:
+			req.Prompt += sb.String()
+		case *api.ChatRequest:
+			if len(req.Messages) == 0 {
+				return errors.New("input chunk has content but there's no message to add it to")
+			}
+
+			req.Messages[len(req.Messages)-1].Content += sb.String()
+		}
+
+		return nil
+	}
+
+	for i := 1; ; i++ {
+		var chunk api.InputChunk
+		if err := dec.Decode(&chunk); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return fmt.Errorf("input chunk %d: %w", i, err)
+		}
+
+		if chunk.Message != nil {
+			req, ok := v.(*api.ChatRequest)
+			if !ok {
+				return fmt.Errorf("input chunk %d: messages can only be sent in chat requests", i)
+			}
+
+			if err := flush(); err != nil {
+				return err
+			}
+
+			req.Messages = append(req.Messages, *chunk.Message)
+		}
+
+		sb.WriteString(chunk.Content)
+	}
+
+	return flush()
+}
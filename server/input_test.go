@@ -0,0 +1,91 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+)
+
+func streamedInputContext(body string) *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	c.Request.Header.Set("Content-Type", "application/x-ndjson")
+	return c
+}
+
+func TestBindStreamedInput(t *testing.T) {
+	t.Run("generate", func(t *testing.T) {
+		c := streamedInputContext(`{"model":"test","prompt":"Summarize: "}
+{"content":"first "}
+{"content":"second"}
+`)
+
+		var req api.GenerateRequest
+		if err := bindRequest(c, &req); err != nil {
+			t.Fatal(err)
+		}
+
+		if req.Prompt != "Summarize: first second" {
+			t.Errorf("unexpected prompt %q", req.Prompt)
+		}
+	})
+
+	t.Run("chat", func(t *testing.T) {
+		c := streamedInputContext(`{"model":"test","messages":[{"role":"system","content":"Be brief."}]}
+{"message":{"role":"user","content":"Summarize: "}}
+{"content":"first "}
+{"content":"second"}
+{"message":{"role":"assistant","content":"ok"}}
+`)
+
+		var req api.ChatRequest
+		if err := bindRequest(c, &req); err != nil {
+			t.Fatal(err)
+		}
+
+		want := []api.Message{
+			{Role: "system", Content: "Be brief."},
+			{Role: "user", Content: "Summarize: first second"},
+			{Role: "assistant", Content: "ok"},
+		}
+		if diff := cmp.Diff(want, req.Messages); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	cases := []struct {
+		name string
+		body string
+		err  string
+	}{
+		{"empty", "", io.EOF.Error()},
+		{"invalid request", `{"model":"test","messages":"hi"}`, "messages: expected array, got string"},
+		{"content without message", `{"model":"test"}` + "\n" + `{"content":"hi"}`, "input chunk has content but there's no message to add it to"},
+		{"invalid chunk", `{"model":"test"}` + "\n" + `{"content":`, "input chunk 1: unexpected EOF"},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			var req api.ChatRequest
+			err := bindRequest(streamedInputContext(tt.body), &req)
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("expected error %q, got %v", tt.err, err)
+			}
+		})
+	}
+
+	t.Run("message in generate", func(t *testing.T) {
+		var req api.GenerateRequest
+		err := bindRequest(streamedInputContext(`{"model":"test"}`+"\n"+`{"message":{"role":"user"}}`), &req)
+		if err == nil || err.Error() != "input chunk 1: messages can only be sent in chat requests" {
+			t.Errorf("unexpected error %v", err)
+		}
+	})
+}
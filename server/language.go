@@ -0,0 +1,134 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/template"
+)
+
+var errResponseLanguage = errors.New("response_language")
+
+// responseLanguage is a language responses can be constrained to
+type responseLanguage struct {
+	// name is the English name the model is asked to respond in
+	name string
+	// scripts are the Unicode scripts the language is written in, as named
+	// by the unicode package
+	scripts []string
+}
+
+// responseLanguages are the languages responses can be constrained to, by
+// ISO 639-1 code
+var responseLanguages = map[string]responseLanguage{
+	"ar": {"Arabic", []string{"Arabic"}},
+	"bg": {"Bulgarian", []string{"Cyrillic"}},
+	"bn": {"Bengali", []string{"Bengali"}},
+	"cs": {"Czech", []string{"Latin"}},
+	"da": {"Danish", []string{"Latin"}},
+	"de": {"German", []string{"Latin"}},
+	"el": {"Greek", []string{"Greek"}},
+	"en": {"English", []string{"Latin"}},
+	"es": {"Spanish", []string{"Latin"}},
+	"fa": {"Persian", []string{"Arabic"}},
+	"fi": {"Finnish", []string{"Latin"}},
+	"fr": {"French", []string{"Latin"}},
+	"he": {"Hebrew", []string{"Hebrew"}},
+	"hi": {"Hindi", []string{"Devanagari"}},
+	"hu": {"Hungarian", []string{"Latin"}},
+	"hy": {"Armenian", []string{"Armenian"}},
+	"id": {"Indonesian", []string{"Latin"}},
+	"it": {"Italian", []string{"Latin"}},
+	"ja": {"Japanese", []string{"Han", "Hiragana", "Katakana"}},
+	"ka": {"Georgian", []string{"Georgian"}},
+	"ko": {"Korean", []string{"Hangul"}},
+	"mr": {"Marathi", []string{"Devanagari"}},
+	"nl": {"Dutch", []string{"Latin"}},
+	"no": {"Norwegian", []string{"Latin"}},
+	"pl": {"Polish", []string{"Latin"}},
+	"pt": {"Portuguese", []string{"Latin"}},
+	"ro": {"Romanian", []string{"Latin"}},
+	"ru": {"Russian", []string{"Cyrillic"}},
+	"sv": {"Swedish", []string{"Latin"}},
+	"sw": {"Swahili", []string{"Latin"}},
+	"ta": {"Tamil", []string{"Tamil"}},
+	"te": {"Telugu", []string{"Telugu"}},
+	"th": {"Thai", []string{"Thai"}},
+	"tr": {"Turkish", []string{"Latin"}},
+	"uk": {"Ukrainian", []string{"Cyrillic"}},
+	"ur": {"Urdu", []string{"Arabic"}},
+	"vi": {"Vietnamese", []string{"Latin"}},
+	"zh": {"Chinese", []string{"Han"}},
+}
+
+// lookupResponseLanguage returns the language named by s, an ISO 639-1 code
+// or English name in any case
+func lookupResponseLanguage(s string) (responseLanguage, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if lang, ok := responseLanguages[s]; ok {
+		return lang, true
+	}
+
+	for _, lang := range responseLanguages {
+		if strings.ToLower(lang.name) == s {
+			return lang, true
+		}
+	}
+
+	return responseLanguage{}, false
+}
+
+// checkResponseLanguage returns an error if opts set a response language
+// that isn't known
+func checkResponseLanguage(opts api.Options) error {
+	if opts.ResponseLanguage == "" {
+		return nil
+	}
+
+	if _, ok := lookupResponseLanguage(opts.ResponseLanguage); !ok {
+		return fmt.Errorf("%w %q isn't a supported language", errResponseLanguage, opts.ResponseLanguage)
+	}
+
+	return nil
+}
+
+// responseScripts returns the scripts responses are constrained to with
+// opts, or nil if they aren't
+func responseScripts(opts *api.Options) []string {
+	if lang, ok := lookupResponseLanguage(opts.ResponseLanguage); ok {
+		return lang.scripts
+	}
+
+	return nil
+}
+
+// withResponseLanguage asks the model to respond in the language set with
+// opts, in a system message or, if tmpl doesn't render system messages, the
+// latest user message. msgs are returned as is if no language is set.
+func withResponseLanguage(msgs []api.Message, tmpl *template.Template, opts *api.Options) []api.Message {
+	lang, ok := lookupResponseLanguage(opts.ResponseLanguage)
+	if !ok {
+		return msgs
+	}
+
+	prompt := fmt.Sprintf("Respond only in %s, even if the question or other messages are in another language.", lang.name)
+	if !tmpl.HandlesRole("system") {
+		for i := len(msgs) - 1; i >= 0; i-- {
+			if msgs[i].Role == "user" {
+				msgs = append([]api.Message(nil), msgs...)
+				msgs[i].Content = prompt + "\n\n" + msgs[i].Content
+				return msgs
+			}
+		}
+
+		return msgs
+	}
+
+	if len(msgs) > 0 && msgs[0].Role == "system" {
+		return append([]api.Message{{Role: "system", Content: msgs[0].Content + "\n\n" + prompt}}, msgs[1:]...)
+	}
+
+	return append([]api.Message{{Role: "system", Content: prompt}}, msgs...)
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/template"
+)
+
+func TestLookupResponseLanguage(t *testing.T) {
+	cases := []struct {
+		in   string
+		name string
+	}{
+		{in: "ja", name: "Japanese"},
+		{in: "FR", name: "French"},
+		{in: "german", name: "German"},
+		{in: " Korean ", name: "Korean"},
+		{in: "klingon"},
+		{in: ""},
+	}
+
+	for _, tt := range cases {
+		lang, ok := lookupResponseLanguage(tt.in)
+		if ok != (tt.name != "") || lang.name != tt.name {
+			t.Errorf("%q: expected %q, got %q", tt.in, tt.name, lang.name)
+		}
+	}
+}
+
+func TestWithResponseLanguage(t *testing.T) {
+	parse := func(s string) *template.Template {
+		t.Helper()
+		tmpl, err := template.Parse(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tmpl
+	}
+
+	system := parse(`{{ range .Messages }}{{ .Role }}: {{ .Content }}{{ end }}`)
+	user := parse(`{{ range .Messages }}{{ if eq .Role "user" }}{{ .Content }}{{ end }}{{ end }}`)
+	prompt := "Respond only in Japanese, even if the question or other messages are in another language."
+
+	cases := []struct {
+		name string
+		tmpl *template.Template
+		lang string
+		msgs []api.Message
+		want []api.Message
+	}{
+		{
+			name: "system message",
+			tmpl: system,
+			lang: "ja",
+			msgs: []api.Message{{Role: "user", Content: "hello"}},
+			want: []api.Message{{Role: "system", Content: prompt}, {Role: "user", Content: "hello"}},
+		},
+		{
+			name: "existing system message",
+			tmpl: system,
+			lang: "Japanese",
+			msgs: []api.Message{{Role: "system", Content: "Be brief."}, {Role: "user", Content: "hello"}},
+			want: []api.Message{{Role: "system", Content: "Be brief.\n\n" + prompt}, {Role: "user", Content: "hello"}},
+		},
+		{
+			name: "no system role",
+			tmpl: user,
+			lang: "ja",
+			msgs: []api.Message{{Role: "user", Content: "first"}, {Role: "assistant", Content: "ok"}, {Role: "user", Content: "hello"}},
+			want: []api.Message{{Role: "user", Content: "first"}, {Role: "assistant", Content: "ok"}, {Role: "user", Content: prompt + "\n\nhello"}},
+		},
+		{
+			name: "no language",
+			tmpl: system,
+			msgs: []api.Message{{Role: "user", Content: "hello"}},
+			want: []api.Message{{Role: "user", Content: "hello"}},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			if diff := cmp.Diff(tt.want, withResponseLanguage(tt.msgs, tt.tmpl, &api.Options{ResponseLanguage: tt.lang})); diff != "" {
+				t.Errorf("messages mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestChatResponseLanguage(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var req llm.CompletionRequest
+	mock := mockRunner{
+		CompletionFn: func(_ context.Context, r llm.CompletionRequest, fn func(llm.CompletionResponse)) error {
+			req = r
+			fn(llm.CompletionResponse{Content: "こんにちは", Done: true, DoneReason: "stop"})
+			return nil
+		},
+	}
+
+	s := Server{sched: newMockScheduler(t, &mock)}
+	createMockModel(t, &s, "test", `{{- range .Messages }}{{ .Role }}: {{ .Content }}
+{{ end }}`)
+
+	w := createRequest(t, s.ChatHandler, api.ChatRequest{
+		Model:    "test",
+		Messages: []api.Message{{Role: "user", Content: "hello"}},
+		Options:  map[string]any{"response_language": "ja"},
+		Stream:   &stream,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+	}
+
+	if !strings.Contains(req.Prompt, "Respond only in Japanese") {
+		t.Errorf("expected the prompt to ask for Japanese, got %q", req.Prompt)
+	}
+
+	if !slices.Equal(req.Scripts, []string{"Han", "Hiragana", "Katakana"}) {
+		t.Errorf("unexpected scripts %v", req.Scripts)
+	}
+
+	w = createRequest(t, s.ChatHandler, api.ChatRequest{
+		Model:    "test",
+		Messages: []api.Message{{Role: "user", Content: "hello"}},
+		Options:  map[string]any{"response_language": "klingon"},
+		Stream:   &stream,
+	})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for an unknown language, got %d: %s", w.Code, w.Body)
+	}
+}
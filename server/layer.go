@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"crypto/sha256"
 	"errors"
 	"fmt"
@@ -121,10 +122,10 @@ func (l *Layer) Remove() error {
 		}
 	}
 
-	blob, err := GetBlobsPath(l.Digest)
-	if err != nil {
-		return err
+	if s := blobStore(l.Digest); s != nil && s.readOnly {
+		// the shared stores are read-only
+		return nil
 	}
 
-	return os.Remove(blob)
+	return localStore().Delete(context.Background(), blobKey(l.Digest))
 }
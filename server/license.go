@@ -0,0 +1,188 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/types/model"
+)
+
+var (
+	errLicenseNotAccepted = errors.New("requires its license to be accepted before it's run")
+	errNoLicense          = errors.New("model doesn't have a license")
+)
+
+// licenses guards the file of accepted licenses
+var licenses sync.Mutex
+
+// licensesPath returns the path of the file of accepted licenses. It's kept
+// with the models so acceptances are shared by everyone using them.
+func licensesPath() string {
+	return filepath.Join(envconfig.Models(), "licenses.json")
+}
+
+// acceptedLicenses returns when each accepted license was accepted, by the
+// digest of its layer
+func acceptedLicenses() (map[string]time.Time, error) {
+	accepted := make(map[string]time.Time)
+
+	f, err := os.Open(licensesPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return accepted, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if err := json.NewDecoder(f).Decode(&accepted); err != nil {
+		return nil, fmt.Errorf("reading accepted licenses: %w", err)
+	}
+
+	return accepted, nil
+}
+
+// licenseAcceptance returns whether the licenses of m have been accepted, or
+// nil if m doesn't require it
+func licenseAcceptance(m *Model) (*api.LicenseAcceptance, error) {
+	if !m.Config.RequireLicenseAcceptance {
+		return nil, nil
+	}
+
+	licenses.Lock()
+	defer licenses.Unlock()
+
+	accepted, err := acceptedLicenses()
+	if err != nil {
+		return nil, err
+	}
+
+	var acceptance api.LicenseAcceptance
+	for _, digest := range m.LicenseDigests {
+		t, ok := accepted[digest]
+		if !ok {
+			return &api.LicenseAcceptance{}, nil
+		}
+
+		if t.After(acceptance.AcceptedAt) {
+			acceptance.AcceptedAt = t
+		}
+	}
+
+	acceptance.Accepted = true
+	return &acceptance, nil
+}
+
+// checkLicense returns an error if m requires its license to be accepted and
+// it hasn't been
+func checkLicense(m *Model) error {
+	acceptance, err := licenseAcceptance(m)
+	if err != nil {
+		return err
+	}
+
+	if acceptance != nil && !acceptance.Accepted {
+		return errLicenseNotAccepted
+	}
+
+	return nil
+}
+
+// acceptLicense records the acceptance of the licenses of m
+func acceptLicense(m *Model) (*api.LicenseAcceptance, error) {
+	if len(m.LicenseDigests) == 0 {
+		return nil, errNoLicense
+	}
+
+	licenses.Lock()
+	defer licenses.Unlock()
+
+	accepted, err := acceptedLicenses()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	for _, digest := range m.LicenseDigests {
+		if _, ok := accepted[digest]; !ok {
+			accepted[digest] = now
+		}
+	}
+
+	b, err := json.MarshalIndent(accepted, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	// the file is replaced rather than written in place so it's never left
+	// partially written
+	temp, err := os.CreateTemp(envconfig.Models(), "licenses-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(temp.Name())
+
+	if _, err := temp.Write(b); err != nil {
+		temp.Close()
+		return nil, err
+	}
+
+	if err := temp.Close(); err != nil {
+		return nil, err
+	}
+
+	if err := os.Rename(temp.Name(), licensesPath()); err != nil {
+		return nil, err
+	}
+
+	slog.Info("license accepted", "model", m.ShortName, "digests", m.LicenseDigests)
+	return &api.LicenseAcceptance{Accepted: true, AcceptedAt: now}, nil
+}
+
+func (s *Server) AcceptLicenseHandler(c *gin.Context) {
+	var req api.AcceptLicenseRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	name, err := getExistingName(model.ParseName(req.Model))
+	if err != nil {
+		c.JSON(http.StatusNotFound, errorBody(api.ErrorCodeModelNotFound, fmt.Sprintf("model '%s' not found", req.Model)))
+		return
+	}
+
+	m, err := GetModel(name.String())
+	if errors.Is(err, os.ErrNotExist) {
+		c.JSON(http.StatusNotFound, errorBody(api.ErrorCodeModelNotFound, fmt.Sprintf("model '%s' not found", req.Model)))
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	acceptance, err := acceptLicense(m)
+	if errors.Is(err, errNoLicense) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, acceptance)
+}
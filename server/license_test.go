@@ -0,0 +1,121 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestLicenseAcceptance(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	var s Server
+
+	show := func(t *testing.T, name string) api.ShowResponse {
+		t.Helper()
+		w := createRequest(t, s.ShowHandler, api.ShowRequest{Model: name})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+
+		var resp api.ShowResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		return resp
+	}
+
+	_, digest := createBinFile(t, nil, nil)
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:                    "test",
+		Files:                    map[string]string{"test.gguf": digest},
+		License:                  "MIT",
+		RequireLicenseAcceptance: true,
+		Stream:                   &stream,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+	}
+
+	w = createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:  "derived",
+		From:   "test",
+		System: "You are a helpful assistant.",
+		Stream: &stream,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+	}
+
+	for _, name := range []string{"test", "derived"} {
+		if resp := show(t, name); resp.LicenseAcceptance == nil || resp.LicenseAcceptance.Accepted {
+			t.Errorf("%s: expected an unaccepted license, got %+v", name, resp.LicenseAcceptance)
+		}
+
+		if _, _, _, err := s.scheduleRunner(context.Background(), name, nil, nil, nil); !errors.Is(err, errLicenseNotAccepted) {
+			t.Errorf("%s: expected %v, got %v", name, errLicenseNotAccepted, err)
+		}
+	}
+
+	w = createRequest(t, s.AcceptLicenseHandler, api.AcceptLicenseRequest{Model: "test"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+	}
+
+	// the models share the license so accepting it for one accepts it for both
+	for _, name := range []string{"test", "derived"} {
+		if resp := show(t, name); resp.LicenseAcceptance == nil || !resp.LicenseAcceptance.Accepted || resp.LicenseAcceptance.AcceptedAt.IsZero() {
+			t.Errorf("%s: expected an accepted license, got %+v", name, resp.LicenseAcceptance)
+		}
+
+		m, err := GetModel(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := checkLicense(m); err != nil {
+			t.Errorf("%s: unexpected error %v", name, err)
+		}
+	}
+
+	t.Run("not required", func(t *testing.T) {
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:   "open",
+			Files:   map[string]string{"test.gguf": digest},
+			License: "MIT",
+			Stream:  &stream,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+
+		if resp := show(t, "open"); resp.LicenseAcceptance != nil {
+			t.Errorf("expected no license acceptance, got %+v", resp.LicenseAcceptance)
+		}
+	})
+
+	t.Run("without license", func(t *testing.T) {
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:                    "unlicensed",
+			Files:                    map[string]string{"test.gguf": digest},
+			RequireLicenseAcceptance: true,
+			Stream:                   &stream,
+		})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d: %s", w.Code, w.Body)
+		}
+
+		w = createRequest(t, s.AcceptLicenseHandler, api.AcceptLicenseRequest{Model: "unlicensed"})
+		if w.Code != http.StatusNotFound {
+			t.Errorf("expected status 404, got %d: %s", w.Code, w.Body)
+		}
+	})
+}
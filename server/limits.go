@@ -0,0 +1,207 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/openai"
+)
+
+// requestLimit bounds the requests to paths matching pattern. A pattern
+// ending in "*" matches any path with that prefix.
+type requestLimit struct {
+	pattern string
+
+	// concurrency is the maximum number of requests in flight, or 0 for no limit
+	concurrency int
+
+	// rate is the number of requests allowed per second with bursts of up to
+	// burst requests, or 0 for no limit
+	rate  float64
+	burst int
+}
+
+func (l requestLimit) matches(path string) bool {
+	if prefix, ok := strings.CutSuffix(l.pattern, "*"); ok {
+		return strings.HasPrefix(path, prefix)
+	}
+
+	return path == l.pattern
+}
+
+// parseRequestLimits parses semicolon separated limits of the form
+// "pattern:key=value,...", e.g. "/v1/*:concurrency=4,rate=60/m". Keys are
+// concurrency, the maximum requests in flight, and rate, the requests allowed
+// per second (/s), minute (/m) or hour (/h).
+func parseRequestLimits(s string) ([]requestLimit, error) {
+	var limits []requestLimit
+	for _, entry := range strings.Split(s, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		pattern, settings, ok := strings.Cut(entry, ":")
+		if !ok || !strings.HasPrefix(pattern, "/") {
+			return nil, fmt.Errorf("invalid request limit %q: expected /path:key=value", entry)
+		}
+
+		l := requestLimit{pattern: strings.TrimSpace(pattern)}
+		for _, setting := range strings.Split(settings, ",") {
+			key, value, ok := strings.Cut(strings.TrimSpace(setting), "=")
+			if !ok {
+				return nil, fmt.Errorf("invalid request limit %q: expected key=value, got %q", entry, setting)
+			}
+
+			switch key {
+			case "concurrency":
+				n, err := strconv.Atoi(value)
+				if err != nil || n < 0 {
+					return nil, fmt.Errorf("invalid request limit %q: invalid concurrency %q", entry, value)
+				}
+
+				l.concurrency = n
+			case "rate":
+				count, per, _ := strings.Cut(value, "/")
+				n, err := strconv.Atoi(count)
+				if err != nil || n < 0 {
+					return nil, fmt.Errorf("invalid request limit %q: invalid rate %q", entry, value)
+				}
+
+				var window time.Duration
+				switch per {
+				case "", "s":
+					window = time.Second
+				case "m":
+					window = time.Minute
+				case "h":
+					window = time.Hour
+				default:
+					return nil, fmt.Errorf("invalid request limit %q: invalid rate %q", entry, value)
+				}
+
+				l.rate, l.burst = float64(n)/window.Seconds(), n
+			default:
+				return nil, fmt.Errorf("invalid request limit %q: unknown key %q", entry, key)
+			}
+		}
+
+		limits = append(limits, l)
+	}
+
+	return limits, nil
+}
+
+// requestLimiter enforces a requestLimit with a count of requests in flight
+// and a token bucket refilled at the limit's rate
+type requestLimiter struct {
+	requestLimit
+	now func() time.Time
+
+	mu       sync.Mutex
+	inflight int
+	tokens   float64
+	last     time.Time
+}
+
+func newRequestLimiter(l requestLimit) *requestLimiter {
+	return &requestLimiter{requestLimit: l, now: time.Now, tokens: float64(l.burst)}
+}
+
+// acquire admits a request, returning false and how long to wait before
+// retrying if the limit has been reached. Admitted requests must call release.
+func (l *requestLimiter) acquire() (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.concurrency > 0 && l.inflight >= l.concurrency {
+		return false, time.Second
+	}
+
+	if l.rate > 0 {
+		now := l.now()
+		if !l.last.IsZero() {
+			l.tokens = min(float64(l.burst), l.tokens+now.Sub(l.last).Seconds()*l.rate)
+		}
+		l.last = now
+
+		if l.tokens < 1 {
+			return false, time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+		}
+
+		l.tokens--
+	}
+
+	l.inflight++
+	return true, 0
+}
+
+func (l *requestLimiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inflight--
+}
+
+// requestLimitsMiddleware rejects requests that exceed any of the limits
+// matching their path with 429 Too Many Requests. Requests to the OpenAI
+// compatible endpoints receive OpenAI formatted errors.
+func requestLimitsMiddleware(limiters []*requestLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		path := c.Request.URL.Path
+
+		var acquired []*requestLimiter
+		defer func() {
+			for _, l := range acquired {
+				l.release()
+			}
+		}()
+
+		for _, l := range limiters {
+			if !l.matches(path) {
+				continue
+			}
+
+			ok, retryAfter := l.acquire()
+			if !ok {
+				msg := fmt.Sprintf("too many requests to %s, please try again later", path)
+				c.Header("Retry-After", strconv.FormatInt(int64(math.Ceil(retryAfter.Seconds())), 10))
+				if strings.HasPrefix(path, "/v1/") {
+					c.AbortWithStatusJSON(http.StatusTooManyRequests, openai.NewError(http.StatusTooManyRequests, msg))
+				} else {
+					c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": msg})
+				}
+				return
+			}
+
+			acquired = append(acquired, l)
+		}
+
+		c.Next()
+	}
+}
+
+// requestLimiters returns limiters for the limits configured with
+// OLLAMA_REQUEST_LIMITS
+func requestLimiters() []*requestLimiter {
+	limits, err := parseRequestLimits(envconfig.RequestLimits())
+	if err != nil {
+		slog.Warn("ignoring OLLAMA_REQUEST_LIMITS", "error", err)
+		return nil
+	}
+
+	limiters := make([]*requestLimiter, len(limits))
+	for i, l := range limits {
+		limiters[i] = newRequestLimiter(l)
+	}
+
+	return limiters
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/openai"
+)
+
+func TestParseRequestLimits(t *testing.T) {
+	cases := []struct {
+		in   string
+		want []requestLimit
+		err  bool
+	}{
+		{in: ""},
+		{
+			in: "/v1/*:concurrency=4,rate=60/m; /api/chat:rate=2",
+			want: []requestLimit{
+				{pattern: "/v1/*", concurrency: 4, rate: 1, burst: 60},
+				{pattern: "/api/chat", rate: 2, burst: 2},
+			},
+		},
+		{in: "/api/*:rate=3600/h", want: []requestLimit{{pattern: "/api/*", rate: 1, burst: 3600}}},
+		{in: "/api/*", err: true},
+		{in: "api:concurrency=1", err: true},
+		{in: "/api/*:concurrency=-1", err: true},
+		{in: "/api/*:rate=1/d", err: true},
+		{in: "/api/*:burst=1", err: true},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseRequestLimits(tt.in)
+			if tt.err != (err != nil) {
+				t.Fatalf("unexpected error %v", err)
+			}
+
+			if diff := cmp.Diff(tt.want, got, cmp.AllowUnexported(requestLimit{})); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRequestLimiter(t *testing.T) {
+	now := time.Now()
+	l := newRequestLimiter(requestLimit{pattern: "/v1/*", concurrency: 2, rate: 1, burst: 3})
+	l.now = func() time.Time { return now }
+
+	if ok, _ := l.acquire(); !ok {
+		t.Fatal("expected first request to be admitted")
+	}
+
+	if ok, _ := l.acquire(); !ok {
+		t.Fatal("expected second request to be admitted")
+	}
+
+	if ok, _ := l.acquire(); ok {
+		t.Fatal("expected request over concurrency limit to be rejected")
+	}
+
+	l.release()
+	l.release()
+
+	if ok, _ := l.acquire(); !ok {
+		t.Fatal("expected third request to be admitted")
+	}
+	l.release()
+
+	ok, retryAfter := l.acquire()
+	if ok {
+		t.Fatal("expected request over rate limit to be rejected")
+	}
+
+	if retryAfter != time.Second {
+		t.Errorf("expected retry after 1s, got %s", retryAfter)
+	}
+
+	now = now.Add(time.Second)
+	if ok, _ := l.acquire(); !ok {
+		t.Fatal("expected request to be admitted after refill")
+	}
+}
+
+func TestRequestLimitsMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	limits, err := parseRequestLimits("/v1/*:concurrency=1;/api/chat:rate=1/h")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var limiters []*requestLimiter
+	for _, l := range limits {
+		limiters = append(limiters, newRequestLimiter(l))
+	}
+
+	block, release := make(chan struct{}), make(chan struct{})
+	r := gin.New()
+	r.Use(requestLimitsMiddleware(limiters))
+	r.POST("/v1/chat/completions", func(c *gin.Context) {
+		block <- struct{}{}
+		<-release
+		c.Status(http.StatusOK)
+	})
+	r.POST("/api/chat", func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.POST("/api/generate", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	do := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, nil))
+		return w
+	}
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- do("/v1/chat/completions") }()
+	<-block
+
+	w := do("/v1/chat/completions")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected status 429, got %d", w.Code)
+	}
+
+	if w.Header().Get("Retry-After") != "1" {
+		t.Errorf("expected Retry-After 1, got %q", w.Header().Get("Retry-After"))
+	}
+
+	var resp openai.ErrorResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.Error.Type != "rate_limit_error" {
+		t.Errorf("expected rate_limit_error, got %q", resp.Error.Type)
+	}
+
+	close(release)
+	if w := <-done; w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	// the concurrency slot is released once the request completes
+	go func() { <-block }()
+	if w := do("/v1/chat/completions"); w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	if w := do("/api/chat"); w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	if w := do("/api/chat"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected status 429, got %d", w.Code)
+	}
+
+	// other endpoints are not limited
+	if w := do("/api/generate"); w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+}
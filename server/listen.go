@@ -0,0 +1,157 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+
+	"github.com/ollama/ollama/envconfig"
+)
+
+const (
+	// httpIdleTimeout closes keep-alive connections that have been idle this
+	// long, so idle clients don't hold connections open indefinitely
+	httpIdleTimeout = 5 * time.Minute
+
+	// http2MaxStreams is the number of concurrent streams an HTTP/2 client
+	// can open on one connection, which is enough for hundreds of streaming
+	// requests
+	http2MaxStreams = 1000
+)
+
+// keyListener is a listener whose clients must present one of its API keys,
+// by digest. The keys have every scope.
+type keyListener struct {
+	net.Listener
+	keys map[string]apiKey
+}
+
+// Listen listens on l. A stale Unix socket left by a server that didn't shut
+// down cleanly is replaced, but not one another server is listening on.
+func Listen(l envconfig.Listener) (net.Listener, error) {
+	var keys map[string]apiKey
+	if l.KeyFile != "" {
+		var err error
+		keys, err = readKeyFile(l.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if l.Network == "unix" {
+		if fi, err := os.Lstat(l.Address); err == nil && fi.Mode()&fs.ModeSocket != 0 {
+			if conn, err := net.DialTimeout("unix", l.Address, time.Second); err == nil {
+				conn.Close()
+				return nil, fmt.Errorf("listen unix %s: another server is listening on it", l.Address)
+			}
+
+			if err := os.Remove(l.Address); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	ln, err := net.Listen(l.Network, l.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	if l.KeyFile != "" {
+		return &keyListener{Listener: ln, keys: keys}, nil
+	}
+
+	return ln, nil
+}
+
+// readKeyFile reads API keys, one per line, into a map of their digests.
+// Blank lines and lines starting with # are ignored.
+func readKeyFile(path string) (map[string]apiKey, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("key file: %w", err)
+	}
+	defer f.Close()
+
+	keys := make(map[string]apiKey)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		keys[apiKeyDigest(line)] = apiKey{Scopes: []apiScope{scopeAdmin}}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("key file %s: %w", path, err)
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("key file %s: no keys", path)
+	}
+
+	return keys, nil
+}
+
+// listenerHandler wraps h so requests on ln carry its API keys, which
+// apiKeysMiddleware requires them to present
+func listenerHandler(ln net.Listener, h http.Handler) http.Handler {
+	kl, ok := ln.(*keyListener)
+	if !ok {
+		return h
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, r.WithContext(withListenerKeys(r.Context(), kl.keys)))
+	})
+}
+
+// configureHTTP sets keep-alive limits on srvr and enables HTTP/2 without TLS
+// if OLLAMA_HTTP2 is set
+func configureHTTP(srvr *http.Server) {
+	srvr.IdleTimeout = httpIdleTimeout
+	if envconfig.HTTP2() {
+		srvr.Handler = h2c.NewHandler(srvr.Handler, &http2.Server{
+			MaxConcurrentStreams: http2MaxStreams,
+			IdleTimeout:          httpIdleTimeout,
+		})
+	}
+}
+
+// serveAll runs each server on its listener. It returns once every server
+// has stopped, closing the others if one fails.
+func serveAll(srvrs []*http.Server, lns []net.Listener) error {
+	errCh := make(chan error, len(lns))
+	for i, ln := range lns {
+		go func() {
+			errCh <- srvrs[i].Serve(ln)
+		}()
+	}
+
+	var err error
+	for range lns {
+		if e := <-errCh; !errors.Is(e, http.ErrServerClosed) && err == nil {
+			err = e
+			// stop the other servers
+			for _, srvr := range srvrs {
+				srvr.Close()
+			}
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return http.ErrServerClosed
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/envconfig"
+)
+
+func TestListenUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ollama.sock")
+
+	// a socket left behind by a server that didn't shut down cleanly
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Skip("unix sockets aren't supported:", err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	ln, err := Listen(envconfig.Listener{Network: "unix", Address: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	srvr := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Ollama is running"))
+	})}
+	go srvr.Serve(ln)
+	defer srvr.Close()
+
+	client := http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+
+	resp, err := client.Get("http://ollama/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected status 200, got %d", resp.StatusCode)
+	}
+}
+
+func TestListenUnixInUse(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ollama.sock")
+	other, err := net.Listen("unix", path)
+	if err != nil {
+		t.Skip("unix sockets aren't supported:", err)
+	}
+	defer other.Close()
+
+	if _, err := Listen(envconfig.Listener{Network: "unix", Address: path}); err == nil {
+		t.Fatal("expected an error listening on a socket another server is listening on")
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("expected the other server's socket to be kept: %v", err)
+	}
+}
+
+func TestListenKeys(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "keys")
+	if err := os.WriteFile(keyFile, []byte("# clients\nkey-one\n\n  key-two  \n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	ln, err := Listen(envconfig.Listener{Network: "tcp", Address: "127.0.0.1:0", KeyFile: keyFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// keys for every listener are accepted too
+	t.Setenv("OLLAMA_API_KEYS", "global-key")
+
+	gin.SetMode(gin.TestMode)
+	var s Server
+	h := listenerHandler(ln, s.GenerateRoutes())
+
+	cases := []struct {
+		name   string
+		method string
+		auth   string
+		status int
+	}{
+		{"no key", http.MethodGet, "", http.StatusUnauthorized},
+		{"wrong key", http.MethodGet, "Bearer key-three", http.StatusUnauthorized},
+		{"not bearer", http.MethodGet, "key-one", http.StatusUnauthorized},
+		{"first key", http.MethodGet, "Bearer key-one", http.StatusOK},
+		{"second key", http.MethodGet, "Bearer key-two", http.StatusOK},
+		{"global key", http.MethodGet, "Bearer global-key", http.StatusOK},
+		{"public route", http.MethodGet, "", http.StatusOK},
+		{"preflight", http.MethodOptions, "", http.StatusNoContent},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			path := "/api/tags"
+			if tt.name == "public route" {
+				path = "/api/version"
+			}
+
+			r := httptest.NewRequest(tt.method, path, nil)
+			if tt.auth != "" {
+				r.Header.Set("Authorization", tt.auth)
+			}
+			if tt.method == http.MethodOptions {
+				r.Header.Set("Origin", "http://localhost")
+				r.Header.Set("Access-Control-Request-Method", http.MethodGet)
+			}
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Errorf("expected status %d, got %d", tt.status, w.Code)
+			}
+		})
+	}
+
+	t.Run("no key file", func(t *testing.T) {
+		if _, err := Listen(envconfig.Listener{Network: "tcp", Address: "127.0.0.1:0", KeyFile: filepath.Join(t.TempDir(), "missing")}); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+
+	t.Run("empty key file", func(t *testing.T) {
+		empty := filepath.Join(t.TempDir(), "keys")
+		if err := os.WriteFile(empty, []byte("# no keys yet\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := Listen(envconfig.Listener{Network: "tcp", Address: "127.0.0.1:0", KeyFile: empty}); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+}
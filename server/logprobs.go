@@ -0,0 +1,21 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+)
+
+// maxTopLogprobs is the most alternatives returned for each generated token
+const maxTopLogprobs = 20
+
+// checkLogprobs returns an error if the logprobs requested are invalid
+func checkLogprobs(logprobs bool, top int) error {
+	switch {
+	case top < 0 || top > maxTopLogprobs:
+		return fmt.Errorf("top_logprobs must be between 0 and %d", maxTopLogprobs)
+	case top > 0 && !logprobs:
+		return errors.New("top_logprobs requires logprobs")
+	}
+
+	return nil
+}
@@ -1,6 +1,8 @@
 package server
 
 import (
+	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
@@ -20,9 +22,12 @@ type Manifest struct {
 	Config        Layer   `json:"config"`
 	Layers        []Layer `json:"layers"`
 
-	filepath string
-	fi       os.FileInfo
-	digest   string
+	name   model.Name
+	fi     os.FileInfo
+	digest string
+
+	// store is the store on disk the manifest was read from
+	store *diskStore
 }
 
 func (m *Manifest) Size() (size int64) {
@@ -34,10 +39,18 @@ func (m *Manifest) Size() (size int64) {
 }
 
 func (m *Manifest) Remove() error {
-	if err := os.Remove(m.filepath); err != nil {
+	if m.store.readOnly {
+		return errSharedModel
+	}
+
+	if err := m.store.Delete(context.Background(), manifestKey(m.name)); err != nil {
 		return err
 	}
 
+	if err := deleteFromStore(context.Background(), m.name); err != nil {
+		slog.Warn("couldn't delete model from the model store", "model", m.name.DisplayShortest(), "error", err)
+	}
+
 	manifests, err := GetManifestPath()
 	if err != nil {
 		return err
@@ -65,112 +78,124 @@ func ParseNamedManifest(n model.Name) (*Manifest, error) {
 		return nil, model.Unqualified(n)
 	}
 
-	manifests, err := GetManifestPath()
-	if err != nil {
-		return nil, err
+	s := manifestStore(n)
+	if s == nil {
+		if err := fetchFromStore(context.Background(), n); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		s = localStore()
 	}
 
-	p := filepath.Join(manifests, n.Filepath())
-
-	var m Manifest
-	f, err := os.Open(p)
+	fi, err := s.stat(manifestKey(n))
 	if err != nil {
 		return nil, err
 	}
-	defer f.Close()
 
-	fi, err := f.Stat()
+	f, err := s.Open(context.Background(), manifestKey(n))
 	if err != nil {
 		return nil, err
 	}
+	defer f.Close()
 
+	var m Manifest
 	sha256sum := sha256.New()
 	if err := json.NewDecoder(io.TeeReader(f, sha256sum)).Decode(&m); err != nil {
 		return nil, err
 	}
 
-	m.filepath = p
+	m.name = n
 	m.fi = fi
 	m.digest = hex.EncodeToString(sha256sum.Sum(nil))
+	m.store = s
 
 	return &m, nil
 }
 
 func WriteManifest(name model.Name, config Layer, layers []Layer) error {
-	manifests, err := GetManifestPath()
-	if err != nil {
-		return err
+	m := Manifest{
+		SchemaVersion: 2,
+		MediaType:     "application/vnd.docker.distribution.manifest.v2+json",
+		Config:        config,
+		Layers:        layers,
 	}
 
-	p := filepath.Join(manifests, name.Filepath())
-	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+	var b bytes.Buffer
+	if err := json.NewEncoder(&b).Encode(m); err != nil {
 		return err
 	}
 
-	f, err := os.Create(p)
-	if err != nil {
+	if err := localStore().Put(context.Background(), manifestKey(name), &b, int64(b.Len())); err != nil {
 		return err
 	}
-	defer f.Close()
 
-	m := Manifest{
-		SchemaVersion: 2,
-		MediaType:     "application/vnd.docker.distribution.manifest.v2+json",
-		Config:        config,
-		Layers:        layers,
+	if err := pushToStore(context.Background(), name, &m); err != nil {
+		slog.Warn("couldn't copy model to the model store", "model", name.DisplayShortest(), "error", err)
 	}
 
-	return json.NewEncoder(f).Encode(m)
+	return nil
 }
 
 func Manifests(continueOnError bool) (map[model.Name]*Manifest, error) {
-	manifests, err := GetManifestPath()
-	if err != nil {
+	if _, err := GetManifestPath(); err != nil {
 		return nil, err
 	}
 
-	// TODO(mxyng): use something less brittle
-	matches, err := filepath.Glob(filepath.Join(manifests, "*", "*", "*", "*"))
-	if err != nil {
-		return nil, err
+	var dirs []string
+	for _, s := range diskStores() {
+		if !s.blobsOnly {
+			dirs = append(dirs, s.path("manifests"))
+		}
 	}
 
 	ms := make(map[model.Name]*Manifest)
-	for _, match := range matches {
-		fi, err := os.Stat(match)
+	for _, dir := range dirs {
+		// TODO(mxyng): use something less brittle
+		matches, err := filepath.Glob(filepath.Join(dir, "*", "*", "*", "*"))
 		if err != nil {
 			return nil, err
 		}
 
-		if !fi.IsDir() {
-			rel, err := filepath.Rel(manifests, match)
+		for _, match := range matches {
+			fi, err := os.Stat(match)
 			if err != nil {
-				if !continueOnError {
-					return nil, fmt.Errorf("%s %w", match, err)
-				}
-				slog.Warn("bad filepath", "path", match, "error", err)
-				continue
+				return nil, err
 			}
 
-			n := model.ParseNameFromFilepath(rel)
-			if !n.IsValid() {
-				if !continueOnError {
-					return nil, fmt.Errorf("%s %w", rel, err)
+			if !fi.IsDir() {
+				rel, err := filepath.Rel(dir, match)
+				if err != nil {
+					if !continueOnError {
+						return nil, fmt.Errorf("%s %w", match, err)
+					}
+					slog.Warn("bad filepath", "path", match, "error", err)
+					continue
 				}
-				slog.Warn("bad manifest name", "path", rel)
-				continue
-			}
 
-			m, err := ParseNamedManifest(n)
-			if err != nil {
-				if !continueOnError {
-					return nil, fmt.Errorf("%s %w", n, err)
+				n := model.ParseNameFromFilepath(rel)
+				if !n.IsValid() {
+					if !continueOnError {
+						return nil, fmt.Errorf("%s %w", rel, err)
+					}
+					slog.Warn("bad manifest name", "path", rel)
+					continue
 				}
-				slog.Warn("bad manifest", "name", n, "error", err)
-				continue
-			}
 
-			ms[n] = m
+				if _, ok := ms[n]; ok {
+					// local models take precedence over shared models
+					continue
+				}
+
+				m, err := ParseNamedManifest(n)
+				if err != nil {
+					if !continueOnError {
+						return nil, fmt.Errorf("%s %w", n, err)
+					}
+					slog.Warn("bad manifest", "name", n, "error", err)
+					continue
+				}
+
+				ms[n] = m
+			}
 		}
 	}
 
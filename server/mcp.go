@@ -0,0 +1,272 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/version"
+)
+
+// mcpProtocolVersion is the version of the Model Context Protocol the agent
+// speaks to MCP servers
+const mcpProtocolVersion = "2025-03-26"
+
+// mcpServer is an MCP server registered in OLLAMA_AGENT_TOOLS. Its tools are
+// listed and called over the Streamable HTTP transport.
+type mcpServer struct {
+	URL string `json:"url"`
+
+	// Headers are sent with every request, such as for authorization
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// mcpClient is a session with an MCP server
+type mcpClient struct {
+	mcpServer
+
+	mu      sync.Mutex
+	id      int
+	session string
+}
+
+type mcpMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      *int            `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  any             `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// connect starts a session with the server
+func (srv mcpServer) connect(ctx context.Context) (*mcpClient, error) {
+	c := &mcpClient{mcpServer: srv}
+
+	var result struct {
+		ProtocolVersion string `json:"protocolVersion"`
+	}
+	if err := c.request(ctx, "initialize", map[string]any{
+		"protocolVersion": mcpProtocolVersion,
+		"capabilities":    map[string]any{},
+		"clientInfo":      map[string]any{"name": "ollama", "version": version.Version},
+	}, &result); err != nil {
+		return nil, fmt.Errorf("mcp server %s: %w", srv.URL, err)
+	}
+
+	if err := c.notify(ctx, "notifications/initialized"); err != nil {
+		return nil, fmt.Errorf("mcp server %s: %w", srv.URL, err)
+	}
+
+	return c, nil
+}
+
+// tools lists the server's tools
+func (c *mcpClient) tools(ctx context.Context) ([]api.Tool, error) {
+	var tools []api.Tool
+	var cursor string
+	for {
+		params := map[string]any{}
+		if cursor != "" {
+			params["cursor"] = cursor
+		}
+
+		var result struct {
+			Tools []struct {
+				Name        string             `json:"name"`
+				Description string             `json:"description"`
+				InputSchema api.ToolParameters `json:"inputSchema"`
+			} `json:"tools"`
+			NextCursor string `json:"nextCursor"`
+		}
+		if err := c.request(ctx, "tools/list", params, &result); err != nil {
+			return nil, fmt.Errorf("mcp server %s: %w", c.URL, err)
+		}
+
+		for _, t := range result.Tools {
+			tools = append(tools, api.Tool{
+				Type:     "function",
+				Function: api.ToolFunction{Name: t.Name, Description: t.Description, Parameters: t.InputSchema},
+			})
+		}
+
+		if result.NextCursor == "" {
+			return tools, nil
+		}
+		cursor = result.NextCursor
+	}
+}
+
+// call calls the tool name and returns the text of its result
+func (c *mcpClient) call(ctx context.Context, name string, args api.ToolCallFunctionArguments) (string, error) {
+	var result struct {
+		Content []struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		} `json:"content"`
+		IsError bool `json:"isError"`
+	}
+	if err := c.request(ctx, "tools/call", map[string]any{"name": name, "arguments": args}, &result); err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	for _, content := range result.Content {
+		if sb.Len() > 0 {
+			sb.WriteString("\n")
+		}
+
+		if content.Type == "text" {
+			sb.WriteString(content.Text)
+		} else {
+			fmt.Fprintf(&sb, "[%s]", content.Type)
+		}
+	}
+
+	if result.IsError {
+		return "", errors.New(sb.String())
+	}
+
+	return sb.String(), nil
+}
+
+// request sends a request and decodes its result into result
+func (c *mcpClient) request(ctx context.Context, method string, params, result any) error {
+	c.mu.Lock()
+	c.id++
+	id := c.id
+	c.mu.Unlock()
+
+	resp, err := c.post(ctx, mcpMessage{JSONRPC: "2.0", ID: &id, Method: method, Params: params})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if session := resp.Header.Get("Mcp-Session-Id"); session != "" {
+		c.mu.Lock()
+		c.session = session
+		c.mu.Unlock()
+	}
+
+	msg, err := readMCPResponse(resp, id)
+	if err != nil {
+		return err
+	}
+
+	if msg.Error != nil {
+		return fmt.Errorf("%s: %s", method, msg.Error.Message)
+	}
+
+	return json.Unmarshal(msg.Result, result)
+}
+
+// notify sends a notification, which has no response
+func (c *mcpClient) notify(ctx context.Context, method string) error {
+	resp, err := c.post(ctx, mcpMessage{JSONRPC: "2.0", Method: method})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (c *mcpClient) post(ctx context.Context, msg mcpMessage) (*http.Response, error) {
+	bts, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, bytes.NewReader(bts))
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range c.Headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json, text/event-stream")
+
+	c.mu.Lock()
+	if c.session != "" {
+		req.Header.Set("Mcp-Session-Id", c.session)
+	}
+	c.mu.Unlock()
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxToolResult))
+		return nil, fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+
+	return resp, nil
+}
+
+// readMCPResponse reads the response to request id, which is either the
+// body or one of the events of a stream
+func readMCPResponse(resp *http.Response, id int) (*mcpMessage, error) {
+	body := io.LimitReader(resp.Body, maxToolResult)
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/event-stream" {
+		var msg mcpMessage
+		if err := json.NewDecoder(body).Decode(&msg); err != nil {
+			return nil, err
+		}
+
+		return &msg, nil
+	}
+
+	// requests and notifications from the server are skipped
+	var data bytes.Buffer
+	response := func() *mcpMessage {
+		defer data.Reset()
+		var msg mcpMessage
+		if err := json.Unmarshal(data.Bytes(), &msg); err == nil && msg.ID != nil && *msg.ID == id && msg.Method == "" {
+			return &msg
+		}
+		return nil
+	}
+
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxToolResult)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if value, ok := strings.CutPrefix(line, "data:"); ok {
+			data.WriteString(strings.TrimPrefix(value, " "))
+		} else if line == "" && data.Len() > 0 {
+			// a blank line ends an event
+			if msg := response(); msg != nil {
+				return msg, nil
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if msg := response(); msg != nil {
+		return msg, nil
+	}
+
+	return nil, errors.New("stream ended without a response")
+}
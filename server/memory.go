@@ -0,0 +1,743 @@
+package server
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/template"
+)
+
+const (
+	// memoryRecall is the most memories added to a chat
+	memoryRecall = 5
+	// memoryDuplicate is the similarity of the embeddings of a fact and a
+	// memory above which the fact is taken to repeat the memory
+	memoryDuplicate = 0.95
+	// memoryMaxKey is the longest memory key in bytes
+	memoryMaxKey = 256
+	// memoryExtractTimeout is the longest facts are extracted from a chat for
+	memoryExtractTimeout = 2 * time.Minute
+)
+
+var (
+	errMemory            = errors.New("invalid memory")
+	errMemoryNotFound    = errors.New("memory not found")
+	errMemoryUnavailable = errors.New("memory isn't available")
+	errMemoryOwned       = errors.New("memory key belongs to another API key")
+)
+
+// memoryPrompt asks the model for the facts of a chat turn worth remembering
+const memoryPrompt = `List the facts about the user in the conversation below that are worth remembering in future conversations, such as their name, preferences, plans and circumstances. Write each fact as a short sentence that stands on its own, on its own line starting with "- ". Leave out anything that only matters to this conversation. If there's nothing worth remembering, reply with only "NONE".`
+
+// memoriesPrompt is added to the system message of chats with memories
+const memoriesPrompt = "You remember these facts from earlier conversations with the user. Use them when they're relevant:"
+
+// memory is a fact remembered for a memory key
+type memory struct {
+	api.Memory
+
+	// Embeddings are the embeddings of the memory by the models that
+	// embedded it, which are cleared when its content changes
+	Embeddings map[string][]float32 `json:"embeddings,omitempty"`
+}
+
+// memoryStore keeps the long-term memories of chats by their memory key. The
+// memories are saved to the models directory whenever they change.
+type memoryStore struct {
+	mu   sync.Mutex
+	path string
+
+	Keys map[string][]*memory `json:"keys"`
+	// Owners are the digests of the API keys that own memory keys. Keys
+	// used without an API key, or with one with the admin scope, have no
+	// owner.
+	Owners map[string]string `json:"owners,omitempty"`
+
+	// embedding are the memory keys and models whose memories are being
+	// embedded in the background
+	embedding map[[2]string]bool
+}
+
+func memoriesPath() string {
+	return filepath.Join(envconfig.Models(), "memories.json")
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{path: memoriesPath(), Keys: make(map[string][]*memory)}
+}
+
+// readMemories reads the saved memories, starting without any if none were
+// saved yet
+func readMemories() (*memoryStore, error) {
+	s := newMemoryStore()
+	bts, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(bts, s); err != nil {
+		return nil, err
+	}
+
+	if s.Keys == nil {
+		s.Keys = make(map[string][]*memory)
+	}
+
+	return s, nil
+}
+
+// save writes the memories. It's called with mu held.
+func (s *memoryStore) save() error {
+	bts, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(s.path), "memories-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+
+	if _, err := temp.Write(bts); err != nil {
+		temp.Close()
+		return err
+	}
+
+	if err := temp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(temp.Name(), s.path)
+}
+
+func checkMemoryKey(key string) error {
+	if key == "" || len(key) > memoryMaxKey {
+		return fmt.Errorf("%w: keys must be 1 to %d bytes", errMemory, memoryMaxKey)
+	}
+
+	return nil
+}
+
+func checkMemoryContent(content string) error {
+	if strings.TrimSpace(content) == "" {
+		return fmt.Errorf("%w: content is required", errMemory)
+	}
+
+	return nil
+}
+
+// claim reports whether owner may use the memories of key, which it owns
+// from then on if no one has used it yet. Keys with memories but no owner
+// can only be used by requests without an owner.
+func (s *memoryStore) claim(key, owner string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if o, ok := s.Owners[key]; ok {
+		return owns(owner, o), nil
+	}
+
+	if owner == "" {
+		return true, nil
+	}
+
+	if len(s.Keys[key]) > 0 {
+		return false, nil
+	}
+
+	if s.Owners == nil {
+		s.Owners = make(map[string]string)
+	}
+
+	s.Owners[key] = owner
+	return true, s.save()
+}
+
+// list returns the memories of key, oldest first
+func (s *memoryStore) list(key string) []api.Memory {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	memories := make([]api.Memory, 0, len(s.Keys[key]))
+	for _, m := range s.Keys[key] {
+		memories = append(memories, m.Memory)
+	}
+
+	return memories
+}
+
+// add adds a memory of content to key, with the embeddings it has
+func (s *memoryStore) add(key, content string, embeddings map[string][]float32) (api.Memory, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC()
+	m := &memory{
+		Memory:     api.Memory{ID: uuid.NewString(), Content: strings.TrimSpace(content), CreatedAt: now, UpdatedAt: now},
+		Embeddings: embeddings,
+	}
+
+	s.Keys[key] = append(s.Keys[key], m)
+	return m.Memory, s.save()
+}
+
+// update replaces the content of the memory id of key
+func (s *memoryStore) update(key, id, content string) (api.Memory, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := slices.IndexFunc(s.Keys[key], func(m *memory) bool { return m.ID == id })
+	if i < 0 {
+		return api.Memory{}, errMemoryNotFound
+	}
+
+	m := s.Keys[key][i]
+	m.Content = strings.TrimSpace(content)
+	m.UpdatedAt = time.Now().UTC()
+	m.Embeddings = nil
+	return m.Memory, s.save()
+}
+
+// delete forgets the memory id of key, or every memory of key if id is empty
+func (s *memoryStore) delete(key, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if id == "" {
+		if _, ok := s.Keys[key]; !ok {
+			return errMemoryNotFound
+		}
+
+		delete(s.Keys, key)
+		return s.save()
+	}
+
+	i := slices.IndexFunc(s.Keys[key], func(m *memory) bool { return m.ID == id })
+	if i < 0 {
+		return errMemoryNotFound
+	}
+
+	s.Keys[key] = slices.Delete(s.Keys[key], i, i+1)
+	if len(s.Keys[key]) == 0 {
+		delete(s.Keys, key)
+	}
+
+	return s.save()
+}
+
+// embedder returns the normalized embedding of input
+type embedder func(ctx context.Context, input string) ([]float32, error)
+
+func normalizedEmbedder(r llm.LlamaServer) embedder {
+	return func(ctx context.Context, input string) ([]float32, error) {
+		embedding, err := r.Embedding(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+
+		return normalize(embedding), nil
+	}
+}
+
+// embedded returns the memories of key that model embedded and their
+// embeddings, and whether any memories of key haven't been embedded by it
+func (s *memoryStore) embedded(key, model string) ([]*memory, [][]float32, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var memories []*memory
+	var embeddings [][]float32
+	var missing bool
+	for _, m := range s.Keys[key] {
+		if e := m.Embeddings[model]; e != nil {
+			memories = append(memories, m)
+			embeddings = append(embeddings, e)
+		} else {
+			missing = true
+		}
+	}
+
+	return memories, embeddings, missing
+}
+
+// startEmbedding reports whether the memories of key can start being
+// embedded by model, which they can't while they already are
+func (s *memoryStore) startEmbedding(key, model string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.embedding[[2]string{key, model}] {
+		return false
+	}
+
+	if s.embedding == nil {
+		s.embedding = make(map[[2]string]bool)
+	}
+	s.embedding[[2]string{key, model}] = true
+	return true
+}
+
+func (s *memoryStore) doneEmbedding(key, model string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.embedding, [2]string{key, model})
+}
+
+// embeddings returns the memories of key embedded by model, embedding the
+// memories that weren't already
+func (s *memoryStore) embeddings(ctx context.Context, key, model string, embed embedder) ([]*memory, [][]float32, error) {
+	s.mu.Lock()
+	memories := slices.Clone(s.Keys[key])
+	embeddings := make([][]float32, len(memories))
+	contents := make([]string, len(memories))
+	for i, m := range memories {
+		embeddings[i], contents[i] = m.Embeddings[model], m.Content
+	}
+	s.mu.Unlock()
+
+	var embedded bool
+	for i := range memories {
+		if embeddings[i] != nil {
+			continue
+		}
+
+		embedding, err := embed(ctx, contents[i])
+		if err != nil {
+			return nil, nil, err
+		}
+
+		embeddings[i], embedded = embedding, true
+	}
+
+	if !embedded {
+		return memories, embeddings, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, m := range memories {
+		// the memory may have been edited while it was embedded
+		if m.Content != contents[i] {
+			continue
+		}
+
+		if m.Embeddings == nil {
+			m.Embeddings = make(map[string][]float32)
+		}
+		m.Embeddings[model] = embeddings[i]
+	}
+
+	if err := s.save(); err != nil {
+		slog.Warn("failed to save memories", "error", err)
+	}
+
+	return memories, embeddings, nil
+}
+
+// recall returns the contents of the memories of key most relevant to query,
+// most relevant first. Only memories already embedded by model are recalled.
+func (s *memoryStore) recall(ctx context.Context, key, model, query string, embed embedder) ([]string, error) {
+	memories, embeddings, _ := s.embedded(key, model)
+	if len(memories) == 0 {
+		return nil, nil
+	}
+
+	q, err := embed(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	scores := make([]float32, len(memories))
+	order := make([]int, len(memories))
+	for i, embedding := range embeddings {
+		scores[i], order[i] = dot(q, embedding), i
+	}
+
+	slices.SortStableFunc(order, func(a, b int) int { return cmp.Compare(scores[b], scores[a]) })
+
+	var recalled []string
+	for _, i := range order[:min(len(order), memoryRecall)] {
+		recalled = append(recalled, memories[i].Content)
+	}
+
+	return recalled, nil
+}
+
+// remember adds the facts to the memories of key that don't repeat one
+func (s *memoryStore) remember(ctx context.Context, key, model string, facts []string, embed embedder) error {
+	_, embeddings, err := s.embeddings(ctx, key, model, embed)
+	if err != nil {
+		return err
+	}
+
+	for _, fact := range facts {
+		embedding, err := embed(ctx, fact)
+		if err != nil {
+			return err
+		}
+
+		if slices.ContainsFunc(embeddings, func(e []float32) bool { return dot(embedding, e) >= memoryDuplicate }) {
+			continue
+		}
+
+		if _, err := s.add(key, fact, map[string][]float32{model: embedding}); err != nil {
+			return err
+		}
+
+		embeddings = append(embeddings, embedding)
+	}
+
+	return nil
+}
+
+// dot returns the dot product of a and b, which is their cosine similarity
+// since embeddings are normalized
+func dot(a, b []float32) float32 {
+	var sum float32
+	for i := range min(len(a), len(b)) {
+		sum += a[i] * b[i]
+	}
+
+	return sum
+}
+
+// withMemories adds memories to the system message of msgs, adding a system
+// message if there isn't one
+func withMemories(msgs []api.Message, memories []string) []api.Message {
+	if len(memories) == 0 {
+		return msgs
+	}
+
+	var sb strings.Builder
+	sb.WriteString(memoriesPrompt)
+	for _, m := range memories {
+		sb.WriteString("\n- " + m)
+	}
+
+	if len(msgs) > 0 && msgs[0].Role == "system" {
+		return append([]api.Message{{Role: "system", Content: msgs[0].Content + "\n\n" + sb.String()}}, msgs[1:]...)
+	}
+
+	return append([]api.Message{{Role: "system", Content: sb.String()}}, msgs...)
+}
+
+// memoryQuery returns the text memories relevant to a chat are recalled by,
+// which is its last user message
+func memoryQuery(msgs []api.Message) string {
+	for _, msg := range slices.Backward(msgs) {
+		if msg.Role == "user" && msg.Content != "" {
+			return msg.Content
+		}
+	}
+
+	return ""
+}
+
+// extractFacts returns the facts worth remembering in msgs with the model
+// running in r
+func extractFacts(ctx context.Context, r llm.LlamaServer, m *Model, opts *api.Options, msgs []api.Message) ([]string, error) {
+	var transcript strings.Builder
+	for _, msg := range msgs {
+		if (msg.Role == "user" || msg.Role == "assistant") && msg.Content != "" {
+			fmt.Fprintf(&transcript, "%s: %s\n\n", msg.Role, msg.Content)
+		}
+	}
+
+	if transcript.Len() == 0 {
+		return nil, nil
+	}
+
+	var b bytes.Buffer
+	if err := m.Template.Execute(&b, template.Values{Messages: []api.Message{
+		{Role: "system", Content: memoryPrompt},
+		{Role: "user", Content: transcript.String()},
+	}}); err != nil {
+		return nil, err
+	}
+
+	factOpts := *opts
+	factOpts.NumPredict = opts.NumCtx / 4
+
+	var sb strings.Builder
+	if err := r.Completion(ctx, llm.CompletionRequest{Prompt: b.String(), Options: &factOpts}, func(cr llm.CompletionResponse) {
+		sb.WriteString(cr.Content)
+	}); err != nil {
+		return nil, err
+	}
+
+	var facts []string
+	for _, line := range strings.Split(sb.String(), "\n") {
+		line = strings.TrimSpace(line)
+		fact, ok := strings.CutPrefix(line, "- ")
+		if !ok {
+			fact, ok = strings.CutPrefix(line, "* ")
+		}
+
+		if fact = strings.TrimSpace(fact); ok && fact != "" {
+			facts = append(facts, fact)
+		}
+	}
+
+	return facts, nil
+}
+
+// memoryEmbedModel returns the model that embeds the memories of chats with
+// chatModel, which is OLLAMA_MEMORY_EMBED_MODEL if it's set
+func memoryEmbedModel(chatModel string) string {
+	return cmp.Or(envconfig.MemoryEmbedModel(), chatModel)
+}
+
+// memoryEmbedder schedules model to embed memories. Its runner is held until
+// ctx is done.
+func (s *Server) memoryEmbedder(ctx context.Context, model string) (embedder, error) {
+	r, _, _, err := s.scheduleRunner(ctx, model, []Capability{}, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return normalizedEmbedder(r), nil
+}
+
+// recallMemories returns the memories of key most relevant to query, for a
+// chat with chatModel. Memories that haven't been embedded yet are embedded
+// in the background, and recalled once they are.
+func (s *Server) recallMemories(ctx context.Context, key, chatModel, query string) []string {
+	model := memoryEmbedModel(chatModel)
+	memories, _, missing := s.memories.embedded(key, model)
+	if missing {
+		go s.embedMemories(key, model)
+	}
+
+	if len(memories) == 0 || query == "" {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	embed, err := s.memoryEmbedder(ctx, model)
+	if err != nil {
+		slog.Warn("failed to recall memories", "model", model, "error", err)
+		return nil
+	}
+
+	recalled, err := s.memories.recall(ctx, key, model, query, embed)
+	if err != nil {
+		slog.Warn("failed to recall memories", "model", model, "error", err)
+	}
+
+	return recalled
+}
+
+// embedMemories embeds the memories of key that model hasn't embedded
+func (s *Server) embedMemories(key, model string) {
+	if !s.memories.startEmbedding(key, model) {
+		return
+	}
+	defer s.memories.doneEmbedding(key, model)
+
+	ctx, cancel := context.WithTimeout(context.Background(), memoryExtractTimeout)
+	defer cancel()
+
+	embed, err := s.memoryEmbedder(ctx, model)
+	if err != nil {
+		slog.Warn("failed to embed memories", "model", model, "error", err)
+		return
+	}
+
+	if _, _, err := s.memories.embeddings(ctx, key, model, embed); err != nil {
+		slog.Warn("failed to embed memories", "model", model, "error", err)
+	}
+}
+
+// rememberChat extracts the facts worth remembering in the messages of a chat
+// turn and adds them to the memories of key. It runs after the turn is done,
+// scheduling the model again so the turn's runner is released, and releases
+// it before the facts are embedded.
+func (s *Server) rememberChat(key, name string, options map[string]any, keepAlive *api.Duration, msgs []api.Message) {
+	ctx, cancel := context.WithTimeout(context.Background(), memoryExtractTimeout)
+	defer cancel()
+
+	extractCtx, release := context.WithCancel(ctx)
+	r, m, opts, err := s.scheduleRunner(extractCtx, name, []Capability{}, options, keepAlive)
+	if err != nil {
+		release()
+		slog.Warn("failed to extract memories", "model", name, "error", err)
+		return
+	}
+
+	facts, err := extractFacts(extractCtx, r, m, opts, msgs)
+	release()
+	if err != nil {
+		slog.Warn("failed to extract memories", "model", name, "error", err)
+		return
+	} else if len(facts) == 0 {
+		return
+	}
+
+	model := memoryEmbedModel(name)
+	embed, err := s.memoryEmbedder(ctx, model)
+	if err != nil {
+		slog.Warn("failed to remember facts", "model", model, "error", err)
+		return
+	}
+
+	if err := s.memories.remember(ctx, key, model, facts, embed); err != nil {
+		slog.Warn("failed to remember facts", "model", model, "error", err)
+	}
+}
+
+func (s *Server) memoryRequest(c *gin.Context) (string, bool) {
+	if s.memories == nil {
+		c.AbortWithStatusJSON(http.StatusNotImplemented, gin.H{"error": errMemoryUnavailable.Error()})
+		return "", false
+	}
+
+	key := c.Param("key")
+	if err := checkMemoryKey(key); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return "", false
+	}
+
+	return key, s.claimMemories(c, key)
+}
+
+// claimMemories checks the request may use the memories of key, aborting it
+// if it can't
+func (s *Server) claimMemories(c *gin.Context, key string) bool {
+	if s.memories == nil {
+		c.AbortWithStatusJSON(http.StatusNotImplemented, gin.H{"error": errMemoryUnavailable.Error()})
+		return false
+	}
+
+	ok, err := s.memories.claim(key, ownerOf(c))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	} else if !ok {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": errMemoryOwned.Error()})
+		return false
+	}
+
+	return true
+}
+
+func bindMemoryRequest(c *gin.Context) (api.MemoryRequest, bool) {
+	var req api.MemoryRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return req, false
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return req, false
+	}
+
+	if err := checkMemoryContent(req.Content); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return req, false
+	}
+
+	return req, true
+}
+
+func (s *Server) ListMemoriesHandler(c *gin.Context) {
+	key, ok := s.memoryRequest(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, api.ListMemoriesResponse{Memories: s.memories.list(key)})
+}
+
+func (s *Server) AddMemoryHandler(c *gin.Context) {
+	key, ok := s.memoryRequest(c)
+	if !ok {
+		return
+	}
+
+	req, ok := bindMemoryRequest(c)
+	if !ok {
+		return
+	}
+
+	m, err := s.memories.add(key, req.Content, nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if model := envconfig.MemoryEmbedModel(); model != "" {
+		go s.embedMemories(key, model)
+	}
+
+	c.JSON(http.StatusCreated, m)
+}
+
+func (s *Server) UpdateMemoryHandler(c *gin.Context) {
+	key, ok := s.memoryRequest(c)
+	if !ok {
+		return
+	}
+
+	req, ok := bindMemoryRequest(c)
+	if !ok {
+		return
+	}
+
+	m, err := s.memories.update(key, c.Param("id"), req.Content)
+	if errors.Is(err, errMemoryNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if model := envconfig.MemoryEmbedModel(); model != "" {
+		go s.embedMemories(key, model)
+	}
+
+	c.JSON(http.StatusOK, m)
+}
+
+func (s *Server) DeleteMemoryHandler(c *gin.Context) {
+	key, ok := s.memoryRequest(c)
+	if !ok {
+		return
+	}
+
+	err := s.memories.delete(key, c.Param("id"))
+	if errors.Is(err, errMemoryNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
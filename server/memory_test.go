@@ -0,0 +1,233 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+)
+
+// wordEmbedding embeds text as the normalized counts of its words, hashed
+// into a few dimensions, so texts with the same words are similar
+func wordEmbedding(_ context.Context, input string) ([]float32, error) {
+	embedding := make([]float32, 16)
+	for _, word := range strings.Fields(strings.ToLower(input)) {
+		h := fnv.New32a()
+		h.Write([]byte(strings.Trim(word, ".,!?")))
+		embedding[h.Sum32()%16]++
+	}
+
+	return normalize(embedding), nil
+}
+
+func TestMemoryStore(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	ctx := context.Background()
+
+	s := newMemoryStore()
+	if err := s.remember(ctx, "jane", "test", []string{"Jane likes tea.", "Jane lives in Oslo.", "jane likes tea"}, wordEmbedding); err != nil {
+		t.Fatal(err)
+	}
+
+	memories := s.list("jane")
+	if len(memories) != 2 {
+		t.Fatalf("expected the repeated fact to be left out, got %v", memories)
+	}
+
+	recalled, err := s.recall(ctx, "jane", "test", "Does Jane live in Oslo?", wordEmbedding)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff([]string{"Jane lives in Oslo.", "Jane likes tea."}, recalled); diff != "" {
+		t.Errorf("recalled mismatch (-want +got):\n%s", diff)
+	}
+
+	m, err := s.update("jane", memories[0].ID, "Jane likes coffee.")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Content != "Jane likes coffee." || m.UpdatedAt.Before(m.CreatedAt) {
+		t.Errorf("unexpected memory %+v", m)
+	}
+
+	saved, err := readMemories()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(s.list("jane"), saved.list("jane")); diff != "" {
+		t.Errorf("saved mismatch (-want +got):\n%s", diff)
+	}
+
+	if err := s.delete("jane", memories[1].ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.delete("jane", memories[1].ID); !errors.Is(err, errMemoryNotFound) {
+		t.Errorf("expected errMemoryNotFound, got %v", err)
+	}
+
+	if err := s.delete("jane", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if memories := s.list("jane"); len(memories) != 0 {
+		t.Errorf("expected no memories, got %v", memories)
+	}
+}
+
+func TestChatMemory(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	prompts := make(chan string, 4)
+	mock := mockRunner{
+		CompletionFn: func(_ context.Context, r llm.CompletionRequest, fn func(llm.CompletionResponse)) error {
+			if strings.Contains(r.Prompt, memoryPrompt) {
+				fn(llm.CompletionResponse{Content: "- The user's name is Jane.\n- The user lives in Oslo.", Done: true})
+				return nil
+			}
+
+			prompts <- r.Prompt
+			fn(llm.CompletionResponse{Content: "Hi Jane!", Done: true, DoneReason: "stop"})
+			return nil
+		},
+		EmbeddingFn: wordEmbedding,
+	}
+
+	s := Server{sched: newMockScheduler(t, &mock)}
+	createMockModel(t, &s, "test", `{{- range .Messages }}{{ .Role }}: {{ .Content }} {{ end }}`)
+	s.memories = newMemoryStore()
+
+	chat := func(content string) string {
+		t.Helper()
+		w := createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model:    "test",
+			Messages: []api.Message{{Role: "user", Content: content}},
+			Memory:   "jane",
+			Stream:   &stream,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		return <-prompts
+	}
+
+	chat("Hello! I'm Jane and I live in Oslo.")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(s.memories.list("jane")) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected facts to be remembered, got %v", s.memories.list("jane"))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	prompt := chat("What's my name?")
+	if !strings.Contains(prompt, memoriesPrompt+"\n- The user's name is Jane.") {
+		t.Errorf("expected the memories in the prompt, got %q", prompt)
+	}
+
+	t.Run("handlers", func(t *testing.T) {
+		srv := httptest.NewServer(s.GenerateRoutes())
+		defer srv.Close()
+
+		u, err := url.Parse(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client := api.NewClient(u, http.DefaultClient)
+		ctx := context.Background()
+
+		list, err := client.ListMemories(ctx, "jane")
+		if err != nil {
+			t.Fatal(err)
+		} else if len(list.Memories) != 2 {
+			t.Fatalf("expected 2 memories, got %v", list.Memories)
+		}
+
+		m, err := client.UpdateMemory(ctx, "jane", list.Memories[0].ID, &api.MemoryRequest{Content: "The user's name is Janet."})
+		if err != nil {
+			t.Fatal(err)
+		} else if m.Content != "The user's name is Janet." {
+			t.Errorf("expected the memory to be edited, got %+v", m)
+		}
+
+		var se api.StatusError
+		if err := client.DeleteMemory(ctx, "jane", "missing"); !errors.As(err, &se) || se.StatusCode != http.StatusNotFound {
+			t.Errorf("expected status 404, got %v", err)
+		}
+
+		if _, err := client.AddMemory(ctx, "jane", &api.MemoryRequest{Content: " "}); !errors.As(err, &se) || se.StatusCode != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %v", err)
+		}
+
+		if _, err := client.AddMemory(ctx, "bob", &api.MemoryRequest{Content: "Bob has a cat."}); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := client.DeleteMemories(ctx, "jane"); err != nil {
+			t.Fatal(err)
+		}
+
+		if list, err := client.ListMemories(ctx, "jane"); err != nil || len(list.Memories) != 0 {
+			t.Errorf("expected no memories, got %v %v", list, err)
+		}
+	})
+}
+
+func TestMemoryEmbedModel(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("OLLAMA_MEMORY_EMBED_MODEL", "embed")
+
+	mock := mockRunner{EmbeddingFn: wordEmbedding}
+	s := Server{sched: newMockScheduler(t, &mock)}
+	createMockModel(t, &s, "embed", `{{ .Prompt }}`)
+	s.memories = newMemoryStore()
+
+	srv := httptest.NewServer(s.GenerateRoutes())
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := api.NewClient(u, http.DefaultClient)
+	for _, content := range []string{"Bob has a cat.", "Bob lives in Rome."} {
+		if _, err := client.AddMemory(context.Background(), "bob", &api.MemoryRequest{Content: content}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// added memories are embedded by the embedding model in the background
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if memories, _, missing := s.memories.embedded("bob", "embed"); len(memories) == 2 && !missing {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("expected the memories to be embedded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	recalled := s.recallMemories(context.Background(), "bob", "test", "Where does Bob live?")
+	if diff := cmp.Diff([]string{"Bob lives in Rome.", "Bob has a cat."}, recalled); diff != "" {
+		t.Errorf("recalled mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+
+	"github.com/ollama/ollama/api"
+)
+
+// Request metadata is logged with every request it's attached to, so it's
+// kept small
+const (
+	maxMetadataKeys        = 16
+	maxMetadataKeyLength   = 64
+	maxMetadataValueLength = 256
+)
+
+// checkMetadata returns an error if md is larger than the metadata limits
+func checkMetadata(md map[string]string) error {
+	if len(md) > maxMetadataKeys {
+		return fmt.Errorf("metadata has %d keys, the limit is %d", len(md), maxMetadataKeys)
+	}
+
+	for k, v := range md {
+		switch {
+		case k == "":
+			return fmt.Errorf("metadata keys can't be empty")
+		case len(k) > maxMetadataKeyLength:
+			return fmt.Errorf("metadata key %.16q... is longer than %d bytes", k, maxMetadataKeyLength)
+		case len(v) > maxMetadataValueLength:
+			return fmt.Errorf("metadata value for %q is longer than %d bytes", k, maxMetadataValueLength)
+		}
+	}
+
+	return nil
+}
+
+// metadataAttr groups md for logging, in key order
+func metadataAttr(md map[string]string) slog.Attr {
+	var args []any
+	for _, k := range slices.Sorted(maps.Keys(md)) {
+		args = append(args, slog.String(k, md[k]))
+	}
+
+	return slog.Group("metadata", args...)
+}
+
+// logRequest logs a completed request that has metadata, so requests can be
+// correlated with the systems that made them
+func logRequest(endpoint, model string, md map[string]string, m api.Metrics) {
+	if len(md) == 0 {
+		return
+	}
+
+	slog.Info("request completed",
+		"endpoint", endpoint,
+		"model", model,
+		metadataAttr(md),
+		"prompt_eval_count", m.PromptEvalCount,
+		"eval_count", m.EvalCount,
+		"total_duration", m.TotalDuration,
+	)
+}
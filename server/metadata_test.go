@@ -0,0 +1,34 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckMetadata(t *testing.T) {
+	tooMany := map[string]string{}
+	for i := range maxMetadataKeys + 1 {
+		tooMany[strings.Repeat("k", i+1)] = "v"
+	}
+
+	cases := []struct {
+		name string
+		md   map[string]string
+		ok   bool
+	}{
+		{"empty", nil, true},
+		{"labels", map[string]string{"trace_id": "4bf92f3577b34da6", "user": "alice"}, true},
+		{"empty key", map[string]string{"": "v"}, false},
+		{"long key", map[string]string{strings.Repeat("k", maxMetadataKeyLength+1): "v"}, false},
+		{"long value", map[string]string{"k": strings.Repeat("v", maxMetadataValueLength+1)}, false},
+		{"too many keys", tooMany, false},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkMetadata(tt.md); (err == nil) != tt.ok {
+				t.Errorf("expected ok %t, got error %v", tt.ok, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,347 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
+)
+
+// metricInfo describes a metric exported in the Prometheus text format
+type metricInfo struct {
+	typ  string
+	help string
+	// buckets are the upper bounds of a histogram's buckets
+	buckets []float64
+}
+
+var durationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+var metricInfos = map[string]metricInfo{
+	"ollama_requests_total":                {typ: "counter", help: "Requests handled, by method, route and status code."},
+	"ollama_request_duration_seconds":      {typ: "histogram", help: "Time to handle requests, including streaming responses.", buckets: durationBuckets},
+	"ollama_prompt_tokens_total":           {typ: "counter", help: "Prompt tokens evaluated."},
+	"ollama_generated_tokens_total":        {typ: "counter", help: "Tokens generated."},
+	"ollama_tokens_per_second":             {typ: "histogram", help: "Generation speed of completions.", buckets: []float64{1, 5, 10, 25, 50, 100, 200, 500, 1000}},
+	"ollama_prompt_truncations_total":      {typ: "counter", help: "Prompts truncated to fit the context window, by whether messages or tokens were discarded."},
+	"ollama_context_shifts_total":          {typ: "counter", help: "Times the oldest part of the context was discarded to keep generating."},
+	"ollama_queue_depth":                   {typ: "gauge", help: "Requests waiting to be scheduled."},
+	"ollama_active_requests":               {typ: "gauge", help: "Requests being handled by each loaded model."},
+	"ollama_loaded_models":                 {typ: "gauge", help: "Models loaded."},
+	"ollama_model_vram_bytes":              {typ: "gauge", help: "Estimated GPU memory used by each loaded model."},
+	"ollama_model_size_bytes":              {typ: "gauge", help: "Estimated memory used by each loaded model."},
+	"ollama_gpu_memory_total_bytes":        {typ: "gauge", help: "Total memory of each GPU."},
+	"ollama_gpu_memory_free_bytes":         {typ: "gauge", help: "Free memory of each GPU."},
+	"ollama_kv_cache_used_tokens":          {typ: "gauge", help: "Tokens held in the KV cache of each loaded model."},
+	"ollama_kv_cache_size_tokens":          {typ: "gauge", help: "Tokens the KV cache of each loaded model can hold."},
+	"ollama_shadow_requests_total":         {typ: "counter", help: "Copies of requests sent to shadow models, by whether their response matched the primary model's, or whether they failed or were skipped."},
+	"ollama_shadow_generated_tokens_total": {typ: "counter", help: "Tokens generated by shadow models."},
+}
+
+// metricLabels formats label names and values for a series, in the order
+// given
+func metricLabels(kv ...string) string {
+	var sb strings.Builder
+	for i := 0; i+1 < len(kv); i += 2 {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		fmt.Fprintf(&sb, `%s="%s"`, kv[i], labelEscaper.Replace(kv[i+1]))
+	}
+	return sb.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func (h *histogram) observe(buckets []float64, v float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(buckets))
+	}
+
+	for i, b := range buckets {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+
+	h.sum += v
+	h.count++
+}
+
+// metrics are the counters and histograms of the server's activity. Gauges
+// are read from the scheduler when metrics are scraped.
+type metrics struct {
+	mu         sync.Mutex
+	values     map[string]map[string]float64
+	histograms map[string]map[string]*histogram
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		values:     make(map[string]map[string]float64),
+		histograms: make(map[string]map[string]*histogram),
+	}
+}
+
+func (m *metrics) add(name, labels string, v float64) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.values[name] == nil {
+		m.values[name] = make(map[string]float64)
+	}
+	m.values[name][labels] += v
+}
+
+func (m *metrics) observe(name, labels string, v float64) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.histograms[name] == nil {
+		m.histograms[name] = make(map[string]*histogram)
+	}
+
+	h, ok := m.histograms[name][labels]
+	if !ok {
+		h = &histogram{}
+		m.histograms[name][labels] = h
+	}
+	h.observe(metricInfos[name].buckets, v)
+}
+
+// completionLabels are the labels of a completion's series: its model, the
+// request metadata keys configured with OLLAMA_METRICS_LABELS, and kv.
+// Metadata keys the request doesn't have are left empty so every series has
+// the same labels.
+func completionLabels(model string, md map[string]string, kv ...string) string {
+	labels := []string{"model", model}
+	for _, k := range envconfig.MetricsLabels() {
+		labels = append(labels, labelName(k), md[k])
+	}
+
+	return metricLabels(append(labels, kv...)...)
+}
+
+// labelName replaces the characters Prometheus doesn't allow in label names
+// with underscores
+func labelName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// recordCompletion records the tokens and speed of a completed generation,
+// and whether its prompt was truncated. truncatedMessages is the number of
+// chat messages left out of the prompt.
+func (m *metrics) recordCompletion(model string, md map[string]string, metrics api.Metrics, diagnostics *api.Diagnostics, truncatedMessages int) {
+	labels := completionLabels(model, md)
+	m.add("ollama_prompt_tokens_total", labels, float64(metrics.PromptEvalCount))
+	m.add("ollama_generated_tokens_total", labels, float64(metrics.EvalCount))
+	if metrics.EvalCount > 0 && metrics.EvalDuration > 0 {
+		m.observe("ollama_tokens_per_second", labels, float64(metrics.EvalCount)/metrics.EvalDuration.Seconds())
+	}
+
+	if truncatedMessages > 0 {
+		m.add("ollama_prompt_truncations_total", completionLabels(model, md, "kind", "messages"), 1)
+	}
+
+	if diagnostics != nil {
+		if diagnostics.PromptTruncated > 0 {
+			m.add("ollama_prompt_truncations_total", completionLabels(model, md, "kind", "tokens"), 1)
+		}
+
+		if diagnostics.ContextShifts > 0 {
+			m.add("ollama_context_shifts_total", labels, float64(diagnostics.ContextShifts))
+		}
+	}
+}
+
+// metricsMiddleware counts requests and how long they take by the route
+// they matched. Requests that don't match a route are counted as "other" so
+// arbitrary paths don't each become a series.
+func metricsMiddleware(m *metrics) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "other"
+		}
+
+		m.add("ollama_requests_total", metricLabels("method", c.Request.Method, "route", route, "code", strconv.Itoa(c.Writer.Status())), 1)
+		m.observe("ollama_request_duration_seconds", metricLabels("route", route), time.Since(start).Seconds())
+	}
+}
+
+// gauges reads the state of the scheduler and the runners of loaded models.
+// Runners that are loading or being changed hold their lock for as long as
+// that takes, so their requests and KV cache are left out rather than
+// waiting for them.
+func (s *Server) gauges(ctx context.Context) map[string]map[string]float64 {
+	gauges := make(map[string]map[string]float64)
+	set := func(name, labels string, v float64) {
+		if gauges[name] == nil {
+			gauges[name] = make(map[string]float64)
+		}
+		gauges[name][labels] = v
+	}
+
+	if s.sched == nil {
+		return gauges
+	}
+
+	set("ollama_queue_depth", "", float64(len(s.sched.pendingReqCh)))
+
+	type loadedModel struct {
+		name string
+		*runnerRef
+	}
+
+	s.sched.loadedMu.Lock()
+	loaded := make([]loadedModel, 0, len(s.sched.loaded))
+	for path, runner := range s.sched.loaded {
+		name := path
+		if runner.model != nil {
+			name = runner.model.ShortName
+		}
+		loaded = append(loaded, loadedModel{name, runner})
+	}
+	s.sched.loadedMu.Unlock()
+
+	set("ollama_loaded_models", "", float64(len(loaded)))
+	for _, m := range loaded {
+		labels := metricLabels("model", m.name)
+
+		set("ollama_model_vram_bytes", labels, float64(m.estimatedVRAM))
+		set("ollama_model_size_bytes", labels, float64(m.estimatedTotal))
+
+		if !m.refMu.TryLock() {
+			continue
+		}
+		set("ollama_active_requests", labels, float64(m.refCount))
+		loading := m.loading
+		m.refMu.Unlock()
+
+		if loading || m.llama == nil {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, time.Second)
+		used, size, err := m.llama.KvCacheUsage(ctx)
+		cancel()
+		if err == nil && size > 0 {
+			set("ollama_kv_cache_used_tokens", labels, float64(used))
+			set("ollama_kv_cache_size_tokens", labels, float64(size))
+		}
+	}
+
+	if s.sched.getGpuFn != nil {
+		for _, gpu := range s.sched.getGpuFn() {
+			if gpu.Library == "cpu" {
+				continue
+			}
+
+			labels := metricLabels("gpu", gpu.ID, "library", gpu.Library)
+			set("ollama_gpu_memory_total_bytes", labels, float64(gpu.TotalMemory))
+			set("ollama_gpu_memory_free_bytes", labels, float64(gpu.FreeMemory))
+		}
+	}
+
+	return gauges
+}
+
+// writeMetrics writes values and histograms in the Prometheus text format,
+// sorted by name and labels
+func writeMetrics(w io.Writer, values map[string]map[string]float64, histograms map[string]map[string]*histogram) {
+	series := func(name, labels string) string {
+		if labels == "" {
+			return name
+		}
+		return name + "{" + labels + "}"
+	}
+
+	names := slices.Sorted(maps.Keys(metricInfos))
+	for _, name := range names {
+		info := metricInfos[name]
+		if len(values[name]) == 0 && len(histograms[name]) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(w, "# HELP %s %s\n", name, info.help)
+		fmt.Fprintf(w, "# TYPE %s %s\n", name, info.typ)
+
+		for _, labels := range slices.Sorted(maps.Keys(values[name])) {
+			fmt.Fprintf(w, "%s %s\n", series(name, labels), strconv.FormatFloat(values[name][labels], 'g', -1, 64))
+		}
+
+		for _, labels := range slices.Sorted(maps.Keys(histograms[name])) {
+			h := histograms[name][labels]
+			sep := ""
+			if labels != "" {
+				sep = ","
+			}
+
+			for i, b := range info.buckets {
+				fmt.Fprintf(w, "%s %d\n", series(name+"_bucket", labels+sep+metricLabels("le", strconv.FormatFloat(b, 'g', -1, 64))), h.counts[i])
+			}
+			fmt.Fprintf(w, "%s %d\n", series(name+"_bucket", labels+sep+`le="+Inf"`), h.count)
+			fmt.Fprintf(w, "%s %s\n", series(name+"_sum", labels), strconv.FormatFloat(h.sum, 'g', -1, 64))
+			fmt.Fprintf(w, "%s %d\n", series(name+"_count", labels), h.count)
+		}
+	}
+}
+
+// MetricsHandler exports the server's metrics in the Prometheus text format
+func (s *Server) MetricsHandler(c *gin.Context) {
+	values := s.gauges(c.Request.Context())
+	var histograms map[string]map[string]*histogram
+
+	if m := s.metrics; m != nil {
+		m.mu.Lock()
+		for name, series := range m.values {
+			values[name] = maps.Clone(series)
+		}
+
+		histograms = make(map[string]map[string]*histogram, len(m.histograms))
+		for name, series := range m.histograms {
+			histograms[name] = make(map[string]*histogram, len(series))
+			for labels, h := range series {
+				histograms[name][labels] = &histogram{counts: slices.Clone(h.counts), sum: h.sum, count: h.count}
+			}
+		}
+		m.mu.Unlock()
+	}
+
+	c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.Status(http.StatusOK)
+	writeMetrics(c.Writer, values, histograms)
+}
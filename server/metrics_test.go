@@ -0,0 +1,158 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	m := newMetrics()
+	m.add("ollama_requests_total", metricLabels("method", "GET", "route", `/a"b\c`, "code", "200"), 1)
+	m.add("ollama_requests_total", metricLabels("method", "GET", "route", `/a"b\c`, "code", "200"), 2)
+	m.observe("ollama_tokens_per_second", metricLabels("model", "test"), 20)
+	m.observe("ollama_tokens_per_second", metricLabels("model", "test"), 2000)
+
+	var sb strings.Builder
+	writeMetrics(&sb, map[string]map[string]float64{
+		"ollama_queue_depth":    {"": 2},
+		"ollama_requests_total": m.values["ollama_requests_total"],
+	}, m.histograms)
+
+	for _, want := range []string{
+		"# TYPE ollama_queue_depth gauge\nollama_queue_depth 2\n",
+		"# TYPE ollama_requests_total counter\n" + `ollama_requests_total{method="GET",route="/a\"b\\c",code="200"} 3` + "\n",
+		`ollama_tokens_per_second_bucket{model="test",le="10"} 0` + "\n",
+		`ollama_tokens_per_second_bucket{model="test",le="25"} 1` + "\n",
+		`ollama_tokens_per_second_bucket{model="test",le="1000"} 1` + "\n",
+		`ollama_tokens_per_second_bucket{model="test",le="+Inf"} 2` + "\n",
+		`ollama_tokens_per_second_sum{model="test"} 2020` + "\n",
+		`ollama_tokens_per_second_count{model="test"} 2` + "\n",
+	} {
+		if !strings.Contains(sb.String(), want) {
+			t.Errorf("expected metrics to contain %q, got:\n%s", want, sb.String())
+		}
+	}
+
+	if strings.Contains(sb.String(), "ollama_loaded_models") {
+		t.Error("expected metrics without values to be left out")
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mock := mockRunner{
+		CompletionFn: func(_ context.Context, r llm.CompletionRequest, fn func(llm.CompletionResponse)) error {
+			fn(llm.CompletionResponse{
+				Content:         "hi",
+				Done:            true,
+				DoneReason:      "stop",
+				PromptEvalCount: 3,
+				EvalCount:       10,
+				EvalDuration:    time.Second,
+				Diagnostics:     &api.Diagnostics{PromptTruncated: 5, ContextShifts: 1},
+			})
+			return nil
+		},
+		KvCacheUsed: 13,
+		KvCacheSize: 2048,
+	}
+
+	s := Server{sched: newMockScheduler(t, &mock)}
+	createMockModel(t, &s, "test", `{{ .Prompt }}`)
+
+	srv := httptest.NewServer(s.GenerateRoutes())
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/api/chat", "application/json", strings.NewReader(`{"model": "test", "messages": [{"role": "user", "content": "hello"}], "stream": false}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.StatusCode)
+	}
+
+	// the mock scheduler doesn't keep the runners it loads
+	s.sched.loadedMu.Lock()
+	s.sched.loaded["test"] = &runnerRef{llama: &mock, model: &Model{ShortName: "test"}, refCount: 1, estimatedVRAM: 1024}
+
+	// runners hold their lock while they load, which metrics don't wait for
+	loading := &runnerRef{llama: &mock, model: &Model{ShortName: "loading"}, refCount: 1, estimatedVRAM: 2048}
+	loading.refMu.Lock()
+	defer loading.refMu.Unlock()
+	s.sched.loaded["loading"] = loading
+	s.sched.loadedMu.Unlock()
+
+	resp, err = http.Get(srv.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("unexpected content type %q", ct)
+	}
+
+	bts, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		`ollama_requests_total{method="POST",route="/api/chat",code="200"} 1`,
+		`ollama_request_duration_seconds_count{route="/api/chat"} 1`,
+		`ollama_prompt_tokens_total{model="test"} 3`,
+		`ollama_generated_tokens_total{model="test"} 10`,
+		`ollama_tokens_per_second_sum{model="test"} 10`,
+		`ollama_prompt_truncations_total{model="test",kind="tokens"} 1`,
+		`ollama_context_shifts_total{model="test"} 1`,
+		"ollama_queue_depth 0",
+		"ollama_loaded_models 2",
+		`ollama_model_vram_bytes{model="loading"} 2048`,
+		`ollama_active_requests{model="test"} 1`,
+		`ollama_model_vram_bytes{model="test"} 1024`,
+		`ollama_kv_cache_used_tokens{model="test"} 13`,
+		`ollama_kv_cache_size_tokens{model="test"} 2048`,
+	} {
+		if !strings.Contains(string(bts), want) {
+			t.Errorf("expected metrics to contain %q, got:\n%s", want, bts)
+		}
+	}
+
+	if strings.Contains(string(bts), `ollama_active_requests{model="loading"}`) {
+		t.Error("expected requests of a loading model to be left out")
+	}
+}
+
+func TestCompletionLabels(t *testing.T) {
+	t.Setenv("OLLAMA_METRICS_LABELS", "team, app.name")
+
+	m := newMetrics()
+	m.recordCompletion("test", map[string]string{"team": "search", "user": "u1"}, api.Metrics{PromptEvalCount: 3}, nil, 1)
+
+	for _, want := range []string{
+		`model="test",team="search",app_name=""`,
+		`model="test",team="search",app_name="",kind="messages"`,
+	} {
+		found := false
+		for name := range m.values {
+			if _, ok := m.values[name][want]; ok {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("expected a series labeled %s, got %v", want, m.values)
+		}
+	}
+}
@@ -79,36 +79,65 @@ func parseFromModel(ctx context.Context, name model.Name, fn func(api.ProgressRe
 }
 
 func detectChatTemplate(layers []*layerGGML) ([]*layerGGML, error) {
+	var kv llm.KV
+	for _, layer := range layers {
+		if layer.GGML != nil && !slices.Contains([]string{"adapter", "projector"}, layer.GGML.KV().Kind()) {
+			kv = layer.GGML.KV()
+			break
+		}
+	}
+
+	// without a recognized chat template, fall back to the template models
+	// with the same architecture and tokenizer are usually trained with
+	t, err := template.Detect(kv.Architecture(), kv.TokenizerPre(), kv.VocabSize())
+	detected := false
 	for _, layer := range layers {
 		if s := layer.GGML.KV().ChatTemplate(); s != "" {
-			if t, err := template.Named(s); err != nil {
+			if named, err := template.Named(s); err != nil {
 				slog.Debug("template detection", "error", err)
 			} else {
-				layer, err := NewLayer(t.Reader(), "application/vnd.ollama.image.template")
-				if err != nil {
-					return nil, err
-				}
-
-				layer.status = fmt.Sprintf("using autodetected template %s", t.Name)
-				layers = append(layers, &layerGGML{layer, nil})
-
-				if t.Parameters != nil {
-					var b bytes.Buffer
-					if err := json.NewEncoder(&b).Encode(t.Parameters); err != nil {
-						return nil, err
-					}
-
-					layer, err := NewLayer(&b, "application/vnd.ollama.image.params")
-					if err != nil {
-						return nil, err
-					}
-
-					layers = append(layers, &layerGGML{layer, nil})
-				}
+				t, detected = named, true
+				break
 			}
 		}
 	}
 
+	var status string
+	switch {
+	case detected:
+		status = fmt.Sprintf("using autodetected template %s", t.Name)
+	case err != nil:
+		if kv != nil {
+			slog.Warn("no chat template detected, prompts will be passed to the model as is", "architecture", kv.Architecture())
+		}
+		return layers, nil
+	default:
+		slog.Warn("no chat template detected, using the usual template for the architecture", "architecture", kv.Architecture(), "template", t.Name)
+		status = fmt.Sprintf("warning: no chat template found, using %s template for %s models", t.Name, kv.Architecture())
+	}
+
+	layer, err := NewLayer(t.Reader(), "application/vnd.ollama.image.template")
+	if err != nil {
+		return nil, err
+	}
+
+	layer.status = status
+	layers = append(layers, &layerGGML{layer, nil})
+
+	if t.Parameters != nil {
+		var b bytes.Buffer
+		if err := json.NewEncoder(&b).Encode(t.Parameters); err != nil {
+			return nil, err
+		}
+
+		layer, err := NewLayer(&b, "application/vnd.ollama.image.params")
+		if err != nil {
+			return nil, err
+		}
+
+		layers = append(layers, &layerGGML{layer, nil})
+	}
+
 	return layers, nil
 }
 
@@ -153,9 +182,9 @@ func parseObjects(s string) []map[string]any {
 	return objs
 }
 
-// parseToolCalls attempts to parse a JSON string into a slice of ToolCalls.
-// mxyng: this only really works if the input contains tool calls in some JSON format
-func (m *Model) parseToolCalls(s string) ([]api.ToolCall, bool) {
+// toolCallKeys returns the keys the model's template uses for the name and
+// arguments of a tool call
+func (m *Model) toolCallKeys() (name, arguments string, ok bool) {
 	// create a subtree from the node that ranges over .ToolCalls
 	tmpl := m.Template.Subtree(func(n parse.Node) bool {
 		if t, ok := n.(*parse.RangeNode); ok {
@@ -166,7 +195,7 @@ func (m *Model) parseToolCalls(s string) ([]api.ToolCall, bool) {
 	})
 
 	if tmpl == nil {
-		return nil, false
+		return "", "", false
 	}
 
 	var b bytes.Buffer
@@ -182,16 +211,15 @@ func (m *Model) parseToolCalls(s string) ([]api.ToolCall, bool) {
 			},
 		},
 	}); err != nil {
-		return nil, false
+		return "", "", false
 	}
 
 	templateObjects := parseObjects(b.String())
 	if len(templateObjects) == 0 {
-		return nil, false
+		return "", "", false
 	}
 
 	// find the keys that correspond to the name and arguments fields
-	var name, arguments string
 	for k, v := range templateObjects[0] {
 		switch v.(type) {
 		case string:
@@ -201,7 +229,14 @@ func (m *Model) parseToolCalls(s string) ([]api.ToolCall, bool) {
 		}
 	}
 
-	if name == "" || arguments == "" {
+	return name, arguments, name != "" && arguments != ""
+}
+
+// parseToolCalls attempts to parse a JSON string into a slice of ToolCalls.
+// mxyng: this only really works if the input contains tool calls in some JSON format
+func (m *Model) parseToolCalls(s string) ([]api.ToolCall, bool) {
+	name, arguments, ok := m.toolCallKeys()
+	if !ok {
 		return nil, false
 	}
 
@@ -10,7 +10,6 @@ import (
 	"regexp"
 	"strings"
 
-	"github.com/ollama/ollama/envconfig"
 	"github.com/ollama/ollama/types/model"
 )
 
@@ -104,7 +103,7 @@ func (mp ModelPath) GetManifestPath() (string, error) {
 	if !name.IsValid() {
 		return "", fs.ErrNotExist
 	}
-	return filepath.Join(envconfig.Models(), "manifests", name.Filepath()), nil
+	return localStore().path(manifestKey(name)), nil
 }
 
 func (mp ModelPath) BaseURL() *url.URL {
@@ -115,7 +114,7 @@ func (mp ModelPath) BaseURL() *url.URL {
 }
 
 func GetManifestPath() (string, error) {
-	path := filepath.Join(envconfig.Models(), "manifests")
+	path := localStore().path("manifests")
 	if err := os.MkdirAll(path, 0o755); err != nil {
 		return "", err
 	}
@@ -132,8 +131,7 @@ func GetBlobsPath(digest string) (string, error) {
 		return "", ErrInvalidDigestFormat
 	}
 
-	digest = strings.ReplaceAll(digest, ":", "-")
-	path := filepath.Join(envconfig.Models(), "blobs", digest)
+	path := localStore().path(blobKey(digest))
 	dirPath := filepath.Dir(path)
 	if digest == "" {
 		dirPath = path
@@ -143,5 +141,11 @@ func GetBlobsPath(digest string) (string, error) {
 		return "", err
 	}
 
+	// the first store on disk with the blob, which is the local store if it
+	// has a copy, and otherwise where it's written to
+	if s := blobStore(digest); s != nil {
+		return s.path(blobKey(digest)), nil
+	}
+
 	return path, nil
 }
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"regexp"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+)
+
+// isMultipart reports whether the body of c is multipart/form-data
+func isMultipart(c *gin.Context) bool {
+	mediaType, _, _ := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	return mediaType == "multipart/form-data"
+}
+
+// readMultipart reads a multipart/form-data request. The "request" part has
+// the JSON request and the other parts are images, read as raw bytes so they
+// aren't base64 encoded in the request or decoded on the server.
+func readMultipart(c *gin.Context) ([]byte, map[string][]api.ImageData, error) {
+	_, params, err := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var request []byte
+	images := make(map[string][]api.ImageData)
+
+	mr := multipart.NewReader(c.Request.Body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, nil, fmt.Errorf("invalid multipart body: %w", err)
+		}
+
+		bts, err := io.ReadAll(part)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid multipart body: %w", err)
+		}
+
+		name := part.FormName()
+		switch {
+		case name == "":
+			return nil, nil, errors.New("multipart parts need a name")
+		case name == "request":
+			request = bts
+		default:
+			images[name] = append(images[name], api.ImageData(bts))
+		}
+	}
+
+	if request == nil {
+		return nil, nil, errors.New(`multipart body is missing the "request" part`)
+	}
+
+	return request, images, nil
+}
+
+var messageImages = regexp.MustCompile(`^messages\[(\d+)\]\.images$`)
+
+// attachImages adds images read from a multipart request to the request v.
+// Images for a generate request are named "images" and images for a chat
+// message are named "messages[i].images", where i is the index of the message.
+func attachImages(v any, images map[string][]api.ImageData) error {
+	for name, imgs := range images {
+		switch req := v.(type) {
+		case *api.GenerateRequest:
+			if name != "images" {
+				return fmt.Errorf("unexpected multipart part %q, expected images", name)
+			}
+
+			req.Images = append(req.Images, imgs...)
+		case *api.ChatRequest:
+			m := messageImages.FindStringSubmatch(name)
+			if m == nil {
+				return fmt.Errorf("unexpected multipart part %q, expected messages[i].images", name)
+			}
+
+			i, err := strconv.Atoi(m[1])
+			if err != nil || i >= len(req.Messages) {
+				return fmt.Errorf("multipart part %q refers to a message that doesn't exist", name)
+			}
+
+			req.Messages[i].Images = append(req.Messages[i].Images, imgs...)
+		default:
+			return fmt.Errorf("unexpected multipart part %q", name)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+)
+
+type formPart struct {
+	name, content string
+}
+
+func multipartContext(t *testing.T, parts ...formPart) *gin.Context {
+	t.Helper()
+
+	var b bytes.Buffer
+	mw := multipart.NewWriter(&b)
+	for _, p := range parts {
+		w, err := mw.CreateFormFile(p.name, "file")
+		if p.name == "request" {
+			w, err = mw.CreateFormField(p.name)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		io.WriteString(w, p.content)
+	}
+	mw.Close()
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/", &b)
+	c.Request.Header.Set("Content-Type", mw.FormDataContentType())
+	return c
+}
+
+func TestBindMultipart(t *testing.T) {
+	t.Run("generate", func(t *testing.T) {
+		c := multipartContext(t,
+			formPart{"images", "image1"},
+			formPart{"request", `{"model":"test","prompt":"describe"}`},
+			formPart{"images", "image2"},
+		)
+
+		var req api.GenerateRequest
+		if err := bindRequest(c, &req); err != nil {
+			t.Fatal(err)
+		}
+
+		want := api.GenerateRequest{Model: "test", Prompt: "describe", Images: []api.ImageData{[]byte("image1"), []byte("image2")}}
+		if diff := cmp.Diff(want, req); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("chat", func(t *testing.T) {
+		c := multipartContext(t,
+			formPart{"request", `{"model":"test","messages":[{"role":"user","content":"hi"},{"role":"user","content":"describe"}]}`},
+			formPart{"messages[1].images", "image1"},
+		)
+
+		var req api.ChatRequest
+		if err := bindRequest(c, &req); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(req.Messages[0].Images) != 0 {
+			t.Errorf("expected no images in the first message, got %d", len(req.Messages[0].Images))
+		}
+
+		if diff := cmp.Diff([]api.ImageData{[]byte("image1")}, req.Messages[1].Images); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	cases := []struct {
+		name  string
+		parts []formPart
+		err   string
+	}{
+		{
+			"missing request",
+			[]formPart{{"messages[0].images", "image"}},
+			`multipart body is missing the "request" part`,
+		},
+		{
+			"unknown part",
+			[]formPart{{"request", `{"model":"test","messages":[{"role":"user"}]}`}, {"images", "image"}},
+			`unexpected multipart part "images", expected messages[i].images`,
+		},
+		{
+			"missing message",
+			[]formPart{{"request", `{"model":"test","messages":[{"role":"user"}]}`}, {"messages[1].images", "image"}},
+			`multipart part "messages[1].images" refers to a message that doesn't exist`,
+		},
+		{
+			"invalid request",
+			[]formPart{{"request", `{"model":"test","messages":"hi"}`}},
+			"messages: expected array, got string",
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			var req api.ChatRequest
+			err := bindRequest(multipartContext(t, tt.parts...), &req)
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("expected error %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
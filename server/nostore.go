@@ -0,0 +1,17 @@
+package server
+
+import "context"
+
+type noStoreKey struct{}
+
+// withNoStore returns a context whose request's content mustn't be kept in
+// logs or caches
+func withNoStore(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noStoreKey{}, true)
+}
+
+// noStore reports whether the content of ctx's request mustn't be kept
+func noStore(ctx context.Context) bool {
+	v, _ := ctx.Value(noStoreKey{}).(bool)
+	return v
+}
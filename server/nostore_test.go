@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+)
+
+func TestChatNoStore(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mock := mockRunner{CompletionFn: func(_ context.Context, r llm.CompletionRequest, fn func(llm.CompletionResponse)) error {
+		fn(llm.CompletionResponse{Content: "hi", Done: true, DoneReason: "stop"})
+		return nil
+	}}
+
+	s := Server{sched: newMockScheduler(t, &mock)}
+	createMockModel(t, &s, "test", `{{ range .Messages }}{{ .Content }}{{ end }}`)
+
+	lookups := func() int {
+		stats := promptTokens.stats()
+		return stats.Hits + stats.Misses
+	}
+
+	stream := false
+	chat := func(req api.ChatRequest) int {
+		t.Helper()
+		req.Model, req.Stream = "test", &stream
+		w := createRequest(t, s.ChatHandler, req)
+		return w.Code
+	}
+
+	before := lookups()
+	if code := chat(api.ChatRequest{Messages: []api.Message{{Role: "user", Content: "a secret"}}, NoStore: true}); code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", code)
+	}
+
+	if !mock.CompletionRequest.NoStore {
+		t.Error("expected the runner to be asked not to keep the prompt")
+	}
+
+	if lookups() != before {
+		t.Error("expected the prompt not to be cached")
+	}
+
+	if code := chat(api.ChatRequest{Messages: []api.Message{{Role: "user", Content: "not a secret"}}}); code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", code)
+	}
+
+	if mock.CompletionRequest.NoStore {
+		t.Error("expected the runner to keep the prompt by default")
+	}
+
+	if lookups() == before {
+		t.Error("expected the prompt to be cached by default")
+	}
+
+	if code := chat(api.ChatRequest{Messages: []api.Message{{Role: "user", Content: "a secret"}}, Session: "s", NoStore: true}); code != http.StatusBadRequest {
+		t.Errorf("expected no_store with a session to be rejected, got %d", code)
+	}
+}
+
+func TestGenerateNoStore(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var marked bool
+	mock := mockRunner{CompletionFn: func(ctx context.Context, r llm.CompletionRequest, fn func(llm.CompletionResponse)) error {
+		marked = noStore(ctx)
+		fn(llm.CompletionResponse{Content: "hi", Done: true, DoneReason: "stop"})
+		return nil
+	}}
+
+	s := Server{sched: newMockScheduler(t, &mock)}
+	createMockModel(t, &s, "test", `{{ .Prompt }}`)
+
+	stream := false
+	for _, secret := range []bool{true, false} {
+		w := createRequest(t, s.GenerateHandler, api.GenerateRequest{Model: "test", Prompt: "a secret", NoStore: secret, Stream: &stream})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+
+		if mock.CompletionRequest.NoStore != secret || marked != secret {
+			t.Errorf("no_store %t: expected the request and its context to be marked the same, got %t and %t", secret, mock.CompletionRequest.NoStore, marked)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/discover"
+	"github.com/ollama/ollama/llm"
+)
+
+// maxOffloadRetries is the number of times a model that runs out of memory
+// while loading is reloaded with a reduced configuration before giving up
+const maxOffloadRetries = 3
+
+// minBatch is the smallest batch size a reduced configuration will use
+const minBatch = 32
+
+// currentOffload returns the configuration a model is loaded with on gpus.
+// If the number of GPU layers isn't set, it's estimated the same way the
+// runner does.
+func currentOffload(gpus discover.GpuInfoList, ggml *llm.GGML, projectors []string, opts api.Options) api.DegradedConfig {
+	offload := api.DegradedConfig{NumGPU: opts.NumGPU, NumBatch: opts.NumBatch}
+	if offload.NumGPU < 0 {
+		offload.NumGPU = 0
+		if len(gpus) > 0 && gpus[0].Library != "cpu" {
+			offload.NumGPU = llm.EstimateGPULayers(gpus, ggml, projectors, opts).Layers
+		}
+	}
+
+	return offload
+}
+
+// reduceOffload halves the GPU layers and batch size of offload. It returns
+// false if neither can be reduced any further.
+func reduceOffload(offload api.DegradedConfig) (api.DegradedConfig, bool) {
+	reduced := api.DegradedConfig{
+		NumGPU:   offload.NumGPU / 2,
+		NumBatch: max(offload.NumBatch/2, min(offload.NumBatch, minBatch)),
+	}
+
+	return reduced, reduced != offload
+}
+
+// applyOffload limits opts to the GPU layers and batch size of offload. It
+// returns the configuration opts were reduced to, or nil if they already use
+// less.
+func applyOffload(opts *api.Options, offload *api.DegradedConfig) *api.DegradedConfig {
+	if offload == nil {
+		return nil
+	}
+
+	var reduced bool
+	if opts.NumGPU < 0 || opts.NumGPU > offload.NumGPU {
+		opts.NumGPU = offload.NumGPU
+		reduced = true
+	}
+
+	if opts.NumBatch > offload.NumBatch {
+		opts.NumBatch = offload.NumBatch
+		reduced = true
+	}
+
+	if !reduced {
+		return nil
+	}
+
+	return &api.DegradedConfig{NumGPU: opts.NumGPU, NumBatch: opts.NumBatch}
+}
+
+// degradedOffload returns the reduced configuration recorded for a model
+// after it ran out of memory, or nil if it hasn't
+func (s *Scheduler) degradedOffload(modelPath string) *api.DegradedConfig {
+	s.offloadsMu.Lock()
+	defer s.offloadsMu.Unlock()
+	if offload, ok := s.offloads[modelPath]; ok {
+		return &offload
+	}
+
+	return nil
+}
+
+// setDegradedOffload records the reduced configuration subsequent loads of a
+// model use
+func (s *Scheduler) setDegradedOffload(modelPath string, offload api.DegradedConfig) {
+	s.offloadsMu.Lock()
+	defer s.offloadsMu.Unlock()
+	if s.offloads == nil {
+		s.offloads = make(map[string]api.DegradedConfig)
+	}
+
+	s.offloads[modelPath] = offload
+}
+
+// clearDegradedOffload forgets the reduced configuration of a model, so it's
+// loaded with its requested options again
+func (s *Scheduler) clearDegradedOffload(modelPath string) {
+	s.offloadsMu.Lock()
+	defer s.offloadsMu.Unlock()
+	delete(s.offloads, modelPath)
+}
+
+// outOfMemory handles a model that ran out of memory after loading. The next
+// load uses a reduced configuration and the loaded runner is replaced by the
+// next request for the model. It returns false if the configuration can't be
+// reduced any further.
+func (s *Scheduler) outOfMemory(model *Model) bool {
+	s.loadedMu.Lock()
+	runner := s.loaded[model.ModelPath]
+	s.loadedMu.Unlock()
+	if runner == nil {
+		return false
+	}
+
+	runner.refMu.Lock()
+	defer runner.refMu.Unlock()
+	if runner.stale {
+		// another request already reduced the configuration
+		return true
+	}
+
+	offload, ok := reduceOffload(runner.offload)
+	if !ok {
+		return false
+	}
+
+	slog.Warn("model ran out of memory, reloading with reduced offload", "model", model.ModelPath, "num_gpu", offload.NumGPU, "num_batch", offload.NumBatch)
+	s.setDegradedOffload(model.ModelPath, offload)
+	runner.stale = true
+	return true
+}
+
+// loadedDegraded returns the reduced configuration of the loaded runner for a
+// model, or nil if it's running with its requested options
+func (s *Scheduler) loadedDegraded(model *Model) *api.DegradedConfig {
+	s.loadedMu.Lock()
+	runner := s.loaded[model.ModelPath]
+	s.loadedMu.Unlock()
+	if runner == nil {
+		return nil
+	}
+
+	runner.refMu.Lock()
+	defer runner.refMu.Unlock()
+	return runner.degraded
+}
+
+// rescheduleOutOfMemory replaces a runner that ran out of memory with one
+// using a reduced configuration. release is called to release the runner and
+// replaced with the release function of its replacement. If the configuration
+// can't be reduced any further, it returns err.
+func (s *Server) rescheduleOutOfMemory(ctx context.Context, m *Model, release *context.CancelFunc, err error, name string, caps []Capability, requestOpts map[string]any, keepAlive *api.Duration) (llm.LlamaServer, error) {
+	if !s.sched.outOfMemory(m) {
+		return nil, err
+	}
+
+	(*release)()
+	ctx, *release = context.WithCancel(ctx)
+	r, _, _, err := s.scheduleRunner(ctx, name, caps, requestOpts, keepAlive)
+	return r, err
+}
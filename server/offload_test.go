@@ -0,0 +1,176 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/discover"
+	"github.com/ollama/ollama/llm"
+)
+
+func TestReduceOffload(t *testing.T) {
+	cases := []struct {
+		in   api.DegradedConfig
+		want api.DegradedConfig
+		ok   bool
+	}{
+		{api.DegradedConfig{NumGPU: 33, NumBatch: 512}, api.DegradedConfig{NumGPU: 16, NumBatch: 256}, true},
+		{api.DegradedConfig{NumGPU: 1, NumBatch: 48}, api.DegradedConfig{NumGPU: 0, NumBatch: 32}, true},
+		{api.DegradedConfig{NumGPU: 0, NumBatch: 64}, api.DegradedConfig{NumGPU: 0, NumBatch: 32}, true},
+		{api.DegradedConfig{NumGPU: 0, NumBatch: 16}, api.DegradedConfig{NumGPU: 0, NumBatch: 16}, false},
+	}
+
+	for _, tt := range cases {
+		got, ok := reduceOffload(tt.in)
+		require.Equal(t, tt.want, got)
+		require.Equal(t, tt.ok, ok)
+	}
+}
+
+func TestApplyOffload(t *testing.T) {
+	opts := api.DefaultOptions()
+	require.Nil(t, applyOffload(&opts, nil))
+	require.Equal(t, -1, opts.NumGPU)
+
+	got := applyOffload(&opts, &api.DegradedConfig{NumGPU: 10, NumBatch: 256})
+	require.Equal(t, &api.DegradedConfig{NumGPU: 10, NumBatch: 256}, got)
+	require.Equal(t, 10, opts.NumGPU)
+	require.Equal(t, 256, opts.NumBatch)
+
+	// options that already use less are unchanged
+	opts.NumGPU = 4
+	opts.NumBatch = 128
+	require.Nil(t, applyOffload(&opts, &api.DegradedConfig{NumGPU: 10, NumBatch: 256}))
+	require.Equal(t, 4, opts.NumGPU)
+	require.Equal(t, 128, opts.NumBatch)
+}
+
+func TestLoadOutOfMemory(t *testing.T) {
+	ctx, done := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer done()
+	s := InitScheduler(ctx)
+
+	opts := api.DefaultOptions()
+	opts.NumGPU = 8
+	req := &LlmRequest{
+		ctx:             ctx,
+		model:           &Model{ModelPath: "foo"},
+		opts:            opts,
+		successCh:       make(chan *runnerRef, 1),
+		errCh:           make(chan error, 1),
+		sessionDuration: &api.Duration{Duration: 2 * time.Second},
+	}
+
+	var loads []api.Options
+	s.newServerFn = func(gpus discover.GpuInfoList, model string, ggml *llm.GGML, adapters []string, projectors []string, opts api.Options, numParallel int) (llm.LlamaServer, error) {
+		loads = append(loads, opts)
+		server := &mockLlm{estimatedVRAMByGPU: map[string]uint64{}}
+		if len(loads) < 3 {
+			server.waitResp = errors.New("llama runner process has terminated: cudaMalloc failed: out of memory")
+		}
+		return server, nil
+	}
+
+	s.load(req, nil, discover.GpuInfoList{}, 1)
+	select {
+	case err := <-req.errCh:
+		t.Fatalf("unexpected error %v", err)
+	case runner := <-req.successCh:
+		require.Equal(t, &api.DegradedConfig{NumGPU: 2, NumBatch: 128}, runner.degraded)
+		require.Equal(t, 8, runner.Options.NumGPU)
+	}
+
+	require.Len(t, loads, 3)
+	require.Equal(t, 4, loads[1].NumGPU)
+	require.Equal(t, 2, loads[2].NumGPU)
+	require.Equal(t, &api.DegradedConfig{NumGPU: 2, NumBatch: 128}, s.degradedOffload("foo"))
+
+	// subsequent loads start with the reduced configuration
+	req.successCh = make(chan *runnerRef, 1)
+	s.load(req, nil, discover.GpuInfoList{}, 1)
+	runner := <-req.successCh
+	require.Len(t, loads, 4)
+	require.Equal(t, 2, loads[3].NumGPU)
+	require.Equal(t, 128, loads[3].NumBatch)
+	require.Equal(t, &api.DegradedConfig{NumGPU: 2, NumBatch: 128}, runner.degraded)
+
+	// other errors fail the load
+	req.model = &Model{ModelPath: "bar"}
+	s.newServerFn = func(gpus discover.GpuInfoList, model string, ggml *llm.GGML, adapters []string, projectors []string, opts api.Options, numParallel int) (llm.LlamaServer, error) {
+		loads = append(loads, opts)
+		return &mockLlm{waitResp: errors.New("wait failure"), estimatedVRAMByGPU: map[string]uint64{}}, nil
+	}
+	s.load(req, nil, discover.GpuInfoList{}, 1)
+	require.ErrorContains(t, <-req.errCh, "wait failure")
+	require.Len(t, loads, 5)
+}
+
+func TestSchedulerOutOfMemory(t *testing.T) {
+	ctx, done := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer done()
+	s := InitScheduler(ctx)
+
+	model := &Model{ModelPath: "foo"}
+	require.False(t, s.outOfMemory(model))
+
+	opts := api.DefaultOptions()
+	runner := &runnerRef{
+		model:       model,
+		modelPath:   model.ModelPath,
+		llama:       &mockLlm{},
+		Options:     &opts,
+		numParallel: 1,
+		offload:     api.DegradedConfig{NumGPU: 20, NumBatch: 512},
+	}
+	s.loaded[model.ModelPath] = runner
+
+	req := &LlmRequest{model: model, opts: opts}
+	require.False(t, runner.needsReload(ctx, req))
+
+	require.True(t, s.outOfMemory(model))
+	require.True(t, runner.needsReload(ctx, req))
+	require.Equal(t, &api.DegradedConfig{NumGPU: 10, NumBatch: 256}, s.degradedOffload(model.ModelPath))
+
+	// the configuration is only reduced once per runner
+	require.True(t, s.outOfMemory(model))
+	require.Equal(t, &api.DegradedConfig{NumGPU: 10, NumBatch: 256}, s.degradedOffload(model.ModelPath))
+
+	runner.stale = false
+	runner.offload = api.DegradedConfig{NumBatch: 16}
+	require.False(t, s.outOfMemory(model))
+}
+
+func TestDegradedOffloadClearedOnUnload(t *testing.T) {
+	ctx, done := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer done()
+	s := InitScheduler(ctx)
+	go s.processCompleted(ctx)
+
+	unload := func(runner *runnerRef) {
+		s.loadedMu.Lock()
+		s.loaded[runner.modelPath] = runner
+		s.loadedMu.Unlock()
+
+		s.expiredCh <- runner
+		select {
+		case <-s.unloadedCh:
+		case <-ctx.Done():
+			t.Fatal("timeout")
+		}
+	}
+
+	// runners replaced after running out of memory keep the reduced
+	// configuration for their replacement
+	s.setDegradedOffload("foo", api.DegradedConfig{NumGPU: 10, NumBatch: 256})
+	unload(&runnerRef{modelPath: "foo", llama: &mockLlm{}, stale: true})
+	require.NotNil(t, s.degradedOffload("foo"))
+
+	// others forget it once they're unloaded
+	unload(&runnerRef{modelPath: "foo", llama: &mockLlm{}})
+	require.Nil(t, s.degradedOffload("foo"))
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/ollama/ollama/discover"
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/llm"
+)
+
+// parallelInterval is how often the parallel requests of loaded models are
+// scaled
+var parallelInterval = 5 * time.Second
+
+// parallelWindow is how long a model's requests are watched before its
+// parallel requests are scaled down to the most it had at once
+var parallelWindow = time.Minute
+
+// monitorParallel scales the parallel requests of each loaded model with
+// the requests waiting for it and the memory free, if enabled with
+// OLLAMA_MAX_PARALLEL
+func (s *Scheduler) monitorParallel(ctx context.Context) {
+	maxParallel := int(envconfig.MaxParallel())
+	if maxParallel == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(parallelInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.scaleParallel(ctx, maxParallel, now)
+		}
+	}
+}
+
+// scaleParallel starts scaling the loaded models whose parallel requests
+// should change
+func (s *Scheduler) scaleParallel(ctx context.Context, maxParallel int, now time.Time) {
+	s.loadedMu.Lock()
+	runners := make([]*runnerRef, 0, len(s.loaded))
+	for _, runner := range s.loaded {
+		runners = append(runners, runner)
+	}
+	s.loadedMu.Unlock()
+
+	for _, runner := range runners {
+		runner.refMu.Lock()
+		numParallel, ok := runner.parallelTarget(maxParallel, now)
+		if ok {
+			runner.scaling = true
+			go s.setParallel(ctx, runner, runner.llama, numParallel)
+		}
+		runner.refMu.Unlock()
+	}
+}
+
+// parallelTarget returns the parallel requests runner should be scaled to,
+// if they should change. Models are scaled up to the requests they have
+// once some are waiting, and down to the most they had at once once they've
+// had no more than half their parallel requests for a while. The refMu
+// must be held.
+func (runner *runnerRef) parallelTarget(maxParallel int, now time.Time) (int, bool) {
+	refs := int(runner.refCount)
+	runner.peak = max(runner.peak, refs)
+	if runner.llama == nil || runner.loading || runner.scaling || runner.fixedParallel || runner.stale || runner.draining {
+		return 0, false
+	}
+
+	if refs > runner.numParallel && runner.numParallel < maxParallel {
+		return min(refs, maxParallel), true
+	}
+
+	if now.Sub(runner.peakSince) < parallelWindow {
+		return 0, false
+	}
+
+	// requests that arrive while the model is scaled down wait for it, so
+	// it's only scaled down while idle
+	if refs == 0 && runner.numParallel > 1 && runner.peak*2 <= runner.numParallel {
+		return max(runner.peak, 1), true
+	}
+
+	runner.peak, runner.peakSince = refs, now
+	return 0, false
+}
+
+// setParallel changes the parallel requests of runner to numParallel,
+// without reloading it
+func (s *Scheduler) setParallel(ctx context.Context, runner *runnerRef, llama llm.LlamaServer, numParallel int) {
+	err := llama.SetParallel(ctx, s.gpusFor(runner, llama), numParallel)
+
+	runner.refMu.Lock()
+	defer runner.refMu.Unlock()
+	runner.scaling = false
+	if runner.llama != llama {
+		// unloaded while it was being scaled
+		return
+	}
+
+	if errors.Is(err, llm.ErrParallelUnsupported) {
+		slog.Info("model's parallel requests can't be scaled without reloading it", "model", runner.modelPath, "error", err)
+		runner.fixedParallel = true
+		return
+	} else if err != nil {
+		slog.Debug("unable to scale parallel requests", "model", runner.modelPath, "parallel", numParallel, "error", err)
+		return
+	}
+
+	slog.Info("scaled parallel requests", "model", runner.modelPath, "parallel", numParallel, "previous", runner.numParallel)
+	opts := *runner.Options
+	opts.NumCtx = runner.numCtx * numParallel
+	runner.Options = &opts
+	runner.numParallel = numParallel
+	runner.estimatedVRAM = llama.EstimatedVRAM()
+	runner.estimatedTotal = llama.EstimatedTotal()
+	runner.recordLayout()
+	runner.peak, runner.peakSince = int(runner.refCount), time.Now()
+}
+
+// gpusFor returns the devices runner is loaded on with the memory free to
+// it: what isn't used by other models, and what it uses itself
+func (s *Scheduler) gpusFor(runner *runnerRef, llama llm.LlamaServer) discover.GpuInfoList {
+	runner.refMu.Lock()
+	loadedOn := runner.gpus
+	runner.refMu.Unlock()
+
+	if len(loadedOn) == 0 || loadedOn[0].Library == "cpu" {
+		return s.getCpuFn()
+	}
+
+	all := s.getGpuFn()
+	gpuReserveFromEnv().apply(all)
+	s.updateFreeSpace(all)
+
+	var gpus discover.GpuInfoList
+	for _, gpu := range loadedOn {
+		for _, g := range all {
+			if g.Library == gpu.Library && g.ID == gpu.ID {
+				g.FreeMemory += llama.EstimatedVRAMByGPU(g.ID)
+				gpus = append(gpus, g)
+			}
+		}
+	}
+
+	return gpus
+}
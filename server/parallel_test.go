@@ -0,0 +1,115 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/discover"
+	"github.com/ollama/ollama/llm"
+)
+
+func TestParallelTarget(t *testing.T) {
+	now := time.Now()
+	longAgo := now.Add(-2 * parallelWindow)
+
+	cases := []struct {
+		name   string
+		runner *runnerRef
+		want   int
+		ok     bool
+	}{
+		{name: "waiting", runner: &runnerRef{numParallel: 1, refCount: 3, peakSince: now}, want: 3, ok: true},
+		{name: "waiting past max", runner: &runnerRef{numParallel: 2, refCount: 9, peakSince: now}, want: 4, ok: true},
+		{name: "at max", runner: &runnerRef{numParallel: 4, refCount: 9, peakSince: now}},
+		{name: "busy", runner: &runnerRef{numParallel: 2, refCount: 2, peakSince: now}},
+		{name: "idle recently", runner: &runnerRef{numParallel: 4, peakSince: now}},
+		{name: "idle", runner: &runnerRef{numParallel: 4, peakSince: longAgo}, want: 1, ok: true},
+		{name: "idle after two at once", runner: &runnerRef{numParallel: 4, peak: 2, peakSince: longAgo}, want: 2, ok: true},
+		{name: "idle after three at once", runner: &runnerRef{numParallel: 4, peak: 3, peakSince: longAgo}},
+		{name: "in use", runner: &runnerRef{numParallel: 4, refCount: 1, peakSince: longAgo}},
+		{name: "loading", runner: &runnerRef{numParallel: 1, refCount: 3, loading: true}},
+		{name: "scaling", runner: &runnerRef{numParallel: 1, refCount: 3, scaling: true}},
+		{name: "fixed", runner: &runnerRef{numParallel: 1, refCount: 3, fixedParallel: true}},
+		{name: "draining", runner: &runnerRef{numParallel: 1, refCount: 3, draining: true}},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := tt.runner
+			runner.llama = &mockLlm{}
+			got, ok := runner.parallelTarget(4, now)
+			require.Equal(t, tt.ok, ok)
+			require.Equal(t, tt.want, got)
+		})
+	}
+
+	t.Run("window", func(t *testing.T) {
+		runner := runnerRef{llama: &mockLlm{}, numParallel: 4, refCount: 1, peak: 3, peakSince: longAgo}
+		_, ok := runner.parallelTarget(4, now)
+		require.False(t, ok)
+		require.Equal(t, 1, runner.peak, "expected a new window to start")
+		require.Equal(t, now, runner.peakSince)
+	})
+}
+
+func TestSetParallel(t *testing.T) {
+	ctx, done := context.WithTimeout(context.Background(), 5*time.Second)
+	defer done()
+
+	s := InitScheduler(ctx)
+	s.getCpuFn = func() discover.GpuInfoList {
+		g := discover.GpuInfo{Library: "cpu"}
+		g.TotalMemory = 24 * 1024 * 1024 * 1024
+		g.FreeMemory = 12 * 1024 * 1024 * 1024
+		return []discover.GpuInfo{g}
+	}
+
+	newRunner := func(llama llm.LlamaServer) *runnerRef {
+		return &runnerRef{
+			llama:       llama,
+			modelPath:   "test",
+			gpus:        s.getCpuFn(),
+			numParallel: 1,
+			numCtx:      2048,
+			Options:     &api.Options{Runner: api.Runner{NumCtx: 2048}},
+			scaling:     true,
+		}
+	}
+
+	t.Run("scaled", func(t *testing.T) {
+		llama := &mockLlm{estimatedTotal: 10}
+		runner := newRunner(llama)
+		s.setParallel(ctx, runner, llama, 4)
+
+		require.Equal(t, 4, llama.parallel)
+		require.Equal(t, 4, runner.numParallel)
+		require.Equal(t, 8192, runner.NumCtx)
+		require.Equal(t, uint64(10), runner.estimatedTotal)
+		require.False(t, runner.scaling)
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		llama := &mockLlm{parallelErr: llm.ErrParallelUnsupported}
+		runner := newRunner(llama)
+		s.setParallel(ctx, runner, llama, 4)
+
+		require.Equal(t, 1, runner.numParallel)
+		require.Equal(t, 2048, runner.NumCtx)
+		require.True(t, runner.fixedParallel)
+		require.False(t, runner.scaling)
+	})
+
+	t.Run("unloaded", func(t *testing.T) {
+		llama := &mockLlm{}
+		runner := newRunner(llama)
+		runner.llama = nil
+		s.setParallel(ctx, runner, llama, 4)
+
+		require.Equal(t, 1, runner.numParallel)
+		require.False(t, runner.scaling)
+	})
+}
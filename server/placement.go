@@ -0,0 +1,118 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/discover"
+	"github.com/ollama/ollama/llm"
+)
+
+var (
+	errTensorSplit = errors.New("tensor_split")
+	errSplitPolicy = errors.New("split_policy")
+)
+
+// checkPlacement returns an error if opts set a tensor split or split
+// policy that can't be used
+func checkPlacement(opts api.Options) error {
+	if _, err := llm.ParseTensorSplit(opts.TensorSplit); err != nil {
+		return fmt.Errorf("%w %q isn't a list of layer counts: %v", errTensorSplit, opts.TensorSplit, err)
+	}
+
+	switch opts.SplitPolicy {
+	case "", "even", "fastest":
+	default:
+		return fmt.Errorf("%w %q isn't one of even or fastest", errSplitPolicy, opts.SplitPolicy)
+	}
+
+	return nil
+}
+
+// gpuIDs returns the IDs of gpus in order
+func gpuIDs(gpus discover.GpuInfoList) []string {
+	ids := make([]string, len(gpus))
+	for i, gpu := range gpus {
+		ids[i] = gpu.ID
+	}
+
+	return ids
+}
+
+// splitGPUs returns the GPUs of gpus that the request's tensor split places
+// layers on, which may be none of them, or gpus if it doesn't set one
+func splitGPUs(req *LlmRequest, gpus discover.GpuInfoList) discover.GpuInfoList {
+	split, _ := llm.ParseTensorSplit(req.opts.TensorSplit)
+	if split == nil || len(req.gpuOrder) == 0 {
+		return gpus
+	}
+
+	var placed discover.GpuInfoList
+	for _, gpu := range gpus {
+		if i := slices.Index(req.gpuOrder, gpu.ID); i >= 0 && i < len(split) && split[i] > 0 {
+			placed = append(placed, gpu)
+		}
+	}
+
+	if byLibrary := placed.ByLibrary(); len(byLibrary) > 1 {
+		slog.Warn("tensor split places layers on GPUs of more than one library, using the first", "split", req.opts.TensorSplit)
+		placed = byLibrary[0]
+	}
+
+	return placed
+}
+
+// optsOn returns the request's options for loading it on gpus. The tensor
+// split and main GPU are set by the position of GPUs in the order they were
+// discovered, so they're changed to positions in gpus.
+func (req *LlmRequest) optsOn(gpus discover.GpuInfoList) api.Options {
+	opts := req.opts
+	if len(req.gpuOrder) == 0 {
+		return opts
+	}
+
+	if split, _ := llm.ParseTensorSplit(opts.TensorSplit); split != nil {
+		counts := make([]string, len(gpus))
+		for i, gpu := range gpus {
+			var n int
+			if j := slices.Index(req.gpuOrder, gpu.ID); j >= 0 && j < len(split) {
+				n = split[j]
+			}
+			counts[i] = strconv.Itoa(n)
+		}
+		opts.TensorSplit = strings.Join(counts, ",")
+	}
+
+	opts.MainGPU = 0
+	if req.opts.MainGPU > 0 && req.opts.MainGPU < len(req.gpuOrder) {
+		opts.MainGPU = max(slices.IndexFunc(gpus, func(gpu discover.GpuInfo) bool {
+			return gpu.ID == req.gpuOrder[req.opts.MainGPU]
+		}), 0)
+	}
+
+	return opts
+}
+
+// placement returns the layers and memory of a runner on each of its GPUs
+func (runner *runnerRef) placement() []api.GPUPlacement {
+	if runner.llama == nil || len(runner.gpus) == 0 || runner.gpus[0].Library == "cpu" {
+		return nil
+	}
+
+	placement := make([]api.GPUPlacement, 0, len(runner.gpus))
+	for _, gpu := range runner.gpus {
+		placement = append(placement, api.GPUPlacement{
+			ID:       gpu.ID,
+			Name:     gpu.Name,
+			Layers:   runner.llama.EstimatedLayersByGPU(gpu.ID),
+			SizeVRAM: int64(runner.llama.EstimatedVRAMByGPU(gpu.ID)),
+		})
+	}
+
+	return placement
+}
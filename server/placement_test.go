@@ -0,0 +1,109 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/discover"
+	"github.com/ollama/ollama/format"
+	"github.com/ollama/ollama/llm"
+)
+
+func TestCheckPlacement(t *testing.T) {
+	cases := []struct {
+		split, policy string
+		err           error
+	}{
+		{},
+		{split: "24,8", policy: "even"},
+		{split: "0,8", policy: "fastest"},
+		{split: "24,x", err: errTensorSplit},
+		{split: "0,0", err: errTensorSplit},
+		{policy: "slowest", err: errSplitPolicy},
+	}
+
+	for _, tt := range cases {
+		opts := api.DefaultOptions()
+		opts.TensorSplit, opts.SplitPolicy = tt.split, tt.policy
+		if err := checkPlacement(opts); !errors.Is(err, tt.err) {
+			t.Errorf("%q %q: expected %v, got %v", tt.split, tt.policy, tt.err, err)
+		}
+	}
+}
+
+func TestOptsOn(t *testing.T) {
+	gpus := discover.GpuInfoList{{ID: "a"}, {ID: "b"}, {ID: "c"}}
+	req := &LlmRequest{opts: api.DefaultOptions(), gpuOrder: gpuIDs(gpus)}
+	req.opts.TensorSplit = "0,4,2"
+	req.opts.MainGPU = 2
+
+	require.Equal(t, discover.GpuInfoList{gpus[1], gpus[2]}, splitGPUs(req, gpus))
+
+	opts := req.optsOn(discover.GpuInfoList{gpus[2], gpus[1]})
+	require.Equal(t, "2,4", opts.TensorSplit)
+	require.Equal(t, 0, opts.MainGPU)
+
+	opts = req.optsOn(discover.GpuInfoList{gpus[1], gpus[2]})
+	require.Equal(t, "4,2", opts.TensorSplit)
+	require.Equal(t, 1, opts.MainGPU)
+
+	// the options the request was made with are kept, so the runner isn't
+	// reloaded for the next request with them
+	require.Equal(t, "0,4,2", req.opts.TensorSplit)
+	require.Equal(t, 2, req.opts.MainGPU)
+}
+
+func TestRequestsTensorSplit(t *testing.T) {
+	ctx, done := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer done()
+
+	s := InitScheduler(ctx)
+	s.getGpuFn = func() discover.GpuInfoList {
+		gpus := discover.GpuInfoList{
+			{ID: "0", Library: "cuda"},
+			{ID: "1", Library: "cuda"},
+			{ID: "2", Library: "cuda"},
+		}
+		for i := range gpus {
+			gpus[i].TotalMemory = 24 * format.GigaByte
+			gpus[i].FreeMemory = 12 * format.GigaByte
+		}
+		return gpus
+	}
+	s.getCpuFn = getCpuFn
+
+	a := newScenarioRequest(t, ctx, "ollama-model-1", 10, nil)
+	a.req.opts.TensorSplit = "1,0,1"
+	a.req.opts.MainGPU = 2
+	a.srv.layersByGPU = map[string]int{"0": 1, "2": 1}
+
+	var loadedOn discover.GpuInfoList
+	var loadedWith api.Options
+	s.newServerFn = func(gpus discover.GpuInfoList, model string, ggml *llm.GGML, adapters []string, projectors []string, opts api.Options, numParallel int) (llm.LlamaServer, error) {
+		loadedOn, loadedWith = gpus, opts
+		return a.srv, nil
+	}
+
+	s.pendingReqCh <- a.req
+	s.Run(ctx)
+	select {
+	case resp := <-a.req.successCh:
+		require.Equal(t, []string{"0", "2"}, gpuIDs(loadedOn))
+		require.Equal(t, "1,1", loadedWith.TensorSplit)
+		require.Equal(t, 1, loadedWith.MainGPU)
+
+		placement := resp.placement()
+		require.Len(t, placement, 2)
+		require.Equal(t, api.GPUPlacement{ID: "0", Layers: 1}, placement[0])
+		require.Equal(t, api.GPUPlacement{ID: "2", Layers: 1}, placement[1])
+	case err := <-a.req.errCh:
+		t.Fatal(err.Error())
+	case <-ctx.Done():
+		t.Fatal("timeout")
+	}
+}
@@ -0,0 +1,389 @@
+package server
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/discover"
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/format"
+	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/types/model"
+)
+
+const (
+	// planLoadRate is the rate, in bytes per second, models are assumed to
+	// be read from disk and loaded at
+	planLoadRate = 2 * format.GigaByte
+
+	// planMaxRequests is the most requests a plan simulates
+	planMaxRequests = 100_000
+
+	planDuration        = time.Hour
+	planRequestDuration = 10 * time.Second
+)
+
+// planModel is a model served in a simulated plan
+type planModel struct {
+	name string
+
+	// size is the memory the model needs and vram the part of it loaded
+	// into the memory models share
+	size, vram uint64
+	processor  string
+
+	requestsPerMinute float64
+	requestDuration   time.Duration
+	loadDuration      time.Duration
+	// keepAlive is negative if the model is never unloaded for being idle
+	keepAlive   time.Duration
+	numParallel int
+}
+
+// planRunner is a loaded model in a simulated plan
+type planRunner struct {
+	// slots are the times each of the model's parallel sequences is free
+	slots []time.Duration
+}
+
+// busyUntil returns the time the runner finishes its requests
+func (r *planRunner) busyUntil() time.Duration {
+	return slices.Max(r.slots)
+}
+
+type planArrival struct {
+	at    time.Duration
+	model int
+}
+
+// planArrivals returns the requests for models over duration in the order
+// they arrive. Requests for each model arrive at a steady rate, offset from
+// the other models' so they're interleaved.
+func planArrivals(models []planModel, duration time.Duration) ([]planArrival, error) {
+	var arrivals []planArrival
+	for i, m := range models {
+		if m.requestsPerMinute <= 0 {
+			continue
+		}
+
+		interval := time.Duration(float64(time.Minute) / m.requestsPerMinute)
+		if interval <= 0 || int64(duration/interval) > planMaxRequests {
+			return nil, fmt.Errorf("too many requests to simulate, the most is %d", planMaxRequests)
+		}
+
+		for at := interval * time.Duration(i) / time.Duration(len(models)); at < duration; at += interval {
+			arrivals = append(arrivals, planArrival{at, i})
+		}
+
+		if len(arrivals) > planMaxRequests {
+			return nil, fmt.Errorf("too many requests to simulate, the most is %d", planMaxRequests)
+		}
+	}
+
+	slices.SortStableFunc(arrivals, func(a, b planArrival) int { return cmp.Compare(a.at, b.at) })
+	return arrivals, nil
+}
+
+// simulatePlan simulates serving models in capacity bytes of memory for
+// duration. Like the scheduler, requests are scheduled one at a time, models
+// are loaded when they fit alongside at most maxLoaded models, idle models
+// are unloaded to make room, least recently used first, and requests wait for
+// busy models to finish when there's nothing to unload.
+func simulatePlan(models []planModel, capacity uint64, maxLoaded int, duration time.Duration) (api.PlanResponse, error) {
+	arrivals, err := planArrivals(models, duration)
+	if err != nil {
+		return api.PlanResponse{}, err
+	}
+
+	resp := api.PlanResponse{VRAM: capacity, Models: make([]api.PlanModelResponse, len(models))}
+	for i, m := range models {
+		resp.Models[i] = api.PlanModelResponse{Model: m.name, Size: m.size, SizeVRAM: m.vram, Processor: m.processor}
+	}
+
+	need := func(m int) uint64 {
+		return min(models[m].vram, capacity)
+	}
+
+	// used is the memory used by loaded models since last, and area the
+	// sum of the memory used over time before it
+	var used uint64
+	var last time.Duration
+	var area float64
+	setUsed := func(at time.Duration, n uint64) {
+		at = max(at, last)
+		area += float64(used) * float64(at-last)
+		last, used = at, n
+		resp.PeakVRAM = max(resp.PeakVRAM, used)
+	}
+
+	loaded := make(map[int]*planRunner)
+	unload := func(m int, at time.Duration) {
+		delete(loaded, m)
+		setUsed(at, used-need(m))
+	}
+
+	// expire unloads the models idle for longer than their keep alive by now
+	expire := func(now time.Duration) {
+		type expiry struct {
+			model int
+			at    time.Duration
+		}
+
+		var expired []expiry
+		for m, r := range loaded {
+			if ka := models[m].keepAlive; ka >= 0 && r.busyUntil()+ka <= now {
+				expired = append(expired, expiry{m, r.busyUntil() + ka})
+			}
+		}
+
+		slices.SortFunc(expired, func(a, b expiry) int { return cmp.Or(cmp.Compare(a.at, b.at), cmp.Compare(a.model, b.model)) })
+		for _, e := range expired {
+			unload(e.model, e.at)
+		}
+	}
+
+	var now time.Duration
+	queued := make([]time.Duration, len(models))
+	for _, a := range arrivals {
+		now = max(now, a.at)
+		expire(now)
+
+		r, ok := loaded[a.model]
+		for !ok && (used+need(a.model) > capacity || len(loaded) >= maxLoaded) {
+			// unload the idle model that's been idle longest, or wait
+			// for the first busy model to finish
+			victim, next := -1, time.Duration(-1)
+			for m, r := range loaded {
+				busy := r.busyUntil()
+				if busy <= now && (victim < 0 || busy < loaded[victim].busyUntil() || (busy == loaded[victim].busyUntil() && m < victim)) {
+					victim = m
+				}
+
+				if busy > now && (next < 0 || busy < next) {
+					next = busy
+				}
+			}
+
+			if victim >= 0 {
+				unload(victim, now)
+				resp.Models[victim].Evictions++
+				continue
+			}
+
+			now = next
+			expire(now)
+		}
+
+		if !ok {
+			setUsed(now, used+need(a.model))
+			resp.Models[a.model].Loads++
+
+			r = &planRunner{slots: make([]time.Duration, max(models[a.model].numParallel, 1))}
+			for i := range r.slots {
+				r.slots[i] = now + models[a.model].loadDuration
+			}
+
+			loaded[a.model] = r
+		}
+
+		slot := 0
+		for i := range r.slots {
+			if r.slots[i] < r.slots[slot] {
+				slot = i
+			}
+		}
+
+		start := max(now, r.slots[slot])
+		r.slots[slot] = start + models[a.model].requestDuration
+
+		wait := start - a.at
+		mr := &resp.Models[a.model]
+		mr.Requests++
+		mr.MaxQueueTime = max(mr.MaxQueueTime, wait)
+		queued[a.model] += wait
+	}
+
+	end := max(duration, now)
+	setUsed(end, used)
+	if capacity > 0 && end > 0 {
+		resp.VRAMUtilization = area / float64(end) / float64(capacity)
+	}
+
+	for i := range resp.Models {
+		if n := resp.Models[i].Requests; n > 0 {
+			resp.Models[i].QueueTime = queued[i] / time.Duration(n)
+		}
+	}
+
+	return resp, nil
+}
+
+// planGPUs returns the GPUs of the largest library a model can be split
+// across, with all of their memory free as on an idle server, or nil if
+// there are no GPUs
+func planGPUs(gpus discover.GpuInfoList) discover.GpuInfoList {
+	var best discover.GpuInfoList
+	var most uint64
+	for _, group := range gpus.ByLibrary() {
+		if group[0].Library == "cpu" {
+			continue
+		}
+
+		if total := availableVRAM(group); best == nil || total > most {
+			best, most = group, total
+		}
+	}
+
+	best = slices.Clone(best)
+	for i := range best {
+		best[i].FreeMemory = best[i].TotalMemory
+	}
+
+	return best
+}
+
+// PlanHandler simulates serving a set of models with an expected mix of
+// requests on the server's hardware, to help choose a deployment layout
+// before committing to it. Nothing is loaded.
+func (s *Server) PlanHandler(c *gin.Context) {
+	var req api.PlanRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if len(req.Models) == 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "models are required"})
+		return
+	}
+
+	duration := planDuration
+	if req.Duration != nil {
+		duration = req.Duration.Duration
+	}
+
+	if duration <= 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "duration must be positive"})
+		return
+	}
+
+	gpus := s.sched.getGpuFn()
+	gpuReserveFromEnv().apply(gpus)
+	gpus = planGPUs(gpus)
+
+	capacity := availableVRAM(gpus)
+	if len(gpus) == 0 {
+		// leave a quarter of system memory for the rest of the system
+		if cpus := s.sched.getCpuFn(); len(cpus) > 0 {
+			capacity = cpus[0].TotalMemory / 4 * 3
+		}
+	}
+
+	maxLoaded := int(envconfig.MaxRunners())
+	if maxLoaded <= 0 {
+		maxLoaded = defaultModelsPerGPU * max(len(gpus), 1)
+	}
+
+	models := make([]planModel, len(req.Models))
+	for i, pm := range req.Models {
+		if pm.RequestsPerMinute < 0 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("requests_per_minute of %q must not be negative", pm.Model)})
+			return
+		}
+
+		name, err := getExistingName(model.ParseName(pm.Model))
+		if err != nil || !name.IsValid() {
+			c.JSON(http.StatusNotFound, errorBody(api.ErrorCodeModelNotFound, fmt.Sprintf("model '%s' not found", pm.Model)))
+			return
+		}
+
+		m, err := GetModel(name.String())
+		if err != nil {
+			handleScheduleError(c, pm.Model, err)
+			return
+		}
+
+		if m.Config.Remote != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%q generates with a remote server and doesn't use local memory", pm.Model)})
+			return
+		}
+
+		ggml, err := llm.LoadModel(m.ModelPath, 0)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		opts, err := modelOptions(m, pm.Options)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		numParallel := int(envconfig.NumParallel())
+		if numParallel <= 0 {
+			numParallel = defaultParallel
+		}
+
+		// embedding models are always loaded with one sequence
+		if m.CheckCapabilities(CapabilityCompletion) != nil || checkMllamaModelFamily(m) {
+			numParallel = 1
+		}
+
+		opts.NumCtx = max(opts.NumCtx, 4) * numParallel
+
+		pmodel := planModel{
+			name:              pm.Model,
+			requestsPerMinute: pm.RequestsPerMinute,
+			requestDuration:   planRequestDuration,
+			keepAlive:         envconfig.KeepAlive(),
+			numParallel:       numParallel,
+		}
+
+		if pm.RequestDuration != nil {
+			pmodel.requestDuration = pm.RequestDuration.Duration
+		}
+
+		if pm.KeepAlive != nil {
+			pmodel.keepAlive = pm.KeepAlive.Duration
+		}
+
+		if len(gpus) == 0 {
+			estimate := llm.EstimateGPULayers(s.sched.getCpuFn(), ggml, m.ProjectorPaths, opts)
+			pmodel.size, pmodel.vram, pmodel.processor = estimate.TotalSize, estimate.TotalSize, "cpu"
+		} else {
+			estimate := llm.EstimateGPULayers(gpus, ggml, m.ProjectorPaths, opts)
+			pmodel.size, pmodel.vram = estimate.TotalSize, estimate.VRAMSize
+			switch {
+			case estimate.VRAMSize == 0:
+				pmodel.processor = "cpu"
+			case estimate.VRAMSize < estimate.TotalSize:
+				pmodel.processor = "gpu/cpu"
+			default:
+				pmodel.processor = "gpu"
+			}
+		}
+
+		pmodel.loadDuration = time.Duration(float64(pmodel.size) / planLoadRate * float64(time.Second))
+		models[i] = pmodel
+	}
+
+	resp, err := simulatePlan(models, capacity, maxLoaded, duration)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestSimulatePlan(t *testing.T) {
+	model := func(name string, vram uint64, rpm float64) planModel {
+		return planModel{
+			name:              name,
+			size:              vram,
+			vram:              vram,
+			processor:         "gpu",
+			requestsPerMinute: rpm,
+			requestDuration:   10 * time.Second,
+			loadDuration:      time.Second,
+			keepAlive:         5 * time.Minute,
+			numParallel:       1,
+		}
+	}
+
+	t.Run("fits", func(t *testing.T) {
+		got, err := simulatePlan([]planModel{model("a", 4, 1), model("b", 4, 1)}, 10, 3, 10*time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := api.PlanResponse{
+			VRAM:     10,
+			PeakVRAM: 8,
+			Models: []api.PlanModelResponse{
+				{Model: "a", Size: 4, SizeVRAM: 4, Processor: "gpu", Requests: 10, Loads: 1, QueueTime: 100 * time.Millisecond, MaxQueueTime: time.Second},
+				{Model: "b", Size: 4, SizeVRAM: 4, Processor: "gpu", Requests: 10, Loads: 1, QueueTime: 100 * time.Millisecond, MaxQueueTime: time.Second},
+			},
+		}
+		if diff := cmp.Diff(want, got, cmp.FilterPath(func(p cmp.Path) bool { return p.String() == "VRAMUtilization" }, cmp.Ignore())); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+
+		// a is loaded for 10m and b for 9m30s of the 10m
+		if want := (4*10.0 + 4*9.5) / 10 / 10; got.VRAMUtilization < want-0.001 || got.VRAMUtilization > want+0.001 {
+			t.Errorf("expected utilization %f, got %f", want, got.VRAMUtilization)
+		}
+	})
+
+	t.Run("evicts", func(t *testing.T) {
+		got, err := simulatePlan([]planModel{model("a", 6, 1), model("b", 6, 1)}, 10, 3, 10*time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, m := range got.Models {
+			if m.Loads != 10 || m.MaxQueueTime != time.Second {
+				t.Errorf("expected %s to be loaded for each request, got %+v", m.Model, m)
+			}
+		}
+
+		if got.Models[0].Evictions != 10 || got.Models[1].Evictions != 9 {
+			t.Errorf("expected the models to evict each other, got %+v", got.Models)
+		}
+
+		if got.PeakVRAM != 6 {
+			t.Errorf("expected peak of 6, got %d", got.PeakVRAM)
+		}
+	})
+
+	t.Run("max loaded", func(t *testing.T) {
+		got, err := simulatePlan([]planModel{model("a", 1, 1), model("b", 1, 1)}, 10, 1, 10*time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got.Models[0].Loads != 10 || got.Models[1].Loads != 10 {
+			t.Errorf("expected one model to be loaded at a time, got %+v", got.Models)
+		}
+	})
+
+	t.Run("waits for busy models", func(t *testing.T) {
+		a := model("a", 6, 1)
+		a.requestDuration = 45 * time.Second
+		got, err := simulatePlan([]planModel{a, model("b", 6, 1)}, 10, 3, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// b arrives at 30s and waits for a to finish at 46s, then loads
+		if want := 17 * time.Second; got.Models[1].MaxQueueTime != want {
+			t.Errorf("expected b to wait %s, got %s", want, got.Models[1].MaxQueueTime)
+		}
+	})
+
+	t.Run("parallel", func(t *testing.T) {
+		a := model("a", 4, 6)
+		a.requestDuration = 20 * time.Second
+		got, err := simulatePlan([]planModel{a}, 10, 3, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// requests arrive every 10s and take 20s on one sequence, so the
+		// last, arriving at 50s, starts at 101s
+		if got.Models[0].MaxQueueTime != 51*time.Second {
+			t.Errorf("expected requests to queue, got %+v", got.Models[0])
+		}
+
+		a.numParallel = 2
+		got, err = simulatePlan([]planModel{a}, 10, 3, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got.Models[0].MaxQueueTime != time.Second {
+			t.Errorf("expected only the load to be waited for, got %+v", got.Models[0])
+		}
+	})
+
+	t.Run("keep alive", func(t *testing.T) {
+		a := model("a", 4, 1)
+		a.keepAlive = 0
+		got, err := simulatePlan([]planModel{a}, 10, 3, 10*time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got.Models[0].Loads != 10 || got.Models[0].Evictions != 0 {
+			t.Errorf("expected a to be unloaded after each request, got %+v", got.Models[0])
+		}
+
+		a.keepAlive = -1
+		got, err = simulatePlan([]planModel{a}, 10, 3, 10*time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got.Models[0].Loads != 1 {
+			t.Errorf("expected a to stay loaded, got %+v", got.Models[0])
+		}
+	})
+
+	t.Run("too many requests", func(t *testing.T) {
+		if _, err := simulatePlan([]planModel{model("a", 4, 1e6)}, 10, 3, time.Hour); err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
+
+func TestPlanHandlerValidation(t *testing.T) {
+	var s Server
+	cases := []struct {
+		name string
+		req  api.PlanRequest
+		code int
+	}{
+		{"no models", api.PlanRequest{}, http.StatusBadRequest},
+		{"zero duration", api.PlanRequest{Models: []api.PlanModel{{Model: "a"}}, Duration: &api.Duration{}}, http.StatusBadRequest},
+		{"missing model", api.PlanRequest{Models: []api.PlanModel{{Model: "missing", RequestsPerMinute: 1}}}, http.StatusNotFound},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			s.sched = &Scheduler{getGpuFn: getGpuFn, getCpuFn: getCpuFn}
+			w := createRequest(t, s.PlanHandler, tt.req)
+			if w.Code != tt.code {
+				t.Errorf("expected status %d, got %d: %s", tt.code, w.Code, w.Body.String())
+			}
+		})
+	}
+}
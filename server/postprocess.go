@@ -0,0 +1,260 @@
+package server
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// streamFilter transforms a response as it's streamed. Write returns the
+// output for a chunk and may hold back text that depends on the chunks that
+// follow. Flush returns the held back text once the response is complete.
+type streamFilter interface {
+	Write(s string) string
+	Flush() string
+}
+
+// postProcessors are the filters that can be applied to responses with the
+// post_process parameter
+var postProcessors = map[string]func(stop []string) streamFilter{
+	"strip_fences":        func([]string) streamFilter { return &fenceFilter{} },
+	"collapse_whitespace": func([]string) streamFilter { return &whitespaceFilter{} },
+	"strip_artifacts":     func(stop []string) streamFilter { return newArtifactFilter(stop) },
+	"normalize_unicode":   func([]string) streamFilter { return &unicodeFilter{} },
+}
+
+// postProcessor applies filters in order
+type postProcessor []streamFilter
+
+// newPostProcessor returns the filters with the given names. stop are the
+// request's stop sequences, which are removed with other artifacts.
+func newPostProcessor(names, stop []string) (postProcessor, error) {
+	var p postProcessor
+	for _, name := range names {
+		fn, ok := postProcessors[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown post_process filter %q", name)
+		}
+
+		p = append(p, fn(stop))
+	}
+
+	return p, nil
+}
+
+func (p postProcessor) Write(s string) string {
+	for _, f := range p {
+		s = f.Write(s)
+	}
+
+	return s
+}
+
+func (p postProcessor) Flush() string {
+	var s string
+	for _, f := range p {
+		s = f.Write(s) + f.Flush()
+	}
+
+	return s
+}
+
+// fenceFilter removes a markdown code fence around the whole response, e.g.
+// a JSON object in a ```json block
+type fenceFilter struct {
+	buf     string
+	started bool
+	fenced  bool
+}
+
+var closingFence = regexp.MustCompile("\n\\s*`{0,3}\\s*$")
+
+func (f *fenceFilter) Write(s string) string {
+	f.buf += s
+	if !f.started {
+		trimmed := strings.TrimLeftFunc(f.buf, unicode.IsSpace)
+		switch {
+		case strings.HasPrefix(trimmed, "```"):
+			i := strings.IndexByte(trimmed, '\n')
+			if i < 0 {
+				// wait for the rest of the opening fence
+				return ""
+			}
+
+			f.buf = trimmed[i+1:]
+			f.started, f.fenced = true, true
+		case strings.HasPrefix("```", trimmed):
+			return ""
+		default:
+			f.started = true
+		}
+	}
+
+	if !f.fenced {
+		s, f.buf = f.buf, ""
+		return s
+	}
+
+	// hold back what could be the closing fence
+	loc := closingFence.FindStringIndex(f.buf)
+	if loc == nil {
+		s, f.buf = f.buf, ""
+		return s
+	}
+
+	s, f.buf = f.buf[:loc[0]], f.buf[loc[0]:]
+	return s
+}
+
+func (f *fenceFilter) Flush() string {
+	s := f.buf
+	f.buf = ""
+	if f.fenced && strings.TrimSpace(s) == "```" {
+		return ""
+	}
+
+	return s
+}
+
+// whitespaceFilter collapses runs of spaces into one space and runs of blank
+// lines into one blank line, and trims whitespace at the start and end of
+// lines and the response
+type whitespaceFilter struct {
+	started  bool
+	space    bool
+	newlines int
+}
+
+func (f *whitespaceFilter) Write(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '\n':
+			f.newlines++
+			f.space = false
+		case unicode.IsSpace(r):
+			f.space = f.newlines == 0
+		default:
+			if f.started {
+				if f.newlines > 0 {
+					b.WriteString(strings.Repeat("\n", min(f.newlines, 2)))
+				} else if f.space {
+					b.WriteByte(' ')
+				}
+			}
+
+			f.started, f.space, f.newlines = true, false, 0
+			b.WriteRune(r)
+		}
+	}
+
+	return b.String()
+}
+
+func (f *whitespaceFilter) Flush() string {
+	return ""
+}
+
+// defaultArtifacts are special tokens models commonly leak into responses as
+// text
+var defaultArtifacts = []string{
+	"<|im_end|>",
+	"<|im_start|>",
+	"<|eot_id|>",
+	"<|end_of_text|>",
+	"<|endoftext|>",
+	"<|end|>",
+	"<end_of_turn>",
+	"</s>",
+}
+
+// artifactFilter removes special tokens and stop sequences from responses
+type artifactFilter struct {
+	artifacts []string
+	buf       string
+}
+
+func newArtifactFilter(stop []string) *artifactFilter {
+	f := artifactFilter{artifacts: defaultArtifacts}
+	for _, s := range stop {
+		if s != "" {
+			f.artifacts = append(f.artifacts, s)
+		}
+	}
+
+	return &f
+}
+
+func (f *artifactFilter) Write(s string) string {
+	f.buf += s
+	for _, a := range f.artifacts {
+		f.buf = strings.ReplaceAll(f.buf, a, "")
+	}
+
+	// hold back the longest suffix that could start an artifact
+	var hold int
+	for _, a := range f.artifacts {
+		for n := min(len(a)-1, len(f.buf)); n > hold; n-- {
+			if strings.HasSuffix(f.buf, a[:n]) {
+				hold = n
+				break
+			}
+		}
+	}
+
+	s, f.buf = f.buf[:len(f.buf)-hold], f.buf[len(f.buf)-hold:]
+	return s
+}
+
+func (f *artifactFilter) Flush() string {
+	s := f.buf
+	f.buf = ""
+	return s
+}
+
+// unicodeFilter normalizes responses to Unicode NFC, so equivalent text is
+// always encoded the same way
+type unicodeFilter struct {
+	buf []byte
+}
+
+func (f *unicodeFilter) Write(s string) string {
+	f.buf = append(f.buf, s...)
+
+	// characters after the last boundary may combine with what follows
+	i := norm.NFC.LastBoundary(f.buf)
+	if i <= 0 {
+		return ""
+	}
+
+	s = norm.NFC.String(string(f.buf[:i]))
+	f.buf = append(f.buf[:0], f.buf[i:]...)
+	return s
+}
+
+func (f *unicodeFilter) Flush() string {
+	s := norm.NFC.String(string(f.buf))
+	f.buf = nil
+	return s
+}
+
+// trimFilter removes whitespace at the end of the response by holding it back
+// until more text follows
+type trimFilter struct {
+	buf string
+}
+
+func (f *trimFilter) Write(s string) string {
+	f.buf += s
+	trimmed := strings.TrimRightFunc(f.buf, unicode.IsSpace)
+	f.buf = f.buf[len(trimmed):]
+	return trimmed
+}
+
+func (f *trimFilter) Flush() string {
+	f.buf = ""
+	return ""
+}
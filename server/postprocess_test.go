@@ -0,0 +1,126 @@
+package server
+
+import (
+	"testing"
+)
+
+func TestPostProcess(t *testing.T) {
+	cases := []struct {
+		name    string
+		filters []string
+		stop    []string
+		input   string
+		want    string
+	}{
+		{
+			name:    "fenced json",
+			filters: []string{"strip_fences"},
+			input:   "```json\n{\"a\": 1}\n```",
+			want:    "{\"a\": 1}",
+		},
+		{
+			name:    "fenced with whitespace",
+			filters: []string{"strip_fences"},
+			input:   "\n  ```\n{\"a\": 1}\n```\n",
+			want:    "{\"a\": 1}",
+		},
+		{
+			name:    "fence in the middle",
+			filters: []string{"strip_fences"},
+			input:   "Here you go:\n```\nx\n```",
+			want:    "Here you go:\n```\nx\n```",
+		},
+		{
+			name:    "text after fence",
+			filters: []string{"strip_fences"},
+			input:   "```\nx\n```\nDone",
+			want:    "x\n```\nDone",
+		},
+		{
+			name:    "short",
+			filters: []string{"strip_fences"},
+			input:   "`x`",
+			want:    "`x`",
+		},
+		{
+			name:    "collapse whitespace",
+			filters: []string{"collapse_whitespace"},
+			input:   "  Hello,   world! \n\n\n\n  How\tare  you?  \n",
+			want:    "Hello, world!\n\nHow are you?",
+		},
+		{
+			name:    "artifacts",
+			filters: []string{"strip_artifacts"},
+			stop:    []string{"User:"},
+			input:   "Hello<|im_end|> there</s> User:<|eot_id|>",
+			want:    "Hello there ",
+		},
+		{
+			name:    "artifact prefix",
+			filters: []string{"strip_artifacts"},
+			input:   "a < b <|",
+			want:    "a < b <|",
+		},
+		{
+			name:    "normalize unicode",
+			filters: []string{"normalize_unicode"},
+			input:   "Café crème",
+			want:    "Café crème",
+		},
+		{
+			name:    "chained",
+			filters: []string{"strip_artifacts", "strip_fences", "collapse_whitespace"},
+			input:   "```json\n{\"a\":   1}\n```<|im_end|>",
+			want:    "{\"a\": 1}",
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			// responses must be the same however they're split into chunks
+			for _, size := range []int{len(tt.input), 1, 2, 3} {
+				p, err := newPostProcessor(tt.filters, tt.stop)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				var got string
+				input := []byte(tt.input)
+				for len(input) > 0 {
+					n := min(size, len(input))
+					got += p.Write(string(input[:n]))
+					input = input[n:]
+				}
+				got += p.Flush()
+
+				if got != tt.want {
+					t.Errorf("chunk size %d: got %q, want %q", size, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestPostProcessUnknown(t *testing.T) {
+	if _, err := newPostProcessor([]string{"strip_fences", "uppercase"}, nil); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestTrimFilter(t *testing.T) {
+	input := "Hello  \n world \n\n"
+	for _, size := range []int{len(input), 1, 2, 3} {
+		var f trimFilter
+		var got string
+		for s := input; len(s) > 0; {
+			n := min(size, len(s))
+			got += f.Write(s[:n])
+			s = s[n:]
+		}
+		got += f.Flush()
+
+		if want := "Hello  \n world"; got != want {
+			t.Errorf("chunk size %d: got %q, want %q", size, got, want)
+		}
+	}
+}
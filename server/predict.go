@@ -0,0 +1,253 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/ollama/ollama/envconfig"
+)
+
+const (
+	// usageDays is the number of days of usage kept per hour of the day
+	usageDays = 14
+	// usageMinDays is the number of days in the last week a model has to be
+	// used in an hour of the day to be preloaded in that hour
+	usageMinDays = 3
+	// usageMinNext is the number of times a model has to follow another to
+	// be preloaded after it
+	usageMinNext = 3
+
+	// predictInterval is how often predicted models are preloaded and the
+	// usage history saved
+	predictInterval = 5 * time.Minute
+	// predictLead is how far ahead of an hour its models are preloaded
+	predictLead = 10 * time.Minute
+)
+
+// usageHistory records when models are used and which models follow each
+// other, to load models before they're requested
+type usageHistory struct {
+	mu    sync.Mutex
+	dirty bool
+	last  string
+
+	// Hours are the days, as "2006-01-02", each model was used in each hour
+	// of the day, oldest first
+	Hours map[string][24][]string `json:"hours"`
+	// Next counts the models requested after each model
+	Next map[string]map[string]int `json:"next"`
+}
+
+func newUsageHistory() *usageHistory {
+	return &usageHistory{Hours: make(map[string][24][]string), Next: make(map[string]map[string]int)}
+}
+
+func usageHistoryPath() string {
+	return filepath.Join(envconfig.Models(), "usage.json")
+}
+
+// readUsageHistory reads the usage history, starting an empty one if there
+// isn't one yet
+func readUsageHistory() (*usageHistory, error) {
+	h := newUsageHistory()
+	bts, err := os.ReadFile(usageHistoryPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return h, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(bts, h); err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
+
+// save writes the usage history if it changed since it was last saved
+func (h *usageHistory) save() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.dirty {
+		return nil
+	}
+
+	bts, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+
+	temp, err := os.CreateTemp(envconfig.Models(), "usage-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+
+	if _, err := temp.Write(bts); err != nil {
+		temp.Close()
+		return err
+	}
+
+	if err := temp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(temp.Name(), usageHistoryPath()); err != nil {
+		return err
+	}
+
+	h.dirty = false
+	return nil
+}
+
+// record records a request for model at t
+func (h *usageHistory) record(model string, t time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	hours := h.Hours[model]
+	day := t.Format(time.DateOnly)
+	if days := hours[t.Hour()]; len(days) == 0 || days[len(days)-1] != day {
+		days = append(days, day)
+		hours[t.Hour()] = days[max(len(days)-usageDays, 0):]
+		h.Hours[model] = hours
+		h.dirty = true
+	}
+
+	if h.last != "" && h.last != model {
+		if h.Next[h.last] == nil {
+			h.Next[h.last] = make(map[string]int)
+		}
+
+		h.Next[h.last][model]++
+		h.dirty = true
+	}
+
+	h.last = model
+}
+
+// hour returns the models used in the hour of the day of t on enough of the
+// previous week's days to be expected then, most used first
+func (h *usageHistory) hour(t time.Time) []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	since := t.AddDate(0, 0, -7).Format(time.DateOnly)
+	days := make(map[string]int)
+	for model, hours := range h.Hours {
+		for _, day := range hours[t.Hour()] {
+			if day >= since {
+				days[model]++
+			}
+		}
+	}
+
+	var models []string
+	for model, n := range days {
+		if n >= usageMinDays {
+			models = append(models, model)
+		}
+	}
+
+	slices.SortFunc(models, func(a, b string) int {
+		return cmp.Or(cmp.Compare(days[b], days[a]), cmp.Compare(a, b))
+	})
+
+	return models
+}
+
+// next returns the model usually requested after model, or "" if no model
+// follows it in at least half of the requests after it
+func (h *usageHistory) next(model string) string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var next string
+	var total, most int
+	for m, n := range h.Next[model] {
+		total += n
+		if n > most || (n == most && m < next) {
+			next, most = m, n
+		}
+	}
+
+	if most < usageMinNext || most*2 < total {
+		return ""
+	}
+
+	return next
+}
+
+// predictivePreload loads models expected to be used soon, every
+// predictInterval until ctx is done
+func (s *Server) predictivePreload(ctx context.Context) {
+	ticker := time.NewTicker(predictInterval)
+	defer ticker.Stop()
+
+	// models are preloaded once per hour they're expected in
+	preloaded := make(map[string]string)
+	for {
+		t := time.Now().Add(predictLead)
+		hour := t.Format("2006-01-02T15")
+		for _, model := range s.usage.hour(t) {
+			if preloaded[model] != hour {
+				preloaded[model] = hour
+				s.preloadPredicted(ctx, model)
+			}
+		}
+
+		if err := s.usage.save(); err != nil {
+			slog.Warn("failed to save usage history", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// preloadPredicted loads model if it fits without unloading other models
+func (s *Server) preloadPredicted(ctx context.Context, name string) {
+	m, err := GetModel(name)
+	if err != nil {
+		slog.Debug("predicted model unavailable", "model", name, "error", err)
+		return
+	}
+
+	if m.Config.Remote != nil {
+		return
+	}
+
+	if err := checkLicense(m); err != nil {
+		return
+	}
+
+	opts, err := modelOptions(m, nil)
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	// cancelling releases the runner, which stays loaded for its keep alive
+	defer cancel()
+
+	runnerCh, errCh := s.sched.GetIdleRunner(ctx, m, opts, nil)
+	select {
+	case <-runnerCh:
+		slog.Info("preloaded predicted model", "model", name)
+	case err := <-errCh:
+		slog.Debug("not preloading predicted model", "model", name, "error", err)
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestUsageHistoryHour(t *testing.T) {
+	h := newUsageHistory()
+	morning := time.Date(2024, 6, 3, 9, 15, 0, 0, time.Local)
+	for day := range 4 {
+		h.record("summarizer", morning.AddDate(0, 0, day))
+		h.record("summarizer", morning.AddDate(0, 0, day).Add(time.Minute))
+		if day%2 == 0 {
+			h.record("coder", morning.AddDate(0, 0, day))
+		}
+	}
+
+	// the hour of the day is the same across the days
+	if got := h.hour(morning.AddDate(0, 0, 4)); !cmp.Equal(got, []string{"summarizer"}) {
+		t.Errorf("expected summarizer, got %v", got)
+	}
+
+	if days := h.Hours["summarizer"][9]; len(days) != 4 {
+		t.Errorf("expected each day to be recorded once, got %v", days)
+	}
+
+	if got := h.hour(morning.Add(time.Hour)); len(got) != 0 {
+		t.Errorf("expected no models in another hour, got %v", got)
+	}
+
+	// usage more than a week old doesn't count
+	if got := h.hour(morning.AddDate(0, 0, 10)); len(got) != 0 {
+		t.Errorf("expected no models after a week, got %v", got)
+	}
+
+	for day := range 2 * usageDays {
+		h.record("summarizer", morning.AddDate(0, 1, day))
+	}
+
+	if days := h.Hours["summarizer"][9]; len(days) != usageDays {
+		t.Errorf("expected %d days to be kept, got %d", usageDays, len(days))
+	}
+}
+
+func TestUsageHistoryNext(t *testing.T) {
+	h := newUsageHistory()
+	now := time.Now()
+	for range usageMinNext - 1 {
+		h.record("transcriber", now)
+		h.record("summarizer", now)
+	}
+
+	if got := h.next("transcriber"); got != "" {
+		t.Errorf("expected no model before %d requests, got %q", usageMinNext, got)
+	}
+
+	h.record("transcriber", now)
+	h.record("summarizer", now)
+	if got := h.next("transcriber"); got != "summarizer" {
+		t.Errorf("expected summarizer, got %q", got)
+	}
+
+	// a model that follows less than half of the time isn't predicted
+	for range usageMinNext + 1 {
+		h.record("transcriber", now)
+		h.record("coder", now)
+		h.record("transcriber", now)
+		h.record("translator", now)
+	}
+
+	if got := h.next("transcriber"); got != "" {
+		t.Errorf("expected no model, got %q", got)
+	}
+}
+
+func TestUsageHistorySave(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	h, err := readUsageHistory()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	h.record("a", now)
+	h.record("b", now)
+	if err := h.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	read, err := readUsageHistory()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(h.Hours, read.Hours); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+
+	if diff := cmp.Diff(h.Next, read.Next); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
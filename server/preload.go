@@ -0,0 +1,145 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/types/model"
+)
+
+// preloadManifest lists the models a server pulls, and optionally loads,
+// before it reports ready
+type preloadManifest struct {
+	Models []preloadModel `json:"models"`
+}
+
+type preloadModel struct {
+	Model string `json:"model"`
+	// Insecure allows pulling from a registry over HTTP
+	Insecure bool `json:"insecure,omitempty"`
+	// Load loads the model into memory after pulling it
+	Load      bool           `json:"load,omitempty"`
+	KeepAlive *api.Duration  `json:"keep_alive,omitempty"`
+	Options   map[string]any `json:"options,omitempty"`
+}
+
+// readPreloadManifest reads the manifest configured with OLLAMA_PRELOAD. It
+// returns nil if no manifest is configured.
+func readPreloadManifest() (*preloadManifest, error) {
+	path := envconfig.Preload()
+	if path == "" {
+		return nil, nil
+	}
+
+	bts, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("preload manifest: %w", err)
+	}
+
+	var m preloadManifest
+	if err := json.Unmarshal(bts, &m); err != nil {
+		return nil, fmt.Errorf("preload manifest %s: %w", path, err)
+	}
+
+	for _, pm := range m.Models {
+		if !model.ParseName(pm.Model).IsValid() {
+			return nil, fmt.Errorf("preload manifest %s: invalid model name %q", path, pm.Model)
+		}
+	}
+
+	return &m, nil
+}
+
+// preloadState tracks the progress of the preload manifest for the readiness
+// endpoint
+type preloadState struct {
+	mu     sync.Mutex
+	done   bool
+	errors map[string]string
+}
+
+// preload pulls models missing from the local store and loads the models
+// marked to be loaded, in the order they're listed. Failures are recorded and
+// keep the server from reporting ready.
+func (s *Server) preload(ctx context.Context, m *preloadManifest) {
+	defer func() {
+		s.preloadState.mu.Lock()
+		s.preloadState.done = true
+		s.preloadState.mu.Unlock()
+	}()
+
+	for _, pm := range m.Models {
+		if err := s.preloadModel(ctx, pm); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+
+			slog.Error("failed to preload model", "model", pm.Model, "error", err)
+			s.preloadState.mu.Lock()
+			if s.preloadState.errors == nil {
+				s.preloadState.errors = make(map[string]string)
+			}
+			s.preloadState.errors[pm.Model] = err.Error()
+			s.preloadState.mu.Unlock()
+		}
+	}
+
+	slog.Info("preload complete", "models", len(m.Models))
+}
+
+func (s *Server) preloadModel(ctx context.Context, pm preloadModel) error {
+	name := model.ParseName(pm.Model)
+	if _, err := ParseNamedManifest(name); errors.Is(err, os.ErrNotExist) {
+		slog.Info("pulling model for preload", "model", pm.Model)
+		fn := func(api.ProgressResponse) {}
+		if err := PullModel(ctx, name.DisplayShortest(), &registryOptions{Insecure: pm.Insecure}, fn); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	if !pm.Load {
+		return nil
+	}
+
+	slog.Info("loading model for preload", "model", pm.Model)
+	ctx, cancel := context.WithCancel(ctx)
+	// cancelling releases the runner, which stays loaded for its keep alive
+	defer cancel()
+
+	_, _, _, err := s.scheduleRunner(ctx, name.String(), nil, pm.Options, pm.KeepAlive)
+	return err
+}
+
+// ReadyHandler reports whether the models in the preload manifest have been
+// pulled and loaded. Unlike "/", which responds as soon as the server is
+// listening, it's suitable for readiness probes.
+func (s *Server) ReadyHandler(c *gin.Context) {
+	if s.preloadState == nil {
+		c.JSON(http.StatusOK, gin.H{"status": "ready"})
+		return
+	}
+
+	s.preloadState.mu.Lock()
+	defer s.preloadState.mu.Unlock()
+
+	switch {
+	case !s.preloadState.done:
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "preloading"})
+	case len(s.preloadState.errors) > 0:
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "failed", "errors": s.preloadState.errors})
+	default:
+		c.JSON(http.StatusOK, gin.H{"status": "ready"})
+	}
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ollama/ollama/types/model"
+)
+
+func TestReadPreloadManifest(t *testing.T) {
+	dir := t.TempDir()
+
+	t.Setenv("OLLAMA_PRELOAD", "")
+	m, err := readPreloadManifest()
+	require.NoError(t, err)
+	require.Nil(t, m)
+
+	path := filepath.Join(dir, "preload.json")
+	t.Setenv("OLLAMA_PRELOAD", path)
+	_, err = readPreloadManifest()
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"models": [{"model": "llama3.2", "load": true, "keep_alive": "1h", "options": {"num_ctx": 8192}}, {"model": "nomic-embed-text"}]}`), 0o644))
+	m, err = readPreloadManifest()
+	require.NoError(t, err)
+	require.Len(t, m.Models, 2)
+	require.True(t, m.Models[0].Load)
+	require.Equal(t, time.Hour, m.Models[0].KeepAlive.Duration)
+	require.InDelta(t, 8192, m.Models[0].Options["num_ctx"], 0)
+	require.False(t, m.Models[1].Load)
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"models": [{"model": "bad model"}]}`), 0o644))
+	_, err = readPreloadManifest()
+	require.ErrorContains(t, err, "invalid model name")
+}
+
+func TestReadyHandler(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	var s Server
+	w := createRequest(t, s.ReadyHandler, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	s.preloadState = &preloadState{}
+	w = createRequest(t, s.ReadyHandler, nil)
+	require.Equal(t, http.StatusServiceUnavailable, w.Code)
+	require.JSONEq(t, `{"status": "preloading"}`, w.Body.String())
+
+	// models already in the local store aren't pulled again
+	require.NoError(t, WriteManifest(model.ParseName("present"), Layer{}, nil))
+	s.preload(context.Background(), &preloadManifest{Models: []preloadModel{{Model: "present"}}})
+	w = createRequest(t, s.ReadyHandler, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	s.preloadState = &preloadState{}
+	s.preload(context.Background(), &preloadManifest{Models: []preloadModel{{Model: "127.0.0.1:1/library/missing", Insecure: true}}})
+	w = createRequest(t, s.ReadyHandler, nil)
+	require.Equal(t, http.StatusServiceUnavailable, w.Code)
+	require.Contains(t, w.Body.String(), `"status":"failed"`)
+}
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ollama/ollama/envconfig"
+)
+
+// memoryPressureInterval is how often available system memory is checked
+const memoryPressureInterval = 5 * time.Second
+
+// minShrinkContext is the smallest context per sequence an idle model is
+// shrunk to under memory pressure
+const minShrinkContext = 2048
+
+// memoryPressure are the percentages of system memory available below which
+// idle models are reloaded with half their context (shrink) or unloaded
+// (evict). A zero threshold disables the action.
+type memoryPressure struct {
+	shrink int
+	evict  int
+}
+
+// parseMemoryPressure parses comma separated thresholds, e.g.
+// "shrink=20,evict=10". An empty string or "off" disables both.
+func parseMemoryPressure(s string) (memoryPressure, error) {
+	s = strings.TrimSpace(s)
+	switch s {
+	case "", "off":
+		return memoryPressure{}, nil
+	}
+
+	var p memoryPressure
+	for _, field := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok {
+			return memoryPressure{}, fmt.Errorf("invalid memory pressure threshold %q", field)
+		}
+
+		n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(value), "%"))
+		if err != nil || n < 0 || n > 100 {
+			return memoryPressure{}, fmt.Errorf("invalid memory pressure threshold %q: must be a percentage", field)
+		}
+
+		switch strings.TrimSpace(key) {
+		case "shrink":
+			p.shrink = n
+		case "evict":
+			p.evict = n
+		default:
+			return memoryPressure{}, fmt.Errorf("invalid memory pressure threshold %q: unknown action %q", field, key)
+		}
+	}
+
+	return p, nil
+}
+
+// monitorMemoryPressure periodically checks available system memory and
+// shrinks or evicts idle models when it drops below the configured thresholds
+func (s *Scheduler) monitorMemoryPressure(ctx context.Context) {
+	thresholds, err := parseMemoryPressure(envconfig.MemoryPressure())
+	if err != nil {
+		slog.Warn("ignoring memory pressure thresholds", "error", err)
+		return
+	}
+
+	if thresholds == (memoryPressure{}) || s.availableMemoryFn == nil {
+		return
+	}
+
+	if _, err := s.availableMemoryFn(); errors.Is(err, errors.ErrUnsupported) {
+		return
+	}
+
+	ticker := time.NewTicker(memoryPressureInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.relieveMemoryPressure(ctx, thresholds)
+		}
+	}
+}
+
+// relieveMemoryPressure shrinks or evicts one idle model if available system
+// memory is below the thresholds. Once memory recovers, shrunk models are
+// reloaded with their full context by their next request.
+func (s *Scheduler) relieveMemoryPressure(ctx context.Context, thresholds memoryPressure) {
+	available, err := s.availableMemoryFn()
+	if err != nil {
+		slog.Debug("unable to check memory pressure", "error", err)
+		return
+	}
+
+	switch {
+	case available < thresholds.evict:
+		if runner := s.findIdleRunner(func(a, b *runnerRef) int { return b.expiresAt.Compare(a.expiresAt) }); runner != nil {
+			slog.Info("evicting idle model under memory pressure", "model", runner.modelPath, "available", fmt.Sprintf("%d%%", available))
+			s.expireRunner(runner.model)
+		}
+	case available < thresholds.shrink:
+		runner := s.findIdleRunner(func(a, b *runnerRef) int { return a.numCtx - b.numCtx })
+		if runner == nil || runner.numCtx/2 < minShrinkContext {
+			return
+		}
+
+		runner.refMu.Lock()
+		displaced := runner.displace()
+		runner.stale = true
+		runner.refMu.Unlock()
+
+		slog.Info("shrinking idle model context under memory pressure", "model", runner.modelPath, "num_ctx", runner.numCtx/2, "available", fmt.Sprintf("%d%%", available))
+		s.setContextLimit(runner.modelPath, runner.numCtx/2)
+		s.expireRunner(runner.model)
+		go s.reloadDisplaced(ctx, []displacedRunner{displaced})
+	default:
+		s.liftContextLimits()
+	}
+}
+
+// liftContextLimits removes the limits on the context of models and marks
+// the runners loaded with a limited context to be replaced by the next
+// request for their model
+func (s *Scheduler) liftContextLimits() {
+	s.contextLimitsMu.Lock()
+	lifted := len(s.contextLimits) > 0
+	clear(s.contextLimits)
+	s.contextLimitsMu.Unlock()
+
+	if !lifted {
+		return
+	}
+
+	s.loadedMu.Lock()
+	defer s.loadedMu.Unlock()
+	for _, r := range s.loaded {
+		r.refMu.Lock()
+		if r.limited {
+			slog.Info("restoring model context after memory pressure", "model", r.modelPath)
+			r.stale = true
+		}
+		r.refMu.Unlock()
+	}
+}
+
+// findIdleRunner returns the loaded runner without active requests that's
+// greatest according to cmp, or nil if all runners are busy
+func (s *Scheduler) findIdleRunner(cmp func(a, b *runnerRef) int) *runnerRef {
+	s.loadedMu.Lock()
+	defer s.loadedMu.Unlock()
+
+	var idle []*runnerRef
+	for _, r := range s.loaded {
+		r.refMu.Lock()
+		if r.refCount == 0 && !r.loading && !r.stale && r.Options != nil {
+			idle = append(idle, r)
+		}
+		r.refMu.Unlock()
+	}
+
+	if len(idle) == 0 {
+		return nil
+	}
+
+	return slices.MaxFunc(idle, cmp)
+}
+
+// contextLimit returns the largest context per sequence a model is loaded
+// with, or 0 if it isn't limited
+func (s *Scheduler) contextLimit(modelPath string) int {
+	s.contextLimitsMu.Lock()
+	defer s.contextLimitsMu.Unlock()
+	return s.contextLimits[modelPath]
+}
+
+func (s *Scheduler) setContextLimit(modelPath string, numCtx int) {
+	s.contextLimitsMu.Lock()
+	defer s.contextLimitsMu.Unlock()
+	if s.contextLimits == nil {
+		s.contextLimits = make(map[string]int)
+	}
+
+	s.contextLimits[modelPath] = numCtx
+}
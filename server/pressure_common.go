@@ -0,0 +1,11 @@
+//go:build !darwin
+
+package server
+
+import "errors"
+
+// availableMemory is only implemented on macOS, where models share unified
+// memory with the rest of the system
+func availableMemory() (int, error) {
+	return 0, errors.ErrUnsupported
+}
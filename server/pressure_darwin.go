@@ -0,0 +1,14 @@
+package server
+
+import "golang.org/x/sys/unix"
+
+// availableMemory returns the percentage of memory the kernel considers
+// available before it starts compressing and swapping
+func availableMemory() (int, error) {
+	level, err := unix.SysctlUint32("kern.memorystatus_level")
+	if err != nil {
+		return 0, err
+	}
+
+	return int(level), nil
+}
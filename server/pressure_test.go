@@ -0,0 +1,140 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/discover"
+	"github.com/ollama/ollama/llm"
+)
+
+func TestParseMemoryPressure(t *testing.T) {
+	cases := []struct {
+		in   string
+		want memoryPressure
+		err  bool
+	}{
+		{in: "", want: memoryPressure{}},
+		{in: "off", want: memoryPressure{}},
+		{in: "shrink=30, evict=15%", want: memoryPressure{shrink: 30, evict: 15}},
+		{in: "evict=5", want: memoryPressure{evict: 5}},
+		{in: "evict", err: true},
+		{in: "evict=101", err: true},
+		{in: "swap=10", err: true},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseMemoryPressure(tt.in)
+			if tt.err {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestRelieveMemoryPressure(t *testing.T) {
+	ctx, done := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer done()
+	s := InitScheduler(ctx)
+
+	available := 50
+	s.availableMemoryFn = func() (int, error) { return available, nil }
+
+	newRunner := func(path string, numCtx int, expiresAt time.Time) *runnerRef {
+		opts := api.DefaultOptions()
+		opts.NumCtx = numCtx
+		r := &runnerRef{
+			model:           &Model{ModelPath: path},
+			modelPath:       path,
+			llama:           &mockLlm{},
+			Options:         &opts,
+			numParallel:     1,
+			numCtx:          numCtx,
+			sessionDuration: time.Minute,
+			expiresAt:       expiresAt,
+		}
+		s.loaded[path] = r
+		return r
+	}
+
+	small := newRunner("small", 2048, time.Now().Add(time.Minute))
+	large := newRunner("large", 32768, time.Now().Add(2*time.Minute))
+	busy := newRunner("busy", 131072, time.Now())
+	busy.refCount = 1
+
+	thresholds := memoryPressure{shrink: 20, evict: 10}
+
+	// no pressure
+	s.relieveMemoryPressure(ctx, thresholds)
+	require.Empty(t, s.expiredCh)
+
+	// the idle model with the largest context is shrunk and reloaded
+	available = 15
+	s.relieveMemoryPressure(ctx, thresholds)
+	require.Equal(t, large, <-s.expiredCh)
+	require.True(t, large.stale)
+	require.Equal(t, 16384, s.contextLimit("large"))
+
+	pending := <-s.pendingReqCh
+	require.Equal(t, "large", pending.model.ModelPath)
+	require.Equal(t, 32768, pending.opts.NumCtx)
+
+	// models can't be shrunk below the minimum context
+	delete(s.loaded, "large")
+	s.relieveMemoryPressure(ctx, thresholds)
+	require.Empty(t, s.expiredCh)
+
+	// the idle model closest to expiring is evicted
+	available = 5
+	s.relieveMemoryPressure(ctx, thresholds)
+	require.Equal(t, small, <-s.expiredCh)
+
+	// limits are lifted once memory recovers, and models loaded with a
+	// limited context are reloaded by their next request
+	shrunk := newRunner("large", 16384, time.Now().Add(time.Minute))
+	shrunk.limited = true
+	available = 50
+	s.relieveMemoryPressure(ctx, thresholds)
+	require.Zero(t, s.contextLimit("large"))
+	require.True(t, shrunk.stale)
+	require.False(t, busy.stale)
+}
+
+func TestLoadContextLimit(t *testing.T) {
+	ctx, done := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer done()
+	s := InitScheduler(ctx)
+	s.setContextLimit("foo", 4096)
+
+	opts := api.DefaultOptions()
+	opts.NumCtx = 8192 * 2
+	req := &LlmRequest{
+		ctx:       ctx,
+		model:     &Model{ModelPath: "foo"},
+		opts:      opts,
+		successCh: make(chan *runnerRef, 1),
+		errCh:     make(chan error, 1),
+	}
+
+	var loaded api.Options
+	s.newServerFn = func(gpus discover.GpuInfoList, model string, ggml *llm.GGML, adapters []string, projectors []string, opts api.Options, numParallel int) (llm.LlamaServer, error) {
+		loaded = opts
+		return &mockLlm{}, nil
+	}
+
+	s.load(req, nil, discover.GpuInfoList{}, 2)
+	runner := <-req.successCh
+	require.Equal(t, 8192, loaded.NumCtx)
+	require.Equal(t, 4096, runner.numCtx)
+	require.Equal(t, 16384, runner.Options.NumCtx)
+	require.True(t, runner.limited)
+}
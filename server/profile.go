@@ -0,0 +1,134 @@
+package server
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/ollama/ollama/api"
+)
+
+// maxProfiles is the number of profiles kept, after which the oldest are
+// dropped
+const maxProfiles = 32
+
+var errProfile = errors.New("profile")
+
+// checkProfile checks the profile option is supported by m
+func checkProfile(m *Model, opts api.Options) error {
+	if opts.Profile && (m.Config.Remote != nil || opts.Fallback != "") {
+		return fmt.Errorf("%w isn't supported by remote servers", errProfile)
+	}
+
+	return nil
+}
+
+// profileStore keeps the latest profiles in memory until they're downloaded
+type profileStore struct {
+	mu       sync.Mutex
+	profiles []ownedProfile
+}
+
+// ownedProfile is a profile and the digest of the API key of the request it
+// was recorded for
+type ownedProfile struct {
+	*api.Profile
+	owner string
+}
+
+var profiles profileStore
+
+// add saves the profile of ops recorded for model for owner, returning its ID
+func (s *profileStore) add(model, owner string, ops []api.ProfileOp) string {
+	p := newProfile(model, ops)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.profiles = append(s.profiles, ownedProfile{p, owner})
+	if len(s.profiles) > maxProfiles {
+		s.profiles = slices.Delete(s.profiles, 0, len(s.profiles)-maxProfiles)
+	}
+
+	return p.ID
+}
+
+// get returns the profile id if owner may read it
+func (s *profileStore) get(id, owner string) *api.Profile {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range s.profiles {
+		if p.ID == id && owns(owner, p.owner) {
+			return p.Profile
+		}
+	}
+
+	return nil
+}
+
+// newProfile returns the profile of ops, slowest first, with the time spent
+// in each layer
+func newProfile(model string, ops []api.ProfileOp) *api.Profile {
+	p := api.Profile{
+		ID:        uuid.NewString(),
+		Model:     model,
+		CreatedAt: time.Now().UTC(),
+		Layers:    []api.ProfileLayer{},
+		Ops:       slices.Clone(ops),
+	}
+
+	layers := make(map[int]time.Duration)
+	for _, op := range ops {
+		p.Duration += op.Duration
+		if layer, ok := opLayer(op.Name); ok {
+			layers[layer] += op.Duration
+		}
+	}
+
+	for layer, d := range layers {
+		p.Layers = append(p.Layers, api.ProfileLayer{Layer: layer, Duration: d})
+	}
+
+	slices.SortFunc(p.Layers, func(a, b api.ProfileLayer) int {
+		return cmp.Compare(a.Layer, b.Layer)
+	})
+
+	slices.SortStableFunc(p.Ops, func(a, b api.ProfileOp) int {
+		return cmp.Compare(b.Duration, a.Duration)
+	})
+
+	return &p
+}
+
+// opLayer returns the layer of a tensor named after it, such as "attn_norm-3"
+func opLayer(name string) (int, bool) {
+	i := strings.LastIndexByte(name, '-')
+	if i < 0 {
+		return 0, false
+	}
+
+	layer, err := strconv.Atoi(name[i+1:])
+	if err != nil || layer < 0 {
+		return 0, false
+	}
+
+	return layer, true
+}
+
+func (s *Server) ProfileHandler(c *gin.Context) {
+	p := profiles.get(c.Param("id"), ownerOf(c))
+	if p == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("profile %q not found", c.Param("id"))})
+		return
+	}
+
+	c.JSON(http.StatusOK, p)
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+)
+
+func TestProfile(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mock := mockRunner{
+		CompletionFn: func(_ context.Context, r llm.CompletionRequest, fn func(llm.CompletionResponse)) error {
+			resp := llm.CompletionResponse{Content: "Hi!", Done: true, DoneReason: "stop"}
+			if r.Options.Profile {
+				resp.Profile = []api.ProfileOp{
+					{Name: "attn_norm-0", Op: "RMS_NORM", Count: 2, Duration: time.Millisecond},
+					{Name: "ffn_out-1", Op: "MUL_MAT", Count: 2, Duration: 5 * time.Millisecond},
+					{Name: "kq-0", Op: "MUL_MAT", Count: 2, Duration: 3 * time.Millisecond},
+					{Name: "result_output", Op: "MUL_MAT", Count: 2, Duration: 2 * time.Millisecond},
+				}
+			}
+			fn(resp)
+			return nil
+		},
+	}
+
+	s := Server{sched: newMockScheduler(t, &mock)}
+	createMockModel(t, &s, "test", `{{ .Prompt }}`)
+
+	generate := func(t *testing.T, profile bool) api.GenerateResponse {
+		t.Helper()
+		w := createRequest(t, s.GenerateHandler, api.GenerateRequest{
+			Model:   "test",
+			Prompt:  "Hello",
+			Options: map[string]any{"profile": profile},
+			Stream:  &stream,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+
+		var resp api.GenerateResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		return resp
+	}
+
+	if resp := generate(t, false); resp.ProfileID != "" {
+		t.Errorf("expected no profile, got %q", resp.ProfileID)
+	}
+
+	resp := generate(t, true)
+	if resp.ProfileID == "" {
+		t.Fatal("expected a profile")
+	}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Params = gin.Params{{Key: "id", Value: resp.ProfileID}}
+	s.ProfileHandler(c)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+	}
+
+	var p api.Profile
+	if err := json.NewDecoder(w.Body).Decode(&p); err != nil {
+		t.Fatal(err)
+	}
+
+	if p.Model != "test" || p.Duration != 11*time.Millisecond {
+		t.Errorf("unexpected profile %+v", p)
+	}
+
+	if diff := cmp.Diff([]api.ProfileLayer{{Layer: 0, Duration: 4 * time.Millisecond}, {Layer: 1, Duration: 5 * time.Millisecond}}, p.Layers); diff != "" {
+		t.Errorf("layers mismatch (-want +got):\n%s", diff)
+	}
+
+	var names []string
+	for _, op := range p.Ops {
+		names = append(names, op.Name)
+	}
+
+	if diff := cmp.Diff([]string{"ffn_out-1", "kq-0", "result_output", "attn_norm-0"}, names); diff != "" {
+		t.Errorf("ops mismatch (-want +got):\n%s", diff)
+	}
+
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	c.Params = gin.Params{{Key: "id", Value: "missing"}}
+	s.ProfileHandler(c)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", w.Code)
+	}
+}
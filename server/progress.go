@@ -0,0 +1,160 @@
+package server
+
+import (
+	"io"
+	"math"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+)
+
+// progressInterval is how often progress is reported while writing a model
+const progressInterval = 500 * time.Millisecond
+
+// tensorSpan is the end offset of a tensor in a file being written
+type tensorSpan struct {
+	name string
+	end  int64
+}
+
+// writeProgress reports the progress of a model file as it is written
+// tensor by tensor, e.g. during conversion or quantization
+type writeProgress struct {
+	stage  string
+	status string
+	fn     func(api.ProgressResponse)
+
+	// tensors are sorted by end offset. The last end offset is the expected
+	// size of the file.
+	tensors []tensorSpan
+	start   time.Time
+}
+
+func (p *writeProgress) total() int64 {
+	if len(p.tensors) == 0 {
+		return 0
+	}
+
+	return p.tensors[len(p.tensors)-1].end
+}
+
+// report sends a progress update for a file with written bytes
+func (p *writeProgress) report(written int64) {
+	resp := api.ProgressResponse{Status: p.status, Stage: p.stage}
+
+	total := p.total()
+	if total > 0 {
+		// sizes may be estimates so never report more than what's expected
+		written = min(written, total)
+
+		i := sort.Search(len(p.tensors), func(i int) bool { return p.tensors[i].end > written })
+		if i < len(p.tensors) {
+			resp.Tensor = p.tensors[i].name
+		}
+
+		resp.Total, resp.Completed = total, written
+		resp.Percent = math.Round(1000*float64(written)/float64(total)) / 10
+		if written > 0 && written < total {
+			elapsed := time.Since(p.start)
+			resp.Remaining = (time.Duration(float64(elapsed)*float64(total-written)/float64(written)) / time.Second) * time.Second
+		}
+	}
+
+	p.fn(resp)
+}
+
+// complete reports the file as fully written
+func (p *writeProgress) complete() {
+	if total := p.total(); total > 0 {
+		p.report(total)
+	}
+}
+
+// watch reports the progress of the file at path every interval until the
+// returned function is called. If p has no tensors, they're read from the
+// GGUF header of the file once it has been written.
+func (p *writeProgress) watch(path string, interval time.Duration) (stop func()) {
+	p.start = time.Now()
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+
+			fi, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+
+			if len(p.tensors) == 0 {
+				p.tensors = ggufSpans(path, fi.Size())
+			}
+
+			p.report(fi.Size())
+		}
+	}()
+
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+// ggufSpans reads the tensor layout from the header of a partially written
+// GGUF file. It returns nil if the header isn't complete.
+func ggufSpans(path string, size int64) []tensorSpan {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	ggml, _, err := llm.DecodeGGML(io.NewSectionReader(f, 0, size), 0)
+	if err != nil {
+		return nil
+	}
+
+	ts := ggml.Tensors()
+	spans := make([]tensorSpan, len(ts.Items))
+	for i, t := range ts.Items {
+		spans[i] = tensorSpan{name: t.Name, end: int64(ts.Offset + t.Offset + t.Size())}
+	}
+
+	sort.Slice(spans, func(i, j int) bool { return spans[i].end < spans[j].end })
+	return spans
+}
+
+// quantizeSpans estimates the layout of ggml after quantizing its weights to
+// tensor kind
+func quantizeSpans(ggml *llm.GGML, kind uint32) []tensorSpan {
+	ts := ggml.Tensors()
+
+	end := int64(ts.Offset)
+	spans := make([]tensorSpan, len(ts.Items))
+	for i, t := range ts.Items {
+		size := t.Size()
+		// only float matrices are quantized
+		if len(t.Shape) > 1 && (t.Kind == 0 || t.Kind == 1 || t.Kind == 30) {
+			size = llm.Tensor{Kind: kind, Shape: t.Shape}.Size()
+		}
+
+		end += int64(size)
+		spans[i] = tensorSpan{name: t.Name, end: end}
+	}
+
+	return spans
+}
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+)
+
+func TestWriteProgressReport(t *testing.T) {
+	var got []api.ProgressResponse
+	p := writeProgress{
+		stage:  "quantizing",
+		status: "quantizing F16 model to Q4_0",
+		fn:     func(resp api.ProgressResponse) { got = append(got, resp) },
+		tensors: []tensorSpan{
+			{name: "token_embd.weight", end: 100},
+			{name: "blk.0.attn_q.weight", end: 300},
+			{name: "output.weight", end: 400},
+		},
+		start: time.Now().Add(-10 * time.Second),
+	}
+
+	p.report(0)
+	p.report(100)
+	p.report(200)
+	p.report(1000)
+
+	for i, want := range []api.ProgressResponse{
+		{Tensor: "token_embd.weight", Total: 400, Completed: 0},
+		{Tensor: "blk.0.attn_q.weight", Total: 400, Completed: 100, Percent: 25, Remaining: 30 * time.Second},
+		{Tensor: "blk.0.attn_q.weight", Total: 400, Completed: 200, Percent: 50, Remaining: 10 * time.Second},
+		{Total: 400, Completed: 400, Percent: 100},
+	} {
+		want.Status, want.Stage = p.status, p.stage
+		if diff := cmp.Diff(want, got[i]); diff != "" {
+			t.Errorf("report %d mismatch (-want +got):\n%s", i, diff)
+		}
+	}
+
+	got = nil
+	(&writeProgress{stage: "converting", status: "converting model", fn: p.fn}).report(10)
+	if diff := cmp.Diff([]api.ProgressResponse{{Status: "converting model", Stage: "converting"}}, got); diff != "" {
+		t.Errorf("mismatch without layout (-want +got):\n%s", diff)
+	}
+}
+
+func TestGGUFSpans(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "model.gguf")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := llm.WriteGGUF(f, llm.KV{"general.architecture": "llama"}, []llm.Tensor{
+		{Name: "token_embd.weight", Kind: 1, Shape: []uint64{2, 4}, WriterTo: bytes.NewReader(make([]byte, 16))},
+		{Name: "blk.0.attn_q.weight", Kind: 1, Shape: []uint64{2, 2}, WriterTo: bytes.NewReader(make([]byte, 8))},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spans := ggufSpans(path, fi.Size())
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+
+	if spans[1].end != fi.Size() {
+		t.Errorf("expected last span to end at %d, got %d", fi.Size(), spans[1].end)
+	}
+
+	// incomplete headers are ignored
+	if spans := ggufSpans(path, 16); spans != nil {
+		t.Errorf("expected no spans for a partial header, got %v", spans)
+	}
+}
+
+func TestQuantizeSpans(t *testing.T) {
+	f, err := os.CreateTemp(t.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := llm.WriteGGUF(f, llm.KV{"general.architecture": "llama"}, []llm.Tensor{
+		{Name: "blk.0.attn_norm.weight", Kind: 0, Shape: []uint64{32}, WriterTo: bytes.NewReader(make([]byte, 128))},
+		{Name: "blk.0.attn_q.weight", Kind: 1, Shape: []uint64{32, 32}, WriterTo: bytes.NewReader(make([]byte, 2048))},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := f.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	ggml, _, err := llm.DecodeGGML(f, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ft, err := llm.ParseFileType("Q8_0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kind, ok := ft.TensorKind()
+	if !ok {
+		t.Fatal("expected tensor kind for Q8_0")
+	}
+
+	spans := quantizeSpans(ggml, kind)
+	offset := int64(ggml.Tensors().Offset)
+
+	// the 1D norm is kept as is and the 32x32 matrix is 32 Q8_0 blocks of 34 bytes
+	if diff := cmp.Diff([]tensorSpan{
+		{name: "blk.0.attn_norm.weight", end: offset + 128},
+		{name: "blk.0.attn_q.weight", end: offset + 128 + 32*34},
+	}, spans, cmp.AllowUnexported(tensorSpan{})); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestWriteProgressWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "model.gguf")
+	if err := os.WriteFile(path, make([]byte, 50), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var got []api.ProgressResponse
+	p := writeProgress{
+		stage:   "quantizing",
+		fn:      func(resp api.ProgressResponse) { mu.Lock(); got = append(got, resp); mu.Unlock() },
+		tensors: []tensorSpan{{name: "a", end: 100}},
+	}
+
+	stop := p.watch(path, time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(got) == 0 {
+		t.Fatal("expected progress to be reported")
+	}
+
+	if got[0].Completed != 50 || got[0].Percent != 50 {
+		t.Errorf("unexpected progress %+v", got[0])
+	}
+
+	n := len(got)
+	time.Sleep(5 * time.Millisecond)
+	if len(got) != n {
+		t.Error("expected no progress after stop")
+	}
+}
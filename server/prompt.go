@@ -7,9 +7,11 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
 	"strings"
 
 	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
 	"github.com/ollama/ollama/llm"
 	"github.com/ollama/ollama/model/mllama"
 	"github.com/ollama/ollama/template"
@@ -17,129 +19,310 @@ import (
 
 type tokenizeFunc func(context.Context, string) ([]int, error)
 
-var errTooManyImages = errors.New("vision model only supports a single image per message")
+var errTooManyImages = errors.New("too many images in a message for this vision model, the limit can be raised with OLLAMA_MAX_IMAGES")
+
+// promptStats describes how chatPrompt fit a chat into the context window
+type promptStats struct {
+	// truncated is the number of messages dropped from the chat
+	truncated int
+	// tokens is the number of tokens in the prompt, imageTokens of which
+	// are images
+	tokens, imageTokens int
+}
 
 // chatPrompt accepts a list of messages and returns the prompt and images that should be used for the next chat turn.
-// chatPrompt truncates any messages that exceed the context window of the model, making sure to always include 1) the
-// latest message and 2) system messages
-func chatPrompt(ctx context.Context, m *Model, tokenize tokenizeFunc, opts *api.Options, msgs []api.Message, tools []api.Tool) (prompt string, images []llm.ImageData, _ error) {
-	var system []api.Message
+// chatPrompt truncates any messages that exceed the context window of the model as tr says, making sure to always
+// include 1) the latest message and 2) system messages
+func chatPrompt(ctx context.Context, m *Model, tokenize tokenizeFunc, opts *api.Options, msgs []api.Message, tools []api.Tool, tr truncation) (prompt string, images []llm.ImageData, stats promptStats, _ error) {
+	tokenizer := func(s string) ([]int, error) {
+		return tokenize(ctx, s)
+	}
 
 	isMllama := checkMllamaModelFamily(m)
 
+	// tool results are rendered as user messages for templates that would
+	// otherwise render them incorrectly or drop them
+	if slices.ContainsFunc(msgs, func(msg api.Message) bool { return msg.Role == "tool" }) && !m.Template.HandlesRole("tool") {
+		var err error
+		if msgs, err = template.ToolResults(msgs, opts.ToolResults); err != nil {
+			return "", nil, promptStats{}, err
+		}
+	}
+
 	var imageNumTokens int
-	// TODO: Ideally we would compute this from the projector metadata but some pieces are implementation dependent
-	if isMllama {
-		// Our mllama implementation packs all of the embeddings into a single token
-		imageNumTokens = 1
-	} else {
-		// Clip images are represented as 768 tokens, each an embedding
-		imageNumTokens = 768
-	}
-
-	n := len(msgs) - 1
-	// in reverse, find all messages that fit into context window
-	for i := n; i >= 0; i-- {
-		if isMllama && len(msgs[i].Images) > 1 {
-			return "", nil, errTooManyImages
+	if m.ProjectorPaths != nil {
+		imageNumTokens = m.ImageTokens()
+	}
+
+	// imageTokens returns the number of tokens the images of msgs take in
+	// the context window
+	imageTokens := func(msgs []api.Message) int {
+		var n int
+		if m.ProjectorPaths != nil {
+			for _, msg := range msgs {
+				n += imageNumTokens * len(msg.Images)
+			}
+		}
+
+		return n
+	}
+
+	// numTokens returns the number of tokens msgs take in the context window
+	numTokens := func(msgs []api.Message) (int, error) {
+		var b bytes.Buffer
+		if err := m.Template.Execute(&b, template.Values{Messages: msgs, Tools: tools, Tokenize: tokenizer}); err != nil {
+			return 0, err
 		}
 
-		// always include the last message
-		if i == n {
-			continue
+		ctxLen, err := promptTokenCount(ctx, m, tools, msgs, &b, tokenizer)
+		if err != nil {
+			return 0, err
 		}
 
-		system = make([]api.Message, 0)
-		for j := range i {
-			if msgs[j].Role == "system" {
-				system = append(system, msgs[j])
+		return ctxLen + imageTokens(msgs), nil
+	}
+
+	// dropped returns the number of messages before the first of the latest
+	// messages window kept, which are dropped unless they're system messages
+	dropped := func(msgs []api.Message, n int) int {
+		var count int
+		for _, msg := range msgs[:n] {
+			if msg.Role != "system" {
+				count++
 			}
 		}
 
-		var b bytes.Buffer
-		if err := m.Template.Execute(&b, template.Values{Messages: append(system, msgs[i:]...), Tools: tools}); err != nil {
-			return "", nil, err
+		return count
+	}
+
+	// window returns the latest messages that fit into the context window
+	// along with the earlier messages kept by keep, and the index of the
+	// first of the latest messages
+	window := func(msgs []api.Message, keep func(api.Message, int) bool) ([]api.Message, int, error) {
+		kept := func(i int) []api.Message {
+			var kept []api.Message
+			for j := range i {
+				if keep(msgs[j], j) {
+					kept = append(kept, msgs[j])
+				}
+			}
+
+			return append(kept, msgs[i:]...)
 		}
 
-		s, err := tokenize(ctx, b.String())
+		n := len(msgs) - 1
+		// in reverse, find all messages that fit into context window
+		for i := n; i >= 0; i-- {
+			if isMllama && uint(len(msgs[i].Images)) > max(envconfig.MaxImages(), 1) {
+				return nil, 0, errTooManyImages
+			}
+
+			// always include the last message
+			if i == n {
+				continue
+			}
+
+			ctxLen, err := numTokens(kept(i))
+			if err != nil {
+				return nil, 0, err
+			}
+
+			if ctxLen > opts.NumCtx {
+				slog.Debug("truncating input messages which exceed context length", "truncated", len(msgs[i:]))
+				break
+			} else {
+				n = i
+			}
+		}
+
+		return kept(n), n, nil
+	}
+
+	drop := func(msg api.Message, _ int) bool { return msg.Role == "system" }
+
+	kept, n, err := window(msgs, drop)
+	if err != nil {
+		return "", nil, promptStats{}, err
+	}
+
+	stats.truncated = dropped(msgs, n)
+	switch tr.strategy {
+	case truncateError:
+		if stats.truncated > 0 {
+			return "", nil, promptStats{}, errTruncated
+		}
+	case truncateMiddleOut:
+		// the first message after the system messages usually sets the task
+		// of the chat, so it's kept along with the latest messages unless the
+		// latest message doesn't fit with it
+		first := slices.IndexFunc(msgs, func(msg api.Message) bool { return msg.Role != "system" })
+		if first < 0 || first >= n {
+			break
+		}
+
+		middleOut, n, err := window(msgs, func(msg api.Message, i int) bool { return drop(msg, i) || i == first })
 		if err != nil {
-			return "", nil, err
+			return "", nil, promptStats{}, err
 		}
 
-		ctxLen := len(s)
-		if m.ProjectorPaths != nil {
-			for _, m := range msgs[i:] {
-				ctxLen += imageNumTokens * len(m.Images)
+		ctxLen, err := numTokens(middleOut)
+		if err != nil {
+			return "", nil, promptStats{}, err
+		}
+
+		if ctxLen <= opts.NumCtx {
+			kept = middleOut
+			stats.truncated = dropped(msgs, n) - 1
+		}
+	case truncateSummarize:
+		// the messages that were dropped are summarized in a system message
+		// so the chat keeps what they said
+		var system, evicted []api.Message
+		for _, msg := range msgs[:n] {
+			if msg.Role == "system" {
+				system = append(system, msg)
+			} else {
+				evicted = append(evicted, msg)
 			}
 		}
 
-		if ctxLen > opts.NumCtx {
-			slog.Debug("truncating input messages which exceed context length", "truncated", len(msgs[i:]))
+		if len(evicted) == 0 || tr.summarize == nil {
 			break
-		} else {
-			n = i
 		}
+
+		summary, err := tr.summarize(ctx, evicted)
+		if err != nil {
+			slog.Warn("summarizing truncated messages failed, dropping them", "error", err)
+			break
+		}
+
+		summarized := append(system, summaryMessage(summary))
+		summarized = append(summarized, msgs[n:]...)
+		kept, n, err = window(summarized, drop)
+		if err != nil {
+			return "", nil, promptStats{}, err
+		}
+
+		stats.truncated = len(evicted) + dropped(summarized, n)
+	}
+
+	stats.tokens, err = numTokens(kept)
+	if err != nil {
+		return "", nil, promptStats{}, err
 	}
 
-	currMsgIdx := n
+	stats.imageTokens = imageTokens(kept)
 
-	for cnt, msg := range msgs[currMsgIdx:] {
+	for cnt, msg := range kept {
 		prefix := ""
 		imgPrompt := ""
 		prompt := msg.Content
 
-		for _, i := range msg.Images {
-			var imgData llm.ImageData
+		msgImages, err := regionImages(msg)
+		if err != nil {
+			return "", nil, promptStats{}, err
+		}
 
-			if isMllama {
-				data, opts, err := mllama.Preprocess(bytes.NewReader(i))
-				if err != nil {
-					return "", nil, err
-				}
+		for _, i := range msgImages {
+			imgData := llm.ImageData{
+				ID:   len(images),
+				Data: i,
+			}
 
-				buf := new(bytes.Buffer)
-				err = binary.Write(buf, binary.LittleEndian, data)
+			if isMllama {
+				var err error
+				imgData, err = mllamaImage(len(images), i)
 				if err != nil {
-					return "", nil, err
-				}
-
-				ar, ok := opts["aspectRatioIndex"].(int)
-				if !ok {
-					return "", nil, fmt.Errorf("missing aspect ratio for image")
-				}
-
-				imgData = llm.ImageData{
-					ID:            len(images),
-					Data:          buf.Bytes(),
-					AspectRatioID: ar,
-				}
-				imgPrompt = "<|image|>"
-			} else {
-				imgData = llm.ImageData{
-					ID:   len(images),
-					Data: i,
+					return "", nil, promptStats{}, err
 				}
 			}
 
 			imgTag := fmt.Sprintf("[img-%d]", imgData.ID)
 			if !strings.Contains(prompt, "[img]") {
 				prefix += imgTag
+				if isMllama {
+					// the image tokens follow all of the images so the
+					// images are attended to together
+					imgPrompt += "<|image|>"
+				}
 			} else {
+				if isMllama {
+					imgTag += "<|image|>"
+				}
+
 				prompt = strings.Replace(prompt, "[img]", imgTag, 1)
 			}
 
 			images = append(images, imgData)
 		}
-		msgs[currMsgIdx+cnt].Content = prefix + imgPrompt + prompt
+		kept[cnt].Content = prefix + imgPrompt + prompt
 	}
 
-	// truncate any messages that do not fit into the context window
 	var b bytes.Buffer
-	if err := m.Template.Execute(&b, template.Values{Messages: append(system, msgs[currMsgIdx:]...), Tools: tools}); err != nil {
-		return "", nil, err
+	if err := m.Template.Execute(&b, template.Values{Messages: kept, Tools: tools, Tokenize: tokenizer}); err != nil {
+		return "", nil, promptStats{}, err
+	}
+
+	return b.String(), images, stats, nil
+}
+
+// rawChatPrompt returns the prompt of a raw chat request, which is used as is,
+// and the images of msgs. Images are numbered in order across the messages,
+// so the prompt refers to them as [img-<n>].
+func rawChatPrompt(m *Model, prompt string, msgs []api.Message) (string, []llm.ImageData, error) {
+	isMllama := checkMllamaModelFamily(m)
+
+	var images []llm.ImageData
+	for _, msg := range msgs {
+		if isMllama && uint(len(msg.Images)) > max(envconfig.MaxImages(), 1) {
+			return "", nil, errTooManyImages
+		}
+
+		msgImages, err := regionImages(msg)
+		if err != nil {
+			return "", nil, err
+		}
+
+		for _, i := range msgImages {
+			imgData := llm.ImageData{ID: len(images), Data: i}
+			if isMllama {
+				var err error
+				imgData, err = mllamaImage(len(images), i)
+				if err != nil {
+					return "", nil, err
+				}
+			}
+
+			images = append(images, imgData)
+		}
+	}
+
+	return prompt, images, nil
+}
+
+// mllamaImage preprocesses an image for an mllama model, which takes the
+// image's pixel values and the index of its aspect ratio
+func mllamaImage(id int, image []byte) (llm.ImageData, error) {
+	data, opts, err := mllama.Preprocess(bytes.NewReader(image))
+	if err != nil {
+		return llm.ImageData{}, err
+	}
+
+	buf := new(bytes.Buffer)
+	if err := binary.Write(buf, binary.LittleEndian, data); err != nil {
+		return llm.ImageData{}, err
+	}
+
+	ar, ok := opts["aspectRatioIndex"].(int)
+	if !ok {
+		return llm.ImageData{}, fmt.Errorf("missing aspect ratio for image")
 	}
 
-	return b.String(), images, nil
+	return llm.ImageData{
+		ID:            id,
+		Data:          buf.Bytes(),
+		AspectRatioID: ar,
+	}, nil
 }
 
 func checkMllamaModelFamily(m *Model) bool {
@@ -3,13 +3,18 @@ package server
 import (
 	"bytes"
 	"context"
+	"errors"
 	"image"
 	"image/png"
+	"os"
+	"slices"
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
 
 	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
 	"github.com/ollama/ollama/template"
 )
 
@@ -299,6 +304,19 @@ func TestChatPrompt(t *testing.T) {
 				aspectRatioID: 1,
 			},
 		},
+		{
+			name:  "tool results without a tool role",
+			model: visionModel,
+			limit: 2048,
+			msgs: []api.Message{
+				{Role: "user", Content: "What's the weather?"},
+				{Role: "assistant", Content: "Let me check.", ToolCalls: []api.ToolCall{{Function: api.ToolCallFunction{Name: "get_weather"}}}},
+				{Role: "tool", Content: "sunny"},
+			},
+			expect: expect{
+				prompt: "What's the weather? Let me check. <function_response name=\"get_weather\">\nsunny\n</function_response> ",
+			},
+		},
 		{
 			name:  "too many images with mllama",
 			model: mllamaModel,
@@ -318,7 +336,7 @@ func TestChatPrompt(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			model := tt.model
 			opts := api.Options{Runner: api.Runner{NumCtx: tt.limit}}
-			prompt, images, err := chatPrompt(context.TODO(), &model, mockRunner{}.Tokenize, &opts, tt.msgs, nil)
+			prompt, images, _, err := chatPrompt(context.TODO(), &model, mockRunner{}.Tokenize, &opts, tt.msgs, nil, truncation{})
 			if tt.error == nil && err != nil {
 				t.Fatal(err)
 			} else if tt.error != nil && err != tt.error {
@@ -351,3 +369,205 @@ func TestChatPrompt(t *testing.T) {
 		})
 	}
 }
+
+func TestChatPromptMllamaImages(t *testing.T) {
+	t.Setenv("OLLAMA_MAX_IMAGES", "2")
+
+	tmpl, err := template.Parse(`{{- range .Messages }}{{ .Content }} {{ end }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := Model{Template: tmpl, ProjectorPaths: []string{"vision"}, Config: ConfigV2{ModelFamilies: []string{"mllama"}}}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 5, 5))); err != nil {
+		t.Fatal(err)
+	}
+	img := buf.Bytes()
+
+	cases := []struct {
+		name   string
+		msgs   []api.Message
+		prompt string
+		err    error
+	}{
+		{
+			name:   "images together",
+			msgs:   []api.Message{{Role: "user", Content: "Compare these pictures", Images: []api.ImageData{img, img}}},
+			prompt: "[img-0][img-1]<|image|><|image|>Compare these pictures ",
+		},
+		{
+			name:   "images in place",
+			msgs:   []api.Message{{Role: "user", Content: "What changed from [img] to [img]?", Images: []api.ImageData{img, img}}},
+			prompt: "What changed from [img-0]<|image|> to [img-1]<|image|>? ",
+		},
+		{
+			name: "too many images",
+			msgs: []api.Message{{Role: "user", Content: "Compare these pictures", Images: []api.ImageData{img, img, img}}},
+			err:  errTooManyImages,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := api.Options{Runner: api.Runner{NumCtx: 2048}}
+			prompt, images, _, err := chatPrompt(context.TODO(), &m, mockRunner{}.Tokenize, &opts, tt.msgs, nil, truncation{})
+			if err != tt.err {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+
+			if prompt != tt.prompt {
+				t.Errorf("expected prompt %q, got %q", tt.prompt, prompt)
+			}
+
+			if tt.err == nil && len(images) != len(tt.msgs[0].Images) {
+				t.Errorf("expected %d images, got %d", len(tt.msgs[0].Images), len(images))
+			}
+
+			for i, img := range images {
+				if img.ID != i || img.AspectRatioID != 1 {
+					t.Errorf("unexpected image %d: id %d, aspect ratio %d", i, img.ID, img.AspectRatioID)
+				}
+			}
+		})
+	}
+}
+
+func TestChatPromptProjectorImageTokens(t *testing.T) {
+	f, err := os.CreateTemp(t.TempDir(), "*.gguf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// a 28x28 image in 14x14 patches takes 4 tokens
+	if err := llm.WriteGGUF(f, llm.KV{
+		"general.architecture":   "clip",
+		"clip.vision.image_size": uint32(28),
+		"clip.vision.patch_size": uint32(14),
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl, err := template.Parse(`{{- range .Messages }}{{ .Content }} {{ end }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msgs := []api.Message{
+		{Role: "user", Content: "look", Images: []api.ImageData{[]byte("image")}},
+		{Role: "assistant", Content: "ok"},
+		{Role: "user", Content: "describe it"},
+	}
+
+	cases := []struct {
+		name   string
+		model  Model
+		images int
+	}{
+		{"projector metadata", Model{Template: tmpl, ProjectorPaths: []string{f.Name()}}, 1},
+		{"unreadable projector", Model{Template: tmpl, ProjectorPaths: []string{"vision"}}, 0},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := api.Options{Runner: api.Runner{NumCtx: 64}}
+			_, images, _, err := chatPrompt(context.TODO(), &tt.model, mockRunner{}.Tokenize, &opts, msgs, nil, truncation{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(images) != tt.images {
+				t.Errorf("expected %d images, got %d", tt.images, len(images))
+			}
+		})
+	}
+}
+
+func TestChatPromptTruncation(t *testing.T) {
+	tmpl, err := template.Parse(`{{- range .Messages }}{{ .Content }} {{ end }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := Model{Template: tmpl}
+
+	msgs := []api.Message{
+		{Role: "system", Content: "be brief"},
+		{Role: "user", Content: "write a poem"},
+		{Role: "assistant", Content: "roses are red"},
+		{Role: "user", Content: "another one"},
+		{Role: "assistant", Content: "violets are blue"},
+		{Role: "user", Content: "one more"},
+	}
+
+	var evicted []api.Message
+	summarize := func(_ context.Context, msgs []api.Message) (string, error) {
+		evicted = msgs
+		return "poem asked", nil
+	}
+
+	cases := []struct {
+		name      string
+		tr        truncation
+		limit     int
+		prompt    string
+		evicted   []api.Message
+		truncated int
+		err       error
+	}{
+		{"drop", truncation{}, 9, "be brief another one violets are blue one more ", nil, 2, nil},
+		{"middle out", truncation{strategy: truncateMiddleOut}, 9, "be brief write a poem\n\none more ", nil, 3, nil},
+		{"middle out without room", truncation{strategy: truncateMiddleOut}, 6, "be brief one more ", nil, 4, nil},
+		{
+			"summarize",
+			truncation{strategy: truncateSummarize, summarize: summarize},
+			13,
+			"be brief\n\nSummary of the earlier conversation:\npoem asked one more ",
+			msgs[1:2],
+			4,
+			nil,
+		},
+		{
+			"summarize error",
+			truncation{strategy: truncateSummarize, summarize: func(context.Context, []api.Message) (string, error) {
+				return "", errors.New("busy")
+			}},
+			13,
+			"be brief roses are red another one violets are blue one more ",
+			nil,
+			1,
+			nil,
+		},
+		{"nothing to summarize", truncation{strategy: truncateSummarize, summarize: summarize}, 64, "be brief write a poem roses are red another one violets are blue one more ", nil, 0, nil},
+		{"error", truncation{strategy: truncateError}, 9, "", nil, 0, errTruncated},
+		{"error with room", truncation{strategy: truncateError}, 64, "be brief write a poem roses are red another one violets are blue one more ", nil, 0, nil},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			evicted = nil
+			opts := api.Options{Runner: api.Runner{NumCtx: tt.limit}}
+			prompt, _, stats, err := chatPrompt(context.TODO(), &m, mockRunner{}.Tokenize, &opts, slices.Clone(msgs), nil, tt.tr)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+
+			if diff := cmp.Diff(tt.prompt, prompt); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tt.evicted, evicted); diff != "" {
+				t.Errorf("evicted mismatch (-want +got):\n%s", diff)
+			}
+
+			if stats.truncated != tt.truncated {
+				t.Errorf("expected %d truncated messages, got %d", tt.truncated, stats.truncated)
+			}
+
+			if tokens := len(strings.Fields(prompt)); stats.tokens != tokens {
+				t.Errorf("expected %d tokens, got %d", tokens, stats.tokens)
+			}
+		})
+	}
+}
@@ -0,0 +1,251 @@
+package server
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
+)
+
+// promptCacheSize is the number of rendered message prefixes the prompt
+// cache keeps the token counts of
+const promptCacheSize = 4096
+
+// promptTokens caches the token counts of rendered chat prompts across requests
+var promptTokens = newPromptCache(promptCacheSize)
+
+// promptCacheEntry is the token count of a rendered list of messages. The
+// rendered prompt is kept as its length and hash so a later prompt can be
+// checked to start with it.
+type promptCacheEntry struct {
+	key    [sha256.Size]byte
+	n      int
+	sum    [sha256.Size]byte
+	tokens int
+
+	// model is the path of the model the messages were rendered for
+	model string
+	// used is when the entry was last added or reused
+	used time.Time
+}
+
+// promptCache is an LRU cache of token counts keyed by the model, template,
+// tools and messages that were rendered. Chats resend every earlier message
+// with each turn, so the prompt of a turn usually starts with the prompt of
+// the turn before it and only the rest has to be tokenized.
+type promptCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List
+	entries map[[sha256.Size]byte]*list.Element
+
+	hits, misses, evictions, tokensReused int
+}
+
+func newPromptCache(size int) *promptCache {
+	return &promptCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[[sha256.Size]byte]*list.Element),
+	}
+}
+
+// promptPrefixKeys returns the cache keys of each prefix of msgs, where
+// keys[i] is the key of msgs[:i+1]. Keys are chained so computing them is
+// linear in the length of msgs.
+func promptPrefixKeys(m *Model, tools []api.Tool, msgs []api.Message) ([][sha256.Size]byte, error) {
+	h := sha256.New()
+	h.Write([]byte(m.ModelPath))
+	h.Write([]byte{0})
+	h.Write([]byte(m.Template.String()))
+	h.Write([]byte{0})
+	if err := json.NewEncoder(h).Encode(tools); err != nil {
+		return nil, err
+	}
+
+	var key [sha256.Size]byte
+	h.Sum(key[:0])
+
+	keys := make([][sha256.Size]byte, len(msgs))
+	for i, msg := range msgs {
+		bts, err := json.Marshal(msg)
+		if err != nil {
+			return nil, err
+		}
+
+		h.Reset()
+		h.Write(key[:])
+		h.Write(bts)
+		h.Sum(key[:0])
+		keys[i] = key
+	}
+
+	return keys, nil
+}
+
+// count returns the number of tokens in prompt, which is the rendering of
+// the messages keys are the prefix keys of. The tokens of the longest cached
+// prefix the prompt starts with are reused and only the rest of the prompt is
+// tokenized. Splitting the prompt can change how the tokens at the split are
+// merged, which is close enough for fitting messages into the context window.
+func (c *promptCache) count(model string, keys [][sha256.Size]byte, prompt []byte, tokenize func(string) ([]int, error)) (int, error) {
+	var reused, n int
+	if e, ok := c.prefix(keys, prompt); ok {
+		reused, n = e.tokens, e.n
+	}
+
+	var tokens int
+	if n < len(prompt) {
+		s, err := tokenize(string(prompt[n:]))
+		if err != nil {
+			return 0, err
+		}
+
+		tokens = len(s)
+	}
+
+	if len(keys) > 0 {
+		c.put(promptCacheEntry{key: keys[len(keys)-1], n: len(prompt), sum: sha256.Sum256(prompt), tokens: reused + tokens, model: model, used: time.Now()})
+	}
+
+	return reused + tokens, nil
+}
+
+// prefix returns the entry of the longest prefix of messages whose prompt
+// begins prompt. Entries that haven't been used within OLLAMA_CACHE_TTL are
+// removed rather than reused.
+func (c *promptCache) prefix(keys [][sha256.Size]byte, prompt []byte) (promptCacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ttl := envconfig.CacheTTL()
+	for i := len(keys) - 1; i >= 0; i-- {
+		el, ok := c.entries[keys[i]]
+		if !ok {
+			continue
+		}
+
+		e := el.Value.(promptCacheEntry)
+		if ttl > 0 && time.Since(e.used) > ttl {
+			c.remove(el)
+			continue
+		}
+
+		if e.n > len(prompt) || sha256.Sum256(prompt[:e.n]) != e.sum {
+			continue
+		}
+
+		e.used = time.Now()
+		el.Value = e
+		c.order.MoveToFront(el)
+		c.hits++
+		c.tokensReused += e.tokens
+		return e, true
+	}
+
+	c.misses++
+	return promptCacheEntry{}, false
+}
+
+func (c *promptCache) put(e promptCacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[e.key]; ok {
+		el.Value = e
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.entries[e.key] = c.order.PushFront(e)
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+		c.evictions++
+	}
+}
+
+func (c *promptCache) remove(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.entries, el.Value.(promptCacheEntry).key)
+}
+
+// expire removes the entries that haven't been used within ttl, returning
+// the number removed
+func (c *promptCache) expire(ttl time.Duration) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// entries are ordered by when they were last used, newest first
+	var expired int
+	for el := c.order.Back(); el != nil && time.Since(el.Value.(promptCacheEntry).used) > ttl; el = c.order.Back() {
+		c.remove(el)
+		expired++
+	}
+
+	return expired
+}
+
+// purge removes the entries of the model at path, or every entry if path is
+// empty, returning the number removed
+func (c *promptCache) purge(path string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var purged int
+	for el := c.order.Front(); el != nil; {
+		next := el.Next()
+		if path == "" || el.Value.(promptCacheEntry).model == path {
+			c.remove(el)
+			purged++
+		}
+		el = next
+	}
+
+	return purged
+}
+
+func (c *promptCache) stats() api.PromptCacheResponse {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return api.PromptCacheResponse{
+		Entries:      c.order.Len(),
+		Size:         c.size,
+		Hits:         c.hits,
+		Misses:       c.misses,
+		Evictions:    c.evictions,
+		TokensReused: c.tokensReused,
+	}
+}
+
+// promptTokenCount returns the number of tokens of prompt, the rendering of
+// msgs, using the prompt cache. Models without weights on disk aren't cached
+// since the tokenizer can't be told apart, and neither are requests that
+// mustn't be stored.
+func promptTokenCount(ctx context.Context, m *Model, tools []api.Tool, msgs []api.Message, prompt *bytes.Buffer, tokenize func(string) ([]int, error)) (int, error) {
+	if m.ModelPath == "" || m.Template == nil || noStore(ctx) {
+		s, err := tokenize(prompt.String())
+		return len(s), err
+	}
+
+	keys, err := promptPrefixKeys(m, tools, msgs)
+	if err != nil {
+		return 0, err
+	}
+
+	return promptTokens.count(m.ModelPath, keys, prompt.Bytes(), tokenize)
+}
+
+// PromptCacheHandler reports the statistics of the prompt token cache
+func (s *Server) PromptCacheHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, promptTokens.stats())
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/template"
+)
+
+func TestPromptCache(t *testing.T) {
+	tmpl, err := template.Parse("{{ range .Messages }}{{ .Role }}: {{ .Content }}\n{{ end }}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &Model{ModelPath: "model", Template: tmpl}
+
+	var tokenized []string
+	tokenize := func(s string) ([]int, error) {
+		tokenized = append(tokenized, s)
+		return make([]int, len(strings.Fields(s))), nil
+	}
+
+	c := newPromptCache(2)
+	count := func(msgs []api.Message) int {
+		t.Helper()
+
+		var b bytes.Buffer
+		if err := m.Template.Execute(&b, template.Values{Messages: msgs}); err != nil {
+			t.Fatal(err)
+		}
+
+		keys, err := promptPrefixKeys(m, nil, msgs)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tokenized = nil
+		n, err := c.count(m.ModelPath, keys, b.Bytes(), tokenize)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return n
+	}
+
+	turn := []api.Message{{Role: "user", Content: "why is the sky blue"}}
+	if n := count(turn); n != 6 {
+		t.Errorf("expected 6 tokens, got %d", n)
+	}
+
+	if len(tokenized) != 1 {
+		t.Fatalf("expected the prompt to be tokenized, got %q", tokenized)
+	}
+
+	turn = append(turn, api.Message{Role: "assistant", Content: "rayleigh scattering"}, api.Message{Role: "user", Content: "thanks"})
+	if n := count(turn); n != 11 {
+		t.Errorf("expected 11 tokens, got %d", n)
+	}
+
+	if len(tokenized) != 1 || tokenized[0] != "assistant: rayleigh scattering\nuser: thanks\n" {
+		t.Errorf("expected only the new messages to be tokenized, got %q", tokenized)
+	}
+
+	if n := count(turn); n != 11 || len(tokenized) != 0 {
+		t.Errorf("expected 11 cached tokens, got %d tokenizing %q", n, tokenized)
+	}
+
+	// a different model doesn't reuse the tokens
+	m = &Model{ModelPath: "other", Template: tmpl}
+	if n := count(turn[:1]); n != 6 || len(tokenized) != 1 {
+		t.Errorf("expected 6 tokens to be tokenized, got %d tokenizing %q", n, tokenized)
+	}
+
+	stats := c.stats()
+	if stats.Hits != 2 || stats.Misses != 2 || stats.Entries != 2 || stats.Evictions != 1 || stats.TokensReused != 17 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestPromptCachePrefixMismatch(t *testing.T) {
+	// the generation prompt is only rendered after the last message, so the
+	// prompt of a turn doesn't start with the prompt of the last turn
+	tmpl, err := template.Parse("{{ range .Messages }}{{ .Role }}: {{ .Content }}\n{{ end }}assistant says")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &Model{ModelPath: "model", Template: tmpl}
+	c := newPromptCache(promptCacheSize)
+
+	var calls int
+	tokenize := func(s string) ([]int, error) {
+		calls++
+		return make([]int, len(strings.Fields(s))), nil
+	}
+
+	msgs := []api.Message{{Role: "user", Content: "hello"}, {Role: "assistant", Content: "hi"}}
+	for i := 1; i <= len(msgs); i++ {
+		var b bytes.Buffer
+		if err := m.Template.Execute(&b, template.Values{Messages: msgs[:i]}); err != nil {
+			t.Fatal(err)
+		}
+
+		keys, err := promptPrefixKeys(m, nil, msgs[:i])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		n, err := c.count(m.ModelPath, keys, b.Bytes(), tokenize)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want := len(strings.Fields(b.String())); n != want {
+			t.Errorf("expected %d tokens, got %d", want, n)
+		}
+	}
+
+	if stats := c.stats(); stats.Hits != 0 || stats.Misses != 2 || calls != 2 {
+		t.Errorf("expected the prompts to be tokenized in full, got %+v after %d calls", stats, calls)
+	}
+}
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
+)
+
+// setProvenance sets headers identifying the model, server and request of a
+// generate or chat response, if OLLAMA_PROVENANCE is set, so the text can be
+// traced back to where it was generated
+func setProvenance(c *gin.Context, m *Model, opts *api.Options, req any) {
+	if !envconfig.Provenance() {
+		return
+	}
+
+	instance := envconfig.Instance()
+	if instance == "" {
+		instance, _ = os.Hostname()
+	}
+
+	c.Header("Ollama-Model", m.ShortName)
+	c.Header("Ollama-Model-Digest", m.Digest)
+	c.Header("Ollama-Instance", instance)
+	c.Header("Ollama-Created", time.Now().UTC().Format(time.RFC3339))
+	c.Header("Ollama-Watermark", strconv.FormatBool(opts.Watermark))
+
+	if b, err := json.Marshal(req); err == nil {
+		c.Header("Ollama-Request-Hash", fmt.Sprintf("sha256:%x", sha256.Sum256(b)))
+	}
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/types/model"
+)
+
+// expireInterval is the longest expired sessions and cached prompts are kept
+// before they're removed
+const expireInterval = time.Minute
+
+// expire removes the sessions and cached prompts that expired until ctx is
+// done, so they aren't kept in memory until the next request looks them up.
+// Runners expire the prompts in their KV caches themselves.
+func expire(ctx context.Context) {
+	ticker := time.NewTicker(expireInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			sessions := purgeChatSessions(func(s *chatSession) bool { return now.After(s.expires) })
+
+			var prompts int
+			if ttl := envconfig.CacheTTL(); ttl > 0 {
+				prompts = promptTokens.expire(ttl) + summaries.expire(ttl)
+			}
+
+			if sessions > 0 || prompts > 0 {
+				slog.Debug("expired sessions and cached prompts", "sessions", sessions, "prompt_cache_entries", prompts)
+			}
+		}
+	}
+}
+
+// purgeRunners clears the KV caches of the runners of the model at path, or
+// of every runner if path is empty, returning the number of slots cleared
+func (s *Server) purgeRunners(ctx context.Context, path string) int {
+	s.sched.loadedMu.Lock()
+	var runners []*runnerRef
+	for _, runner := range s.sched.loaded {
+		if runner.llama != nil && (path == "" || runner.modelPath == path) {
+			runners = append(runners, runner)
+		}
+	}
+	s.sched.loadedMu.Unlock()
+
+	var slots int
+	for _, runner := range runners {
+		n, err := runner.llama.PurgeCache(ctx)
+		if err != nil {
+			slog.Warn("couldn't purge the cache of a runner", "model", runner.modelPath, "error", err)
+			continue
+		}
+		slots += n
+	}
+
+	return slots
+}
+
+func (s *Server) PurgeHandler(c *gin.Context) {
+	var req api.PurgeRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var set int
+	for _, ok := range []bool{req.Model != "", req.Key != "", req.All} {
+		if ok {
+			set++
+		}
+	}
+
+	if set != 1 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "one of model, key or all is required"})
+		return
+	}
+
+	var resp api.PurgeResponse
+	switch {
+	case req.All:
+		resp.Sessions = purgeChatSessions(func(*chatSession) bool { return true })
+		resp.PromptCacheEntries = promptTokens.purge("") + summaries.purge("")
+		resp.CacheSlots = s.purgeRunners(c.Request.Context(), "")
+	case req.Key != "":
+		resp.Sessions = purgeChatSessions(func(s *chatSession) bool { return s.id == req.Key || s.memory == req.Key })
+	default:
+		name := model.ParseName(req.Model)
+		if !name.IsValid() {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid model name"})
+			return
+		}
+
+		if n, err := getExistingName(name); err == nil {
+			name = n
+		}
+
+		resp.Sessions = purgeChatSessions(func(s *chatSession) bool { return s.model == name.String() })
+
+		// the caches are kept by the model's weights, which are gone if
+		// it was deleted
+		if m, err := GetModel(name.String()); err == nil {
+			resp.PromptCacheEntries = promptTokens.purge(m.ModelPath) + summaries.purge(m.ModelPath)
+			resp.CacheSlots = s.purgeRunners(c.Request.Context(), m.ModelPath)
+		}
+	}
+
+	slog.Info("purged sessions and caches", "model", req.Model, "all", req.All, "sessions", resp.Sessions, "prompt_cache_entries", resp.PromptCacheEntries, "cache_slots", resp.CacheSlots)
+	c.JSON(http.StatusOK, resp)
+}
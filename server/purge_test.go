@@ -0,0 +1,126 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/types/model"
+)
+
+func TestPurgeHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	s := Server{sched: newMockScheduler(t, &mockRunner{})}
+	createMockModel(t, &s, "test", `{{ .Prompt }}`)
+
+	m, err := GetModel("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	all := func(*chatSession) bool { return true }
+	t.Cleanup(func() {
+		purgeChatSessions(all)
+		promptTokens.purge("")
+	})
+
+	runner := &mockLlm{}
+	s.sched.loaded[m.ModelPath] = &runnerRef{llama: runner, modelPath: m.ModelPath}
+
+	setup := func() (*chatSession, *chatSession) {
+		t.Helper()
+		purgeChatSessions(all)
+		promptTokens.purge("")
+
+		a, err := newChatSession(model.ParseName("test"), api.SessionRequest{Memory: "alice"}, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b, err := newChatSession(model.ParseName("test"), api.SessionRequest{}, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		addChatSession(&chatSession{id: "other", model: "other", created: time.Now(), expires: time.Now().Add(time.Hour)})
+		promptTokens.put(promptCacheEntry{key: [32]byte{1}, model: m.ModelPath, used: time.Now()})
+		promptTokens.put(promptCacheEntry{key: [32]byte{2}, model: "other", used: time.Now()})
+		return a, b
+	}
+
+	purge := func(req api.PurgeRequest) api.PurgeResponse {
+		t.Helper()
+		w := createRequest(t, s.PurgeHandler, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+
+		var resp api.PurgeResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	a, b := setup()
+	if resp := purge(api.PurgeRequest{Key: "alice"}); resp != (api.PurgeResponse{Sessions: 1}) {
+		t.Errorf("unexpected response %+v", resp)
+	}
+	if _, err := getChatSession(a.id, ""); err == nil {
+		t.Error("expected the session with the memory key to be purged")
+	}
+
+	if resp := purge(api.PurgeRequest{Key: b.id}); resp != (api.PurgeResponse{Sessions: 1}) {
+		t.Errorf("unexpected response %+v", resp)
+	}
+
+	setup()
+	if resp := purge(api.PurgeRequest{Model: "test"}); resp != (api.PurgeResponse{Sessions: 2, PromptCacheEntries: 1, CacheSlots: 1}) {
+		t.Errorf("unexpected response %+v", resp)
+	}
+	if _, err := getChatSession("other", ""); err != nil {
+		t.Errorf("expected the session of another model to be kept, got %v", err)
+	}
+
+	setup()
+	if resp := purge(api.PurgeRequest{All: true}); resp != (api.PurgeResponse{Sessions: 3, PromptCacheEntries: 2, CacheSlots: 1}) {
+		t.Errorf("unexpected response %+v", resp)
+	}
+
+	if runner.purged != 2 {
+		t.Errorf("expected the runner's cache to be purged twice, got %d", runner.purged)
+	}
+
+	for _, req := range []api.PurgeRequest{{}, {Model: "test", All: true}} {
+		if w := createRequest(t, s.PurgeHandler, req); w.Code != http.StatusBadRequest {
+			t.Errorf("%+v: expected status 400, got %d", req, w.Code)
+		}
+	}
+}
+
+func TestPromptCacheExpire(t *testing.T) {
+	c := newPromptCache(4)
+	c.put(promptCacheEntry{key: [32]byte{1}, used: time.Now().Add(-time.Hour)})
+	c.put(promptCacheEntry{key: [32]byte{2}, used: time.Now()})
+
+	if n := c.expire(time.Minute); n != 1 {
+		t.Errorf("expected 1 expired entry, got %d", n)
+	}
+
+	if _, ok := c.entries[[32]byte{2}]; !ok || c.order.Len() != 1 {
+		t.Error("expected the entry used recently to be kept")
+	}
+
+	// entries that expired aren't reused even before they're removed
+	t.Setenv("OLLAMA_CACHE_TTL", "1m")
+	c.put(promptCacheEntry{key: [32]byte{3}, used: time.Now().Add(-time.Hour)})
+	if _, ok := c.prefix([][32]byte{{3}}, nil); ok {
+		t.Error("expected an expired entry not to be reused")
+	}
+}
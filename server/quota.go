@@ -0,0 +1,338 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/openai"
+)
+
+// quotaPaths are the endpoints that generate tokens, which clients over
+// their quota are refused
+var quotaPaths = []string{
+	"/api/generate",
+	"/api/chat",
+	"/api/ensemble",
+	"/api/batch",
+	"/api/agent",
+	"/api/embed",
+	"/api/embeddings",
+	"/api/rerank",
+	"/v1/chat/completions",
+	"/v1/completions",
+	"/v1/embeddings",
+}
+
+var errQuotaExceeded = errors.New("token quota exceeded, please try again later")
+
+// tokenQuota bounds the tokens a client uses in each minute and day, or 0
+// for no limit
+type tokenQuota struct {
+	minute, day int
+}
+
+// parseTokenQuotas parses semicolon separated quotas of the form
+// "client:key=value,...", e.g. "*:minute=10000,day=1000000". Clients are
+// named as in usage responses, and "*" is the quota of every client
+// without its own. Keys are minute and day, the tokens allowed per minute
+// and per day.
+func parseTokenQuotas(s string) (map[string]tokenQuota, error) {
+	quotas := make(map[string]tokenQuota)
+	for _, entry := range strings.Split(s, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		client, settings, ok := strings.Cut(entry, ":")
+		client = strings.TrimSpace(client)
+		if !ok || client == "" {
+			return nil, fmt.Errorf("invalid token quota %q: expected client:key=value", entry)
+		}
+
+		var q tokenQuota
+		for _, setting := range strings.Split(settings, ",") {
+			key, value, ok := strings.Cut(strings.TrimSpace(setting), "=")
+			if !ok {
+				return nil, fmt.Errorf("invalid token quota %q: expected key=value, got %q", entry, setting)
+			}
+
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid token quota %q: invalid %s %q", entry, key, value)
+			}
+
+			switch key {
+			case "minute":
+				q.minute = n
+			case "day":
+				q.day = n
+			default:
+				return nil, fmt.Errorf("invalid token quota %q: unknown key %q", entry, key)
+			}
+		}
+
+		quotas[client] = q
+	}
+
+	return quotas, nil
+}
+
+// clientUsage is the tokens a client has used, in the current minute and
+// day and since the server started by model
+type clientUsage struct {
+	minute, day             time.Time
+	minuteTokens, dayTokens int
+	models                  map[string]*api.ModelUsage
+}
+
+// quotaTracker accounts the tokens each client uses and enforces their
+// quotas. Minutes and days start on the minute and at midnight UTC, when
+// their count is reset.
+type quotaTracker struct {
+	quotas map[string]tokenQuota
+	now    func() time.Time
+
+	mu      sync.Mutex
+	clients map[string]*clientUsage
+}
+
+func newQuotaTracker(quotas map[string]tokenQuota) *quotaTracker {
+	return &quotaTracker{quotas: quotas, now: time.Now, clients: make(map[string]*clientUsage)}
+}
+
+// tokenQuotas returns a tracker of the quotas configured with
+// OLLAMA_TOKEN_QUOTAS
+func tokenQuotas() *quotaTracker {
+	quotas, err := parseTokenQuotas(envconfig.TokenQuotas())
+	if err != nil {
+		slog.Warn("ignoring OLLAMA_TOKEN_QUOTAS", "error", err)
+		quotas = nil
+	}
+
+	return newQuotaTracker(quotas)
+}
+
+func (q *quotaTracker) quota(client string) tokenQuota {
+	if quota, ok := q.quotas[client]; ok {
+		return quota
+	}
+
+	return q.quotas["*"]
+}
+
+// usage returns the usage of client in the current minute and day. q.mu must
+// be held.
+func (q *quotaTracker) usage(client string) *clientUsage {
+	u, ok := q.clients[client]
+	if !ok {
+		u = &clientUsage{models: make(map[string]*api.ModelUsage)}
+		q.clients[client] = u
+	}
+
+	now := q.now().UTC()
+	if minute := now.Truncate(time.Minute); !minute.Equal(u.minute) {
+		u.minute, u.minuteTokens = minute, 0
+	}
+
+	if day := now.Truncate(24 * time.Hour); !day.Equal(u.day) {
+		u.day, u.dayTokens = day, 0
+	}
+
+	return u
+}
+
+// check returns false and how long to wait before retrying if client has
+// used its quota. Requests in flight when the quota is reached are still
+// counted, so a client can go over its quota by as much as they use.
+func (q *quotaTracker) check(client string) (bool, time.Duration) {
+	quota := q.quota(client)
+	if quota.minute == 0 && quota.day == 0 {
+		return true, 0
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	u := q.usage(client)
+	now := q.now()
+	switch {
+	case quota.day > 0 && u.dayTokens >= quota.day:
+		return false, u.day.Add(24 * time.Hour).Sub(now)
+	case quota.minute > 0 && u.minuteTokens >= quota.minute:
+		return false, u.minute.Add(time.Minute).Sub(now)
+	}
+
+	return true, 0
+}
+
+// record adds the tokens of a request to model to the usage of client
+func (q *quotaTracker) record(client, model string, prompt, completion int) {
+	if q == nil {
+		return
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	u := q.usage(client)
+	u.minuteTokens += prompt + completion
+	u.dayTokens += prompt + completion
+
+	m, ok := u.models[model]
+	if !ok {
+		m = &api.ModelUsage{Model: model}
+		u.models[model] = m
+	}
+
+	m.Requests++
+	m.PromptTokens += prompt
+	m.CompletionTokens += completion
+}
+
+// response returns the usage of client, the client of the request, or of
+// every client if all is set
+func (q *quotaTracker) response(client string, all bool) api.UsageResponse {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	resp := api.UsageResponse{Client: client, Clients: []api.ClientUsage{}}
+	for _, name := range slices.Sorted(maps.Keys(q.clients)) {
+		if !all && name != client {
+			continue
+		}
+
+		u := q.usage(name)
+		quota := q.quota(name)
+
+		models := make([]api.ModelUsage, 0, len(u.models))
+		for _, m := range u.models {
+			models = append(models, *m)
+		}
+		slices.SortFunc(models, func(a, b api.ModelUsage) int { return cmp.Compare(a.Model, b.Model) })
+
+		resp.Clients = append(resp.Clients, api.ClientUsage{
+			Client:       name,
+			MinuteTokens: u.minuteTokens,
+			MinuteQuota:  quota.minute,
+			DayTokens:    u.dayTokens,
+			DayQuota:     quota.day,
+			Models:       models,
+		})
+	}
+
+	return resp
+}
+
+type quotaClientKey struct{}
+
+// withQuotaClient returns a context whose completions count towards the quota
+// of client
+func withQuotaClient(ctx context.Context, client string) context.Context {
+	return context.WithValue(ctx, quotaClientKey{}, client)
+}
+
+// quotaClient returns the client whose quota the completions of ctx count
+// towards. Completions the server runs on its own, such as shadow requests,
+// don't have one.
+func quotaClient(ctx context.Context) (string, bool) {
+	client, ok := ctx.Value(quotaClientKey{}).(string)
+	return client, ok
+}
+
+// checkQuota returns errQuotaExceeded if the client of ctx has used its
+// quota, so requests that run many completions stop once it's reached
+func (s *Server) checkQuota(ctx context.Context) error {
+	client, ok := quotaClient(ctx)
+	if !ok || s.quotas == nil {
+		return nil
+	}
+
+	if ok, _ := s.quotas.check(client); !ok {
+		return errQuotaExceeded
+	}
+
+	return nil
+}
+
+// recordUsage adds the tokens of a completion of model to the usage of the
+// client of ctx
+func (s *Server) recordUsage(ctx context.Context, model string, prompt, completion int) {
+	if client, ok := quotaClient(ctx); ok {
+		s.quotas.record(client, model, prompt, completion)
+	}
+}
+
+// clientOf returns the name of the client of a request: a digest of its API
+// key, so keys aren't revealed in usage responses, or its address if API
+// keys aren't required. Keys that haven't been checked and forwarded
+// addresses from proxies that aren't trusted are ignored, so clients can't
+// change them to get another quota.
+func clientOf(c *gin.Context) string {
+	if key, ok := keyOf(c); ok {
+		return "key-" + key.digest[:12]
+	}
+
+	if len(envconfig.TrustedProxies()) > 0 {
+		return cmp.Or(c.ClientIP(), "local")
+	}
+
+	return cmp.Or(c.RemoteIP(), "local")
+}
+
+// quotaMiddleware refuses requests to endpoints that generate tokens from
+// clients that have used their quota with 429 Too Many Requests. Requests to
+// the OpenAI compatible endpoints receive OpenAI formatted errors. The
+// client is set on the context of other requests, so every completion they
+// run is counted with recordUsage.
+func quotaMiddleware(q *quotaTracker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		path := c.Request.URL.Path
+		if !slices.Contains(quotaPaths, path) {
+			c.Next()
+			return
+		}
+
+		client := clientOf(c)
+		ok, retryAfter := q.check(client)
+		if !ok {
+			msg := errQuotaExceeded.Error()
+			c.Header("Retry-After", strconv.FormatInt(int64(math.Ceil(retryAfter.Seconds())), 10))
+			if strings.HasPrefix(path, "/v1/") {
+				c.AbortWithStatusJSON(http.StatusTooManyRequests, openai.NewError(http.StatusTooManyRequests, msg))
+			} else {
+				c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": msg})
+			}
+			return
+		}
+
+		c.Request = c.Request.WithContext(withQuotaClient(c.Request.Context(), client))
+		c.Next()
+	}
+}
+
+func (s *Server) UsageHandler(c *gin.Context) {
+	q := s.quotas
+	if q == nil {
+		q = newQuotaTracker(nil)
+	}
+
+	// only admins see the usage of other clients
+	key, ok := keyOf(c)
+	c.JSON(http.StatusOK, q.response(clientOf(c), !ok || key.allows(scopeAdmin)))
+}
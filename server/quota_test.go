@@ -0,0 +1,290 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+)
+
+func TestParseTokenQuotas(t *testing.T) {
+	cases := []struct {
+		in   string
+		want map[string]tokenQuota
+		err  bool
+	}{
+		{in: "", want: map[string]tokenQuota{}},
+		{
+			in: "*:minute=1000,day=50000; key-0123456789ab:day=0",
+			want: map[string]tokenQuota{
+				"*":                {minute: 1000, day: 50000},
+				"key-0123456789ab": {},
+			},
+		},
+		{in: "*", err: true},
+		{in: ":day=1", err: true},
+		{in: "*:minute=-1", err: true},
+		{in: "*:hour=1", err: true},
+		{in: "*:day", err: true},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseTokenQuotas(tt.in)
+			if tt.err != (err != nil) {
+				t.Fatalf("unexpected error %v", err)
+			}
+
+			if diff := cmp.Diff(tt.want, got, cmp.AllowUnexported(tokenQuota{})); !tt.err && diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestQuotaTracker(t *testing.T) {
+	now := time.Date(2024, 5, 1, 23, 59, 30, 0, time.UTC)
+	q := newQuotaTracker(map[string]tokenQuota{"*": {minute: 100, day: 250}, "unlimited": {}})
+	q.now = func() time.Time { return now }
+
+	q.record("a", "llama", 60, 40)
+	if ok, retryAfter := q.check("a"); ok || retryAfter != 30*time.Second {
+		t.Errorf("expected the minute's quota to be used until the next minute, got %t and %s", ok, retryAfter)
+	}
+
+	if ok, _ := q.check("b"); !ok {
+		t.Error("expected quotas to be per client")
+	}
+
+	q.record("unlimited", "llama", 1000, 0)
+	if ok, _ := q.check("unlimited"); !ok {
+		t.Error("expected a client's own quota to override the default")
+	}
+
+	now = now.Add(time.Minute)
+	if ok, _ := q.check("a"); !ok {
+		t.Error("expected the minute's count to be reset")
+	}
+
+	// the day started at midnight with the last minute
+	q.record("a", "llama", 100, 0)
+	q.record("a", "qwen", 100, 50)
+	if ok, retryAfter := q.check("a"); ok || retryAfter != 23*time.Hour+59*time.Minute+30*time.Second {
+		t.Errorf("expected the day's quota to be used until the next day, got %t and %s", ok, retryAfter)
+	}
+
+	got := q.response("a", true)
+	want := api.UsageResponse{
+		Client: "a",
+		Clients: []api.ClientUsage{
+			{
+				Client:       "a",
+				MinuteTokens: 250,
+				MinuteQuota:  100,
+				DayTokens:    250,
+				DayQuota:     250,
+				Models: []api.ModelUsage{
+					{Model: "llama", Requests: 2, PromptTokens: 160, CompletionTokens: 40},
+					{Model: "qwen", Requests: 1, PromptTokens: 100, CompletionTokens: 50},
+				},
+			},
+			{Client: "b", MinuteQuota: 100, DayQuota: 250, Models: []api.ModelUsage{}},
+			{
+				Client:       "unlimited",
+				MinuteTokens: 0,
+				DayTokens:    0,
+				Models:       []api.ModelUsage{{Model: "llama", Requests: 1, PromptTokens: 1000}},
+			},
+		},
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("usage mismatch (-want +got):\n%s", diff)
+	}
+
+	if got := q.response("b", false); len(got.Clients) != 1 || got.Clients[0].Client != "b" {
+		t.Errorf("expected only the usage of the client, got %+v", got.Clients)
+	}
+}
+
+func TestQuotaMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("OLLAMA_TOKEN_QUOTAS", "*:minute=5")
+
+	path := filepath.Join(t.TempDir(), "keys.json")
+	if err := os.WriteFile(path, []byte(`[
+		{"key": "first", "scopes": ["generate"]},
+		{"key": "second", "scopes": ["generate"]}
+	]`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("OLLAMA_API_KEYS", "admin")
+	t.Setenv("OLLAMA_API_KEYS_FILE", path)
+
+	mock := mockRunner{CompletionFn: func(_ context.Context, r llm.CompletionRequest, fn func(llm.CompletionResponse)) error {
+		fn(llm.CompletionResponse{Content: "hi", Done: true, DoneReason: "stop", PromptEvalCount: 3, EvalCount: 2})
+		return nil
+	}}
+
+	s := Server{sched: newMockScheduler(t, &mock)}
+	createMockModel(t, &s, "test", `{{ .Prompt }}`)
+
+	srv := httptest.NewServer(s.GenerateRoutes())
+	defer srv.Close()
+
+	chat := func(srv *httptest.Server, key, path, body string, header ...string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodPost, srv.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req.Header.Set("Authorization", "Bearer "+key)
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	body := `{"model": "test", "messages": [{"role": "user", "content": "hello"}], "stream": false}`
+	if resp := chat(srv, "first", "/api/chat", body); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.StatusCode)
+	}
+
+	for _, path := range []string{"/api/chat", "/v1/chat/completions"} {
+		resp := chat(srv, "first", path, body)
+		if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") == "" {
+			t.Errorf("%s: expected status 429 with Retry-After, got %d", path, resp.StatusCode)
+		}
+	}
+
+	if resp := chat(srv, "second", "/api/chat", body); resp.StatusCode != http.StatusOK {
+		t.Errorf("expected another client to be allowed, got %d", resp.StatusCode)
+	}
+
+	t.Setenv("OLLAMA_HOST", srv.URL)
+	usage := func(key string) api.UsageResponse {
+		t.Helper()
+		t.Setenv("OLLAMA_CLIENT_KEY", key)
+		client, err := api.ClientFromEnvironment()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		usage, err := client.Usage(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return *usage
+	}
+
+	all := usage("admin")
+	if len(all.Clients) != 2 {
+		t.Fatalf("expected 2 clients, got %+v", all.Clients)
+	}
+
+	for _, c := range all.Clients {
+		if !strings.HasPrefix(c.Client, "key-") || strings.Contains(c.Client, "first") || c.MinuteTokens != 5 || c.MinuteQuota != 5 {
+			t.Errorf("unexpected usage %+v", c)
+		}
+	}
+
+	if own := usage("second"); len(own.Clients) != 1 || own.Clients[0].Client != own.Client {
+		t.Errorf("expected only the usage of the client, got %+v", own)
+	}
+
+	// without API keys, clients are their address, whatever key or
+	// forwarded address they send
+	t.Setenv("OLLAMA_API_KEYS", "")
+	t.Setenv("OLLAMA_API_KEYS_FILE", "")
+	open := httptest.NewServer(s.GenerateRoutes())
+	defer open.Close()
+
+	if resp := chat(open, "first", "/api/chat", body); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.StatusCode)
+	}
+
+	if resp := chat(open, "other", "/api/chat", body, "X-Forwarded-For", "10.0.0.1"); resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("expected another key and forwarded address to share the quota, got %d", resp.StatusCode)
+	}
+}
+
+func TestQuotaBatch(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("OLLAMA_TOKEN_QUOTAS", "*:minute=5")
+	t.Setenv("OLLAMA_API_KEYS", "")
+	t.Setenv("OLLAMA_API_KEYS_FILE", "")
+
+	mock := mockRunner{CompletionFn: func(_ context.Context, r llm.CompletionRequest, fn func(llm.CompletionResponse)) error {
+		fn(llm.CompletionResponse{Content: "hi", Done: true, DoneReason: "stop", PromptEvalCount: 3, EvalCount: 2})
+		return nil
+	}}
+
+	s := Server{sched: newMockScheduler(t, &mock)}
+	createMockModel(t, &s, "test", `{{ .Prompt }}`)
+
+	srv := httptest.NewServer(s.GenerateRoutes())
+	defer srv.Close()
+
+	post := func(path, body string) *http.Response {
+		t.Helper()
+		resp, err := http.Post(srv.URL+path, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	resp := post("/api/batch", `{"model": "test", "requests": [{"prompt": "one"}], "stream": false}`)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.StatusCode)
+	}
+
+	usage := s.quotas.response("", true)
+	if len(usage.Clients) != 1 || usage.Clients[0].MinuteTokens != 5 {
+		t.Fatalf("expected the batch to use 5 tokens, got %+v", usage.Clients)
+	}
+
+	if resp := post("/api/batch", `{"model": "test", "requests": [{"prompt": "two"}], "stream": false}`); resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("expected status 429, got %d", resp.StatusCode)
+	}
+
+	if resp := post("/api/chat", `{"model": "test", "messages": [{"role": "user", "content": "hello"}], "stream": false}`); resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("expected status 429, got %d", resp.StatusCode)
+	}
+
+	// completions the server runs on its own aren't counted
+	if _, err := s.generateCompletion(context.Background(), "test", "three", "", nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// and a client's completions stop once its quota is used
+	ctx := withQuotaClient(context.Background(), usage.Clients[0].Client)
+	if _, err := s.generateCompletion(ctx, "test", "four", "", nil, nil, nil); !errors.Is(err, errQuotaExceeded) {
+		t.Errorf("expected %v, got %v", errQuotaExceeded, err)
+	}
+
+	if usage := s.quotas.response("", true); usage.Clients[0].MinuteTokens != 5 {
+		t.Errorf("expected 5 tokens used, got %d", usage.Clients[0].MinuteTokens)
+	}
+}
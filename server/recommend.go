@@ -0,0 +1,201 @@
+package server
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/discover"
+	"github.com/ollama/ollama/format"
+)
+
+// recommendVariant is a tag of a model in the library quantized to quantization
+type recommendVariant struct {
+	tag          string
+	quantization string
+}
+
+// recommendModel is a model in the library that may be recommended for
+// capability. Variants are ordered from highest to lowest quality.
+type recommendModel struct {
+	capability string
+	parameters uint64
+	variants   []recommendVariant
+}
+
+func instruct(q4, q8 string) []recommendVariant {
+	return []recommendVariant{{q8, "Q8_0"}, {q4, "Q4_K_M"}}
+}
+
+var recommendCatalog = []recommendModel{
+	{"chat", 1_240_000_000, instruct("llama3.2:1b", "llama3.2:1b-instruct-q8_0")},
+	{"chat", 3_210_000_000, instruct("llama3.2:3b", "llama3.2:3b-instruct-q8_0")},
+	{"chat", 8_030_000_000, instruct("llama3.1:8b", "llama3.1:8b-instruct-q8_0")},
+	{"chat", 9_240_000_000, instruct("gemma2:9b", "gemma2:9b-instruct-q8_0")},
+	{"chat", 14_800_000_000, instruct("qwen2.5:14b", "qwen2.5:14b-instruct-q8_0")},
+	{"chat", 27_200_000_000, instruct("gemma2:27b", "gemma2:27b-instruct-q8_0")},
+	{"chat", 32_800_000_000, instruct("qwen2.5:32b", "qwen2.5:32b-instruct-q8_0")},
+	{"chat", 70_600_000_000, instruct("llama3.3:70b", "llama3.3:70b-instruct-q8_0")},
+
+	{"code", 1_540_000_000, instruct("qwen2.5-coder:1.5b", "qwen2.5-coder:1.5b-instruct-q8_0")},
+	{"code", 3_090_000_000, instruct("qwen2.5-coder:3b", "qwen2.5-coder:3b-instruct-q8_0")},
+	{"code", 7_620_000_000, instruct("qwen2.5-coder:7b", "qwen2.5-coder:7b-instruct-q8_0")},
+	{"code", 14_800_000_000, instruct("qwen2.5-coder:14b", "qwen2.5-coder:14b-instruct-q8_0")},
+	{"code", 32_800_000_000, instruct("qwen2.5-coder:32b", "qwen2.5-coder:32b-instruct-q8_0")},
+
+	{"vision", 7_240_000_000, []recommendVariant{{"llava:7b", "Q4_0"}}},
+	{"vision", 10_700_000_000, []recommendVariant{{"llama3.2-vision:11b", "Q4_K_M"}}},
+	{"vision", 13_400_000_000, []recommendVariant{{"llava:13b", "Q4_0"}}},
+	{"vision", 34_800_000_000, []recommendVariant{{"llava:34b", "Q4_0"}}},
+
+	{"embedding", 23_000_000, []recommendVariant{{"all-minilm", "F16"}}},
+	{"embedding", 137_000_000, []recommendVariant{{"nomic-embed-text", "F16"}}},
+	{"embedding", 335_000_000, []recommendVariant{{"mxbai-embed-large", "F16"}}},
+	{"embedding", 567_000_000, []recommendVariant{{"bge-m3", "F16"}}},
+}
+
+// bitsPerWeight is the average size of a weight for each quantization
+var bitsPerWeight = map[string]float64{
+	"F16":    16,
+	"Q8_0":   8.5,
+	"Q4_K_M": 4.85,
+	"Q4_0":   4.5,
+}
+
+// estimateSize estimates the memory needed to run a model with parameters
+// weights quantized to quantization at the default context length. The
+// overhead covers the KV cache, compute graph and runtime.
+func estimateSize(parameters uint64, quantization string) uint64 {
+	weights := uint64(float64(parameters) * bitsPerWeight[quantization] / 8)
+	return weights + weights/5 + 512*format.MebiByte
+}
+
+// availableVRAM returns the memory of the largest group of GPUs a single
+// model may be split across, or 0 if there are no GPUs
+func availableVRAM(gpus discover.GpuInfoList) uint64 {
+	var vram uint64
+	for _, group := range gpus.ByLibrary() {
+		var total uint64
+		for _, g := range group {
+			if g.Library == "cpu" {
+				continue
+			}
+
+			total += g.TotalMemory - min(g.TotalMemory, g.MinimumMemory)
+		}
+
+		vram = max(vram, total)
+	}
+
+	return vram
+}
+
+var errUnknownCapability = errors.New("unknown capability")
+
+// recommend returns the models for capability that fit in vram bytes of GPU
+// memory and ram bytes of system memory. Models that fit entirely on the GPU
+// are preferred, then larger models. A quarter of system memory is left for
+// the rest of the system.
+func recommend(capability string, vram, ram uint64) ([]api.Recommendation, error) {
+	switch capability {
+	case "":
+		capability = "chat"
+	case "embeddings":
+		capability = "embedding"
+	case "chat", "code", "vision", "embedding":
+	default:
+		return nil, fmt.Errorf("%w %q: must be one of chat, code, vision or embedding", errUnknownCapability, capability)
+	}
+
+	usable := ram / 4 * 3
+
+	recommendations := []api.Recommendation{}
+	for _, m := range recommendCatalog {
+		if m.capability != capability {
+			continue
+		}
+
+		var best *api.Recommendation
+		for _, v := range m.variants {
+			r := api.Recommendation{
+				Model:         v.tag,
+				Quantization:  v.quantization,
+				ParameterSize: format.HumanNumber(m.parameters),
+				Size:          estimateSize(m.parameters, v.quantization),
+			}
+
+			switch {
+			case r.Size <= vram:
+				r.Processor = "gpu"
+			case vram > 0 && r.Size <= vram+usable:
+				r.Processor = "gpu/cpu"
+			case vram == 0 && r.Size <= usable:
+				r.Processor = "cpu"
+			default:
+				continue
+			}
+
+			// prefer the highest quality variant that fits on the GPU,
+			// otherwise the smallest since it will run faster
+			if best == nil || (best.Processor != "gpu" && (r.Processor == "gpu" || r.Size < best.Size)) {
+				best = &r
+			}
+		}
+
+		if best != nil {
+			recommendations = append(recommendations, *best)
+		}
+	}
+
+	slices.SortStableFunc(recommendations, func(a, b api.Recommendation) int {
+		if a.Processor == "gpu" != (b.Processor == "gpu") {
+			if a.Processor == "gpu" {
+				return -1
+			}
+			return 1
+		}
+
+		return cmp.Compare(b.Size, a.Size)
+	})
+
+	return recommendations, nil
+}
+
+// RecommendHandler suggests models for a capability based on the memory of
+// the detected GPUs and system
+func (s *Server) RecommendHandler(c *gin.Context) {
+	var req api.RecommendRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	gpus := s.sched.getGpuFn()
+	gpuReserveFromEnv().apply(gpus)
+
+	resp := api.RecommendResponse{
+		VRAM:    availableVRAM(gpus),
+		Threads: runtime.NumCPU(),
+	}
+
+	if cpus := s.sched.getCpuFn(); len(cpus) > 0 {
+		resp.Memory = cpus[0].TotalMemory
+	}
+
+	models, err := recommend(strings.ToLower(req.Capability), resp.VRAM, resp.Memory)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp.Models = models
+	c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/discover"
+	"github.com/ollama/ollama/format"
+)
+
+func TestRecommend(t *testing.T) {
+	cases := []struct {
+		name       string
+		capability string
+		vram, ram  uint64
+		want       []string
+		processors []string
+	}{
+		{
+			name: "cpu only",
+			ram:  8 * format.GibiByte,
+			// smaller variants are preferred on the cpu
+			want:       []string{"llama3.1:8b", "llama3.2:3b", "llama3.2:1b"},
+			processors: []string{"cpu", "cpu", "cpu"},
+		},
+		{
+			name:       "small gpu",
+			vram:       6 * format.GibiByte,
+			ram:        16 * format.GibiByte,
+			want:       []string{"llama3.1:8b", "llama3.2:3b-instruct-q8_0", "llama3.2:1b-instruct-q8_0", "qwen2.5:14b", "gemma2:9b"},
+			processors: []string{"gpu", "gpu", "gpu", "gpu/cpu", "gpu/cpu"},
+		},
+		{
+			name:       "code",
+			capability: "code",
+			vram:       24 * format.GibiByte,
+			ram:        32 * format.GibiByte,
+			want:       []string{"qwen2.5-coder:32b", "qwen2.5-coder:14b-instruct-q8_0", "qwen2.5-coder:7b-instruct-q8_0", "qwen2.5-coder:3b-instruct-q8_0", "qwen2.5-coder:1.5b-instruct-q8_0"},
+			processors: []string{"gpu", "gpu", "gpu", "gpu", "gpu"},
+		},
+		{
+			name:       "embeddings",
+			capability: "embeddings",
+			ram:        4 * format.GibiByte,
+			want:       []string{"bge-m3", "mxbai-embed-large", "nomic-embed-text", "all-minilm"},
+			processors: []string{"cpu", "cpu", "cpu", "cpu"},
+		},
+		{
+			name:       "nothing fits",
+			capability: "vision",
+			ram:        2 * format.GibiByte,
+			want:       []string{},
+			processors: []string{},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := recommend(tt.capability, tt.vram, tt.ram)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			models := []string{}
+			processors := []string{}
+			for _, r := range got {
+				models = append(models, r.Model)
+				processors = append(processors, r.Processor)
+			}
+
+			if !slices.Equal(models, tt.want) {
+				t.Errorf("models = %v, want %v", models, tt.want)
+			}
+
+			if !slices.Equal(processors, tt.processors) {
+				t.Errorf("processors = %v, want %v", processors, tt.processors)
+			}
+		})
+	}
+
+	if _, err := recommend("audio", 0, format.GibiByte); !errors.Is(err, errUnknownCapability) {
+		t.Errorf("expected %v, got %v", errUnknownCapability, err)
+	}
+}
+
+func TestRecommendHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	s := Server{
+		sched: &Scheduler{
+			getGpuFn: func() discover.GpuInfoList {
+				g := discover.GpuInfo{Library: "cuda", MinimumMemory: 512 * format.MebiByte}
+				g.TotalMemory = 8*format.GibiByte + 512*format.MebiByte
+				return discover.GpuInfoList{g, g}
+			},
+			getCpuFn: func() discover.GpuInfoList {
+				g := discover.GpuInfo{Library: "cpu"}
+				g.TotalMemory = 32 * format.GibiByte
+				return discover.GpuInfoList{g}
+			},
+		},
+	}
+
+	w := createRequest(t, s.RecommendHandler, api.RecommendRequest{Capability: "Vision"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp api.RecommendResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.VRAM != 16*format.GibiByte {
+		t.Errorf("vram = %d, want %d", resp.VRAM, 16*format.GibiByte)
+	}
+
+	if resp.Memory != 32*format.GibiByte {
+		t.Errorf("memory = %d, want %d", resp.Memory, 32*format.GibiByte)
+	}
+
+	if len(resp.Models) == 0 || resp.Models[0].Model != "llava:13b" || resp.Models[0].Processor != "gpu" {
+		t.Errorf("unexpected recommendations %+v", resp.Models)
+	}
+
+	w = createRequest(t, s.RecommendHandler, api.RecommendRequest{Capability: "audio"})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", w.Code)
+	}
+}
@@ -0,0 +1,195 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"regexp/syntax"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// regexGrammar returns a GBNF grammar whose root rule matches the whole of a
+// response if and only if the regular expression does. Anchors are allowed
+// but have no effect since the whole response always has to match.
+func regexGrammar(pattern string) (string, error) {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	sb.WriteString("root ::= ")
+	if err := writeRegex(&sb, re); err != nil {
+		return "", err
+	}
+
+	return sb.String(), nil
+}
+
+func writeRegex(sb *strings.Builder, re *syntax.Regexp) error {
+	switch re.Op {
+	case syntax.OpEmptyMatch, syntax.OpBeginLine, syntax.OpEndLine, syntax.OpBeginText, syntax.OpEndText:
+		sb.WriteString(`""`)
+	case syntax.OpLiteral:
+		if re.Flags&syntax.FoldCase == 0 {
+			writeLiteral(sb, re.Rune)
+			break
+		}
+
+		for i, r := range re.Rune {
+			if i > 0 {
+				sb.WriteByte(' ')
+			}
+
+			// match every case of the rune
+			folds := []rune{r}
+			for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+				folds = append(folds, f)
+			}
+
+			ranges := make([]rune, 0, 2*len(folds))
+			for _, f := range folds {
+				ranges = append(ranges, f, f)
+			}
+			writeClass(sb, ranges)
+		}
+	case syntax.OpCharClass:
+		if len(re.Rune) == 0 {
+			return errors.New("regex can't match anything")
+		}
+
+		writeClass(sb, re.Rune)
+	case syntax.OpAnyCharNotNL:
+		sb.WriteString(`[^\n]`)
+	case syntax.OpAnyChar:
+		sb.WriteByte('.')
+	case syntax.OpCapture:
+		return writeGroup(sb, re.Sub[0])
+	case syntax.OpStar, syntax.OpPlus, syntax.OpQuest:
+		if err := writeGroup(sb, re.Sub[0]); err != nil {
+			return err
+		}
+
+		sb.WriteString(map[syntax.Op]string{syntax.OpStar: "*", syntax.OpPlus: "+", syntax.OpQuest: "?"}[re.Op])
+	case syntax.OpRepeat:
+		if err := writeGroup(sb, re.Sub[0]); err != nil {
+			return err
+		}
+
+		switch {
+		case re.Max < 0:
+			fmt.Fprintf(sb, "{%d,}", re.Min)
+		case re.Min == re.Max:
+			fmt.Fprintf(sb, "{%d}", re.Min)
+		default:
+			fmt.Fprintf(sb, "{%d,%d}", re.Min, re.Max)
+		}
+	case syntax.OpConcat:
+		if len(re.Sub) == 0 {
+			sb.WriteString(`""`)
+		}
+
+		for i, sub := range re.Sub {
+			if i > 0 {
+				sb.WriteByte(' ')
+			}
+
+			if err := writeRegex(sb, sub); err != nil {
+				return err
+			}
+		}
+	case syntax.OpAlternate:
+		sb.WriteByte('(')
+		for i, sub := range re.Sub {
+			if i > 0 {
+				sb.WriteString(" | ")
+			}
+
+			if err := writeRegex(sb, sub); err != nil {
+				return err
+			}
+		}
+		sb.WriteByte(')')
+	case syntax.OpNoMatch:
+		return errors.New("regex can't match anything")
+	default:
+		return fmt.Errorf("regex %s isn't supported", re.Op)
+	}
+
+	return nil
+}
+
+func writeGroup(sb *strings.Builder, re *syntax.Regexp) error {
+	// alternations are already grouped
+	if re.Op == syntax.OpAlternate {
+		return writeRegex(sb, re)
+	}
+
+	sb.WriteByte('(')
+	if err := writeRegex(sb, re); err != nil {
+		return err
+	}
+	sb.WriteByte(')')
+	return nil
+}
+
+func writeLiteral(sb *strings.Builder, runes []rune) {
+	sb.WriteByte('"')
+	for _, r := range runes {
+		switch r {
+		case '"', '\\':
+			sb.WriteByte('\\')
+			sb.WriteRune(r)
+		default:
+			writeRune(sb, r)
+		}
+	}
+	sb.WriteByte('"')
+}
+
+// writeClass writes a character class of rune ranges, which are pairs of
+// the first and last runes of each range
+func writeClass(sb *strings.Builder, ranges []rune) {
+	sb.WriteByte('[')
+	for i := 0; i < len(ranges); i += 2 {
+		writeClassRune(sb, ranges[i])
+		if ranges[i+1] != ranges[i] {
+			sb.WriteByte('-')
+			writeClassRune(sb, ranges[i+1])
+		}
+	}
+	sb.WriteByte(']')
+}
+
+func writeClassRune(sb *strings.Builder, r rune) {
+	switch r {
+	case '[', ']', '\\':
+		sb.WriteByte('\\')
+		sb.WriteRune(r)
+	case '-', '^':
+		fmt.Fprintf(sb, `\x%02X`, r)
+	default:
+		writeRune(sb, r)
+	}
+}
+
+// writeRune writes a rune, escaping it unless it's printable
+func writeRune(sb *strings.Builder, r rune) {
+	switch {
+	case r == '\n':
+		sb.WriteString(`\n`)
+	case r == '\r':
+		sb.WriteString(`\r`)
+	case r == '\t':
+		sb.WriteString(`\t`)
+	case strconv.IsPrint(r):
+		sb.WriteRune(r)
+	case r < 0x100:
+		fmt.Fprintf(sb, `\x%02X`, r)
+	case r < 0x10000:
+		fmt.Fprintf(sb, `\u%04X`, r)
+	default:
+		fmt.Fprintf(sb, `\U%08X`, r)
+	}
+}
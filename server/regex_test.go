@@ -0,0 +1,48 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/ollama/ollama/llama"
+)
+
+func TestRegexGrammar(t *testing.T) {
+	cases := []struct {
+		pattern string
+		want    string
+	}{
+		{`abc`, `root ::= "abc"`},
+		{`^[0-9]{3}-[0-9]{4}$`, `root ::= "" ([0-9]){3} "-" ([0-9]){4} ""`},
+		{`(yes|no)`, `root ::= ("yes" | "no")`},
+		{`a+b*c?`, `root ::= ("a")+ ("b")* ("c")?`},
+		{`x{2,}y{1,3}`, `root ::= ("x"){2,} ("y"){1,3}`},
+		{`[^a-z]`, `root ::= [\x00-` + "`" + `{-\U0010FFFF]`},
+		{`(?i)no`, `root ::= [Nn] [Oo]`},
+		{`"\\.`, `root ::= "\"\\" [^\n]`},
+		{`[\-^\]]`, `root ::= [\x2D\]-\x5E]`},
+		{`SELECT \w+ FROM \w+;`, `root ::= "SELECT " ([0-9A-Z_a-z])+ " FROM " ([0-9A-Z_a-z])+ ";"`},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.pattern, func(t *testing.T) {
+			got, err := regexGrammar(tt.pattern)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != tt.want {
+				t.Errorf("got %s; want %s", got, tt.want)
+			}
+
+			if err := llama.ValidateGrammar(got); err != nil {
+				t.Errorf("invalid grammar %s: %v", got, err)
+			}
+		})
+	}
+
+	for _, pattern := range []string{`(`, `\bword\b`, `[^\x00-\x{10FFFF}]`} {
+		if _, err := regexGrammar(pattern); err == nil {
+			t.Errorf("%s: expected an error", pattern)
+		}
+	}
+}
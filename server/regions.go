@@ -0,0 +1,140 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	"image/png"
+	"math"
+
+	"github.com/ollama/ollama/api"
+)
+
+var errImageRegion = errors.New("invalid image region")
+
+// checkImageRegions checks the image regions of msgs are well formed and
+// refer to their messages' images. Whether regions fit in their images is
+// only known once the images are decoded.
+func checkImageRegions(msgs []api.Message) error {
+	for i, msg := range msgs {
+		seen := make(map[int]bool)
+		for _, region := range msg.ImageRegions {
+			if region.Image < 0 || region.Image >= len(msg.Images) {
+				return fmt.Errorf("%w: message %d has no image %d", errImageRegion, i, region.Image)
+			}
+
+			if seen[region.Image] {
+				return fmt.Errorf("%w: image %d of message %d has more than one region", errImageRegion, region.Image, i)
+			}
+			seen[region.Image] = true
+
+			if err := checkImageRegion(region); err != nil {
+				return fmt.Errorf("%w: image %d of message %d: %v", errImageRegion, region.Image, i, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func checkImageRegion(region api.ImageRegion) error {
+	switch {
+	case region.Crop != nil && region.Box != nil:
+		return errors.New("crop and box can't both be set")
+	case region.Crop != nil:
+		if len(region.Crop) != 4 {
+			return errors.New("crop must be [x, y, width, height]")
+		}
+
+		if region.Crop[0] < 0 || region.Crop[1] < 0 || region.Crop[2] <= 0 || region.Crop[3] <= 0 {
+			return errors.New("crop must have a positive size inside the image")
+		}
+	case region.Box != nil:
+		if len(region.Box) != 4 {
+			return errors.New("box must be [x0, y0, x1, y1]")
+		}
+
+		for _, v := range region.Box {
+			if v < 0 || v > 1 {
+				return errors.New("box coordinates must be between 0 and 1")
+			}
+		}
+
+		if region.Box[0] >= region.Box[2] || region.Box[1] >= region.Box[3] {
+			return errors.New("box must have a positive size")
+		}
+	default:
+		return errors.New("crop or box is required")
+	}
+
+	return nil
+}
+
+// regionImages returns the images of msg, with those that have a region
+// cropped to it
+func regionImages(msg api.Message) ([]api.ImageData, error) {
+	if len(msg.ImageRegions) == 0 {
+		return msg.Images, nil
+	}
+
+	images := make([]api.ImageData, len(msg.Images))
+	copy(images, msg.Images)
+	for _, region := range msg.ImageRegions {
+		if region.Image < 0 || region.Image >= len(images) {
+			return nil, fmt.Errorf("%w: no image %d", errImageRegion, region.Image)
+		}
+
+		cropped, err := cropImage(images[region.Image], region)
+		if err != nil {
+			return nil, fmt.Errorf("%w: image %d: %v", errImageRegion, region.Image, err)
+		}
+
+		images[region.Image] = cropped
+	}
+
+	return images, nil
+}
+
+// cropImage crops the image in data to region, returning the crop as a PNG
+// so it's preprocessed as any other image
+func cropImage(data []byte, region api.ImageRegion) ([]byte, error) {
+	if err := checkImageRegion(region); err != nil {
+		return nil, err
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	bounds := img.Bounds()
+	var r image.Rectangle
+	if region.Box != nil {
+		x := func(v float64) int { return bounds.Min.X + int(math.Round(v*float64(bounds.Dx()))) }
+		y := func(v float64) int { return bounds.Min.Y + int(math.Round(v*float64(bounds.Dy()))) }
+		r = image.Rect(x(region.Box[0]), y(region.Box[1]), x(region.Box[2]), y(region.Box[3]))
+	} else {
+		r = image.Rect(region.Crop[0], region.Crop[1], region.Crop[0]+region.Crop[2], region.Crop[1]+region.Crop[3]).Add(bounds.Min)
+	}
+
+	r = r.Intersect(bounds)
+	if r.Empty() {
+		return nil, fmt.Errorf("region is outside the %dx%d image", bounds.Dx(), bounds.Dy())
+	}
+
+	sub, ok := img.(interface {
+		SubImage(image.Rectangle) image.Image
+	})
+	if !ok {
+		return nil, errors.New("image can't be cropped")
+	}
+
+	var b bytes.Buffer
+	if err := png.Encode(&b, sub.SubImage(r)); err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
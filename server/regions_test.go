@@ -0,0 +1,149 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+)
+
+// testImage returns a PNG of a width by height image whose pixels' red and
+// green values are their x and y
+func testImage(t *testing.T, width, height int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := range width {
+		for y := range height {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 0, 255})
+		}
+	}
+
+	var b bytes.Buffer
+	if err := png.Encode(&b, img); err != nil {
+		t.Fatal(err)
+	}
+
+	return b.Bytes()
+}
+
+func TestCropImage(t *testing.T) {
+	data := testImage(t, 100, 50)
+
+	cases := []struct {
+		name   string
+		region api.ImageRegion
+		bounds image.Rectangle
+		err    bool
+	}{
+		{"crop", api.ImageRegion{Crop: []int{10, 20, 30, 10}}, image.Rect(10, 20, 40, 30), false},
+		{"crop past the edge", api.ImageRegion{Crop: []int{90, 40, 30, 30}}, image.Rect(90, 40, 100, 50), false},
+		{"box", api.ImageRegion{Box: []float64{0.5, 0, 1, 0.5}}, image.Rect(50, 0, 100, 25), false},
+		{"outside", api.ImageRegion{Crop: []int{100, 0, 10, 10}}, image.Rectangle{}, true},
+		{"both", api.ImageRegion{Crop: []int{0, 0, 10, 10}, Box: []float64{0, 0, 1, 1}}, image.Rectangle{}, true},
+		{"empty box", api.ImageRegion{Box: []float64{0.5, 0, 0.5, 1}}, image.Rectangle{}, true},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			cropped, err := cropImage(data, tt.region)
+			if tt.err {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			img, err := png.Decode(bytes.NewReader(cropped))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if img.Bounds().Size() != tt.bounds.Size() {
+				t.Errorf("expected a %v crop, got %v", tt.bounds.Size(), img.Bounds().Size())
+			}
+
+			// the first pixel of the crop is the region's top left corner
+			r, g, _, _ := img.At(img.Bounds().Min.X, img.Bounds().Min.Y).RGBA()
+			if int(r>>8) != tt.bounds.Min.X || int(g>>8) != tt.bounds.Min.Y {
+				t.Errorf("expected the crop to start at %v, got (%d,%d)", tt.bounds.Min, r>>8, g>>8)
+			}
+		})
+	}
+}
+
+func TestChatImageRegions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var images []llm.ImageData
+	mock := mockRunner{
+		CompletionFn: func(_ context.Context, r llm.CompletionRequest, fn func(llm.CompletionResponse)) error {
+			images = r.Images
+			fn(llm.CompletionResponse{Content: "A chart.", Done: true, DoneReason: "stop"})
+			return nil
+		},
+	}
+
+	s := Server{sched: newMockScheduler(t, &mock)}
+	createMockModel(t, &s, "test", `{{- range .Messages }}{{ .Role }}: {{ .Content }} {{ end }}`)
+
+	screenshot := testImage(t, 200, 100)
+	w := createRequest(t, s.ChatHandler, api.ChatRequest{
+		Model: "test",
+		Messages: []api.Message{{
+			Role:         "user",
+			Content:      "What's in the corner?",
+			Images:       []api.ImageData{screenshot, screenshot},
+			ImageRegions: []api.ImageRegion{{Image: 1, Box: []float64{0.75, 0.5, 1, 1}}},
+		}},
+		Stream: &stream,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+	}
+
+	if len(images) != 2 || !bytes.Equal(images[0].Data, screenshot) {
+		t.Fatalf("expected the first image to be sent as is, got %d images", len(images))
+	}
+
+	cropped, err := png.DecodeConfig(bytes.NewReader(images[1].Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cropped.Width != 50 || cropped.Height != 50 {
+		t.Errorf("expected a 50x50 crop, got %dx%d", cropped.Width, cropped.Height)
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, regions := range [][]api.ImageRegion{
+			{{Image: 2, Crop: []int{0, 0, 10, 10}}},
+			{{Image: 0, Crop: []int{0, 0, 10, 10}}, {Image: 0, Box: []float64{0, 0, 1, 1}}},
+			{{Image: 0}},
+		} {
+			w := createRequest(t, s.ChatHandler, api.ChatRequest{
+				Model:    "test",
+				Messages: []api.Message{{Role: "user", Images: []api.ImageData{screenshot}, ImageRegions: regions}},
+				Stream:   &stream,
+			})
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("expected status 400 for %v, got %d: %s", regions, w.Code, w.Body)
+			}
+		}
+
+		err := checkImageRegions([]api.Message{{Images: []api.ImageData{screenshot}, ImageRegions: []api.ImageRegion{{Box: []float64{0, 0, 2, 1}}}}})
+		if !errors.Is(err, errImageRegion) {
+			t.Errorf("expected errImageRegion, got %v", err)
+		}
+	})
+}
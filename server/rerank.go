@@ -0,0 +1,168 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/types/model"
+)
+
+// rerankSeparator returns the text between the query and document of the
+// inputs of a reranker, which is its end of sequence and separator tokens.
+// With the start and end tokens the runner adds, inputs are
+// "[BOS] query [EOS] [SEP] document [EOS]" as rerankers are trained with.
+func rerankSeparator(ctx context.Context, r llm.LlamaServer, kv llm.KV) (string, error) {
+	var tokens []int
+	for _, kind := range []string{"eos", "seperator"} {
+		if id, ok := kv.SpecialToken(kind); ok && !slices.Contains(tokens, id) {
+			tokens = append(tokens, id)
+		}
+	}
+
+	if len(tokens) == 0 {
+		return "", errors.New("reranker has no separator token")
+	}
+
+	return r.Detokenize(ctx, tokens)
+}
+
+// rank returns the relevance score of each input, from 0 to 1. Rerankers
+// return a logit in place of an embedding.
+func rank(ctx context.Context, r llm.LlamaServer, input []string, batches [][]int) ([]float64, error) {
+	scores := make([]float64, len(input))
+	err := forBatches(ctx, batches, func(ctx context.Context, i int) error {
+		score, err := r.Embedding(ctx, input[i])
+		if err != nil {
+			return err
+		}
+
+		if len(score) != 1 {
+			return fmt.Errorf("expected a score, got %d values", len(score))
+		}
+
+		scores[i] = 1 / (1 + math.Exp(-float64(score[0])))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return scores, nil
+}
+
+func (s *Server) RerankHandler(c *gin.Context) {
+	checkpointStart := time.Now()
+	var req api.RerankRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	switch {
+	case req.Query == "":
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "query is required"})
+		return
+	case req.TopN < 0:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "top_n must be positive"})
+		return
+	}
+
+	name, err := getExistingName(model.ParseName(req.Model))
+	if err != nil {
+		c.JSON(http.StatusNotFound, errorBody(api.ErrorCodeModelNotFound, fmt.Sprintf("model '%s' not found", req.Model)))
+		return
+	}
+
+	r, m, opts, err := s.scheduleRunner(c.Request.Context(), name.String(), []Capability{CapabilityRerank}, req.Options, req.KeepAlive)
+	if errors.Is(err, errCapabilityRerank) {
+		c.JSON(http.StatusBadRequest, errorBody(api.ErrorCodeUnsupportedCapability, fmt.Sprintf("%q does not support reranking", req.Model)))
+		return
+	} else if err != nil {
+		handleScheduleError(c, req.Model, err)
+		return
+	}
+
+	checkpointLoaded := time.Now()
+
+	if len(req.Documents) == 0 {
+		c.JSON(http.StatusOK, api.RerankResponse{Model: req.Model, Results: []api.RerankResult{}})
+		return
+	}
+
+	kv, err := getKVData(m.ModelPath, false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	sep, err := rerankSeparator(c.Request.Context(), r, kv)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	input := make([]string, len(req.Documents))
+	for i, doc := range req.Documents {
+		input[i] = req.Query + sep + doc
+	}
+
+	ctxLen := opts.NumCtx
+	if n := int(kv.ContextLength()); n > 0 {
+		ctxLen = min(ctxLen, n)
+	}
+
+	counts, err := fitContext(c.Request.Context(), r, kv, input, ctxLen, req.Truncate == nil || *req.Truncate)
+	if errors.Is(err, errContextExceeded) {
+		c.JSON(http.StatusBadRequest, errorBody(api.ErrorCodeContextExceeded, err.Error()))
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	scores, err := rank(c.Request.Context(), r, input, embedBatches(counts, ctxLen))
+	if err != nil {
+		slog.Error("reranking failed", "error", err)
+		c.JSON(http.StatusInternalServerError, errorBody(errorCode(err), fmt.Sprintf("failed to rerank documents: %v", err)))
+		return
+	}
+
+	results := make([]api.RerankResult, len(scores))
+	var count int
+	for i, score := range scores {
+		results[i] = api.RerankResult{Index: i, RelevanceScore: score}
+		count += counts[i]
+	}
+	s.recordUsage(c.Request.Context(), req.Model, count, 0)
+
+	slices.SortStableFunc(results, func(a, b api.RerankResult) int {
+		return cmp.Compare(b.RelevanceScore, a.RelevanceScore)
+	})
+
+	if req.TopN > 0 && req.TopN < len(results) {
+		results = results[:req.TopN]
+	}
+
+	c.JSON(http.StatusOK, api.RerankResponse{
+		Model:           req.Model,
+		Results:         results,
+		TotalDuration:   time.Since(checkpointStart),
+		LoadDuration:    checkpointLoaded.Sub(checkpointStart),
+		PromptEvalCount: count,
+	})
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+)
+
+func TestRerank(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var mu sync.Mutex
+	var inputs []string
+	mock := mockRunner{
+		DetokenizeFn: func(_ context.Context, tokens []int) (string, error) {
+			if len(tokens) != 1 || tokens[0] != 102 {
+				t.Errorf("expected the separator token, got %v", tokens)
+			}
+			return "[SEP]", nil
+		},
+		// the score of a document is the number of times it has the query
+		EmbeddingFn: func(_ context.Context, input string) ([]float32, error) {
+			mu.Lock()
+			inputs = append(inputs, input)
+			mu.Unlock()
+
+			query, doc, _ := strings.Cut(input, "[SEP]")
+			return []float32{float32(strings.Count(doc, query))}, nil
+		},
+	}
+
+	s := Server{sched: newMockScheduler(t, &mock)}
+	_, digest := createBinFile(t, llm.KV{
+		"general.architecture":              "bert",
+		"bert.pooling_type":                 uint32(llm.PoolingTypeRank),
+		"bert.context_length":               uint32(512),
+		"tokenizer.ggml.seperator_token_id": uint32(102),
+	}, []llm.Tensor{})
+	if w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:  "reranker",
+		Files:  map[string]string{"reranker.gguf": digest},
+		Stream: &stream,
+	}); w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	createMockModel(t, &s, "test", "")
+
+	w := createRequest(t, s.RerankHandler, api.RerankRequest{
+		Model:     "reranker",
+		Query:     "tea",
+		Documents: []string{"coffee beans", "green tea and more tea", "tea"},
+		TopN:      2,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp api.RerankResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(resp.Results) != 2 || resp.Results[0].Index != 1 || resp.Results[1].Index != 2 {
+		t.Fatalf("unexpected results %+v", resp.Results)
+	}
+
+	if want := 1 / (1 + math.Exp(-2)); math.Abs(resp.Results[0].RelevanceScore-want) > 1e-9 {
+		t.Errorf("expected score %f, got %f", want, resp.Results[0].RelevanceScore)
+	}
+
+	if len(inputs) != 3 || !strings.HasPrefix(inputs[0], "tea[SEP]") {
+		t.Errorf("unexpected inputs %q", inputs)
+	}
+
+	w = createRequest(t, s.RerankHandler, api.RerankRequest{Model: "test", Query: "tea", Documents: []string{"tea"}})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for a model that doesn't rerank, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = createRequest(t, s.RerankHandler, api.RerankRequest{Model: "reranker", Documents: []string{"tea"}})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 without a query, got %d", w.Code)
+	}
+}
@@ -4,7 +4,7 @@ import (
 	"bytes"
 	"cmp"
 	"context"
-	"encoding/binary"
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -25,16 +25,13 @@ import (
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
-	"golang.org/x/sync/errgroup"
 
 	"github.com/ollama/ollama/api"
-	"github.com/ollama/ollama/discover"
 	"github.com/ollama/ollama/envconfig"
 	"github.com/ollama/ollama/llm"
-	"github.com/ollama/ollama/model/mllama"
 	"github.com/ollama/ollama/openai"
-	"github.com/ollama/ollama/runners"
 	"github.com/ollama/ollama/template"
+	"github.com/ollama/ollama/tracing"
 	"github.com/ollama/ollama/types/errtypes"
 	"github.com/ollama/ollama/types/model"
 	"github.com/ollama/ollama/version"
@@ -45,6 +42,42 @@ var mode string = gin.DebugMode
 type Server struct {
 	addr  net.Addr
 	sched *Scheduler
+
+	// lns are the listeners the server serves the API on
+	lns []net.Listener
+
+	// pipe accepts in-process connections from the server's Client
+	pipe *pipeListener
+
+	// mux is the handler for requests, or nil for the API's routes
+	mux http.Handler
+
+	// preloadManifest is nil if there's no preload manifest
+	preloadManifest *preloadManifest
+
+	// preloadState is nil if there's no preload manifest
+	preloadState *preloadState
+
+	// usage is nil unless models are preloaded based on usage patterns
+	usage *usageHistory
+
+	// memories are the long-term memories of chats with a memory key, or
+	// nil if memories aren't available
+	memories *memoryStore
+
+	// quotas accounts the tokens each client uses, or is nil if the
+	// server's routes haven't been generated
+	quotas *quotaTracker
+
+	// metrics are exported at /metrics, or are nil if the server's routes
+	// haven't been generated
+	metrics *metrics
+
+	// audit is where requests are audited to, or nil if they aren't
+	audit *auditLog
+
+	// restore undoes the options of a server created with New, or is nil
+	restore func()
 }
 
 func init() {
@@ -62,6 +95,7 @@ func init() {
 var (
 	errRequired    = errors.New("is required")
 	errBadTemplate = errors.New("template error")
+	errDraftModel  = errors.New("draft model not found")
 )
 
 func modelOptions(model *Model, requestOpts map[string]interface{}) (api.Options, error) {
@@ -74,6 +108,18 @@ func modelOptions(model *Model, requestOpts map[string]interface{}) (api.Options
 		return api.Options{}, err
 	}
 
+	// the runner loads the draft model from the path of its weights
+	if opts.DraftModel != "" {
+		draft, err := GetModel(opts.DraftModel)
+		if errors.Is(err, os.ErrNotExist) {
+			return api.Options{}, fmt.Errorf("%w: %s", errDraftModel, opts.DraftModel)
+		} else if err != nil {
+			return api.Options{}, err
+		}
+
+		opts.DraftModel = draft.ModelPath
+	}
+
 	return opts, nil
 }
 
@@ -93,26 +139,108 @@ func (s *Server) scheduleRunner(ctx context.Context, name string, caps []Capabil
 		return nil, nil, nil, fmt.Errorf("%s %w", name, err)
 	}
 
+	if err := checkLicense(model); err != nil {
+		return nil, nil, nil, fmt.Errorf("%s %w", name, err)
+	}
+
 	opts, err := modelOptions(model, requestOpts)
 	if err != nil {
 		return nil, nil, nil, err
 	}
 
-	runnerCh, errCh := s.sched.GetRunner(ctx, model, opts, keepAlive)
-	var runner *runnerRef
-	select {
-	case runner = <-runnerCh:
-	case err = <-errCh:
+	if err := checkWatermark(model, opts); err != nil {
+		return nil, nil, nil, err
+	}
+
+	if err := checkStop(model, opts); err != nil {
+		return nil, nil, nil, err
+	}
+
+	if err := checkResponseLanguage(opts); err != nil {
+		return nil, nil, nil, err
+	}
+
+	if err := checkPlacement(opts); err != nil {
+		return nil, nil, nil, err
+	}
+
+	if err := checkShadow(opts); err != nil {
+		return nil, nil, nil, err
+	}
+
+	if err := checkProfile(model, opts); err != nil {
+		return nil, nil, nil, err
+	}
+
+	// remote models aren't scheduled, as they don't use local memory
+	if remote := model.Config.Remote; remote != nil {
+		r, err := llm.NewRemoteServer(remote.URL, remote.Model)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		return r, model, &opts, nil
+	}
+
+	fb, err := newFallback(opts)
+	if err != nil {
 		return nil, nil, nil, err
 	}
 
-	return runner.llama, model, &opts, nil
+	ph := phasesFrom(ctx)
+	ph.reportPhase(api.PhaseQueued)
+
+	_, span := tracing.StartSpan(ctx, "queue", tracing.KindInternal, tracing.String("gen_ai.request.model", name))
+	defer span.End()
+
+	var r llm.LlamaServer
+	if fb != nil {
+		r, err = s.scheduleFallback(ctx, model, opts, keepAlive, fb)
+		if err != nil {
+			span.SetError(err)
+			return nil, nil, nil, err
+		}
+	} else {
+		runnerCh, errCh := s.sched.getRunnerFor(ctx, model, opts, keepAlive)
+		loading := ph.loadingC()
+		for r == nil {
+			select {
+			case runner := <-runnerCh:
+				r = runner.llama
+			case err = <-errCh:
+				span.SetError(err)
+				return nil, nil, nil, err
+			case <-loading:
+				ph.reportPhase(api.PhaseLoading)
+				span.SetAttributes(tracing.Bool("ollama.model_loaded", true))
+				loading = nil
+			}
+		}
+
+		// the runner may be ready before the loading signal is received
+		select {
+		case <-loading:
+			ph.reportPhase(api.PhaseLoading)
+			span.SetAttributes(tracing.Bool("ollama.model_loaded", true))
+		default:
+		}
+	}
+	ph.flush()
+
+	if s.usage != nil {
+		s.usage.record(name, time.Now())
+		if next := s.usage.next(name); next != "" {
+			go s.preloadPredicted(context.Background(), next)
+		}
+	}
+
+	return r, model, &opts, nil
 }
 
 func (s *Server) GenerateHandler(c *gin.Context) {
 	checkpointStart := time.Now()
 	var req api.GenerateRequest
-	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+	if err := bindRequest(c, &req); errors.Is(err, io.EOF) {
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
 		return
 	} else if err != nil {
@@ -120,19 +248,41 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 		return
 	}
 
+	if req.NoStore {
+		c.Request = c.Request.WithContext(withNoStore(c.Request.Context()))
+	}
+
+	auditOf(c).request(req.Model, req.Options, req.Metadata, noStore(c.Request.Context()))
+
+	if err := checkMetadata(req.Metadata); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := checkLogprobs(req.Logprobs, req.TopLogprobs); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var err error
+	if req.Format, err = responseFormat(req.Format); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	name := model.ParseName(req.Model)
 	if !name.IsValid() {
 		// Ideally this is "invalid model name" but we're keeping with
 		// what the API currently returns until we can change it.
-		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model '%s' not found", req.Model)})
+		c.JSON(http.StatusNotFound, errorBody(api.ErrorCodeModelNotFound, fmt.Sprintf("model '%s' not found", req.Model)))
 		return
 	}
 
 	// We cannot currently consolidate this into GetModel because all we'll
 	// induce infinite recursion given the current code structure.
-	name, err := getExistingName(name)
+	name, err = getExistingName(name)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model '%s' not found", req.Model)})
+		c.JSON(http.StatusNotFound, errorBody(api.ErrorCodeModelNotFound, fmt.Sprintf("model '%s' not found", req.Model)))
 		return
 	}
 
@@ -140,7 +290,7 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 	if err != nil {
 		switch {
 		case errors.Is(err, fs.ErrNotExist):
-			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model '%s' not found", req.Model)})
+			c.JSON(http.StatusNotFound, errorBody(api.ErrorCodeModelNotFound, fmt.Sprintf("model '%s' not found", req.Model)))
 		case err.Error() == errtypes.InvalidModelNameErrMsg:
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		default:
@@ -168,14 +318,32 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 		return
 	}
 
+	if req.Prefix != "" && req.Prompt != "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "prefix and prompt can't both be set"})
+		return
+	}
+
+	// the prefix and suffix are filled in between
+	infill := req.Suffix != "" || req.Prefix != ""
+
 	caps := []Capability{CapabilityCompletion}
-	if req.Suffix != "" {
+	if infill {
 		caps = append(caps, CapabilityInsert)
 	}
 
-	r, m, opts, err := s.scheduleRunner(c.Request.Context(), name.String(), caps, req.Options, req.KeepAlive)
+	// the runner is held until release is called
+	ctx, release := context.WithCancel(c.Request.Context())
+	defer func() { release() }()
+
+	// events are only sent in streamed responses
+	events := req.Events && (req.Stream == nil || *req.Stream)
+	if events {
+		ctx = withPhases(ctx, phaseWriter(c, req.Model))
+	}
+
+	r, m, opts, err := s.scheduleRunner(ctx, name.String(), caps, req.Options, req.KeepAlive)
 	if errors.Is(err, errCapabilityCompletion) {
-		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%q does not support generate", req.Model)})
+		c.JSON(http.StatusBadRequest, errorBody(api.ErrorCodeUnsupportedCapability, fmt.Sprintf("%q does not support generate", req.Model)))
 		return
 	} else if err != nil {
 		handleScheduleError(c, req.Model, err)
@@ -183,65 +351,69 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 	}
 
 	checkpointLoaded := time.Now()
+	setProvenance(c, m, opts, req)
 
 	// load the model
-	if req.Prompt == "" {
-		c.JSON(http.StatusOK, api.GenerateResponse{
+	if req.Prompt == "" && !infill {
+		res := api.GenerateResponse{
 			Model:      req.Model,
 			CreatedAt:  time.Now().UTC(),
 			Done:       true,
 			DoneReason: "load",
-		})
+		}
+
+		if events {
+			writeEvent(c, api.Event{Phase: api.PhaseDone, Model: req.Model, CreatedAt: res.CreatedAt, Generate: &res})
+			return
+		}
+
+		c.JSON(http.StatusOK, res)
 		return
 	}
 
 	isMllama := checkMllamaModelFamily(model)
-	if isMllama && len(req.Images) > 1 {
-		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "this model only supports one image: more than one image sent"})
+	if isMllama && uint(len(req.Images)) > max(envconfig.MaxImages(), 1) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": errTooManyImages.Error()})
 		return
 	}
 
 	images := make([]llm.ImageData, len(req.Images))
 	for i := range req.Images {
 		if isMllama {
-			data, opts, err := mllama.Preprocess(bytes.NewReader(req.Images[i]))
-			if err != nil {
-				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "error processing image"})
-				return
-			}
-
-			ar, ok := opts["aspectRatioIndex"].(int)
-			if !ok {
-				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "error processing image"})
-				return
-			}
-
-			buf := new(bytes.Buffer)
-			err = binary.Write(buf, binary.LittleEndian, data)
+			images[i], err = mllamaImage(i, req.Images[i])
 			if err != nil {
 				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "error processing image"})
 				return
 			}
-
-			images[i] = llm.ImageData{ID: i, Data: buf.Bytes(), AspectRatioID: ar}
 		} else {
 			images[i] = llm.ImageData{ID: i, Data: req.Images[i]}
 		}
 	}
 
-	prompt := req.Prompt
+	prompt := cmp.Or(req.Prefix, req.Prompt)
+	_, span := tracing.StartSpan(c.Request.Context(), "prompt", tracing.KindInternal)
 	if !req.Raw {
 		tmpl := m.Template
+		if infill && req.Template == "" && !slices.Contains(tmpl.Vars(), "suffix") {
+			// code models whose template doesn't take a suffix are
+			// prompted with their fill-in-the-middle tokens
+			if fim := m.fimTemplate(); fim != nil {
+				tmpl = fim
+			}
+		}
+
 		if req.Template != "" {
 			tmpl, err = template.Parse(req.Template)
 			if err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 				return
 			}
+
+			tmpl.Sandbox(template.DefaultLimits)
 		}
 
 		var values template.Values
-		if req.Suffix != "" {
+		if infill {
 			values.Prompt = prompt
 			values.Suffix = req.Suffix
 		} else {
@@ -256,15 +428,26 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 				msgs = append(msgs, m.Messages...)
 			}
 
-			for _, i := range images {
-				imgPrompt := ""
-				if isMllama {
-					imgPrompt = "<|image|>"
+			if isMllama && len(images) > 0 {
+				// mllama images are attended to together, so they're sent in
+				// one message with the image tokens after all of the images
+				var sb strings.Builder
+				for _, i := range images {
+					fmt.Fprintf(&sb, "[img-%d]", i.ID)
+				}
+				sb.WriteString(strings.Repeat("<|image|>", len(images)))
+				msgs = append(msgs, api.Message{Role: "user", Content: sb.String()})
+			} else {
+				for _, i := range images {
+					msgs = append(msgs, api.Message{Role: "user", Content: fmt.Sprintf("[img-%d]", i.ID)})
 				}
-				msgs = append(msgs, api.Message{Role: "user", Content: fmt.Sprintf("[img-%d]"+imgPrompt, i.ID)})
 			}
 
 			values.Messages = append(msgs, api.Message{Role: "user", Content: req.Prompt})
+			if opts.FormatExample {
+				values.Messages = withFormatExample(values.Messages, tmpl, req.Format)
+			}
+			values.Messages = withResponseLanguage(values.Messages, tmpl, opts)
 		}
 
 		var b bytes.Buffer
@@ -285,24 +468,63 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 
 		prompt = b.String()
 	}
+	span.End()
+
+	if !noStore(c.Request.Context()) {
+		slog.Debug("generate request", "images", len(images), "prompt", prompt)
+	}
+
+	pp, err := newPostProcessor(opts.PostProcess, opts.Stop)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if opts.TrimWhitespace {
+		pp = append(pp, &trimFilter{})
+	}
+
+	// end of generation tokens can only be ignored with raw prompts since
+	// templates rely on them to end the response
+	if !req.Raw {
+		opts.IgnoreEOS = false
+	}
 
-	slog.Debug("generate request", "images", len(images), "prompt", prompt)
+	ticket, err := admitPrefill(m.ModelPath, prompt, images, req.Stream == nil || *req.Stream)
+	if err != nil {
+		handleScheduleError(c, req.Model, err)
+		return
+	}
 
 	ch := make(chan any)
 	go func() {
 		// TODO (jmorganca): avoid building the response twice both here and below
 		var sb strings.Builder
 		defer close(ch)
-		if err := r.Completion(c.Request.Context(), llm.CompletionRequest{
-			Prompt:  prompt,
-			Images:  images,
-			Format:  req.Format,
-			Options: opts,
-		}, func(cr llm.CompletionResponse) {
+		defer ticket.done()
+		var started bool
+		completionReq := llm.CompletionRequest{
+			Prompt:      prompt,
+			Images:      images,
+			Format:      req.Format,
+			Options:     opts,
+			Logprobs:    req.Logprobs,
+			TopLogprobs: req.TopLogprobs,
+			NoStore:     noStore(c.Request.Context()),
+			Scripts:     responseScripts(opts),
+		}
+		fn := ticket.track(func(cr llm.CompletionResponse) {
+			started = true
+			content := pp.Write(cr.Content)
+			if cr.Done {
+				content += pp.Flush()
+			}
+
 			res := api.GenerateResponse{
 				Model:      req.Model,
 				CreatedAt:  time.Now().UTC(),
-				Response:   cr.Content,
+				Response:   content,
+				Logprobs:   cr.Logprobs,
 				Done:       cr.Done,
 				DoneReason: cr.DoneReason,
 				Metrics: api.Metrics{
@@ -310,6 +532,8 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 					PromptEvalDuration: cr.PromptEvalDuration,
 					EvalCount:          cr.EvalCount,
 					EvalDuration:       cr.EvalDuration,
+					DraftCount:         cr.DraftCount,
+					DraftAcceptedCount: cr.DraftAcceptedCount,
 				},
 			}
 
@@ -320,6 +544,19 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 			if cr.Done {
 				res.TotalDuration = time.Since(checkpointStart)
 				res.LoadDuration = checkpointLoaded.Sub(checkpointStart)
+				res.Degraded = s.sched.loadedDegraded(m)
+				res.Advisories = s.sched.takeAdvisories(m)
+				res.Diagnostics = cr.Diagnostics
+				if cr.Profile != nil {
+					res.ProfileID = profiles.add(req.Model, ownerOf(c), cr.Profile)
+				}
+				res.Metadata = req.Metadata
+				if !noStore(c.Request.Context()) {
+					logRequest("generate", req.Model, req.Metadata, res.Metrics)
+				}
+				auditOf(c).completed(prompt, sb.String(), res.Metrics)
+				s.recordUsage(c.Request.Context(), req.Model, res.PromptEvalCount, res.EvalCount)
+				s.metrics.recordCompletion(req.Model, req.Metadata, res.Metrics, res.Diagnostics, 0)
 
 				if !req.Raw {
 					tokens, err := r.Tokenize(c.Request.Context(), prompt+sb.String())
@@ -329,21 +566,39 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 					}
 					res.Context = tokens
 				}
+
+				if !noStore(c.Request.Context()) && !req.Raw && req.Context == nil && len(req.Images) == 0 && sampleShadow(opts) {
+					s.shadow("generate", req.Model, opts, sb.String(), res.Metrics, func(ctx context.Context, name string) (llm.CompletionResponse, error) {
+						return s.generateCompletion(ctx, name, req.Prompt, req.System, req.Format, shadowOptions(req.Options), req.KeepAlive)
+					})
+				}
 			}
 
 			ch <- res
-		}); err != nil {
-			ch <- gin.H{"error": err.Error()}
+		})
+
+		err := r.Completion(c.Request.Context(), completionReq, fn)
+		if err != nil && !started && errorCode(err) == api.ErrorCodeOutOfMemory {
+			r, err = s.rescheduleOutOfMemory(c.Request.Context(), m, &release, err, name.String(), caps, req.Options, req.KeepAlive)
+			if err == nil {
+				err = r.Completion(c.Request.Context(), completionReq, fn)
+			}
+		}
+
+		if err != nil {
+			ch <- errorResponse(err)
 		}
 	}()
 
 	if req.Stream != nil && !*req.Stream {
 		var r api.GenerateResponse
 		var sb strings.Builder
+		var logprobs []api.Logprob
 		for rr := range ch {
 			switch t := rr.(type) {
 			case api.GenerateResponse:
 				sb.WriteString(t.Response)
+				logprobs = append(logprobs, t.Logprobs...)
 				r = t
 			case gin.H:
 				msg, ok := t["error"].(string)
@@ -351,7 +606,7 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 					msg = "unexpected error format in response"
 				}
 
-				c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+				c.JSON(http.StatusInternalServerError, errorBody(errorCodeOf(t), msg))
 				return
 			default:
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "unexpected response"})
@@ -360,11 +615,22 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 		}
 
 		r.Response = sb.String()
+		r.Logprobs = logprobs
 		c.JSON(http.StatusOK, r)
 		return
 	}
 
-	streamResponse(c, ch)
+	out := ch
+	if events {
+		out = streamEvents(c.Request.Context(), ch, api.Event{
+			Phase:        api.PhasePrefill,
+			Model:        req.Model,
+			CreatedAt:    time.Now().UTC(),
+			PromptTokens: estimateTokens(prompt, images),
+		})
+	}
+
+	streamPacedResponse(c, out, opts.StreamRate)
 }
 
 func (s *Server) EmbedHandler(c *gin.Context) {
@@ -380,6 +646,18 @@ func (s *Server) EmbedHandler(c *gin.Context) {
 		return
 	}
 
+	auditOf(c).request(req.Model, req.Options, req.Metadata, false)
+
+	if err := checkMetadata(req.Metadata); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := checkDimensions(req.Dimensions, 0); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	truncate := true
 
 	if req.Truncate != nil && !*req.Truncate {
@@ -410,7 +688,7 @@ func (s *Server) EmbedHandler(c *gin.Context) {
 
 	name, err := getExistingName(model.ParseName(req.Model))
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model '%s' not found", req.Model)})
+		c.JSON(http.StatusNotFound, errorBody(api.ErrorCodeModelNotFound, fmt.Sprintf("model '%s' not found", req.Model)))
 		return
 	}
 
@@ -433,60 +711,49 @@ func (s *Server) EmbedHandler(c *gin.Context) {
 		return
 	}
 
-	var count int
-	for i, s := range input {
-		tokens, err := r.Tokenize(c.Request.Context(), s)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-
-		ctxLen := min(opts.NumCtx, int(kvData.ContextLength()))
-		if len(tokens) > ctxLen {
-			if !truncate {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "input length exceeds maximum context length"})
-				return
-			}
-
-			tokens = tokens[:ctxLen]
-			s, err = r.Detokenize(c.Request.Context(), tokens)
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-				return
-			}
-		}
+	if err := checkDimensions(req.Dimensions, int(kvData.EmbeddingLength())); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-		count += len(tokens)
+	ctxLen := opts.NumCtx
+	if n := int(kvData.ContextLength()); n > 0 {
+		ctxLen = min(ctxLen, n)
+	}
 
-		input[i] = s
+	counts, err := fitContext(c.Request.Context(), r, kvData, input, ctxLen, truncate)
+	if errors.Is(err, errContextExceeded) {
+		c.JSON(http.StatusBadRequest, errorBody(api.ErrorCodeContextExceeded, err.Error()))
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
 	}
 
-	var g errgroup.Group
-	embeddings := make([][]float32, len(input))
-	for i, text := range input {
-		g.Go(func() error {
-			embedding, err := r.Embedding(c.Request.Context(), text)
-			if err != nil {
-				return err
-			}
-			embeddings[i] = normalize(embedding)
-			return nil
-		})
+	var count int
+	for _, n := range counts {
+		count += n
 	}
 
-	if err := g.Wait(); err != nil {
+	embeddings, err := embed(c.Request.Context(), r, input, embedBatches(counts, ctxLen), req.Dimensions)
+	if err != nil {
 		slog.Error("embedding generation failed", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Errorf("failed to generate embeddings: %v", err)})
+		c.JSON(http.StatusInternalServerError, errorBody(errorCode(err), fmt.Sprintf("failed to generate embeddings: %v", err)))
 		return
 	}
 
 	resp := api.EmbedResponse{
-		Model:           req.Model,
-		Embeddings:      embeddings,
-		TotalDuration:   time.Since(checkpointStart),
-		LoadDuration:    checkpointLoaded.Sub(checkpointStart),
-		PromptEvalCount: count,
-	}
+		Model:            req.Model,
+		Embeddings:       embeddings,
+		Metadata:         req.Metadata,
+		TotalDuration:    time.Since(checkpointStart),
+		LoadDuration:     checkpointLoaded.Sub(checkpointStart),
+		PromptEvalCount:  count,
+		PromptEvalCounts: counts,
+	}
+	logRequest("embed", req.Model, req.Metadata, api.Metrics{PromptEvalCount: count, TotalDuration: resp.TotalDuration})
+	s.recordUsage(c.Request.Context(), req.Model, count, 0)
+	auditOf(c).completed("", "", api.Metrics{PromptEvalCount: count})
 	c.JSON(http.StatusOK, resp)
 }
 
@@ -538,10 +805,16 @@ func (s *Server) EmbeddingsHandler(c *gin.Context) {
 	embedding, err := r.Embedding(c.Request.Context(), req.Prompt)
 	if err != nil {
 		slog.Info(fmt.Sprintf("embedding generation failed: %v", err))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Errorf("failed to generate embedding: %v", err)})
+		c.JSON(http.StatusInternalServerError, errorBody(errorCode(err), fmt.Sprintf("failed to generate embedding: %v", err)))
 		return
 	}
 
+	if _, ok := quotaClient(c.Request.Context()); ok {
+		if tokens, err := r.Tokenize(c.Request.Context(), req.Prompt); err == nil {
+			s.recordUsage(c.Request.Context(), req.Model, len(tokens), 0)
+		}
+	}
+
 	var e []float64
 	for _, v := range embedding {
 		e = append(e, float64(v))
@@ -705,7 +978,7 @@ func (s *Server) DeleteHandler(c *gin.Context) {
 
 	n, err := getExistingName(n)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model '%s' not found", cmp.Or(r.Model, r.Name))})
+		c.JSON(http.StatusNotFound, errorBody(api.ErrorCodeModelNotFound, fmt.Sprintf("model '%s' not found", cmp.Or(r.Model, r.Name))))
 		return
 	}
 
@@ -713,14 +986,17 @@ func (s *Server) DeleteHandler(c *gin.Context) {
 	if err != nil {
 		switch {
 		case os.IsNotExist(err):
-			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model '%s' not found", cmp.Or(r.Model, r.Name))})
+			c.JSON(http.StatusNotFound, errorBody(api.ErrorCodeModelNotFound, fmt.Sprintf("model '%s' not found", cmp.Or(r.Model, r.Name))))
 		default:
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		}
 		return
 	}
 
-	if err := m.Remove(); err != nil {
+	if err := m.Remove(); errors.Is(err, errSharedModel) {
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("model '%s' is in the read-only shared model store and can't be deleted", cmp.Or(r.Model, r.Name))})
+		return
+	} else if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -756,7 +1032,7 @@ func (s *Server) ShowHandler(c *gin.Context) {
 	if err != nil {
 		switch {
 		case os.IsNotExist(err):
-			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model '%s' not found", req.Model)})
+			c.JSON(http.StatusNotFound, errorBody(api.ErrorCodeModelNotFound, fmt.Sprintf("model '%s' not found", req.Model)))
 		case err.Error() == errtypes.InvalidModelNameErrMsg:
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		default:
@@ -806,13 +1082,25 @@ func GetModelInfo(req api.ShowRequest) (*api.ShowResponse, error) {
 		return nil, err
 	}
 
+	acceptance, err := licenseAcceptance(m)
+	if err != nil {
+		return nil, err
+	}
+
 	resp := &api.ShowResponse{
-		License:    strings.Join(m.License, "\n"),
-		System:     m.System,
-		Template:   m.Template.String(),
-		Details:    modelDetails,
-		Messages:   msgs,
-		ModifiedAt: manifest.fi.ModTime(),
+		License:           strings.Join(m.License, "\n"),
+		System:            m.System,
+		Template:          m.Template.String(),
+		Details:           modelDetails,
+		Messages:          msgs,
+		ModifiedAt:        manifest.fi.ModTime(),
+		LicenseAcceptance: acceptance,
+		Capabilities:      m.capabilities(),
+		Card:              m.Card,
+	}
+
+	if m.ToolPrompt != nil {
+		resp.ToolPrompt = m.ToolPrompt.String()
 	}
 
 	var params []string
@@ -850,6 +1138,13 @@ func GetModelInfo(req api.ShowRequest) (*api.ShowResponse, error) {
 	delete(kvData, "tokenizer.chat_template")
 	resp.ModelInfo = kvData
 
+	if req.Tensors && m.ModelPath != "" {
+		resp.Tensors, err = getTensors(m.ModelPath, req.Checksums)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	if len(m.ProjectorPaths) > 0 {
 		projectorData, err := getKVData(m.ProjectorPaths[0], req.Verbose)
 		if err != nil {
@@ -862,6 +1157,11 @@ func GetModelInfo(req api.ShowRequest) (*api.ShowResponse, error) {
 }
 
 func getKVData(digest string, verbose bool) (llm.KV, error) {
+	// remote models have no weights to read
+	if digest == "" {
+		return llm.KV{}, nil
+	}
+
 	maxArraySize := 0
 	if verbose {
 		maxArraySize = -1
@@ -884,6 +1184,42 @@ func getKVData(digest string, verbose bool) (llm.KV, error) {
 	return kv, nil
 }
 
+// getTensors returns the tensors of the model at path. If checksums is true,
+// each tensor's data is read and its sha256 digest included.
+func getTensors(path string, checksums bool) ([]api.Tensor, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ggml, _, err := llm.DecodeGGML(f, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	ts := ggml.Tensors()
+	tensors := make([]api.Tensor, len(ts.Items))
+	for i, t := range ts.Items {
+		tensors[i] = api.Tensor{
+			Name:  t.Name,
+			Type:  t.Type(),
+			Shape: t.Shape,
+		}
+
+		if checksums {
+			h := sha256.New()
+			if _, err := io.Copy(h, io.NewSectionReader(f, int64(ts.Offset+t.Offset), int64(t.Size()))); err != nil {
+				return nil, err
+			}
+
+			tensors[i].Digest = fmt.Sprintf("sha256:%x", h.Sum(nil))
+		}
+	}
+
+	return tensors, nil
+}
+
 func (s *Server) ListHandler(c *gin.Context) {
 	ms, err := Manifests(true)
 	if err != nil {
@@ -967,7 +1303,7 @@ func (s *Server) CopyHandler(c *gin.Context) {
 	}
 
 	if err := CopyModel(src, dst); errors.Is(err, os.ErrNotExist) {
-		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model %q not found", r.Source)})
+		c.JSON(http.StatusNotFound, errorBody(api.ErrorCodeModelNotFound, fmt.Sprintf("model %q not found", r.Source)))
 	} else if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 	}
@@ -1090,7 +1426,12 @@ func allowedHost(host string) bool {
 
 func allowedHostsMiddleware(addr net.Addr) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if addr == nil {
+		// check against the address of the listener the request came in on
+		if local, ok := c.Request.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+			addr = local
+		}
+
+		if addr == nil || addr.Network() == "unix" {
 			c.Next()
 			return
 		}
@@ -1130,32 +1471,93 @@ func (s *Server) GenerateRoutes() http.Handler {
 	config := cors.DefaultConfig()
 	config.AllowWildcard = true
 	config.AllowBrowserExtensions = true
-	config.AllowHeaders = []string{"Authorization", "Content-Type", "User-Agent", "Accept", "X-Requested-With"}
+	config.AllowHeaders = []string{"Authorization", "Content-Type", "Content-Encoding", "User-Agent", "Accept", "X-Requested-With"}
 	openAIProperties := []string{"lang", "package-version", "os", "arch", "retry-count", "runtime", "runtime-version", "async"}
 	for _, prop := range openAIProperties {
 		config.AllowHeaders = append(config.AllowHeaders, "x-stainless-"+prop)
 	}
 	config.AllowOrigins = envconfig.Origins()
 
+	s.metrics = newMetrics()
+
+	s.audit = auditLogOrNil()
+
 	r := gin.Default()
+	if err := r.SetTrustedProxies(envconfig.TrustedProxies()); err != nil {
+		slog.Warn("ignoring OLLAMA_TRUSTED_PROXIES", "error", err)
+		_ = r.SetTrustedProxies(nil)
+	}
+
 	r.Use(
+		metricsMiddleware(s.metrics),
+		tracingMiddleware(),
+		auditMiddleware(s.audit),
 		cors.New(config),
 		allowedHostsMiddleware(s.addr),
+		compressionMiddleware(),
 	)
 
+	r.Use(apiKeysMiddleware(loadAPIKeys()))
+
+	if limiters := requestLimiters(); len(limiters) > 0 {
+		r.Use(requestLimitsMiddleware(limiters))
+	}
+
+	s.quotas = tokenQuotas()
+	r.Use(quotaMiddleware(s.quotas))
+
 	r.POST("/api/pull", s.PullHandler)
 	r.POST("/api/generate", s.GenerateHandler)
 	r.POST("/api/chat", s.ChatHandler)
+	r.POST("/api/ensemble", s.EnsembleHandler)
+	r.POST("/api/batch", s.BatchHandler)
+	r.POST("/api/agent", s.AgentHandler)
 	r.POST("/api/embed", s.EmbedHandler)
+	r.POST("/api/rerank", s.RerankHandler)
 	r.POST("/api/embeddings", s.EmbeddingsHandler)
+	r.POST("/api/split", s.SplitHandler)
+	r.POST("/api/tokenize", s.TokenizeHandler)
+	r.POST("/api/detokenize", s.DetokenizeHandler)
+	r.POST("/api/tokenizer/check", s.TokenizerCheckHandler)
+	r.POST("/api/watermark", s.WatermarkHandler)
 	r.POST("/api/create", s.CreateHandler)
 	r.POST("/api/push", s.PushHandler)
 	r.POST("/api/copy", s.CopyHandler)
 	r.DELETE("/api/delete", s.DeleteHandler)
 	r.POST("/api/show", s.ShowHandler)
+	r.POST("/api/license/accept", s.AcceptLicenseHandler)
 	r.POST("/api/blobs/:digest", s.CreateBlobHandler)
 	r.HEAD("/api/blobs/:digest", s.HeadBlobHandler)
+	r.POST("/api/uploads", s.CreateUploadHandler)
+	r.GET("/api/uploads/:id", s.UploadHandler)
+	r.PATCH("/api/uploads/:id/:digest", s.UploadBlobHandler)
+	r.DELETE("/api/uploads/:id", s.DeleteUploadHandler)
+	r.POST("/api/sessions", s.CreateSessionHandler)
+	r.GET("/api/sessions", s.ListSessionsHandler)
+	r.GET("/api/sessions/:id", s.SessionHandler)
+	r.POST("/api/sessions/:id/fork", s.ForkSessionHandler)
+	r.DELETE("/api/sessions/:id", s.DeleteSessionHandler)
+	r.POST("/api/purge", s.PurgeHandler)
+	r.GET("/api/memories/:key", s.ListMemoriesHandler)
+	r.POST("/api/memories/:key", s.AddMemoryHandler)
+	r.DELETE("/api/memories/:key", s.DeleteMemoryHandler)
+	r.PUT("/api/memories/:key/:id", s.UpdateMemoryHandler)
+	r.DELETE("/api/memories/:key/:id", s.DeleteMemoryHandler)
 	r.GET("/api/ps", s.PsHandler)
+	r.GET("/api/usage", s.UsageHandler)
+	r.POST("/api/recommend", s.RecommendHandler)
+	r.POST("/api/plan", s.PlanHandler)
+	r.GET("/api/debug/prompt-cache", s.PromptCacheHandler)
+	r.GET("/api/profiles/:id", s.ProfileHandler)
+	r.GET("/metrics", s.MetricsHandler)
+	s.chaosRoutes(r)
+
+	if envconfig.RegistryCache() {
+		for _, method := range []string{http.MethodGet, http.MethodHead} {
+			r.Handle(method, "/v2/:namespace/:model/manifests/:tag", s.RegistryManifestHandler)
+			r.Handle(method, "/v2/:namespace/:model/blobs/:digest", s.RegistryBlobHandler)
+		}
+	}
 
 	// Compatibility endpoints
 	r.POST("/v1/chat/completions", openai.ChatMiddleware(), s.ChatHandler)
@@ -1173,12 +1575,18 @@ func (s *Server) GenerateRoutes() http.Handler {
 		r.Handle(method, "/api/version", func(c *gin.Context) {
 			c.JSON(http.StatusOK, gin.H{"version": version.Version})
 		})
+		r.Handle(method, "/api/ready", s.ReadyHandler)
 	}
 
 	return r
 }
 
-func Serve(ln net.Listener) error {
+// Serve serves the API on each of lns until the server is interrupted
+func Serve(lns ...net.Listener) error {
+	if len(lns) == 0 {
+		return errors.New("no listeners")
+	}
+
 	level := slog.LevelInfo
 	if envconfig.Debug() {
 		level = slog.LevelDebug
@@ -1200,88 +1608,29 @@ func Serve(ln net.Listener) error {
 
 	slog.SetDefault(slog.New(handler))
 
-	blobsDir, err := GetBlobsPath("")
+	stopTracing := startTracing()
+	defer stopTracing()
+
+	s, err := New(Options{Listeners: lns})
 	if err != nil {
 		return err
 	}
-	if err := fixBlobs(blobsDir); err != nil {
-		return err
-	}
-
-	if !envconfig.NoPrune() {
-		if _, err := Manifests(false); err != nil {
-			slog.Warn("corrupt manifests detected, skipping prune operation.  Re-pull or delete to clear", "error", err)
-		} else {
-			// clean up unused layers and manifests
-			if err := PruneLayers(); err != nil {
-				return err
-			}
-
-			manifestsPath, err := GetManifestPath()
-			if err != nil {
-				return err
-			}
-
-			if err := PruneDirectory(manifestsPath); err != nil {
-				return err
-			}
-		}
-	}
-
-	ctx, done := context.WithCancel(context.Background())
-	schedCtx, schedDone := context.WithCancel(ctx)
-	sched := InitScheduler(schedCtx)
-	s := &Server{addr: ln.Addr(), sched: sched}
 
+	// Use http.DefaultServeMux so we get net/http/pprof for free.
+	//
+	// TODO(bmizerany): Decide if we want to make this configurable so it is
+	// not exposed by default, or allow users to bind it to a different port.
+	// This was a quick and easy way to get pprof, but it may not be the best
+	// way.
 	http.Handle("/", s.GenerateRoutes())
-
-	slog.Info(fmt.Sprintf("Listening on %s (version %s)", ln.Addr(), version.Version))
-	srvr := &http.Server{
-		// Use http.DefaultServeMux so we get net/http/pprof for
-		// free.
-		//
-		// TODO(bmizerany): Decide if we want to make this
-		// configurable so it is not exposed by default, or allow
-		// users to bind it to a different port. This was a quick
-		// and easy way to get pprof, but it may not be the best
-		// way.
-		Handler: nil,
-	}
+	s.mux = http.DefaultServeMux
+	defer s.audit.close()
 
 	// listen for a ctrl+c and stop any loaded llm
-	signals := make(chan os.Signal, 1)
-	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
-	go func() {
-		<-signals
-		srvr.Close()
-		schedDone()
-		sched.unloadAllRunners()
-		done()
-	}()
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-	// Locate and log what runners are present at startup
-	var runnerNames []string
-	for v := range runners.GetAvailableServers() {
-		runnerNames = append(runnerNames, v)
-	}
-	slog.Info("Dynamic LLM libraries", "runners", runnerNames)
-	slog.Debug("Override detection logic by setting OLLAMA_LLM_LIBRARY")
-
-	s.sched.Run(schedCtx)
-
-	// At startup we retrieve GPU information so we can get log messages before loading a model
-	// This will log warnings to the log in case we have problems with detected GPUs
-	gpus := discover.GetGPUInfo()
-	gpus.LogDetails()
-
-	err = srvr.Serve(ln)
-	// If server is closed from the signal handler, wait for the ctx to be done
-	// otherwise error out quickly
-	if !errors.Is(err, http.ErrServerClosed) {
-		return err
-	}
-	<-ctx.Done()
-	return nil
+	return s.Run(ctx)
 }
 
 func waitForStream(c *gin.Context, ch chan interface{}) {
@@ -1314,33 +1663,70 @@ func waitForStream(c *gin.Context, ch chan interface{}) {
 }
 
 func streamResponse(c *gin.Context, ch chan any) {
+	streamPacedResponse(c, ch, 0)
+}
+
+// streamPacedResponse is streamResponse, streaming at most rate tokens per
+// second if rate is positive
+func streamPacedResponse(c *gin.Context, ch chan any, rate float32) {
 	c.Header("Content-Type", "application/x-ndjson")
-	c.Stream(func(w io.Writer) bool {
-		val, ok := <-ch
-		if !ok {
-			return false
-		}
+	// stop proxies such as nginx from buffering the stream
+	c.Header("X-Accel-Buffering", "no")
 
-		bts, err := json.Marshal(val)
-		if err != nil {
-			slog.Info(fmt.Sprintf("streamResponse: json.Marshal failed with %s", err))
-			return false
-		}
+	sw := newStreamWriter(c.Writer, envconfig.FlushInterval(), envconfig.WriteTimeout())
+	if sw.bucket = newTokenBucket(float64(rate), 1); sw.bucket != nil {
+		ch = bufferStream(c.Request.Context(), ch)
+	}
+	defer sw.stop()
 
-		// Delineate chunks with new-line delimiter
-		bts = append(bts, '\n')
-		if _, err := w.Write(bts); err != nil {
-			slog.Info(fmt.Sprintf("streamResponse: w.Write failed with %s", err))
-			return false
-		}
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-sw.flushC:
+			if err := sw.flush(); err != nil {
+				slog.Info(fmt.Sprintf("streamResponse: flush failed with %s", err))
+				return
+			}
+		case val, ok := <-ch:
+			if !ok {
+				if err := sw.flush(); err != nil {
+					slog.Info(fmt.Sprintf("streamResponse: flush failed with %s", err))
+				}
+				return
+			}
 
-		return true
-	})
+			if err := sw.pace(c.Request.Context(), streamedTokens(val)); err != nil {
+				return
+			}
+
+			bts, err := json.Marshal(val)
+			if err != nil {
+				slog.Info(fmt.Sprintf("streamResponse: json.Marshal failed with %s", err))
+				return
+			}
+
+			// Delineate chunks with new-line delimiter
+			bts = append(bts, '\n')
+			if err := sw.write(bts); err != nil {
+				slog.Info(fmt.Sprintf("streamResponse: w.Write failed with %s", err))
+				return
+			}
+		}
+	}
 }
 
 func (s *Server) PsHandler(c *gin.Context) {
 	models := []api.ProcessModelResponse{}
 
+	// names of the models with an old version draining
+	swapping := make(map[string]bool)
+	for _, v := range s.sched.loaded {
+		if v.draining {
+			swapping[v.model.Name] = true
+		}
+	}
+
 	for _, v := range s.sched.loaded {
 		model := v.model
 		modelDetails := api.ModelDetails{
@@ -1352,13 +1738,15 @@ func (s *Server) PsHandler(c *gin.Context) {
 		}
 
 		mr := api.ProcessModelResponse{
-			Model:     model.ShortName,
-			Name:      model.ShortName,
-			Size:      int64(v.estimatedTotal),
-			SizeVRAM:  int64(v.estimatedVRAM),
-			Digest:    model.Digest,
-			Details:   modelDetails,
-			ExpiresAt: v.expiresAt,
+			Model:      model.ShortName,
+			Name:       model.ShortName,
+			Size:       int64(v.estimatedTotal),
+			SizeVRAM:   int64(v.estimatedVRAM),
+			Digest:     model.Digest,
+			Details:    modelDetails,
+			ExpiresAt:  v.expiresAt,
+			Advisories: v.advisories,
+			Placement:  v.placement(),
 		}
 		// The scheduler waits to set expiresAt, so if a model is loading it's
 		// possible that it will be set to the unix epoch. For those cases, just
@@ -1368,6 +1756,13 @@ func (s *Server) PsHandler(c *gin.Context) {
 			mr.ExpiresAt = time.Now().Add(v.sessionDuration)
 		}
 
+		switch {
+		case v.draining:
+			mr.Swap = "draining"
+		case v.loading && swapping[model.Name]:
+			mr.Swap = "loading"
+		}
+
 		models = append(models, mr)
 	}
 
@@ -1383,7 +1778,7 @@ func (s *Server) ChatHandler(c *gin.Context) {
 	checkpointStart := time.Now()
 
 	var req api.ChatRequest
-	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+	if err := bindRequest(c, &req); errors.Is(err, io.EOF) {
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
 		return
 	} else if err != nil {
@@ -1391,13 +1786,25 @@ func (s *Server) ChatHandler(c *gin.Context) {
 		return
 	}
 
+	if req.NoStore {
+		c.Request = c.Request.WithContext(withNoStore(c.Request.Context()))
+	}
+
+	auditOf(c).request(req.Model, req.Options, req.Metadata, noStore(c.Request.Context()))
+
+	var err error
+	if req.Format, err = responseFormat(req.Format); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	// expire the runner
 	if len(req.Messages) == 0 && req.KeepAlive != nil && int(req.KeepAlive.Seconds()) == 0 {
 		model, err := GetModel(req.Model)
 		if err != nil {
 			switch {
 			case os.IsNotExist(err):
-				c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model '%s' not found", req.Model)})
+				c.JSON(http.StatusNotFound, errorBody(api.ErrorCodeModelNotFound, fmt.Sprintf("model '%s' not found", req.Model)))
 			case err.Error() == errtypes.InvalidModelNameErrMsg:
 				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			default:
@@ -1417,25 +1824,160 @@ func (s *Server) ChatHandler(c *gin.Context) {
 		return
 	}
 
+	// tools the model can't call aren't rendered in the prompt or parsed
+	// from the response
+	if req.ToolChoice != nil && req.ToolChoice.Type == "none" {
+		req.Tools = nil
+	}
+
 	caps := []Capability{CapabilityCompletion}
 	if len(req.Tools) > 0 {
 		caps = append(caps, CapabilityTools)
 	}
 
+	if req.Think != nil && *req.Think {
+		caps = append(caps, CapabilityThinking)
+	}
+
+	if err := checkMetadata(req.Metadata); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	for i, doc := range req.Documents {
+		if doc.Content == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("document %d has no content", i)})
+			return
+		}
+	}
+
+	if err := checkTruncation(req.Truncation); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := checkLogprobs(req.Logprobs, req.TopLogprobs); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := checkToolValidation(req.ToolValidation); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := checkImageRegions(req.Messages); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	switch {
+	case req.Raw && len(req.Documents) > 0:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "documents aren't supported with raw chat requests"})
+		return
+	case req.Raw && req.Prompt == "" && len(req.Messages) > 0:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "raw chat requests need a prompt"})
+		return
+	case !req.Raw && req.Prompt != "":
+		c.JSON(http.StatusBadRequest, gin.H{"error": "prompt is only supported with raw chat requests"})
+		return
+	}
+
+	if noStore(c.Request.Context()) && req.Session != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no_store can't be used with sessions"})
+		return
+	}
+
+	var session *chatSession
+	if req.Session != "" {
+		if req.Raw {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "sessions aren't supported with raw chat requests"})
+			return
+		}
+
+		session, err = getChatSession(req.Session, ownerOf(c))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+
+		req.Model = cmp.Or(req.Model, session.model)
+		req.Memory = cmp.Or(req.Memory, session.memory)
+		req.Truncation = cmp.Or(req.Truncation, session.truncation)
+		req.Options = session.chatOptions(req.Options)
+		if req.KeepAlive == nil {
+			req.KeepAlive = session.keepAlive
+		}
+	}
+
+	if req.Memory != "" {
+		switch {
+		case req.Raw:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "memory isn't supported with raw chat requests"})
+			return
+		case s.memories == nil:
+			c.JSON(http.StatusNotImplemented, gin.H{"error": errMemoryUnavailable.Error()})
+			return
+		}
+
+		if err := checkMemoryKey(req.Memory); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if !s.claimMemories(c, req.Memory) {
+			return
+		}
+	}
+
 	name := model.ParseName(req.Model)
 	if !name.IsValid() {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "model is required"})
 		return
 	}
-	name, err := getExistingName(name)
+	name, err = getExistingName(name)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "model is required"})
 		return
 	}
 
-	r, m, opts, err := s.scheduleRunner(c.Request.Context(), name.String(), caps, req.Options, req.KeepAlive)
+	if session != nil {
+		if name.String() != session.model {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("session %s chats with %q", session.id, session.model)})
+			return
+		}
+
+		if err := session.begin(); err != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		defer session.end()
+	}
+
+	// memories are recalled before the chat's runner is scheduled, so the
+	// model that embeds them isn't waited for while it's held
+	var memories []string
+	if req.Memory != "" && len(req.Messages) > 0 {
+		chat := req.Messages
+		if session != nil {
+			chat = append(session.history(), req.Messages...)
+		}
+		memories = s.recallMemories(c.Request.Context(), req.Memory, name.String(), memoryQuery(chat))
+	}
+
+	// the runner is held until release is called
+	ctx, release := context.WithCancel(c.Request.Context())
+	defer func() { release() }()
+
+	// events are only sent in streamed responses
+	events := req.Events && (req.Stream == nil || *req.Stream)
+	if events {
+		ctx = withPhases(ctx, phaseWriter(c, req.Model))
+	}
+
+	r, m, opts, err := s.scheduleRunner(ctx, name.String(), caps, req.Options, req.KeepAlive)
 	if errors.Is(err, errCapabilityCompletion) {
-		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%q does not support chat", req.Model)})
+		c.JSON(http.StatusBadRequest, errorBody(api.ErrorCodeUnsupportedCapability, fmt.Sprintf("%q does not support chat", req.Model)))
 		return
 	} else if err != nil {
 		handleScheduleError(c, req.Model, err)
@@ -1443,47 +1985,253 @@ func (s *Server) ChatHandler(c *gin.Context) {
 	}
 
 	checkpointLoaded := time.Now()
+	setProvenance(c, m, opts, req)
 
-	if len(req.Messages) == 0 {
-		c.JSON(http.StatusOK, api.ChatResponse{
+	if len(req.Messages) == 0 && req.Prompt == "" {
+		res := api.ChatResponse{
 			Model:      req.Model,
 			CreatedAt:  time.Now().UTC(),
 			Message:    api.Message{Role: "assistant"},
 			Done:       true,
 			DoneReason: "load",
-		})
+		}
+
+		if events {
+			writeEvent(c, api.Event{Phase: api.PhaseDone, Model: req.Model, CreatedAt: res.CreatedAt, Chat: &res})
+			return
+		}
+
+		c.JSON(http.StatusOK, res)
 		return
 	}
 
-	msgs := append(m.Messages, req.Messages...)
-	if req.Messages[0].Role != "system" && m.System != "" {
-		msgs = append([]api.Message{{Role: "system", Content: m.System}}, msgs...)
-	}
+	var prompt string
+	var images []llm.ImageData
+	var stats promptStats
+	var sessionTruncated int
+	var history []api.Message
+	if req.Raw {
+		prompt, images, err = rawChatPrompt(m, req.Prompt, req.Messages)
+	} else {
+		chat := req.Messages
+		if session != nil {
+			chat = append(session.history(), req.Messages...)
+		}
+		history = chat
 
-	prompt, images, err := chatPrompt(c.Request.Context(), m, r.Tokenize, opts, msgs, req.Tools)
-	if err != nil {
+		msgs := append(m.Messages, chat...)
+		if chat[0].Role != "system" && m.System != "" {
+			msgs = append([]api.Message{{Role: "system", Content: m.System}}, msgs...)
+		}
+
+		msgs, err = withToolPrompt(msgs, m, req.Tools)
+		if err != nil {
+			slog.Error("tool prompt error", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		msgs = withDocuments(msgs, req.Documents)
+		if opts.FormatExample {
+			msgs = withFormatExample(msgs, m.Template, req.Format)
+		}
+		msgs = withResponseLanguage(msgs, m.Template, opts)
+
+		msgs = withMemories(msgs, memories)
+
+		tr := truncation{strategy: req.Truncation, summarize: summarizer(r, m, opts)}
+		pctx, span := tracing.StartSpan(c.Request.Context(), "prompt", tracing.KindInternal)
+		prompt, images, stats, err = chatPrompt(pctx, m, r.Tokenize, opts, msgs, req.Tools, tr)
+		span.SetAttributes(tracing.Int("ollama.prompt_tokens", stats.tokens), tracing.Int("ollama.truncated_messages", stats.truncated))
+		span.SetError(err)
+		span.End()
+
+		// messages dropped from a session stay dropped so later turns start
+		// with the same prompt, which the runner has cached
+		if session != nil && (req.Truncation == "" || req.Truncation == truncateDrop) {
+			sessionTruncated = max(stats.truncated-nonSystemMessages(m.Messages), 0)
+		}
+	}
+	if errors.Is(err, errTruncated) || errors.Is(err, template.ErrToolResults) || errors.Is(err, errImageRegion) {
+		c.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	} else if err != nil {
 		slog.Error("chat prompt error", "error", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	slog.Debug("chat request", "images", len(images), "prompt", prompt)
+	if !noStore(c.Request.Context()) {
+		slog.Debug("chat request", "images", len(images), "prompt", prompt)
+	}
+
+	pp, err := newPostProcessor(opts.PostProcess, opts.Stop)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if opts.TrimWhitespace {
+		pp = append(pp, &trimFilter{})
+	}
+
+	// end of generation tokens can only be ignored with raw prompts since
+	// templates rely on them to end the response
+	if !req.Raw {
+		opts.IgnoreEOS = false
+	}
+
+	format := req.Format
+	var toolCalls *toolCallStream
+	if len(format) == 0 {
+		format, err = m.chatFormat(req.Tools, req.ToolChoice, req.ParallelToolCalls)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// responses constrained to tool calls are streamed as deltas, unless
+		// they may be generated again
+		if format != nil && req.ToolValidation != toolValidationRetry {
+			name, arguments, _ := m.toolCallKeys()
+			toolCalls = &toolCallStream{name: name, arguments: arguments}
+		}
+	}
+
+	// the reasoning of models that think before they answer is separated
+	// from their content. Constrained responses can't reason.
+	var think *thinkingParser
+	if tag := m.thinkingTag(); tag != "" {
+		if req.Think != nil && !*req.Think && !req.Raw {
+			prompt = withoutThinking(prompt, tag)
+		}
+
+		if len(format) == 0 {
+			think = newThinkingParser(prompt, tag)
+		}
+	}
+
+	// responses with invalid tool calls are generated again constrained to
+	// calls matching the tools' parameters, unless the request has its own
+	// format
+	var retryFormat json.RawMessage
+	if req.ToolValidation == toolValidationRetry && len(req.Format) == 0 && len(req.Tools) > 0 {
+		choice := &api.ToolChoice{Type: "required"}
+		if req.ToolChoice != nil && req.ToolChoice.Type == "function" {
+			choice = req.ToolChoice
+		}
+
+		retryFormat, err = m.chatFormat(strictTools(req.Tools), choice, req.ParallelToolCalls)
+		if err != nil {
+			slog.Debug("tool calls can't be constrained, they won't be generated again", "error", err)
+			retryFormat = nil
+		}
+	}
+
+	ticket, err := admitPrefill(m.ModelPath, prompt, images, req.Stream == nil || *req.Stream)
+	if err != nil {
+		handleScheduleError(c, req.Model, err)
+		return
+	}
 
 	ch := make(chan any)
 	go func() {
 		defer close(ch)
-		var sb strings.Builder
+		defer ticket.done()
+		var sb, content strings.Builder
 		var toolCallIndex int = 0
-		if err := r.Completion(c.Request.Context(), llm.CompletionRequest{
-			Prompt:  prompt,
-			Images:  images,
-			Format:  req.Format,
-			Options: opts,
-		}, func(r llm.CompletionResponse) {
+		var started bool
+
+		// generated is the text of the response before its reasoning is
+		// separated, of spent responses, and thought is the number of
+		// responses it's been reasoning for. Responses are generally a token
+		// each.
+		var generated, reasoning strings.Builder
+		var spent, thought int
+		var overBudget bool
+
+		// logprobs are those of the content held back from the responses
+		// sent so far
+		var logprobs []api.Logprob
+
+		// invalid is set when the response's tool calls don't match their
+		// tools and it's being generated again
+		var invalid, retried bool
+		completionCtx, cancelCompletion := context.WithCancel(c.Request.Context())
+		defer cancelCompletion()
+
+		// checkToolCalls returns the errors of calls, or false if the
+		// response is to be generated again
+		checkToolCalls := func(calls []api.ToolCall) ([]api.ToolCallError, bool) {
+			if req.ToolValidation == "" {
+				return nil, true
+			}
+
+			errs := validateToolCalls(req.Tools, calls)
+			if len(errs) > 0 && retryFormat != nil && !retried {
+				slog.Debug("tool calls don't match their tools, generating again", "errors", errs)
+				invalid = true
+				cancelCompletion()
+				return nil, false
+			}
+
+			return errs, true
+		}
+
+		completionReq := llm.CompletionRequest{
+			Prompt:      prompt,
+			Images:      images,
+			Format:      format,
+			Options:     opts,
+			Logprobs:    req.Logprobs,
+			TopLogprobs: req.TopLogprobs,
+			NoStore:     noStore(c.Request.Context()),
+			Scripts:     responseScripts(opts),
+		}
+		fn := ticket.track(func(r llm.CompletionResponse) {
+			if invalid || overBudget {
+				return
+			}
+
+			started = true
+			var thinking string
+			if think != nil {
+				generated.WriteString(r.Content)
+				spent++
+				inReasoning := think.Thinking()
+				thinking, r.Content = think.Write(r.Content)
+				if r.Done {
+					t, c := think.Flush()
+					thinking, r.Content = thinking+t, r.Content+c
+				}
+				reasoning.WriteString(thinking)
+
+				// reasoning over budget is cut off, and the response is
+				// generated again from the end of it
+				if inReasoning && think.Thinking() && opts.ThinkBudget > 0 && !r.Done {
+					if thought++; thought >= opts.ThinkBudget {
+						overBudget = true
+						cancelCompletion()
+					}
+				}
+			}
+
+			r.Content = pp.Write(r.Content)
+			if r.Done {
+				r.Content += pp.Flush()
+			}
+			content.WriteString(r.Content)
+			logprobs = append(logprobs, r.Logprobs...)
+
+			send := func(res api.ChatResponse) {
+				res.Logprobs, logprobs = logprobs, nil
+				ch <- res
+			}
+
 			res := api.ChatResponse{
 				Model:      req.Model,
 				CreatedAt:  time.Now().UTC(),
-				Message:    api.Message{Role: "assistant", Content: r.Content},
+				Message:    api.Message{Role: "assistant", Content: r.Content, Thinking: thinking},
 				Done:       r.Done,
 				DoneReason: r.DoneReason,
 				Metrics: api.Metrics{
@@ -1491,19 +2239,61 @@ func (s *Server) ChatHandler(c *gin.Context) {
 					PromptEvalDuration: r.PromptEvalDuration,
 					EvalCount:          r.EvalCount,
 					EvalDuration:       r.EvalDuration,
+					DraftCount:         r.DraftCount,
+					DraftAcceptedCount: r.DraftAcceptedCount,
 				},
 			}
 
 			if r.Done {
 				res.TotalDuration = time.Since(checkpointStart)
 				res.LoadDuration = checkpointLoaded.Sub(checkpointStart)
+				res.Degraded = s.sched.loadedDegraded(m)
+				res.Advisories = s.sched.takeAdvisories(m)
+				res.Diagnostics = r.Diagnostics
+				if r.Profile != nil {
+					res.ProfileID = profiles.add(req.Model, ownerOf(c), r.Profile)
+				}
+				res.Metadata = req.Metadata
+				res.TruncatedMessages = stats.truncated
+				res.PromptTokens = stats.tokens
+				res.ImageTokens = stats.imageTokens
+				if !noStore(c.Request.Context()) {
+					logRequest("chat", req.Model, req.Metadata, res.Metrics)
+				}
+				auditOf(c).completed(prompt, content.String(), res.Metrics)
+				s.recordUsage(c.Request.Context(), req.Model, res.PromptEvalCount, res.EvalCount)
+				s.metrics.recordCompletion(req.Model, req.Metadata, res.Metrics, res.Diagnostics, stats.truncated)
+				if !noStore(c.Request.Context()) && len(history) > 0 && sampleShadow(opts) {
+					s.shadow("chat", req.Model, opts, content.String(), res.Metrics, func(ctx context.Context, name string) (llm.CompletionResponse, error) {
+						return s.chatCompletion(ctx, name, history, req.Tools, req.Format, shadowOptions(req.Options), req.KeepAlive)
+					})
+				}
+				if len(req.Documents) > 0 {
+					res.Citations = parseCitations(content.String(), req.Documents)
+				}
 			}
 
 			// TODO: tool call checking and filtering should be moved outside of this callback once streaming
 			// however this was a simple change for now without reworking streaming logic of this (and other)
 			// handlers
 			if req.Stream != nil && !*req.Stream || len(req.Tools) == 0 {
-				ch <- res
+				if req.ToolValidation != "" && len(req.Tools) > 0 {
+					// the response is sent once it's complete so its tool
+					// calls can be checked
+					if !r.Done {
+						return
+					}
+
+					res.Message.Content = content.String()
+					res.Message.Thinking = reasoning.String()
+					if calls, ok := m.parseToolCalls(res.Message.Content); ok {
+						if res.ToolCallErrors, ok = checkToolCalls(calls); !ok {
+							return
+						}
+					}
+				}
+
+				send(res)
 				return
 			}
 
@@ -1511,15 +2301,36 @@ func (s *Server) ChatHandler(c *gin.Context) {
 			// If tools are recognized, use a flag to track the sending of a tool downstream
 			// This ensures that content is cleared from the message on the last chunk sent
 			sb.WriteString(r.Content)
+			if toolCalls != nil {
+				res.Message.ToolCallDeltas = toolCalls.write(r.Content)
+			}
+
 			if toolCalls, ok := m.parseToolCalls(sb.String()); ok {
+				errs, ok := checkToolCalls(toolCalls)
+				if !ok {
+					return
+				}
+
+				for i := range errs {
+					errs[i].Index += toolCallIndex
+				}
+
 				res.Message.ToolCalls = toolCalls
+				res.Message.RawToolCalls = sb.String()
+				res.ToolCallErrors = errs
 				for i := range toolCalls {
 					toolCalls[i].Function.Index = toolCallIndex
 					toolCallIndex++
 				}
 				res.Message.Content = ""
 				sb.Reset()
-				ch <- res
+				send(res)
+				return
+			}
+
+			if len(res.Message.ToolCallDeltas) > 0 || res.Message.Thinking != "" && !r.Done {
+				res.Message.Content = ""
+				send(res)
 				return
 			}
 
@@ -1528,20 +2339,88 @@ func (s *Server) ChatHandler(c *gin.Context) {
 				if toolCallIndex == 0 {
 					res.Message.Content = sb.String()
 				}
-				ch <- res
+				send(res)
 			}
-		}); err != nil {
-			ch <- gin.H{"error": err.Error()}
+		})
+
+		err := r.Completion(completionCtx, completionReq, fn)
+		if err != nil && !started && errorCode(err) == api.ErrorCodeOutOfMemory {
+			r, err = s.rescheduleOutOfMemory(c.Request.Context(), m, &release, err, name.String(), caps, req.Options, req.KeepAlive)
+			if err == nil {
+				err = r.Completion(completionCtx, completionReq, fn)
+			}
+		}
+
+		if overBudget {
+			overBudget = false
+			completionReq.Prompt = think.cutOff(prompt, generated.String())
+
+			// the reasoning generated so far counts towards num_predict
+			if opts.NumPredict > 0 {
+				cutOffOpts := *opts
+				cutOffOpts.NumPredict = max(opts.NumPredict-spent, 1)
+				completionReq.Options = &cutOffOpts
+			}
+
+			completionCtx, cancelCompletion = context.WithCancel(c.Request.Context())
+			defer cancelCompletion()
+			err = r.Completion(completionCtx, completionReq, fn)
+		}
+
+		if invalid {
+			invalid, retried = false, true
+			sb.Reset()
+			content.Reset()
+			logprobs = nil
+			// discard the text held back from the invalid response
+			pp.Flush()
+			// the reasoning is kept, since calls generated again are
+			// constrained and can't reason
+			think = nil
+
+			completionReq.Format = retryFormat
+			err = r.Completion(c.Request.Context(), completionReq, fn)
+		}
+
+		if err != nil {
+			ch <- errorResponse(err)
+			return
+		}
+
+		remember := req.Memory != "" && !noStore(c.Request.Context())
+		if session == nil && !remember {
+			return
+		}
+
+		reply := api.Message{Role: "assistant", Content: content.String()}
+		if calls, ok := m.parseToolCalls(reply.Content); ok && len(req.Tools) > 0 {
+			reply.Content, reply.ToolCalls = "", calls
+		}
+
+		turn := append(slices.Clone(req.Messages), reply)
+		if session != nil {
+			session.commit(turn, sessionTruncated)
+
+			if req.Truncation == truncateSummarize && float64(stats.tokens) > checkpointThreshold*float64(opts.NumCtx) {
+				go s.checkpoint(session, name.String(), req.Options, req.KeepAlive)
+			}
+		}
+
+		if remember {
+			go s.rememberChat(req.Memory, name.String(), req.Options, req.KeepAlive, turn)
 		}
 	}()
 
 	if req.Stream != nil && !*req.Stream {
 		var resp api.ChatResponse
-		var sb strings.Builder
+		var sb, thinking strings.Builder
+		var logprobs []api.Logprob
 		for rr := range ch {
 			switch t := rr.(type) {
 			case api.ChatResponse:
 				sb.WriteString(t.Message.Content)
+				thinking.WriteString(t.Message.Thinking)
+				logprobs = append(logprobs, t.Logprobs...)
 				resp = t
 			case gin.H:
 				msg, ok := t["error"].(string)
@@ -1549,7 +2428,7 @@ func (s *Server) ChatHandler(c *gin.Context) {
 					msg = "unexpected error format in response"
 				}
 
-				c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+				c.JSON(http.StatusInternalServerError, errorBody(errorCodeOf(t), msg))
 				return
 			default:
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "unexpected response"})
@@ -1558,10 +2437,13 @@ func (s *Server) ChatHandler(c *gin.Context) {
 		}
 
 		resp.Message.Content = sb.String()
+		resp.Message.Thinking = thinking.String()
+		resp.Logprobs = logprobs
 
 		if len(req.Tools) > 0 {
 			if toolCalls, ok := m.parseToolCalls(sb.String()); ok {
 				resp.Message.ToolCalls = toolCalls
+				resp.Message.RawToolCalls = sb.String()
 				resp.Message.Content = ""
 			}
 		}
@@ -1570,20 +2452,44 @@ func (s *Server) ChatHandler(c *gin.Context) {
 		return
 	}
 
-	streamResponse(c, ch)
+	out := ch
+	if events {
+		out = streamEvents(c.Request.Context(), ch, api.Event{
+			Phase:        api.PhasePrefill,
+			Model:        req.Model,
+			CreatedAt:    time.Now().UTC(),
+			PromptTokens: stats.tokens,
+		})
+	}
+
+	streamPacedResponse(c, out, opts.StreamRate)
 }
 
 func handleScheduleError(c *gin.Context, name string, err error) {
+	if c.Writer.Written() {
+		// the model failed to load after its loading event was sent
+		writeEventError(c, err)
+		return
+	}
+
 	switch {
-	case errors.Is(err, errCapabilities), errors.Is(err, errRequired):
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, errCapabilities), errors.Is(err, errRequired), errors.Is(err, errDraftModel), errors.Is(err, errFallback), errors.Is(err, errWatermark), errors.Is(err, errStop), errors.Is(err, errProfile), errors.Is(err, errResponseLanguage), errors.Is(err, errTensorSplit), errors.Is(err, errSplitPolicy), errors.Is(err, errShadow):
+		c.JSON(http.StatusBadRequest, errorResponse(err))
+	case errors.Is(err, errLicenseNotAccepted):
+		c.JSON(http.StatusForbidden, errorResponse(err))
 	case errors.Is(err, context.Canceled):
 		c.JSON(499, gin.H{"error": "request canceled"})
 	case errors.Is(err, ErrMaxQueue):
-		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		c.JSON(http.StatusServiceUnavailable, errorResponse(err))
+	case errors.Is(err, ErrTTFTTarget):
+		var ae *admissionError
+		if errors.As(err, &ae) {
+			c.Header("Retry-After", retryAfterSeconds(ae.retryAfter))
+		}
+		c.JSON(http.StatusServiceUnavailable, errorResponse(err))
 	case errors.Is(err, os.ErrNotExist):
-		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model %q not found, try pulling it first", name)})
+		c.JSON(http.StatusNotFound, errorBody(api.ErrorCodeModelNotFound, fmt.Sprintf("model %q not found, try pulling it first", name)))
 	default:
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusInternalServerError, errorResponse(err))
 	}
 }
@@ -19,6 +19,7 @@ import (
 	"github.com/ollama/ollama/api"
 	"github.com/ollama/ollama/envconfig"
 	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/template"
 )
 
 var stream bool = false
@@ -709,4 +710,37 @@ func TestCreateDetectTemplate(t *testing.T) {
 			filepath.Join(p, "blobs", "sha256-ca239d7bd8ea90e4a5d2e6bf88f8d74a47b14336e73eb4e18bed4dd325018116"),
 		})
 	})
+
+	t.Run("architecture", func(t *testing.T) {
+		_, digest := createBinFile(t, llm.KV{
+			"general.architecture": "qwen2",
+		}, nil)
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Name:   "test",
+			Files:  map[string]string{"test.gguf": digest},
+			Stream: &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d", w.Code)
+		}
+
+		m, err := GetModel("test")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want, err := template.Detect("qwen2", "", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if m.Template.String() != string(want.Bytes) {
+			t.Errorf("expected chatml template, got %q", m.Template.String())
+		}
+
+		if m.Options["stop"] == nil {
+			t.Error("expected stop parameters")
+		}
+	})
 }
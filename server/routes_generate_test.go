@@ -4,8 +4,10 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
+	"net/http/httptest"
 	"strings"
 	"sync"
 	"testing"
@@ -26,6 +28,10 @@ type mockRunner struct {
 	llm.CompletionRequest
 	llm.CompletionResponse
 	CompletionFn func(context.Context, llm.CompletionRequest, func(llm.CompletionResponse)) error
+	EmbeddingFn  func(context.Context, string) ([]float32, error)
+	DetokenizeFn func(context.Context, []int) (string, error)
+
+	KvCacheUsed, KvCacheSize int
 }
 
 func (m *mockRunner) Completion(ctx context.Context, r llm.CompletionRequest, fn func(r llm.CompletionResponse)) error {
@@ -37,6 +43,18 @@ func (m *mockRunner) Completion(ctx context.Context, r llm.CompletionRequest, fn
 	return nil
 }
 
+func (m *mockRunner) Embedding(ctx context.Context, input string) ([]float32, error) {
+	return m.EmbeddingFn(ctx, input)
+}
+
+func (m *mockRunner) Detokenize(ctx context.Context, tokens []int) (string, error) {
+	return m.DetokenizeFn(ctx, tokens)
+}
+
+func (m *mockRunner) KvCacheUsage(context.Context) (int, int, error) {
+	return m.KvCacheUsed, m.KvCacheSize, nil
+}
+
 func (mockRunner) Tokenize(_ context.Context, s string) (tokens []int, err error) {
 	for range strings.Fields(s) {
 		tokens = append(tokens, len(tokens))
@@ -51,6 +69,61 @@ func newMockServer(mock *mockRunner) func(discover.GpuInfoList, string, *llm.GGM
 	}
 }
 
+// newMockScheduler returns a running scheduler that loads every model with
+// mock
+func newMockScheduler(t *testing.T, mock *mockRunner) *Scheduler {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	s := &Scheduler{
+		pendingReqCh:  make(chan *LlmRequest, 16),
+		finishedReqCh: make(chan *LlmRequest, 1),
+		expiredCh:     make(chan *runnerRef, 1),
+		unloadedCh:    make(chan any, 1),
+		loaded:        make(map[string]*runnerRef),
+		newServerFn:   newMockServer(mock),
+		getGpuFn:      discover.GetGPUInfo,
+		getCpuFn:      discover.GetCPUInfo,
+		reschedDelay:  250 * time.Millisecond,
+		loadFn: func(req *LlmRequest, ggml *llm.GGML, gpus discover.GpuInfoList, numParallel int) {
+			req.successCh <- &runnerRef{
+				llama: mock,
+			}
+		},
+	}
+
+	go s.Run(ctx)
+	return s
+}
+
+// createMockModel creates a model named name with a minimal llama file
+func createMockModel(t *testing.T, s *Server, name, template string) {
+	_, digest := createBinFile(t, llm.KV{
+		"general.architecture":          "llama",
+		"llama.block_count":             uint32(1),
+		"llama.context_length":          uint32(8192),
+		"llama.embedding_length":        uint32(4096),
+		"llama.attention.head_count":    uint32(32),
+		"llama.attention.head_count_kv": uint32(8),
+		"tokenizer.ggml.tokens":         []string{""},
+		"tokenizer.ggml.scores":         []float32{0},
+		"tokenizer.ggml.token_type":     []int32{0},
+	}, []llm.Tensor{
+		{Name: "token_embd.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "output.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+	})
+
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:    name,
+		Files:    map[string]string{"file.gguf": digest},
+		Template: template,
+		Stream:   &stream,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+}
+
 func TestGenerateChat(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 
@@ -176,7 +249,7 @@ func TestGenerateChat(t *testing.T) {
 			t.Errorf("expected status 400, got %d", w.Code)
 		}
 
-		if diff := cmp.Diff(w.Body.String(), `{"error":"\"bert\" does not support chat"}`); diff != "" {
+		if diff := cmp.Diff(w.Body.String(), `{"code":"unsupported_capability","error":"\"bert\" does not support chat"}`); diff != "" {
 			t.Errorf("mismatch (-got +want):\n%s", diff)
 		}
 	})
@@ -356,6 +429,116 @@ func TestGenerateChat(t *testing.T) {
 		checkChatResponse(t, w.Body, "test-system", "Abra kadabra!")
 	})
 
+	mock.CompletionResponse.Content = "Llamas are camelids [2]."
+	t.Run("messages with documents", func(t *testing.T) {
+		w := createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model: "test-system",
+			Messages: []api.Message{
+				{Role: "user", Content: "What are llamas?"},
+			},
+			Documents: []api.Document{
+				{ID: "a", Content: "Alpacas are smaller than llamas."},
+				{ID: "b", Title: "Llamas", Content: "Llamas are camelids."},
+			},
+			Stream: &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+
+		want := "system: You are a helpful assistant.\n\n" + documentsPrompt + "\n\n[1]\nAlpacas are smaller than llamas.\n\n[2] Llamas\nLlamas are camelids.\nuser: What are llamas?\n"
+		if diff := cmp.Diff(mock.CompletionRequest.Prompt, want); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+
+		var resp api.ChatResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if diff := cmp.Diff(resp.Citations, []api.Citation{{Index: 1, DocumentID: "b", Start: 20, End: 23}}); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+	})
+
+	t.Run("messages with metadata", func(t *testing.T) {
+		w := createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model:    "test-system",
+			Messages: []api.Message{{Role: "user", Content: "Hello!"}},
+			Metadata: map[string]string{"trace_id": "abc"},
+			Stream:   &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+
+		var resp api.ChatResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if diff := cmp.Diff(resp.Metadata, map[string]string{"trace_id": "abc"}); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+	})
+
+	t.Run("raw", func(t *testing.T) {
+		w := createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model: "test-system",
+			Messages: []api.Message{
+				{Role: "user", Content: "What's this?", Images: []api.ImageData{[]byte("image")}},
+			},
+			Raw:    true,
+			Prompt: "<user>[img-0] What's this?</user>",
+			Stream: &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+
+		if diff := cmp.Diff(mock.CompletionRequest.Prompt, "<user>[img-0] What's this?</user>"); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+
+		if len(mock.CompletionRequest.Images) != 1 || string(mock.CompletionRequest.Images[0].Data) != "image" {
+			t.Errorf("unexpected images %v", mock.CompletionRequest.Images)
+		}
+	})
+
+	t.Run("raw errors", func(t *testing.T) {
+		cases := map[string]api.ChatRequest{
+			"prompt without raw": {Prompt: "Hello!"},
+			"raw without prompt": {Raw: true},
+			"raw with documents": {Raw: true, Prompt: "Hello!", Documents: []api.Document{{Content: "doc"}}},
+		}
+
+		for name, req := range cases {
+			req.Model = "test-system"
+			req.Messages = []api.Message{{Role: "user", Content: "Hello!"}}
+			req.Stream = &stream
+
+			if w := createRequest(t, s.ChatHandler, req); w.Code != http.StatusBadRequest {
+				t.Errorf("%s: expected status 400, got %d", name, w.Code)
+			}
+		}
+	})
+
+	t.Run("messages with empty document", func(t *testing.T) {
+		w := createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model:     "test-system",
+			Messages:  []api.Message{{Role: "user", Content: "Hello!"}},
+			Documents: []api.Document{{ID: "a"}},
+			Stream:    &stream,
+		})
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", w.Code)
+		}
+	})
+
 	t.Run("messages with tools (non-streaming)", func(t *testing.T) {
 		if w.Code != http.StatusOK {
 			t.Fatalf("failed to create test-system model: %d", w.Code)
@@ -367,29 +550,17 @@ func TestGenerateChat(t *testing.T) {
 				Function: api.ToolFunction{
 					Name:        "get_weather",
 					Description: "Get the current weather",
-					Parameters: struct {
-						Type       string   `json:"type"`
-						Required   []string `json:"required"`
-						Properties map[string]struct {
-							Type        string   `json:"type"`
-							Description string   `json:"description"`
-							Enum        []string `json:"enum,omitempty"`
-						} `json:"properties"`
-					}{
+					Parameters: api.ToolParameters{
 						Type:     "object",
 						Required: []string{"location"},
-						Properties: map[string]struct {
-							Type        string   `json:"type"`
-							Description string   `json:"description"`
-							Enum        []string `json:"enum,omitempty"`
-						}{
+						Properties: map[string]api.ToolProperty{
 							"location": {
-								Type:        "string",
+								Type:        api.PropertyType{"string"},
 								Description: "The city and state",
 							},
 							"unit": {
-								Type: "string",
-								Enum: []string{"celsius", "fahrenheit"},
+								Type: api.PropertyType{"string"},
+								Enum: []any{"celsius", "fahrenheit"},
 							},
 						},
 					},
@@ -455,6 +626,66 @@ func TestGenerateChat(t *testing.T) {
 		if diff := cmp.Diff(resp.Message.ToolCalls[0], expectedToolCall); diff != "" {
 			t.Errorf("tool call mismatch (-got +want):\n%s", diff)
 		}
+
+		if resp.Message.RawToolCalls != mock.CompletionResponse.Content {
+			t.Errorf("expected raw tool calls %q, got %q", mock.CompletionResponse.Content, resp.Message.RawToolCalls)
+		}
+	})
+
+	t.Run("messages with parallel strict tools", func(t *testing.T) {
+		strict := []api.Tool{{
+			Type: "function",
+			Function: api.ToolFunction{
+				Name: "get_weather",
+				Parameters: api.ToolParameters{
+					Type:       "object",
+					Required:   []string{"location"},
+					Properties: map[string]api.ToolProperty{"location": {Type: api.PropertyType{"string"}}},
+				},
+				Strict: true,
+			},
+		}}
+
+		mock.CompletionResponse = llm.CompletionResponse{
+			Content:    `[{"name":"get_weather","arguments":{"location":"Seattle, WA"}},{"name":"get_weather","arguments":{"location":"Paris, FR"}}]`,
+			Done:       true,
+			DoneReason: "done",
+		}
+
+		w := createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model:             "test-system",
+			Messages:          []api.Message{{Role: "user", Content: "What's the weather in Seattle and Paris?"}},
+			Tools:             strict,
+			ParallelToolCalls: true,
+			Stream:            &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+
+		var format map[string]any
+		if err := json.Unmarshal(mock.CompletionRequest.Format, &format); err != nil {
+			t.Fatal(err)
+		}
+
+		if format["type"] != "array" {
+			t.Errorf("expected an array format, got %v", format)
+		}
+
+		var resp api.ChatResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		var locations []any
+		for _, tc := range resp.Message.ToolCalls {
+			locations = append(locations, tc.Function.Arguments["location"])
+		}
+
+		if diff := cmp.Diff([]any{"Seattle, WA", "Paris, FR"}, locations); diff != "" {
+			t.Errorf("tool calls mismatch (-want +got):\n%s", diff)
+		}
 	})
 
 	t.Run("messages with tools (streaming)", func(t *testing.T) {
@@ -464,29 +695,17 @@ func TestGenerateChat(t *testing.T) {
 				Function: api.ToolFunction{
 					Name:        "get_weather",
 					Description: "Get the current weather",
-					Parameters: struct {
-						Type       string   `json:"type"`
-						Required   []string `json:"required"`
-						Properties map[string]struct {
-							Type        string   `json:"type"`
-							Description string   `json:"description"`
-							Enum        []string `json:"enum,omitempty"`
-						} `json:"properties"`
-					}{
+					Parameters: api.ToolParameters{
 						Type:     "object",
 						Required: []string{"location"},
-						Properties: map[string]struct {
-							Type        string   `json:"type"`
-							Description string   `json:"description"`
-							Enum        []string `json:"enum,omitempty"`
-						}{
+						Properties: map[string]api.ToolProperty{
 							"location": {
-								Type:        "string",
+								Type:        api.PropertyType{"string"},
 								Description: "The city and state",
 							},
 							"unit": {
-								Type: "string",
-								Enum: []string{"celsius", "fahrenheit"},
+								Type: api.PropertyType{"string"},
+								Enum: []any{"celsius", "fahrenheit"},
 							},
 						},
 					},
@@ -554,6 +773,7 @@ func TestGenerateChat(t *testing.T) {
 		// Read and validate the streamed responses
 		decoder := json.NewDecoder(w.Body)
 		var finalToolCall api.ToolCall
+		var raw string
 
 		for {
 			var resp api.ChatResponse
@@ -568,9 +788,14 @@ func TestGenerateChat(t *testing.T) {
 					t.Errorf("expected 1 tool call in final response, got %d", len(resp.Message.ToolCalls))
 				}
 				finalToolCall = resp.Message.ToolCalls[0]
+				raw = resp.Message.RawToolCalls
 			}
 		}
 
+		if want := `{"name":"get_weather","arguments":{"location":"Seattle, WA","unit":"celsius"}}`; raw != want {
+			t.Errorf("expected raw tool calls %q, got %q", want, raw)
+		}
+
 		expectedToolCall := api.ToolCall{
 			Function: api.ToolCallFunction{
 				Name: "get_weather",
@@ -585,6 +810,191 @@ func TestGenerateChat(t *testing.T) {
 			t.Errorf("final tool call mismatch (-got +want):\n%s", diff)
 		}
 	})
+
+	t.Run("messages with strict tools (streaming)", func(t *testing.T) {
+		strict := []api.Tool{{
+			Type: "function",
+			Function: api.ToolFunction{
+				Name: "get_weather",
+				Parameters: api.ToolParameters{
+					Type:       "object",
+					Required:   []string{"location"},
+					Properties: map[string]api.ToolProperty{"location": {Type: api.PropertyType{"string"}}},
+				},
+				Strict: true,
+			},
+		}}
+
+		mock.CompletionFn = func(ctx context.Context, r llm.CompletionRequest, fn func(r llm.CompletionResponse)) error {
+			for _, content := range []string{`{"name":"get_`, `weather","arguments":{"loca`, `tion":"Seattle`, `, WA"}}`} {
+				fn(llm.CompletionResponse{Content: content})
+			}
+
+			fn(llm.CompletionResponse{Done: true, DoneReason: "stop"})
+			return nil
+		}
+
+		w := createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model:    "test-system",
+			Messages: []api.Message{{Role: "user", Content: "What's the weather in Seattle?"}},
+			Tools:    strict,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+
+		var deltas []api.ToolCallDelta
+		var toolCalls []api.ToolCall
+		decoder := json.NewDecoder(w.Body)
+		for {
+			var resp api.ChatResponse
+			if err := decoder.Decode(&resp); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if resp.Message.Content != "" {
+				t.Errorf("expected no content, got %q", resp.Message.Content)
+			}
+
+			deltas = append(deltas, resp.Message.ToolCallDeltas...)
+			toolCalls = append(toolCalls, resp.Message.ToolCalls...)
+		}
+
+		want := []api.ToolCallDelta{
+			{Name: "get_weather"},
+			{Arguments: `{"loca`},
+			{Arguments: `tion":"Seattle`},
+			{Arguments: `, WA"}`},
+		}
+		if diff := cmp.Diff(want, deltas); diff != "" {
+			t.Errorf("tool call deltas mismatch (-want +got):\n%s", diff)
+		}
+
+		if len(toolCalls) != 1 || toolCalls[0].Function.Arguments["location"] != "Seattle, WA" {
+			t.Errorf("unexpected tool calls %v", toolCalls)
+		}
+	})
+
+	t.Run("aborted generation", func(t *testing.T) {
+		diagnostics := &api.Diagnostics{Reason: "runner_crashed", Message: "GGML_ASSERT failed", LogID: "0123abcd"}
+		mock.CompletionFn = func(ctx context.Context, r llm.CompletionRequest, fn func(r llm.CompletionResponse)) error {
+			fn(llm.CompletionResponse{Content: "Hello"})
+			fn(llm.CompletionResponse{Done: true, DoneReason: "abort", Diagnostics: diagnostics})
+			return nil
+		}
+
+		stream := false
+		w := createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model:    "test-system",
+			Messages: []api.Message{{Role: "user", Content: "Hello!"}},
+			Stream:   &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+
+		var resp api.ChatResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.DoneReason != "abort" || resp.Message.Content != "Hello" {
+			t.Errorf("unexpected response %+v", resp)
+		}
+
+		if diff := cmp.Diff(diagnostics, resp.Diagnostics); diff != "" {
+			t.Errorf("diagnostics mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("messages with validated tools", func(t *testing.T) {
+		tools := []api.Tool{{
+			Type: "function",
+			Function: api.ToolFunction{
+				Name: "get_weather",
+				Parameters: api.ToolParameters{
+					Type:     "object",
+					Required: []string{"location"},
+					Properties: map[string]api.ToolProperty{
+						"location": {Type: api.PropertyType{"string"}},
+						"unit":     {Type: api.PropertyType{"string"}, Enum: []any{"celsius", "fahrenheit"}},
+					},
+				},
+			},
+		}}
+
+		var formats []json.RawMessage
+		mock.CompletionFn = func(ctx context.Context, r llm.CompletionRequest, fn func(r llm.CompletionResponse)) error {
+			formats = append(formats, r.Format)
+			content := `{"name":"get_weather","arguments":{"unit":"kelvin"}}`
+			if r.Format != nil {
+				content = `{"name":"get_weather","arguments":{"location":"Seattle, WA","unit":"celsius"}}`
+			}
+
+			fn(llm.CompletionResponse{Content: content})
+			fn(llm.CompletionResponse{Done: true, DoneReason: "stop"})
+			return nil
+		}
+
+		chat := func(validation string) api.ChatResponse {
+			t.Helper()
+
+			formats = nil
+			w := createRequest(t, s.ChatHandler, api.ChatRequest{
+				Model:          "test-system",
+				Messages:       []api.Message{{Role: "user", Content: "What's the weather in Seattle?"}},
+				Tools:          tools,
+				ToolValidation: validation,
+				Stream:         &stream,
+			})
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+			}
+
+			var resp api.ChatResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+
+			return resp
+		}
+
+		resp := chat("error")
+		want := []api.ToolCallError{{Name: "get_weather", Errors: []string{
+			`arguments: missing required "location"`,
+			`arguments.unit: "kelvin" isn't one of ["celsius","fahrenheit"]`,
+		}}}
+		if diff := cmp.Diff(want, resp.ToolCallErrors); diff != "" {
+			t.Errorf("tool call errors mismatch (-want +got):\n%s", diff)
+		}
+
+		if len(resp.Message.ToolCalls) != 1 || len(formats) != 1 {
+			t.Errorf("expected the invalid tool call once, got %v after %d completions", resp.Message.ToolCalls, len(formats))
+		}
+
+		resp = chat("retry")
+		if len(formats) != 2 || formats[0] != nil || formats[1] == nil {
+			t.Fatalf("expected the response to be generated again with a format, got %q", formats)
+		}
+
+		if len(resp.ToolCallErrors) > 0 || len(resp.Message.ToolCalls) != 1 || resp.Message.ToolCalls[0].Function.Arguments["location"] != "Seattle, WA" {
+			t.Errorf("expected a valid tool call, got %+v", resp)
+		}
+
+		w := createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model:          "test-system",
+			Messages:       []api.Message{{Role: "user", Content: "Hello!"}},
+			ToolValidation: "maybe",
+		})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", w.Code)
+		}
+	})
 }
 
 func TestGenerate(t *testing.T) {
@@ -669,7 +1079,7 @@ func TestGenerate(t *testing.T) {
 			t.Errorf("expected status 404, got %d", w.Code)
 		}
 
-		if diff := cmp.Diff(w.Body.String(), `{"error":"model '' not found"}`); diff != "" {
+		if diff := cmp.Diff(w.Body.String(), `{"code":"model_not_found","error":"model '' not found"}`); diff != "" {
 			t.Errorf("mismatch (-got +want):\n%s", diff)
 		}
 	})
@@ -680,7 +1090,7 @@ func TestGenerate(t *testing.T) {
 			t.Errorf("expected status 404, got %d", w.Code)
 		}
 
-		if diff := cmp.Diff(w.Body.String(), `{"error":"model '' not found"}`); diff != "" {
+		if diff := cmp.Diff(w.Body.String(), `{"code":"model_not_found","error":"model '' not found"}`); diff != "" {
 			t.Errorf("mismatch (-got +want):\n%s", diff)
 		}
 	})
@@ -709,7 +1119,7 @@ func TestGenerate(t *testing.T) {
 			t.Errorf("expected status 400, got %d", w.Code)
 		}
 
-		if diff := cmp.Diff(w.Body.String(), `{"error":"\"bert\" does not support generate"}`); diff != "" {
+		if diff := cmp.Diff(w.Body.String(), `{"code":"unsupported_capability","error":"\"bert\" does not support generate"}`); diff != "" {
 			t.Errorf("mismatch (-got +want):\n%s", diff)
 		}
 	})
@@ -725,7 +1135,7 @@ func TestGenerate(t *testing.T) {
 			t.Errorf("expected status 400, got %d", w.Code)
 		}
 
-		if diff := cmp.Diff(w.Body.String(), `{"error":"registry.ollama.ai/library/test:latest does not support insert"}`); diff != "" {
+		if diff := cmp.Diff(w.Body.String(), `{"code":"unsupported_capability","error":"registry.ollama.ai/library/test:latest does not support insert"}`); diff != "" {
 			t.Errorf("mismatch (-got +want):\n%s", diff)
 		}
 	})
@@ -958,4 +1368,266 @@ func TestGenerate(t *testing.T) {
 			t.Errorf("mismatch (-got +want):\n%s", diff)
 		}
 	})
+
+	t.Run("ignore eos", func(t *testing.T) {
+		for _, raw := range []bool{true, false} {
+			w := createRequest(t, s.GenerateHandler, api.GenerateRequest{
+				Model:   "test-system",
+				Prompt:  "Help me write tests.",
+				Raw:     raw,
+				Stream:  &stream,
+				Options: map[string]any{"ignore_eos": true},
+			})
+
+			if w.Code != http.StatusOK {
+				t.Errorf("expected status 200, got %d", w.Code)
+			}
+
+			if mock.CompletionRequest.Options.IgnoreEOS != raw {
+				t.Errorf("raw %v: expected ignore_eos %v, got %v", raw, raw, mock.CompletionRequest.Options.IgnoreEOS)
+			}
+		}
+	})
+}
+
+func TestGenerateDraftModel(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	mock := mockRunner{CompletionResponse: llm.CompletionResponse{
+		Done:               true,
+		DoneReason:         "stop",
+		EvalCount:          10,
+		DraftCount:         12,
+		DraftAcceptedCount: 9,
+	}}
+
+	s := Server{sched: newMockScheduler(t, &mock)}
+	createMockModel(t, &s, "test", `{{ .Prompt }}`)
+	createMockModel(t, &s, "draft", `{{ .Prompt }}`)
+
+	m, err := GetModel("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	draft, err := GetModel("draft")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts, err := modelOptions(m, map[string]any{"draft_model": "draft"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if opts.DraftModel != draft.ModelPath {
+		t.Errorf("expected draft model %q, got %q", draft.ModelPath, opts.DraftModel)
+	}
+
+	w := createRequest(t, s.GenerateHandler, api.GenerateRequest{
+		Model:   "test",
+		Prompt:  "Hello!",
+		Options: map[string]any{"draft_model": "missing"},
+		Stream:  &stream,
+	})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", w.Code)
+	}
+
+	w = createRequest(t, s.GenerateHandler, api.GenerateRequest{
+		Model:   "test",
+		Prompt:  "Hello!",
+		Options: map[string]any{"draft_model": "draft"},
+		Stream:  &stream,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp api.GenerateResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.DraftCount != 12 || resp.DraftAcceptedCount != 9 {
+		t.Errorf("expected 9 of 12 drafts accepted, got %d of %d", resp.DraftAcceptedCount, resp.DraftCount)
+	}
+}
+
+func TestChatRemoteModel(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	var prompt string
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/completions" {
+			http.NotFound(w, r)
+			return
+		}
+
+		var req struct {
+			Model  string `json:"model"`
+			Prompt string `json:"prompt"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if req.Model != "served-model" {
+			t.Errorf("expected model served-model, got %q", req.Model)
+		}
+
+		prompt = req.Prompt
+		fmt.Fprint(w, "data: {\"choices\":[{\"text\":\"Hi!\",\"finish_reason\":\"stop\"}]}\n\n")
+		fmt.Fprint(w, "data: {\"choices\":[],\"usage\":{\"prompt_tokens\":5,\"completion_tokens\":2}}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer remote.Close()
+
+	// remote models aren't scheduled, so the mock runner is never used
+	var mock mockRunner
+	s := Server{sched: newMockScheduler(t, &mock)}
+
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:    "remote",
+		Remote:   &api.RemoteModel{URL: remote.URL + "/v1", Model: "served-model"},
+		Template: `{{- range .Messages }}{{ .Role }}: {{ .Content }} {{ end }}`,
+		Stream:   &stream,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:  "invalid",
+		Remote: &api.RemoteModel{URL: "ftp://example.com"},
+		Stream: &stream,
+	})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", w.Code)
+	}
+
+	w = createRequest(t, s.ChatHandler, api.ChatRequest{
+		Model:    "remote",
+		Messages: []api.Message{{Role: "user", Content: "Hello!"}},
+		Stream:   &stream,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp api.ChatResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	if prompt != "user: Hello! " {
+		t.Errorf("expected the templated prompt, got %q", prompt)
+	}
+
+	if resp.Message.Content != "Hi!" || resp.PromptEvalCount != 5 || resp.EvalCount != 2 {
+		t.Errorf("unexpected response %+v", resp)
+	}
+
+	w = createRequest(t, s.ShowHandler, api.ShowRequest{Model: "remote"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var show api.ShowResponse
+	if err := json.NewDecoder(w.Body).Decode(&show); err != nil {
+		t.Fatal(err)
+	}
+
+	if from := "FROM " + remote.URL + "/v1#served-model"; !strings.Contains(show.Modelfile, from) {
+		t.Errorf("expected modelfile to contain %q, got %q", from, show.Modelfile)
+	}
+}
+
+func TestGenerateLogprobs(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	mock := mockRunner{CompletionFn: func(_ context.Context, r llm.CompletionRequest, fn func(llm.CompletionResponse)) error {
+		fn(llm.CompletionResponse{Content: "Hi", Logprobs: []api.Logprob{
+			{TokenLogprob: api.TokenLogprob{Token: "Hi", Logprob: -0.1}, TopLogprobs: []api.TokenLogprob{{Token: "Hi", Logprob: -0.1}}},
+		}})
+		fn(llm.CompletionResponse{Content: " there", Logprobs: []api.Logprob{
+			{TokenLogprob: api.TokenLogprob{Token: " there", Logprob: -0.5}, Offset: 2},
+		}})
+		fn(llm.CompletionResponse{Done: true, DoneReason: "stop"})
+		return nil
+	}}
+
+	s := Server{sched: newMockScheduler(t, &mock)}
+	createMockModel(t, &s, "test", `{{- range .Messages }}{{ .Content }}{{ end }}{{ .Prompt }}`)
+
+	want := []api.Logprob{
+		{TokenLogprob: api.TokenLogprob{Token: "Hi", Logprob: -0.1}, TopLogprobs: []api.TokenLogprob{{Token: "Hi", Logprob: -0.1}}},
+		{TokenLogprob: api.TokenLogprob{Token: " there", Logprob: -0.5}, Offset: 2},
+	}
+
+	t.Run("generate", func(t *testing.T) {
+		w := createRequest(t, s.GenerateHandler, api.GenerateRequest{
+			Model:       "test",
+			Prompt:      "Hello!",
+			Logprobs:    true,
+			TopLogprobs: 1,
+			Stream:      &stream,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		if !mock.CompletionRequest.Logprobs || mock.CompletionRequest.TopLogprobs != 1 {
+			t.Errorf("expected logprobs to be requested, got %+v", mock.CompletionRequest)
+		}
+
+		var resp api.GenerateResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if diff := cmp.Diff(want, resp.Logprobs); diff != "" {
+			t.Errorf("logprobs mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("chat", func(t *testing.T) {
+		w := createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model:       "test",
+			Messages:    []api.Message{{Role: "user", Content: "Hello!"}},
+			Logprobs:    true,
+			TopLogprobs: 1,
+			Stream:      &stream,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		var resp api.ChatResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if diff := cmp.Diff(want, resp.Logprobs); diff != "" {
+			t.Errorf("logprobs mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, req := range []api.GenerateRequest{
+			{Model: "test", Prompt: "Hello!", Logprobs: true, TopLogprobs: 21},
+			{Model: "test", Prompt: "Hello!", TopLogprobs: 5},
+		} {
+			req.Stream = &stream
+			w := createRequest(t, s.GenerateHandler, req)
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("expected status 400, got %d", w.Code)
+			}
+		}
+	})
 }
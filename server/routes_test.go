@@ -20,6 +20,8 @@ import (
 	"testing"
 	"unicode"
 
+	"github.com/google/go-cmp/cmp"
+
 	"github.com/ollama/ollama/api"
 	"github.com/ollama/ollama/llm"
 	"github.com/ollama/ollama/openai"
@@ -654,7 +656,9 @@ func TestShow(t *testing.T) {
 
 	var s Server
 
-	_, digest1 := createBinFile(t, llm.KV{"general.architecture": "test"}, nil)
+	_, digest1 := createBinFile(t, llm.KV{"general.architecture": "test"}, []llm.Tensor{
+		{Name: "token_embd.weight", Kind: 1, Shape: []uint64{8}, WriterTo: bytes.NewReader(make([]byte, 16))},
+	})
 	_, digest2 := createBinFile(t, llm.KV{"general.type": "projector", "general.architecture": "clip"}, nil)
 
 	createRequest(t, s.CreateHandler, api.CreateRequest{
@@ -682,6 +686,28 @@ func TestShow(t *testing.T) {
 	if resp.ProjectorInfo["general.architecture"] != "clip" {
 		t.Fatal("Expected projector architecture to be 'clip', but got", resp.ProjectorInfo["general.architecture"])
 	}
+
+	// tensors are only listed if they're requested, even for verbose requests
+	for _, req := range []api.ShowRequest{{Name: "show-model", Verbose: true}, {Name: "show-model", Tensors: true}} {
+		w := createRequest(t, s.ShowHandler, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d", w.Code)
+		}
+
+		var resp api.ShowResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		var want []api.Tensor
+		if req.Tensors {
+			want = []api.Tensor{{Name: "token_embd.weight", Type: "F16", Shape: []uint64{8}}}
+		}
+
+		if diff := cmp.Diff(want, resp.Tensors); diff != "" {
+			t.Errorf("tensors with %+v mismatch (-want +got):\n%s", req, diff)
+		}
+	}
 }
 
 func TestNormalize(t *testing.T) {
@@ -5,13 +5,16 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
 	"os"
 	"reflect"
 	"runtime"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ollama/ollama/api"
@@ -30,6 +33,27 @@ type LlmRequest struct {
 	successCh       chan *runnerRef
 	errCh           chan error
 	schedAttempts   uint
+
+	// devices are the IDs of the GPUs the model is restricted to, if any
+	devices []string
+
+	// defragment are the GPUs idle models are unloaded from before loading
+	// the model, after a load ran out of memory due to fragmentation. It's
+	// emptied, but not nil, once they've been unloaded.
+	defragment discover.GpuInfoList
+	// displaced are the models unloaded to defragment VRAM
+	displaced []displacedRunner
+
+	// noEvict fails the request with errNoRoom rather than unloading another
+	// model to make room for it
+	noEvict bool
+
+	// runner is the runner the request was scheduled on
+	runner *runnerRef
+
+	// gpuOrder are the IDs of the GPUs in the order they were discovered,
+	// which the tensor split and main GPU options refer to them by
+	gpuOrder []string
 }
 
 type Scheduler struct {
@@ -41,11 +65,28 @@ type Scheduler struct {
 	loaded   map[string]*runnerRef
 	loadedMu sync.Mutex
 
+	// offloads are the reduced configurations of models that ran out of memory
+	offloads   map[string]api.DegradedConfig
+	offloadsMu sync.Mutex
+
+	// contextLimits are the largest contexts per sequence models are loaded
+	// with while system memory is under pressure
+	contextLimits   map[string]int
+	contextLimitsMu sync.Mutex
+
 	loadFn       func(req *LlmRequest, ggml *llm.GGML, gpus discover.GpuInfoList, numParallel int)
-	newServerFn  func(gpus discover.GpuInfoList, model string, ggml *llm.GGML, adapters []string, projectors []string, opts api.Options, numParallel int) (llm.LlamaServer, error)
+	newServerFn  newServerFunc
 	getGpuFn     func() discover.GpuInfoList
 	getCpuFn     func() discover.GpuInfoList
 	reschedDelay time.Duration
+
+	// availableMemoryFn returns the percentage of system memory available
+	availableMemoryFn func() (int, error)
+	// fullscreenFn reports whether a full-screen application is running
+	fullscreenFn func() (bool, error)
+	// yielding is set while GPUs are released for a full-screen
+	// application, when models are loaded on the CPU
+	yielding atomic.Bool
 }
 
 // Default automatic value for number of models we allow per GPU
@@ -58,8 +99,13 @@ var defaultModelsPerGPU = 3
 // we'll back off down to 1 to try to get it to fit
 var defaultParallel = 4
 
+// newServerFunc starts a runner for a model
+type newServerFunc func(gpus discover.GpuInfoList, model string, ggml *llm.GGML, adapters []string, projectors []string, opts api.Options, numParallel int) (llm.LlamaServer, error)
+
 var ErrMaxQueue = errors.New("server busy, please try again.  maximum pending requests exceeded")
 
+var errNoRoom = errors.New("no room to load the model without unloading another")
+
 func InitScheduler(ctx context.Context) *Scheduler {
 	maxQueue := envconfig.MaxQueue()
 	sched := &Scheduler{
@@ -68,10 +114,13 @@ func InitScheduler(ctx context.Context) *Scheduler {
 		expiredCh:     make(chan *runnerRef, maxQueue),
 		unloadedCh:    make(chan interface{}, maxQueue),
 		loaded:        make(map[string]*runnerRef),
-		newServerFn:   llm.NewLlamaServer,
+		newServerFn:   chaosServerFn(llm.NewServer),
 		getGpuFn:      discover.GetGPUInfo,
 		getCpuFn:      discover.GetCPUInfo,
 		reschedDelay:  250 * time.Millisecond,
+
+		availableMemoryFn: availableMemory,
+		fullscreenFn:      fullscreenApp,
 	}
 	sched.loadFn = sched.load
 	return sched
@@ -79,10 +128,36 @@ func InitScheduler(ctx context.Context) *Scheduler {
 
 // context must be canceled to decrement ref count and release the runner
 func (s *Scheduler) GetRunner(c context.Context, model *Model, opts api.Options, sessionDuration *api.Duration) (chan *runnerRef, chan error) {
+	return s.getRunner(c, model, opts, sessionDuration, false)
+}
+
+// GetIdleRunner is GetRunner, but fails with errNoRoom if another model has
+// to be unloaded to load the model
+func (s *Scheduler) GetIdleRunner(c context.Context, model *Model, opts api.Options, sessionDuration *api.Duration) (chan *runnerRef, chan error) {
+	return s.getRunner(c, model, opts, sessionDuration, true)
+}
+
+type noEvictKey struct{}
+
+// withNoEvict returns a context whose requests fail with errNoRoom rather
+// than unloading another model to load theirs
+func withNoEvict(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noEvictKey{}, true)
+}
+
+// getRunnerFor is GetRunner, or GetIdleRunner if ctx is from withNoEvict
+func (s *Scheduler) getRunnerFor(ctx context.Context, model *Model, opts api.Options, sessionDuration *api.Duration) (chan *runnerRef, chan error) {
+	noEvict, _ := ctx.Value(noEvictKey{}).(bool)
+	return s.getRunner(ctx, model, opts, sessionDuration, noEvict)
+}
+
+func (s *Scheduler) getRunner(c context.Context, model *Model, opts api.Options, sessionDuration *api.Duration, noEvict bool) (chan *runnerRef, chan error) {
 	if opts.NumCtx < 4 {
 		opts.NumCtx = 4
 	}
 
+	devices := assignDevices(model, &opts)
+
 	req := &LlmRequest{
 		ctx:             c,
 		model:           model,
@@ -90,6 +165,8 @@ func (s *Scheduler) GetRunner(c context.Context, model *Model, opts api.Options,
 		sessionDuration: sessionDuration,
 		successCh:       make(chan *runnerRef),
 		errCh:           make(chan error, 1),
+		devices:         devices,
+		noEvict:         noEvict,
 	}
 
 	select {
@@ -110,6 +187,18 @@ func (s *Scheduler) Run(ctx context.Context) {
 	go func() {
 		s.processCompleted(ctx)
 	}()
+
+	go func() {
+		s.monitorMemoryPressure(ctx)
+	}()
+
+	go func() {
+		s.monitorFullscreen(ctx)
+	}()
+
+	go func() {
+		s.monitorParallel(ctx)
+	}()
 }
 
 func (s *Scheduler) processPending(ctx context.Context) {
@@ -137,20 +226,36 @@ func (s *Scheduler) processPending(ctx context.Context) {
 				slog.Warn("mllama doesn't support parallel requests yet")
 			}
 
+			// models start with one parallel request and are scaled up as
+			// requests wait for them
+			if numParallel == 0 && envconfig.MaxParallel() > 0 {
+				numParallel = 1
+			}
+
 			for {
 				var runnerToExpire *runnerRef
 				s.loadedMu.Lock()
 				runner := s.loaded[pending.model.ModelPath]
 				loadedCount := len(s.loaded)
 				s.loadedMu.Unlock()
+				if runner == nil {
+					s.drainOldVersions(pending.model)
+				}
+
 				if runner != nil {
 					if runner.needsReload(ctx, pending) {
+						if s.hotSwap(runner, pending) {
+							// load the new version beside the old
+							continue
+						}
 						runnerToExpire = runner
 					} else {
 						// Runner is usable, return it
 						pending.useLoadedRunner(runner, s.finishedReqCh)
 						break
 					}
+				} else if r := s.findRunnerToDefragment(pending); r != nil {
+					runnerToExpire = r
 				} else if envconfig.MaxRunners() > 0 && loadedCount >= int(envconfig.MaxRunners()) {
 					slog.Debug("max runners achieved, unloading one to make room", "runner_count", loadedCount)
 					runnerToExpire = s.findRunnerToUnload()
@@ -158,10 +263,13 @@ func (s *Scheduler) processPending(ctx context.Context) {
 					// Either no models are loaded or below envconfig.MaxRunners
 					// Get a refreshed GPU list
 					var gpus discover.GpuInfoList
-					if pending.opts.NumGPU == 0 {
+					if pending.opts.NumGPU == 0 || s.yielding.Load() {
 						gpus = s.getCpuFn()
 					} else {
 						gpus = s.getGpuFn()
+						pending.gpuOrder = gpuIDs(gpus)
+						gpus = filterDevices(gpus, pending.devices)
+						gpuReserveFromEnv().apply(gpus)
 					}
 
 					if envconfig.MaxRunners() <= 0 {
@@ -186,6 +294,8 @@ func (s *Scheduler) processPending(ctx context.Context) {
 						}
 					}
 
+					phasesFrom(pending.ctx).signalLoading()
+
 					// Load model for fitting
 					ggml, err := llm.LoadModel(pending.model.ModelPath, 0)
 					if err != nil {
@@ -269,7 +379,7 @@ func (s *Scheduler) processPending(ctx context.Context) {
 							}()
 							break
 						}
-						runnerToExpire = s.findRunnerToUnload()
+						runnerToExpire = s.findRunnerToUnloadOn(gpus)
 					}
 				}
 
@@ -278,6 +388,13 @@ func (s *Scheduler) processPending(ctx context.Context) {
 					slog.Error("runner to expire was nil!")
 					continue
 				}
+
+				if pending.noEvict {
+					slog.Debug("not unloading a model to make room", "model", pending.model.ModelPath)
+					pending.errCh <- errNoRoom
+					break
+				}
+
 				// Trigger an expiration to unload once it's done
 				runnerToExpire.refMu.Lock()
 				slog.Debug("resetting model to expire immediately to make room", "modelPath", runnerToExpire.modelPath, "refCount", runnerToExpire.refCount)
@@ -319,7 +436,12 @@ func (s *Scheduler) processCompleted(ctx context.Context) {
 			return
 		case finished := <-s.finishedReqCh:
 			s.loadedMu.Lock()
-			runner := s.loaded[finished.model.ModelPath]
+			runner := finished.runner
+			if runner == nil {
+				runner = s.loaded[finished.model.ModelPath]
+			} else if !s.isLoaded(runner) {
+				runner = nil
+			}
 			s.loadedMu.Unlock()
 			if runner == nil {
 				slog.Error("finished request signal received after model unloaded", "modelPath", finished.model.ModelPath)
@@ -374,8 +496,14 @@ func (s *Scheduler) processCompleted(ctx context.Context) {
 			s.loadedMu.Lock()
 			slog.Debug("got lock to unload", "modelPath", runner.modelPath)
 			finished := runner.waitForVRAMRecovery()
+			if !runner.stale {
+				// the memory the model ran out of may be free by the time
+				// it's loaded again. Stale runners are being replaced, so
+				// their replacement keeps the reduced configuration.
+				s.clearDegradedOffload(runner.modelPath)
+			}
 			runner.unload()
-			delete(s.loaded, runner.modelPath)
+			s.forget(runner)
 			s.loadedMu.Unlock()
 			slog.Debug("runner released", "modelPath", runner.modelPath)
 			runner.refMu.Unlock()
@@ -394,6 +522,7 @@ func (pending *LlmRequest) useLoadedRunner(runner *runnerRef, finished chan *Llm
 	runner.refMu.Lock()
 	defer runner.refMu.Unlock()
 	runner.refCount++
+	runner.peak = max(runner.peak, int(runner.refCount))
 	if runner.expireTimer != nil {
 		runner.expireTimer.Stop()
 		runner.expireTimer = nil
@@ -401,6 +530,12 @@ func (pending *LlmRequest) useLoadedRunner(runner *runnerRef, finished chan *Llm
 	if pending.sessionDuration != nil {
 		runner.sessionDuration = pending.sessionDuration.Duration
 	}
+	// the runner serves the latest version of its model when a change, such
+	// as to its template, doesn't need it to be reloaded
+	if runner.model != nil && runner.model.Name == pending.model.Name {
+		runner.model = pending.model
+	}
+	pending.runner = runner
 	pending.successCh <- runner
 	go func() {
 		<-pending.ctx.Done()
@@ -417,7 +552,15 @@ func (s *Scheduler) load(req *LlmRequest, ggml *llm.GGML, gpus discover.GpuInfoL
 	if req.sessionDuration != nil {
 		sessionDuration = req.sessionDuration.Duration
 	}
-	llama, err := s.newServerFn(gpus, req.model.ModelPath, ggml, req.model.AdapterPaths, req.model.ProjectorPaths, req.opts, numParallel)
+	opts := req.optsOn(gpus)
+	degraded := applyOffload(&opts, s.degradedOffload(req.model.ModelPath))
+	var limited bool
+	if limit := s.contextLimit(req.model.ModelPath); limit > 0 && opts.NumCtx > limit*numParallel {
+		slog.Info("limiting context under memory pressure", "model", req.model.ModelPath, "num_ctx", limit)
+		opts.NumCtx = limit * numParallel
+		limited = true
+	}
+	llama, err := s.newServerFn(gpus, req.model.ModelPath, ggml, req.model.AdapterPaths, req.model.ProjectorPaths, opts, numParallel)
 	if err != nil {
 		// some older models are not compatible with newer versions of llama.cpp
 		// show a generalized compatibility error until there is a better way to
@@ -440,8 +583,19 @@ func (s *Scheduler) load(req *LlmRequest, ggml *llm.GGML, gpus discover.GpuInfoL
 		estimatedTotal:  llama.EstimatedTotal(),
 		loading:         true,
 		refCount:        1,
+		offload:         currentOffload(gpus, ggml, req.model.ProjectorPaths, opts),
+		degraded:        degraded,
+		loadedAt:        time.Now(),
+		numCtx:          opts.NumCtx / numParallel,
+		limited:         limited,
+		yielded:         s.yielding.Load() && req.opts.NumGPU != 0,
 	}
+	runner.recordLayout()
 	runner.numParallel = numParallel
+	runner.peakSince = runner.loadedAt
+	if envconfig.MaxParallel() > 0 {
+		runner.fixedParallel = checkMllamaModelFamily(req.model) || req.model.CheckCapabilities(CapabilityCompletion) != nil
+	}
 	runner.refMu.Lock()
 
 	s.loadedMu.Lock()
@@ -451,7 +605,46 @@ func (s *Scheduler) load(req *LlmRequest, ggml *llm.GGML, gpus discover.GpuInfoL
 
 	go func() {
 		defer runner.refMu.Unlock()
-		if err = llama.WaitUntilRunning(req.ctx); err != nil {
+		for attempt := 0; ; attempt++ {
+			err := runner.llama.WaitUntilRunning(req.ctx)
+			if err == nil {
+				break
+			}
+
+			if errorCode(err) == api.ErrorCodeOutOfMemory && s.shouldDefragment(req, runner) {
+				slog.Warn("model ran out of memory despite sufficient free VRAM, defragmenting", "model", runner.modelPath, "error", err)
+				req.defragment = runner.gpus
+				runner.stale = true
+				runner.refCount--
+				s.expiredCh <- runner
+				go func() {
+					// requeue in a go routine to avoid deadlocking the
+					// scheduler if the queue is full
+					s.pendingReqCh <- req
+				}()
+				return
+			}
+
+			if attempt < maxOffloadRetries && errorCode(err) == api.ErrorCodeOutOfMemory {
+				if offload, ok := reduceOffload(runner.offload); ok {
+					slog.Warn("model ran out of memory while loading, retrying with reduced offload", "model", runner.modelPath, "num_gpu", offload.NumGPU, "num_batch", offload.NumBatch, "error", err)
+					runner.llama.Close()
+					opts := opts
+					degraded := applyOffload(&opts, &offload)
+					llama, err = s.newServerFn(gpus, req.model.ModelPath, ggml, req.model.AdapterPaths, req.model.ProjectorPaths, opts, numParallel)
+					if err == nil {
+						s.setDegradedOffload(runner.modelPath, offload)
+						runner.llama = llama
+						runner.estimatedVRAM = llama.EstimatedVRAM()
+						runner.estimatedTotal = llama.EstimatedTotal()
+						runner.recordLayout()
+						runner.offload = offload
+						runner.degraded = degraded
+						continue
+					}
+				}
+			}
+
 			slog.Error("error loading llama server", "error", err)
 			runner.refCount--
 			req.errCh <- err
@@ -461,12 +654,17 @@ func (s *Scheduler) load(req *LlmRequest, ggml *llm.GGML, gpus discover.GpuInfoL
 		}
 		slog.Debug("finished setting up runner", "model", req.model.ModelPath)
 		runner.loading = false
+		req.runner = runner
+		runner.adviseQuantization()
 		go func() {
 			<-req.ctx.Done()
 			slog.Debug("context for request finished")
 			s.finishedReqCh <- req
 		}()
 		req.successCh <- runner
+		if len(req.displaced) > 0 {
+			go s.reloadDisplaced(context.Background(), req.displaced)
+		}
 	}()
 }
 
@@ -545,6 +743,8 @@ type runnerRef struct {
 	gpus           discover.GpuInfoList // Recorded at time of provisioning
 	estimatedVRAM  uint64
 	estimatedTotal uint64
+	layout         map[string]uint64 // VRAM allocated on each GPU by ID
+	loadedAt       time.Time
 
 	sessionDuration time.Duration
 	expireTimer     *time.Timer
@@ -554,6 +754,39 @@ type runnerRef struct {
 	modelPath   string
 	numParallel int
 	*api.Options
+
+	// offload is the configuration the runner was started with
+	offload api.DegradedConfig
+	// degraded is set if offload was reduced after running out of memory
+	degraded *api.DegradedConfig
+	// numCtx is the context per sequence the runner was started with, and
+	// limited is set if it was less than requested under memory pressure
+	numCtx  int
+	limited bool
+	// yielded is set if the runner was loaded on the CPU while GPUs were
+	// released for a full-screen application
+	yielded bool
+	// stale is set if the runner must be replaced by the next request for its
+	// model, e.g. after running out of memory
+	stale bool
+	// draining is set once a new version of the runner's model is loaded in
+	// its place. It takes no more requests and is unloaded once those it has
+	// finish.
+	draining bool
+
+	// peak is the most requests the runner has had at once since peakSince,
+	// and scaling is set while its parallel requests are being changed.
+	// fixedParallel is set if they can't be changed without reloading it.
+	peak          int
+	peakSince     time.Time
+	scaling       bool
+	fixedParallel bool
+
+	// advisories warn that the model's quantization is slow on the hardware
+	// it was loaded on, and advised is set once they've been returned with
+	// a response
+	advisories []api.Advisory
+	advised    bool
 }
 
 // The refMu must already be held when calling unload
@@ -581,7 +814,7 @@ func (runner *runnerRef) needsReload(ctx context.Context, req *LlmRequest) bool
 		timeout = 2 * time.Minute // Initial load can take a long time for big models on slow systems...
 	}
 
-	if runner.Options == nil {
+	if runner.Options == nil || runner.stale || runner.draining {
 		return true
 	}
 
@@ -696,21 +929,27 @@ func pickBestFullFitByLibrary(req *LlmRequest, ggml *llm.GGML, gpus discover.Gpu
 		numParallelToTry = []int{*numParallel}
 	}
 
+	// an explicit tensor split sets the GPUs the model is loaded on
+	gpus = splitGPUs(req, gpus)
+
 	for _, gl := range gpus.ByLibrary() {
 		var ok bool
 		sgl := append(make(discover.GpuInfoList, 0, len(gl)), gl...)
 
-		// TODO - potentially sort by performance capability, existing models loaded, etc.
 		// TODO - Eliminate any GPUs that already have envconfig.MaxRunners loaded on them
-		// Note: at present, this will favor more VRAM over faster GPU speed in mixed setups
-		sort.Sort(sort.Reverse(discover.ByFreeMemory(sgl)))
+		// Note: unless the fastest are filled first, this will favor more VRAM over faster GPU speed in mixed setups
+		if req.opts.SplitPolicy == "fastest" {
+			slices.SortStableFunc(sgl, func(a, b discover.GpuInfo) int { return discover.ComparePerformance(b, a) })
+		} else {
+			sort.Sort(sort.Reverse(discover.ByFreeMemory(sgl)))
+		}
 
 		// First attempt to fit the model into a single GPU
 		for _, p := range numParallelToTry {
 			req.opts.NumCtx = req.origNumCtx * p
-			if !envconfig.SchedSpread() {
+			if !envconfig.SchedSpread() && req.opts.TensorSplit == "" {
 				for _, g := range sgl {
-					if ok, estimatedVRAM = llm.PredictServerFit([]discover.GpuInfo{g}, ggml, req.model.AdapterPaths, req.model.ProjectorPaths, req.opts); ok {
+					if ok, estimatedVRAM = llm.PredictServerFit([]discover.GpuInfo{g}, ggml, req.model.AdapterPaths, req.model.ProjectorPaths, req.optsOn([]discover.GpuInfo{g})); ok {
 						slog.Info("new model will fit in available VRAM in single GPU, loading", "model", req.model.ModelPath, "gpu", g.ID, "parallel", p, "available", g.FreeMemory, "required", format.HumanBytes2(estimatedVRAM))
 						*numParallel = p
 						return []discover.GpuInfo{g}
@@ -726,7 +965,7 @@ func pickBestFullFitByLibrary(req *LlmRequest, ggml *llm.GGML, gpus discover.Gpu
 		// Now try all the GPUs
 		for _, p := range numParallelToTry {
 			req.opts.NumCtx = req.origNumCtx * p
-			if ok, estimatedVRAM = llm.PredictServerFit(sgl, ggml, req.model.AdapterPaths, req.model.ProjectorPaths, req.opts); ok {
+			if ok, estimatedVRAM = llm.PredictServerFit(sgl, ggml, req.model.AdapterPaths, req.model.ProjectorPaths, req.optsOn(sgl)); ok {
 				slog.Info("new model will fit in available VRAM, loading", "model", req.model.ModelPath, "library", sgl[0].Library, "parallel", p, "required", format.HumanBytes2(estimatedVRAM))
 				*numParallel = p
 				return sgl
@@ -742,6 +981,9 @@ func pickBestPartialFitByLibrary(req *LlmRequest, ggml *llm.GGML, gpus discover.
 		*numParallel = 1
 		req.opts.NumCtx = req.origNumCtx
 	}
+	if placed := splitGPUs(req, gpus); len(placed) > 0 {
+		gpus = placed
+	}
 	byLibrary := gpus.ByLibrary()
 	if len(byLibrary) <= 1 {
 		return gpus
@@ -749,7 +991,7 @@ func pickBestPartialFitByLibrary(req *LlmRequest, ggml *llm.GGML, gpus discover.
 	var bestEstimate uint64
 	var bestFit int
 	for i, gl := range byLibrary {
-		_, estimatedVRAM := llm.PredictServerFit(gl, ggml, req.model.AdapterPaths, req.model.ProjectorPaths, req.opts)
+		_, estimatedVRAM := llm.PredictServerFit(gl, ggml, req.model.AdapterPaths, req.model.ProjectorPaths, req.optsOn(gl))
 		if estimatedVRAM > bestEstimate {
 			bestEstimate = estimatedVRAM
 			bestFit = i
@@ -760,12 +1002,31 @@ func pickBestPartialFitByLibrary(req *LlmRequest, ggml *llm.GGML, gpus discover.
 
 // findRunnerToUnload finds a runner to unload to make room for a new model
 func (s *Scheduler) findRunnerToUnload() *runnerRef {
+	return s.findRunnerToUnloadOn(nil)
+}
+
+// findRunnerToUnloadOn prefers runners loaded on gpus so models assigned to
+// other devices keep running. All runners are considered if none are on gpus.
+func (s *Scheduler) findRunnerToUnloadOn(gpus discover.GpuInfoList) *runnerRef {
 	s.loadedMu.Lock()
 	runnerList := make([]*runnerRef, 0, len(s.loaded))
 	for _, r := range s.loaded {
 		runnerList = append(runnerList, r)
 	}
 	s.loadedMu.Unlock()
+
+	if len(gpus) > 0 {
+		var onGpus []*runnerRef
+		for _, r := range runnerList {
+			if r.usesDevices(gpus) {
+				onGpus = append(onGpus, r)
+			}
+		}
+
+		if len(onGpus) > 0 {
+			runnerList = onGpus
+		}
+	}
 	if len(runnerList) == 0 {
 		slog.Debug("no loaded runner to unload")
 		return nil
@@ -775,6 +1036,17 @@ func (s *Scheduler) findRunnerToUnload() *runnerRef {
 	// e.g., if we have multiple options, will one make room for the request?
 	sort.Sort(ByDuration(runnerList))
 
+	// Runners replaced by a new version are unloaded once they're idle anyway
+	for _, runner := range runnerList {
+		runner.refMu.Lock()
+		draining := runner.draining
+		runner.refMu.Unlock()
+		if draining {
+			slog.Debug("found a draining runner to unload")
+			return runner
+		}
+	}
+
 	// First try to find a runner that's already idle
 	for _, runner := range runnerList {
 		runner.refMu.Lock()
@@ -790,6 +1062,100 @@ func (s *Scheduler) findRunnerToUnload() *runnerRef {
 	return runnerList[0]
 }
 
+// hotSwap starts draining runner, if it's serving requests with an older
+// version of pending's model, so the new version can be loaded beside it
+// rather than after those requests finish. It returns false if the runner
+// should be unloaded before the model's loaded again instead, including
+// when it's another model with the same weights.
+func (s *Scheduler) hotSwap(runner *runnerRef, pending *LlmRequest) bool {
+	s.loadedMu.Lock()
+	runner.refMu.Lock()
+	swap := runner.model != nil && runner.refCount > 0 && !runner.stale && !runner.draining && runner.model.Name == pending.model.Name && runner.model.Digest != pending.model.Digest
+	runner.refMu.Unlock()
+	if !swap {
+		s.loadedMu.Unlock()
+		return false
+	}
+
+	slog.Info("loading a new version of a model beside the old", "model", pending.model.ShortName, "digest", pending.model.Digest)
+	idle := s.drain(runner)
+	s.loadedMu.Unlock()
+
+	// the expired runner handler takes loadedMu, so it's only sent the
+	// runner once it's released
+	if idle {
+		s.expiredCh <- runner
+	}
+	return true
+}
+
+// drainOldVersions starts draining the runners of other versions of model,
+// with other weights, before it's loaded
+func (s *Scheduler) drainOldVersions(model *Model) {
+	s.loadedMu.Lock()
+	var idle []*runnerRef
+	// drain rekeys runners in s.loaded, so it's called on a snapshot of them
+	for _, runner := range slices.Collect(maps.Values(s.loaded)) {
+		runner.refMu.Lock()
+		old := runner.model != nil && !runner.draining && runner.model.Name == model.Name && runner.modelPath != model.ModelPath
+		runner.refMu.Unlock()
+		if old {
+			slog.Info("replacing an old version of a model", "model", model.ShortName, "digest", model.Digest)
+			if s.drain(runner) {
+				idle = append(idle, runner)
+			}
+		}
+	}
+	s.loadedMu.Unlock()
+
+	for _, runner := range idle {
+		s.expiredCh <- runner
+	}
+}
+
+// drain stops runner taking requests and unloads it once those it has
+// finish. Its key in s.loaded is freed for a new version of its model, but
+// it's kept loaded so its memory is still accounted for. It returns true if
+// runner has no requests, and the caller must send it to s.expiredCh after
+// releasing s.loadedMu. s.loadedMu must be held.
+func (s *Scheduler) drain(runner *runnerRef) bool {
+	runner.refMu.Lock()
+	defer runner.refMu.Unlock()
+
+	runner.draining = true
+	if runner.expireTimer != nil {
+		runner.expireTimer.Stop()
+		runner.expireTimer = nil
+	}
+	runner.sessionDuration = 0
+	runner.expiresAt = time.Now()
+
+	s.forget(runner)
+	s.loaded[fmt.Sprintf("%s#%p", runner.modelPath, runner)] = runner
+
+	return runner.refCount <= 0
+}
+
+// isLoaded reports whether runner is in s.loaded. s.loadedMu must be held.
+func (s *Scheduler) isLoaded(runner *runnerRef) bool {
+	for _, r := range s.loaded {
+		if r == runner {
+			return true
+		}
+	}
+
+	return false
+}
+
+// forget removes runner from s.loaded. s.loadedMu must be held.
+func (s *Scheduler) forget(runner *runnerRef) {
+	for key, r := range s.loaded {
+		if r == runner {
+			delete(s.loaded, key)
+		}
+	}
+}
+
 func (s *Scheduler) unloadAllRunners() {
 	s.loadedMu.Lock()
 	defer s.loadedMu.Unlock()
@@ -3,6 +3,7 @@ package server
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"log/slog"
 	"os"
@@ -256,6 +257,159 @@ func TestRequestsSimpleReloadSameModel(t *testing.T) {
 	}
 }
 
+func TestRequestsHotSwap(t *testing.T) {
+	ctx, done := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer done()
+	s := InitScheduler(ctx)
+	s.getGpuFn = getGpuFn
+	s.getCpuFn = getCpuFn
+	a := newScenarioRequest(t, ctx, "ollama-model-1", 10, &api.Duration{Duration: 5 * time.Millisecond})
+	b := newScenarioRequest(t, ctx, "ollama-model-1", 20, &api.Duration{Duration: 5 * time.Millisecond})
+	a.req.model.Digest = "sha256:1"
+	tmpModel := *a.req.model
+	b.req.model = &tmpModel
+	b.req.model.Digest = "sha256:2"
+	b.req.model.AdapterPaths = []string{"new"}
+	b.ggml = a.ggml
+
+	s.newServerFn = a.newServer
+	s.pendingReqCh <- a.req
+	s.Run(ctx)
+	select {
+	case resp := <-a.req.successCh:
+		require.Equal(t, resp.llama, a.srv)
+	case err := <-a.req.errCh:
+		t.Fatal(err.Error())
+	case <-ctx.Done():
+		t.Fatal("timeout")
+	}
+
+	// the new version is loaded while the old one still has a request
+	s.newServerFn = b.newServer
+	s.pendingReqCh <- b.req
+	select {
+	case resp := <-b.req.successCh:
+		require.Equal(t, resp.llama, b.srv)
+		require.Empty(t, b.req.errCh)
+	case err := <-b.req.errCh:
+		t.Fatal(err.Error())
+	case <-ctx.Done():
+		t.Fatal("timeout")
+	}
+
+	w := createRequest(t, (&Server{sched: s}).PsHandler, nil)
+	var ps api.ProcessResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&ps))
+	require.Len(t, ps.Models, 2)
+	swaps := map[string]string{}
+	for _, m := range ps.Models {
+		swaps[m.Digest] = m.Swap
+	}
+	require.Equal(t, map[string]string{"sha256:1": "draining", "sha256:2": ""}, swaps)
+
+	// the old version is unloaded once its request finishes
+	a.ctxDone()
+	require.Eventually(t, func() bool {
+		s.loadedMu.Lock()
+		defer s.loadedMu.Unlock()
+		return len(s.loaded) == 1 && s.loaded[b.req.model.ModelPath].llama == b.srv
+	}, 200*time.Millisecond, 5*time.Millisecond)
+	b.ctxDone()
+}
+
+func TestHotSwapOtherModel(t *testing.T) {
+	s := InitScheduler(context.Background())
+	runner := &runnerRef{refCount: 1, model: &Model{Name: "copy", Digest: "sha256:1", ModelPath: "blob"}}
+	pending := &LlmRequest{model: &Model{Name: "original", Digest: "sha256:2", ModelPath: "blob"}}
+
+	// models sharing weights aren't versions of each other
+	require.False(t, s.hotSwap(runner, pending))
+	require.False(t, runner.draining)
+}
+
+func TestDrainOldVersionsIdle(t *testing.T) {
+	s := InitScheduler(context.Background())
+	s.expiredCh = make(chan *runnerRef)
+	model := &Model{Name: "model", Digest: "sha256:3", ModelPath: "blob-3"}
+	for _, path := range []string{"blob-1", "blob-2"} {
+		s.loaded[path] = &runnerRef{modelPath: path, model: &Model{Name: "model", ModelPath: path}}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.drainOldVersions(model)
+		close(done)
+	}()
+
+	// the expired runner handler takes loadedMu for each runner it's sent
+	for range 2 {
+		select {
+		case runner := <-s.expiredCh:
+			s.loadedMu.Lock()
+			require.True(t, runner.draining)
+			require.True(t, s.isLoaded(runner))
+			s.loadedMu.Unlock()
+		case <-time.After(time.Second):
+			t.Fatal("timeout")
+		}
+	}
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("timeout")
+	}
+
+	s.loadedMu.Lock()
+	defer s.loadedMu.Unlock()
+	require.Len(t, s.loaded, 2)
+	require.NotContains(t, s.loaded, "blob-1")
+	require.NotContains(t, s.loaded, "blob-2")
+}
+
+func TestRequestsReplaceOldVersion(t *testing.T) {
+	ctx, done := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer done()
+	s := InitScheduler(ctx)
+	s.getGpuFn = getGpuFn
+	s.getCpuFn = getCpuFn
+	a := newScenarioRequest(t, ctx, "ollama-model-1", 10, &api.Duration{Duration: time.Minute})
+	// the model is created again with other weights
+	b := newScenarioRequest(t, ctx, "ollama-model-1", 20, &api.Duration{Duration: time.Minute})
+
+	s.newServerFn = a.newServer
+	s.pendingReqCh <- a.req
+	s.Run(ctx)
+	select {
+	case <-a.req.successCh:
+	case err := <-a.req.errCh:
+		t.Fatal(err.Error())
+	case <-ctx.Done():
+		t.Fatal("timeout")
+	}
+
+	s.newServerFn = b.newServer
+	s.pendingReqCh <- b.req
+	select {
+	case resp := <-b.req.successCh:
+		require.Equal(t, resp.llama, b.srv)
+	case err := <-b.req.errCh:
+		t.Fatal(err.Error())
+	case <-ctx.Done():
+		t.Fatal("timeout")
+	}
+
+	// the old version isn't kept loaded for its keep alive once it's idle
+	a.ctxDone()
+	require.Eventually(t, func() bool {
+		s.loadedMu.Lock()
+		defer s.loadedMu.Unlock()
+		_, ok := s.loaded[a.req.model.ModelPath]
+		return len(s.loaded) == 1 && !ok
+	}, 200*time.Millisecond, 5*time.Millisecond)
+	b.ctxDone()
+}
+
 func TestRequestsMultipleLoadedModels(t *testing.T) {
 	ctx, done := context.WithTimeout(context.Background(), 500*time.Millisecond)
 	defer done()
@@ -353,6 +507,47 @@ func TestRequestsMultipleLoadedModels(t *testing.T) {
 	s.loadedMu.Unlock()
 }
 
+func TestRequestsNoEvict(t *testing.T) {
+	ctx, done := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer done()
+	s := InitScheduler(ctx)
+	s.getGpuFn = getGpuFn
+	s.getCpuFn = getCpuFn
+	a := newScenarioRequest(t, ctx, "ollama-model-1", 10, nil)
+	b := newScenarioRequest(t, ctx, "ollama-model-2", 10, nil)
+	b.req.noEvict = true
+
+	t.Setenv("OLLAMA_MAX_LOADED_MODELS", "1")
+	s.newServerFn = a.newServer
+	s.pendingReqCh <- a.req
+	s.Run(ctx)
+	select {
+	case resp := <-a.req.successCh:
+		require.Equal(t, resp.llama, a.srv)
+	case err := <-a.req.errCh:
+		t.Fatal(err.Error())
+	case <-ctx.Done():
+		t.Fatal("timeout")
+	}
+
+	// b would need a to be unloaded
+	s.newServerFn = b.newServer
+	s.pendingReqCh <- b.req
+	select {
+	case <-b.req.successCh:
+		t.Fatal("expected b not to load")
+	case err := <-b.req.errCh:
+		require.ErrorIs(t, err, errNoRoom)
+	case <-ctx.Done():
+		t.Fatal("timeout")
+	}
+
+	s.loadedMu.Lock()
+	require.Len(t, s.loaded, 1)
+	require.Contains(t, s.loaded, a.req.model.ModelPath)
+	s.loadedMu.Unlock()
+}
+
 func TestGetRunner(t *testing.T) {
 	ctx, done := context.WithTimeout(context.Background(), 200*time.Millisecond)
 	defer done()
@@ -764,6 +959,10 @@ type mockLlm struct {
 	estimatedVRAM      uint64
 	estimatedTotal     uint64
 	estimatedVRAMByGPU map[string]uint64
+	layersByGPU        map[string]int
+	purged             int
+	parallel           int
+	parallelErr        error
 }
 
 func (s *mockLlm) Ping(ctx context.Context) error             { return s.pingResp }
@@ -791,3 +990,21 @@ func (s *mockLlm) Close() error {
 func (s *mockLlm) EstimatedVRAM() uint64                  { return s.estimatedVRAM }
 func (s *mockLlm) EstimatedTotal() uint64                 { return s.estimatedTotal }
 func (s *mockLlm) EstimatedVRAMByGPU(gpuid string) uint64 { return s.estimatedVRAMByGPU[gpuid] }
+func (s *mockLlm) EstimatedLayersByGPU(gpuid string) int  { return s.layersByGPU[gpuid] }
+func (s *mockLlm) KvCacheUsage(ctx context.Context) (int, int, error) {
+	return 0, 0, nil
+}
+
+func (s *mockLlm) PurgeCache(ctx context.Context) (int, error) {
+	s.purged++
+	return 1, nil
+}
+
+func (s *mockLlm) SetParallel(ctx context.Context, gpus discover.GpuInfoList, numParallel int) error {
+	if s.parallelErr != nil {
+		return s.parallelErr
+	}
+
+	s.parallel = numParallel
+	return nil
+}
@@ -0,0 +1,283 @@
+package server
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+)
+
+// schema is the subset of JSON schema needed to describe API requests. A
+// schema without types accepts any value.
+type schema struct {
+	types      []string
+	properties map[string]*schema
+	// additional applies to object keys not in properties. Nil accepts any value.
+	additional *schema
+	items      *schema
+}
+
+var (
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+	durationType   = reflect.TypeOf(api.Duration{})
+	propertyType   = reflect.TypeOf(api.PropertyType{})
+	toolChoiceType = reflect.TypeOf(api.ToolChoice{})
+	optionsType    = reflect.TypeOf(api.Options{})
+)
+
+// schemaOf returns the schema of the JSON accepted when unmarshaling into t
+func schemaOf(t reflect.Type) *schema {
+	return schemaOfStructs(t, make(map[reflect.Type]*schema))
+}
+
+// schemaOfStructs returns the schema of t, reusing the schemas of structs
+// already seen so recursive types, such as tool properties, terminate
+func schemaOfStructs(t reflect.Type, structs map[reflect.Type]*schema) *schema {
+	switch t {
+	case rawMessageType:
+		return &schema{}
+	case durationType:
+		return &schema{types: []string{"string", "number"}}
+	case propertyType:
+		return &schema{types: []string{"string", "array"}, items: &schema{types: []string{"string"}}}
+	case toolChoiceType:
+		s := &schema{properties: make(map[string]*schema)}
+		addFields(s, t, structs)
+		s.types = []string{"string", "object"}
+		return s
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return schemaOfStructs(t.Elem(), structs)
+	case reflect.Interface:
+		return &schema{}
+	case reflect.String:
+		return &schema{types: []string{"string"}}
+	case reflect.Bool:
+		return &schema{types: []string{"boolean"}}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &schema{types: []string{"integer"}}
+	case reflect.Float32, reflect.Float64:
+		return &schema{types: []string{"number"}}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// byte slices are base64 encoded strings
+			return &schema{types: []string{"string"}}
+		}
+
+		return &schema{types: []string{"array"}, items: schemaOfStructs(t.Elem(), structs)}
+	case reflect.Map:
+		return &schema{types: []string{"object"}, additional: schemaOfStructs(t.Elem(), structs)}
+	case reflect.Struct:
+		if s, ok := structs[t]; ok {
+			return s
+		}
+
+		s := &schema{types: []string{"object"}, properties: make(map[string]*schema)}
+		structs[t] = s
+		addFields(s, t, structs)
+		return s
+	}
+
+	return &schema{}
+}
+
+// addFields adds the JSON fields of struct t to s, including fields of
+// embedded structs without a name
+func addFields(s *schema, t reflect.Type, structs map[reflect.Type]*schema) {
+	for i := range t.NumField() {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" || (!f.IsExported() && !f.Anonymous) {
+			continue
+		}
+
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+
+			if ft.Kind() == reflect.Struct {
+				addFields(s, ft, structs)
+				continue
+			}
+		}
+
+		s.properties[cmp.Or(name, f.Name)] = schemaOfStructs(f.Type, structs)
+	}
+}
+
+var requestSchemas sync.Map
+
+// requestSchema returns the schema for requests unmarshaled into t. Model
+// options are checked against [api.Options]; unknown options are allowed.
+func requestSchema(t reflect.Type) *schema {
+	if s, ok := requestSchemas.Load(t); ok {
+		return s.(*schema)
+	}
+
+	s := schemaOf(t)
+	if _, ok := s.properties["options"]; ok {
+		s.properties["options"] = schemaOf(optionsType)
+	}
+
+	requestSchemas.Store(t, s)
+	return s
+}
+
+// property returns the schema of key. Like encoding/json, keys match field
+// names case insensitively if there's no exact match.
+func (s *schema) property(key string) (*schema, bool) {
+	if p, ok := s.properties[key]; ok {
+		return p, true
+	}
+
+	for name, p := range s.properties {
+		if strings.EqualFold(name, key) {
+			return p, true
+		}
+	}
+
+	return s.additional, s.additional != nil
+}
+
+// validate appends an error for each value in v at path that doesn't match s
+func (s *schema) validate(path string, v any, errs []string) []string {
+	if v == nil || len(s.types) == 0 {
+		return errs
+	}
+
+	got := jsonType(v)
+	if !slices.Contains(s.types, got) && (got != "integer" || !slices.Contains(s.types, "number")) {
+		return append(errs, fmt.Sprintf("%s: expected %s, got %s", path, strings.Join(s.types, " or "), got))
+	}
+
+	switch v := v.(type) {
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+
+		for _, k := range keys {
+			if p, ok := s.property(k); ok {
+				errs = p.validate(joinPath(path, k), v[k], errs)
+			}
+		}
+	case []any:
+		if s.items != nil {
+			for i, item := range v {
+				errs = s.items.validate(fmt.Sprintf("%s[%d]", path, i), item, errs)
+			}
+		}
+	}
+
+	return errs
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+
+	return path + "." + key
+}
+
+// jsonType returns the JSON schema type of a value decoded by encoding/json
+func jsonType(v any) string {
+	switch v := v.(type) {
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == math.Trunc(v) && !math.IsInf(v, 0) {
+			return "integer"
+		}
+
+		return "number"
+	}
+
+	return "null"
+}
+
+// validateRequest checks that the JSON in bts matches the type of v, returning
+// an error naming the path and expected type of each mismatched field
+func validateRequest(bts []byte, v any) error {
+	var body any
+	if err := json.Unmarshal(bts, &body); err != nil {
+		return err
+	}
+
+	s := requestSchema(reflect.TypeOf(v))
+	if errs := s.validate("", body, nil); len(errs) > 0 {
+		if strings.HasPrefix(errs[0], ": ") {
+			errs[0] = "request body" + errs[0]
+		}
+
+		return errors.New(strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// bindRequest validates and decodes the JSON body of c into v. It returns
+// io.EOF if the body is empty. A multipart/form-data body has the JSON in its
+// "request" part and raw images in its other parts, and an
+// application/x-ndjson body is the request followed by input chunks.
+func bindRequest(c *gin.Context, v any) error {
+	if isStreamedInput(c) {
+		return bindStreamedInput(c, v)
+	}
+
+	if isMultipart(c) {
+		bts, images, err := readMultipart(c)
+		if err != nil {
+			return err
+		}
+
+		if err := decodeRequest(bts, v); err != nil {
+			return err
+		}
+
+		return attachImages(v, images)
+	}
+
+	bts, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return err
+	}
+
+	return decodeRequest(bts, v)
+}
+
+func decodeRequest(bts []byte, v any) error {
+	if len(bytes.TrimSpace(bts)) == 0 {
+		return io.EOF
+	}
+
+	if err := validateRequest(bts, v); err != nil {
+		return err
+	}
+
+	return json.Unmarshal(bts, v)
+}
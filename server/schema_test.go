@@ -0,0 +1,139 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestValidateRequest(t *testing.T) {
+	cases := []struct {
+		name string
+		body string
+		v    any
+		want string
+	}{
+		{
+			name: "valid chat",
+			body: `{"model": "test", "messages": [{"role": "user", "content": "hi", "images": ["aGk="]}], "stream": false, "keep_alive": "5m", "format": {"type": "object"}, "options": {"num_ctx": 4096, "temperature": 1, "stop": ["\n"], "unknown": "ignored"}}`,
+			v:    &api.ChatRequest{},
+		},
+		{
+			name: "valid generate",
+			body: `{"model": "test", "prompt": "hi", "context": [1, 2, 3], "keep_alive": 300, "stream": null}`,
+			v:    &api.GenerateRequest{},
+		},
+		{
+			name: "tool choice",
+			body: `{"model": "test", "tool_choice": "required"}`,
+			v:    &api.ChatRequest{},
+		},
+		{
+			name: "tool choice function",
+			body: `{"model": "test", "tool_choice": {"type": "function", "function": {"name": 1}}}`,
+			v:    &api.ChatRequest{},
+			want: "tool_choice.function.name: expected string, got integer",
+		},
+		{
+			name: "option type",
+			body: `{"model": "test", "options": {"num_ctx": "4096"}}`,
+			v:    &api.ChatRequest{},
+			want: "options.num_ctx: expected integer, got string",
+		},
+		{
+			name: "fractional integer",
+			body: `{"model": "test", "options": {"num_predict": 1.5}}`,
+			v:    &api.GenerateRequest{},
+			want: "options.num_predict: expected integer, got number",
+		},
+		{
+			name: "nested path",
+			body: `{"model": "test", "messages": [{"role": "user", "content": "hi"}, {"role": "user", "content": 1}]}`,
+			v:    &api.ChatRequest{},
+			want: "messages[1].content: expected string, got integer",
+		},
+		{
+			name: "multiple errors",
+			body: `{"model": 1, "stream": "yes", "options": {"stop": "\n"}}`,
+			v:    &api.GenerateRequest{},
+			want: "model: expected string, got integer; options.stop: expected array, got string; stream: expected boolean, got string",
+		},
+		{
+			name: "keep alive",
+			body: `{"model": "test", "keep_alive": true}`,
+			v:    &api.GenerateRequest{},
+			want: "keep_alive: expected string or number, got boolean",
+		},
+		{
+			name: "tools",
+			body: `{"model": "test", "tools": [{"type": "function", "function": {"name": "f", "parameters": {"required": "x"}}}]}`,
+			v:    &api.ChatRequest{},
+			want: "tools[0].function.parameters.required: expected array, got string",
+		},
+		{
+			name: "case insensitive",
+			body: `{"Model": 1}`,
+			v:    &api.ChatRequest{},
+			want: "Model: expected string, got integer",
+		},
+		{
+			name: "not an object",
+			body: `["test"]`,
+			v:    &api.ChatRequest{},
+			want: "request body: expected object, got array",
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateRequest([]byte(tt.body), tt.v)
+			if tt.want == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			if err == nil || err.Error() != tt.want {
+				t.Fatalf("expected error %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestBindRequest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	bind := func(body string) (api.ChatRequest, error) {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/api/chat", strings.NewReader(body))
+
+		var req api.ChatRequest
+		err := bindRequest(c, &req)
+		return req, err
+	}
+
+	if _, err := bind("  "); !errors.Is(err, io.EOF) {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+
+	if _, err := bind(`{"model": `); err == nil {
+		t.Error("expected error for invalid JSON")
+	}
+
+	req, err := bind(`{"model": "test", "messages": [{"role": "USER", "content": "hi"}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if req.Model != "test" || len(req.Messages) != 1 || req.Messages[0].Role != "user" {
+		t.Errorf("unexpected request %+v", req)
+	}
+}
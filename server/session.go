@@ -0,0 +1,515 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/types/model"
+)
+
+const (
+	// checkpointThreshold is the fraction of the context window the prompt
+	// of a session that summarizes truncated messages fills before the
+	// oldest of its exchanges are summarized
+	checkpointThreshold = 0.75
+	// checkpointTimeout is the longest a session's exchanges are summarized
+	// for
+	checkpointTimeout = 5 * time.Minute
+)
+
+var (
+	errSessionNotFound = errors.New("session not found")
+	errSessionBusy     = errors.New("session is already generating a response")
+	errForkIndex       = errors.New("fork index is out of range")
+)
+
+// chatSession is a conversation whose history is kept by the server, so chat
+// requests only send new messages. Messages that stop fitting in the context
+// window stay dropped in later turns, which keeps the start of the prompt the
+// same from turn to turn so the runner reuses its cache of it.
+//
+// Sessions that summarize truncated messages instead checkpoint a summary of
+// their oldest exchanges as they near the end of the context window, which
+// takes the place of the exchanges in later prompts.
+type chatSession struct {
+	id         string
+	parent     string
+	model      string
+	keepAlive  *api.Duration
+	options    map[string]any
+	memory     string
+	truncation string
+	created    time.Time
+	// owner is the digest of the API key the session was created with
+	owner string
+
+	// expires is guarded by chatSessions
+	expires time.Time
+
+	mu sync.Mutex
+	// messages are only ever appended to, so forks share the messages they
+	// start with
+	messages []api.Message
+	// truncated is the number of messages at the start of messages, other
+	// than system messages, left out of the prompt
+	truncated int
+	busy      bool
+
+	// summary summarizes the first summarized messages, other than system
+	// messages, which it takes the place of in the prompt
+	summary     string
+	summarized  int
+	summarizing bool
+}
+
+var chatSessions = struct {
+	sync.Mutex
+	m map[string]*chatSession
+}{m: make(map[string]*chatSession)}
+
+func newChatSession(name model.Name, req api.SessionRequest, owner string) (*chatSession, error) {
+	name, err := getExistingName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := GetModel(name.String()); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	session := &chatSession{
+		id:         uuid.NewString(),
+		model:      name.String(),
+		keepAlive:  req.KeepAlive,
+		options:    req.Options,
+		memory:     req.Memory,
+		truncation: req.Truncation,
+		created:    now,
+		owner:      owner,
+		expires:    now.Add(envconfig.SessionTTL()),
+		messages:   slices.Clone(req.Messages),
+	}
+
+	addChatSession(session)
+	return session, nil
+}
+
+// addChatSession adds session to the sessions, removing those that expired
+func addChatSession(session *chatSession) {
+	chatSessions.Lock()
+	defer chatSessions.Unlock()
+
+	for id, s := range chatSessions.m {
+		if session.created.After(s.expires) {
+			delete(chatSessions.m, id)
+		}
+	}
+
+	chatSessions.m[session.id] = session
+}
+
+// purgeChatSessions removes the sessions match returns true for, returning
+// the number removed
+func purgeChatSessions(match func(*chatSession) bool) int {
+	chatSessions.Lock()
+	defer chatSessions.Unlock()
+
+	var purged int
+	for id, s := range chatSessions.m {
+		if match(s) {
+			delete(chatSessions.m, id)
+			purged++
+		}
+	}
+
+	return purged
+}
+
+// fork starts a session of owner with the first index messages of s, or all
+// of them if index is nil. The fork keeps the
+// messages of the prefix that s leaves out of the prompt, and its checkpoint
+// if the checkpoint is within the prefix, so the fork's prompts start like
+// those of s and the runner reuses its cache of them. A turn s is
+// generating isn't part of the fork.
+func (s *chatSession) fork(index *int, owner string) (*chatSession, error) {
+	s.mu.Lock()
+	n := len(s.messages)
+	if index != nil {
+		n = *index
+	}
+
+	if n < 0 || n > len(s.messages) {
+		s.mu.Unlock()
+		return nil, errForkIndex
+	}
+
+	now := time.Now()
+	fork := &chatSession{
+		id:         uuid.NewString(),
+		parent:     s.id,
+		model:      s.model,
+		keepAlive:  s.keepAlive,
+		options:    s.options,
+		memory:     s.memory,
+		truncation: s.truncation,
+		created:    now,
+		owner:      owner,
+		expires:    now.Add(envconfig.SessionTTL()),
+		// the capacity is limited so appending to either session copies
+		// the messages rather than writing over the other's
+		messages: s.messages[:n:n],
+	}
+
+	prefix := nonSystemMessages(fork.messages)
+	fork.truncated = min(s.truncated, prefix)
+	if s.summarized <= prefix {
+		fork.summary, fork.summarized = s.summary, s.summarized
+	}
+	s.mu.Unlock()
+
+	addChatSession(fork)
+	return fork, nil
+}
+
+// getChatSession returns the session id if owner may use it
+func getChatSession(id, owner string) (*chatSession, error) {
+	chatSessions.Lock()
+	defer chatSessions.Unlock()
+
+	s, ok := chatSessions.m[id]
+	if !ok || !owns(owner, s.owner) {
+		return nil, errSessionNotFound
+	}
+
+	if time.Now().After(s.expires) {
+		delete(chatSessions.m, id)
+		return nil, errSessionNotFound
+	}
+
+	s.expires = time.Now().Add(envconfig.SessionTTL())
+	return s, nil
+}
+
+func deleteChatSession(id string) {
+	chatSessions.Lock()
+	defer chatSessions.Unlock()
+	delete(chatSessions.m, id)
+}
+
+func (s *chatSession) response(messages bool) api.SessionResponse {
+	chatSessions.Lock()
+	resp := api.SessionResponse{ID: s.id, Model: s.model, Memory: s.memory, Parent: s.parent, CreatedAt: s.created, ExpiresAt: s.expires}
+	chatSessions.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	resp.Truncated = s.truncated
+	resp.Summary = s.summary
+	resp.Summarized = s.summarized
+	if messages {
+		resp.Messages = slices.Clone(s.messages)
+	}
+
+	return resp
+}
+
+// begin starts a chat turn in the session, which has one turn at a time
+func (s *chatSession) begin() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.busy {
+		return errSessionBusy
+	}
+
+	s.busy = true
+	return nil
+}
+
+// end ends the chat turn started by begin
+func (s *chatSession) end() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.busy = false
+}
+
+// history returns the messages of the session left in the prompt, with the
+// summary of its checkpoint in place of the messages it summarizes
+func (s *chatSession) history() []api.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	history := make([]api.Message, 0, len(s.messages)+1)
+	skip := max(s.truncated, s.summarized)
+	var dropped int
+	for _, msg := range s.messages {
+		if msg.Role == "system" {
+			history = append(history, msg)
+			continue
+		}
+
+		if dropped < skip {
+			dropped++
+			continue
+		}
+
+		if dropped == skip && s.summary != "" {
+			history = append(history, summaryMessage(s.summary))
+			dropped++
+		}
+
+		history = append(history, msg)
+	}
+
+	return history
+}
+
+// summaryMessage returns the system message a summary of earlier messages is
+// added to the prompt with
+func summaryMessage(summary string) api.Message {
+	return api.Message{Role: "system", Content: "Summary of the earlier conversation:\n" + summary}
+}
+
+// beginCheckpoint returns the messages the next checkpoint of the session
+// summarizes: its last summary, if any, and the oldest half of the exchanges
+// after it. Exchanges start with a user message, and the latest is never
+// summarized. It returns false if there's nothing to summarize or the
+// session is already being summarized.
+func (s *chatSession) beginCheckpoint() ([]api.Message, int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.summarizing {
+		return nil, 0, false
+	}
+
+	var msgs []api.Message
+	var skipped int
+	for _, msg := range s.messages {
+		if msg.Role == "system" {
+			continue
+		}
+
+		if skipped < s.summarized {
+			skipped++
+			continue
+		}
+
+		msgs = append(msgs, msg)
+	}
+
+	n := len(msgs) / 2
+	for n < len(msgs) && msgs[n].Role != "user" {
+		n++
+	}
+
+	if n == 0 || n >= len(msgs) {
+		return nil, 0, false
+	}
+
+	msgs = msgs[:n]
+	if s.summary != "" {
+		msgs = append([]api.Message{summaryMessage(s.summary)}, msgs...)
+	}
+
+	s.summarizing = true
+	return msgs, n, true
+}
+
+// endCheckpoint checkpoints summary of the count messages after the last
+// checkpoint, or only ends the checkpoint started by beginCheckpoint if
+// count is 0
+func (s *chatSession) endCheckpoint(summary string, count int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.summarizing = false
+	if count > 0 {
+		s.summary = summary
+		s.summarized += count
+	}
+}
+
+// commit adds the messages of a chat turn to the session, with the number of
+// earlier messages the turn left out of the prompt
+func (s *chatSession) commit(msgs []api.Message, truncated int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.messages = append(s.messages, msgs...)
+	s.truncated += truncated
+}
+
+// chatOptions returns the options of a chat request in the session, which
+// override the session's
+func (s *chatSession) chatOptions(opts map[string]any) map[string]any {
+	if len(s.options) == 0 {
+		return opts
+	}
+
+	merged := maps.Clone(s.options)
+	maps.Copy(merged, opts)
+	return merged
+}
+
+// checkpoint summarizes the oldest exchanges of session that aren't
+// summarized yet. It runs after a chat turn, scheduling the model again so
+// the turn's runner is released.
+func (s *Server) checkpoint(session *chatSession, name string, options map[string]any, keepAlive *api.Duration) {
+	msgs, count, ok := session.beginCheckpoint()
+	if !ok {
+		return
+	}
+
+	var summary string
+	defer func() { session.endCheckpoint(summary, count) }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), checkpointTimeout)
+	defer cancel()
+
+	r, m, opts, err := s.scheduleRunner(ctx, name, []Capability{}, options, keepAlive)
+	if err == nil {
+		summary, err = summarizer(r, m, opts)(ctx, msgs)
+	}
+
+	if err != nil || summary == "" {
+		slog.Warn("failed to summarize session", "session", session.id, "error", err)
+		count = 0
+	}
+}
+
+// nonSystemMessages returns the number of msgs that aren't system messages
+func nonSystemMessages(msgs []api.Message) int {
+	var n int
+	for _, msg := range msgs {
+		if msg.Role != "system" {
+			n++
+		}
+	}
+
+	return n
+}
+
+func (s *Server) CreateSessionHandler(c *gin.Context) {
+	var req api.SessionRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	name := model.ParseName(req.Model)
+	if !name.IsValid() {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "model is required"})
+		return
+	}
+
+	if err := checkTruncation(req.Truncation); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Memory != "" {
+		if err := checkMemoryKey(req.Memory); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if !s.claimMemories(c, req.Memory) {
+			return
+		}
+	}
+
+	session, err := newChatSession(name, req, ownerOf(c))
+	if err != nil {
+		handleScheduleError(c, req.Model, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, session.response(true))
+}
+
+func (s *Server) SessionHandler(c *gin.Context) {
+	session, err := getChatSession(c.Param("id"), ownerOf(c))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, session.response(true))
+}
+
+func (s *Server) ForkSessionHandler(c *gin.Context) {
+	var req api.ForkSessionRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	session, err := getChatSession(c.Param("id"), ownerOf(c))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	fork, err := session.fork(req.Index, ownerOf(c))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, fork.response(true))
+}
+
+func (s *Server) ListSessionsHandler(c *gin.Context) {
+	chatSessions.Lock()
+	sessions := slices.Collect(maps.Values(chatSessions.m))
+	chatSessions.Unlock()
+
+	now := time.Now()
+	owner := ownerOf(c)
+	resp := api.ListSessionsResponse{Sessions: []api.SessionResponse{}}
+	for _, session := range sessions {
+		if !owns(owner, session.owner) {
+			continue
+		}
+
+		r := session.response(false)
+		if now.Before(r.ExpiresAt) {
+			resp.Sessions = append(resp.Sessions, r)
+		}
+	}
+
+	slices.SortFunc(resp.Sessions, func(a, b api.SessionResponse) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+
+	c.JSON(http.StatusOK, resp)
+}
+
+func (s *Server) DeleteSessionHandler(c *gin.Context) {
+	session, err := getChatSession(c.Param("id"), ownerOf(c))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	deleteChatSession(session.id)
+	c.Status(http.StatusOK)
+}
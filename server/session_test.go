@@ -0,0 +1,417 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+)
+
+func TestChatSession(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	var replies int
+	mock := mockRunner{CompletionFn: func(_ context.Context, r llm.CompletionRequest, fn func(llm.CompletionResponse)) error {
+		replies++
+		fn(llm.CompletionResponse{Content: strings.Repeat("hi ", replies), Done: true, DoneReason: "stop"})
+		return nil
+	}}
+
+	s := Server{sched: newMockScheduler(t, &mock)}
+	createMockModel(t, &s, "test", `{{- range .Messages }}{{ .Role }}: {{ .Content }}
+{{ end }}`)
+
+	srv := httptest.NewServer(s.GenerateRoutes())
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := api.NewClient(u, http.DefaultClient)
+	ctx := context.Background()
+
+	statusCode := func(err error) int {
+		var se api.StatusError
+		if errors.As(err, &se) {
+			return se.StatusCode
+		}
+		return 0
+	}
+
+	if _, err := client.CreateSession(ctx, &api.SessionRequest{Model: "missing"}); statusCode(err) != http.StatusNotFound {
+		t.Fatalf("expected status 404, got %v", err)
+	}
+
+	session, err := client.CreateSession(ctx, &api.SessionRequest{
+		Model:    "test",
+		Messages: []api.Message{{Role: "system", Content: "You are terse."}},
+		Options:  map[string]any{"seed": 42},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream := false
+	chat := func(content string) error {
+		return client.Chat(ctx, &api.ChatRequest{
+			Session:  session.ID,
+			Messages: []api.Message{{Role: "user", Content: content}},
+			Stream:   &stream,
+		}, func(api.ChatResponse) error { return nil })
+	}
+
+	if err := chat("hello"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := chat("again"); err != nil {
+		t.Fatal(err)
+	}
+
+	// only the new messages are sent, the rest of the chat comes from the
+	// session
+	want := "system: You are terse.\nuser: hello\nassistant: hi \nuser: again\n"
+	if diff := cmp.Diff(want, mock.CompletionRequest.Prompt); diff != "" {
+		t.Errorf("prompt mismatch (-want +got):\n%s", diff)
+	}
+
+	if mock.CompletionRequest.Options.Seed != 42 {
+		t.Errorf("expected the session's options, got seed %d", mock.CompletionRequest.Options.Seed)
+	}
+
+	got, err := client.Session(ctx, session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff([]api.Message{
+		{Role: "system", Content: "You are terse."},
+		{Role: "user", Content: "hello"},
+		{Role: "assistant", Content: "hi "},
+		{Role: "user", Content: "again"},
+		{Role: "assistant", Content: "hi hi "},
+	}, got.Messages); diff != "" {
+		t.Errorf("history mismatch (-want +got):\n%s", diff)
+	}
+
+	// sessions have one chat at a time
+	cs, err := getChatSession(session.ID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cs.begin(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := chat("busy"); err == nil || err.Error() != errSessionBusy.Error() {
+		t.Errorf("expected %v, got %v", errSessionBusy, err)
+	}
+	cs.end()
+
+	list, err := client.ListSessions(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(list.Sessions) != 1 || list.Sessions[0].ID != session.ID || len(list.Sessions[0].Messages) != 0 {
+		t.Errorf("unexpected sessions %+v", list.Sessions)
+	}
+
+	if err := client.DeleteSession(ctx, session.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := chat("gone"); err == nil || err.Error() != errSessionNotFound.Error() {
+		t.Errorf("expected %v, got %v", errSessionNotFound, err)
+	}
+}
+
+func TestChatSessionHistory(t *testing.T) {
+	s := chatSession{messages: []api.Message{
+		{Role: "system", Content: "system"},
+		{Role: "user", Content: "one"},
+		{Role: "assistant", Content: "two"},
+	}}
+
+	s.commit([]api.Message{{Role: "user", Content: "three"}, {Role: "assistant", Content: "four"}}, 2)
+
+	// truncated messages stay out of later prompts, but system messages are
+	// always kept
+	want := []api.Message{
+		{Role: "system", Content: "system"},
+		{Role: "user", Content: "three"},
+		{Role: "assistant", Content: "four"},
+	}
+	if diff := cmp.Diff(want, s.history()); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+
+	if diff := cmp.Diff(map[string]any{"seed": 1, "temperature": 0.5}, (&chatSession{options: map[string]any{"seed": 1, "temperature": 0.1}}).chatOptions(map[string]any{"temperature": 0.5})); diff != "" {
+		t.Errorf("options mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestSessionCheckpoint(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	prompts := make(chan string, 1)
+	mock := mockRunner{CompletionFn: func(_ context.Context, r llm.CompletionRequest, fn func(llm.CompletionResponse)) error {
+		if strings.Contains(r.Prompt, summarizePrompt) {
+			fn(llm.CompletionResponse{Content: "they counted to twenty", Done: true})
+			return nil
+		}
+
+		prompts <- r.Prompt
+		fn(llm.CompletionResponse{Content: "ok", Done: true, DoneReason: "stop"})
+		return nil
+	}}
+
+	s := Server{sched: newMockScheduler(t, &mock)}
+	createMockModel(t, &s, "test", `{{- range .Messages }}{{ .Role }}: {{ .Content }}
+{{ end }}`)
+
+	srv := httptest.NewServer(s.GenerateRoutes())
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := api.NewClient(u, http.DefaultClient)
+	ctx := context.Background()
+
+	session, err := client.CreateSession(ctx, &api.SessionRequest{
+		Model:      "test",
+		Options:    map[string]any{"num_ctx": 40},
+		Truncation: "summarize",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.DeleteSession(ctx, session.ID)
+
+	stream := false
+	chat := func(i int) string {
+		t.Helper()
+		// each message is 11 tokens and each reply 2
+		content := strings.TrimSpace(strings.Repeat(fmt.Sprintf("n%d ", i), 10))
+		if err := client.Chat(ctx, &api.ChatRequest{
+			Session:  session.ID,
+			Messages: []api.Message{{Role: "user", Content: content}},
+			Stream:   &stream,
+		}, func(api.ChatResponse) error { return nil }); err != nil {
+			t.Fatal(err)
+		}
+
+		return <-prompts
+	}
+
+	// the third exchange fills more than 3/4 of the context window, so the
+	// first two are summarized
+	for i := range 3 {
+		chat(i)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got, err := client.Session(ctx, session.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got.Summarized > 0 {
+			if got.Summarized != 4 || got.Summary != "they counted to twenty" || len(got.Messages) != 6 {
+				t.Fatalf("unexpected checkpoint %+v", got)
+			}
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("expected the session to be summarized")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	prompt := chat(3)
+	if !strings.HasPrefix(prompt, "system: Summary of the earlier conversation:\nthey counted to twenty\nuser: n2 ") {
+		t.Errorf("expected the summary in place of the first exchanges, got %q", prompt)
+	}
+
+	if strings.Contains(prompt, "n0") || strings.Contains(prompt, "n1") {
+		t.Errorf("expected the summarized exchanges to be left out, got %q", prompt)
+	}
+}
+
+func TestForkSession(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	mock := mockRunner{CompletionFn: func(_ context.Context, r llm.CompletionRequest, fn func(llm.CompletionResponse)) error {
+		fn(llm.CompletionResponse{Content: "ok", Done: true, DoneReason: "stop"})
+		return nil
+	}}
+
+	s := Server{sched: newMockScheduler(t, &mock)}
+	createMockModel(t, &s, "test", `{{- range .Messages }}{{ .Role }}: {{ .Content }}
+{{ end }}`)
+
+	srv := httptest.NewServer(s.GenerateRoutes())
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := api.NewClient(u, http.DefaultClient)
+	ctx := context.Background()
+
+	session, err := client.CreateSession(ctx, &api.SessionRequest{
+		Model:    "test",
+		Messages: []api.Message{{Role: "system", Content: "You are terse."}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream := false
+	chat := func(id, content string) error {
+		return client.Chat(ctx, &api.ChatRequest{
+			Session:  id,
+			Messages: []api.Message{{Role: "user", Content: content}},
+			Stream:   &stream,
+		}, func(api.ChatResponse) error { return nil })
+	}
+
+	for _, content := range []string{"hello", "again"} {
+		if err := chat(session.ID, content); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// sessions can be forked while they're generating
+	cs, err := getChatSession(session.ID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cs.begin(); err != nil {
+		t.Fatal(err)
+	}
+
+	index := 3
+	fork, err := client.ForkSession(ctx, session.ID, &api.ForkSessionRequest{Index: &index})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs.end()
+
+	if fork.Parent != session.ID || fork.ID == session.ID || len(fork.Messages) != 3 {
+		t.Fatalf("unexpected fork %+v", fork)
+	}
+
+	if err := chat(fork.ID, "instead"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "system: You are terse.\nuser: hello\nassistant: ok\nuser: instead\n"
+	if diff := cmp.Diff(want, mock.CompletionRequest.Prompt); diff != "" {
+		t.Errorf("prompt mismatch (-want +got):\n%s", diff)
+	}
+
+	if err := chat(session.ID, "more"); err != nil {
+		t.Fatal(err)
+	}
+
+	parent, err := client.Session(ctx, session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(parent.Messages) != 7 || parent.Messages[3].Content != "again" || parent.Messages[5].Content != "more" {
+		t.Errorf("expected the parent's history to be unchanged by the fork, got %+v", parent.Messages)
+	}
+
+	// forks default to the whole history
+	all, err := client.ForkSession(ctx, session.ID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(parent.Messages, all.Messages); diff != "" {
+		t.Errorf("history mismatch (-want +got):\n%s", diff)
+	}
+
+	var se api.StatusError
+	index = 8
+	if _, err := client.ForkSession(ctx, session.ID, &api.ForkSessionRequest{Index: &index}); !errors.As(err, &se) || se.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %v", err)
+	}
+
+	if _, err := client.ForkSession(ctx, "missing", nil); !errors.As(err, &se) || se.StatusCode != http.StatusNotFound {
+		t.Errorf("expected status 404, got %v", err)
+	}
+}
+
+func TestForkSessionPrefix(t *testing.T) {
+	messages := []api.Message{{Role: "system", Content: "s"}}
+	for i := range 6 {
+		messages = append(messages, api.Message{Role: "user", Content: fmt.Sprint("u", i)}, api.Message{Role: "assistant", Content: fmt.Sprint("a", i)})
+	}
+
+	// capacity beyond the messages lets appends write in place
+	parent := &chatSession{id: "parent", messages: slices.Grow(messages, 4), truncated: 2, summary: "earlier", summarized: 4}
+
+	cases := []struct {
+		index      int
+		truncated  int
+		summarized int
+	}{
+		{index: 13, truncated: 2, summarized: 4},
+		{index: 5, truncated: 2, summarized: 4},
+		{index: 4, truncated: 2},
+		{index: 2, truncated: 1},
+		{index: 0},
+	}
+
+	for _, tt := range cases {
+		t.Run(fmt.Sprint(tt.index), func(t *testing.T) {
+			fork, err := parent.fork(&tt.index, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer deleteChatSession(fork.id)
+
+			if fork.truncated != tt.truncated || fork.summarized != tt.summarized || (fork.summary != "") != (tt.summarized > 0) {
+				t.Errorf("expected %d truncated and %d summarized, got %d and %d (%q)", tt.truncated, tt.summarized, fork.truncated, fork.summarized, fork.summary)
+			}
+
+			fork.commit([]api.Message{{Role: "user", Content: "fork"}}, 0)
+			parent.commit([]api.Message{{Role: "user", Content: "parent"}}, 0)
+			defer func() { parent.messages = parent.messages[:len(messages)] }()
+
+			if got := fork.messages[tt.index].Content; got != "fork" {
+				t.Errorf("expected the fork's message, got %q", got)
+			}
+
+			if got := parent.messages[len(messages)].Content; got != "parent" {
+				t.Errorf("expected the parent's message, got %q", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"math/rand/v2"
+	"time"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/types/model"
+)
+
+var errShadow = errors.New("shadow")
+
+// shadowTimeout is the longest a shadow request runs for
+const shadowTimeout = 10 * time.Minute
+
+// checkShadow returns an error if opts set a shadow model or fraction that
+// can't be used
+func checkShadow(opts api.Options) error {
+	if opts.Shadow == "" {
+		if opts.ShadowFraction != 0 {
+			return fmt.Errorf("%w_fraction is set without a shadow model", errShadow)
+		}
+		return nil
+	}
+
+	if !model.ParseName(opts.Shadow).IsValid() {
+		return fmt.Errorf("%w %q isn't a valid model name", errShadow, opts.Shadow)
+	}
+
+	if opts.ShadowFraction < 0 || opts.ShadowFraction > 1 {
+		return fmt.Errorf("%w_fraction %v must be between 0 and 1", errShadow, opts.ShadowFraction)
+	}
+
+	return nil
+}
+
+// sampleShadow reports whether a copy of a request made with opts is sent
+// to their shadow model. Every request is copied if no fraction is set.
+func sampleShadow(opts *api.Options) bool {
+	if opts.Shadow == "" {
+		return false
+	}
+
+	return opts.ShadowFraction == 0 || rand.Float32() < opts.ShadowFraction
+}
+
+// shadowOptions returns the options a request's copy is sent with: the
+// request's own, without the shadow so the shadow model's copy isn't copied
+// again
+func shadowOptions(options map[string]any) map[string]any {
+	shadow := maps.Clone(options)
+	if shadow == nil {
+		shadow = make(map[string]any)
+	}
+	shadow["shadow"] = ""
+	shadow["shadow_fraction"] = 0.0
+	return shadow
+}
+
+// shadow sends a copy of a completed request to the shadow model set in
+// opts and logs how its response compares to the primary model's. The
+// copy's response is discarded. run runs the copy on the named model. The
+// copy is skipped if the shadow model doesn't fit without unloading another
+// model, so it never evicts the model it shadows.
+func (s *Server) shadow(kind, name string, opts *api.Options, output string, metrics api.Metrics, run func(ctx context.Context, name string) (llm.CompletionResponse, error)) {
+	shadow := opts.Shadow
+	go func() {
+		ctx, cancel := context.WithTimeout(withNoEvict(context.Background()), shadowTimeout)
+		defer cancel()
+
+		start := time.Now()
+		res, err := run(ctx, shadow)
+		if errors.Is(err, errNoRoom) {
+			slog.Debug("skipping shadow request, the shadow model doesn't fit", "kind", kind, "model", name, "shadow", shadow)
+			s.metrics.add("ollama_shadow_requests_total", metricLabels("model", name, "shadow", shadow, "result", "skipped"), 1)
+			return
+		} else if err != nil {
+			slog.Warn("shadow request failed", "kind", kind, "model", name, "shadow", shadow, "error", err)
+			s.metrics.add("ollama_shadow_requests_total", metricLabels("model", name, "shadow", shadow, "result", "error"), 1)
+			return
+		}
+
+		result := "differ"
+		if res.Content == output {
+			result = "match"
+		}
+
+		slog.Info("shadow request", "kind", kind, "model", name, "shadow", shadow, "result", result,
+			"eval_count", metrics.EvalCount, "shadow_eval_count", res.EvalCount,
+			"tokens_per_second", tokensPerSecond(metrics.EvalCount, metrics.EvalDuration),
+			"shadow_tokens_per_second", tokensPerSecond(res.EvalCount, res.EvalDuration),
+			"shadow_duration", time.Since(start))
+		slog.Debug("shadow response", "model", name, "shadow", shadow,
+			"output_length", len(output), "shadow_output_length", len(res.Content),
+			"common_prefix_length", commonPrefixLength(output, res.Content))
+
+		s.metrics.add("ollama_shadow_requests_total", metricLabels("model", name, "shadow", shadow, "result", result), 1)
+		s.metrics.add("ollama_shadow_generated_tokens_total", metricLabels("model", name, "shadow", shadow), float64(res.EvalCount))
+	}()
+}
+
+// commonPrefixLength returns the length in bytes of the longest prefix a
+// and b share, which is where a shadow's response starts to differ
+func commonPrefixLength(a, b string) int {
+	n := min(len(a), len(b))
+	for i := range n {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+
+	return n
+}
+
+// tokensPerSecond returns the generation speed of count tokens generated in
+// d, or 0 if none were
+func tokensPerSecond(count int, d time.Duration) float64 {
+	if count == 0 || d <= 0 {
+		return 0
+	}
+
+	return float64(count) / d.Seconds()
+}
@@ -0,0 +1,209 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/discover"
+	"github.com/ollama/ollama/llm"
+)
+
+func TestCheckShadow(t *testing.T) {
+	cases := []struct {
+		name string
+		opts api.Options
+		err  bool
+	}{
+		{name: "unset"},
+		{name: "model", opts: api.Options{Shadow: "test:q4_0"}},
+		{name: "fraction", opts: api.Options{Shadow: "test:q4_0", ShadowFraction: 0.1}},
+		{name: "invalid model", opts: api.Options{Shadow: "a b"}, err: true},
+		{name: "fraction without model", opts: api.Options{ShadowFraction: 0.5}, err: true},
+		{name: "negative fraction", opts: api.Options{Shadow: "test", ShadowFraction: -1}, err: true},
+		{name: "fraction over one", opts: api.Options{Shadow: "test", ShadowFraction: 1.5}, err: true},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkShadow(tt.opts)
+			if tt.err != (err != nil) {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+			if err != nil && !errors.Is(err, errShadow) {
+				t.Errorf("expected errShadow, got %v", err)
+			}
+		})
+	}
+}
+
+func TestShadow(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	shadowed := make(chan llm.CompletionRequest, 4)
+	mock := mockRunner{
+		CompletionFn: func(_ context.Context, r llm.CompletionRequest, fn func(llm.CompletionResponse)) error {
+			content := "primary"
+			if r.Options.Shadow == "" {
+				shadowed <- r
+				content = "shadow"
+			}
+
+			fn(llm.CompletionResponse{Content: content, Done: true, DoneReason: "stop", EvalCount: 1, EvalDuration: time.Second})
+			return nil
+		},
+	}
+
+	s := Server{sched: newMockScheduler(t, &mock), metrics: newMetrics()}
+	createMockModel(t, &s, "test", `{{ range .Messages }}{{ .Role }}: {{ .Content }}{{ "\n" }}{{ end }}`)
+	createMockModel(t, &s, "test-next", `{{ range .Messages }}{{ .Role }}: {{ .Content }}{{ "\n" }}{{ end }}`)
+
+	t.Run("chat", func(t *testing.T) {
+		w := createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model:    "test",
+			Messages: []api.Message{{Role: "user", Content: "Hello!"}},
+			Stream:   &stream,
+			Options:  map[string]any{"shadow": "test-next", "temperature": 0.5},
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+
+		select {
+		case r := <-shadowed:
+			if r.Prompt != "user: Hello!\n" {
+				t.Errorf("expected the shadow to be sent the same messages, got %q", r.Prompt)
+			}
+			if r.Options.Temperature != 0.5 {
+				t.Errorf("expected the shadow to be sent the request's options, got temperature %v", r.Options.Temperature)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected a copy of the request to be sent to the shadow model")
+		}
+
+		waitForMetric(t, s.metrics, "ollama_shadow_requests_total", metricLabels("model", "test", "shadow", "test-next", "result", "differ"))
+	})
+
+	t.Run("generate", func(t *testing.T) {
+		w := createRequest(t, s.GenerateHandler, api.GenerateRequest{
+			Model:   "test",
+			Prompt:  "Hello!",
+			Stream:  &stream,
+			Options: map[string]any{"shadow": "test-next"},
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+
+		select {
+		case r := <-shadowed:
+			if r.Prompt != "user: Hello!\n" {
+				t.Errorf("expected the shadow to be sent the same prompt, got %q", r.Prompt)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected a copy of the request to be sent to the shadow model")
+		}
+	})
+
+	t.Run("no store", func(t *testing.T) {
+		w := createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model:    "test",
+			Messages: []api.Message{{Role: "user", Content: "Hello!"}},
+			Stream:   &stream,
+			NoStore:  true,
+			Options:  map[string]any{"shadow": "test-next"},
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+
+		select {
+		case <-shadowed:
+			t.Fatal("expected no_store requests not to be copied")
+		case <-time.After(100 * time.Millisecond):
+		}
+	})
+
+	t.Run("no room", func(t *testing.T) {
+		loadFn := s.sched.loadFn
+		t.Cleanup(func() { s.sched.loadFn = loadFn })
+		s.sched.loadFn = func(req *LlmRequest, ggml *llm.GGML, gpus discover.GpuInfoList, numParallel int) {
+			if req.noEvict {
+				req.errCh <- errNoRoom
+				return
+			}
+			loadFn(req, ggml, gpus, numParallel)
+		}
+
+		w := createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model:    "test",
+			Messages: []api.Message{{Role: "user", Content: "Hello!"}},
+			Stream:   &stream,
+			Options:  map[string]any{"shadow": "test-next"},
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+
+		waitForMetric(t, s.metrics, "ollama_shadow_requests_total", metricLabels("model", "test", "shadow", "test-next", "result", "skipped"))
+		select {
+		case <-shadowed:
+			t.Fatal("expected the shadow not to be loaded in place of another model")
+		default:
+		}
+	})
+
+	t.Run("invalid fraction", func(t *testing.T) {
+		w := createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model:    "test",
+			Messages: []api.Message{{Role: "user", Content: "Hello!"}},
+			Stream:   &stream,
+			Options:  map[string]any{"shadow": "test-next", "shadow_fraction": 2},
+		})
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("expected status 400, got %d: %s", w.Code, w.Body)
+		}
+	})
+}
+
+func TestCommonPrefixLength(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"hello", "hello", 5},
+		{"hello", "help", 3},
+		{"hello", "hello, world", 5},
+		{"a", "b", 0},
+	}
+
+	for _, tt := range cases {
+		if got := commonPrefixLength(tt.a, tt.b); got != tt.want {
+			t.Errorf("commonPrefixLength(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+// waitForMetric waits for a counter to be recorded by a background goroutine
+func waitForMetric(t *testing.T, m *metrics, name, labels string) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		m.mu.Lock()
+		v := m.values[name][labels]
+		m.mu.Unlock()
+		if v > 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("expected %s{%s} to be recorded", name, labels)
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/types/model"
+)
+
+// SplitHandler splits text into chunks of at most a number of tokens, counted
+// with the model's tokenizer
+func (s *Server) SplitHandler(c *gin.Context) {
+	var req api.SplitRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Size <= 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "size must be greater than 0"})
+		return
+	}
+
+	if req.Overlap < 0 || req.Overlap >= req.Size {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "overlap must be at least 0 and less than size"})
+		return
+	}
+
+	name, err := getExistingName(model.ParseName(req.Model))
+	if err != nil {
+		c.JSON(http.StatusNotFound, errorBody(api.ErrorCodeModelNotFound, fmt.Sprintf("model '%s' not found", req.Model)))
+		return
+	}
+
+	r, _, _, err := s.scheduleRunner(c.Request.Context(), name.String(), []Capability{}, req.Options, req.KeepAlive)
+	if err != nil {
+		handleScheduleError(c, req.Model, err)
+		return
+	}
+
+	tokens, err := r.Tokenize(c.Request.Context(), req.Input)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	chunks, err := splitTokens(c.Request.Context(), r.Detokenize, tokens, req.Size, req.Overlap)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, api.SplitResponse{Model: req.Model, Chunks: chunks})
+}
+
+type detokenizeFunc func(context.Context, []int) (string, error)
+
+// splitTokens splits tokens into chunks of at most size tokens, where each
+// chunk starts with the last overlap tokens of the previous one. Chunks are
+// shortened rather than split part way through a character whose bytes are
+// spread over several tokens.
+func splitTokens(ctx context.Context, detokenize detokenizeFunc, tokens []int, size, overlap int) ([]api.Chunk, error) {
+	chunks := []api.Chunk{}
+	for start := 0; start < len(tokens); {
+		end := min(start+size, len(tokens))
+
+		text, err := detokenize(ctx, tokens[start:end])
+		if err != nil {
+			return nil, err
+		}
+
+		for end-start > 1 {
+			if r, n := utf8.DecodeRuneInString(text); start > 0 && r == utf8.RuneError && n == 1 {
+				start++
+			} else if r, n := utf8.DecodeLastRuneInString(text); end < len(tokens) && r == utf8.RuneError && n == 1 {
+				end--
+			} else {
+				break
+			}
+
+			if text, err = detokenize(ctx, tokens[start:end]); err != nil {
+				return nil, err
+			}
+		}
+
+		chunks = append(chunks, api.Chunk{Text: text, Tokens: end - start})
+		if end == len(tokens) {
+			break
+		}
+
+		start = max(end-overlap, start+1)
+	}
+
+	return chunks, nil
+}
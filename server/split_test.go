@@ -0,0 +1,133 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+)
+
+// bytesDetokenize treats every token as a byte of the text
+func bytesDetokenize(_ context.Context, tokens []int) (string, error) {
+	b := make([]byte, len(tokens))
+	for i, t := range tokens {
+		b[i] = byte(t)
+	}
+	return string(b), nil
+}
+
+func bytesTokens(s string) []int {
+	tokens := make([]int, len(s))
+	for i := range len(s) {
+		tokens[i] = int(s[i])
+	}
+	return tokens
+}
+
+func TestSplitTokens(t *testing.T) {
+	cases := []struct {
+		name    string
+		input   string
+		size    int
+		overlap int
+		want    []api.Chunk
+	}{
+		{"empty", "", 4, 0, []api.Chunk{}},
+		{"single chunk", "abc", 4, 0, []api.Chunk{{Text: "abc", Tokens: 3}}},
+		{
+			"exact",
+			"abcdefgh",
+			4,
+			0,
+			[]api.Chunk{{Text: "abcd", Tokens: 4}, {Text: "efgh", Tokens: 4}},
+		},
+		{
+			"remainder",
+			"abcdefghij",
+			4,
+			0,
+			[]api.Chunk{{Text: "abcd", Tokens: 4}, {Text: "efgh", Tokens: 4}, {Text: "ij", Tokens: 2}},
+		},
+		{
+			"overlap",
+			"abcdefgh",
+			4,
+			2,
+			[]api.Chunk{{Text: "abcd", Tokens: 4}, {Text: "cdef", Tokens: 4}, {Text: "efgh", Tokens: 4}},
+		},
+		{
+			"multi-byte boundary",
+			"abcé",
+			4,
+			0,
+			[]api.Chunk{{Text: "abc", Tokens: 3}, {Text: "é", Tokens: 2}},
+		},
+		{
+			"multi-byte overlap",
+			"abé",
+			3,
+			1,
+			[]api.Chunk{{Text: "ab", Tokens: 2}, {Text: "bé", Tokens: 3}},
+		},
+		{
+			"multi-byte overlap start",
+			"aéb",
+			3,
+			2,
+			[]api.Chunk{{Text: "aé", Tokens: 3}, {Text: "éb", Tokens: 3}},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := splitTokens(context.Background(), bytesDetokenize, bytesTokens(tt.input), tt.size, tt.overlap)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	t.Run("detokenize error", func(t *testing.T) {
+		_, err := splitTokens(context.Background(), func(context.Context, []int) (string, error) {
+			return "", errors.New("boom")
+		}, []int{1, 2}, 4, 0)
+		if err == nil || err.Error() != "boom" {
+			t.Errorf("expected error, got %v", err)
+		}
+	})
+}
+
+func TestSplitHandlerValidation(t *testing.T) {
+	var s Server
+
+	cases := []struct {
+		name string
+		req  api.SplitRequest
+		err  string
+	}{
+		{"missing size", api.SplitRequest{Model: "test", Input: "hi"}, "size must be greater than 0"},
+		{"negative overlap", api.SplitRequest{Model: "test", Input: "hi", Size: 4, Overlap: -1}, "overlap must be at least 0 and less than size"},
+		{"overlap too large", api.SplitRequest{Model: "test", Input: "hi", Size: 4, Overlap: 4}, "overlap must be at least 0 and less than size"},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			w := createRequest(t, s.SplitHandler, tt.req)
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("expected status 400, got %d", w.Code)
+			}
+
+			if diff := cmp.Diff(`{"error":"`+tt.err+`"}`, w.Body.String()); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,29 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/stop"
+)
+
+var errStop = errors.New("invalid stop")
+
+// checkStop returns an error if the stop patterns of opts can't be matched
+// by the runner of m
+func checkStop(m *Model, opts api.Options) error {
+	if len(opts.StopRegex) == 0 {
+		return nil
+	}
+
+	if m.Config.Remote != nil || opts.Fallback != "" {
+		return fmt.Errorf("%w: remote servers don't support stop_regex", errStop)
+	}
+
+	if _, err := stop.Compile(opts.StopRegex); err != nil {
+		return fmt.Errorf("%w: %w", errStop, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,37 @@
+package server
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestCheckStop(t *testing.T) {
+	local := &Model{}
+	remote := &Model{Config: ConfigV2{Remote: &api.RemoteModel{URL: "http://example.com/v1"}}}
+
+	cases := []struct {
+		m    *Model
+		stop []string
+		err  bool
+	}{
+		{local, nil, false},
+		{local, []string{`\n{2,}`}, false},
+		{local, []string{"("}, true},
+		{local, []string{"x*"}, true},
+		{remote, nil, false},
+		{remote, []string{`\n{2,}`}, true},
+	}
+
+	for _, tt := range cases {
+		opts := api.DefaultOptions()
+		opts.Stop = []string{"</s>", "/x*/"}
+		opts.StopRegex = tt.stop
+
+		err := checkStop(tt.m, opts)
+		if tt.err != errors.Is(err, errStop) {
+			t.Errorf("%q: unexpected error %v", tt.stop, err)
+		}
+	}
+}
@@ -0,0 +1,530 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/types/model"
+)
+
+var errReadOnlyStore = errors.New("model store is read-only")
+
+// Store is a storage backend for models' manifests and blobs. The local
+// models directory is a store on disk that models are written to, and that
+// they're read from along with the read-only stores of the shared models and
+// shared blob directories (see diskStores). Runners load blobs from these
+// directories directly, and blobs that are built up over time, by creating,
+// pulling or uploading them, are written in place in the local store's
+// directory.
+//
+// Models are also mirrored to the store configured with OLLAMA_STORE so that
+// servers sharing it can run them without pulling them from a registry.
+// Models that aren't on disk are copied to the local store from it when
+// they're first used. Mirroring is best-effort: models are written locally
+// whether or not the mirror can be written.
+//
+// Keys are slash-separated paths laid out like the models directory, such as
+// "manifests/registry.ollama.ai/library/llama3.2/latest" and
+// "blobs/sha256-<digest>". Each blob has a list of the manifests that
+// reference it at "blobs/sha256-<digest>.refs", so it's deleted with the last
+// of them.
+type Store interface {
+	// Open returns the contents of key. The error wraps fs.ErrNotExist if
+	// key doesn't exist.
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+
+	// Exists reports whether key exists
+	Exists(ctx context.Context, key string) (bool, error)
+
+	// Put writes size bytes read from r to key. Read-only stores return an
+	// error wrapping errReadOnlyStore.
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+
+	// Delete removes key. Read-only stores return an error wrapping
+	// errReadOnlyStore.
+	Delete(ctx context.Context, key string) error
+}
+
+// StoreFunc opens the store configured by a URL
+type StoreFunc func(u *url.URL) (Store, error)
+
+var storeFuncs = map[string]StoreFunc{
+	"file":  newDiskStore,
+	"http":  newHTTPStore,
+	"https": newHTTPStore,
+	"s3":    newS3Store,
+}
+
+// RegisterStore registers the function that opens stores with URLs of scheme,
+// which replaces any store already registered for it. The store is selected
+// by OLLAMA_STORE, such as OLLAMA_STORE=s3://bucket/models.
+func RegisterStore(scheme string, fn StoreFunc) {
+	storeFuncs[strings.ToLower(scheme)] = fn
+}
+
+// OpenStore opens the store configured by rawURL with the function registered
+// for its scheme
+func OpenStore(rawURL string) (Store, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	fn, ok := storeFuncs[strings.ToLower(u.Scheme)]
+	if !ok {
+		return nil, fmt.Errorf("no model store is registered for %q", u.Scheme)
+	}
+
+	return fn(u)
+}
+
+// storeOverride is the store of a server embedded with Options.Store, which
+// takes precedence over OLLAMA_STORE
+var storeOverride struct {
+	mu    sync.Mutex
+	store Store
+}
+
+// modelStore returns the configured store, or nil if models are only kept in
+// the local models directory
+func modelStore() (Store, error) {
+	storeOverride.mu.Lock()
+	defer storeOverride.mu.Unlock()
+	if storeOverride.store != nil {
+		return storeOverride.store, nil
+	}
+
+	if s := envconfig.Store(); s != "" {
+		return OpenStore(s)
+	}
+
+	return nil, nil
+}
+
+func manifestKey(n model.Name) string {
+	return path.Join("manifests", filepath.ToSlash(n.Filepath()))
+}
+
+func blobKey(digest string) string {
+	return path.Join("blobs", strings.ReplaceAll(digest, ":", "-"))
+}
+
+func refsKey(digest string) string {
+	return blobKey(digest) + ".refs"
+}
+
+// blobRefs returns the keys of the manifests in s that reference a blob. It
+// returns false if the blob has no list, such as when it was written before
+// references were tracked, in which case it's never deleted.
+func blobRefs(ctx context.Context, s Store, digest string) ([]string, bool, error) {
+	r, err := s.Open(ctx, refsKey(digest))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	defer r.Close()
+
+	var refs []string
+	if err := json.NewDecoder(r).Decode(&refs); err != nil {
+		return nil, false, err
+	}
+
+	return refs, true, nil
+}
+
+func putBlobRefs(ctx context.Context, s Store, digest string, refs []string) error {
+	bts, err := json.Marshal(refs)
+	if err != nil {
+		return err
+	}
+
+	return s.Put(ctx, refsKey(digest), bytes.NewReader(bts), int64(len(bts)))
+}
+
+// fetchFromStore copies the model n and its blobs from the mirror to the
+// local store. It returns an error wrapping fs.ErrNotExist if there's no
+// mirror or n isn't in it.
+func fetchFromStore(ctx context.Context, n model.Name) error {
+	s, err := modelStore()
+	if err != nil {
+		return err
+	} else if s == nil {
+		return fs.ErrNotExist
+	}
+
+	r, err := s.Open(ctx, manifestKey(n))
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	bts, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(bts, &m); err != nil {
+		return err
+	}
+
+	slog.Info("copying model from the model store", "model", n.DisplayShortest())
+	for _, layer := range append(m.Layers, m.Config) {
+		if err := fetchBlob(ctx, s, layer.Digest); err != nil {
+			return fmt.Errorf("copying %s from the model store: %w", layer.Digest, err)
+		}
+	}
+
+	return localStore().Put(ctx, manifestKey(n), bytes.NewReader(bts), int64(len(bts)))
+}
+
+// fetchBlob copies a blob from the mirror to the local store unless it's
+// already on disk, checking its digest
+func fetchBlob(ctx context.Context, s Store, digest string) error {
+	if blobStore(digest) != nil {
+		return nil
+	}
+
+	r, err := s.Open(ctx, blobKey(digest))
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	return localStore().Put(ctx, blobKey(digest), &digestReader{r: r, h: sha256.New(), digest: digest}, -1)
+}
+
+// digestReader reads r, failing at its end with errDigestMismatch if what
+// was read doesn't have digest, so a store doesn't keep it
+type digestReader struct {
+	r      io.Reader
+	h      hash.Hash
+	digest string
+}
+
+func (d *digestReader) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	d.h.Write(p[:n])
+	if errors.Is(err, io.EOF) {
+		if got := fmt.Sprintf("sha256:%x", d.h.Sum(nil)); got != d.digest {
+			return n, fmt.Errorf("%w: want %s, got %s", errDigestMismatch, d.digest, got)
+		}
+	}
+
+	return n, err
+}
+
+// pushToStore copies the model n and its blobs from the local models
+// directory to the store. Blobs already in the store aren't copied again.
+func pushToStore(ctx context.Context, n model.Name, m *Manifest) error {
+	s, err := modelStore()
+	if err != nil || s == nil {
+		return err
+	}
+
+	for _, layer := range append(m.Layers, m.Config) {
+		err := pushBlob(ctx, s, layer)
+		if errors.Is(err, errReadOnlyStore) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		refs, _, err := blobRefs(ctx, s, layer.Digest)
+		if err != nil {
+			return err
+		}
+
+		if !slices.Contains(refs, manifestKey(n)) {
+			if err := putBlobRefs(ctx, s, layer.Digest, append(refs, manifestKey(n))); err != nil {
+				return err
+			}
+		}
+	}
+
+	bts, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	err = s.Put(ctx, manifestKey(n), strings.NewReader(string(bts)), int64(len(bts)))
+	if errors.Is(err, errReadOnlyStore) {
+		return nil
+	}
+
+	return err
+}
+
+// pushBlob copies the blob of layer to the store unless it's already there
+func pushBlob(ctx context.Context, s Store, layer Layer) error {
+	key := blobKey(layer.Digest)
+	if ok, err := s.Exists(ctx, key); err != nil {
+		return err
+	} else if ok {
+		return nil
+	}
+
+	f, err := layer.Open()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return s.Put(ctx, key, f, layer.Size)
+}
+
+// deleteFromStore removes the model n from the store, and the blobs no
+// other model in the store references
+func deleteFromStore(ctx context.Context, n model.Name) error {
+	s, err := modelStore()
+	if err != nil || s == nil {
+		return err
+	}
+
+	var m Manifest
+	r, err := s.Open(ctx, manifestKey(n))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	err = json.NewDecoder(r).Decode(&m)
+	r.Close()
+	if err != nil {
+		return err
+	}
+
+	err = s.Delete(ctx, manifestKey(n))
+	if errors.Is(err, errReadOnlyStore) || errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for _, layer := range append(m.Layers, m.Config) {
+		refs, ok, err := blobRefs(ctx, s, layer.Digest)
+		if err != nil {
+			return err
+		} else if !ok {
+			continue
+		}
+
+		refs = slices.DeleteFunc(refs, func(ref string) bool { return ref == manifestKey(n) })
+		if len(refs) > 0 {
+			if err := putBlobRefs(ctx, s, layer.Digest, refs); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := s.Delete(ctx, blobKey(layer.Digest)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+
+		if err := s.Delete(ctx, refsKey(layer.Digest)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// diskStore is a store in a directory laid out like the models directory:
+// the local models directory, a shared directory, or a mirror on a network
+// volume configured with a file URL, such as file:///mnt/models.
+type diskStore struct {
+	root string
+
+	// blobsOnly stores keep blobs directly in root, without manifests, like
+	// the shared blob directory
+	blobsOnly bool
+
+	// readOnly stores are never written to, like the shared directories
+	readOnly bool
+}
+
+func newDiskStore(u *url.URL) (Store, error) {
+	if u.Path == "" {
+		return nil, errors.New("file model store requires a path")
+	}
+
+	return &diskStore{root: filepath.FromSlash(u.Path)}, nil
+}
+
+// path returns the file of key, or "" if the store can't hold it
+func (s *diskStore) path(key string) string {
+	if s.blobsOnly {
+		name, ok := strings.CutPrefix(key, "blobs/")
+		if !ok || strings.Contains(name, "/") {
+			return ""
+		}
+
+		return filepath.Join(s.root, name)
+	}
+
+	return filepath.Join(s.root, filepath.FromSlash(key))
+}
+
+// stat returns the file info of key, which must be a regular file
+func (s *diskStore) stat(key string) (fs.FileInfo, error) {
+	p := s.path(key)
+	if p == "" {
+		return nil, &fs.PathError{Op: "stat", Path: key, Err: fs.ErrNotExist}
+	}
+
+	fi, err := os.Stat(p)
+	if err != nil {
+		return nil, err
+	} else if !fi.Mode().IsRegular() {
+		return nil, &fs.PathError{Op: "stat", Path: p, Err: fs.ErrNotExist}
+	}
+
+	return fi, nil
+}
+
+func (s *diskStore) Open(_ context.Context, key string) (io.ReadCloser, error) {
+	if _, err := s.stat(key); err != nil {
+		return nil, err
+	}
+
+	return os.Open(s.path(key))
+}
+
+func (s *diskStore) Exists(_ context.Context, key string) (bool, error) {
+	if _, err := s.stat(key); errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (s *diskStore) Put(_ context.Context, key string, r io.Reader, _ int64) error {
+	if s.readOnly {
+		return fmt.Errorf("%s: %w", key, errReadOnlyStore)
+	}
+
+	p := s.path(key)
+	if p == "" {
+		return fmt.Errorf("%s: %w", key, errReadOnlyStore)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+
+	// write to a temporary file first so readers never see part of a file
+	temp, err := os.CreateTemp(filepath.Dir(p), ".put-")
+	if err != nil {
+		return err
+	}
+	defer temp.Close()
+	defer os.Remove(temp.Name())
+
+	if _, err := io.Copy(temp, r); err != nil {
+		return err
+	}
+
+	if err := temp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(temp.Name(), 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(temp.Name(), p)
+}
+
+func (s *diskStore) Delete(_ context.Context, key string) error {
+	if s.readOnly {
+		return fmt.Errorf("%s: %w", key, errReadOnlyStore)
+	}
+
+	return os.Remove(s.path(key))
+}
+
+// httpStore is a read-only store served over HTTP, such as a static file
+// server or a public bucket. It's configured with the URL keys are relative
+// to, such as https://models.example.com/ollama.
+type httpStore struct {
+	base *url.URL
+}
+
+func newHTTPStore(u *url.URL) (Store, error) {
+	base := *u
+	base.Path = strings.TrimSuffix(base.Path, "/") + "/"
+	return &httpStore{base: &base}, nil
+}
+
+func (s *httpStore) do(ctx context.Context, method, key string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, s.base.JoinPath(key).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: %w", key, fs.ErrNotExist)
+	case resp.StatusCode >= http.StatusBadRequest:
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: %s", key, resp.Status)
+	}
+
+	return resp, nil
+}
+
+func (s *httpStore) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
+
+func (s *httpStore) Exists(ctx context.Context, key string) (bool, error) {
+	resp, err := s.do(ctx, http.MethodHead, key)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+
+	return true, nil
+}
+
+func (s *httpStore) Put(context.Context, string, io.Reader, int64) error {
+	return errReadOnlyStore
+}
+
+func (s *httpStore) Delete(context.Context, string) error {
+	return errReadOnlyStore
+}
@@ -0,0 +1,328 @@
+package server
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ollama/ollama/format"
+)
+
+// s3PartSize is the size of the parts objects larger than it are uploaded
+// in, since a single upload is limited to 5 GiB
+const s3PartSize = 512 * format.MebiByte
+
+// s3MaxParts is the most parts an object can be uploaded in
+const s3MaxParts = 10000
+
+// s3Store is a store in an S3 bucket, or a bucket of a service compatible
+// with S3. It's configured with a URL of the bucket and the prefix keys are
+// under, such as s3://bucket/models. The region and endpoint can be set with
+// the region and endpoint query parameters, and otherwise come from
+// AWS_REGION and AWS_ENDPOINT_URL_S3. Credentials come from
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN.
+type s3Store struct {
+	bucket, prefix string
+	region         string
+
+	// endpoint is set for services other than AWS, whose buckets are
+	// addressed by path
+	endpoint *url.URL
+
+	accessKey, secretKey, sessionToken string
+
+	// partSize is the size of the parts of multipart uploads
+	partSize int64
+
+	// now is the time requests are signed at
+	now func() time.Time
+}
+
+func newS3Store(u *url.URL) (Store, error) {
+	if u.Host == "" {
+		return nil, errors.New("s3 model store requires a bucket")
+	}
+
+	q := u.Query()
+	s := &s3Store{
+		bucket:       u.Host,
+		prefix:       strings.Trim(u.Path, "/"),
+		region:       cmp.Or(q.Get("region"), os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"), "us-east-1"),
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		partSize:     s3PartSize,
+		now:          time.Now,
+	}
+
+	if endpoint := cmp.Or(q.Get("endpoint"), os.Getenv("AWS_ENDPOINT_URL_S3")); endpoint != "" {
+		var err error
+		if s.endpoint, err = url.Parse(endpoint); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.accessKey == "" || s.secretKey == "" {
+		return nil, errors.New("s3 model store requires AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+
+	return s, nil
+}
+
+// url returns the URL of key
+func (s *s3Store) url(key string) *url.URL {
+	key = strings.TrimPrefix(s.prefix+"/"+key, "/")
+	if s.endpoint != nil {
+		return s.endpoint.JoinPath(s.bucket, key)
+	}
+
+	return &url.URL{
+		Scheme: "https",
+		Host:   fmt.Sprintf("%s.s3.%s.amazonaws.com", s.bucket, s.region),
+		Path:   "/" + key,
+	}
+}
+
+func (s *s3Store) do(ctx context.Context, method, key string, query url.Values, body io.Reader, size int64) (*http.Response, error) {
+	if body != nil && size == 0 {
+		body = http.NoBody
+	}
+
+	u := s.url(key)
+	u.RawQuery = query.Encode()
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+
+	if body != nil {
+		req.ContentLength = size
+	}
+
+	s.sign(req)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: %w", key, fs.ErrNotExist)
+	case resp.StatusCode >= http.StatusBadRequest:
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("%s: %s: %s", key, resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	return resp, nil
+}
+
+// sign signs req with AWS Signature Version 4. The payload isn't signed so
+// blobs can be streamed.
+func (s *s3Store) sign(req *http.Request) {
+	const payload = "UNSIGNED-PAYLOAD"
+
+	t := s.now().UTC()
+	date := t.Format("20060102")
+	req.Header.Set("X-Amz-Date", t.Format("20060102T150405Z"))
+	req.Header.Set("X-Amz-Content-Sha256", payload)
+	if s.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s3Escape(req.URL.Path),
+		strings.ReplaceAll(req.URL.Query().Encode(), "+", "%20"),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payload,
+	}, "\n")
+
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + t.Format("20060102T150405Z") + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + s.secretKey)
+	for _, v := range []string{date, s.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, v)
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, hex.EncodeToString(hmacSHA256(key, stringToSign)),
+	))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3Escape escapes a path the way AWS signatures expect, which escapes every
+// byte but unreserved characters and slashes
+func s3Escape(s string) string {
+	var sb strings.Builder
+	for _, b := range []byte(s) {
+		switch {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9',
+			b == '-', b == '_', b == '.', b == '~', b == '/':
+			sb.WriteByte(b)
+		default:
+			fmt.Fprintf(&sb, "%%%02X", b)
+		}
+	}
+
+	return sb.String()
+}
+
+func (s *s3Store) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
+
+func (s *s3Store) Exists(ctx context.Context, key string) (bool, error) {
+	resp, err := s.do(ctx, http.MethodHead, key, nil, nil, 0)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+
+	return true, nil
+}
+
+func (s *s3Store) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	if size > s.partSize {
+		return s.putMultipart(ctx, key, r, size)
+	}
+
+	resp, err := s.do(ctx, http.MethodPut, key, nil, r, size)
+	if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
+
+type s3Part struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+// putMultipart writes size bytes read from r to key in parts, aborting the
+// upload if a part fails so its parts don't take space in the bucket
+func (s *s3Store) putMultipart(ctx context.Context, key string, r io.Reader, size int64) (err error) {
+	resp, err := s.do(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, nil, 0)
+	if err != nil {
+		return err
+	}
+
+	var upload struct {
+		UploadID string `xml:"UploadId"`
+	}
+	err = xml.NewDecoder(resp.Body).Decode(&upload)
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("%s: starting upload: %w", key, err)
+	} else if upload.UploadID == "" {
+		return fmt.Errorf("%s: starting upload: no upload id", key)
+	}
+
+	defer func() {
+		if err != nil {
+			if resp, err := s.do(context.WithoutCancel(ctx), http.MethodDelete, key, url.Values{"uploadId": {upload.UploadID}}, nil, 0); err == nil {
+				resp.Body.Close()
+			}
+		}
+	}()
+
+	partSize := max(s.partSize, (size+s3MaxParts-1)/s3MaxParts)
+	var parts []s3Part
+	for n := 1; size > 0; n++ {
+		length := min(partSize, size)
+		resp, err := s.do(ctx, http.MethodPut, key, url.Values{"partNumber": {strconv.Itoa(n)}, "uploadId": {upload.UploadID}}, io.LimitReader(r, length), length)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+
+		parts = append(parts, s3Part{PartNumber: n, ETag: resp.Header.Get("ETag")})
+		size -= length
+	}
+
+	var body bytes.Buffer
+	if err := xml.NewEncoder(&body).Encode(struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []s3Part `xml:"Part"`
+	}{Parts: parts}); err != nil {
+		return err
+	}
+
+	resp, err = s.do(ctx, http.MethodPost, key, url.Values{"uploadId": {upload.UploadID}}, &body, int64(body.Len()))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// completing an upload can fail after it's been accepted, which is
+	// reported in the body of a successful response
+	var result struct {
+		XMLName xml.Name
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err == nil && result.XMLName.Local == "Error" {
+		return fmt.Errorf("%s: completing upload: %s: %s", key, result.Code, result.Message)
+	}
+
+	return nil
+}
+
+func (s *s3Store) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, nil, 0)
+	if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
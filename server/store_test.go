@@ -0,0 +1,277 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ollama/ollama/types/model"
+)
+
+func TestStore(t *testing.T) {
+	store := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	t.Setenv("OLLAMA_STORE", "file://"+filepath.ToSlash(store))
+
+	// models written locally are mirrored to the store
+	config, err := NewLayer(strings.NewReader("{}"), "application/vnd.docker.container.image.v1+json")
+	require.NoError(t, err)
+	layer, err := NewLayer(strings.NewReader("weights"), "application/vnd.ollama.image.model")
+	require.NoError(t, err)
+
+	name := model.ParseName("stored")
+	require.NoError(t, WriteManifest(name, config, []Layer{layer}))
+	require.FileExists(t, filepath.Join(store, filepath.FromSlash(manifestKey(name))))
+	require.FileExists(t, filepath.Join(store, filepath.FromSlash(blobKey(layer.Digest))))
+
+	// another server copies them from the store when they're first used
+	models := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", models)
+	m, err := ParseNamedManifest(name)
+	require.NoError(t, err)
+	require.Equal(t, layer.Digest, m.Layers[0].Digest)
+	require.FileExists(t, filepath.Join(models, "manifests", name.Filepath()))
+
+	blob, err := GetBlobsPath(layer.Digest)
+	require.NoError(t, err)
+	bts, err := os.ReadFile(blob)
+	require.NoError(t, err)
+	require.Equal(t, "weights", string(bts))
+
+	// copies are mirrored too
+	copied := model.ParseName("copied")
+	require.NoError(t, CopyModel(name, copied))
+	require.FileExists(t, filepath.Join(store, filepath.FromSlash(manifestKey(copied))))
+
+	// deleting a model deletes its manifest but keeps the blobs other models
+	// reference
+	require.NoError(t, m.Remove())
+	require.NoFileExists(t, filepath.Join(store, filepath.FromSlash(manifestKey(name))))
+	require.FileExists(t, filepath.Join(store, filepath.FromSlash(blobKey(layer.Digest))))
+
+	// and deletes them with the last model that does
+	m, err = ParseNamedManifest(copied)
+	require.NoError(t, err)
+	require.NoError(t, m.Remove())
+	require.NoFileExists(t, filepath.Join(store, filepath.FromSlash(blobKey(layer.Digest))))
+	require.NoFileExists(t, filepath.Join(store, filepath.FromSlash(refsKey(layer.Digest))))
+
+	// models in neither place don't exist
+	_, err = ParseNamedManifest(model.ParseName("missing"))
+	require.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+// failingStore is a store that can't be reached
+type failingStore struct{}
+
+func (failingStore) Open(context.Context, string) (io.ReadCloser, error) {
+	return nil, errors.New("unreachable")
+}
+
+func (failingStore) Exists(context.Context, string) (bool, error) {
+	return false, errors.New("unreachable")
+}
+
+func (failingStore) Put(context.Context, string, io.Reader, int64) error {
+	return errors.New("unreachable")
+}
+
+func (failingStore) Delete(context.Context, string) error {
+	return errors.New("unreachable")
+}
+
+func TestStoreBestEffort(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	storeOverride.mu.Lock()
+	storeOverride.store = failingStore{}
+	storeOverride.mu.Unlock()
+	t.Cleanup(func() {
+		storeOverride.mu.Lock()
+		storeOverride.store = nil
+		storeOverride.mu.Unlock()
+	})
+
+	config, err := NewLayer(strings.NewReader("{}"), "application/vnd.docker.container.image.v1+json")
+	require.NoError(t, err)
+
+	// models are written, copied and deleted locally even if the store
+	// can't be written
+	name := model.ParseName("local")
+	require.NoError(t, WriteManifest(name, config, nil))
+	require.NoError(t, CopyModel(name, model.ParseName("local-copy")))
+
+	m, err := ParseNamedManifest(name)
+	require.NoError(t, err)
+	require.NoError(t, m.Remove())
+}
+
+func TestStoreDigestMismatch(t *testing.T) {
+	store := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	t.Setenv("OLLAMA_STORE", "file://"+filepath.ToSlash(store))
+
+	config, err := NewLayer(strings.NewReader("{}"), "application/vnd.docker.container.image.v1+json")
+	require.NoError(t, err)
+
+	name := model.ParseName("corrupt")
+	require.NoError(t, WriteManifest(name, config, nil))
+	require.NoError(t, os.WriteFile(filepath.Join(store, filepath.FromSlash(blobKey(config.Digest))), []byte("[]"), 0o644))
+
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	_, err = ParseNamedManifest(name)
+	require.ErrorIs(t, err, errDigestMismatch)
+}
+
+func TestHTTPStore(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "blobs"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "blobs", "sha256-x"), []byte("blob"), 0o644))
+
+	srv := httptest.NewServer(http.StripPrefix("/ollama", http.FileServer(http.Dir(dir))))
+	defer srv.Close()
+
+	s, err := OpenStore(srv.URL + "/ollama")
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	ok, err := s.Exists(ctx, "blobs/sha256-x")
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	r, err := s.Open(ctx, "blobs/sha256-x")
+	require.NoError(t, err)
+	bts, err := io.ReadAll(r)
+	r.Close()
+	require.NoError(t, err)
+	require.Equal(t, "blob", string(bts))
+
+	_, err = s.Open(ctx, "blobs/sha256-y")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+
+	require.ErrorIs(t, s.Put(ctx, "blobs/sha256-y", strings.NewReader("blob"), 4), errReadOnlyStore)
+}
+
+func TestS3Store(t *testing.T) {
+	var mu sync.Mutex
+	objects := map[string][]byte{}
+	parts := map[string][]byte{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=key/") || !strings.Contains(auth, "/eu-west-1/s3/aws4_request") || r.Header.Get("X-Amz-Date") == "" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		q := r.URL.Query()
+		switch {
+		case r.Method == http.MethodPost && q.Has("uploads"):
+			w.Write([]byte(`<InitiateMultipartUploadResult><UploadId>upload</UploadId></InitiateMultipartUploadResult>`))
+		case r.Method == http.MethodPut && q.Get("uploadId") == "upload":
+			bts, _ := io.ReadAll(r.Body)
+			parts[q.Get("partNumber")] = bts
+			w.Header().Set("ETag", `"`+q.Get("partNumber")+`"`)
+		case r.Method == http.MethodPost && q.Get("uploadId") == "upload":
+			var complete struct {
+				Parts []s3Part `xml:"Part"`
+			}
+			if err := xml.NewDecoder(r.Body).Decode(&complete); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			var bts []byte
+			for _, p := range complete.Parts {
+				if p.ETag != `"`+strconv.Itoa(p.PartNumber)+`"` {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				bts = append(bts, parts[strconv.Itoa(p.PartNumber)]...)
+			}
+			objects[r.URL.Path] = bts
+			w.Write([]byte(`<CompleteMultipartUploadResult></CompleteMultipartUploadResult>`))
+		case r.Method == http.MethodPut:
+			bts, _ := io.ReadAll(r.Body)
+			objects[r.URL.Path] = bts
+		case r.Method == http.MethodGet, r.Method == http.MethodHead:
+			bts, ok := objects[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write(bts)
+		case r.Method == http.MethodDelete:
+			delete(objects, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer srv.Close()
+
+	_, err := OpenStore("s3://bucket/models")
+	require.Error(t, err, "credentials are required")
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "key")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	s, err := OpenStore("s3://bucket/models?region=eu-west-1&endpoint=" + url.QueryEscape(srv.URL))
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	require.NoError(t, s.Put(ctx, "blobs/sha256-x", bytes.NewReader([]byte("blob")), 4))
+	require.Contains(t, objects, "/bucket/models/blobs/sha256-x")
+
+	ok, err := s.Exists(ctx, "blobs/sha256-x")
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	r, err := s.Open(ctx, "blobs/sha256-x")
+	require.NoError(t, err)
+	bts, err := io.ReadAll(r)
+	r.Close()
+	require.NoError(t, err)
+	require.Equal(t, "blob", string(bts))
+
+	require.NoError(t, s.Delete(ctx, "blobs/sha256-x"))
+	ok, err = s.Exists(ctx, "blobs/sha256-x")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	// large objects are uploaded in parts
+	s.(*s3Store).partSize = 2
+	require.NoError(t, s.Put(ctx, "blobs/sha256-y", bytes.NewReader([]byte("large")), 5))
+	require.Len(t, parts, 3)
+	require.Equal(t, "large", string(objects["/bucket/models/blobs/sha256-y"]))
+
+	// buckets on AWS are addressed by host
+	aws, err := newS3Store(&url.URL{Scheme: "s3", Host: "bucket", Path: "/models"})
+	require.NoError(t, err)
+	require.Equal(t, "https://bucket.s3.us-east-1.amazonaws.com/models/blobs/sha256-x", aws.(*s3Store).url("blobs/sha256-x").String())
+}
+
+func TestRegisterStore(t *testing.T) {
+	_, err := OpenStore("mem://models")
+	require.Error(t, err)
+
+	RegisterStore("mem", func(u *url.URL) (Store, error) {
+		return &diskStore{root: t.TempDir()}, nil
+	})
+	defer delete(storeFuncs, "mem")
+
+	s, err := OpenStore("mem://models")
+	require.NoError(t, err)
+	require.IsType(t, &diskStore{}, s)
+}
@@ -0,0 +1,207 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/ollama/ollama/api"
+)
+
+// streamWriter writes chunks of a streaming response. Chunks are flushed to
+// the client immediately, or at most interval after they're written if
+// interval is set, so many concurrent streams make fewer, larger writes.
+type streamWriter struct {
+	w        http.ResponseWriter
+	rc       *http.ResponseController
+	interval time.Duration
+	timeout  time.Duration
+
+	timer *time.Timer
+	// flushC receives when buffered chunks are due to be flushed. It's nil
+	// while nothing is buffered.
+	flushC <-chan time.Time
+
+	// bucket paces the tokens streamed, or is nil if they aren't paced
+	bucket *tokenBucket
+}
+
+func newStreamWriter(w http.ResponseWriter, interval, timeout time.Duration) *streamWriter {
+	return &streamWriter{
+		w:        w,
+		rc:       http.NewResponseController(w),
+		interval: interval,
+		timeout:  timeout,
+	}
+}
+
+// deadline bounds the next write so a client that stops reading doesn't hold
+// the stream open
+func (sw *streamWriter) deadline() {
+	if sw.timeout <= 0 {
+		return
+	}
+
+	if err := sw.rc.SetWriteDeadline(time.Now().Add(sw.timeout)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		slog.Debug("failed to set write deadline", "error", err)
+	}
+}
+
+func (sw *streamWriter) write(b []byte) error {
+	sw.deadline()
+	if _, err := sw.w.Write(b); err != nil {
+		return err
+	}
+
+	if sw.interval <= 0 {
+		return sw.flush()
+	}
+
+	if sw.flushC == nil {
+		sw.timer = time.NewTimer(sw.interval)
+		sw.flushC = sw.timer.C
+	}
+
+	return nil
+}
+
+func (sw *streamWriter) flush() error {
+	sw.stop()
+	sw.deadline()
+	if err := sw.rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+
+	return nil
+}
+
+// stop cancels a pending flush
+func (sw *streamWriter) stop() {
+	if sw.timer != nil {
+		sw.timer.Stop()
+		sw.timer, sw.flushC = nil, nil
+	}
+}
+
+// pace waits until n more tokens can be streamed without exceeding the
+// stream's rate. Buffered chunks are flushed before waiting so the client
+// receives tokens as they're paced.
+func (sw *streamWriter) pace(ctx context.Context, n int) error {
+	if sw.bucket == nil || n <= 0 {
+		return nil
+	}
+
+	wait := sw.bucket.reserve(n, time.Now())
+	if wait <= 0 {
+		return nil
+	}
+
+	if sw.flushC != nil {
+		if err := sw.flush(); err != nil {
+			return err
+		}
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// bufferStream relays the chunks sent on ch without blocking the sender, so a
+// paced stream doesn't hold up the runner generating it, which would stall
+// the other requests it's generating for
+func bufferStream(ctx context.Context, ch chan any) chan any {
+	out := make(chan any)
+	go func() {
+		defer close(out)
+
+		var queue []any
+		for ch != nil || len(queue) > 0 {
+			var send chan any
+			var next any
+			if len(queue) > 0 {
+				send, next = out, queue[0]
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case val, ok := <-ch:
+				if !ok {
+					ch = nil
+					continue
+				}
+				queue = append(queue, val)
+			case send <- next:
+				queue = queue[1:]
+			}
+		}
+	}()
+
+	return out
+}
+
+// tokenBucket limits a stream to rate tokens per second on average, with
+// bursts of up to burst tokens
+type tokenBucket struct {
+	rate  float64
+	burst float64
+
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket returns a full bucket, or nil if rate isn't positive
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst)}
+}
+
+// reserve takes n tokens at now, and returns how long to wait until they
+// would have been in the bucket
+func (b *tokenBucket) reserve(n int, now time.Time) time.Duration {
+	if !b.last.IsZero() {
+		b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// streamedTokens is the number of tokens a chunk of a streaming response is
+// counted as when it's paced. Chunks of generated text count as one, as
+// they're usually a token each.
+func streamedTokens(chunk any) int {
+	switch c := chunk.(type) {
+	case api.GenerateResponse:
+		if c.Response != "" {
+			return 1
+		}
+	case api.ChatResponse:
+		if c.Message.Content != "" || c.Message.Thinking != "" || len(c.Message.ToolCalls) > 0 {
+			return 1
+		}
+	case api.Event:
+		if c.Content != "" || c.Thinking != "" {
+			return 1
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,184 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/http2"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestStreamWriter(t *testing.T) {
+	t.Run("no interval", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		sw := newStreamWriter(w, 0, 0)
+		if err := sw.write([]byte("a\n")); err != nil {
+			t.Fatal(err)
+		}
+
+		if !w.Flushed || sw.flushC != nil {
+			t.Errorf("expected the chunk to be flushed")
+		}
+	})
+
+	t.Run("interval", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		sw := newStreamWriter(w, time.Millisecond, 0)
+		for _, chunk := range []string{"a\n", "b\n"} {
+			if err := sw.write([]byte(chunk)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if w.Flushed || sw.flushC == nil {
+			t.Fatalf("expected the chunks to be buffered")
+		}
+
+		<-sw.flushC
+		if err := sw.flush(); err != nil {
+			t.Fatal(err)
+		}
+
+		if !w.Flushed || sw.flushC != nil || w.Body.String() != "a\nb\n" {
+			t.Errorf("expected the chunks to be flushed, got %q", w.Body.String())
+		}
+	})
+}
+
+func TestStreamResponse(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("OLLAMA_FLUSH_INTERVAL", "10ms")
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/chat", nil)
+
+	ch := make(chan any)
+	go func() {
+		defer close(ch)
+		ch <- gin.H{"n": 1}
+		ch <- gin.H{"n": 2}
+	}()
+
+	streamResponse(c, ch)
+
+	if got, want := w.Body.String(), "{\"n\":1}\n{\"n\":2}\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if !w.Flushed {
+		t.Error("expected the stream to be flushed")
+	}
+
+	if got := w.Header().Get("X-Accel-Buffering"); got != "no" {
+		t.Errorf("expected proxy buffering to be disabled, got %q", got)
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	if newTokenBucket(0, 1) != nil {
+		t.Fatal("expected no bucket without a rate")
+	}
+
+	start := time.Now()
+	b := newTokenBucket(10, 2)
+	for i, tt := range []struct {
+		at   time.Duration
+		n    int
+		wait time.Duration
+	}{
+		// the burst is taken immediately
+		{at: 0, n: 1},
+		{at: 0, n: 1},
+		// then tokens are paced to the rate
+		{at: 0, n: 1, wait: 100 * time.Millisecond},
+		{at: 100 * time.Millisecond, n: 1, wait: 100 * time.Millisecond},
+		// and the bucket refills up to the burst while the stream is idle
+		{at: time.Second, n: 2},
+		{at: time.Second, n: 1, wait: 100 * time.Millisecond},
+	} {
+		if wait := b.reserve(tt.n, start.Add(tt.at)); wait.Round(time.Millisecond) != tt.wait {
+			t.Errorf("%d: expected to wait %s, got %s", i, tt.wait, wait)
+		}
+	}
+}
+
+func TestStreamPacedResponse(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/generate", nil)
+
+	ch := make(chan any)
+	generated := make(chan time.Duration, 1)
+	start := time.Now()
+	go func() {
+		defer close(ch)
+		for range 5 {
+			ch <- api.GenerateResponse{Response: "a"}
+		}
+		ch <- api.GenerateResponse{Done: true}
+		generated <- time.Since(start)
+	}()
+
+	streamPacedResponse(c, ch, 100)
+
+	// generation isn't held up by the pace of the stream
+	if d := <-generated; d > 20*time.Millisecond {
+		t.Errorf("expected the chunks to be buffered, generation took %s", d)
+	}
+
+	// the first token is sent immediately and the rest 10ms apart, with
+	// the final chunk, which has no text, sent without waiting
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("expected the stream to be paced, took %s", elapsed)
+	}
+
+	if got := strings.Count(w.Body.String(), "\n"); got != 6 {
+		t.Errorf("expected 6 chunks, got %d", got)
+	}
+}
+
+func TestHTTP2(t *testing.T) {
+	t.Setenv("OLLAMA_HTTP2", "1")
+
+	srvr := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	})}
+	configureHTTP(srvr)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go srvr.Serve(ln)
+	defer srvr.Close()
+
+	// connect with HTTP/2 prior knowledge
+	client := http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	}}
+
+	resp, err := client.Get("http://" + ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.ProtoMajor != 2 {
+		t.Errorf("expected HTTP/2, got %s", resp.Proto)
+	}
+}
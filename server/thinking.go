@@ -0,0 +1,189 @@
+package server
+
+import (
+	"log/slog"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/ollama/ollama/llm"
+)
+
+// thinkingTags are the tags models that reason before they answer put their
+// reasoning in
+var thinkingTags = []string{"<think>", "<thinking>", "<reasoning>"}
+
+// thinkingTokens caches the thinking tag in the vocabulary of each model
+// file, or "" if it has none
+var thinkingTokens sync.Map
+
+// thinkingTag returns the tag m puts its reasoning in, found in its template
+// or vocabulary, or "" if m doesn't reason in tags
+func (m *Model) thinkingTag() string {
+	template := m.Template.String()
+	for _, tag := range thinkingTags {
+		if strings.Contains(template, tag) {
+			return tag
+		}
+	}
+
+	if m.Config.Remote != nil || m.ModelPath == "" {
+		return ""
+	}
+
+	if tag, ok := thinkingTokens.Load(m.ModelPath); ok {
+		return tag.(string)
+	}
+
+	// the whole vocabulary is read to look for thinking tags
+	ggml, err := llm.LoadModel(m.ModelPath, -1)
+	if err != nil {
+		slog.Debug("couldn't read model metadata", "model", m.ModelPath, "error", err)
+		return ""
+	}
+
+	var tag string
+	for _, t := range thinkingTags {
+		if ggml.KV().HasToken(t) {
+			tag = t
+			break
+		}
+	}
+
+	thinkingTokens.Store(m.ModelPath, tag)
+	return tag
+}
+
+// closingTag returns the tag that closes an opening tag such as <think>
+func closingTag(tag string) string {
+	return "</" + tag[1:]
+}
+
+// withoutThinking returns prompt with an empty reasoning section for the
+// model to continue from, so it answers without reasoning first. Templates
+// of some models open the section themselves.
+func withoutThinking(prompt, tag string) string {
+	trimmed := strings.TrimRightFunc(prompt, unicode.IsSpace)
+	if strings.HasSuffix(trimmed, tag) {
+		return trimmed + "\n\n" + closingTag(tag) + "\n\n"
+	}
+
+	return prompt + tag + "\n\n" + closingTag(tag) + "\n\n"
+}
+
+type thinkingState int
+
+const (
+	// thinkingStart is before the response has started
+	thinkingStart thinkingState = iota
+	// thinkingIn is within the reasoning section
+	thinkingIn
+	// thinkingDone is after the reasoning section, or if the response
+	// doesn't start with one
+	thinkingDone
+)
+
+// thinkingParser separates the reasoning of a streamed response, in thinking
+// tags at its start, from its content. Text that could be the start of a tag
+// is held back until it's known whether it is.
+type thinkingParser struct {
+	open, close string
+	state       thinkingState
+	buf         string
+
+	// trim is set while whitespace at the start of the reasoning or
+	// content is removed
+	trim bool
+}
+
+// newThinkingParser returns a parser of the responses of a model that reasons
+// in tag. Responses start within the reasoning section if the prompt opens
+// it.
+func newThinkingParser(prompt, tag string) *thinkingParser {
+	p := &thinkingParser{open: tag, close: closingTag(tag), trim: true}
+	if strings.HasSuffix(strings.TrimRightFunc(prompt, unicode.IsSpace), tag) {
+		p.state = thinkingIn
+	}
+
+	return p
+}
+
+// Write returns the reasoning and content in s, along with any text held
+// back from earlier writes
+func (p *thinkingParser) Write(s string) (thinking, content string) {
+	p.buf += s
+	for {
+		switch p.state {
+		case thinkingStart:
+			trimmed := strings.TrimLeftFunc(p.buf, unicode.IsSpace)
+			switch {
+			case strings.HasPrefix(trimmed, p.open):
+				p.state, p.buf = thinkingIn, trimmed[len(p.open):]
+				continue
+			case strings.HasPrefix(p.open, trimmed):
+				return "", ""
+			}
+
+			p.state, p.trim = thinkingDone, false
+		case thinkingIn:
+			if p.trim {
+				p.buf = strings.TrimLeftFunc(p.buf, unicode.IsSpace)
+				p.trim = p.buf == ""
+			}
+
+			if i := strings.Index(p.buf, p.close); i >= 0 {
+				thinking = p.buf[:i]
+				p.state, p.buf, p.trim = thinkingDone, p.buf[i+len(p.close):], true
+				_, content = p.Write("")
+				return strings.TrimRightFunc(thinking, unicode.IsSpace), content
+			}
+
+			// hold back what could be the start of the closing tag, and
+			// whitespace that could be before it
+			n := len(p.buf)
+			for i := range p.buf {
+				if strings.HasPrefix(p.close, p.buf[i:]) {
+					n = i
+					break
+				}
+			}
+			n = len(strings.TrimRightFunc(p.buf[:n], unicode.IsSpace))
+
+			thinking, p.buf = p.buf[:n], p.buf[n:]
+			return thinking, ""
+		case thinkingDone:
+			if p.trim {
+				p.buf = strings.TrimLeftFunc(p.buf, unicode.IsSpace)
+				p.trim = p.buf == ""
+			}
+
+			content, p.buf = p.buf, ""
+			return "", content
+		}
+	}
+}
+
+// Flush returns the text held back by the parser, reasoning if the response
+// ended without closing its reasoning section
+func (p *thinkingParser) Flush() (thinking, content string) {
+	s := p.buf
+	p.buf = ""
+	if p.state == thinkingIn {
+		return strings.TrimRightFunc(s, unicode.IsSpace), ""
+	}
+
+	return "", s
+}
+
+// Thinking reports whether the parser is within the reasoning section
+func (p *thinkingParser) Thinking() bool {
+	return p.state == thinkingIn
+}
+
+// cutOff ends the reasoning section of a response generated from prompt, so
+// what's written after is content, and returns the prompt to generate the
+// rest of the response from
+func (p *thinkingParser) cutOff(prompt, generated string) string {
+	p.state, p.buf, p.trim = thinkingDone, "", true
+	return prompt + strings.TrimRightFunc(generated, unicode.IsSpace) + "\n" + p.close + "\n\n"
+}
@@ -0,0 +1,262 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+)
+
+func TestThinkingParser(t *testing.T) {
+	cases := []struct {
+		name     string
+		prompt   string
+		chunks   []string
+		thinking string
+		content  string
+	}{
+		{
+			name:     "tags",
+			chunks:   []string{"<think>", "\nLet me", " think.\n", "</think>", "\n\nThe answer", " is 4."},
+			thinking: "Let me think.",
+			content:  "The answer is 4.",
+		},
+		{
+			name:     "split tags",
+			chunks:   []string{" <th", "ink>a", " b </th", "ink", ">\n", "c"},
+			thinking: "a b",
+			content:  "c",
+		},
+		{
+			name:     "opened by prompt",
+			prompt:   "<|im_start|>assistant\n<think>\n",
+			chunks:   []string{"reasoning", "</think>answer"},
+			thinking: "reasoning",
+			content:  "answer",
+		},
+		{
+			name:    "no reasoning",
+			chunks:  []string{"<", "b>bold</b> and </think>"},
+			content: "<b>bold</b> and </think>",
+		},
+		{
+			name:     "unclosed",
+			chunks:   []string{"<think>still", " thinking\n"},
+			thinking: "still thinking",
+		},
+		{
+			name:     "closing tag text",
+			chunks:   []string{"<think>a </ b", "</think>c"},
+			thinking: "a </ b",
+			content:  "c",
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newThinkingParser(tt.prompt, "<think>")
+
+			var thinking, content strings.Builder
+			for _, chunk := range tt.chunks {
+				th, c := p.Write(chunk)
+				thinking.WriteString(th)
+				content.WriteString(c)
+			}
+
+			th, c := p.Flush()
+			thinking.WriteString(th)
+			content.WriteString(c)
+
+			if thinking.String() != tt.thinking {
+				t.Errorf("expected thinking %q, got %q", tt.thinking, thinking.String())
+			}
+
+			if content.String() != tt.content {
+				t.Errorf("expected content %q, got %q", tt.content, content.String())
+			}
+		})
+	}
+}
+
+func TestWithoutThinking(t *testing.T) {
+	if got, want := withoutThinking("assistant\n", "<think>"), "assistant\n<think>\n\n</think>\n\n"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+
+	if got, want := withoutThinking("assistant\n<think>\n", "<think>"), "assistant\n<think>\n\n</think>\n\n"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestChatThinking(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var prompts []string
+	var responses []string
+	var numPredict []int
+	mock := mockRunner{
+		CompletionFn: func(ctx context.Context, r llm.CompletionRequest, fn func(llm.CompletionResponse)) error {
+			prompts = append(prompts, r.Prompt)
+			numPredict = append(numPredict, r.Options.NumPredict)
+			for i, s := range responses {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				fn(llm.CompletionResponse{Content: s, Done: i == len(responses)-1, DoneReason: "stop"})
+			}
+			return nil
+		},
+	}
+
+	s := Server{sched: newMockScheduler(t, &mock)}
+	createMockModel(t, &s, "plain", `{{- range .Messages }}{{ .Content }}{{ end }}`)
+
+	_, digest := createBinFile(t, llm.KV{
+		"general.architecture":      "qwen2",
+		"qwen2.block_count":         uint32(1),
+		"qwen2.context_length":      uint32(4096),
+		"tokenizer.ggml.tokens":     []string{"a", "<think>", "</think>"},
+		"tokenizer.ggml.scores":     []float32{0, 0, 0},
+		"tokenizer.ggml.token_type": []int32{1, 4, 4},
+	}, []llm.Tensor{
+		{Name: "token_embd.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+	})
+
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:    "reasoner",
+		Files:    map[string]string{"file.gguf": digest},
+		Template: `{{- range .Messages }}{{ .Content }}{{ end }}|`,
+		Stream:   &stream,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+	}
+
+	t.Run("separated", func(t *testing.T) {
+		prompts, responses = nil, []string{"<think>", "2 + 2", " is 4", "</think>", "\n\n4"}
+		w := createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model:    "reasoner",
+			Messages: []api.Message{{Role: "user", Content: "2 + 2?"}},
+			Stream:   &stream,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+
+		var resp api.ChatResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.Message.Thinking != "2 + 2 is 4" || resp.Message.Content != "4" {
+			t.Errorf("unexpected message %+v", resp.Message)
+		}
+	})
+
+	t.Run("streamed", func(t *testing.T) {
+		prompts, responses = nil, []string{"<think>", "hmm", "</think>", "4"}
+		streamed := true
+		w := createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model:    "reasoner",
+			Messages: []api.Message{{Role: "user", Content: "2 + 2?"}},
+			Stream:   &streamed,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+
+		var thinking, content []string
+		d := json.NewDecoder(w.Body)
+		for {
+			var resp api.ChatResponse
+			if err := d.Decode(&resp); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if resp.Message.Thinking != "" {
+				thinking = append(thinking, resp.Message.Thinking)
+			}
+			if resp.Message.Content != "" {
+				content = append(content, resp.Message.Content)
+			}
+		}
+
+		if strings.Join(thinking, "|") != "hmm" || strings.Join(content, "|") != "4" {
+			t.Errorf("unexpected thinking %q and content %q", thinking, content)
+		}
+	})
+
+	t.Run("suppressed", func(t *testing.T) {
+		prompts, responses = nil, []string{"4"}
+		w := createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model:    "reasoner",
+			Messages: []api.Message{{Role: "user", Content: "2 + 2?"}},
+			Think:    new(bool),
+			Stream:   &stream,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+
+		if want := "2 + 2?|<think>\n\n</think>\n\n"; len(prompts) != 1 || prompts[0] != want {
+			t.Errorf("expected prompt %q, got %q", want, prompts)
+		}
+	})
+
+	t.Run("budget", func(t *testing.T) {
+		prompts, responses, numPredict = nil, []string{"<think>", "one", " two", " three", "</think>", "4"}, nil
+		w := createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model:    "reasoner",
+			Messages: []api.Message{{Role: "user", Content: "2 + 2?"}},
+			Options:  map[string]any{"think_budget": 2, "num_predict": 10},
+			Stream:   &stream,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+
+		var resp api.ChatResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if want := "2 + 2?|<think>one two\n</think>\n\n"; len(prompts) != 2 || prompts[1] != want {
+			t.Errorf("expected the response to continue from %q, got %q", want, prompts)
+		}
+
+		// the 3 tokens generated before the cut off count towards num_predict
+		if want := []int{10, 7}; !slices.Equal(numPredict, want) {
+			t.Errorf("expected num_predict %v, got %v", want, numPredict)
+		}
+
+		// the mock generates the whole response again from the cut off
+		// reasoning, which is all content
+		if resp.Message.Thinking != "one two" {
+			t.Errorf("expected thinking %q, got %q", "one two", resp.Message.Thinking)
+		}
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		think := true
+		w := createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model:    "plain",
+			Messages: []api.Message{{Role: "user", Content: "2 + 2?"}},
+			Think:    &think,
+			Stream:   &stream,
+		})
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "does not support thinking") {
+			t.Errorf("expected status 400, got %d: %s", w.Code, w.Body)
+		}
+	})
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/types/model"
+)
+
+// TokenizeHandler returns the tokens of a text with the model's tokenizer so
+// clients can budget the context window themselves
+func (s *Server) TokenizeHandler(c *gin.Context) {
+	var req api.TokenizeRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	name, err := getExistingName(model.ParseName(req.Model))
+	if err != nil {
+		c.JSON(http.StatusNotFound, errorBody(api.ErrorCodeModelNotFound, fmt.Sprintf("model '%s' not found", req.Model)))
+		return
+	}
+
+	r, _, _, err := s.scheduleRunner(c.Request.Context(), name.String(), []Capability{}, req.Options, req.KeepAlive)
+	if err != nil {
+		handleScheduleError(c, req.Model, err)
+		return
+	}
+
+	tokens, err := r.Tokenize(c.Request.Context(), req.Text)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if tokens == nil {
+		tokens = []int{}
+	}
+
+	c.JSON(http.StatusOK, api.TokenizeResponse{Model: req.Model, Tokens: tokens, Count: len(tokens)})
+}
+
+// DetokenizeHandler returns the text of tokens with the model's tokenizer
+func (s *Server) DetokenizeHandler(c *gin.Context) {
+	var req api.DetokenizeRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	name, err := getExistingName(model.ParseName(req.Model))
+	if err != nil {
+		c.JSON(http.StatusNotFound, errorBody(api.ErrorCodeModelNotFound, fmt.Sprintf("model '%s' not found", req.Model)))
+		return
+	}
+
+	r, _, _, err := s.scheduleRunner(c.Request.Context(), name.String(), []Capability{}, req.Options, req.KeepAlive)
+	if err != nil {
+		handleScheduleError(c, req.Model, err)
+		return
+	}
+
+	text, err := r.Detokenize(c.Request.Context(), req.Tokens)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, api.DetokenizeResponse{Model: req.Model, Text: text})
+}
+
+// TokenizerCheckHandler round trips texts through the model's tokenizer and
+// reports the ones that change, which points to a tokenizer that was converted
+// incorrectly, e.g. with missing merges or the wrong pre-tokenizer
+func (s *Server) TokenizerCheckHandler(c *gin.Context) {
+	var req api.TokenizerCheckRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if len(req.Input) == 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "input is required"})
+		return
+	}
+
+	name, err := getExistingName(model.ParseName(req.Model))
+	if err != nil {
+		c.JSON(http.StatusNotFound, errorBody(api.ErrorCodeModelNotFound, fmt.Sprintf("model '%s' not found", req.Model)))
+		return
+	}
+
+	r, _, _, err := s.scheduleRunner(c.Request.Context(), name.String(), []Capability{}, req.Options, req.KeepAlive)
+	if err != nil {
+		handleScheduleError(c, req.Model, err)
+		return
+	}
+
+	resp, err := checkTokenizer(c.Request.Context(), r.Tokenize, r.Detokenize, req.Input)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp.Model = req.Model
+	c.JSON(http.StatusOK, resp)
+}
+
+// checkTokenizer tokenizes and detokenizes each of inputs, collecting the
+// ones that don't come back the same
+func checkTokenizer(ctx context.Context, tokenize tokenizeFunc, detokenize detokenizeFunc, inputs []string) (api.TokenizerCheckResponse, error) {
+	resp := api.TokenizerCheckResponse{Mismatches: []api.TokenizerMismatch{}}
+	for i, input := range inputs {
+		tokens, err := tokenize(ctx, input)
+		if err != nil {
+			return api.TokenizerCheckResponse{}, err
+		}
+
+		output, err := detokenize(ctx, tokens)
+		if err != nil {
+			return api.TokenizerCheckResponse{}, err
+		}
+
+		resp.Tokens += len(tokens)
+		if output != input {
+			resp.Mismatches = append(resp.Mismatches, api.TokenizerMismatch{
+				Index:  i,
+				Offset: mismatchOffset(input, output),
+				Input:  input,
+				Output: output,
+				Tokens: tokens,
+			})
+		}
+	}
+
+	return resp, nil
+}
+
+// mismatchOffset returns the byte offset of the first difference of a and b
+func mismatchOffset(a, b string) int {
+	n := min(len(a), len(b))
+	for i := range n {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+
+	return n
+}
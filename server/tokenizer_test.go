@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestCheckTokenizer(t *testing.T) {
+	// lossy drops bytes that aren't ASCII, like a tokenizer without byte fallback
+	lossy := func(_ context.Context, s string) ([]int, error) {
+		return bytesTokens(strings.Map(func(r rune) rune {
+			if r > 0x7f {
+				return -1
+			}
+			return r
+		}, s)), nil
+	}
+
+	t.Run("round trip", func(t *testing.T) {
+		tokenize := func(_ context.Context, s string) ([]int, error) { return bytesTokens(s), nil }
+		got, err := checkTokenizer(context.Background(), tokenize, bytesDetokenize, []string{"hello", "héllo 世界"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := api.TokenizerCheckResponse{Tokens: 18, Mismatches: []api.TokenizerMismatch{}}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("mismatch", func(t *testing.T) {
+		got, err := checkTokenizer(context.Background(), lossy, bytesDetokenize, []string{"hello", "héllo", "hi 世"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := api.TokenizerCheckResponse{
+			Tokens: 12,
+			Mismatches: []api.TokenizerMismatch{
+				{Index: 1, Offset: 1, Input: "héllo", Output: "hllo", Tokens: bytesTokens("hllo")},
+				{Index: 2, Offset: 3, Input: "hi 世", Output: "hi ", Tokens: bytesTokens("hi ")},
+			},
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+	})
+}
+
+func TestTokenizerCheckHandlerValidation(t *testing.T) {
+	var s Server
+	w := createRequest(t, s.TokenizerCheckHandler, api.TokenizerCheckRequest{Model: "test"})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", w.Code)
+	}
+
+	if diff := cmp.Diff(`{"error":"input is required"}`, w.Body.String()); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestTokenizeHandlers(t *testing.T) {
+	var s Server
+	cases := []struct {
+		name    string
+		handler func(*gin.Context)
+		body    any
+	}{
+		{"tokenize", s.TokenizeHandler, api.TokenizeRequest{Model: "missing", Text: "hello"}},
+		{"detokenize", s.DetokenizeHandler, api.DetokenizeRequest{Model: "missing", Tokens: []int{1, 2}}},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			w := createRequest(t, tt.handler, tt.body)
+			if w.Code != http.StatusNotFound {
+				t.Errorf("expected status 404, got %d: %s", w.Code, w.Body.String())
+			}
+
+			w = createRequest(t, tt.handler, "text")
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("expected status 400, got %d: %s", w.Code, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package server
+
+import (
+	"strings"
+
+	"github.com/ollama/ollama/api"
+)
+
+// withToolPrompt returns msgs with the model's instructions for using tools
+// prepended to the system message, for chats with tools
+func withToolPrompt(msgs []api.Message, m *Model, tools api.Tools) ([]api.Message, error) {
+	if m.ToolPrompt == nil || len(tools) == 0 {
+		return msgs, nil
+	}
+
+	var sb strings.Builder
+	if err := m.ToolPrompt.ExecuteTools(&sb, tools); err != nil {
+		return nil, err
+	}
+
+	prompt := strings.TrimSpace(sb.String())
+	if prompt == "" {
+		return msgs, nil
+	}
+
+	if len(msgs) > 0 && msgs[0].Role == "system" {
+		return append([]api.Message{{Role: "system", Content: prompt + "\n\n" + msgs[0].Content}}, msgs[1:]...), nil
+	}
+
+	return append([]api.Message{{Role: "system", Content: prompt}}, msgs...), nil
+}
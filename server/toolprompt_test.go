@@ -0,0 +1,108 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+)
+
+func TestChatToolPrompt(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var prompt string
+	mock := mockRunner{CompletionFn: func(_ context.Context, r llm.CompletionRequest, fn func(llm.CompletionResponse)) error {
+		prompt = r.Prompt
+		fn(llm.CompletionResponse{Content: "hi", Done: true, DoneReason: "stop"})
+		return nil
+	}}
+
+	s := Server{sched: newMockScheduler(t, &mock)}
+	createMockModel(t, &s, "base", "{{ range .Messages }}{{ .Role }}: {{ .Content }}\n{{ end }}{{ if .Tools }}{{ end }}")
+
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:      "tools",
+		From:       "base",
+		System:     "You are a bot.",
+		ToolPrompt: "Call a tool to answer. The tools are:{{ range .Tools }} {{ .Function.Name }}{{ end }}",
+		Stream:     &stream,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+	}
+
+	tools := []api.Tool{
+		{Type: "function", Function: api.ToolFunction{Name: "get_weather"}},
+		{Type: "function", Function: api.ToolFunction{Name: "get_time"}},
+	}
+
+	cases := []struct {
+		name     string
+		messages []api.Message
+		tools    []api.Tool
+		want     string
+	}{
+		{
+			name:     "tools",
+			messages: []api.Message{{Role: "user", Content: "Hello"}},
+			tools:    tools,
+			want:     "system: Call a tool to answer. The tools are: get_weather get_time\n\nYou are a bot.\nuser: Hello\n",
+		},
+		{
+			name:     "system message",
+			messages: []api.Message{{Role: "system", Content: "Be brief."}, {Role: "user", Content: "Hello"}},
+			tools:    tools,
+			want:     "system: Call a tool to answer. The tools are: get_weather get_time\n\nBe brief.\nuser: Hello\n",
+		},
+		{
+			name:     "no tools",
+			messages: []api.Message{{Role: "user", Content: "Hello"}},
+			want:     "system: You are a bot.\nuser: Hello\n",
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			w := createRequest(t, s.ChatHandler, api.ChatRequest{
+				Model:    "tools",
+				Messages: tt.messages,
+				Tools:    tt.tools,
+				Stream:   &stream,
+			})
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+			}
+
+			if prompt != tt.want {
+				t.Errorf("expected prompt %q, got %q", tt.want, prompt)
+			}
+		})
+	}
+
+	t.Run("show", func(t *testing.T) {
+		resp, err := GetModelInfo(api.ShowRequest{Model: "tools"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.ToolPrompt == "" {
+			t.Error("expected the tool prompt")
+		}
+	})
+
+	t.Run("bad template", func(t *testing.T) {
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:      "bad",
+			From:       "base",
+			ToolPrompt: "{{ range .Tools }}",
+			Stream:     &stream,
+		})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d: %s", w.Code, w.Body)
+		}
+	})
+}
@@ -0,0 +1,180 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ollama/ollama/api"
+)
+
+// ways of validating the tool calls in chat responses
+const (
+	// toolValidationError reports the tool calls that don't match their tools
+	toolValidationError = "error"
+	// toolValidationRetry generates the response again constrained to valid
+	// tool calls, then reports the calls that still don't match
+	toolValidationRetry = "retry"
+)
+
+func checkToolValidation(validation string) error {
+	switch validation {
+	case "", toolValidationError, toolValidationRetry:
+		return nil
+	default:
+		return fmt.Errorf("invalid tool_validation %q, expected %q or %q", validation, toolValidationError, toolValidationRetry)
+	}
+}
+
+// validateToolCalls returns an error for each of calls that doesn't call one
+// of tools with arguments matching its parameters
+func validateToolCalls(tools []api.Tool, calls []api.ToolCall) []api.ToolCallError {
+	var errs []api.ToolCallError
+	for i, call := range calls {
+		var callErrs []string
+		if j := slices.IndexFunc(tools, func(t api.Tool) bool { return t.Function.Name == call.Function.Name }); j < 0 {
+			callErrs = []string{fmt.Sprintf("unknown tool %q", call.Function.Name)}
+		} else {
+			p := tools[j].Function.Parameters
+			params := api.ToolProperty{Type: api.PropertyType{"object"}, Properties: p.Properties, Required: p.Required}
+			callErrs = validateArgument(params, "arguments", map[string]any(call.Function.Arguments), nil)
+		}
+
+		if len(callErrs) > 0 {
+			errs = append(errs, api.ToolCallError{Index: i, Name: call.Function.Name, Errors: callErrs})
+		}
+	}
+
+	return errs
+}
+
+// validateArgument appends an error for each value in v at path that doesn't
+// match p
+func validateArgument(p api.ToolProperty, path string, v any, errs []string) []string {
+	if got := jsonType(v); len(p.Type) > 0 && !slices.Contains(p.Type, got) && (got != "integer" || !slices.Contains(p.Type, "number")) {
+		return append(errs, fmt.Sprintf("%s: expected %s, got %s", path, strings.Join(p.Type, " or "), got))
+	}
+
+	if len(p.Enum) > 0 && !slices.ContainsFunc(p.Enum, func(e any) bool { return reflect.DeepEqual(e, v) }) {
+		errs = append(errs, fmt.Sprintf("%s: %s isn't one of %s", path, jsonString(v), jsonString(p.Enum)))
+	}
+
+	if p.Const != nil && !reflect.DeepEqual(p.Const, v) {
+		errs = append(errs, fmt.Sprintf("%s: expected %s, got %s", path, jsonString(p.Const), jsonString(v)))
+	}
+
+	switch v := v.(type) {
+	case map[string]any:
+		for _, k := range p.Required {
+			if _, ok := v[k]; !ok {
+				errs = append(errs, fmt.Sprintf("%s: missing required %q", path, k))
+			}
+		}
+
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+
+		for _, k := range keys {
+			if prop, ok := p.Properties[k]; ok {
+				errs = validateArgument(prop, joinPath(path, k), v[k], errs)
+			} else if p.AdditionalProperties != nil && !*p.AdditionalProperties {
+				errs = append(errs, fmt.Sprintf("%s: unexpected %q", path, k))
+			}
+		}
+	case []any:
+		if p.MinItems != nil && len(v) < *p.MinItems {
+			errs = append(errs, fmt.Sprintf("%s: expected at least %d items, got %d", path, *p.MinItems, len(v)))
+		}
+
+		if p.MaxItems != nil && len(v) > *p.MaxItems {
+			errs = append(errs, fmt.Sprintf("%s: expected at most %d items, got %d", path, *p.MaxItems, len(v)))
+		}
+
+		for i, item := range v {
+			itemPath := fmt.Sprintf("%s[%d]", path, i)
+			switch {
+			case i < len(p.PrefixItems):
+				errs = validateArgument(p.PrefixItems[i], itemPath, item, errs)
+			case p.Items != nil:
+				errs = validateArgument(*p.Items, itemPath, item, errs)
+			}
+		}
+	case string:
+		n := utf8.RuneCountInString(v)
+		if p.MinLength != nil && n < *p.MinLength {
+			errs = append(errs, fmt.Sprintf("%s: expected at least %d characters, got %d", path, *p.MinLength, n))
+		}
+
+		if p.MaxLength != nil && n > *p.MaxLength {
+			errs = append(errs, fmt.Sprintf("%s: expected at most %d characters, got %d", path, *p.MaxLength, n))
+		}
+
+		// patterns Go can't compile aren't checked
+		if re, err := regexp.Compile(p.Pattern); p.Pattern != "" && err == nil && !re.MatchString(v) {
+			errs = append(errs, fmt.Sprintf("%s: %q doesn't match pattern %q", path, v, p.Pattern))
+		}
+	case float64:
+		switch {
+		case p.Minimum != nil && v < *p.Minimum:
+			errs = append(errs, fmt.Sprintf("%s: %v is less than %v", path, v, *p.Minimum))
+		case p.Maximum != nil && v > *p.Maximum:
+			errs = append(errs, fmt.Sprintf("%s: %v is greater than %v", path, v, *p.Maximum))
+		case p.ExclusiveMinimum != nil && v <= *p.ExclusiveMinimum:
+			errs = append(errs, fmt.Sprintf("%s: %v isn't greater than %v", path, v, *p.ExclusiveMinimum))
+		case p.ExclusiveMaximum != nil && v >= *p.ExclusiveMaximum:
+			errs = append(errs, fmt.Sprintf("%s: %v isn't less than %v", path, v, *p.ExclusiveMaximum))
+		}
+	}
+
+	matches := func(p api.ToolProperty) bool { return len(validateArgument(p, path, v, nil)) == 0 }
+	if len(p.AnyOf) > 0 && !slices.ContainsFunc(p.AnyOf, matches) {
+		errs = append(errs, fmt.Sprintf("%s: doesn't match any of the allowed schemas", path))
+	}
+
+	if len(p.OneOf) > 0 {
+		var n int
+		for _, s := range p.OneOf {
+			if matches(s) {
+				n++
+			}
+		}
+
+		if n != 1 {
+			errs = append(errs, fmt.Sprintf("%s: matches %d of the schemas, expected exactly one", path, n))
+		}
+	}
+
+	for _, s := range p.AllOf {
+		errs = validateArgument(s, path, v, errs)
+	}
+
+	return errs
+}
+
+// jsonString returns v as JSON for error messages
+func jsonString(v any) string {
+	bts, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+
+	return string(bts)
+}
+
+// strictTools returns tools with their arguments constrained to their
+// parameters
+func strictTools(tools []api.Tool) []api.Tool {
+	strict := slices.Clone(tools)
+	for i := range strict {
+		strict[i].Function.Strict = true
+	}
+
+	return strict
+}
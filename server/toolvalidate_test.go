@@ -0,0 +1,91 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestValidateToolCalls(t *testing.T) {
+	var tools []api.Tool
+	if err := json.Unmarshal([]byte(`[{
+		"type": "function",
+		"function": {
+			"name": "book_flight",
+			"parameters": {
+				"type": "object",
+				"required": ["from", "to"],
+				"properties": {
+					"from": {"type": "string", "pattern": "^[A-Z]{3}$"},
+					"to": {"type": "string", "pattern": "^[A-Z]{3}$"},
+					"class": {"type": "string", "enum": ["economy", "business"]},
+					"passengers": {"type": "integer", "minimum": 1, "maximum": 9},
+					"dates": {"type": "array", "items": {"type": "string"}, "minItems": 1},
+					"seat": {"anyOf": [{"type": "string", "maxLength": 3}, {"type": "null"}]},
+					"extras": {"type": "object", "properties": {"bags": {"type": "integer"}}, "additionalProperties": false}
+				}
+			}
+		}
+	}]`), &tools); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name string
+		call string
+		want []string
+	}{
+		{
+			name: "valid",
+			call: `{"name":"book_flight","arguments":{"from":"SEA","to":"CDG","class":"economy","passengers":2,"dates":["2024-07-01"],"seat":null,"extras":{"bags":1}}}`,
+		},
+		{
+			name: "missing required",
+			call: `{"name":"book_flight","arguments":{"from":"SEA"}}`,
+			want: []string{`arguments: missing required "to"`},
+		},
+		{
+			name: "wrong types",
+			call: `{"name":"book_flight","arguments":{"from":"SEA","to":"CDG","passengers":1.5,"dates":"2024-07-01"}}`,
+			want: []string{"arguments.dates: expected array, got string", "arguments.passengers: expected integer, got number"},
+		},
+		{
+			name: "constraints",
+			call: `{"name":"book_flight","arguments":{"from":"Seattle","to":"CDG","class":"first","passengers":12,"dates":[],"seat":"12AB","extras":{"pets":1}}}`,
+			want: []string{
+				`arguments.class: "first" isn't one of ["economy","business"]`,
+				"arguments.dates: expected at least 1 items, got 0",
+				`arguments.extras: unexpected "pets"`,
+				`arguments.from: "Seattle" doesn't match pattern "^[A-Z]{3}$"`,
+				"arguments.passengers: 12 is greater than 9",
+				"arguments.seat: doesn't match any of the allowed schemas",
+			},
+		},
+		{
+			name: "unknown tool",
+			call: `{"name":"book_hotel","arguments":{}}`,
+			want: []string{`unknown tool "book_hotel"`},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			var call api.ToolCall
+			if err := json.Unmarshal([]byte(tt.call), &call.Function); err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			if errs := validateToolCalls(tools, []api.ToolCall{call}); len(errs) > 0 {
+				got = errs[0].Errors
+			}
+
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("errors mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
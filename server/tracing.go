@@ -0,0 +1,70 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/tracing"
+)
+
+// startTracing starts exporting traces if an OTLP endpoint is configured. It
+// returns a function that exports the spans left, which does nothing if
+// traces aren't exported.
+func startTracing() func() {
+	endpoint := envconfig.OtelEndpoint()
+	if endpoint == "" {
+		return func() {}
+	}
+
+	shutdown, err := tracing.StartExporter(endpoint, envconfig.OtelHeaders(), cmp.Or(envconfig.OtelServiceName(), "ollama"))
+	if err != nil {
+		slog.Warn("invalid OpenTelemetry configuration, traces won't be exported", "error", err)
+		return func() {}
+	}
+
+	slog.Info("exporting traces", "endpoint", endpoint)
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := shutdown(ctx); err != nil {
+			slog.Warn("couldn't export the remaining trace spans", "error", err)
+		}
+	}
+}
+
+// tracingMiddleware records a span of each request named by its method and
+// route, continuing the trace of its traceparent header. The trace is passed
+// on to runners even if spans aren't recorded.
+func tracingMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := c.FullPath()
+		if route == "" {
+			route = "other"
+		}
+
+		ctx := tracing.Extract(c.Request.Context(), c.Request.Header)
+		ctx, span := tracing.StartSpan(ctx, c.Request.Method+" "+route, tracing.KindServer,
+			tracing.String("http.request.method", c.Request.Method),
+			tracing.String("http.route", route),
+			tracing.String("url.path", c.Request.URL.Path),
+		)
+		defer span.End()
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(tracing.Int("http.response.status_code", status))
+		if status >= http.StatusInternalServerError {
+			span.SetError(errors.New(http.StatusText(status)))
+		}
+	}
+}
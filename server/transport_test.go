@@ -0,0 +1,203 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRegistryConfigsLookup(t *testing.T) {
+	configs := registryConfigs{
+		"registry.example.com:5000": {Proxy: "http://a"},
+		"registry.example.com":      {Proxy: "http://b"},
+		"*":                         {Proxy: "http://c"},
+	}
+
+	for host, want := range map[string]string{
+		"registry.example.com:5000": "registry.example.com:5000",
+		"registry.example.com:443":  "registry.example.com",
+		"registry.example.com":      "registry.example.com",
+		"registry.ollama.ai":        "*",
+	} {
+		if key, _, ok := configs.lookup(host); !ok || key != want {
+			t.Errorf("%s: expected %q, got %q", host, want, key)
+		}
+	}
+
+	if _, _, ok := (registryConfigs{}).lookup("registry.ollama.ai"); ok {
+		t.Error("expected no match")
+	}
+}
+
+func TestLoadRegistryConfigs(t *testing.T) {
+	if configs, err := loadRegistryConfigs(""); err != nil || configs != nil {
+		t.Errorf("expected no configs, got %v, %v", configs, err)
+	}
+
+	path := filepath.Join(t.TempDir(), "registries.json")
+	if err := os.WriteFile(path, []byte(`{"*": {"proxy": "socks5://proxy:1080", "ca_certs": ["ca.pem"]}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	configs, err := loadRegistryConfigs(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c := configs["*"]; c.Proxy != "socks5://proxy:1080" || len(c.CACerts) != 1 {
+		t.Errorf("unexpected config %+v", c)
+	}
+
+	if err := os.WriteFile(path, []byte(`{`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := loadRegistryConfigs(path); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestRegistryConfigTransport(t *testing.T) {
+	t.Run("invalid proxy", func(t *testing.T) {
+		if _, err := (registryConfig{Proxy: "ftp://proxy"}).transport(); err == nil {
+			t.Error("expected error")
+		}
+	})
+
+	t.Run("invalid ca", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "ca.pem")
+		if err := os.WriteFile(path, []byte("not a certificate"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := (registryConfig{CACerts: []string{path}}).transport(); err == nil {
+			t.Error("expected error")
+		}
+	})
+
+	t.Run("custom ca", func(t *testing.T) {
+		srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		defer srv.Close()
+
+		path := filepath.Join(t.TempDir(), "ca.pem")
+		if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := (&http.Client{}).Get(srv.URL); err == nil {
+			t.Fatal("expected untrusted certificate to fail")
+		}
+
+		tr, err := (registryConfig{CACerts: []string{path}}).transport()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := (&http.Client{Transport: tr}).Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	})
+
+	t.Run("proxy auth", func(t *testing.T) {
+		var auth string
+		proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			auth = r.Header.Get("Proxy-Authorization")
+			w.WriteHeader(http.StatusTeapot)
+		}))
+		defer proxy.Close()
+
+		u, err := url.Parse(proxy.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		u.User = url.UserPassword("user", "pass")
+
+		tr, err := (registryConfig{Proxy: u.String()}).transport()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := (&http.Client{Transport: tr}).Get("http://registry.example.com/v2/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusTeapot {
+			t.Errorf("expected request to go through proxy, got %s", resp.Status)
+		}
+
+		if want := "Basic " + base64.StdEncoding.EncodeToString([]byte("user:pass")); auth != want {
+			t.Errorf("expected proxy authorization %q, got %q", want, auth)
+		}
+	})
+}
+
+func TestRegistryTransportInvalidConfig(t *testing.T) {
+	configs := registryTransports.configs
+	t.Cleanup(func() { registryTransports.configs = configs })
+
+	registryTransports.configs = func() (registryConfigs, error) {
+		return registryConfigs{"bad.example.com": {Proxy: "ftp://proxy"}}, nil
+	}
+
+	if _, err := registryTransport("bad.example.com"); !errors.Is(err, errInvalidRegistryConfig) {
+		t.Errorf("expected %v, got %v", errInvalidRegistryConfig, err)
+	}
+
+	if tr, err := registryTransport("registry.ollama.ai"); err != nil || tr != nil {
+		t.Errorf("expected default transport, got %v, %v", tr, err)
+	}
+}
+
+func TestDownloadChunkRegistryTransport(t *testing.T) {
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Host != "cdn.example.com" {
+			http.Error(w, "unexpected host", http.StatusBadGateway)
+			return
+		}
+
+		w.Write([]byte("blob"))
+	}))
+	defer proxy.Close()
+
+	configs := registryTransports.configs
+	t.Cleanup(func() {
+		registryTransports.configs = configs
+		registryTransports.Lock()
+		delete(registryTransports.transports, "registry.example.com")
+		registryTransports.Unlock()
+	})
+
+	registryTransports.configs = func() (registryConfigs, error) {
+		return registryConfigs{"registry.example.com": {Proxy: proxy.URL}}, nil
+	}
+
+	// the blob is redirected to a host without a configuration, which is
+	// still requested through the registry's proxy
+	b := &blobDownload{Name: filepath.Join(t.TempDir(), "blob"), registry: "registry.example.com"}
+	part := &blobDownloadPart{Size: 4, blobDownload: b}
+	u, err := url.Parse("http://cdn.example.com/blob")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := b.downloadChunk(context.Background(), u, &buf, part); err != nil {
+		t.Fatal(err)
+	}
+
+	if buf.String() != "blob" {
+		t.Errorf("expected the blob from the proxy, got %q", buf.String())
+	}
+}
@@ -0,0 +1,231 @@
+package server
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/template"
+)
+
+// strategies for truncating chats that don't fit in the context window
+const (
+	// truncateDrop drops the oldest messages
+	truncateDrop = "drop"
+	// truncateMiddleOut drops the oldest messages but the first one
+	truncateMiddleOut = "middle-out"
+	// truncateSummarize replaces the oldest messages with a summary
+	truncateSummarize = "summarize"
+	// truncateError fails chats that don't fit rather than truncating them
+	truncateError = "error"
+)
+
+var errTruncated = errors.New("messages exceed the context length and truncation is \"error\"")
+
+// truncation is how chatPrompt fits a chat in the context window. The zero
+// value drops the oldest messages.
+type truncation struct {
+	strategy string
+
+	// summarize returns a summary of messages for truncateSummarize
+	summarize func(context.Context, []api.Message) (string, error)
+}
+
+func checkTruncation(strategy string) error {
+	switch strategy {
+	case "", truncateDrop, truncateMiddleOut, truncateSummarize, truncateError:
+		return nil
+	default:
+		return fmt.Errorf("invalid truncation %q, expected %q, %q, %q or %q", strategy, truncateDrop, truncateMiddleOut, truncateSummarize, truncateError)
+	}
+}
+
+const summarizePrompt = `Summarize the conversation below in a few sentences. Keep the facts, decisions, names and open questions needed to continue it. Reply with only the summary.`
+
+// summarizer returns a function that summarizes messages with the model
+// running in r. Summaries are limited to a quarter of the context window so
+// they leave room for the rest of the chat. Summaries are cached, so only
+// the messages after the longest prefix that was summarized before are
+// summarized, along with the summary of the prefix. Requests that mustn't be
+// stored neither use nor add to the cache.
+func summarizer(r llm.LlamaServer, m *Model, opts *api.Options) func(context.Context, []api.Message) (string, error) {
+	summarize := func(ctx context.Context, msgs []api.Message) (string, error) {
+		var transcript strings.Builder
+		for _, msg := range msgs {
+			if msg.Content != "" {
+				fmt.Fprintf(&transcript, "%s: %s\n\n", msg.Role, msg.Content)
+			}
+
+			for _, tc := range msg.ToolCalls {
+				fmt.Fprintf(&transcript, "%s: called %s with %s\n\n", msg.Role, tc.Function.Name, tc.Function.Arguments.String())
+			}
+		}
+
+		var b bytes.Buffer
+		if err := m.Template.Execute(&b, template.Values{Messages: []api.Message{
+			{Role: "system", Content: summarizePrompt},
+			{Role: "user", Content: transcript.String()},
+		}}); err != nil {
+			return "", err
+		}
+
+		summaryOpts := *opts
+		summaryOpts.NumPredict = opts.NumCtx / 4
+
+		var sb strings.Builder
+		if err := r.Completion(ctx, llm.CompletionRequest{Prompt: b.String(), Options: &summaryOpts}, func(cr llm.CompletionResponse) {
+			sb.WriteString(cr.Content)
+		}); err != nil {
+			return "", err
+		}
+
+		return strings.TrimSpace(sb.String()), nil
+	}
+
+	return func(ctx context.Context, msgs []api.Message) (string, error) {
+		if noStore(ctx) {
+			return summarize(ctx, msgs)
+		}
+
+		keys, err := promptPrefixKeys(m, nil, msgs)
+		if err != nil {
+			return "", err
+		}
+
+		summary, n := summaries.prefix(keys)
+		if n == len(msgs) {
+			return summary, nil
+		}
+
+		fold := msgs[n:]
+		if summary != "" {
+			fold = append([]api.Message{summaryMessage(summary)}, fold...)
+		}
+
+		summary, err = summarize(ctx, fold)
+		if err != nil {
+			return "", err
+		}
+
+		summaries.put(m.ModelPath, keys[len(keys)-1], summary)
+		return summary, nil
+	}
+}
+
+// summaryCacheSize is the number of summaries the summary cache keeps
+const summaryCacheSize = 256
+
+// summaries caches the summaries of messages truncated from chats
+var summaries = newSummaryCache(summaryCacheSize)
+
+type summaryCacheEntry struct {
+	key     [sha256.Size]byte
+	summary string
+
+	// model is the path of the model that summarized the messages
+	model string
+	// used is when the entry was last added or reused
+	used time.Time
+}
+
+// summaryCache is an LRU cache of summaries keyed like the prompt cache, by
+// the model, template and messages that were summarized. Chats resend every
+// earlier message with each turn, so the messages truncated from a turn
+// usually start with those truncated from the turn before it.
+type summaryCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List
+	entries map[[sha256.Size]byte]*list.Element
+}
+
+func newSummaryCache(size int) *summaryCache {
+	return &summaryCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[[sha256.Size]byte]*list.Element),
+	}
+}
+
+// prefix returns the summary of the longest prefix of the messages keys are
+// the prefix keys of, and the number of messages in the prefix
+func (c *summaryCache) prefix(keys [][sha256.Size]byte) (string, int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i := len(keys) - 1; i >= 0; i-- {
+		if el, ok := c.entries[keys[i]]; ok {
+			e := el.Value.(summaryCacheEntry)
+			e.used = time.Now()
+			el.Value = e
+			c.order.MoveToFront(el)
+			return e.summary, i + 1
+		}
+	}
+
+	return "", 0
+}
+
+func (c *summaryCache) put(model string, key [sha256.Size]byte, summary string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e := summaryCacheEntry{key: key, summary: summary, model: model, used: time.Now()}
+	if el, ok := c.entries[key]; ok {
+		el.Value = e
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(e)
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *summaryCache) remove(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.entries, el.Value.(summaryCacheEntry).key)
+}
+
+// expire removes the summaries that haven't been used within ttl, returning
+// the number removed
+func (c *summaryCache) expire(ttl time.Duration) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var expired int
+	for el := c.order.Back(); el != nil && time.Since(el.Value.(summaryCacheEntry).used) > ttl; el = c.order.Back() {
+		c.remove(el)
+		expired++
+	}
+
+	return expired
+}
+
+// purge removes the summaries of the model at path, or every summary if path
+// is empty, returning the number removed
+func (c *summaryCache) purge(path string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var purged int
+	for el := c.order.Front(); el != nil; {
+		next := el.Next()
+		if path == "" || el.Value.(summaryCacheEntry).model == path {
+			c.remove(el)
+			purged++
+		}
+		el = next
+	}
+
+	return purged
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/template"
+)
+
+func TestSummarizer(t *testing.T) {
+	tmpl, err := template.Parse(`{{- range .Messages }}{{ .Role }}: {{ .Content }}
+{{ end }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	summaries.purge("")
+	t.Cleanup(func() { summaries.purge("") })
+
+	var req llm.CompletionRequest
+	var calls int
+	r := mockRunner{CompletionFn: func(_ context.Context, r llm.CompletionRequest, fn func(llm.CompletionResponse)) error {
+		req = r
+		calls++
+		fn(llm.CompletionResponse{Content: " The user asked for "})
+		fn(llm.CompletionResponse{Content: "the weather. ", Done: true})
+		return nil
+	}}
+
+	opts := api.Options{Runner: api.Runner{NumCtx: 2048}, NumPredict: -1}
+	summarize := summarizer(&r, &Model{Template: tmpl}, &opts)
+	msgs := []api.Message{
+		{Role: "user", Content: "What's the weather in Paris?"},
+		{Role: "assistant", ToolCalls: []api.ToolCall{{Function: api.ToolCallFunction{Name: "get_weather", Arguments: api.ToolCallFunctionArguments{"city": "Paris"}}}}},
+	}
+	summary, err := summarize(context.Background(), msgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if summary != "The user asked for the weather." {
+		t.Errorf("unexpected summary %q", summary)
+	}
+
+	for _, s := range []string{"system: " + summarizePrompt, "user: What's the weather in Paris?", `assistant: called get_weather with {"city":"Paris"}`} {
+		if !strings.Contains(req.Prompt, s) {
+			t.Errorf("expected %q in prompt %q", s, req.Prompt)
+		}
+	}
+
+	if req.Options.NumPredict != 512 || opts.NumPredict != -1 {
+		t.Errorf("expected the summary to be limited to 512 tokens without changing the chat options, got %d and %d", req.Options.NumPredict, opts.NumPredict)
+	}
+
+	// summaries are cached, and later messages are folded into them
+	if summary, err := summarize(context.Background(), msgs); err != nil || summary != "The user asked for the weather." || calls != 1 {
+		t.Errorf("expected the cached summary, got %q, %v after %d calls", summary, err, calls)
+	}
+
+	more := append(msgs, api.Message{Role: "user", Content: "And in Rome?"})
+	if _, err := summarize(context.Background(), more); err != nil {
+		t.Fatal(err)
+	}
+
+	if calls != 2 || strings.Contains(req.Prompt, "Paris") || !strings.Contains(req.Prompt, "system: Summary of the earlier conversation:\nThe user asked for the weather.") || !strings.Contains(req.Prompt, "user: And in Rome?") {
+		t.Errorf("expected the new message to be folded into the summary, got %q", req.Prompt)
+	}
+
+	// requests that mustn't be stored neither reuse nor add to the cache
+	summaries.purge("")
+	secret := []api.Message{{Role: "user", Content: "My password is hunter2"}}
+	for range 2 {
+		if _, err := summarize(withNoStore(context.Background()), secret); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if calls != 4 {
+		t.Errorf("expected no_store summaries to be summarized every time, got %d calls", calls)
+	}
+
+	if keys, err := promptPrefixKeys(&Model{Template: tmpl}, nil, secret); err != nil {
+		t.Fatal(err)
+	} else if _, n := summaries.prefix(keys); n != 0 {
+		t.Error("expected no_store summaries not to be cached")
+	}
+}
+
+func TestCheckTruncation(t *testing.T) {
+	for _, s := range []string{"", "drop", "middle-out", "summarize", "error"} {
+		if err := checkTruncation(s); err != nil {
+			t.Errorf("%q: unexpected error %v", s, err)
+		}
+	}
+
+	if err := checkTruncation("oldest"); err == nil {
+		t.Error("expected an error")
+	}
+}
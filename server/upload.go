@@ -226,7 +226,7 @@ func (b *blobUpload) uploadPart(ctx context.Context, method string, requestURL *
 	md5sum := md5.New()
 	w := &progressWriter{blobUpload: b}
 
-	resp, err := makeRequest(ctx, method, requestURL, headers, io.TeeReader(sr, io.MultiWriter(w, md5sum)), opts)
+	resp, err := makeRequest(ctx, method, requestURL, headers, io.TeeReader(transferLimiter().Reader(ctx, sr), io.MultiWriter(w, md5sum)), opts)
 	if err != nil {
 		w.Rollback()
 		return err
@@ -0,0 +1,342 @@
+package server
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/ollama/ollama/api"
+)
+
+// uploadSessionTTL is how long an idle upload session is kept
+const uploadSessionTTL = 24 * time.Hour
+
+var (
+	errUploadNotFound     = errors.New("upload session not found")
+	errUploadIncomplete   = errors.New("upload session has incomplete blobs")
+	errUploadBlobNotFound = errors.New("blob is not part of this upload session")
+	errUploadOffset       = errors.New("upload offset does not match the received size")
+)
+
+// uploadSession holds blobs uploaded in chunks by a remote client before the
+// model referencing them is created. Received data is written next to the
+// final blob with a suffix unique to the session so an interrupted upload can
+// resume from the bytes already on disk without other sessions for the same
+// blob writing over it.
+type uploadSession struct {
+	id string
+
+	// expires is guarded by uploadSessions
+	expires time.Time
+
+	mu      sync.Mutex
+	blobs   []*api.UploadBlob
+	writing map[string]bool
+}
+
+var uploadSessions = struct {
+	sync.Mutex
+	m map[string]*uploadSession
+}{m: make(map[string]*uploadSession)}
+
+// partialPath returns where the session writes the data received for digest
+func (s *uploadSession) partialPath(digest string) (string, error) {
+	p, err := GetBlobsPath(digest)
+	if err != nil {
+		return "", err
+	}
+
+	return p + "-upload-" + s.id, nil
+}
+
+// discard removes the data the session received for blobs it didn't finish
+func (s *uploadSession) discard() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, b := range s.blobs {
+		if b.Offset == b.Size {
+			continue
+		}
+
+		if p, err := s.partialPath(b.Digest); err == nil {
+			if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+				slog.Warn("couldn't remove partial upload", "path", p, "error", err)
+			}
+		}
+	}
+}
+
+// newUploadSession creates a session for blobs, skipping any already on disk
+func newUploadSession(blobs []api.UploadBlob) (*uploadSession, error) {
+	if len(blobs) == 0 {
+		return nil, errors.New("no blobs provided")
+	}
+
+	session := &uploadSession{
+		id:      uuid.NewString(),
+		expires: time.Now().Add(uploadSessionTTL),
+		writing: make(map[string]bool),
+	}
+
+	for _, b := range blobs {
+		p, err := GetBlobsPath(b.Digest)
+		if err != nil || b.Digest == "" {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidDigestFormat, b.Digest)
+		}
+
+		if slices.ContainsFunc(session.blobs, func(o *api.UploadBlob) bool { return o.Digest == b.Digest }) {
+			continue
+		}
+
+		blob := &api.UploadBlob{Digest: b.Digest, Size: b.Size}
+		if fi, err := os.Stat(p); err == nil {
+			blob.Size, blob.Offset = fi.Size(), fi.Size()
+		} else if b.Size <= 0 {
+			return nil, fmt.Errorf("invalid size %d for blob %q", b.Size, b.Digest)
+		}
+
+		session.blobs = append(session.blobs, blob)
+	}
+
+	uploadSessions.Lock()
+	var expired []*uploadSession
+	now := time.Now()
+	for id, s := range uploadSessions.m {
+		if now.After(s.expires) {
+			delete(uploadSessions.m, id)
+			expired = append(expired, s)
+		}
+	}
+
+	uploadSessions.m[session.id] = session
+	uploadSessions.Unlock()
+
+	for _, s := range expired {
+		s.discard()
+	}
+
+	return session, nil
+}
+
+func getUploadSession(id string) (*uploadSession, error) {
+	uploadSessions.Lock()
+	s, ok := uploadSessions.m[id]
+	if !ok {
+		uploadSessions.Unlock()
+		return nil, errUploadNotFound
+	}
+
+	if time.Now().After(s.expires) {
+		delete(uploadSessions.m, id)
+		uploadSessions.Unlock()
+		s.discard()
+		return nil, errUploadNotFound
+	}
+
+	s.expires = time.Now().Add(uploadSessionTTL)
+	uploadSessions.Unlock()
+	return s, nil
+}
+
+func deleteUploadSession(id string) {
+	uploadSessions.Lock()
+	defer uploadSessions.Unlock()
+	delete(uploadSessions.m, id)
+}
+
+func (s *uploadSession) response() api.UploadResponse {
+	uploadSessions.Lock()
+	resp := api.UploadResponse{ID: s.id, ExpiresAt: s.expires}
+	uploadSessions.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, b := range s.blobs {
+		resp.Blobs = append(resp.Blobs, *b)
+	}
+
+	return resp
+}
+
+// complete reports whether every blob in the session has been received
+func (s *uploadSession) complete() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return !slices.ContainsFunc(s.blobs, func(b *api.UploadBlob) bool { return b.Offset < b.Size })
+}
+
+// write appends r to the blob with digest at offset. The blob is verified and
+// moved into place once all of its bytes are received.
+func (s *uploadSession) write(digest string, offset int64, r io.Reader) (api.UploadBlob, error) {
+	s.mu.Lock()
+	i := slices.IndexFunc(s.blobs, func(b *api.UploadBlob) bool { return b.Digest == digest })
+	if i < 0 {
+		s.mu.Unlock()
+		return api.UploadBlob{}, fmt.Errorf("%w: %q", errUploadBlobNotFound, digest)
+	}
+
+	blob := s.blobs[i]
+	if blob.Offset == blob.Size {
+		defer s.mu.Unlock()
+		return *blob, nil
+	}
+
+	if offset != blob.Offset || s.writing[digest] {
+		defer s.mu.Unlock()
+		return *blob, errUploadOffset
+	}
+
+	size := blob.Size
+	s.writing[digest] = true
+	s.mu.Unlock()
+
+	p, err := s.partialPath(digest)
+	if err != nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.writing, digest)
+		return *blob, err
+	}
+
+	n, err := writeUploadChunk(p, digest, offset, size, r)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.writing, digest)
+	blob.Offset = n
+	return *blob, err
+}
+
+// writeUploadChunk writes r to the partial file p at offset and returns the
+// number of bytes received in total. Once size bytes are received the data is
+// verified against digest and moved into the blobs directory.
+func writeUploadChunk(p, digest string, offset, size int64, r io.Reader) (int64, error) {
+	f, err := os.OpenFile(p, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return offset, err
+	}
+	defer f.Close()
+
+	// discard anything past the offset, e.g. from a chunk interrupted mid write
+	if err := f.Truncate(offset); err != nil {
+		return offset, err
+	}
+
+	n, err := io.Copy(io.NewOffsetWriter(f, offset), io.LimitReader(r, size-offset))
+	offset += n
+	if err != nil || offset < size {
+		return offset, err
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(f, 0, size)); err != nil {
+		return offset, err
+	}
+
+	if got := fmt.Sprintf("sha256:%x", h.Sum(nil)); got != digest {
+		f.Close()
+		if err := os.Remove(p); err != nil {
+			return offset, err
+		}
+
+		return 0, fmt.Errorf("%w: expected %q, got %q", errDigestMismatch, digest, got)
+	}
+
+	if err := f.Close(); err != nil {
+		return offset, err
+	}
+
+	final, err := GetBlobsPath(digest)
+	if err != nil {
+		return offset, err
+	}
+
+	return offset, os.Rename(p, final)
+}
+
+func (s *Server) CreateUploadHandler(c *gin.Context) {
+	var req api.UploadRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	session, err := newUploadSession(req.Blobs)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, session.response())
+}
+
+func (s *Server) UploadHandler(c *gin.Context) {
+	session, err := getUploadSession(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, session.response())
+}
+
+// UploadBlobHandler receives a chunk of a blob. The Upload-Offset header
+// must match the number of bytes already received for the blob.
+func (s *Server) UploadBlobHandler(c *gin.Context) {
+	session, err := getUploadSession(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing or invalid Upload-Offset header"})
+		return
+	}
+
+	blob, err := session.write(c.Param("digest"), offset, c.Request.Body)
+	switch {
+	case errors.Is(err, errUploadOffset):
+		c.Header("Upload-Offset", strconv.FormatInt(blob.Offset, 10))
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, errUploadBlobNotFound):
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, errDigestMismatch):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case err != nil:
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusOK, blob)
+	}
+}
+
+func (s *Server) DeleteUploadHandler(c *gin.Context) {
+	session, err := getUploadSession(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	deleteUploadSession(session.id)
+	session.discard()
+
+	c.Status(http.StatusOK)
+}
@@ -0,0 +1,214 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+)
+
+func TestUploadSession(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	f, err := os.CreateTemp(t.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := llm.WriteGGUF(f, llm.KV{"general.architecture": "test"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	bts, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	digest, size := GetSHA256Digest(bytes.NewReader(bts))
+
+	var s Server
+	srv := httptest.NewServer(s.GenerateRoutes())
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := api.NewClient(u, http.DefaultClient)
+	ctx := context.Background()
+
+	statusCode := func(err error) int {
+		var se api.StatusError
+		if errors.As(err, &se) {
+			return se.StatusCode
+		}
+		return 0
+	}
+
+	session, err := client.CreateUpload(ctx, &api.UploadRequest{Blobs: []api.UploadBlob{{Digest: digest, Size: size}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(session.Blobs) != 1 || session.Blobs[0].Offset != 0 {
+		t.Fatalf("unexpected session %+v", session)
+	}
+
+	// creating the model before the upload finishes fails
+	if err := client.Create(ctx, &api.CreateRequest{Model: "test", Files: map[string]string{"test.gguf": digest}, Upload: session.ID}, func(api.ProgressResponse) error { return nil }); err == nil || err.Error() != errUploadIncomplete.Error() {
+		t.Errorf("expected %v, got %v", errUploadIncomplete, err)
+	}
+
+	half := size / 2
+	blob, err := client.UploadChunk(ctx, session.ID, digest, 0, bytes.NewReader(bts[:half]))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if blob.Offset != half {
+		t.Errorf("expected offset %d, got %d", half, blob.Offset)
+	}
+
+	// resending from the wrong offset is rejected
+	if _, err := client.UploadChunk(ctx, session.ID, digest, 0, bytes.NewReader(bts)); statusCode(err) != http.StatusConflict {
+		t.Errorf("expected status 409, got %v", err)
+	}
+
+	// another session for the same blob doesn't share the data received
+	other, err := client.CreateUpload(ctx, &api.UploadRequest{Blobs: []api.UploadBlob{{Digest: digest, Size: size}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if other.Blobs[0].Offset != 0 {
+		t.Errorf("expected offset 0, got %d", other.Blobs[0].Offset)
+	}
+
+	if _, err := client.UploadChunk(ctx, other.ID, digest, 0, bytes.NewReader(bts[:1])); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := GetBlobsPath(digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.DeleteUpload(ctx, other.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.Upload(ctx, other.ID); statusCode(err) != http.StatusNotFound {
+		t.Errorf("expected status 404, got %v", err)
+	}
+
+	// deleting the session discarded its partial data
+	if _, err := os.Stat(p + "-upload-" + other.ID); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected partial data to be removed, got %v", err)
+	}
+
+	// and left the first session's alone
+	if blob, err = client.UploadChunk(ctx, session.ID, digest, half, bytes.NewReader(bts[half:])); err != nil {
+		t.Fatal(err)
+	} else if blob.Offset != size {
+		t.Errorf("expected offset %d, got %d", size, blob.Offset)
+	}
+
+	if _, err := os.Stat(p); err != nil {
+		t.Fatalf("expected blob to exist: %v", err)
+	}
+
+	if err := client.Create(ctx, &api.CreateRequest{Model: "test", Files: map[string]string{"test.gguf": digest}, Upload: session.ID}, func(api.ProgressResponse) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.Upload(ctx, session.ID); statusCode(err) != http.StatusNotFound {
+		t.Errorf("expected session to be closed after create, got %v", err)
+	}
+}
+
+func TestUploadSessionErrors(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	data := []byte("hello world")
+	digest, size := GetSHA256Digest(bytes.NewReader(data))
+
+	if _, err := newUploadSession(nil); err == nil {
+		t.Error("expected error for empty session")
+	}
+
+	if _, err := newUploadSession([]api.UploadBlob{{Digest: "sha256:bad", Size: 1}}); !errors.Is(err, ErrInvalidDigestFormat) {
+		t.Errorf("expected %v, got %v", ErrInvalidDigestFormat, err)
+	}
+
+	if _, err := newUploadSession([]api.UploadBlob{{Digest: digest}}); err == nil {
+		t.Error("expected error for missing size")
+	}
+
+	session, err := newUploadSession([]api.UploadBlob{{Digest: digest, Size: size}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := session.write("sha256:"+string(bytes.Repeat([]byte("0"), 64)), 0, bytes.NewReader(data)); !errors.Is(err, errUploadBlobNotFound) {
+		t.Errorf("expected %v, got %v", errUploadBlobNotFound, err)
+	}
+
+	blob, err := session.write(digest, 0, bytes.NewReader([]byte("goodbye wld")))
+	if !errors.Is(err, errDigestMismatch) {
+		t.Errorf("expected %v, got %v", errDigestMismatch, err)
+	}
+
+	if blob.Offset != 0 {
+		t.Errorf("expected offset to reset after a digest mismatch, got %d", blob.Offset)
+	}
+
+	if _, err := session.write(digest, 0, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	if !session.complete() {
+		t.Error("expected session to be complete")
+	}
+
+	// expired sessions discard their partial data
+	other := []byte("hello again")
+	digest, size = GetSHA256Digest(bytes.NewReader(other))
+	expired, err := newUploadSession([]api.UploadBlob{{Digest: digest, Size: size}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := expired.write(digest, 0, bytes.NewReader(other[:5])); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := expired.partialPath(digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(p); err != nil {
+		t.Fatalf("expected partial data: %v", err)
+	}
+
+	uploadSessions.Lock()
+	expired.expires = time.Now().Add(-time.Second)
+	uploadSessions.Unlock()
+
+	if _, err := getUploadSession(expired.id); !errors.Is(err, errUploadNotFound) {
+		t.Errorf("expected %v, got %v", errUploadNotFound, err)
+	}
+
+	if _, err := os.Stat(p); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected partial data to be removed, got %v", err)
+	}
+}
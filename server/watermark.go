@@ -0,0 +1,78 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/types/model"
+	"github.com/ollama/ollama/watermark"
+)
+
+var errWatermark = errors.New("watermark")
+
+// checkWatermark returns an error if opts set a watermark m can't be
+// generated with
+func checkWatermark(m *Model, opts api.Options) error {
+	switch {
+	case !opts.Watermark:
+		return nil
+	case envconfig.WatermarkKey() == "":
+		return fmt.Errorf("%w requires OLLAMA_WATERMARK_KEY to be set", errWatermark)
+	case m.Config.Remote != nil, opts.Fallback != "":
+		return fmt.Errorf("%w isn't supported by remote servers", errWatermark)
+	}
+
+	return nil
+}
+
+// WatermarkHandler scores text against the server's watermark with the
+// model's tokenizer
+func (s *Server) WatermarkHandler(c *gin.Context) {
+	var req api.WatermarkRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	key := envconfig.WatermarkKey()
+	if key == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "OLLAMA_WATERMARK_KEY isn't set"})
+		return
+	}
+
+	name, err := getExistingName(model.ParseName(req.Model))
+	if err != nil {
+		c.JSON(http.StatusNotFound, errorBody(api.ErrorCodeModelNotFound, fmt.Sprintf("model '%s' not found", req.Model)))
+		return
+	}
+
+	r, _, _, err := s.scheduleRunner(c.Request.Context(), name.String(), []Capability{}, nil, req.KeepAlive)
+	if err != nil {
+		handleScheduleError(c, req.Model, err)
+		return
+	}
+
+	tokens, err := r.Tokenize(c.Request.Context(), req.Text)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	result := watermark.Detect(watermark.Key(key), tokens)
+	c.JSON(http.StatusOK, api.WatermarkResponse{
+		Model:       req.Model,
+		Tokens:      result.Scored,
+		Green:       result.Green,
+		ZScore:      result.ZScore,
+		Watermarked: result.Watermarked(),
+	})
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/watermark"
+)
+
+func TestGenerateWatermark(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	t.Setenv("OLLAMA_PROVENANCE", "1")
+	t.Setenv("OLLAMA_INSTANCE", "node-1")
+
+	mock := mockRunner{CompletionResponse: llm.CompletionResponse{Done: true, DoneReason: "stop"}}
+	s := Server{sched: newMockScheduler(t, &mock)}
+	createMockModel(t, &s, "test", `{{ .Prompt }}`)
+
+	req := api.GenerateRequest{
+		Model:   "test",
+		Prompt:  "Hello!",
+		Options: map[string]any{"watermark": true},
+		Stream:  &stream,
+	}
+
+	w := createRequest(t, s.GenerateHandler, req)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "OLLAMA_WATERMARK_KEY") {
+		t.Errorf("expected status 400 without a key, got %d: %s", w.Code, w.Body.String())
+	}
+
+	t.Setenv("OLLAMA_WATERMARK_KEY", "secret")
+	w = createRequest(t, s.GenerateHandler, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if !mock.CompletionRequest.Options.Watermark {
+		t.Error("expected the completion to be watermarked")
+	}
+
+	m, err := GetModel("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for k, v := range map[string]string{
+		"Ollama-Model":        "test:latest",
+		"Ollama-Model-Digest": m.Digest,
+		"Ollama-Instance":     "node-1",
+		"Ollama-Watermark":    "true",
+	} {
+		if got := w.Header().Get(k); got != v {
+			t.Errorf("expected %s %q, got %q", k, v, got)
+		}
+	}
+
+	for _, k := range []string{"Ollama-Created", "Ollama-Request-Hash"} {
+		if w.Header().Get(k) == "" {
+			t.Errorf("expected a %s header", k)
+		}
+	}
+
+	t.Setenv("OLLAMA_PROVENANCE", "")
+	w = createRequest(t, s.GenerateHandler, req)
+	if w.Header().Get("Ollama-Model-Digest") != "" {
+		t.Error("expected no provenance headers")
+	}
+}
+
+func TestWatermarkHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	var mock mockRunner
+	s := Server{sched: newMockScheduler(t, &mock)}
+	createMockModel(t, &s, "test", `{{ .Prompt }}`)
+
+	req := api.WatermarkRequest{Model: "test", Text: "one two three four five"}
+	w := createRequest(t, s.WatermarkHandler, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 without a key, got %d", w.Code)
+	}
+
+	t.Setenv("OLLAMA_WATERMARK_KEY", "secret")
+	w = createRequest(t, s.WatermarkHandler, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp api.WatermarkResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	// the mock tokenizes each word as its index
+	want := watermark.Detect(watermark.Key("secret"), []int{0, 1, 2, 3, 4})
+	if resp.Tokens != 4 || resp.Green != want.Green || resp.ZScore != want.ZScore || resp.Watermarked {
+		t.Errorf("unexpected response %+v", resp)
+	}
+}
@@ -0,0 +1,31 @@
+// Package stop validates the regular expressions of stop_regex, which end
+// generation at the first match, such as `\n{2,}`. They're checked by the
+// server before a request is scheduled and compiled by runners, so both
+// accept the same patterns.
+package stop
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// Compile returns the regular expressions of patterns. Patterns that don't
+// compile or that match empty text, which would stop generation right
+// away, are invalid.
+func Compile(patterns []string) ([]*regexp.Regexp, error) {
+	res := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid stop_regex %q: %w", pattern, err)
+		}
+
+		if re.MatchString("") {
+			return nil, fmt.Errorf("invalid stop_regex %q: pattern matches empty text", pattern)
+		}
+
+		res = append(res, re)
+	}
+
+	return res, nil
+}
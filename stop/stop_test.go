@@ -0,0 +1,20 @@
+package stop
+
+import "testing"
+
+func TestCompile(t *testing.T) {
+	res, err := Compile([]string{`\n{2,}`, `[.!?] `})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(res) != 2 || !res[0].MatchString("a\n\nb") {
+		t.Errorf("unexpected patterns %v", res)
+	}
+
+	for _, pattern := range []string{"(", "a*", ""} {
+		if _, err := Compile([]string{pattern}); err == nil {
+			t.Errorf("expected %q to be invalid", pattern)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package template
+
+import (
+	"container/list"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sync"
+	"text/template"
+	"time"
+	"unicode/utf8"
+)
+
+// now returns the current time and can be replaced in tests
+var now = time.Now
+
+var funcs = template.FuncMap{
+	"json":   toJSON,
+	"toJSON": toJSON,
+	"fromJSON": func(s string) (any, error) {
+		var v any
+		if err := json.Unmarshal([]byte(s), &v); err != nil {
+			return nil, err
+		}
+		return v, nil
+	},
+	"regexMatch": func(pattern, s string) (bool, error) {
+		re, err := compileRegexp(pattern)
+		if err != nil {
+			return false, err
+		}
+		return re.MatchString(s), nil
+	},
+	"regexFind": func(pattern, s string) (string, error) {
+		re, err := compileRegexp(pattern)
+		if err != nil {
+			return "", err
+		}
+		return re.FindString(s), nil
+	},
+	"regexReplace": func(pattern, repl, s string) (string, error) {
+		re, err := compileRegexp(pattern)
+		if err != nil {
+			return "", err
+		}
+		return re.ReplaceAllString(s, repl), nil
+	},
+	"add": arithmetic(func(a, b int64) (int64, error) { return a + b, nil }, func(a, b float64) float64 { return a + b }),
+	"sub": arithmetic(func(a, b int64) (int64, error) { return a - b, nil }, func(a, b float64) float64 { return a - b }),
+	"mul": arithmetic(func(a, b int64) (int64, error) { return a * b, nil }, func(a, b float64) float64 { return a * b }),
+	"div": arithmetic(func(a, b int64) (int64, error) {
+		if b == 0 {
+			return 0, errors.New("division by zero")
+		}
+		return a / b, nil
+	}, func(a, b float64) float64 { return a / b }),
+	"mod": arithmetic(func(a, b int64) (int64, error) {
+		if b == 0 {
+			return 0, errors.New("division by zero")
+		}
+		return a % b, nil
+	}, nil),
+	"now": func() time.Time {
+		return now()
+	},
+	// formatDate formats t with a Go time layout, e.g. "2006-01-02"
+	"formatDate": func(layout string, t time.Time) string {
+		return t.Format(layout)
+	},
+	"truncateTokens": truncateTokens(nil),
+	stepFunc:         noStep,
+}
+
+func toJSON(v any) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// regexpCacheSize is the number of compiled patterns compileRegexp keeps
+const regexpCacheSize = 256
+
+// regexps is an LRU cache of compiled patterns. Patterns can be built from
+// request data, so the cache is bounded.
+var regexps = struct {
+	sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+}{order: list.New(), entries: make(map[string]*list.Element)}
+
+// compileRegexp compiles pattern, caching the result since templates are
+// executed for every request
+func compileRegexp(pattern string) (*regexp.Regexp, error) {
+	regexps.Lock()
+	if e, ok := regexps.entries[pattern]; ok {
+		regexps.order.MoveToFront(e)
+		regexps.Unlock()
+		return e.Value.(*regexp.Regexp), nil
+	}
+	regexps.Unlock()
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	regexps.Lock()
+	defer regexps.Unlock()
+
+	if e, ok := regexps.entries[pattern]; ok {
+		regexps.order.MoveToFront(e)
+		return e.Value.(*regexp.Regexp), nil
+	}
+
+	regexps.entries[pattern] = regexps.order.PushFront(re)
+	for regexps.order.Len() > regexpCacheSize {
+		oldest := regexps.order.Back()
+		regexps.order.Remove(oldest)
+		delete(regexps.entries, oldest.Value.(*regexp.Regexp).String())
+	}
+
+	return re, nil
+}
+
+// arithmetic returns a template function applying intOp if both arguments are
+// integers and floatOp otherwise. A nil floatOp only accepts integers.
+func arithmetic(intOp func(a, b int64) (int64, error), floatOp func(a, b float64) float64) func(a, b any) (any, error) {
+	return func(a, b any) (any, error) {
+		x, xInt, err := toNumber(a)
+		if err != nil {
+			return nil, err
+		}
+
+		y, yInt, err := toNumber(b)
+		if err != nil {
+			return nil, err
+		}
+
+		if xInt && yInt {
+			return intOp(int64(x), int64(y))
+		}
+
+		if floatOp == nil {
+			return nil, fmt.Errorf("expected integers, got %v and %v", a, b)
+		}
+
+		return floatOp(x, y), nil
+	}
+}
+
+// toNumber converts a numeric value to a float64, reporting whether it's an
+// integer. JSON numbers, which are decoded as float64, are integers if they
+// have no fractional part.
+func toNumber(v any) (float64, bool, error) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true, nil
+	case reflect.Float32, reflect.Float64:
+		f := rv.Float()
+		return f, f == float64(int64(f)), nil
+	default:
+		return 0, false, fmt.Errorf("expected a number, got %T", v)
+	}
+}
+
+// truncateTokens returns a template function that truncates a string to at
+// most n tokens. Without a tokenizer, tokens are estimated as 4 bytes each.
+func truncateTokens(tokenize func(string) ([]int, error)) func(n int, s string) (string, error) {
+	return func(n int, s string) (string, error) {
+		if n <= 0 {
+			return "", nil
+		}
+
+		if tokenize == nil {
+			if len(s) <= n*4 {
+				return s, nil
+			}
+
+			// don't split a multibyte character
+			cut := n * 4
+			for cut > 0 && !utf8.RuneStart(s[cut]) {
+				cut--
+			}
+			return s[:cut], nil
+		}
+
+		tokens, err := tokenize(s)
+		if err != nil {
+			return "", err
+		}
+
+		if len(tokens) <= n {
+			return s, nil
+		}
+
+		// find the longest prefix that fits, on a rune boundary
+		runes := []rune(s)
+		lo, hi := 0, len(runes)
+		for lo < hi {
+			mid := (lo + hi + 1) / 2
+			tokens, err := tokenize(string(runes[:mid]))
+			if err != nil {
+				return "", err
+			}
+
+			if len(tokens) <= n {
+				lo = mid
+			} else {
+				hi = mid - 1
+			}
+		}
+
+		return string(runes[:lo]), nil
+	}
+}
@@ -0,0 +1,127 @@
+package template
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestFuncs(t *testing.T) {
+	now = func() time.Time { return time.Date(2024, time.December, 24, 9, 30, 0, 0, time.UTC) }
+	t.Cleanup(func() { now = time.Now })
+
+	cases := []struct {
+		name     string
+		template string
+		want     string
+		err      bool
+	}{
+		{"toJSON", `{{ toJSON .Messages }}`, `[{"role":"user","content":"Hello, world!"}]`, false},
+		{"fromJSON", `{{ with fromJSON "{\"a\": [1, 2]}" }}{{ index .a 1 }}{{ end }}`, "2", false},
+		{"fromJSON invalid", `{{ fromJSON "{" }}`, "", true},
+		{"regexMatch", `{{ if regexMatch "^Hello" (index .Messages 0).Content }}yes{{ end }}`, "yes", false},
+		{"regexFind", `{{ regexFind "w[a-z]+" (index .Messages 0).Content }}`, "world", false},
+		{"regexReplace", `{{ (index .Messages 0).Content | regexReplace "(\\w+), (\\w+)" "$2, $1" }}`, "world, Hello!", false},
+		{"regex invalid", `{{ regexMatch "(" "" }}`, "", true},
+		{"add", `{{ add 1 2 }}`, "3", false},
+		{"sub", `{{ sub (len .Messages) 1 }}`, "0", false},
+		{"mul float", `{{ mul 1.5 2 }}`, "3", false},
+		{"div", `{{ div 7 2 }} {{ div 7.0 2.5 }}`, "3 2.8", false},
+		{"div by zero", `{{ div 1 0 }}`, "", true},
+		{"mod", `{{ mod 7 3 }}`, "1", false},
+		{"mod float", `{{ mod 7.5 3 }}`, "", true},
+		{"not a number", `{{ add "a" 1 }}`, "", true},
+		{"formatDate", `{{ now | formatDate "2 January 2006" }}`, "24 December 2024", false},
+		{"truncateTokens", `{{ (index .Messages 0).Content | truncateTokens 2 }}`, "Hello, w", false},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := Parse(`{{- range .Messages }}{{ end }}` + tt.template)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var b bytes.Buffer
+			err = tmpl.Execute(&b, Values{Messages: []api.Message{{Role: "user", Content: "Hello, world!"}}})
+			if tt.err {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if b.String() != tt.want {
+				t.Errorf("got %q, want %q", b.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileRegexp(t *testing.T) {
+	first, err := compileRegexp("^0$")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if re, err := compileRegexp("^0$"); err != nil {
+		t.Fatal(err)
+	} else if re != first {
+		t.Error("expected the cached pattern")
+	}
+
+	for i := range regexpCacheSize {
+		if _, err := compileRegexp(fmt.Sprintf("^%d$", i+1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	regexps.Lock()
+	n := regexps.order.Len()
+	_, ok := regexps.entries["^0$"]
+	regexps.Unlock()
+
+	if n != regexpCacheSize {
+		t.Errorf("expected %d cached patterns, got %d", regexpCacheSize, n)
+	}
+
+	if ok {
+		t.Error("expected the least recently used pattern to be evicted")
+	}
+}
+
+func TestTruncateTokens(t *testing.T) {
+	// one token per word
+	tokenize := func(s string) ([]int, error) {
+		return make([]int, len(strings.Fields(s))), nil
+	}
+
+	tmpl, err := Parse(`{{ range .Messages }}{{ .Content | truncateTokens 3 }}{{ end }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, Values{
+		Messages: []api.Message{{Role: "user", Content: "one two three four five"}},
+		Tokenize: tokenize,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := b.String(), "one two three "; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// without a tokenizer tokens are estimated from the length
+	truncate := truncateTokens(nil)
+	if got, _ := truncate(1, "日本語"); got != "日" {
+		t.Errorf("got %q, want %q", got, "日")
+	}
+}
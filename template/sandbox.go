@@ -0,0 +1,186 @@
+package template
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"text/template"
+	"text/template/parse"
+	"time"
+)
+
+// ErrLimitExceeded is returned when a sandboxed template exceeds its limits
+var ErrLimitExceeded = errors.New("template exceeded execution limits")
+
+// Limits bounds the resources used to execute a template. Zero values are
+// unlimited.
+type Limits struct {
+	// MaxOutput is the largest output in bytes
+	MaxOutput int
+	// MaxSteps is the largest number of range iterations and template calls
+	MaxSteps int
+	// Timeout is the longest time an execution may take
+	Timeout time.Duration
+}
+
+// DefaultLimits are the limits for templates that come with models, which
+// may be pulled from any registry. They're generous enough for the largest
+// context windows.
+var DefaultLimits = Limits{
+	MaxOutput: 32 << 20,
+	MaxSteps:  1_000_000,
+	Timeout:   5 * time.Second,
+}
+
+// maxFormatWidth is the largest width or precision printf accepts in a
+// sandboxed template, since fmt allocates the padding up front
+const maxFormatWidth = 1024
+
+// stepFunc is called at the start of every range iteration and template call
+// so limits can be checked while a template doesn't produce output
+const stepFunc = "sandboxStep"
+
+// Sandbox restricts the template to l and to functions that are safe to run
+// on untrusted input. It must be called before the template is executed.
+func (t *Template) Sandbox(l Limits) *Template {
+	for _, tmpl := range t.Templates() {
+		if tmpl.Tree == nil || tmpl.Root == nil {
+			continue
+		}
+
+		insertSteps(tmpl.Root)
+		tmpl.Root.Nodes = append([]parse.Node{stepNode()}, tmpl.Root.Nodes...)
+	}
+
+	t.limits = &l
+	return t
+}
+
+// insertSteps adds a step to the start of every range body under n
+func insertSteps(n parse.Node) {
+	switch n := n.(type) {
+	case *parse.ListNode:
+		for _, c := range n.Nodes {
+			insertSteps(c)
+		}
+	case *parse.IfNode:
+		insertSteps(&n.BranchNode)
+	case *parse.WithNode:
+		insertSteps(&n.BranchNode)
+	case *parse.RangeNode:
+		insertSteps(&n.BranchNode)
+		n.List.Nodes = append([]parse.Node{stepNode()}, n.List.Nodes...)
+	case *parse.BranchNode:
+		for _, l := range []*parse.ListNode{n.List, n.ElseList} {
+			if l != nil {
+				insertSteps(l)
+			}
+		}
+	}
+}
+
+func stepNode() parse.Node {
+	return &parse.ActionNode{
+		NodeType: parse.NodeAction,
+		Pipe: &parse.PipeNode{
+			NodeType: parse.NodePipe,
+			Cmds: []*parse.CommandNode{
+				{
+					NodeType: parse.NodeCommand,
+					Args:     []parse.Node{parse.NewIdentifier(stepFunc)},
+				},
+			},
+		},
+	}
+}
+
+// sandbox tracks the resources used by a single execution
+type sandbox struct {
+	limits   Limits
+	deadline time.Time
+	steps    int
+	written  int
+}
+
+func newSandbox(l Limits) *sandbox {
+	s := sandbox{limits: l}
+	if l.Timeout > 0 {
+		s.deadline = time.Now().Add(l.Timeout)
+	}
+
+	return &s
+}
+
+func (s *sandbox) step() (string, error) {
+	s.steps++
+	if s.limits.MaxSteps > 0 && s.steps > s.limits.MaxSteps {
+		return "", fmt.Errorf("%w: more than %d steps", ErrLimitExceeded, s.limits.MaxSteps)
+	}
+
+	if !s.deadline.IsZero() && time.Now().After(s.deadline) {
+		return "", fmt.Errorf("%w: took longer than %s", ErrLimitExceeded, s.limits.Timeout)
+	}
+
+	return "", nil
+}
+
+func (s *sandbox) funcs() template.FuncMap {
+	return template.FuncMap{
+		stepFunc: s.step,
+		"printf": func(format string, args ...any) (string, error) {
+			if err := checkFormat(format); err != nil {
+				return "", err
+			}
+
+			return fmt.Sprintf(format, args...), nil
+		},
+		"call": func(any, ...any) (any, error) {
+			return nil, errors.New("call is not allowed in templates")
+		},
+	}
+}
+
+// writer limits the output written to w
+func (s *sandbox) writer(w io.Writer) io.Writer {
+	return writerFunc(func(p []byte) (int, error) {
+		s.written += len(p)
+		if s.limits.MaxOutput > 0 && s.written > s.limits.MaxOutput {
+			return 0, fmt.Errorf("%w: output larger than %d bytes", ErrLimitExceeded, s.limits.MaxOutput)
+		}
+
+		return w.Write(p)
+	})
+}
+
+type writerFunc func([]byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+var formatVerb = regexp.MustCompile(`%[-+# 0]*(\*|\d*)(?:\.(\*|\d*))?`)
+
+// checkFormat rejects printf formats with large or variable widths
+func checkFormat(format string) error {
+	for _, m := range formatVerb.FindAllStringSubmatch(format, -1) {
+		for _, n := range m[1:] {
+			if n == "*" {
+				return errors.New("printf: variable width is not allowed in templates")
+			}
+
+			if w, err := strconv.Atoi(n); err == nil && w > maxFormatWidth {
+				return fmt.Errorf("printf: width %d is larger than %d", w, maxFormatWidth)
+			}
+		}
+	}
+
+	return nil
+}
+
+// noStep is the step function for templates that aren't sandboxed, such as
+// subtrees of sandboxed templates
+func noStep() string {
+	return ""
+}
@@ -0,0 +1,138 @@
+package template
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"text/template/parse"
+	"time"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestSandbox(t *testing.T) {
+	limits := Limits{MaxOutput: 1024, MaxSteps: 100, Timeout: time.Second}
+
+	cases := []struct {
+		name     string
+		template string
+		limits   Limits
+		want     string
+		err      error
+	}{
+		{
+			name:     "messages",
+			template: `{{ range .Messages }}<|{{ .Role }}|>{{ .Content }}{{ end }}`,
+			limits:   limits,
+			want:     "<|user|>Hello<|assistant|>Hi<|user|>Hello",
+		},
+		{
+			name:     "legacy",
+			template: `{{ if .Prompt }}Q: {{ .Prompt }} {{ end }}A: {{ .Response }}`,
+			limits:   limits,
+			want:     "Q: Hello A: HiQ: Hello A: ",
+		},
+		{
+			name:     "range",
+			template: `{{ range 1000 }}{{ end }}{{ .Messages }}`,
+			limits:   limits,
+			err:      ErrLimitExceeded,
+		},
+		{
+			name:     "recursion",
+			template: `{{ define "a" }}{{ template "a" . }}{{ template "a" . }}{{ end }}{{ template "a" .Messages }}`,
+			limits:   limits,
+			err:      ErrLimitExceeded,
+		},
+		{
+			name:     "output",
+			template: `{{ range 50 }}{{ range $.Messages }}{{ .Content }}{{ .Content }}{{ .Content }}{{ .Content }}{{ .Content }}{{ end }}{{ end }}`,
+			limits:   Limits{MaxOutput: 1024},
+			err:      ErrLimitExceeded,
+		},
+		{
+			name:     "timeout",
+			template: `{{ range 100000000 }}{{ end }}{{ .Messages }}`,
+			limits:   Limits{Timeout: time.Millisecond},
+			err:      ErrLimitExceeded,
+		},
+		{
+			name:     "printf",
+			template: `{{ range .Messages }}{{ printf "%5s|%.1f" .Role 1.25 }}{{ end }}`,
+			limits:   limits,
+			want:     " user|1.2assistant|1.2 user|1.2",
+		},
+		{
+			name:     "printf width",
+			template: `{{ printf "%999999999d" 1 }}{{ .Messages }}`,
+			limits:   limits,
+			err:      errors.New("printf: width 999999999 is larger than 1024"),
+		},
+		{
+			name:     "printf variable width",
+			template: `{{ printf "%*d" 999999999 1 }}{{ .Messages }}`,
+			limits:   limits,
+			err:      errors.New("printf: variable width is not allowed in templates"),
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := Parse(tt.template)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var b bytes.Buffer
+			err = tmpl.Sandbox(tt.limits).Execute(&b, Values{
+				Messages: []api.Message{
+					{Role: "user", Content: "Hello"},
+					{Role: "assistant", Content: "Hi"},
+					{Role: "user", Content: "Hello"},
+				},
+			})
+
+			switch {
+			case tt.err == nil && err != nil:
+				t.Fatal(err)
+			case tt.err != nil && err == nil:
+				t.Fatalf("expected error %v, got output %q", tt.err, b.String())
+			case tt.err != nil:
+				if !errors.Is(err, tt.err) && !strings.Contains(err.Error(), tt.err.Error()) {
+					t.Fatalf("expected error %v, got %v", tt.err, err)
+				}
+			case b.String() != tt.want:
+				t.Errorf("got %q, want %q", b.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestSandboxSubtree(t *testing.T) {
+	tmpl, err := Parse(`{{ range .Messages }}{{ range .ToolCalls }}{"name": "{{ .Function.Name }}"}{{ end }}{{ end }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sub := tmpl.Sandbox(DefaultLimits).Subtree(func(n parse.Node) bool {
+		if t, ok := n.(*parse.RangeNode); ok {
+			return strings.Contains(t.Pipe.String(), "ToolCalls")
+		}
+		return false
+	})
+	if sub == nil {
+		t.Fatal("expected subtree")
+	}
+
+	var b bytes.Buffer
+	if err := sub.Execute(&b, map[string]any{
+		"ToolCalls": []api.ToolCall{{Function: api.ToolCallFunction{Name: "get_weather"}}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := b.String(), `{"name": "get_weather"}`; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
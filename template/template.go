@@ -90,11 +90,57 @@ func Named(s string) (*named, error) {
 	return nil, errors.New("no matching template found")
 }
 
+// fingerprints are the templates instruction tuned models are usually trained
+// with, by architecture and tokenizer. Empty fields match any value.
+var fingerprints = []struct {
+	architecture string
+	pre          string
+	vocabSize    uint64
+	name         string
+}{
+	{architecture: "llama", pre: "llama-bpe", name: "llama3-instruct"},
+	{architecture: "llama", vocabSize: 32768, name: "mistral-instruct"},
+	{architecture: "qwen2", name: "chatml"},
+	{architecture: "gemma", name: "gemma-instruct"},
+	{architecture: "gemma2", name: "gemma-instruct"},
+	{architecture: "phi3", name: "phi-3"},
+	{architecture: "granite", name: "granite-instruct"},
+	{architecture: "starcoder2", name: "starcoder2-instruct"},
+}
+
+// Detect returns the template usually used by models with the architecture
+// and tokenizer, for models without a chat template
+func Detect(architecture, pre string, vocabSize uint64) (*named, error) {
+	templates, err := templatesOnce()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range fingerprints {
+		if f.architecture != architecture ||
+			(f.pre != "" && f.pre != pre) ||
+			(f.vocabSize != 0 && f.vocabSize != vocabSize) {
+			continue
+		}
+
+		for _, t := range templates {
+			if t.Name == f.name {
+				return t, nil
+			}
+		}
+	}
+
+	return nil, errors.New("no matching template found")
+}
+
 var DefaultTemplate, _ = Parse("{{ .Prompt }}")
 
 type Template struct {
 	*template.Template
 	raw string
+
+	// limits is set for sandboxed templates
+	limits *Limits
 }
 
 // response is a template node that can be added to templates that don't already have one
@@ -116,13 +162,6 @@ var response = parse.ActionNode{
 	},
 }
 
-var funcs = template.FuncMap{
-	"json": func(v any) string {
-		b, _ := json.Marshal(v)
-		return string(b)
-	},
-}
-
 func Parse(s string) (*Template, error) {
 	tmpl := template.New("").Option("missingkey=zero").Funcs(funcs)
 
@@ -168,6 +207,10 @@ type Values struct {
 	Prompt string
 	Suffix string
 
+	// Tokenize is the model's tokenizer, used by template functions that
+	// count tokens
+	Tokenize func(string) ([]int, error)
+
 	// forceLegacy is a flag used to test compatibility with legacy templates
 	forceLegacy bool
 }
@@ -218,16 +261,49 @@ func (t *Template) Subtree(fn func(parse.Node) bool) *template.Template {
 	return nil
 }
 
+// executionFuncs returns the functions that depend on the values or the
+// sandbox of a single execution, and a function limiting writes to the
+// template's output
+func (t *Template) executionFuncs(v Values) (template.FuncMap, func(io.Writer) io.Writer) {
+	fm := template.FuncMap{}
+	if v.Tokenize != nil && strings.Contains(t.raw, "truncateTokens") {
+		fm["truncateTokens"] = truncateTokens(v.Tokenize)
+	}
+
+	if t.limits == nil {
+		return fm, func(w io.Writer) io.Writer { return w }
+	}
+
+	s := newSandbox(*t.limits)
+	for k, f := range s.funcs() {
+		fm[k] = f
+	}
+
+	return fm, s.writer
+}
+
 func (t *Template) Execute(w io.Writer, v Values) error {
+	fm, limit := t.executionFuncs(v)
+
+	tmpl := t.Template
+	if len(fm) > 0 {
+		clone, err := t.Template.Clone()
+		if err != nil {
+			return err
+		}
+
+		tmpl = clone.Funcs(fm)
+	}
+
 	system, messages := collate(v.Messages)
 	if v.Prompt != "" && v.Suffix != "" {
-		return t.Template.Execute(w, map[string]any{
+		return tmpl.Execute(limit(w), map[string]any{
 			"Prompt":   v.Prompt,
 			"Suffix":   v.Suffix,
 			"Response": "",
 		})
 	} else if !v.forceLegacy && slices.Contains(t.Vars(), "messages") {
-		return t.Template.Execute(w, map[string]any{
+		return tmpl.Execute(limit(w), map[string]any{
 			"System":   system,
 			"Messages": messages,
 			"Tools":    v.Tools,
@@ -237,10 +313,11 @@ func (t *Template) Execute(w io.Writer, v Values) error {
 
 	system = ""
 	var b bytes.Buffer
+	out := limit(&b)
 	var prompt, response string
 	for _, m := range messages {
 		execute := func() error {
-			if err := t.Template.Execute(&b, map[string]any{
+			if err := tmpl.Execute(out, map[string]any{
 				"System":   system,
 				"Prompt":   prompt,
 				"Response": response,
@@ -285,7 +362,7 @@ func (t *Template) Execute(w io.Writer, v Values) error {
 	})
 
 	tree := parse.Tree{Root: nodes.(*parse.ListNode)}
-	if err := template.Must(template.New("").AddParseTree("", &tree)).Execute(&b, map[string]any{
+	if err := template.Must(template.New("").Funcs(funcs).Funcs(fm).AddParseTree("", &tree)).Execute(out, map[string]any{
 		"System":   system,
 		"Prompt":   prompt,
 		"Response": response,
@@ -62,6 +62,41 @@ func TestNamed(t *testing.T) {
 	}
 }
 
+func TestDetect(t *testing.T) {
+	cases := []struct {
+		architecture string
+		pre          string
+		vocabSize    uint64
+		want         string
+	}{
+		{"llama", "llama-bpe", 128256, "llama3-instruct"},
+		{"llama", "", 32768, "mistral-instruct"},
+		{"qwen2", "qwen2", 151936, "chatml"},
+		{"gemma2", "", 256000, "gemma-instruct"},
+		{"phi3", "", 32064, "phi-3"},
+		{"llama", "", 32000, ""},
+		{"bert", "", 30522, ""},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.architecture, func(t *testing.T) {
+			got, err := Detect(tt.architecture, tt.pre, tt.vocabSize)
+			if tt.want == "" {
+				if err == nil {
+					t.Fatalf("expected no template, got %q", got.Name)
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if got.Name != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got.Name)
+			}
+		})
+	}
+}
+
 func TestTemplate(t *testing.T) {
 	cases := make(map[string][]api.Message)
 	for _, mm := range [][]api.Message{
@@ -0,0 +1,38 @@
+package template
+
+import (
+	"io"
+	"text/template"
+
+	"github.com/ollama/ollama/api"
+)
+
+// ParseToolPrompt parses a model's instructions for using tools, a template
+// of the tools of a request executed with ExecuteTools. Unlike chat
+// templates, nothing is added to it.
+func ParseToolPrompt(s string) (*Template, error) {
+	tmpl, err := template.New("").Option("missingkey=zero").Funcs(funcs).Parse(s)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Template{Template: tmpl, raw: s}, nil
+}
+
+// ExecuteTools executes a template parsed with ParseToolPrompt with the tools
+// of a request as .Tools
+func (t *Template) ExecuteTools(w io.Writer, tools api.Tools) error {
+	fm, limit := t.executionFuncs(Values{})
+
+	tmpl := t.Template
+	if len(fm) > 0 {
+		clone, err := t.Template.Clone()
+		if err != nil {
+			return err
+		}
+
+		tmpl = clone.Funcs(fm)
+	}
+
+	return tmpl.Execute(limit(w), map[string]any{"Tools": tools})
+}
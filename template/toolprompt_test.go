@@ -0,0 +1,49 @@
+package template
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestToolPrompt(t *testing.T) {
+	tools := api.Tools{
+		{Type: "function", Function: api.ToolFunction{Name: "get_weather", Description: "Get the weather in a city"}},
+		{Type: "function", Function: api.ToolFunction{Name: "get_time", Description: "Get the time in a city"}},
+	}
+
+	tmpl, err := ParseToolPrompt(`Call a tool by answering with {"name": ..., "arguments": ...}. The tools are:
+{{- range .Tools }}
+- {{ .Function.Name }}: {{ .Function.Description }}
+{{- end }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b strings.Builder
+	if err := tmpl.ExecuteTools(&b, tools); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `Call a tool by answering with {"name": ..., "arguments": ...}. The tools are:
+- get_weather: Get the weather in a city
+- get_time: Get the time in a city`
+	if b.String() != want {
+		t.Errorf("expected %q, got %q", want, b.String())
+	}
+
+	t.Run("sandboxed", func(t *testing.T) {
+		tmpl, err := ParseToolPrompt(`{{ range $.Tools }}{{ range $.Tools }}{{ range $.Tools }}x{{ end }}{{ end }}{{ end }}`)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var b strings.Builder
+		err = tmpl.Sandbox(Limits{MaxSteps: 4}).ExecuteTools(&b, tools)
+		if !errors.Is(err, ErrLimitExceeded) {
+			t.Errorf("expected %v, got %v", ErrLimitExceeded, err)
+		}
+	})
+}
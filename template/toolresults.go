@@ -0,0 +1,189 @@
+package template
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"text/template/parse"
+
+	"github.com/ollama/ollama/api"
+)
+
+// Styles of tool results rendered for templates that don't handle the tool
+// role. They're set with the tool_results parameter.
+const (
+	// ToolResultsFunctionResponse wraps results in a function_response block
+	ToolResultsFunctionResponse = "function_response"
+
+	// ToolResultsJSON renders results as JSON objects of the tool's name and
+	// the result
+	ToolResultsJSON = "json"
+
+	// ToolResultsUser renders results as user messages as is
+	ToolResultsUser = "user"
+
+	// ToolResultsTemplate passes tool messages to the template unchanged
+	ToolResultsTemplate = "template"
+)
+
+var ErrToolResults = errors.New("tool_results must be one of \"function_response\", \"json\", \"user\" or \"template\"")
+
+// HandlesRole reports whether the template renders messages of role, which
+// templates do by comparing the role of messages to it or by rendering the
+// role of every message as is. Legacy templates, which don't range over
+// messages, only handle the system, user and assistant roles.
+func (t *Template) HandlesRole(role string) bool {
+	if !slices.Contains(t.Vars(), "messages") {
+		return slices.Contains([]string{"system", "user", "assistant"}, role)
+	}
+
+	for _, tt := range t.Templates() {
+		for _, n := range tt.Root.Nodes {
+			if slices.Contains(stringLiterals(n), role) || rendersRole(n) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// ToolResults returns msgs with tool messages rendered as user messages in
+// style, for templates that don't handle the tool role. The results are named
+// after the tool calls of the assistant message before them, in order.
+func ToolResults(msgs []api.Message, style string) ([]api.Message, error) {
+	switch style {
+	case "":
+		style = ToolResultsFunctionResponse
+	case ToolResultsFunctionResponse, ToolResultsJSON, ToolResultsUser:
+	case ToolResultsTemplate:
+		return msgs, nil
+	default:
+		return nil, fmt.Errorf("%w, got %q", ErrToolResults, style)
+	}
+
+	rendered := make([]api.Message, len(msgs))
+	var calls []api.ToolCall
+	for i, msg := range msgs {
+		rendered[i] = msg
+		if msg.Role != "tool" {
+			calls = msg.ToolCalls
+			continue
+		}
+
+		var name string
+		if len(calls) > 0 {
+			name, calls = calls[0].Function.Name, calls[1:]
+		}
+
+		content := msg.Content
+		switch style {
+		case ToolResultsFunctionResponse:
+			if name != "" {
+				content = fmt.Sprintf("<function_response name=%q>\n%s\n</function_response>", name, content)
+			} else {
+				content = fmt.Sprintf("<function_response>\n%s\n</function_response>", content)
+			}
+		case ToolResultsJSON:
+			b, err := json.Marshal(struct {
+				Name    string `json:"name,omitempty"`
+				Content string `json:"content"`
+			}{name, content})
+			if err != nil {
+				return nil, err
+			}
+
+			content = string(b)
+		}
+
+		rendered[i].Role = "user"
+		rendered[i].Content = content
+	}
+
+	return rendered, nil
+}
+
+// rendersRole walks the node tree reporting whether it has an action that
+// renders the role of a message, such as {{ .Role }}
+func rendersRole(n parse.Node) bool {
+	switch n := n.(type) {
+	case *parse.ListNode:
+		return slices.ContainsFunc(n.Nodes, rendersRole)
+	case *parse.ActionNode:
+		if len(n.Pipe.Decl) > 0 || len(n.Pipe.Cmds) == 0 || len(n.Pipe.Cmds[0].Args) == 0 {
+			return false
+		}
+
+		var ident []string
+		switch a := n.Pipe.Cmds[0].Args[0].(type) {
+		case *parse.FieldNode:
+			ident = a.Ident
+		case *parse.VariableNode:
+			ident = a.Ident
+		}
+
+		return len(ident) > 0 && ident[len(ident)-1] == "Role"
+	case *parse.BranchNode:
+		for _, n := range []*parse.ListNode{n.List, n.ElseList} {
+			if n != nil && rendersRole(n) {
+				return true
+			}
+		}
+	case *parse.IfNode:
+		return rendersRole(&n.BranchNode)
+	case *parse.RangeNode:
+		return rendersRole(&n.BranchNode)
+	case *parse.WithNode:
+		return rendersRole(&n.BranchNode)
+	}
+
+	return false
+}
+
+// stringLiterals walks the node tree returning the string constants in it
+func stringLiterals(n parse.Node) []string {
+	switch n := n.(type) {
+	case *parse.ListNode:
+		var s []string
+		for _, n := range n.Nodes {
+			s = append(s, stringLiterals(n)...)
+		}
+
+		return s
+	case *parse.TemplateNode:
+		return stringLiterals(n.Pipe)
+	case *parse.ActionNode:
+		return stringLiterals(n.Pipe)
+	case *parse.BranchNode:
+		s := stringLiterals(n.Pipe)
+		for _, n := range []*parse.ListNode{n.List, n.ElseList} {
+			if n != nil {
+				s = append(s, stringLiterals(n)...)
+			}
+		}
+		return s
+	case *parse.IfNode:
+		return stringLiterals(&n.BranchNode)
+	case *parse.RangeNode:
+		return stringLiterals(&n.BranchNode)
+	case *parse.WithNode:
+		return stringLiterals(&n.BranchNode)
+	case *parse.PipeNode:
+		if n == nil {
+			return nil
+		}
+
+		var s []string
+		for _, c := range n.Cmds {
+			for _, a := range c.Args {
+				s = append(s, stringLiterals(a)...)
+			}
+		}
+		return s
+	case *parse.StringNode:
+		return []string{n.Text}
+	}
+
+	return nil
+}
@@ -0,0 +1,97 @@
+package template
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestHandlesRole(t *testing.T) {
+	cases := []struct {
+		template string
+		roles    map[string]bool
+	}{
+		{
+			`{{ if .System }}{{ .System }}{{ end }}{{ .Prompt }}{{ .Response }}`,
+			map[string]bool{"system": true, "user": true, "assistant": true, "tool": false},
+		},
+		{
+			`{{ range .Messages }}<|{{ .Role }}|>{{ .Content }}{{ end }}`,
+			map[string]bool{"user": true, "tool": true},
+		},
+		{
+			`{{ range $m := .Messages }}{{ if eq $m.Role "user" }}### Instruction:{{ else }}### Response:{{ end }} {{ $m.Content }}{{ end }}`,
+			map[string]bool{"user": true, "assistant": false, "tool": false},
+		},
+		{
+			`{{ range .Messages }}{{ if eq .Role "user" }}[INST] {{ .Content }}{{ else if and .Content (eq .Role "tool") }}[TOOL_RESULTS] {{ .Content }}{{ end }}{{ end }}`,
+			map[string]bool{"user": true, "tool": true, "assistant": false},
+		},
+	}
+
+	for _, tt := range cases {
+		tmpl, err := Parse(tt.template)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for role, want := range tt.roles {
+			if got := tmpl.HandlesRole(role); got != want {
+				t.Errorf("%s: HandlesRole(%q) = %v; want %v", tt.template, role, got, want)
+			}
+		}
+	}
+}
+
+func TestToolResults(t *testing.T) {
+	msgs := []api.Message{
+		{Role: "user", Content: "What's the weather in Paris and Rome?"},
+		{Role: "assistant", ToolCalls: []api.ToolCall{
+			{Function: api.ToolCallFunction{Name: "get_weather", Arguments: api.ToolCallFunctionArguments{"city": "Paris"}}},
+			{Function: api.ToolCallFunction{Name: "get_time", Arguments: api.ToolCallFunctionArguments{"city": "Rome"}}},
+		}},
+		{Role: "tool", Content: "sunny"},
+		{Role: "tool", Content: "noon"},
+		{Role: "tool", Content: "extra"},
+	}
+
+	cases := map[string][]string{
+		"":                  {"<function_response name=\"get_weather\">\nsunny\n</function_response>", "<function_response name=\"get_time\">\nnoon\n</function_response>", "<function_response>\nextra\n</function_response>"},
+		ToolResultsJSON:     {`{"name":"get_weather","content":"sunny"}`, `{"name":"get_time","content":"noon"}`, `{"content":"extra"}`},
+		ToolResultsUser:     {"sunny", "noon", "extra"},
+		ToolResultsTemplate: nil,
+	}
+
+	for style, want := range cases {
+		t.Run(style, func(t *testing.T) {
+			got, err := ToolResults(msgs, style)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if want == nil {
+				if diff := cmp.Diff(msgs, got); diff != "" {
+					t.Errorf("mismatch (-want +got):\n%s", diff)
+				}
+				return
+			}
+
+			if diff := cmp.Diff(msgs[:2], got[:2]); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+
+			for i, content := range want {
+				if msg := got[2+i]; msg.Role != "user" || msg.Content != content {
+					t.Errorf("message %d = %s %q; want user %q", 2+i, msg.Role, msg.Content, content)
+				}
+			}
+		})
+	}
+
+	if _, err := ToolResults(msgs, "xml"); !errors.Is(err, ErrToolResults) {
+		t.Errorf("err = %v; want %v", err, ErrToolResults)
+	}
+}
@@ -0,0 +1,308 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ollama/ollama/version"
+)
+
+const (
+	// maxQueuedSpans is the number of ended spans waiting to be exported
+	// after which more are dropped
+	maxQueuedSpans = 2048
+
+	// maxBatchSpans is the most spans exported in one request
+	maxBatchSpans = 512
+
+	// exportInterval is how often queued spans are exported
+	exportInterval = 5 * time.Second
+)
+
+// active is the exporter spans are recorded for, or nil
+var active atomic.Pointer[exporter]
+
+func current() *exporter {
+	return active.Load()
+}
+
+// exporter sends ended spans to an OTLP/HTTP endpoint in batches
+type exporter struct {
+	endpoint string
+	headers  map[string]string
+	resource otlpResource
+	client   *http.Client
+
+	spans   chan otlpSpan
+	dropped atomic.Int64
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// StartExporter starts exporting spans to the OTLP/HTTP traces endpoint,
+// sending headers, comma separated key=value pairs as in
+// OTEL_EXPORTER_OTLP_HEADERS, with each request. Spans are attributed to the
+// service named service. The returned function stops recording spans and
+// exports those left.
+func StartExporter(endpoint, headers, service string) (func(context.Context) error, error) {
+	if _, err := url.Parse(endpoint); err != nil {
+		return nil, err
+	}
+
+	h, err := parseHeaders(headers)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &exporter{
+		endpoint: endpoint,
+		headers:  h,
+		resource: otlpResource{Attributes: []otlpAttr{
+			otlpAttribute(String("service.name", service)),
+			otlpAttribute(String("service.version", version.Version)),
+		}},
+		client: &http.Client{Timeout: 10 * time.Second},
+		spans:  make(chan otlpSpan, maxQueuedSpans),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	active.Store(e)
+	go e.run()
+
+	var once sync.Once
+	return func(ctx context.Context) error {
+		once.Do(func() {
+			active.CompareAndSwap(e, nil)
+			close(e.stop)
+		})
+
+		select {
+		case <-e.done:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}, nil
+}
+
+// parseHeaders parses comma separated key=value pairs with URL encoded
+// values
+func parseHeaders(s string) (map[string]string, error) {
+	headers := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+
+		key, value, ok := strings.Cut(pair, "=")
+		if key = strings.TrimSpace(key); !ok || key == "" {
+			return nil, fmt.Errorf("invalid header %q", pair)
+		}
+
+		value, err := url.QueryUnescape(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid header %q: %w", pair, err)
+		}
+
+		headers[key] = value
+	}
+
+	return headers, nil
+}
+
+// export queues s, which ended at end, to be exported. Spans are dropped
+// rather than block the request they're part of if the queue is full.
+func (e *exporter) export(s *Span, end time.Time) {
+	s.mu.Lock()
+	span := otlpSpan{
+		TraceID:           hex.EncodeToString(s.sc.TraceID[:]),
+		SpanID:            hex.EncodeToString(s.sc.SpanID[:]),
+		Name:              s.name,
+		Kind:              s.kind,
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(end.UnixNano(), 10),
+	}
+
+	for _, attr := range s.attrs {
+		span.Attributes = append(span.Attributes, otlpAttribute(attr))
+	}
+
+	if s.err != "" {
+		span.Status = &otlpStatus{Code: 2, Message: s.err}
+	}
+	s.mu.Unlock()
+
+	if s.parent != (SpanID{}) {
+		span.ParentSpanID = hex.EncodeToString(s.parent[:])
+	}
+
+	select {
+	case e.spans <- span:
+	default:
+		e.dropped.Add(1)
+	}
+}
+
+func (e *exporter) run() {
+	defer close(e.done)
+
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+
+	var batch []otlpSpan
+	for {
+		select {
+		case span := <-e.spans:
+			if batch = append(batch, span); len(batch) >= maxBatchSpans {
+				e.send(batch)
+				batch = nil
+			}
+		case <-ticker.C:
+			e.send(batch)
+			batch = nil
+		case <-e.stop:
+			for {
+				select {
+				case span := <-e.spans:
+					batch = append(batch, span)
+				default:
+					for len(batch) > 0 {
+						n := min(len(batch), maxBatchSpans)
+						e.send(batch[:n])
+						batch = batch[n:]
+					}
+					return
+				}
+			}
+		}
+	}
+}
+
+func (e *exporter) send(spans []otlpSpan) {
+	if dropped := e.dropped.Swap(0); dropped > 0 {
+		slog.Warn("dropped trace spans, the exporter can't keep up", "spans", dropped)
+	}
+
+	if len(spans) == 0 {
+		return
+	}
+
+	body, err := json.Marshal(otlpTraces{ResourceSpans: []otlpResourceSpans{{
+		Resource:   e.resource,
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "ollama", Version: version.Version}, Spans: spans}},
+	}}})
+	if err != nil {
+		slog.Warn("couldn't export trace spans", "error", err)
+		return
+	}
+
+	req, err := http.NewRequest(http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		slog.Warn("couldn't export trace spans", "error", err)
+		return
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		slog.Warn("couldn't export trace spans", "endpoint", e.endpoint, "error", err)
+		return
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		slog.Warn("couldn't export trace spans", "endpoint", e.endpoint, "status", resp.Status)
+	}
+}
+
+// The types below are the JSON encoding of OTLP trace requests. IDs are hex
+// encoded and 64-bit integers are strings.
+
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttr `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string      `json:"traceId"`
+	SpanID            string      `json:"spanId"`
+	ParentSpanID      string      `json:"parentSpanId,omitempty"`
+	Name              string      `json:"name"`
+	Kind              Kind        `json:"kind"`
+	StartTimeUnixNano string      `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string      `json:"endTimeUnixNano"`
+	Attributes        []otlpAttr  `json:"attributes,omitempty"`
+	Status            *otlpStatus `json:"status,omitempty"`
+}
+
+type otlpAttr struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+func otlpAttribute(attr Attr) otlpAttr {
+	var v otlpValue
+	switch value := attr.Value.(type) {
+	case string:
+		v.StringValue = &value
+	case int64:
+		s := strconv.FormatInt(value, 10)
+		v.IntValue = &s
+	case float64:
+		v.DoubleValue = &value
+	case bool:
+		v.BoolValue = &value
+	default:
+		s := fmt.Sprint(value)
+		v.StringValue = &s
+	}
+
+	return otlpAttr{Key: attr.Key, Value: v}
+}
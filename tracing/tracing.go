@@ -0,0 +1,245 @@
+// Package tracing records spans of the work done for requests and exports
+// them to an OpenTelemetry collector with OTLP over HTTP.
+//
+// Trace context is read from and propagated with W3C traceparent headers, so
+// spans recorded here join the traces of the services calling Ollama. Spans
+// are only recorded once an exporter has been started with [StartExporter];
+// until then starting a span returns nil, and every method of a nil *Span
+// does nothing.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceID identifies a trace
+type TraceID [16]byte
+
+// SpanID identifies a span within a trace
+type SpanID [8]byte
+
+// SpanContext is the part of a span propagated to other services
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	// Sampled is set if the span is recorded
+	Sampled bool
+}
+
+// IsValid reports whether sc has a trace and span ID
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// Traceparent returns the traceparent header of sc
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+
+	return fmt.Sprintf("00-%s-%s-%s", hex.EncodeToString(sc.TraceID[:]), hex.EncodeToString(sc.SpanID[:]), flags)
+}
+
+var errInvalidTraceparent = errors.New("invalid traceparent")
+
+// ParseTraceparent parses a W3C traceparent header. Versions after 00 are
+// parsed as version 00, as the specification asks.
+func ParseTraceparent(s string) (SpanContext, error) {
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return SpanContext{}, errInvalidTraceparent
+	}
+
+	var sc SpanContext
+	var flags [1]byte
+	for _, field := range []struct {
+		s   string
+		dst []byte
+	}{
+		{parts[1], sc.TraceID[:]},
+		{parts[2], sc.SpanID[:]},
+		{parts[3], flags[:]},
+	} {
+		// upper case hex isn't allowed
+		if len(field.s) != 2*len(field.dst) || strings.ToLower(field.s) != field.s {
+			return SpanContext{}, errInvalidTraceparent
+		}
+
+		if _, err := hex.Decode(field.dst, []byte(field.s)); err != nil {
+			return SpanContext{}, errInvalidTraceparent
+		}
+	}
+
+	if !sc.IsValid() {
+		return SpanContext{}, errInvalidTraceparent
+	}
+
+	sc.Sampled = flags[0]&1 == 1
+	return sc, nil
+}
+
+// Kind is the role of a span in a trace, as numbered by OTLP
+type Kind int
+
+const (
+	KindInternal Kind = 1
+	KindServer   Kind = 2
+	KindClient   Kind = 3
+)
+
+// Attr is an attribute of a span. Values are strings, ints, int64s,
+// float64s or bools.
+type Attr struct {
+	Key   string
+	Value any
+}
+
+func String(key, value string) Attr        { return Attr{key, value} }
+func Int(key string, value int) Attr       { return Attr{key, int64(value)} }
+func Int64(key string, value int64) Attr   { return Attr{key, value} }
+func Float(key string, value float64) Attr { return Attr{key, value} }
+func Bool(key string, value bool) Attr     { return Attr{key, value} }
+
+// Span is an operation of a trace
+type Span struct {
+	exporter *exporter
+
+	name   string
+	kind   Kind
+	sc     SpanContext
+	parent SpanID
+	start  time.Time
+
+	mu    sync.Mutex
+	attrs []Attr
+	err   string
+	ended bool
+}
+
+// SpanContext returns the context of s propagated to other services
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+
+	return s.sc
+}
+
+// SetAttributes adds attrs to s
+func (s *Span) SetAttributes(attrs ...Attr) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attrs = append(s.attrs, attrs...)
+}
+
+// SetError marks s as failed with err, if it's not nil
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err.Error()
+}
+
+// End ends s now
+func (s *Span) End() {
+	s.EndAt(time.Now())
+}
+
+// EndAt ends s at t, and exports it if it's sampled. Spans can only be
+// ended once.
+func (s *Span) EndAt(t time.Time) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	ended := s.ended
+	s.ended = true
+	s.mu.Unlock()
+
+	if !ended && s.sc.Sampled {
+		s.exporter.export(s, t)
+	}
+}
+
+type spanKey struct{}
+
+type remoteKey struct{}
+
+// FromContext returns the span of ctx, or nil
+func FromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// WithRemote returns a context whose spans are children of the span of
+// another service identified by sc
+func WithRemote(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// Extract returns a context whose spans continue the trace of the
+// traceparent header of h, if it has a valid one
+func Extract(ctx context.Context, h http.Header) context.Context {
+	if sc, err := ParseTraceparent(h.Get("traceparent")); err == nil {
+		return WithRemote(ctx, sc)
+	}
+
+	return ctx
+}
+
+// Inject sets the traceparent header of h to the span of ctx, so the
+// service it's sent to continues the trace. The trace ctx continues is
+// passed on if spans aren't recorded.
+func Inject(ctx context.Context, h http.Header) {
+	if s := FromContext(ctx); s != nil {
+		h.Set("traceparent", s.sc.Traceparent())
+	} else if remote, ok := ctx.Value(remoteKey{}).(SpanContext); ok {
+		h.Set("traceparent", remote.Traceparent())
+	}
+}
+
+// StartSpan starts a span named name that's a child of the span of ctx, or
+// of the remote span ctx continues, and returns a context with the new
+// span. It returns ctx and a nil span if traces aren't exported.
+func StartSpan(ctx context.Context, name string, kind Kind, attrs ...Attr) (context.Context, *Span) {
+	return StartSpanAt(ctx, name, kind, time.Now(), attrs...)
+}
+
+// StartSpanAt starts a span as in [StartSpan] that started at t, such as
+// for an operation timed by another process
+func StartSpanAt(ctx context.Context, name string, kind Kind, t time.Time, attrs ...Attr) (context.Context, *Span) {
+	e := current()
+	if e == nil {
+		return ctx, nil
+	}
+
+	s := &Span{exporter: e, name: name, kind: kind, start: t, attrs: attrs}
+	if parent := FromContext(ctx); parent != nil {
+		s.sc.TraceID, s.parent, s.sc.Sampled = parent.sc.TraceID, parent.sc.SpanID, parent.sc.Sampled
+	} else if remote, ok := ctx.Value(remoteKey{}).(SpanContext); ok {
+		s.sc.TraceID, s.parent, s.sc.Sampled = remote.TraceID, remote.SpanID, remote.Sampled
+	} else {
+		rand.Read(s.sc.TraceID[:])
+		s.sc.Sampled = true
+	}
+
+	rand.Read(s.sc.SpanID[:])
+	return context.WithValue(ctx, spanKey{}, s), s
+}
@@ -0,0 +1,168 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	cases := []struct {
+		in      string
+		sampled bool
+		err     bool
+	}{
+		{in: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", sampled: true},
+		{in: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00"},
+		{in: "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-future", sampled: true},
+		{in: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-future", err: true},
+		{in: "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", err: true},
+		{in: "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", err: true},
+		{in: "00-00000000000000000000000000000000-00f067aa0ba902b7-01", err: true},
+		{in: "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", err: true},
+		{in: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7", err: true},
+		{in: "", err: true},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.in, func(t *testing.T) {
+			sc, err := ParseTraceparent(tt.in)
+			if tt.err != (err != nil) {
+				t.Fatalf("unexpected error %v", err)
+			}
+
+			if tt.err {
+				return
+			}
+
+			if sc.Sampled != tt.sampled {
+				t.Errorf("expected sampled %t, got %t", tt.sampled, sc.Sampled)
+			}
+
+			if got, want := sc.Traceparent()[3:55], tt.in[3:55]; got != want {
+				t.Errorf("expected IDs %s, got %s", want, got)
+			}
+		})
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	ctx, span := StartSpan(context.Background(), "test", KindInternal)
+	if span != nil {
+		t.Fatal("expected no span without an exporter")
+	}
+
+	// nil spans can be used
+	span.SetAttributes(String("key", "value"))
+	span.SetError(errors.New("failed"))
+	span.End()
+
+	parent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	ctx = Extract(ctx, http.Header{"Traceparent": {parent}})
+
+	h := make(http.Header)
+	Inject(ctx, h)
+	if got := h.Get("traceparent"); got != parent {
+		t.Errorf("expected the trace to be passed on, got %q", got)
+	}
+}
+
+func TestExport(t *testing.T) {
+	var mu sync.Mutex
+	var requests []otlpTraces
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret token" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected headers %v", r.Header)
+		}
+
+		var traces otlpTraces
+		if err := json.NewDecoder(r.Body).Decode(&traces); err != nil {
+			t.Error(err)
+		}
+
+		mu.Lock()
+		requests = append(requests, traces)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	shutdown, err := StartExporter(srv.URL+"/v1/traces", "Authorization=Bearer%20secret%20token", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := Extract(context.Background(), http.Header{"Traceparent": {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}})
+	ctx, parent := StartSpan(ctx, "parent", KindServer, Int("count", 3))
+	_, child := StartSpanAt(ctx, "child", KindInternal, time.Unix(1, 0), Bool("ok", false), Float("ratio", 0.5))
+	child.SetError(errors.New("failed"))
+	child.EndAt(time.Unix(2, 0))
+	child.End()
+	parent.End()
+
+	h := make(http.Header)
+	Inject(ctx, h)
+	if got, want := h.Get("traceparent"), parent.SpanContext().Traceparent(); got != want {
+		t.Errorf("expected traceparent %s, got %s", want, got)
+	}
+
+	// spans aren't recorded for requests whose caller didn't sample them
+	unsampled := Extract(context.Background(), http.Header{"Traceparent": {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00"}})
+	_, span := StartSpan(unsampled, "unsampled", KindServer)
+	span.End()
+
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, span := StartSpan(context.Background(), "stopped", KindInternal); span != nil {
+		t.Error("expected no spans after the exporter stopped")
+	}
+
+	if len(requests) != 1 {
+		t.Fatalf("expected 1 export, got %d", len(requests))
+	}
+
+	rs := requests[0].ResourceSpans[0]
+	if name := *rs.Resource.Attributes[0].Value.StringValue; name != "test" {
+		t.Errorf("expected service name test, got %q", name)
+	}
+
+	spans := rs.ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %+v", spans)
+	}
+
+	c, p := spans[0], spans[1]
+	if c.Name != "child" || p.Name != "parent" {
+		t.Fatalf("unexpected spans %+v", spans)
+	}
+
+	if c.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || p.TraceID != c.TraceID {
+		t.Errorf("expected spans to continue the trace, got %s and %s", p.TraceID, c.TraceID)
+	}
+
+	if p.ParentSpanID != "00f067aa0ba902b7" || c.ParentSpanID != p.SpanID {
+		t.Errorf("unexpected parents %s and %s", p.ParentSpanID, c.ParentSpanID)
+	}
+
+	if c.StartTimeUnixNano != "1000000000" || c.EndTimeUnixNano != "2000000000" {
+		t.Errorf("unexpected times %s to %s", c.StartTimeUnixNano, c.EndTimeUnixNano)
+	}
+
+	if c.Status == nil || c.Status.Code != 2 || c.Status.Message != "failed" || p.Status != nil {
+		t.Errorf("unexpected statuses %+v and %+v", c.Status, p.Status)
+	}
+
+	if v := p.Attributes[0].Value; p.Attributes[0].Key != "count" || v.IntValue == nil || *v.IntValue != "3" {
+		t.Errorf("unexpected attributes %+v", p.Attributes)
+	}
+
+	if len(c.Attributes) != 2 || *c.Attributes[0].Value.BoolValue || *c.Attributes[1].Value.DoubleValue != 0.5 {
+		t.Errorf("unexpected attributes %+v", c.Attributes)
+	}
+}
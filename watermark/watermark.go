@@ -0,0 +1,87 @@
+// Package watermark implements a statistical watermark of generated text.
+//
+// At each position the vocabulary is split into a green list and a red list
+// by a hash of a secret key and the previous token, and the logits of green
+// tokens are raised before sampling. Text generated this way has many more
+// green tokens than the Gamma fraction expected of other text, which can be
+// detected with the key and a tokenizer alone, without the model.
+package watermark
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"math"
+)
+
+const (
+	// Gamma is the fraction of the vocabulary on the green list of each
+	// position
+	Gamma = 0.25
+
+	// Delta is added to the logits of green tokens
+	Delta = 2.0
+
+	// Threshold is the z-score above which text is considered watermarked
+	Threshold = 4.0
+)
+
+// Key returns the key of the watermark with secret
+func Key(secret string) uint64 {
+	sum := sha256.Sum256([]byte(secret))
+	return binary.LittleEndian.Uint64(sum[:8])
+}
+
+// Green reports whether token is on the green list of the position after
+// prev
+func Green(key uint64, prev, token int) bool {
+	h := mix(key ^ mix(uint64(prev)) ^ mix(uint64(token)<<32|0x9e3779b9))
+	return float64(h>>11)/(1<<53) < Gamma
+}
+
+// mix is the finalizer of splitmix64
+func mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// Result is the score of tokens against a watermark
+type Result struct {
+	// Scored is the number of tokens scored, which excludes the first as
+	// the token before it isn't known
+	Scored int
+
+	// Green is the number of scored tokens on their position's green list
+	Green int
+
+	// ZScore is the number of standard deviations Green is above what's
+	// expected of text without the watermark
+	ZScore float64
+}
+
+// Watermarked reports whether the tokens are likely to have been generated
+// with the watermark
+func (r Result) Watermarked() bool {
+	return r.ZScore >= Threshold
+}
+
+// Detect scores tokens against the watermark with key
+func Detect(key uint64, tokens []int) Result {
+	var r Result
+	for i := 1; i < len(tokens); i++ {
+		r.Scored++
+		if Green(key, tokens[i-1], tokens[i]) {
+			r.Green++
+		}
+	}
+
+	if r.Scored > 0 {
+		n := float64(r.Scored)
+		r.ZScore = (float64(r.Green) - Gamma*n) / math.Sqrt(n*Gamma*(1-Gamma))
+	}
+
+	return r
+}
@@ -0,0 +1,62 @@
+package watermark
+
+import (
+	"math/rand/v2"
+	"testing"
+)
+
+func TestGreen(t *testing.T) {
+	key := Key("secret")
+
+	var green int
+	for token := range 100000 {
+		if Green(key, 42, token) {
+			green++
+		}
+	}
+
+	if frac := float64(green) / 100000; frac < Gamma-0.01 || frac > Gamma+0.01 {
+		t.Errorf("expected about %v of tokens to be green, got %v", Gamma, frac)
+	}
+
+	if Green(key, 42, 7) != Green(key, 42, 7) {
+		t.Error("expected the green list to be deterministic")
+	}
+}
+
+func TestDetect(t *testing.T) {
+	key := Key("secret")
+	r := rand.New(rand.NewPCG(1, 2))
+
+	// tokens sampled uniformly aren't watermarked
+	tokens := make([]int, 200)
+	for i := range tokens {
+		tokens[i] = r.IntN(32000)
+	}
+
+	if result := Detect(key, tokens); result.Watermarked() {
+		t.Errorf("expected random tokens not to be watermarked, got %+v", result)
+	}
+
+	// tokens sampled mostly from the green list are
+	tokens = tokens[:1]
+	for len(tokens) < 200 {
+		token := r.IntN(32000)
+		if Green(key, tokens[len(tokens)-1], token) || r.Float64() < 0.3 {
+			tokens = append(tokens, token)
+		}
+	}
+
+	result := Detect(key, tokens)
+	if !result.Watermarked() || result.Scored != 199 {
+		t.Errorf("expected watermarked tokens to be detected, got %+v", result)
+	}
+
+	if result := Detect(Key("other"), tokens); result.Watermarked() {
+		t.Errorf("expected tokens not to be watermarked with another key, got %+v", result)
+	}
+
+	if result := Detect(key, tokens[:1]); result != (Result{}) {
+		t.Errorf("expected an empty result, got %+v", result)
+	}
+}